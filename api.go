@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/goxray/tun/pkg/api"
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/config"
+)
+
+// daemonAPI is the daemon managed by the control API, profiles are read from and activated in the config
+// file at path, the daemon applies them like changes of profile use.
+type daemonAPI struct {
+	d    *daemon
+	path string
+}
+
+func (a *daemonAPI) Status() any {
+	return a.d.status()
+}
+
+func (a *daemonAPI) Profiles() ([]api.Profile, error) {
+	cfg, err := config.Load(a.path)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]api.Profile, 0, len(cfg.Profiles))
+	for _, p := range cfg.Profiles {
+		profiles = append(profiles, api.Profile{Name: p.Name, Active: p.Name == cfg.Active})
+	}
+
+	return profiles, nil
+}
+
+func (a *daemonAPI) Activate(name string) error {
+	cfg, err := config.Load(a.path)
+	if err != nil {
+		return err
+	}
+	if err = cfg.SetActive(name); err != nil {
+		return err
+	}
+
+	return cfg.Save(a.path)
+}
+
+func (a *daemonAPI) Reconnect() error {
+	d := a.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.link == "" {
+		return client.ErrNotConnected
+	}
+
+	link, profile := d.link, d.profile
+	d.switchTo("", "")
+	d.switchTo(link, profile)
+	if d.link == "" {
+		return errors.New("reconnect failed")
+	}
+
+	return nil
+}
+
+// serveAPI serves the control API of the daemon with the config file at path until ctx is done.
+func (d *daemon) serveAPI(ctx context.Context, opts *api.Options, path string) {
+	if err := api.Serve(ctx, opts, &daemonAPI{d: d, path: path}, d.logger); err != nil {
+		d.logger.Warn("api not served", "addr", opts.Addr, "err", err)
+	}
+}
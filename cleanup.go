@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+// cleanupCmd undoes system changes left by a crashed run, as recorded in the journal next to the config file.
+func cleanupCmd(args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print cleanup report as JSON")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		return usageError("usage: cleanup [-json]")
+	}
+
+	cfg, err := clientConfig(slog.LevelError)
+	if err != nil {
+		return err
+	}
+	vpn, err := client.NewClientWithOpts(cfg)
+	if err != nil {
+		return err
+	}
+	report, err := vpn.Cleanup()
+	if report == nil {
+		if err == nil {
+			fmt.Println("nothing to clean up")
+		}
+
+		return err
+	}
+
+	if *asJSON {
+		b, jsonErr := json.MarshalIndent(report, "", "  ")
+		if jsonErr != nil {
+			return jsonErr
+		}
+		fmt.Println(string(b))
+
+		return err
+	}
+
+	fmt.Printf("crashed run pid: %d\n", report.PID)
+	if report.TUN != "" {
+		fmt.Printf("deleted device:  %s\n", report.TUN)
+	}
+	for _, r := range report.Routes {
+		fmt.Printf("deleted route:   %s\n", r)
+	}
+	for _, r := range report.Gone {
+		fmt.Printf("gone route:      %s\n", r)
+	}
+	if report.SystemProxy {
+		fmt.Println("system proxy:    restored")
+	}
+
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/goxray/tun/pkg/config"
+)
+
+// configCmd exports and imports the whole configuration file: profiles, groups, schedule, rotation and settings,
+// to migrate to another machine or share the setup.
+func configCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New("config: command is required (export, import)")
+	}
+
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return withExit(exitConfig, err)
+	}
+
+	switch args[0] {
+	case "export":
+		fs := flag.NewFlagSet("config export", flag.ExitOnError)
+		sanitize := fs.Bool("sanitize", false, "remove server credentials of profiles and secrets of settings, to share the setup")
+		passwordFile := fs.String("password-file", "", "encrypt the export with the password in the file")
+		_ = fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return usageError("usage: config export [-sanitize] [-password-file <file>] <out.json>")
+		}
+		password, err := readPassword(*passwordFile)
+		if err != nil {
+			return err
+		}
+		if *sanitize {
+			cfg = cfg.Sanitized()
+		}
+		b, err := cfg.Export(password)
+		if err != nil {
+			return err
+		}
+
+		// Private like the config file, unencrypted exports contain credentials.
+		return os.WriteFile(fs.Arg(0), b, 0o600)
+	case "import":
+		fs := flag.NewFlagSet("config import", flag.ExitOnError)
+		merge := fs.Bool("merge", false, "add imported profiles, groups and settings to the current config instead of replacing it")
+		passwordFile := fs.String("password-file", "", "decrypt the export with the password in the file")
+		_ = fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return usageError("usage: config import [-merge] [-password-file <file>] <in.json>")
+		}
+		password, err := readPassword(*passwordFile)
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		in, err := config.ParseExport(b, password)
+		if err != nil {
+			return withExit(exitConfig, fmt.Errorf("import %s: %w", fs.Arg(0), err))
+		}
+		for _, name := range cfg.Import(in, *merge) {
+			fmt.Fprintf(os.Stderr, "profile %q skipped: it has no link and no saved profile has its name\n", name)
+		}
+
+		return cfg.Save(path)
+	default:
+		return fmt.Errorf("config: unknown command %q", args[0])
+	}
+}
+
+// readPassword returns the first line of the password file, empty if path is empty.
+func readPassword(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", withExit(exitConfig, err)
+	}
+	password, _, _ := strings.Cut(string(b), "\n")
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", withExit(exitConfig, fmt.Errorf("password file %s is empty", path))
+	}
+
+	return password, nil
+}
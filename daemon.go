@@ -0,0 +1,907 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/config"
+	"github.com/goxray/tun/pkg/schedule"
+	"github.com/goxray/tun/pkg/stats"
+)
+
+// Events of daemon connections and scheduled transitions, printed like client events.
+const (
+	eventScheduledConnect    client.EventType = "scheduled_connect"
+	eventScheduledDisconnect client.EventType = "scheduled_disconnect"
+	eventRotated             client.EventType = "rotated"
+	eventGroupSwitched       client.EventType = "group_switched"
+	eventConnected           client.EventType = "connected"
+	eventConnectFailed       client.EventType = "connect_failed"
+	eventWatchdogRestart     client.EventType = "watchdog_restart"
+)
+
+const (
+	// rotationCheck is the interval of rotation policy checks.
+	rotationCheck = 10 * time.Second
+	// stateSave is the interval of session state saves.
+	stateSave = time.Minute
+	// groupProbeTimeout limits a probe of a group profile.
+	groupProbeTimeout = 10 * time.Second
+	// exitCountryTTL is how long exit country of a group profile is used before it is looked up again.
+	exitCountryTTL = 6 * time.Hour
+)
+
+// daemon keeps the client connected to the active profile of the configuration file,
+// the file is watched and changes of the active profile are applied live. The client is recreated
+// with the profile settings when switching to a profile overriding the client settings.
+// With schedule the connection is kept only within the schedule windows.
+//
+// Session state is saved to statePath, so the connection and traffic totals are restored after restart.
+// Usage statistics are counted to stats, nil if they are disabled.
+type daemon struct {
+	vpn       *client.Client
+	logger    *slog.Logger
+	override  string // Link from the environment, used instead of the active profile.
+	statePath string
+	stats     *stats.Store
+	// obfuscated is set if traffic obfuscation is configured, its connections are marked in stats.
+	obfuscated bool
+	// vpnSettings are the profile settings vpn was created with, see config.Profile.
+	vpnSettings *config.Settings
+	// vpnFileSettings are the settings of the configuration file vpn was created with.
+	vpnFileSettings config.Settings
+	// fileSettings are the settings of the applied configuration file.
+	fileSettings config.Settings
+	// profileSettings are settings of the file profiles overriding the client settings.
+	profileSettings map[string]*config.Settings
+
+	mu      sync.Mutex
+	link    string // Link of the current connection, empty if not connected.
+	profile string // Profile name of the current connection.
+	// balanced are links the current connection spreads flows over besides link, see client.ConnectBalanced.
+	balanced    []string
+	want        string // Link of the active profile, connected to within the schedule.
+	wantProfile string
+	// wantBalanced are links of load-balance group profiles the wanted connection is balanced over.
+	wantBalanced []string
+	state        *config.State
+	// restore is the profile of the previous daemon run, it is preferred by the first rotation.
+	restore  string
+	schedule *schedule.Schedule
+	// rescheduled wakes up runSchedule when the schedule changes.
+	rescheduled chan struct{}
+	rotation    *config.RotationPolicy
+	rotationIdx int // Index of the wanted rotation profile.
+	group       *config.GroupPolicy
+	groupIdx    int // Index of the wanted group profile.
+	// regroup wakes up runGroupProbes when the group changes.
+	regroup chan struct{}
+	// backoff delays connecting again after failures, like the retries of on-demand connections.
+	backoff client.ReconnectBackoff
+	retryAt time.Time // Time of the next connection attempt after a failure, zero if there is none.
+	// retry wakes up runRetries when retryAt changes.
+	retry chan struct{}
+	// exitCountries are exit countries of group profile links looked up by probes.
+	exitCountries map[string]exitCountry
+	connectedAt   time.Time // Time of the current connection.
+	// counted is the traffic of the current connection and the time it was last counted to stats.
+	counted struct {
+		read, written int64
+		at            time.Time
+	}
+
+	// handover is the connection handed over by the previous binary, adopted by the first connection.
+	handover *upgradeHandover
+	// upgrading is set while the upgraded binary is executed without d.mu held, the connection and the session
+	// state are left to it.
+	upgrading bool
+
+	// lastStatus is the last known liveStatus, see status.
+	lastStatus struct {
+		mu     sync.Mutex
+		status *liveStatus
+	}
+	// lastHealth is the last known health of the connection, see health.
+	lastHealth struct {
+		mu          sync.Mutex
+		live, ready error
+	}
+}
+
+func daemonCmd(args []string) error {
+	if len(args) != 0 {
+		return usageError("usage: daemon")
+	}
+
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return withExit(exitConfig, err)
+	}
+
+	clientCfg, err := clientConfig(slog.LevelInfo)
+	if err != nil {
+		return err
+	}
+	settings, err := loadSettings("")
+	if err != nil {
+		return err
+	}
+	updateEvery, err := settings.UpdateCheckInterval()
+	if err != nil {
+		return err
+	}
+	metricsPush, err := settings.MetricsPushOptions()
+	if err != nil {
+		return err
+	}
+	watchdogPeriod, err := settings.WatchdogPeriod()
+	if err != nil {
+		return err
+	}
+	apiOpts, err := settings.APIOptions()
+	if err != nil {
+		return err
+	}
+	logger := clientCfg.Logger
+	if settings.Notify {
+		startNotifications()
+	}
+	vpn, err := client.NewClientWithOpts(clientCfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d := &daemon{
+		vpn:         vpn,
+		logger:      logger,
+		override:    os.Getenv(config.EnvLink),
+		statePath:   config.StatePath(path),
+		obfuscated:  clientCfg.Obfuscation != nil,
+		rescheduled: make(chan struct{}, 1),
+		regroup:     make(chan struct{}, 1),
+		retry:       make(chan struct{}, 1),
+
+		vpnFileSettings: cfg.Settings,
+	}
+	if d.state, err = config.LoadState(d.statePath); err != nil {
+		logger.Warn("session state is invalid, starting new session", "err", err)
+		d.state = &config.State{Since: time.Now()}
+	}
+	d.restore = d.state.Profile
+	d.backoff.SetOptions(clientCfg.Reconnect)
+	if d.stats, err = stats.Open(config.StatsPath(path)); err != nil {
+		logger.Warn("statistics are invalid, not counting statistics", "err", err)
+	}
+	logger.Info("session loaded", "profile", d.state.Profile, "since", d.state.Since,
+		"bytes_read", d.state.BytesRead, "bytes_written", d.state.BytesWritten)
+	if d.handover, err = takeHandover(); err != nil {
+		logger.Warn("handover of the previous binary is invalid, connecting again", "err", err)
+	}
+
+	d.apply(cfg)
+	d.dropHandover()
+	go d.runSchedule(ctx)
+	go d.runRotation(ctx)
+	go d.runGroupProbes(ctx)
+	go d.runRetries(ctx)
+	go d.runStateSaves(ctx)
+	go d.runUpgrades(ctx)
+	go d.serveStatus(ctx, config.SocketPath(path))
+	if updateEvery > 0 {
+		go d.runUpdateCheck(ctx, updateEvery)
+	}
+	if metricsPush != nil {
+		go d.runMetricsPush(ctx, metricsPush)
+	}
+	if watchdogPeriod > 0 {
+		go d.runWatchdog(ctx, watchdogPeriod)
+	}
+	if settings.HealthListen != "" {
+		go serveHealth(ctx, settings.HealthListen, logger, d.health)
+	}
+	if apiOpts != nil {
+		go d.serveAPI(ctx, apiOpts, path)
+	}
+	logger.Info("watching config for changes", "path", path)
+	err = config.Watch(ctx, path, d.apply, func(err error) {
+		logger.Error("config reload failed, keeping current config", "err", err)
+	})
+	d.shutdown()
+
+	return err
+}
+
+// shutdown disconnects and saves the session, the connection profile is kept to be restored by the next run.
+func (d *daemon) shutdown() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	profile := d.profile
+	d.switchTo("", "")
+	d.saveState(profile) // Overrides the disconnected state saved by switchTo.
+	d.logger.Info("session saved", "profile", profile, "bytes_read", d.state.BytesRead, "bytes_written", d.state.BytesWritten)
+}
+
+// runStateSaves saves session state periodically until ctx is done, so that totals survive crashes.
+func (d *daemon) runStateSaves(ctx context.Context) {
+	ticker := time.NewTicker(stateSave)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		d.countStats(time.Now())
+		d.saveState(d.profile)
+		d.mu.Unlock()
+	}
+}
+
+// saveState writes session state with profile, traffic totals including the current connection,
+// how the last connection ended and the recent connection attempts.
+func (d *daemon) saveState(profile string) {
+	if d.upgrading {
+		return
+	}
+	if last := d.vpn.LastDisconnect(); last != nil {
+		d.state.LastDisconnect = last
+	}
+	d.state.AddHistory(d.vpn.History())
+	st := *d.state
+	st.Profile = profile
+	if d.link != "" {
+		st.BytesRead += int64(d.vpn.BytesRead())
+		st.BytesWritten += int64(d.vpn.BytesWritten())
+	}
+	if err := st.Save(d.statePath); err != nil {
+		d.logger.Warn("session state save failed", "err", err)
+	}
+	if d.stats != nil {
+		if err := d.stats.Save(); err != nil {
+			d.logger.Warn("statistics save failed", "err", err)
+		}
+	}
+}
+
+// countStats adds traffic and uptime of the current connection since it was last counted to stats.
+func (d *daemon) countStats(now time.Time) {
+	if d.link == "" || d.stats == nil {
+		return
+	}
+
+	read, written := int64(d.vpn.BytesRead()), int64(d.vpn.BytesWritten())
+	d.stats.Add(now, serverName(d.link, d.profile), read-d.counted.read, written-d.counted.written, now.Sub(d.counted.at))
+	d.counted.read, d.counted.written, d.counted.at = read, written, now
+}
+
+// serverName returns statistics server name of the connection: profile name or the link host.
+func serverName(link, profile string) string {
+	if profile != "" {
+		return profile
+	}
+	if u, err := url.Parse(link); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+
+	return "link"
+}
+
+// apply makes the configuration effective, the connection is switched if the active profile,
+// the rotation group, the active proxy group or the schedule changed. It is established again with a new
+// client if the settings of the file or of the connection profile changed.
+func (d *daemon) apply(cfg *config.File) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.applySettings()
+
+	if err := d.applySchedule(cfg.Schedule); err != nil {
+		d.logger.Error("schedule is invalid, keeping current schedule", "err", err)
+	}
+	d.fileSettings = cfg.Settings
+	d.profileSettings = make(map[string]*config.Settings)
+	for _, p := range cfg.Profiles {
+		if p.Settings != nil {
+			d.profileSettings[p.Name] = p.Settings
+		}
+	}
+
+	prevBalanced := d.wantBalanced
+	d.wantBalanced = nil
+	link := d.override
+	if link != "" {
+		if link != d.want {
+			d.logger.Info("connecting to the link from environment", "env", config.EnvLink)
+			d.want, d.wantProfile = link, ""
+			d.sync()
+		}
+		return
+	}
+
+	rotation, err := cfg.RotationPolicy()
+	if err != nil {
+		d.logger.Error("rotation is invalid, keeping current connection", "err", err)
+		return
+	}
+	d.rotation = rotation
+	d.group = nil
+	if rotation != nil {
+		d.applyRotation()
+		return
+	}
+
+	group, err := cfg.GroupPolicy()
+	if err != nil {
+		d.logger.Error("active group is invalid, keeping current connection", "group", cfg.Active, "err", err)
+		return
+	}
+	if d.group = group; group != nil {
+		d.applyGroup(prevBalanced)
+		return
+	}
+
+	if p, err := cfg.ActiveProfile(); err == nil {
+		if link, err = p.ConnectLink(); err != nil {
+			d.logger.Error("active profile is invalid, keeping current connection", "profile", p.Name, "err", err)
+			return
+		}
+	} else if cfg.Active != "" {
+		d.logger.Error("active profile not found, keeping current connection", "err", err)
+		return
+	}
+
+	if link == d.want {
+		d.logger.Info("config applied, active profile unchanged", "profile", cfg.Active)
+		d.sync()
+		return
+	}
+
+	d.logger.Info("active profile changed, switching connection", "profile", cfg.Active)
+	d.want, d.wantProfile = link, cfg.Active
+	d.sync()
+}
+
+// applySettings establishes the current connection again if the settings of the file or of the connection
+// profile changed, the client is recreated with them.
+func (d *daemon) applySettings() {
+	if d.link == "" || d.clientCurrent(d.profile) {
+		return
+	}
+
+	d.logger.Info("settings changed, reconnecting", "profile", d.profile)
+	d.switchTo(d.link, d.profile)
+}
+
+// applyRotation connects to a profile of the rotation group, the current one is kept if it is in the group.
+func (d *daemon) applyRotation() {
+	idx := slices.Index(d.rotation.Links, d.want)
+	if idx < 0 && d.restore != "" {
+		idx = slices.Index(d.rotation.Names, d.restore)
+	}
+	d.restore = ""
+	if idx < 0 {
+		idx = d.rotation.Next(-1, rand.IntN)
+		d.logger.Info("rotation applied, switching connection", "profile", d.rotation.Names[idx])
+	} else {
+		d.logger.Info("config applied, rotation profile unchanged", "profile", d.rotation.Names[idx])
+	}
+	d.rotationIdx = idx
+	d.want, d.wantProfile = d.rotation.Links[idx], d.rotation.Names[idx]
+	d.sync()
+}
+
+// runRotation switches connection to the next profile of the rotation group when it is due, until ctx is done.
+func (d *daemon) runRotation(ctx context.Context) {
+	ticker := time.NewTicker(rotationCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		if d.rotationDue() {
+			d.rotate()
+		}
+		d.mu.Unlock()
+	}
+}
+
+// rotationDue reports whether the current connection reached rotation interval or traffic.
+func (d *daemon) rotationDue() bool {
+	r := d.rotation
+	if r == nil || d.link == "" || d.link != d.want {
+		return false
+	}
+
+	return r.Interval > 0 && time.Since(d.connectedAt) >= r.Interval ||
+		r.Bytes > 0 && int64(d.vpn.BytesRead()+d.vpn.BytesWritten()) >= r.Bytes
+}
+
+// rotate reconnects to the next profile of the rotation group, the current connection is kept if it fails.
+func (d *daemon) rotate() {
+	idx := d.rotation.Next(d.rotationIdx, rand.IntN)
+	link := d.rotation.Links[idx]
+	d.logger.Info("rotating connection", "from", d.rotation.Names[d.rotationIdx], "to", d.rotation.Names[idx])
+	d.switchTo(link, d.rotation.Names[idx])
+	if d.link != link {
+		d.logger.Error("rotation failed, keeping current connection")
+		d.connectedAt = time.Now() // Retry after the next interval.
+		return
+	}
+
+	d.rotationIdx, d.want, d.wantProfile = idx, link, d.rotation.Names[idx]
+	logEvent(client.Event{
+		Type: eventRotated, Time: time.Now(), Message: "connection rotated",
+		Attrs: map[string]string{"profile": d.rotation.Names[idx]},
+	})
+}
+
+// applyGroup connects to a profile of the active proxy group: the selected one of select group, otherwise
+// the current one if it is in the group until the probes pick another one. Connection of load-balance group
+// is balanced over the other group profiles, prevBalanced are kept if the profile is unchanged.
+func (d *daemon) applyGroup(prevBalanced []string) {
+	g := d.group
+	idx := g.Selected
+	if g.Type != config.GroupSelect {
+		idx = slices.Index(g.Links, d.want)
+		if idx < 0 && d.restore != "" {
+			idx = slices.Index(g.Names, d.restore)
+		}
+		idx = max(idx, 0)
+		select {
+		case d.regroup <- struct{}{}:
+		default:
+		}
+	}
+	d.restore = ""
+	d.groupIdx = idx
+	if g.Type == config.GroupLoadBalance {
+		d.wantBalanced = slices.Delete(slices.Clone(g.Links), idx, idx+1)
+		if g.Links[idx] == d.want && !slices.ContainsFunc(prevBalanced, func(link string) bool { return !slices.Contains(g.Links, link) }) {
+			d.wantBalanced = prevBalanced
+		}
+	}
+	if g.Links[idx] == d.want {
+		d.logger.Info("config applied, group profile unchanged", "group", g.Name, "profile", g.Names[idx])
+	} else {
+		d.logger.Info("group applied, switching connection", "group", g.Name, "type", g.Type, "profile", g.Names[idx])
+	}
+	d.want, d.wantProfile = g.Links[idx], g.Names[idx]
+	d.sync()
+}
+
+// runGroupProbes probes profiles of the active url-test, fallback or load-balance group every group interval
+// and when the group changes, the connection is switched to the profile picked by the group, until ctx is done.
+func (d *daemon) runGroupProbes(ctx context.Context) {
+	for {
+		d.mu.Lock()
+		var interval time.Duration
+		if d.group != nil {
+			interval = d.group.Interval
+		}
+		d.mu.Unlock()
+
+		var fire <-chan time.Time
+		var timer *time.Timer
+		if interval > 0 {
+			timer = time.NewTimer(interval)
+			fire = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-d.regroup:
+		case <-fire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		d.probeGroup(ctx)
+	}
+}
+
+// exitCountry is exit country code of a link and the time it was looked up.
+type exitCountry struct {
+	code string
+	at   time.Time
+}
+
+// probeGroup probes profiles of the active group and switches connection to the profile the group picks,
+// the current connection is kept if no profile passes the probes and the group filters. Probes run their
+// own proxy instances dialing around the tunnel, d.mu is held only to pick the profile and switch to it.
+func (d *daemon) probeGroup(ctx context.Context) {
+	d.mu.Lock()
+	g, vpn := d.group, d.vpn
+	d.mu.Unlock()
+	if g == nil || g.Type == config.GroupSelect {
+		return
+	}
+
+	latencies := make([]time.Duration, len(g.Links))
+	countries := make([]string, len(g.Links))
+	for i, link := range g.Links {
+		probeCtx, cancel := context.WithTimeout(ctx, groupProbeTimeout)
+		latency, country, err := d.probe(probeCtx, vpn, link, len(g.Countries) > 0)
+		cancel()
+		if err != nil {
+			d.logger.Warn("group probe failed", "group", g.Name, "profile", g.Names[i], "err", err)
+			continue
+		}
+		latencies[i], countries[i] = latency, country
+		d.logger.Debug("group probe", "group", g.Name, "profile", g.Names[i], "latency", latency, "country", country)
+	}
+	latencies = g.Filter(latencies, countries)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.group != g || ctx.Err() != nil {
+		return // Group changed while probing, it is probed again.
+	}
+	idx := g.Pick(latencies, d.groupIdx, rand.IntN)
+	if idx < 0 {
+		d.logger.Error("no group profile passed probes and filters, keeping current connection", "group", g.Name)
+		return
+	}
+	var balanced []string
+	if g.Type == config.GroupLoadBalance {
+		for i, latency := range latencies {
+			if latency > 0 && i != idx {
+				balanced = append(balanced, g.Links[i])
+			}
+		}
+		// Recovered profiles join on the next switch, flows pinned to the balanced servers are not moved for them.
+		failed := slices.ContainsFunc(d.wantBalanced, func(link string) bool { return !slices.Contains(balanced, link) })
+		if idx == d.groupIdx && !failed {
+			return
+		}
+	} else if idx == d.groupIdx {
+		return
+	}
+
+	link := g.Links[idx]
+	d.logger.Info("switching group connection", "group", g.Name, "from", g.Names[d.groupIdx], "to", g.Names[idx],
+		"servers", len(balanced)+1)
+	prevWant, prevProfile, prevBalanced := d.want, d.wantProfile, d.wantBalanced
+	d.want, d.wantProfile, d.wantBalanced = link, g.Names[idx], balanced
+	d.sync()
+	if d.link != "" && (d.link != link || !slices.Equal(d.balanced, balanced)) {
+		d.logger.Error("group switch failed, keeping current connection", "group", g.Name)
+		d.want, d.wantProfile, d.wantBalanced = prevWant, prevProfile, prevBalanced
+		return
+	}
+
+	d.groupIdx = idx
+	attrs := map[string]string{"group": g.Name, "profile": g.Names[idx]}
+	if g.Type == config.GroupLoadBalance {
+		attrs["servers"] = strconv.Itoa(len(balanced) + 1)
+	}
+	logEvent(client.Event{Type: eventGroupSwitched, Time: time.Now(), Message: "group connection switched", Attrs: attrs})
+}
+
+// probe measures latency of link with vpn, with country its exit country is looked up unless it is known
+// already. It must be called without d.mu held.
+func (d *daemon) probe(ctx context.Context, vpn *client.Client, link string, country bool) (time.Duration, string, error) {
+	d.mu.Lock()
+	cached, ok := d.exitCountries[link]
+	d.mu.Unlock()
+	if !country || ok && time.Since(cached.at) < exitCountryTTL {
+		latency, err := vpn.Probe(ctx, link)
+		return latency, cached.code, err
+	}
+
+	latency, exit, err := vpn.ProbeExit(ctx, link)
+	if err != nil {
+		return 0, "", err
+	}
+	d.mu.Lock()
+	if d.exitCountries == nil {
+		d.exitCountries = make(map[string]exitCountry)
+	}
+	d.exitCountries[link] = exitCountry{code: exit.Country, at: time.Now()}
+	d.mu.Unlock()
+
+	return latency, exit.Country, nil
+}
+
+// applySchedule replaces the schedule, nil removes it.
+func (d *daemon) applySchedule(cfg *config.Schedule) error {
+	var sched *schedule.Schedule
+	if cfg != nil {
+		var err error
+		if sched, err = cfg.Parse(); err != nil {
+			return err
+		}
+	}
+	if sched == nil && d.schedule == nil || sched != nil && d.schedule != nil && *sched == *d.schedule {
+		return nil
+	}
+
+	d.schedule = sched
+	if sched != nil {
+		d.logger.Info("schedule applied", "connect", cfg.Connect, "disconnect", cfg.Disconnect)
+	} else {
+		d.logger.Info("schedule removed, staying connected")
+	}
+	select {
+	case d.rescheduled <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// sync connects to the wanted link if the schedule allows it, disconnects otherwise.
+func (d *daemon) sync() {
+	d.syncAt(time.Now())
+}
+
+func (d *daemon) syncAt(now time.Time) {
+	link := d.want
+	if d.schedule != nil && !d.schedule.Active(now) {
+		link = ""
+	}
+	if link != d.link || link != "" && !slices.Equal(d.wantBalanced, d.balanced) {
+		d.switchTo(link, d.wantProfile)
+	}
+}
+
+// runSchedule connects and disconnects at schedule transitions until ctx is done.
+func (d *daemon) runSchedule(ctx context.Context) {
+	for {
+		d.mu.Lock()
+		var next time.Time
+		if d.schedule != nil {
+			next = d.schedule.Next(time.Now())
+		}
+		d.mu.Unlock()
+
+		var fire <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			fire = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-d.rescheduled:
+		case <-fire:
+			d.mu.Lock()
+			if d.schedule != nil {
+				d.transition(next)
+			}
+			d.mu.Unlock()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// transition applies the schedule window at the transition time and emits the scheduled transition event.
+func (d *daemon) transition(at time.Time) {
+	active := d.schedule.Active(at)
+	ev := client.Event{Type: eventScheduledDisconnect, Time: time.Now(), Message: "scheduled disconnect"}
+	if active {
+		ev.Type, ev.Message = eventScheduledConnect, "scheduled connect"
+	}
+	logEvent(ev)
+	d.syncAt(at)
+	if active && d.link == "" && d.want != "" {
+		d.logger.Error("scheduled connect failed, retrying", "at", d.retryAt)
+	}
+}
+
+// runRetries connects again to the wanted link after failed connection attempts, delayed by the reconnect
+// backoff, until ctx is done.
+func (d *daemon) runRetries(ctx context.Context) {
+	for {
+		d.mu.Lock()
+		retryAt := d.retryAt
+		d.mu.Unlock()
+
+		var fire <-chan time.Time
+		var timer *time.Timer
+		if !retryAt.IsZero() {
+			timer = time.NewTimer(time.Until(retryAt))
+			fire = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-d.retry:
+		case <-fire:
+			d.mu.Lock()
+			if d.retryAt.Equal(retryAt) {
+				d.retryAt = time.Time{}
+				d.sync()
+			}
+			d.mu.Unlock()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// retryLater records failed connection attempt, the wanted link is connected again after the returned backoff
+// delay. It must be called with d.mu held.
+func (d *daemon) retryLater() time.Duration {
+	now := time.Now()
+	delay, failures, coolDown := d.backoff.Failed(now)
+	if coolDown {
+		d.logger.Warn("connecting keeps failing, cooling down", "failures", failures, "retry_in", delay)
+		logEvent(client.Event{
+			Type: client.EventReconnectCoolDown, Time: now, Message: "connecting keeps failing, cooling down",
+			Attrs: map[string]string{"failures": strconv.Itoa(failures), "retry_in": delay.String()},
+		})
+	}
+	d.retryAt = now.Add(delay)
+	select {
+	case d.retry <- struct{}{}:
+	default:
+	}
+
+	return delay
+}
+
+// switchTo disconnects current connection and connects to link (if not empty) of profile, balanced over
+// wantBalanced if link is the wanted one. The previous connection is restored if link fails to connect,
+// the wanted link is connected again by runRetries after the reconnect backoff delay.
+// It must be called with d.mu held.
+func (d *daemon) switchTo(link, profile string) {
+	if d.upgrading {
+		return // Connected by the upgraded binary, or again if the upgrade fails.
+	}
+	prev, prevProfile, prevBalanced := d.link, d.profile, d.balanced
+	if prev != "" {
+		if err := d.vpn.Disconnect(context.Background()); err != nil {
+			d.logger.Warn("disconnect failed", "err", err)
+		}
+		d.disconnected()
+	}
+	defer func() { d.saveState(d.profile) }()
+	if link == "" {
+		return
+	}
+
+	handover := d.handover
+	var balanced []string
+	if link == d.want {
+		balanced = d.wantBalanced
+	}
+	if err := d.connect(link, profile, balanced); err != nil {
+		delay := d.retryLater()
+		attrs := map[string]string{"profile": serverName(link, profile), "err": err.Error(), "retry_in": delay.String()}
+		if hint := client.Hint(err); hint != "" {
+			attrs["hint"] = hint
+		}
+		logEvent(client.Event{Type: eventConnectFailed, Time: time.Now(), Message: "connect failed", Attrs: attrs})
+		if prev == "" {
+			return
+		}
+		if err = d.connect(prev, prevProfile, prevBalanced); err != nil {
+			d.logger.Error("restoring previous connection failed", "err", err)
+			return
+		}
+		link, profile, balanced = prev, prevProfile, prevBalanced
+	} else {
+		d.backoff.Succeeded()
+		d.retryAt = time.Time{}
+	}
+	d.link, d.profile, d.balanced = link, profile, balanced
+	d.connectedAt = time.Now()
+	d.counted.read, d.counted.written, d.counted.at = 0, 0, d.connectedAt
+	// The connection of the previous binary goes on, it is counted once.
+	upgraded := handover != nil && handover.Profile == profile
+	if upgraded {
+		d.connectedAt = handover.Since
+	}
+	if d.stats != nil && !upgraded {
+		d.stats.Connected(d.connectedAt, serverName(link, profile))
+		if d.obfuscated {
+			d.stats.Obfuscated(d.connectedAt, serverName(link, profile))
+		}
+	}
+	logEvent(client.Event{
+		Type: eventConnected, Time: time.Now(), Message: "connected",
+		Attrs: map[string]string{"profile": serverName(link, profile)},
+	})
+	if url := d.vpn.PACURL(); url != "" {
+		d.logger.Info("serving PAC file", "url", url)
+	}
+}
+
+// disconnected counts traffic of the ended connection. It must be called with d.mu held.
+func (d *daemon) disconnected() {
+	d.countStats(time.Now())
+	d.state.BytesRead += int64(d.vpn.BytesRead())
+	d.state.BytesWritten += int64(d.vpn.BytesWritten())
+	d.link, d.profile, d.balanced = "", "", nil
+}
+
+// connect connects to link of profile, flows are spread over the servers of balanced links too if there are any.
+// TUN device handed over by the previous binary is adopted by the first connection.
+func (d *daemon) connect(link, profile string, balanced []string) error {
+	if err := d.useClient(profile); err != nil {
+		return err
+	}
+	if d.handover != nil {
+		d.vpn.Adopt(&d.handover.Handover)
+		d.handover = nil
+	}
+	if len(balanced) == 0 {
+		return d.vpn.Connect(link)
+	}
+	d.logger.Info("balancing connections", "servers", len(balanced)+1)
+
+	return d.vpn.ConnectBalanced(append([]string{link}, balanced...))
+}
+
+// useClient replaces the disconnected client with a client of profile settings (merged with the client
+// settings like on the command line) if they differ from the settings of the current client.
+func (d *daemon) useClient(profile string) error {
+	if d.clientCurrent(profile) {
+		return nil
+	}
+	if err := d.newClient(profile); err != nil {
+		return err
+	}
+	if d.vpnSettings != nil {
+		d.logger.Info("applied profile settings", "profile", profile)
+	}
+
+	return nil
+}
+
+// clientCurrent reports whether the client was created with the settings of the file and of profile.
+func (d *daemon) clientCurrent(profile string) bool {
+	return reflect.DeepEqual(d.profileSettings[profile], d.vpnSettings) && reflect.DeepEqual(d.fileSettings, d.vpnFileSettings)
+}
+
+// newClient replaces the disconnected client with a new client of profile settings.
+func (d *daemon) newClient(profile string) error {
+	settings := d.profileSettings[profile]
+	cfg, err := profileClientConfig(profile, slog.LevelInfo)
+	if err != nil {
+		return fmt.Errorf("profile %q settings: %w", profile, err)
+	}
+	vpn, err := client.NewClientWithOpts(cfg)
+	if err != nil {
+		return fmt.Errorf("profile %q settings: %w", profile, err)
+	}
+	d.state.AddHistory(d.vpn.History()) // Kept, the history of vpn is gone with it.
+	d.vpn, d.vpnSettings, d.vpnFileSettings, d.obfuscated = vpn, settings, d.fileSettings, cfg.Obfuscation != nil
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+// execCmd replaces the process with the program run in the network namespace of the connection (see -netns),
+// so the program is tunneled while the rest of the system is not.
+func execCmd(args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return usageError("usage: exec -- <cmd> [args]")
+	}
+
+	cfg, err := clientConfig(slog.LevelError)
+	if err != nil {
+		return err
+	}
+	name := cfg.Netns
+	if name == "" {
+		name = client.DefaultNetns
+	}
+
+	return client.ExecNetns(name, args)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/config"
+)
+
+// Exit codes of all commands, they are stable so scripts and supervisors can react to failures.
+const (
+	exitFailure    = 1 // Any other failure.
+	exitUsage      = 2 // Invalid command line.
+	exitConfig     = 3 // Invalid link, profile, config file, flags or environment, retrying does not help.
+	exitPermission = 4 // Missing privileges, e.g. not run as root.
+	exitTimeout    = 5 // Connecting timed out, e.g. the server is not reachable.
+	exitAuth       = 6 // The server rejected the credentials of the link.
+	exitRunning    = 7 // Another client is running with the same config or conflicting routes.
+)
+
+// exitError sets the exit code of err explicitly.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExit returns err with exit code, nil if err is nil.
+func withExit(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &exitError{code: code, err: err}
+}
+
+// usageError returns invalid command line error with usage message.
+func usageError(usage string) error {
+	return &exitError{code: exitUsage, err: errors.New(usage)}
+}
+
+// exitCode returns the exit code of err returned by a command.
+func exitCode(err error) int {
+	var (
+		exitErr *exitError
+		netErr  net.Error
+	)
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return exitPermission
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	case errors.Is(err, client.ErrInvalidConfig), errors.Is(err, config.ErrNotFound):
+		return exitConfig
+	case errors.Is(err, client.ErrJournalInUse), errors.Is(err, client.ErrConflict):
+		return exitRunning
+	case errors.Is(err, client.ErrAuth), errors.Is(err, client.ErrRealityAuth):
+		return exitAuth
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return exitTimeout
+	}
+
+	return exitFailure
+}
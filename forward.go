@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+func forwardCmd(args []string) error {
+	if len(args) != 3 {
+		return usageError("usage: forward <config_url> <local_addr> <remote_addr>")
+	}
+
+	return connect(args[0], false, func(vpn *client.Client) error {
+		f, err := vpn.Forward(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		slog.Info("Forwarding", "local", f.Addr(), "remote", args[2])
+
+		return nil
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/goxray/tun/pkg/config"
+)
+
+func groupCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New("group: command is required (list, select)")
+	}
+
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return usageError("usage: group list")
+		}
+		// The daemon saves the profile it is connected to, it is the current pick of the active group.
+		var current string
+		if st, err := config.LoadState(config.StatePath(path)); err == nil {
+			current = st.Profile
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, g := range cfg.Groups {
+			profile := g.Selected
+			if g.Type == config.GroupSelect && profile == "" && len(g.Profiles) > 0 {
+				profile = g.Profiles[0]
+			}
+			active := ""
+			if g.Name == cfg.Active {
+				active = "active"
+				if g.Type != config.GroupSelect && slices.Contains(g.Profiles, current) {
+					profile = current
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", g.Name, g.Type, strings.Join(g.Profiles, ","), profile, active)
+		}
+
+		return w.Flush()
+	case "select":
+		if len(args) != 2 && len(args) != 3 {
+			return usageError("usage: group select <group> [profile]")
+		}
+		g, err := cfg.Group(args[1])
+		if err != nil {
+			return err
+		}
+		if len(args) == 3 {
+			if err = cfg.Select(g.Name, args[2]); err != nil {
+				return err
+			}
+		}
+		// Reject broken groups early instead of failing in the daemon.
+		if _, err = g.Policy(cfg); err != nil {
+			return err
+		}
+		cfg.Active = g.Name
+
+		return cfg.Save(path)
+	default:
+		return fmt.Errorf("group: unknown command %q", args[0])
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+// healthReadTimeout limits reading requests of the health endpoints.
+const healthReadTimeout = 5 * time.Second
+
+// tunnelHealth reports whether the tunnel of vpn connected at connectedAt (zero if it is not connected) works:
+// live fails if the tunnel is broken (the last health check of the connection failed or the tunnel stopped),
+// so orchestrators restart the container, ready fails as well while the tunnel is not connected.
+func tunnelHealth(vpn *client.Client, connectedAt time.Time) (live, ready error) {
+	if connectedAt.IsZero() {
+		return nil, client.ErrNotConnected
+	}
+	if last := vpn.LastDisconnect(); last != nil && last.Time.After(connectedAt) {
+		err := fmt.Errorf("tunnel stopped: %s", last.Reason)
+		return err, err
+	}
+	if check := vpn.LastCheck(); check.Err != nil && check.Time.After(connectedAt) {
+		err := fmt.Errorf("health check failed: %w", check.Err)
+		return err, err
+	}
+
+	return nil, nil
+}
+
+// serveHealth serves /healthz (liveness) and /readyz (readiness) endpoints on addr until ctx is done, they respond
+// with 503 status and the error of health when it fails.
+func serveHealth(ctx context.Context, addr string, logger *slog.Logger, health func() (live, ready error)) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Warn("health endpoints not served", "addr", addr, "err", err)
+		return
+	}
+
+	respond := func(w http.ResponseWriter, err error) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprintln(w, "ok")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		live, _ := health()
+		respond(w, live)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		_, ready := health()
+		respond(w, ready)
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: healthReadTimeout}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	logger.Debug("serving health endpoints", "addr", l.Addr())
+	if err = srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		logger.Warn("health endpoints stopped", "err", err)
+	}
+}
+
+// health returns tunnelHealth of the daemon connection. The last known health is returned while d.mu is held
+// long, e.g. while connecting.
+func (d *daemon) health() (live, ready error) {
+	last := &d.lastHealth
+	if !d.mu.TryLock() {
+		last.mu.Lock()
+		defer last.mu.Unlock()
+		return last.live, last.ready
+	}
+	var connectedAt time.Time
+	if d.link != "" {
+		connectedAt = d.connectedAt
+	}
+	live, ready = tunnelHealth(d.vpn, connectedAt)
+	d.mu.Unlock()
+
+	last.mu.Lock()
+	defer last.mu.Unlock()
+	last.live, last.ready = live, ready
+
+	return live, ready
+}
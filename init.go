@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/config"
+	"github.com/goxray/tun/pkg/service"
+)
+
+const (
+	// subscriptionTimeout limits fetching subscription of the setup.
+	subscriptionTimeout = 30 * time.Second
+	// initGroup is the url-test group the setup creates for several profiles.
+	initGroup = "auto"
+	// wireGuardOverhead is the outer IP and UDP headers plus WireGuard header of the tunneled packets.
+	wireGuardOverhead = 80
+	// defaultMTU is the TUN device MTU if none is set.
+	defaultMTU = 1500
+)
+
+// setupDNS are DNS over HTTPS resolvers the setup configures if the system resolver fails
+// to resolve the server.
+var setupDNS = []string{"https://1.1.1.1/dns-query", "https://8.8.8.8/dns-query"}
+
+// initCmd is the interactive first run setup: it asks for a link or a subscription, tests the servers,
+// picks MTU and DNS settings for this network, writes the config file and optionally installs the daemon
+// as a system service.
+func initCmd(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	name := fs.String("name", service.DefaultName, "service name of the daemon")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		return usageError("usage: init [-name <service>]")
+	}
+
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return withExit(exitConfig, err)
+	}
+	w := &wizard{in: bufio.NewScanner(os.Stdin)}
+	if len(cfg.Profiles) > 0 {
+		fmt.Printf("%s has %d profiles already, the new ones are added to them.\n", path, len(cfg.Profiles))
+	}
+
+	var links []string
+	for len(links) == 0 {
+		answer, err := w.ask("Connection link, file with the link or subscription URL", "")
+		if err != nil {
+			return err
+		}
+		if links, err = setupLinks(answer); err != nil {
+			fmt.Println(err)
+		}
+	}
+	profiles, err := w.profiles(cfg, links)
+	if err != nil {
+		return err
+	}
+
+	clientCfg, err := clientConfig(slog.LevelError)
+	if err != nil {
+		return err
+	}
+	vpn, err := client.NewClientWithOpts(clientCfg)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Testing servers...")
+	var passed []string
+	for _, p := range profiles {
+		ctx, cancel := context.WithTimeout(context.Background(), groupProbeTimeout)
+		latency, err := vpn.Probe(ctx, p.Link)
+		cancel()
+		if err != nil {
+			fmt.Printf("  %-20s failed: %v\n", p.Name, err)
+			continue
+		}
+		fmt.Printf("  %-20s ok, %s\n", p.Name, latency.Round(time.Millisecond))
+		passed = append(passed, p.Name)
+	}
+	if len(passed) == 0 {
+		if ok, err := w.confirm("No server is reachable, save the profiles anyway?", false); err != nil || !ok {
+			return errors.Join(err, errors.New("setup cancelled, nothing saved"))
+		}
+	}
+
+	setupSettings(cfg, vpn, profiles[0].Link)
+	for _, p := range profiles {
+		if err = cfg.Set(p); err != nil {
+			return err
+		}
+	}
+	active := profiles[0].Name
+	if len(passed) > 0 {
+		active = passed[0]
+	}
+	if len(profiles) > 1 && slices.IndexFunc(cfg.Groups, func(g *config.Group) bool { return g.Name == initGroup }) < 0 {
+		g := &config.Group{Name: initGroup, Type: config.GroupURLTest}
+		for _, p := range profiles {
+			g.Profiles = append(g.Profiles, p.Name)
+		}
+		cfg.Groups = append(cfg.Groups, g)
+		active = initGroup
+		fmt.Printf("Created %q group connecting to the fastest of the profiles.\n", initGroup)
+	}
+	cfg.Active = active
+	if err = cfg.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %s, %q is active.\n", path, active)
+
+	install, err := w.confirm("Install the daemon as a system service, connected at boot?", false)
+	if err != nil {
+		return err
+	}
+	if install {
+		unit, err := installService(*name, path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed service %s (%s), it is connecting now.\n", *name, unit)
+		return nil
+	}
+	fmt.Printf("Connect with:  sudo %s up %s\n", os.Args[0], profiles[0].Name)
+	fmt.Printf("or stay connected to %q with:  sudo %s daemon\n", active, os.Args[0])
+
+	return nil
+}
+
+// wizard asks questions on the terminal.
+type wizard struct {
+	in *bufio.Scanner
+}
+
+// ask prints question and returns the answer, def if it is empty.
+func (w *wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	if !w.in.Scan() {
+		fmt.Println()
+		return "", errors.Join(w.in.Err(), errors.New("setup cancelled, input closed"))
+	}
+	if answer := strings.TrimSpace(w.in.Text()); answer != "" {
+		return answer, nil
+	}
+
+	return def, nil
+}
+
+// confirm asks yes or no question.
+func (w *wizard) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := w.ask(question+" ("+hint+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// profiles names new profiles of links: a single link is named by the user, subscription links by their remarks.
+func (w *wizard) profiles(cfg *config.File, links []string) ([]*config.Profile, error) {
+	taken := func(name string) bool {
+		_, err := cfg.Group(name)
+		return err == nil || slices.ContainsFunc(cfg.Profiles, func(p *config.Profile) bool { return p.Name == name })
+	}
+	if len(links) == 1 {
+		for {
+			name, err := w.ask("Profile name", profileName(client.LinkRemark(links[0]), "default"))
+			if err != nil {
+				return nil, err
+			}
+			if !taken(name) {
+				return []*config.Profile{{Name: name, Link: links[0]}}, nil
+			}
+			if ok, err := w.confirm(fmt.Sprintf("%q exists, replace it?", name), false); err != nil || ok {
+				return []*config.Profile{{Name: name, Link: links[0]}}, err
+			}
+		}
+	}
+
+	profiles := make([]*config.Profile, 0, len(links))
+	var names []string
+	for i, link := range links {
+		base := profileName(client.LinkRemark(link), "server-"+strconv.Itoa(i+1))
+		name := base
+		for n := 2; taken(name) || slices.Contains(names, name); n++ {
+			name = base + "-" + strconv.Itoa(n)
+		}
+		names = append(names, name)
+		profiles = append(profiles, &config.Profile{Name: name, Link: link})
+	}
+	fmt.Printf("Found %d servers: %s\n", len(links), strings.Join(names, ", "))
+
+	return profiles, nil
+}
+
+// profileName returns profile name of the link remark, def if the remark is empty.
+func profileName(remark, def string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.':
+			return unicode.ToLower(r)
+		case unicode.IsSpace(r):
+			return '-'
+		}
+		return -1
+	}, remark)
+	if name = strings.Trim(name, "-"); name == "" {
+		return def
+	}
+
+	return name
+}
+
+// setupLinks returns valid links of answer: subscription URL, link or file containing it.
+func setupLinks(answer string) ([]string, error) {
+	var links []string
+	if strings.HasPrefix(answer, "http://") || strings.HasPrefix(answer, "https://") {
+		b, err := fetchSubscription(answer)
+		if err != nil {
+			return nil, err
+		}
+		links = config.ParseSubscription(b)
+	} else {
+		link, err := readLink(answer)
+		if err != nil {
+			return nil, err
+		}
+		links = []string{link}
+	}
+
+	var valid []string
+	for _, link := range links {
+		// Broken links are rejected now instead of failing on connect.
+		if _, err := client.ShareLink(link); err != nil {
+			fmt.Printf("skipping invalid link: %v\n", err)
+			continue
+		}
+		valid = append(valid, link)
+	}
+	if len(valid) == 0 {
+		return nil, errors.New("no valid connection link found, try again")
+	}
+
+	return valid, nil
+}
+
+// fetchSubscription downloads subscription content, before the tunnel is set up.
+func fetchSubscription(url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), subscriptionTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("subscription: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("subscription: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("subscription: %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+}
+
+// setupSettings picks MTU and DNS settings of the network for link, settings set already are kept.
+// Smaller MTU is set on networks with lower MTU of the gateway interface (PPPoE, mobile networks) and for
+// WireGuard, which adds its headers to the tunneled packets. DNS over HTTPS resolves the server if the system resolver fails to.
+func setupSettings(cfg *config.File, vpn *client.Client, link string) {
+	plan, planErr := vpn.Plan(link)
+	if cfg.Settings.MTU == 0 {
+		mtu, err := vpn.GatewayMTU()
+		if err == nil && plan != nil && plan.Protocol == "wireguard" {
+			mtu -= wireGuardOverhead
+		}
+		if err == nil && mtu < defaultMTU && mtu >= 576 {
+			cfg.Settings.MTU = mtu
+			fmt.Printf("MTU set to %d for this network.\n", mtu)
+		}
+	}
+
+	if planErr == nil || len(cfg.Settings.BootstrapDNS) > 0 {
+		return
+	}
+	settings := cfg.Settings
+	settings.BootstrapDNS = setupDNS
+	clientCfg, err := settings.ClientConfig(slog.LevelError)
+	if err != nil {
+		return
+	}
+	bootstrapped, err := client.NewClientWithOpts(clientCfg)
+	if err != nil {
+		return
+	}
+	if _, err = bootstrapped.Plan(link); err == nil {
+		cfg.Settings.BootstrapDNS = setupDNS
+		fmt.Printf("System DNS failed to resolve the server (%v), using DNS over HTTPS.\n", planErr)
+	}
+}
+
+// installService installs the daemon with config file path as system service name.
+func installService(name, path string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", err
+	}
+
+	return service.Install(name, []string{exe, "-config", path, "daemon"})
+}
@@ -0,0 +1,250 @@
+/*
+Package socks5 implements minimal SOCKS5 server (RFC 1928) supporting CONNECT and, if the backend relays datagrams,
+UDP ASSOCIATE commands without authentication.
+
+It is used to expose proxy backends which are not SOCKS5 servers themselves (e.g. SSH connection)
+as SOCKS5 inbound for the TUN pipe.
+*/
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	version5 = 0x05
+
+	methodNoAuth       = 0x00
+	methodNoAcceptable = 0xff
+
+	cmdConnect      = 0x01
+	cmdUDPAssociate = 0x03
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	repSucceeded          = 0x00
+	repGeneralFailure     = 0x01
+	repCmdNotSupported    = 0x07
+	repAddrTypeNotSupport = 0x08
+)
+
+// handshakeTimeout limits the time client has to finish SOCKS handshake.
+const handshakeTimeout = 10 * time.Second
+
+// DialFunc connects to the addr on the named network.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Server is a SOCKS5 server relaying CONNECT requests through Dial and UDP ASSOCIATE requests through ListenPacket.
+type Server struct {
+	Dial DialFunc
+	// ListenPacket relays datagrams of UDP associations (nil: UDP ASSOCIATE is not supported).
+	ListenPacket ListenPacketFunc
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewServer creates new Server with dial used for outgoing connections.
+func NewServer(dial DialFunc) *Server {
+	return &Server{Dial: dial, conns: map[net.Conn]struct{}{}}
+}
+
+// Serve accepts connections on ln and blocks until ln is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		if !s.track(conn) {
+			_ = conn.Close()
+			return nil
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+
+			s.handle(conn)
+		}()
+	}
+}
+
+// Close closes all active connections and waits for handlers to finish. Listener must be closed by the caller.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	_ = conn.Close()
+}
+
+func (s *Server) handle(conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	cmd, addr, err := handshake(conn, s.ListenPacket != nil)
+	if err != nil {
+		return
+	}
+	if cmd == cmdUDPAssociate {
+		s.associate(conn)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	remote, err := s.Dial(ctx, "tcp", addr)
+	if err != nil {
+		_ = writeReply(conn, repGeneralFailure)
+		return
+	}
+	defer remote.Close()
+
+	if err = writeReply(conn, repSucceeded); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	relay(conn, remote)
+}
+
+// handshake negotiates auth method and reads CONNECT (or UDP ASSOCIATE if udp) request, returning the command and
+// requested address.
+func handshake(rw io.ReadWriter, udp bool) (byte, string, error) {
+	// +----+----------+----------+
+	// |VER | NMETHODS | METHODS  |
+	// +----+----------+----------+
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(rw, hdr); err != nil {
+		return 0, "", fmt.Errorf("read greeting: %w", err)
+	}
+	if hdr[0] != version5 {
+		return 0, "", fmt.Errorf("unsupported version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return 0, "", fmt.Errorf("read methods: %w", err)
+	}
+
+	method := byte(methodNoAcceptable)
+	for _, m := range methods {
+		if m == methodNoAuth {
+			method = methodNoAuth
+		}
+	}
+	if _, err := rw.Write([]byte{version5, method}); err != nil {
+		return 0, "", fmt.Errorf("write method: %w", err)
+	}
+	if method == methodNoAcceptable {
+		return 0, "", errors.New("no acceptable auth method")
+	}
+
+	// +----+-----+-------+------+----------+----------+
+	// |VER | CMD |  RSV  | ATYP | DST.ADDR | DST.PORT |
+	// +----+-----+-------+------+----------+----------+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(rw, req); err != nil {
+		return 0, "", fmt.Errorf("read request: %w", err)
+	}
+	if req[1] != cmdConnect && (req[1] != cmdUDPAssociate || !udp) {
+		_ = writeReply(rw, repCmdNotSupported)
+		return 0, "", fmt.Errorf("unsupported command %d", req[1])
+	}
+
+	var host string
+	switch req[3] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if req[3] == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(rw, ip); err != nil {
+			return 0, "", fmt.Errorf("read ip: %w", err)
+		}
+		host = ip.String()
+	case atypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(rw, l); err != nil {
+			return 0, "", fmt.Errorf("read domain length: %w", err)
+		}
+		domain := make([]byte, l[0])
+		if _, err := io.ReadFull(rw, domain); err != nil {
+			return 0, "", fmt.Errorf("read domain: %w", err)
+		}
+		host = string(domain)
+	default:
+		_ = writeReply(rw, repAddrTypeNotSupport)
+		return 0, "", fmt.Errorf("unsupported address type %d", req[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(rw, port); err != nil {
+		return 0, "", fmt.Errorf("read port: %w", err)
+	}
+
+	return req[1], net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeReply writes reply with zero bound address, clients are not expected to use it.
+func writeReply(w io.Writer, rep byte) error {
+	_, err := w.Write([]byte{version5, rep, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+
+	return err
+}
+
+// relay copies data in both directions until either side is done.
+func relay(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		_ = dst.Close()
+		done <- struct{}{}
+	}
+
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	<-done
+}
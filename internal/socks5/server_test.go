@@ -0,0 +1,154 @@
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Connect(t *testing.T) {
+	echo := startEchoServer(t)
+
+	var dialed string
+	srv := NewServer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		return (&net.Dialer{}).DialContext(ctx, network, echo)
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(ln) }()
+	defer func() {
+		require.NoError(t, ln.Close())
+		require.NoError(t, srv.Close())
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Greeting with "no auth" method.
+	_, err = conn.Write([]byte{0x05, 0x01, 0x00})
+	require.NoError(t, err)
+	resp := make([]byte, 2)
+	_, err = io.ReadFull(conn, resp)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x05, 0x00}, resp)
+
+	// CONNECT example.com:80.
+	req := append([]byte{0x05, 0x01, 0x00, 0x03, byte(len("example.com"))}, "example.com"...)
+	_, err = conn.Write(append(req, 0x00, 0x50))
+	require.NoError(t, err)
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x00), reply[1])
+	require.Equal(t, "example.com:80", dialed)
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+}
+
+func TestServer_UnsupportedCommand(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_, _, _ = handshake(server, false)
+		_ = server.Close()
+	}()
+
+	_, err := client.Write([]byte{0x05, 0x01, 0x00})
+	require.NoError(t, err)
+	_, err = io.ReadFull(client, make([]byte, 2))
+	require.NoError(t, err)
+
+	// UDP ASSOCIATE is not supported without ListenPacket.
+	_, err = client.Write([]byte{0x05, 0x03, 0x00, 0x01})
+	require.NoError(t, err)
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(client, reply)
+	require.NoError(t, err)
+	require.Equal(t, byte(0x07), reply[1])
+}
+
+func TestServer_UDPAssociate(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	srv := NewServer(nil)
+	srv.ListenPacket = func(ctx context.Context) (net.PacketConn, error) {
+		return (&net.ListenConfig{}).ListenPacket(ctx, "udp", "127.0.0.1:0")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(ln) }()
+	defer func() {
+		require.NoError(t, ln.Close())
+		require.NoError(t, srv.Close())
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte{0x05, 0x01, 0x00, 0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	require.NoError(t, err)
+	reply := make([]byte, 12)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x05, 0x00, 0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1}, reply[:10])
+	relay := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(binary.BigEndian.Uint16(reply[10:]))}
+
+	pc, err := net.DialUDP("udp", nil, relay)
+	require.NoError(t, err)
+	defer pc.Close()
+	dst := echo.LocalAddr().(*net.UDPAddr)
+	header := binary.BigEndian.AppendUint16([]byte{0, 0, 0, 0x01, 127, 0, 0, 1}, uint16(dst.Port))
+	_, err = pc.Write(append(header, "ping"...))
+	require.NoError(t, err)
+
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 1024)
+	n, err := pc.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, append(header, "ping"...), buf[:n], "reply from the destination")
+}
+
+func startEchoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
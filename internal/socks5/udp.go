@@ -0,0 +1,193 @@
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maxUDPPacket is the largest SOCKS5 UDP packet.
+const maxUDPPacket = 65535
+
+// ListenPacketFunc opens a packet connection relaying datagrams of one UDP association, ctx is done when
+// the association ends. Destinations passed to WriteTo are *net.UDPAddr or Addr if the client requested
+// a domain name.
+type ListenPacketFunc func(ctx context.Context) (net.PacketConn, error)
+
+// Addr is a "host:port" UDP address with domain name host.
+type Addr string
+
+// Network implements net.Addr.
+func (a Addr) Network() string { return "udp" }
+
+// String implements net.Addr.
+func (a Addr) String() string { return string(a) }
+
+// associate serves UDP ASSOCIATE request of conn, the association lasts as long as conn.
+func (s *Server) associate(conn net.Conn) {
+	local, _ := conn.LocalAddr().(*net.TCPAddr)
+	client, _ := conn.RemoteAddr().(*net.TCPAddr)
+	if local == nil || client == nil {
+		_ = writeReply(conn, repGeneralFailure)
+		return
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP, Zone: local.Zone})
+	if err != nil {
+		_ = writeReply(conn, repGeneralFailure)
+		return
+	}
+	defer relay.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	remote, err := s.ListenPacket(ctx)
+	if err != nil {
+		_ = writeReply(conn, repGeneralFailure)
+		return
+	}
+	defer remote.Close()
+
+	if err = writeBoundReply(conn, relay.LocalAddr().(*net.UDPAddr)); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	a := &udpAssociation{relay: relay, remote: remote}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.replies()
+	}()
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		_ = relay.Close()
+		_ = remote.Close()
+	}()
+	a.requests(client.IP)
+	<-done
+}
+
+// udpAssociation relays datagrams between SOCKS5 client and the remote packet connection.
+type udpAssociation struct {
+	relay  *net.UDPConn
+	remote net.PacketConn
+	client atomic.Pointer[net.UDPAddr] // The last source of client datagrams, replies are sent to it.
+}
+
+// requests sends datagrams of the client at ip to their destinations until the relay is closed.
+func (a *udpAssociation) requests(ip net.IP) {
+	buf := make([]byte, maxUDPPacket)
+	for {
+		n, src, err := a.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !src.IP.Equal(ip) {
+			continue // RFC 1928 7: datagrams of other hosts are dropped.
+		}
+		payload, dst, ok := parseUDPRequest(buf[:n])
+		if !ok {
+			continue
+		}
+		a.client.Store(src)
+		_, _ = a.remote.WriteTo(payload, dst)
+	}
+}
+
+// replies sends datagrams received from the remote to the client until the remote is closed.
+func (a *udpAssociation) replies() {
+	buf := make([]byte, maxUDPPacket)
+	out := make([]byte, 0, maxUDPPacket)
+	for {
+		n, src, err := a.remote.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		client := a.client.Load()
+		if client == nil {
+			continue
+		}
+		header, ok := appendUDPHeader(out[:0], src)
+		if !ok {
+			continue
+		}
+		_, _ = a.relay.WriteToUDP(append(header, buf[:n]...), client)
+	}
+}
+
+// parseUDPRequest returns the payload and destination of SOCKS5 UDP request packet, fragments are not supported.
+func parseUDPRequest(pkt []byte) ([]byte, net.Addr, bool) {
+	// +----+------+------+----------+----------+----------+
+	// |RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
+	// +----+------+------+----------+----------+----------+
+	if len(pkt) < 4 || pkt[2] != 0 {
+		return nil, nil, false
+	}
+
+	var ipLen int
+	switch pkt[3] {
+	case atypIPv4:
+		ipLen = net.IPv4len
+	case atypIPv6:
+		ipLen = net.IPv6len
+	case atypDomain:
+		if len(pkt) < 5 || len(pkt) < 5+int(pkt[4])+2 {
+			return nil, nil, false
+		}
+		host, rest := string(pkt[5:5+int(pkt[4])]), pkt[5+int(pkt[4]):]
+		port := strconv.Itoa(int(binary.BigEndian.Uint16(rest)))
+
+		return rest[2:], Addr(net.JoinHostPort(host, port)), true
+	default:
+		return nil, nil, false
+	}
+	if len(pkt) < 4+ipLen+2 {
+		return nil, nil, false
+	}
+	ip, rest := net.IP(pkt[4:4+ipLen]), pkt[4+ipLen:]
+
+	return rest[2:], &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(rest))}, true
+}
+
+// appendUDPHeader appends SOCKS5 UDP header of src address to b: RSV FRAG ATYP DST.ADDR DST.PORT.
+func appendUDPHeader(b []byte, src net.Addr) ([]byte, bool) {
+	host, port, err := net.SplitHostPort(src.String())
+	if err != nil {
+		return nil, false
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, false
+	}
+
+	b = append(b, 0, 0, 0)
+	switch ip := net.ParseIP(host); {
+	case ip.To4() != nil:
+		b = append(append(b, atypIPv4), ip.To4()...)
+	case ip != nil:
+		b = append(append(b, atypIPv6), ip...)
+	case len(host) <= 255:
+		b = append(append(b, atypDomain, byte(len(host))), host...)
+	default:
+		return nil, false
+	}
+
+	return binary.BigEndian.AppendUint16(b, uint16(p)), true
+}
+
+// writeBoundReply writes succeeded reply with bound address addr, it is the relay address of UDP association.
+func writeBoundReply(w io.Writer, addr *net.UDPAddr) error {
+	b := []byte{version5, repSucceeded, 0x00}
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		b = append(append(b, atypIPv4), ip4...)
+	} else {
+		b = append(append(b, atypIPv6), addr.IP.To16()...)
+	}
+	_, err := w.Write(binary.BigEndian.AppendUint16(b, uint16(addr.Port)))
+
+	return err
+}
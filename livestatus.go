@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Connection states of liveStatus.
+const (
+	liveConnected    = "connected"
+	liveDisconnected = "disconnected" // The daemon runs, but it is not connected, e.g. outside the schedule.
+	liveStopped      = "stopped"      // The daemon does not run.
+)
+
+// Formats of the status stream, see statusStream.
+const (
+	formatJSONStream = "json-stream"
+	formatWaybar     = "waybar"
+	formatI3blocks   = "i3blocks"
+)
+
+// statusSocketTimeout limits a status socket exchange.
+const statusSocketTimeout = time.Second
+
+// liveStatus is the daemon connection state served on the status socket, for status bars.
+type liveStatus struct {
+	State        string    `json:"state"`
+	Profile      string    `json:"profile,omitempty"`
+	Since        time.Time `json:"since,omitzero"` // Time of the connection.
+	BytesRead    int64     `json:"bytes_read"`     // Traffic of the connection.
+	BytesWritten int64     `json:"bytes_written"`
+	// ReadRate and WriteRate are the throughput in bytes per second since the previous update of the stream.
+	ReadRate  float64 `json:"read_rate"`
+	WriteRate float64 `json:"write_rate"`
+	// PID is the daemon process, self-update signals it to upgrade.
+	PID int `json:"pid,omitempty"`
+}
+
+// liveStatus returns the connection state. It must be called with d.mu held.
+func (d *daemon) liveStatus() *liveStatus {
+	if d.link == "" {
+		return &liveStatus{State: liveDisconnected, PID: os.Getpid()}
+	}
+
+	return &liveStatus{
+		State: liveConnected, Profile: serverName(d.link, d.profile), Since: d.connectedAt,
+		BytesRead: int64(d.vpn.BytesRead()), BytesWritten: int64(d.vpn.BytesWritten()), PID: os.Getpid(),
+	}
+}
+
+// status returns the current liveStatus. The last known status is returned while d.mu is held long,
+// e.g. while connecting.
+func (d *daemon) status() *liveStatus {
+	last := &d.lastStatus
+	if !d.mu.TryLock() {
+		last.mu.Lock()
+		defer last.mu.Unlock()
+		if last.status == nil {
+			return &liveStatus{State: liveDisconnected, PID: os.Getpid()}
+		}
+		return last.status
+	}
+	st := d.liveStatus()
+	d.mu.Unlock()
+
+	last.mu.Lock()
+	defer last.mu.Unlock()
+	last.status = st
+
+	return st
+}
+
+// serveStatus answers every connection to the status socket at path with the current liveStatus as json,
+// until ctx is done.
+func (d *daemon) serveStatus(ctx context.Context, path string) {
+	if conn, err := net.DialTimeout("unix", path, statusSocketTimeout); err == nil {
+		_ = conn.Close()
+		d.logger.Warn("status socket is served by another daemon, not serving it", "path", path)
+		return
+	}
+	_ = os.Remove(path) // Left by a crashed run.
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		d.logger.Warn("status socket not served", "path", path, "err", err)
+		return
+	}
+	defer os.Remove(path)
+	// Status bars of desktop users can read it, access is limited by the config directory permissions.
+	_ = os.Chmod(path, 0o666)
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(statusSocketTimeout))
+		_ = json.NewEncoder(conn).Encode(d.status())
+		_ = conn.Close()
+	}
+}
+
+// readLiveStatus reads the daemon status from the status socket at path, stopped if the daemon does not serve it.
+func readLiveStatus(path string) *liveStatus {
+	conn, err := net.DialTimeout("unix", path, statusSocketTimeout)
+	if err != nil {
+		return &liveStatus{State: liveStopped}
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(statusSocketTimeout))
+	st := &liveStatus{}
+	if err = json.NewDecoder(conn).Decode(st); err != nil {
+		return &liveStatus{State: liveStopped}
+	}
+
+	return st
+}
+
+// statusStream prints the daemon status in format every interval until ctx is done, once if interval is zero.
+// Throughput is computed from traffic of consecutive updates.
+func statusStream(ctx context.Context, path, format string, interval time.Duration) error {
+	var (
+		prev   *liveStatus
+		prevAt time.Time
+	)
+	for {
+		st, at := readLiveStatus(path), time.Now()
+		if prev != nil && prev.State == liveConnected && st.State == liveConnected && prev.Since.Equal(st.Since) {
+			secs := at.Sub(prevAt).Seconds()
+			st.ReadRate = max(float64(st.BytesRead-prev.BytesRead)/secs, 0)
+			st.WriteRate = max(float64(st.BytesWritten-prev.BytesWritten)/secs, 0)
+		}
+		prev, prevAt = st, at
+
+		line, err := formatStatus(st, format)
+		if err != nil {
+			return err
+		}
+		fmt.Println(line)
+		if interval == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// formatStatus returns the status line of the stream format.
+func formatStatus(st *liveStatus, format string) (string, error) {
+	text := "VPN " + st.State
+	if st.State == liveConnected {
+		text = fmt.Sprintf("VPN %s ↓%s ↑%s", st.Profile, formatRate(st.ReadRate), formatRate(st.WriteRate))
+	}
+
+	switch format {
+	case formatJSONStream:
+		b, err := json.Marshal(st)
+		return string(b), err
+	case formatWaybar:
+		tooltip := "VPN " + st.State
+		if st.State == liveConnected {
+			tooltip = fmt.Sprintf("Connected to %s since %s\nTraffic ↓%s ↑%s", st.Profile,
+				st.Since.Local().Format(time.DateTime), formatBytes(st.BytesRead), formatBytes(st.BytesWritten))
+		}
+		b, err := json.Marshal(map[string]string{"text": text, "tooltip": tooltip, "class": st.State, "alt": st.State})
+		return string(b), err
+	case formatI3blocks:
+		return text, nil
+	default:
+		return "", usageError(fmt.Sprintf("unknown status format %q, use %s", format,
+			strings.Join([]string{formatJSONStream, formatWaybar, formatI3blocks}, ", ")))
+	}
+}
+
+// formatRate formats bytes per second, e.g. "1.5 MiB/s".
+func formatRate(rate float64) string {
+	return formatBytes(int64(rate)) + "/s"
+}
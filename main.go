@@ -2,58 +2,654 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/config"
 )
 
-var cmdArgsErr = `ERROR: no config_link provided
-usage: %s <config_url>
-  - config_url - xray connection link, like "vless://example..."
+var usage = `usage: %[1]s [flags] <config_url>
+       %[1]s [flags] <command> [args]
+
+  - config_url - xray connection link, like "vless://example...",
+    path to a file containing the link or WireGuard config, or profile name,
+    or part of profile name or server remark matching a single profile
+
+commands:
+  init [-name <service>]           interactive setup: add a link or subscription, test it, write the config file
+  up [-dry-run] <config_url>       connect (default command), -dry-run prints planned system changes instead
+  profile add <name> <config_url>  save connection profile
+  profile list                     list saved profiles
+  profile rm <name>                remove saved profile
+  profile use <name>               make profile active, daemon connects to the active profile
+  group list                       list proxy groups of the config file with their current profile
+  group select <group> [profile]   make group active, optionally select its profile (select groups)
+  config export [-sanitize] [-password-file <file>] <out.json>
+                                   export profiles, groups and settings to a portable file, optionally encrypted
+  config import [-merge] [-password-file <file>] <in.json>
+                                   replace the config file with an export, or merge the export into it
+  daemon                           stay connected to the active profile, config file changes are applied live
+  forward <config_url> <local_addr> <remote_addr>
+                                   connect and forward TCP connections to local_addr through the tunnel to remote_addr
+  status [-json]                   print exit IP, country and ASN of the traffic, to verify it goes through the tunnel
+  status -history [-json]          print recent connects, reconnects and disconnects of daemon with their errors
+  status -watch [-format json-stream|waybar|i3blocks] [-interval <duration>]
+                                   stream daemon connection state and throughput, for status bars
+  stats [-since <period>] [-by day|server] [-json]
+                                   print traffic, uptime and connects counted by daemon per day and server
+  exec -- <cmd> [args]             run program in the network namespace of -netns connection, tunneled (Linux)
+  cleanup [-json]                  undo system changes (routes, TUN device, system proxy) left by a crashed run
+  uninstall-service [-name <service>] [-purge] [-json]
+                                   stop the daemon, remove its service unit, undo system changes, delete state files
+  link <config_url>                print standard share link of the config
+  verify <config_url>              check REALITY server handshake, report the link parameter to fix
+  qr import <image> [name]         read share link from QR code image, save as profile if name is given
+  qr show <config_url> [out.png]   show QR code of the share link in terminal or write it to PNG image
+  self-update [-check] [-force]    install the latest release verified by its signed manifest
+  version [-json]                  print version, embedded xray-core, supported protocols and build info
+
+environment:
+  GOXRAY_LINK                      config_url used if not given as argument (up, daemon)
+  GOXRAY_CONFIG                    configuration file path
+  GOXRAY_INBOUND_PORT              same as -inbound-port
+  GOXRAY_INBOUND_ADDRESS           same as -inbound-address
+  GOXRAY_INBOUND_SOCKET            same as -inbound-socket
+  GOXRAY_INBOUND_ALLOW             same as -inbound-allow
+  GOXRAY_INBOUND_MAX_CONNS         same as -inbound-max-conns
+  GOXRAY_INBOUND_MAX_CONNS_PER_IP  same as -inbound-max-conns-per-ip
+  GOXRAY_UPSTREAM                  same as -upstream
+  GOXRAY_SYSTEM_PROXY              same as -system-proxy
+  GOXRAY_PAC_LISTEN                same as -pac-listen
+  GOXRAY_TUN_ADDRESS               same as -tun-address
+  GOXRAY_MTU                       same as -mtu
+  GOXRAY_ROUTE_METRIC              same as -route-metric
+  GOXRAY_ROUTE_TABLE               same as -route-table
+  GOXRAY_ROUTE_PRIORITY            same as -route-priority
+  GOXRAY_ROUTE_MARK                same as -route-mark
+  GOXRAY_ROUTE_VERIFY_INTERVAL     same as -route-verify-interval
+  GOXRAY_ROUTE_REPAIR              same as -route-repair
+  GOXRAY_NETNS                     same as -netns
+  GOXRAY_GATEWAY_MODE              same as -gateway-mode
+  GOXRAY_NETWORK_MANAGER           same as -network-manager
+  GOXRAY_TUNNEL_DNS                same as -tunnel-dns
+  GOXRAY_GATEWAY                   same as -gateway
+  GOXRAY_GATEWAY_WAIT              same as -gateway-wait
+  GOXRAY_NAT64_PREFIX              same as -nat64-prefix
+  GOXRAY_ECH                       same as -ech
+  GOXRAY_ECH_OUTER_SNI             same as -ech-outer-sni
+  GOXRAY_CLIENT_CERT               same as -client-cert
+  GOXRAY_CLIENT_KEY                same as -client-key
+  GOXRAY_CLIENT_KEY_PASSWORD       same as -client-key-password
+  GOXRAY_DOMAIN_STRATEGY           same as -domain-strategy
+  GOXRAY_BOOTSTRAP_DNS             same as -bootstrap-dns
+  GOXRAY_OBFS_PADDING              same as -obfs-padding
+  GOXRAY_OBFS_FRAGMENT             same as -obfs-fragment
+  GOXRAY_OBFS_PACKETS              same as -obfs-packets
+  GOXRAY_OBFS_JITTER               same as -obfs-jitter
+  GOXRAY_LOG_LEVEL                 same as -log-level
+  GOXRAY_LOG_DEDUP                 same as -log-dedup
+  GOXRAY_LOG_LEVELS                same as -log-levels
+  GOXRAY_CHECK_URL                 same as -check-url
+  GOXRAY_CHECK_STATUS              same as -check-status
+  GOXRAY_CHECK_TIMEOUT             same as -check-timeout
+  GOXRAY_CHECK_INTERVAL            same as -check-interval
+  GOXRAY_WATCHDOG                  same as -watchdog
+  GOXRAY_EXIT_INFO_URL             same as -exit-info-url
+  GOXRAY_CONTROL_ROUTE             same as -control-route
+  GOXRAY_UPDATE_CHECK              same as -update-check
+  GOXRAY_NOTIFY                    same as -notify
+  GOXRAY_METRICS_PUSH              same as -metrics-push
+  GOXRAY_METRICS_INTERVAL          same as -metrics-interval
+  GOXRAY_METRICS_TAGS              same as -metrics-tags
+  GOXRAY_HEALTH_LISTEN             same as -health-listen
+  GOXRAY_API_LISTEN                same as -api-listen
+  GOXRAY_API_TOKEN                 same as -api-token
+  GOXRAY_API_READ_TOKEN            same as -api-read-token
+  GOXRAY_API_CERT                  same as -api-cert
+  GOXRAY_API_KEY                   same as -api-key
+  GOXRAY_API_CLIENT_CA             same as -api-client-ca
+  GOXRAY_CAPTIVE_PORTAL            same as -captive-portal
+  GOXRAY_CAPTIVE_PORTAL_WAIT       same as -captive-portal-wait
+  GOXRAY_CAPTIVE_PORTAL_URL        same as -captive-portal-url
+  GOXRAY_BALANCER_STICKY           same as -balancer-sticky
+  GOXRAY_TCP_IDLE_TIMEOUT          same as -tcp-idle-timeout
+  GOXRAY_UDP_IDLE_TIMEOUT          same as -udp-idle-timeout
+  GOXRAY_MAX_FLOWS                 same as -max-flows
+  GOXRAY_FLOW_QUEUE_TIMEOUT        same as -flow-queue-timeout
+  GOXRAY_DRAIN_TIMEOUT             same as -drain-timeout
+  GOXRAY_PROXY_DIAL_TIMEOUT        same as -proxy-dial-timeout
+  GOXRAY_SOCKS_TIMEOUT             same as -socks-timeout
+  GOXRAY_HANDSHAKE_TIMEOUT         same as -handshake-timeout
+  GOXRAY_CONN_IDLE_TIMEOUT         same as -conn-idle-timeout
+  GOXRAY_UPLINK_ONLY_TIMEOUT       same as -uplink-only-timeout
+  GOXRAY_DOWNLINK_ONLY_TIMEOUT     same as -downlink-only-timeout
+  GOXRAY_TCP_KEEPALIVE             same as -tcp-keepalive
+  GOXRAY_MEMORY_BUDGET_MIB         same as -memory-budget-mib
+  GOXRAY_GO_MEM_LIMIT              same as -go-mem-limit
+  GOXRAY_PIPE_WORKERS              same as -pipe-workers
+  GOXRAY_PIPE_QUEUE                same as -pipe-queue
+  GOXRAY_PIPE_OVERFLOW             same as -pipe-overflow
+  GOXRAY_UDP_SESSION_CACHE         same as -udp-session-cache
+  GOXRAY_UDP_SPARE_SESSIONS        same as -udp-spare-sessions
+  GOXRAY_UDP_BATCH                 same as -udp-batch
+  GOXRAY_QUIC                      same as -quic
+  GOXRAY_QUIC_DELAY                same as -quic-delay
+  GOXRAY_WARMUP_CONNS              same as -warmup-conns
+  GOXRAY_WARMUP_TARGET             same as -warmup-target
+  GOXRAY_WARMUP_RECYCLE            same as -warmup-recycle
+  GOXRAY_DSCP                      same as -dscp
+  GOXRAY_DSCP_CLASSES              same as -dscp-classes
+  GOXRAY_SNIFFING                  same as -sniffing
+  GOXRAY_SNIFFING_ROUTE_ONLY       same as -sniffing-route-only
+  GOXRAY_ON_DEMAND                 same as -on-demand
+  GOXRAY_RECONNECT_MIN_BACKOFF     same as -reconnect-min-backoff
+  GOXRAY_RECONNECT_MAX_BACKOFF     same as -reconnect-max-backoff
+  GOXRAY_RECONNECT_FAILURES        same as -reconnect-failures
+  GOXRAY_RECONNECT_COOL_DOWN       same as -reconnect-cool-down
+  GOXRAY_FLOW_LOG                  same as -flow-log
+  GOXRAY_CAPTURE                   same as -capture
+  GOXRAY_CAPTURE_FILTER            same as -capture-filter
+  GOXRAY_CAPTURE_SNAPLEN           same as -capture-snaplen
+  GOXRAY_CAPTURE_MAX_MIB           same as -capture-max-mib
+
+  flags take precedence over environment, environment over "settings" of the configuration file
+
+flags:
 `
 
+var (
+	configPath           = flag.String("config", "", "configuration file path (default: $GOXRAY_CONFIG or user config dir)")
+	inboundPort          = flag.Int("inbound-port", 0, "local inbound proxy port (default: random free port)")
+	inboundAddr          = flag.String("inbound-address", "", "local inbound proxy IP, e.g. 0.0.0.0 to share in LAN (default: 127.0.0.1, requires -inbound-port)")
+	inboundSock          = flag.String("inbound-socket", "", "local inbound proxy Unix domain socket path, used instead of TCP port")
+	inboundAllow         = flag.String("inbound-allow", "", "comma separated IPs or CIDR networks allowed to connect to the inbound proxy (default: any)")
+	inboundMaxConns      = flag.Int("inbound-max-conns", 0, "max concurrent inbound proxy connections (default: unlimited)")
+	inboundMaxConnsPerIP = flag.Int("inbound-max-conns-per-ip", 0, "max concurrent inbound proxy connections from one IP (default: unlimited)")
+	upstream             = flag.String("upstream", "", "pipe TUN device into an external SOCKS5 proxy IP:port instead of connecting to the server, config_url is not needed then")
+	systemProxy          = flag.Bool("system-proxy", false, "point OS proxy settings to the inbound proxy while connected")
+	pacListen            = flag.String("pac-listen", "", "serve PAC file mirroring the routes on the address while connected, e.g. 127.0.0.1:8086")
+	tunAddress           = flag.String("tun-address", "", "TUN device address in CIDR notation (default: 192.18.0.1/32)")
+	mtu                  = flag.Int("mtu", 0, "TUN device MTU (default: 1500)")
+	netnsName            = flag.String("netns", "", "create TUN device in the Linux network namespace, e.g. "+client.DefaultNetns+", only programs started with exec command are tunneled")
+	gatewayMode          = flag.Bool("gateway-mode", false, "forward traffic of other hosts or containers routed via this one into the tunnel, Linux only")
+	networkManager       = flag.Bool("network-manager", false, "leave the TUN device unmanaged by NetworkManager, so it does not remove the routes, Linux only")
+	tunnelDNS            = flag.String("tunnel-dns", "", "comma separated DNS server IPs of the TUN device set with systemd-resolved, all queries go to them, Linux only")
+	gatewayAddr          = flag.String("gateway", "", "gateway IP or interface whose gateway is used, e.g. 192.168.1.1 or eth0 (default: gateway of the default route)")
+	gatewayWait          = flag.String("gateway-wait", "", "max wait for the gateway on connect, e.g. 1m when started before the network is up (default: no wait)")
+	routeMetric          = flag.Int("route-metric", 0, "metric of added routes, lower wins over routes of other VPN software, Linux only (default: 1)")
+	routeTable           = flag.Int("route-table", 0, "install routes to the routing table selected by ip rule instead of the main table, Linux only, e.g. 100")
+	routePriority        = flag.Int("route-priority", 0, "priority of the -route-table rule, lower is looked up first (default: before the main table)")
+	routeMark            = flag.Int("route-mark", 0, "apply the -route-table rule to packets with the firewall mark only (default: all packets)")
+	routeVerifyInterval  = flag.String("route-verify-interval", "", "read routes back from the routing table at the interval while connected to detect removed or overridden routes, e.g. 30s (default: after connect only)")
+	routeRepair          = flag.Bool("route-repair", false, "add routes removed by another program back instead of only reporting them")
+	nat64Prefix          = flag.String("nat64-prefix", "", "NAT64 prefix to reach IPv4 server on IPv6-only network, e.g. 64:ff9b::/96 (default: discovered via DNS64)")
+	echConfigList        = flag.String("ech", "", "base64 ECHConfigList of the server enabling Encrypted Client Hello (default: \"ech\" link parameter)")
+	echOuterSNI          = flag.String("ech-outer-sni", "", "use the ECH configs with the public name only, e.g. cdn.example.com (default: any)")
+	clientCert           = flag.String("client-cert", "", "client certificate for servers requiring mutual TLS: PEM or PKCS#12 file, or keystore:NAME of the OS keystore")
+	clientKey            = flag.String("client-key", "", "PEM file with the key of -client-cert (default: the key in -client-cert file)")
+	clientKeyPassword    = flag.String("client-key-password", "", "password of PKCS#12 -client-cert file, prefer "+config.EnvClientKeyPassword+" to keep it off the process list")
+	domainStrategy       = flag.String("domain-strategy", "", "server host name resolution: AsIs, UseIP, UseIPv4 or UseIPv6 (default: any family)")
+	bootstrapDNS         = flag.String("bootstrap-dns", "", "comma separated DNS servers resolving the server host name: IP[:port], tcp://IP[:port] or https://IP/dns-query (default: system resolver)")
+	obfsPadding          = flag.String("obfs-padding", "", "range of random padding bytes of xhttp requests against traffic analysis, e.g. 100-1000, costs throughput (default: none)")
+	obfsFragment         = flag.String("obfs-fragment", "", "split packets of the connection to the server into fragments of the range of bytes, e.g. 10-100, costs throughput (default: none)")
+	obfsPackets          = flag.String("obfs-packets", "", "packets to split by -obfs-fragment: tlshello or range of packet numbers, e.g. 1-3 (default: tlshello)")
+	obfsJitter           = flag.String("obfs-jitter", "", "range of delay between -obfs-fragment fragments in milliseconds, e.g. 10-50, costs latency (default: none)")
+	logLevel             = flag.String("log-level", "", "log level: debug, info, warn or error (default: error, info for daemon)")
+	logLevels            = flag.String("log-levels", "", "comma separated component:level pairs overriding -log-level of components tun, route, xray, pipe and dns, e.g. xray:error,route:debug")
+	logDedup             = flag.String("log-dedup", "", "window identical log messages are counted in instead of logged, e.g. 1m, 0s logs all (default: 10s)")
+	checkURL             = flag.String("check-url", "", "URL requested through the tunnel by connectivity checks (default: "+client.DefaultCheckURL+")")
+	checkStatus          = flag.Int("check-status", 0, "HTTP status of successful connectivity check (default: 204 for the default URL, any 2xx otherwise)")
+	checkTimeout         = flag.String("check-timeout", "", "connectivity check and exit info timeout, e.g. 5s (default: 10s)")
+	checkInterval        = flag.String("check-interval", "", "interval of health checks while connected, e.g. 30s (default: disabled)")
+	watchdog             = flag.String("watchdog", "", "rebuild daemon connection with a new client if health checks fail and no traffic arrives for the period, e.g. 5m, requires -check-interval (default: disabled)")
+	captivePortal        = flag.String("captive-portal", "", "captive portal handling: detect (fail to connect), wait (until login) or bypass (keep the portal reachable) (default: off)")
+	captivePortalWait    = flag.String("captive-portal-wait", "", "max wait for the portal login in wait mode, e.g. 10m (default: 5m)")
+	captivePortalURL     = flag.String("captive-portal-url", "", "plain HTTP captive portal probe URL responding with 204 status (default: "+client.DefaultCaptivePortalProbeURL+")")
+	balancerSticky       = flag.String("balancer-sticky", "", "keep connections to a destination on the same server of load-balance group while new ones are made within the duration, e.g. 30m, or off (default: 10m)")
+	tcpIdleTimeout       = flag.String("tcp-idle-timeout", "", "close tunneled TCP connections idle for the duration, e.g. 1h (default: 2h4m)")
+	udpIdleTimeout       = flag.String("udp-idle-timeout", "", "close tunneled UDP flows idle for the duration, e.g. 1m (default: 30s)")
+	maxFlows             = flag.Int("max-flows", 0, "max concurrent tunneled connections, new ones are rejected over the limit (default: unlimited)")
+	flowQueueTimeout     = flag.String("flow-queue-timeout", "", "max wait of new connection for a free slot over -max-flows, e.g. 2s (default: rejected right away)")
+	drainTimeout         = flag.String("drain-timeout", "", "grace period of active connections to finish on disconnect, e.g. 10s (default: closed right away)")
+	proxyDialTimeout     = flag.String("proxy-dial-timeout", "", "limit of connecting new tunneled connection to the local or upstream proxy, e.g. 2s (default: 4s)")
+	socksTimeout         = flag.String("socks-timeout", "", "limit of SOCKS5 handshake of new tunneled connection with the proxy, e.g. 30s (default: 10s)")
+	handshakeTimeout     = flag.String("handshake-timeout", "", "limit of the protocol handshake of proxied connections, e.g. 8s (default: 4s)")
+	connIdleTimeout      = flag.String("conn-idle-timeout", "", "close proxied connections idle for the duration, e.g. 2h for SSH (default: 5m)")
+	uplinkOnlyTimeout    = flag.String("uplink-only-timeout", "", "close proxied connections the duration after the server closed the downlink (default: 1s)")
+	downlinkOnlyTimeout  = flag.String("downlink-only-timeout", "", "close proxied connections the duration after the application closed the uplink (default: 1s)")
+	tcpKeepAlive         = flag.String("tcp-keepalive", "", "idle time and interval of TCP keepalive probes of server connections, e.g. 30s (default: OS settings)")
+	memoryBudgetMiB      = flag.Int("memory-budget-mib", 0, "size buffers and the connection limit to the memory in MiB for low-memory devices, e.g. 64 on a 128 MB router (default: sized for desktop)")
+	goMemLimit           = flag.Bool("go-mem-limit", false, "set Go runtime memory limit (GOMEMLIMIT) to -memory-budget-mib")
+	pipeWorkers          = flag.Int("pipe-workers", 0, "number of workers queueing packets of the TUN device to the TCP/IP stack sharded by connection (default: 1)")
+	pipeQueue            = flag.Int("pipe-queue", 0, "packets queued per pipe worker (default: 256)")
+	pipeOverflow         = flag.String("pipe-overflow", "", "packets of a pipe worker with full queue: drop or block to wait for the worker (default: drop)")
+	udpSessionCache      = flag.String("udp-session-cache", "", "keep the SOCKS association of a closed UDP flow for its source for the duration, e.g. 1m (default: 30s)")
+	udpSpareSessions     = flag.Int("udp-spare-sessions", 0, "SOCKS associations established ahead for new UDP sources, e.g. 4 for DNS-heavy workloads (default: 0)")
+	udpBatch             = flag.Int("udp-batch", 0, "most datagrams of a UDP flow sent by one system call (default: 16)")
+	quic                 = flag.String("quic", "", "QUIC (UDP port 443) mode: relay, block to make browsers use TCP or deprioritize to delay new QUIC flows (default: relay)")
+	quicDelay            = flag.String("quic-delay", "", "delay of new QUIC flows with -quic deprioritize, e.g. 500ms (default: 300ms)")
+	warmupConns          = flag.Int("warmup-conns", 0, "connections to the server kept warm while connected, up to 16, so first flows skip handshakes (default: 0)")
+	warmupTarget         = flag.String("warmup-target", "", "host:port warm connections are opened to through the proxy (default: host of -check-url)")
+	warmupRecycle        = flag.String("warmup-recycle", "", "open a warm connection again after the duration, before it is closed as idle, e.g. 30s (default: 1m)")
+	dscp                 = flag.Int("dscp", 0, "DSCP mark of server connections 0-63, e.g. 46 for VoIP (default: not marked)")
+	dscpClasses          = flag.String("dscp-classes", "", "comma separated inner:outer DSCP pairs carrying application marks over to server connections, e.g. 46:46,34:46 (not with mux)")
+	sniffing             = flag.String("sniffing", "", "comma separated protocols sniffed for destination domains of domain routing rules: http, tls, quic, fakedns (default: disabled)")
+	sniffingRouteOnly    = flag.Bool("sniffing-route-only", false, "use sniffed domains for routing only, connect to the original IPs")
+	onDemand             = flag.Bool("on-demand", false, "set up TUN device and routes in standby, connect to the server when the first packet arrives")
+	reconnectMinBackoff  = flag.String("reconnect-min-backoff", "", "delay of the -on-demand or daemon retry after the first failure, doubled after every failure, e.g. 5s (default: 1s)")
+	reconnectMaxBackoff  = flag.String("reconnect-max-backoff", "", "max delay of -on-demand retries, e.g. 30s (default: 1m)")
+	reconnectFailures    = flag.Int("reconnect-failures", 0, "consecutive -on-demand failures starting the cool-down (default: 5)")
+	reconnectCoolDown    = flag.String("reconnect-cool-down", "", "delay of -on-demand and daemon retries in cool-down until one succeeds, e.g. 15m (default: 5m)")
+	flowLog              = flag.String("flow-log", "", "append closed tunneled connections to the JSON Lines file")
+	capturePackets       = flag.String("capture", "", "append tunneled packets to the pcap-ng file, e.g. for Wireshark")
+	captureFilter        = flag.String("capture-filter", "", "capture packets matching BPF-style expression only, e.g. \"udp and port 53\" (default: all)")
+	captureSnapLen       = flag.Int("capture-snaplen", 0, "truncate captured packets to the length, e.g. 128 for headers only (default: not truncated)")
+	captureMaxMiB        = flag.Int("capture-max-mib", 0, "stop writing -flow-log and -capture files at the size in MiB (default: unlimited)")
+	exitInfoURL          = flag.String("exit-info-url", "", "endpoint reporting exit IP, country and ASN, JSON like ipinfo.io or plain IP (default: "+client.DefaultExitInfoURL+")")
+	controlRoute         = flag.String("control-route", "", "route of control traffic like update checks while connected: tunnel or direct, around the TUN device (default: tunnel)")
+	updateCheck          = flag.String("update-check", "", "interval of daemon checks for a newer release, reported as update_available event, e.g. 24h (default: disabled)")
+	desktopNotify        = flag.Bool("notify", false, "show desktop notifications of daemon connects, disconnects and failures")
+	metricsPush          = flag.String("metrics-push", "", "push daemon metrics to Pushgateway http://host:9091, statsd://host:8125 or dogstatsd://host:8125 (default: disabled)")
+	metricsInterval      = flag.String("metrics-interval", "", "interval of -metrics-push, e.g. 1m (default: 15s)")
+	metricsTags          = flag.String("metrics-tags", "", "comma separated key=value tags of -metrics-push, Pushgateway grouping labels or DogStatsD tags, e.g. host=pi")
+	healthListen         = flag.String("health-listen", "", "address serving /healthz and /readyz endpoints of the tunnel health for orchestrators, e.g. :8080 (default: disabled)")
+	apiListen            = flag.String("api-listen", "", "address serving the daemon control API, e.g. 127.0.0.1:8081, other than loopback requires -api-cert and a token or -api-client-ca (default: disabled)")
+	apiToken             = flag.String("api-token", "", "bearer token of the control API granting control, prefer "+config.EnvAPIToken+" to keep it off the process list")
+	apiReadToken         = flag.String("api-read-token", "", "bearer token of the control API granting read-only access, prefer "+config.EnvAPIReadToken+" to keep it off the process list")
+	apiCert              = flag.String("api-cert", "", "PEM file of the control API server certificate, enables TLS")
+	apiKey               = flag.String("api-key", "", "PEM file of the key of -api-cert")
+	apiClientCA          = flag.String("api-client-ca", "", "PEM file of the CA verifying control API client certificates, \"read-only\" organizational unit grants read-only access")
+)
+
 func main() {
-	// Get connection link from first cmd argument
-	if len(os.Args[1:]) != 1 {
-		fmt.Printf(cmdArgsErr, os.Args[0])
-		os.Exit(0)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var err error
+	switch flag.Arg(0) {
+	case "":
+		if link := os.Getenv(config.EnvLink); link != "" || upstreamSet() {
+			err = connect(link, false)
+			break
+		}
+		fmt.Println("ERROR: no config_link provided")
+		flag.Usage()
+		os.Exit(exitUsage)
+	case "init":
+		err = initCmd(flag.Args()[1:])
+	case "up":
+		err = upCmd(flag.Args()[1:])
+	case "daemon":
+		err = daemonCmd(flag.Args()[1:])
+	case "profile":
+		err = profileCmd(flag.Args()[1:])
+	case "group":
+		err = groupCmd(flag.Args()[1:])
+	case "config":
+		err = configCmd(flag.Args()[1:])
+	case "forward":
+		err = forwardCmd(flag.Args()[1:])
+	case "status":
+		err = statusCmd(flag.Args()[1:])
+	case "stats":
+		err = statsCmd(flag.Args()[1:])
+	case "exec":
+		err = execCmd(flag.Args()[1:])
+	case "cleanup":
+		err = cleanupCmd(flag.Args()[1:])
+	case "uninstall-service":
+		err = uninstallServiceCmd(flag.Args()[1:])
+	case "link":
+		err = linkCmd(flag.Args()[1:])
+	case "verify":
+		err = verifyCmd(flag.Args()[1:])
+	case "qr":
+		err = qrCmd(flag.Args()[1:])
+	case "self-update":
+		err = selfUpdateCmd(flag.Args()[1:])
+	case "version":
+		err = versionCmd(flag.Args()[1:])
+	default:
+		if flag.NArg() != 1 {
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		err = connect(flag.Arg(0), false)
+	}
+	if err != nil {
+		log.Print(err)
+		if hint := client.Hint(err); hint != "" {
+			log.Print("hint: " + hint)
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+func upCmd(args []string) error {
+	fs := flag.NewFlagSet("up", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print generated xray config, TUN device and routes without connecting")
+	_ = fs.Parse(args)
+	link := fs.Arg(0)
+	if fs.NArg() == 0 {
+		link = os.Getenv(config.EnvLink)
+	}
+	if fs.NArg() > 1 || link == "" && !upstreamSet() {
+		return usageError("usage: up [-dry-run] <config_url>")
+	}
+
+	return connect(link, *dryRun)
+}
+
+// connect connects to arg and stays connected until terminated, onConnected callbacks are called once connected.
+func connect(arg string, dryRun bool, onConnected ...func(vpn *client.Client) error) error {
+	var clientLink, profile string
+	if arg != "" {
+		var err error
+		if clientLink, profile, err = resolveLink(arg); err != nil {
+			return withExit(exitConfig, err)
+		}
 	}
-	clientLink := os.Args[1]
 
 	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, os.Interrupt, syscall.SIGTERM)
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelError,
-	}))
+	cfg, err := profileClientConfig(profile, slog.LevelError)
+	if err != nil {
+		return err
+	}
+	vpn, err := client.NewClientWithOpts(cfg)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		plan, err := vpn.Plan(clientLink)
+		if err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
 
-	vpn, err := client.NewClientWithOpts(client.Config{
-		TLSAllowInsecure: false,
-		Logger:           logger,
-	})
+		return nil
+	}
+
+	settings, err := loadSettings(profile)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	var connectedAt atomic.Pointer[time.Time]
+	if settings.HealthListen != "" {
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		go serveHealth(ctx, settings.HealthListen, cfg.Logger, func() (live, ready error) {
+			var at time.Time
+			if p := connectedAt.Load(); p != nil {
+				at = *p
+			}
+			return tunnelHealth(vpn, at)
+		})
 	}
 
 	slog.Info("Connecting to VPN server")
 	err = vpn.Connect(clientLink)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	now := time.Now()
+	connectedAt.Store(&now)
 
 	slog.Info("Connected to VPN server")
+	if url := vpn.PACURL(); url != "" {
+		slog.Info("Serving PAC file", "url", url)
+	}
+	for _, fn := range onConnected {
+		if err = fn(vpn); err != nil {
+			return errors.Join(err, vpn.Disconnect(context.Background()))
+		}
+	}
 	<-sigterm
 	slog.Info("Received term signal, disconnecting...")
 	if err = vpn.Disconnect(context.Background()); err != nil {
 		slog.Warn("Disconnecting VPN failed", "error", err)
-		os.Exit(0)
+		return nil
 	}
 
 	slog.Info("VPN disconnected successfully")
-	os.Exit(0)
+
+	return nil
+}
+
+// clientConfig returns client config from settings of the configuration file, environment and flags,
+// in the order of increasing precedence.
+func clientConfig(defaultLevel slog.Level) (client.Config, error) {
+	return profileClientConfig("", defaultLevel)
+}
+
+// profileClientConfig is clientConfig with the settings overridden by the settings of profile, see config.Profile.
+func profileClientConfig(profile string, defaultLevel slog.Level) (client.Config, error) {
+	settings, err := loadSettings(profile)
+	if err != nil {
+		return client.Config{}, err
+	}
+	clientCfg, err := settings.ClientConfig(defaultLevel)
+	if err != nil {
+		return client.Config{}, withExit(exitConfig, err)
+	}
+	clientCfg.OnEvent = logEvent
+	if path, err := configFilePath(); err == nil {
+		clientCfg.Journal = config.JournalPath(path)
+		clientCfg.AuditLog = config.AuditPath(path)
+	}
+
+	return clientCfg, nil
+}
+
+// loadSettings returns settings of the configuration file, the file profile (if not empty), environment
+// and flags, in the order of increasing precedence.
+func loadSettings(profile string) (config.Settings, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return config.Settings{}, withExit(exitConfig, err)
+	}
+	env, err := config.SettingsFromEnv()
+	if err != nil {
+		return config.Settings{}, withExit(exitConfig, err)
+	}
+	flags := config.Settings{
+		InboundPort:          *inboundPort,
+		InboundAddress:       *inboundAddr,
+		InboundSocket:        *inboundSock,
+		InboundAllow:         config.SplitList(*inboundAllow),
+		InboundMaxConns:      *inboundMaxConns,
+		InboundMaxConnsPerIP: *inboundMaxConnsPerIP,
+		Upstream:             *upstream,
+		SystemProxy:          *systemProxy,
+		PACListen:            *pacListen,
+		TUNAddress:           *tunAddress,
+		MTU:                  *mtu,
+		RouteMetric:          *routeMetric,
+		RouteTable:           *routeTable,
+		RoutePriority:        *routePriority,
+		RouteMark:            *routeMark,
+		RouteVerifyInterval:  *routeVerifyInterval,
+		RouteRepair:          *routeRepair,
+		Netns:                *netnsName,
+		GatewayMode:          *gatewayMode,
+		NetworkManager:       *networkManager,
+		TunnelDNS:            config.SplitList(*tunnelDNS),
+		Gateway:              *gatewayAddr,
+		GatewayWait:          *gatewayWait,
+		NAT64Prefix:          *nat64Prefix,
+		ECH:                  *echConfigList,
+		ECHOuterSNI:          *echOuterSNI,
+		ClientCert:           *clientCert,
+		ClientKey:            *clientKey,
+		ClientKeyPassword:    *clientKeyPassword,
+		DomainStrategy:       *domainStrategy,
+		BootstrapDNS:         config.SplitList(*bootstrapDNS),
+		ObfsPadding:          *obfsPadding,
+		ObfsFragment:         *obfsFragment,
+		ObfsPackets:          *obfsPackets,
+		ObfsJitter:           *obfsJitter,
+		LogLevel:             *logLevel,
+		LogDedup:             *logDedup,
+		LogLevels:            config.SplitList(*logLevels),
+		CheckURL:             *checkURL,
+		CheckStatus:          *checkStatus,
+		CheckTimeout:         *checkTimeout,
+		CheckInterval:        *checkInterval,
+		Watchdog:             *watchdog,
+		ExitInfoURL:          *exitInfoURL,
+		ControlRoute:         *controlRoute,
+		UpdateCheck:          *updateCheck,
+		Notify:               *desktopNotify,
+		MetricsPush:          *metricsPush,
+		MetricsInterval:      *metricsInterval,
+		MetricsTags:          config.SplitList(*metricsTags),
+		HealthListen:         *healthListen,
+		APIListen:            *apiListen,
+		APIToken:             *apiToken,
+		APIReadToken:         *apiReadToken,
+		APICert:              *apiCert,
+		APIKey:               *apiKey,
+		APIClientCA:          *apiClientCA,
+		CaptivePortal:        *captivePortal,
+		CaptivePortalWait:    *captivePortalWait,
+		CaptivePortalURL:     *captivePortalURL,
+		BalancerSticky:       *balancerSticky,
+		TCPIdleTimeout:       *tcpIdleTimeout,
+		UDPIdleTimeout:       *udpIdleTimeout,
+		MaxFlows:             *maxFlows,
+		FlowQueueTimeout:     *flowQueueTimeout,
+		DrainTimeout:         *drainTimeout,
+		ProxyDialTimeout:     *proxyDialTimeout,
+		SOCKSTimeout:         *socksTimeout,
+		HandshakeTimeout:     *handshakeTimeout,
+		ConnIdleTimeout:      *connIdleTimeout,
+		UplinkOnlyTimeout:    *uplinkOnlyTimeout,
+		DownlinkOnlyTimeout:  *downlinkOnlyTimeout,
+		TCPKeepAlive:         *tcpKeepAlive,
+		MemoryBudgetMiB:      *memoryBudgetMiB,
+		GoMemLimit:           *goMemLimit,
+		PipeWorkers:          *pipeWorkers,
+		PipeQueue:            *pipeQueue,
+		PipeOverflow:         *pipeOverflow,
+		UDPSessionCache:      *udpSessionCache,
+		UDPSpareSessions:     *udpSpareSessions,
+		UDPBatch:             *udpBatch,
+		QUIC:                 *quic,
+		QUICDelay:            *quicDelay,
+		WarmupConns:          *warmupConns,
+		WarmupTarget:         *warmupTarget,
+		WarmupRecycle:        *warmupRecycle,
+		DSCP:                 *dscp,
+		DSCPClasses:          config.SplitList(*dscpClasses),
+		Sniffing:             config.SplitList(*sniffing),
+		SniffingRouteOnly:    *sniffingRouteOnly,
+		OnDemand:             *onDemand,
+		ReconnectMinBackoff:  *reconnectMinBackoff,
+		ReconnectMaxBackoff:  *reconnectMaxBackoff,
+		ReconnectFailures:    *reconnectFailures,
+		ReconnectCoolDown:    *reconnectCoolDown,
+		FlowLog:              *flowLog,
+		Capture:              *capturePackets,
+		CaptureFilter:        *captureFilter,
+		CaptureSnapLen:       *captureSnapLen,
+		CaptureMaxMiB:        *captureMaxMiB,
+	}
+
+	return cfg.ProfileSettings(profile).Override(env).Override(flags), nil
+}
+
+// logEvent prints client events, they are meant for the user regardless of the log level.
+// Connection events are shown as desktop notifications too if they are enabled.
+func logEvent(ev client.Event) {
+	args := make([]any, 0, 2*len(ev.Attrs)+2)
+	args = append(args, "event", ev.Type)
+	for k, v := range ev.Attrs {
+		args = append(args, k, v)
+	}
+	slog.Warn(ev.Message, args...)
+	notifyEvent(ev)
+}
+
+// upstreamSet reports whether the external SOCKS5 proxy is configured, no connection link is needed then.
+func upstreamSet() bool {
+	if *upstream != "" || os.Getenv(config.EnvUpstream) != "" {
+		return true
+	}
+	cfg, err := loadConfig()
+
+	return err == nil && cfg.Settings.Upstream != ""
+}
+
+// resolveLink returns connection link for arg: link itself, contents of the file or saved profile link.
+// The profile name is returned too, empty if arg is not a profile.
+func resolveLink(arg string) (link, profile string, err error) {
+	if strings.Contains(arg, "://") {
+		return arg, "", nil
+	}
+	if _, err := os.Stat(arg); err == nil {
+		link, err = readLink(arg)
+		return link, "", err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", "", err
+	}
+	matches := cfg.Match(arg)
+	switch len(matches) {
+	case 0:
+		// Not a profile either, let the client report the invalid link.
+		return arg, "", nil
+	case 1:
+		if matches[0].Name != arg {
+			fmt.Fprintf(os.Stderr, "using profile %q (%s)\n", matches[0].Name, matches[0].Remark())
+		}
+		link, err = matches[0].ConnectLink()
+		return link, matches[0].Name, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%q matches %d profiles, use one of the names:", arg, len(matches))
+	for _, p := range matches {
+		fmt.Fprintf(&b, "\n  %-20s %s", p.Name, p.Remark())
+	}
+
+	return "", "", withExit(exitConfig, errors.New(b.String()))
+}
+
+// readLink returns arg itself if it is a link, or contents of the file if arg is a path to existing file.
+func readLink(arg string) (string, error) {
+	if strings.Contains(arg, "://") {
+		return arg, nil
+	}
+	if _, err := os.Stat(arg); err != nil {
+		return arg, nil
+	}
+
+	b, err := os.ReadFile(arg)
+	if err != nil {
+		return "", fmt.Errorf("read config file: %w", err)
+	}
+
+	return string(b), nil
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/goxray/tun/pkg/metrics"
+)
+
+// metricsPushTimeout limits a metrics push.
+const metricsPushTimeout = 10 * time.Second
+
+// runMetricsPush pushes daemon metrics to the sink of opts every interval until ctx is done. The sink is reached
+// like update checks, see controlDial.
+func (d *daemon) runMetricsPush(ctx context.Context, opts *metrics.Options) {
+	pusher, err := metrics.New(opts, d.controlDial)
+	if err != nil {
+		d.logger.Warn("metrics push disabled", "err", err)
+		return
+	}
+
+	ticker := time.NewTicker(opts.PushInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pushCtx, cancel := context.WithTimeout(ctx, metricsPushTimeout)
+		err := pusher.Push(pushCtx, d.metrics())
+		cancel()
+		if err != nil {
+			d.logger.Warn("metrics push failed", "err", err)
+		}
+	}
+}
+
+// metrics returns the daemon metrics: connection state, traffic totals of the session and flows.
+func (d *daemon) metrics() []metrics.Metric {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var connected, uptime float64
+	read, written := d.state.BytesRead, d.state.BytesWritten
+	if d.link != "" {
+		connected, uptime = 1, time.Since(d.connectedAt).Seconds()
+		read += int64(d.vpn.BytesRead())
+		written += int64(d.vpn.BytesWritten())
+	}
+	flows := d.vpn.FlowStats()
+
+	return []metrics.Metric{
+		{Name: "goxray_connected", Help: "Whether the tunnel is connected.", Type: metrics.Gauge, Value: connected},
+		{Name: "goxray_connection_seconds", Help: "Duration of the current connection.", Type: metrics.Gauge, Value: uptime},
+		{Name: "goxray_bytes_read_total", Help: "Bytes read from the TUN device in the session.", Type: metrics.Counter, Value: float64(read)},
+		{Name: "goxray_bytes_written_total", Help: "Bytes written to the TUN device in the session.", Type: metrics.Counter, Value: float64(written)},
+		{Name: "goxray_flows_active", Help: "Currently tunneled flows.", Type: metrics.Gauge, Value: float64(flows.Active)},
+		{Name: "goxray_flows_rejected_total", Help: "Flows rejected over the flow limit.", Type: metrics.Counter, Value: float64(flows.Rejected)},
+		{Name: "goxray_flows_timed_out_total", Help: "Connections failed by proxy dial or SOCKS5 handshake timeout.", Type: metrics.Counter, Value: float64(flows.TimedOut)},
+		{Name: "goxray_flows_retried_total", Help: "Connection dials retried after the proxy refused or reset them.", Type: metrics.Counter, Value: float64(flows.Retried)},
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/notify"
+)
+
+// notifications queues desktop notifications of the events, nil if they are disabled, see startNotifications.
+var notifications chan notification
+
+// notification is a desktop notification.
+type notification struct {
+	summary, body string
+}
+
+// startNotifications enables desktop notifications of the events, they are shown in order by a goroutine,
+// so the event emitters are not blocked. It must be called before the events are emitted.
+func startNotifications() {
+	notifications = make(chan notification, 16)
+	go func() {
+		reported := false
+		for n := range notifications {
+			if err := notify.Send(n.summary, n.body); err != nil && !reported {
+				slog.Warn("desktop notification failed, further failures are not reported", "err", err)
+				reported = true
+			}
+		}
+	}()
+}
+
+// notifyEvent queues desktop notification of connects, disconnects and failures, other events are skipped.
+// Notifications are dropped if they pile up.
+func notifyEvent(ev client.Event) {
+	if notifications == nil {
+		return
+	}
+	var n notification
+	switch ev.Type {
+	case eventConnected, client.EventOnDemandConnected:
+		n = notification{"VPN connected", ev.Attrs["profile"]}
+	case client.EventDisconnected:
+		if ev.Attrs["reason"] == string(client.DisconnectUser) {
+			return // Switches and shutdown of the daemon.
+		}
+		n = notification{"VPN disconnected", strings.TrimSpace(ev.Attrs["reason"] + " " + ev.Attrs["err"])}
+	case eventScheduledDisconnect:
+		n = notification{"VPN disconnected", ev.Message}
+	case eventConnectFailed, client.EventOnDemandFailed:
+		n = notification{"VPN connection failed", strings.TrimSpace(ev.Attrs["err"] + "\n" + ev.Attrs["hint"])}
+	case client.EventReconnectCoolDown:
+		n = notification{"VPN keeps failing to connect", "retrying in " + ev.Attrs["retry_in"]}
+	case client.EventTunnelPanic:
+		n = notification{"VPN connection crashed", ev.Attrs["err"]}
+	case eventWatchdogRestart:
+		n = notification{"VPN connection stalled, restarting", ev.Attrs["err"]}
+	case client.EventCaptivePortal:
+		n = notification{"Captive portal detected", "log in at " + ev.Attrs["url"]}
+	default:
+		return
+	}
+	if n.body == "" {
+		n.body = ev.Message
+	}
+
+	select {
+	case notifications <- n:
+	default:
+	}
+}
@@ -0,0 +1,271 @@
+// Package api serves the HTTP control API of daemon mode, so a fleet of headless machines running the client can
+// be managed centrally.
+//
+// Requests are authenticated by bearer tokens ("Authorization: Bearer <token>") or TLS client certificates
+// and authorized by role:
+//
+//	GET /v1/status            RoleRead     the connection status
+//	GET /v1/profiles          RoleRead     the profiles of the config file and the active one
+//	PUT /v1/profiles/active   RoleControl  make profile {"name": "..."} active, the daemon connects to it
+//	POST /v1/reconnect        RoleControl  establish the connection again
+//
+// Without credentials the API is read-only and it is served on loopback addresses only.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// readTimeout limits reading requests.
+const readTimeout = 10 * time.Second
+
+// ReadOnlyUnit is the Organizational Unit of client certificates granted RoleRead, other certificates verified
+// by Options.ClientCA are granted RoleControl.
+const ReadOnlyUnit = "read-only"
+
+// Role is the access granted to a request.
+type Role int
+
+const (
+	// RoleNone is not granted access, the request is not authenticated.
+	RoleNone Role = iota
+	// RoleRead reads the status and the profiles.
+	RoleRead
+	// RoleControl switches profiles and reconnects too.
+	RoleControl
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleControl:
+		return "control"
+	default:
+		return "none"
+	}
+}
+
+// Options configure the API server.
+type Options struct {
+	// Addr is the listen address, e.g. "127.0.0.1:8081". Addresses other than loopback require TLS and
+	// credentials.
+	Addr string
+	// Token grants RoleControl.
+	Token string
+	// ReadToken grants RoleRead.
+	ReadToken string
+	// CertFile and KeyFile are PEM files of the server certificate and its key, TLS is enabled with them.
+	CertFile, KeyFile string
+	// ClientCA is PEM file of the CA verifying client certificates (mutual TLS), see ReadOnlyUnit.
+	ClientCA string
+}
+
+// Validate checks that the API is not exposed beyond loopback without TLS and credentials.
+func (o *Options) Validate() error {
+	host, _, err := net.SplitHostPort(o.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid api listen address: %w", err)
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return errors.New("api certificate and key must be set together")
+	}
+	if o.ClientCA != "" && o.CertFile == "" {
+		return errors.New("api client CA requires api certificate")
+	}
+	if o.Token != "" && o.Token == o.ReadToken {
+		return errors.New("api token and read token must differ")
+	}
+	if !loopback(host) && (o.CertFile == "" || o.Token == "" && o.ReadToken == "" && o.ClientCA == "") {
+		return fmt.Errorf("api on non-loopback address %q requires api certificate and a token or client CA", o.Addr)
+	}
+
+	return nil
+}
+
+// loopback reports whether listen host serves loopback only, empty host listens on all addresses.
+func loopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorize returns the role of request r.
+func (o *Options) authorize(r *http.Request) Role {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		switch {
+		case !ok:
+			return RoleNone
+		case o.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(o.Token)) == 1:
+			return RoleControl
+		case o.ReadToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(o.ReadToken)) == 1:
+			return RoleRead
+		default:
+			return RoleNone
+		}
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if slices.Contains(r.TLS.VerifiedChains[0][0].Subject.OrganizationalUnit, ReadOnlyUnit) {
+			return RoleRead
+		}
+		return RoleControl
+	}
+	if o.Token == "" && o.ReadToken == "" && o.ClientCA == "" {
+		return RoleRead // Served on loopback only, see Validate.
+	}
+
+	return RoleNone
+}
+
+// tlsConfig returns TLS configuration of the server, nil if TLS is not enabled.
+func (o *Options) tlsConfig() (*tls.Config, error) {
+	if o.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("api certificate: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if o.ClientCA != "" {
+		b, err := os.ReadFile(o.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("api client CA: %w", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("api client CA %s: no PEM certificates", o.ClientCA)
+		}
+		// Token clients do not have certificates.
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return cfg, nil
+}
+
+// Profile is a profile of the config file.
+type Profile struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+// Daemon is the daemon managed by the API.
+type Daemon interface {
+	// Status returns the connection status, it is encoded as json.
+	Status() any
+	// Profiles returns the profiles of the config file.
+	Profiles() ([]Profile, error)
+	// Activate makes profile name active.
+	Activate(name string) error
+	// Reconnect establishes the connection again, it fails if it is not connected.
+	Reconnect() error
+}
+
+// Handler returns handler of the API endpoints of d authorized with o, requests are logged to logger.
+func Handler(o *Options, d Daemon, logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	handle := func(pattern string, role Role, h func(w http.ResponseWriter, r *http.Request) error) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			granted := o.authorize(r)
+			switch {
+			case granted == RoleNone:
+				w.Header().Set("WWW-Authenticate", `Bearer realm="goxray"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				logger.Warn("api request unauthorized", "remote", r.RemoteAddr, "request", pattern)
+				return
+			case granted < role:
+				http.Error(w, fmt.Sprintf("%s role required", role), http.StatusForbidden)
+				logger.Warn("api request forbidden", "remote", r.RemoteAddr, "request", pattern, "role", granted)
+				return
+			}
+			if role == RoleControl {
+				logger.Info("api request", "remote", r.RemoteAddr, "request", pattern)
+			}
+			if err := h(w, r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		})
+	}
+
+	handle("GET /v1/status", RoleRead, func(w http.ResponseWriter, _ *http.Request) error {
+		return writeJSON(w, d.Status())
+	})
+	handle("GET /v1/profiles", RoleRead, func(w http.ResponseWriter, _ *http.Request) error {
+		profiles, err := d.Profiles()
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, profiles)
+	})
+	handle("PUT /v1/profiles/active", RoleControl, func(w http.ResponseWriter, r *http.Request) error {
+		var p Profile
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&p); err != nil {
+			return fmt.Errorf("invalid profile: %w", err)
+		}
+		if err := d.Activate(p.Name); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+	handle("POST /v1/reconnect", RoleControl, func(w http.ResponseWriter, _ *http.Request) error {
+		if err := d.Reconnect(); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	return mux
+}
+
+// writeJSON writes v as json response.
+func writeJSON(w http.ResponseWriter, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Serve serves the API of d on o.Addr until ctx is done.
+func Serve(ctx context.Context, o *Options, d Daemon, logger *slog.Logger) error {
+	tlsCfg, err := o.tlsConfig()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", o.Addr)
+	if err != nil {
+		return err
+	}
+	if tlsCfg != nil {
+		l = tls.NewListener(l, tlsCfg)
+	}
+	srv := &http.Server{Handler: Handler(o, d, logger), ReadHeaderTimeout: readTimeout}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	logger.Info("serving api", "addr", l.Addr(), "tls", tlsCfg != nil)
+	if err = srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testDaemon is Daemon with profiles "home" and "work".
+type testDaemon struct {
+	active      string
+	reconnected int
+}
+
+func (d *testDaemon) Status() any { return map[string]string{"state": "connected"} }
+
+func (d *testDaemon) Profiles() ([]Profile, error) {
+	return []Profile{{Name: "home", Active: d.active == "home"}, {Name: "work", Active: d.active == "work"}}, nil
+}
+
+func (d *testDaemon) Activate(name string) error {
+	if name != "home" && name != "work" {
+		return errors.New("profile not found")
+	}
+	d.active = name
+
+	return nil
+}
+
+func (d *testDaemon) Reconnect() error {
+	d.reconnected++
+
+	return nil
+}
+
+// request sends request of method to path of h with bearer token (if not empty), it returns the status
+// and the body.
+func request(t *testing.T, h http.Handler, method, path, token, body string) (int, string) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func TestHandler(t *testing.T) {
+	d := &testDaemon{active: "home"}
+	h := Handler(&Options{Addr: "127.0.0.1:0", Token: "control", ReadToken: "read"}, d, slog.New(slog.DiscardHandler))
+
+	code, _ := request(t, h, http.MethodGet, "/v1/status", "", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+	code, _ = request(t, h, http.MethodGet, "/v1/status", "wrong", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+	code, body := request(t, h, http.MethodGet, "/v1/status", "read", "")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"state": "connected"}`, body)
+	code, body = request(t, h, http.MethodGet, "/v1/profiles", "control", "")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `[{"name": "home", "active": true}, {"name": "work", "active": false}]`, body)
+
+	// Read-only role does not control the daemon.
+	code, body = request(t, h, http.MethodPut, "/v1/profiles/active", "read", `{"name": "work"}`)
+	require.Equal(t, http.StatusForbidden, code)
+	require.Equal(t, "control role required", body)
+	code, _ = request(t, h, http.MethodPost, "/v1/reconnect", "read", "")
+	require.Equal(t, http.StatusForbidden, code)
+	require.Equal(t, "home", d.active)
+	require.Zero(t, d.reconnected)
+
+	code, _ = request(t, h, http.MethodPut, "/v1/profiles/active", "control", `{"name": "work"}`)
+	require.Equal(t, http.StatusNoContent, code)
+	require.Equal(t, "work", d.active)
+	code, body = request(t, h, http.MethodPut, "/v1/profiles/active", "control", `{"name": "lab"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, "profile not found", body)
+	code, _ = request(t, h, http.MethodPost, "/v1/reconnect", "control", "")
+	require.Equal(t, http.StatusNoContent, code)
+	require.Equal(t, 1, d.reconnected)
+}
+
+func TestHandler_noCredentials(t *testing.T) {
+	h := Handler(&Options{Addr: "127.0.0.1:0"}, &testDaemon{}, slog.New(slog.DiscardHandler))
+
+	code, _ := request(t, h, http.MethodGet, "/v1/status", "", "")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = request(t, h, http.MethodPost, "/v1/reconnect", "", "")
+	require.Equal(t, http.StatusForbidden, code, "read-only without credentials")
+}
+
+func TestOptions_Validate(t *testing.T) {
+	require.NoError(t, (&Options{Addr: "127.0.0.1:8081"}).Validate())
+	require.NoError(t, (&Options{Addr: "localhost:8081", Token: "secret"}).Validate())
+	require.NoError(t, (&Options{Addr: ":8081", CertFile: "cert.pem", KeyFile: "key.pem", ReadToken: "secret"}).Validate())
+	require.NoError(t, (&Options{Addr: "0.0.0.0:8081", CertFile: "cert.pem", KeyFile: "key.pem", ClientCA: "ca.pem"}).Validate())
+
+	for _, o := range []Options{
+		{Addr: "8081"},
+		{Addr: ":8081"},
+		{Addr: "192.168.1.5:8081", Token: "secret"},
+		{Addr: ":8081", CertFile: "cert.pem", KeyFile: "key.pem"},
+		{Addr: "127.0.0.1:8081", CertFile: "cert.pem"},
+		{Addr: "127.0.0.1:8081", ClientCA: "ca.pem"},
+		{Addr: "127.0.0.1:8081", Token: "secret", ReadToken: "secret"},
+	} {
+		require.Error(t, o.Validate(), o)
+	}
+}
+
+// testCert returns PEM certificate and key signed by parent (self-signed if nil) with organizational unit ou.
+func testCert(t *testing.T, parent *tls.Certificate, ca bool, ou string) (certPEM, keyPEM []byte, cert tls.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test", OrganizationalUnit: []string{ou}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if ca {
+		tmpl.IsCA, tmpl.BasicConstraintsValid, tmpl.KeyUsage = true, true, x509.KeyUsageCertSign
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	return certPEM, keyPEM, cert
+}
+
+func TestServe_mutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	caPEM, _, ca := testCert(t, nil, true, "")
+	srvPEM, srvKey, _ := testCert(t, &ca, false, "")
+	_, _, control := testCert(t, &ca, false, "ops")
+	_, _, readOnly := testCert(t, &ca, false, ReadOnlyUnit)
+	_, _, stranger := testCert(t, nil, false, "")
+	o := &Options{
+		CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), ClientCA: filepath.Join(dir, "ca.pem"),
+	}
+	require.NoError(t, os.WriteFile(o.CertFile, srvPEM, 0o600))
+	require.NoError(t, os.WriteFile(o.KeyFile, srvKey, 0o600))
+	require.NoError(t, os.WriteFile(o.ClientCA, caPEM, 0o600))
+
+	// The port is picked by the test, Serve listens on it.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	o.Addr = l.Addr().String()
+	require.NoError(t, l.Close())
+	require.NoError(t, o.Validate())
+	d := &testDaemon{active: "home"}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Serve(ctx, o, d, slog.New(slog.DiscardHandler)) }()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	post := func(cert *tls.Certificate) int {
+		tlsCfg := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			tlsCfg.Certificates = []tls.Certificate{*cert}
+		}
+		cl := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+		var resp *http.Response
+		require.Eventually(t, func() bool {
+			resp, err = cl.Post("https://"+o.Addr+"/v1/reconnect", "", nil)
+			return err == nil || strings.Contains(err.Error(), "certificate")
+		}, 5*time.Second, 10*time.Millisecond)
+		if err != nil {
+			return 0 // Rejected by the handshake.
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusNoContent, post(&control))
+	require.Equal(t, http.StatusForbidden, post(&readOnly))
+	require.Equal(t, http.StatusUnauthorized, post(nil))
+	require.Zero(t, post(&stranger), "not signed by the client CA")
+	require.Equal(t, 1, d.reconnected)
+
+	cancel()
+	require.NoError(t, <-done)
+}
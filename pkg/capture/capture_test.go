@@ -0,0 +1,122 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ipv4Packet returns IPv4 packet of proto with ports in the first payload bytes.
+func ipv4Packet(proto byte, src, dst string, srcPort, dstPort uint16) []byte {
+	b := make([]byte, 28)
+	b[0] = 0x45
+	b[9] = proto
+	copy(b[12:16], net.ParseIP(src).To4())
+	copy(b[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(b[20:22], srcPort)
+	binary.BigEndian.PutUint16(b[22:24], dstPort)
+
+	return b
+}
+
+func ipv6Packet(proto byte, src, dst string, srcPort, dstPort uint16) []byte {
+	b := make([]byte, 48)
+	b[0] = 0x60
+	b[6] = proto
+	copy(b[8:24], net.ParseIP(src))
+	copy(b[24:40], net.ParseIP(dst))
+	binary.BigEndian.PutUint16(b[40:42], srcPort)
+	binary.BigEndian.PutUint16(b[42:44], dstPort)
+
+	return b
+}
+
+func TestFilter(t *testing.T) {
+	dns := ipv4Packet(protoUDP, "192.18.0.1", "1.1.1.1", 40000, 53)
+	ssh := ipv4Packet(protoTCP, "192.18.0.1", "10.0.0.5", 40001, 22)
+	web6 := ipv6Packet(protoTCP, "fd00::1", "2001:db8::5", 40002, 443)
+	ping := ipv4Packet(protoICMP, "192.18.0.1", "10.0.0.5", 0, 0)
+
+	for expr, want := range map[string][]bool{
+		"":                             {true, true, true, true},
+		"udp":                          {true, false, false, false},
+		"tcp and port 22":              {false, true, false, false},
+		"ip6":                          {false, false, true, false},
+		"icmp":                         {false, false, false, true},
+		"dst port 53":                  {true, false, false, false},
+		"src port 53":                  {false, false, false, false},
+		"host 10.0.0.5":                {false, true, false, true},
+		"dst net 10.0.0.0/8":           {false, true, false, true},
+		"net 2001:db8::/32":            {false, false, true, false},
+		"not port 22":                  {true, false, true, true},
+		"!(udp || icmp) && ip":         {false, true, false, false},
+		"udp or tcp and port 443":      {true, false, true, false},
+		"(udp or tcp) and not port 53": {false, true, true, false},
+	} {
+		f, err := ParseFilter(expr)
+		require.NoError(t, err, expr)
+		require.Equal(t, expr, f.String())
+		for i, pkt := range [][]byte{dns, ssh, web6, ping} {
+			require.Equal(t, want[i], f.Match(pkt), "%q packet %d", expr, i)
+		}
+	}
+
+	f, err := ParseFilter("tcp")
+	require.NoError(t, err)
+	require.False(t, f.Match([]byte{0x45}), "malformed packet")
+
+	for _, expr := range []string{"tcp and", "(udp", "port http", "host example.com", "net 10.0.0.1", "dst tcp", "udp udp"} {
+		_, err := ParseFilter(expr)
+		require.ErrorContains(t, err, "invalid filter", expr)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	filter, err := ParseFilter("udp")
+	require.NoError(t, err)
+	w, err := NewWriter(&buf, WriterOptions{SnapLen: 26, Filter: filter})
+	require.NoError(t, err)
+	require.Equal(t, 28+20, buf.Len(), "section and interface headers")
+	require.EqualValues(t, blockSHB, binary.LittleEndian.Uint32(buf.Bytes()[0:4]))
+	require.EqualValues(t, byteOrder, binary.LittleEndian.Uint32(buf.Bytes()[8:12]))
+	require.EqualValues(t, blockIDB, binary.LittleEndian.Uint32(buf.Bytes()[28:32]))
+	require.EqualValues(t, linkTypeRaw, binary.LittleEndian.Uint16(buf.Bytes()[36:38]))
+	require.EqualValues(t, 26, binary.LittleEndian.Uint32(buf.Bytes()[40:44]))
+
+	at := time.UnixMicro(1700000000123456)
+	pkt := ipv4Packet(protoUDP, "192.18.0.1", "1.1.1.1", 40000, 53)
+	require.NoError(t, w.WritePacket(at, Outbound, pkt))
+	require.NoError(t, w.WritePacket(at, Inbound, ipv4Packet(protoTCP, "1.1.1.1", "192.18.0.1", 443, 40000)))
+
+	epb := buf.Bytes()[48:]
+	n := binary.LittleEndian.Uint32(epb[4:8])
+	require.EqualValues(t, 32+28+12, n, "filtered packet is not written")
+	require.Len(t, epb, int(n))
+	require.EqualValues(t, blockEPB, binary.LittleEndian.Uint32(epb[0:4]))
+	ts := uint64(binary.LittleEndian.Uint32(epb[12:16]))<<32 | uint64(binary.LittleEndian.Uint32(epb[16:20]))
+	require.EqualValues(t, at.UnixMicro(), ts)
+	require.EqualValues(t, 26, binary.LittleEndian.Uint32(epb[20:24]), "captured length")
+	require.EqualValues(t, len(pkt), binary.LittleEndian.Uint32(epb[24:28]), "original length")
+	require.Equal(t, pkt[:26], epb[28:54])
+	require.EqualValues(t, optEPBFlags, binary.LittleEndian.Uint16(epb[56:58]))
+	require.EqualValues(t, Outbound, binary.LittleEndian.Uint32(epb[60:64]))
+	require.Equal(t, n, binary.LittleEndian.Uint32(epb[n-4:]))
+}
+
+func TestWriter_maxBytes(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, WriterOptions{MaxBytes: 48 + 72, Offset: 0})
+	require.NoError(t, err)
+	pkt := ipv4Packet(protoUDP, "192.18.0.1", "1.1.1.1", 40000, 53)
+	require.NoError(t, w.WritePacket(time.Now(), Outbound, pkt))
+	require.ErrorIs(t, w.WritePacket(time.Now(), Outbound, pkt), ErrLimit)
+	require.Equal(t, 48+72, buf.Len())
+
+	_, err = NewWriter(&buf, WriterOptions{MaxBytes: 100, Offset: 100})
+	require.ErrorIs(t, err, ErrLimit, "file is full")
+}
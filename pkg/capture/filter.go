@@ -0,0 +1,272 @@
+// Package capture writes IP packets to pcap-ng files and filters them with BPF-style expressions.
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Filter selects IP packets by a BPF-style (tcpdump) expression, a subset of it is supported:
+//
+//   - protocols: ip, ip6, tcp, udp, icmp (ICMP and ICMPv6);
+//   - host <ip>, net <cidr>, port <port>, optionally prefixed with src or dst direction;
+//   - not (!), and (&&), or (||) operators and parentheses, "not" binds tighter than "and", "and" than "or".
+//
+// E.g. "udp and port 53", "host 10.0.0.5 and not (port 22 or port 443)". Nil Filter matches all packets.
+type Filter struct {
+	expr  string
+	match func(p *packet) bool
+}
+
+// ParseFilter parses filter expression, empty expression returns nil Filter.
+func ParseFilter(expr string) (*Filter, error) {
+	tokens := tokenize(expr)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &filterParser{tokens: tokens}
+	match, err := p.or()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", expr, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q", expr, p.tokens[p.pos])
+	}
+
+	return &Filter{expr: expr, match: match}, nil
+}
+
+// Match reports whether IP packet pkt matches the filter, malformed packets match nil Filter only.
+func (f *Filter) Match(pkt []byte) bool {
+	if f == nil {
+		return true
+	}
+	p, ok := parsePacket(pkt)
+
+	return ok && f.match(p)
+}
+
+// String returns the filter expression.
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+
+	return f.expr
+}
+
+func tokenize(expr string) []string {
+	for _, op := range []string{"(", ")", "!"} {
+		expr = strings.ReplaceAll(expr, op, " "+op+" ")
+	}
+
+	return strings.Fields(expr)
+}
+
+// filterParser is a recursive descent parser of filter expressions.
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+func (p *filterParser) next() (string, error) {
+	tok := p.peek()
+	if tok == "" {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+
+	return tok, nil
+}
+
+func (p *filterParser) or() (func(*packet) bool, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok == "or" || tok == "||"; tok = p.peek() {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(pkt *packet) bool { return l(pkt) || right(pkt) }
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) and() (func(*packet) bool, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok == "and" || tok == "&&"; tok = p.peek() {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(pkt *packet) bool { return l(pkt) && right(pkt) }
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) unary() (func(*packet) bool, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case "not", "!":
+		m, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(pkt *packet) bool { return !m(pkt) }, nil
+	case "(":
+		m, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if tok, err = p.next(); err != nil || tok != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+
+		return m, nil
+	case "ip":
+		return func(pkt *packet) bool { return pkt.version == 4 }, nil
+	case "ip6":
+		return func(pkt *packet) bool { return pkt.version == 6 }, nil
+	case "tcp":
+		return func(pkt *packet) bool { return pkt.proto == protoTCP }, nil
+	case "udp":
+		return func(pkt *packet) bool { return pkt.proto == protoUDP }, nil
+	case "icmp":
+		return func(pkt *packet) bool { return pkt.proto == protoICMP || pkt.proto == protoICMPv6 }, nil
+	}
+
+	src, dst := true, true
+	switch tok {
+	case "src":
+		dst = false
+	case "dst":
+		src = false
+	default:
+		p.pos--
+	}
+
+	return p.primitive(src, dst)
+}
+
+// primitive parses host, net or port primitive matching the source if src and the destination if dst.
+func (p *filterParser) primitive(src, dst bool) (func(*packet) bool, error) {
+	kind, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	arg, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	var match func(ip net.IP, port uint16, hasPort bool) bool
+	switch kind {
+	case "host":
+		host := net.ParseIP(arg)
+		if host == nil {
+			return nil, fmt.Errorf("invalid host %q", arg)
+		}
+		match = func(ip net.IP, _ uint16, _ bool) bool { return host.Equal(ip) }
+	case "net":
+		_, n, err := net.ParseCIDR(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid net %q", arg)
+		}
+		match = func(ip net.IP, _ uint16, _ bool) bool { return n.Contains(ip) }
+	case "port":
+		n, err := strconv.ParseUint(arg, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", arg)
+		}
+		match = func(_ net.IP, port uint16, hasPort bool) bool { return hasPort && port == uint16(n) }
+	default:
+		return nil, fmt.Errorf("unknown primitive %q", kind)
+	}
+
+	return func(pkt *packet) bool {
+		return src && match(pkt.src, pkt.srcPort, pkt.hasPorts) || dst && match(pkt.dst, pkt.dstPort, pkt.hasPorts)
+	}, nil
+}
+
+// IP protocol numbers.
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// packet is the parsed IP header of a packet with ports of TCP and UDP.
+type packet struct {
+	version          int
+	proto            byte
+	src, dst         net.IP
+	srcPort, dstPort uint16
+	hasPorts         bool
+}
+
+// parsePacket parses IPv4 or IPv6 header, IPv6 extension headers are not followed.
+func parsePacket(b []byte) (*packet, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+
+	p := &packet{version: int(b[0] >> 4)}
+	var payload []byte
+	switch p.version {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if len(b) < 20 || ihl < 20 || len(b) < ihl {
+			return nil, false
+		}
+		p.proto = b[9]
+		p.src, p.dst = net.IP(b[12:16]), net.IP(b[16:20])
+		if binary.BigEndian.Uint16(b[6:8])&0x1fff == 0 { // Not a continuing fragment.
+			payload = b[ihl:]
+		}
+	case 6:
+		if len(b) < 40 {
+			return nil, false
+		}
+		p.proto = b[6]
+		p.src, p.dst = net.IP(b[8:24]), net.IP(b[24:40])
+		payload = b[40:]
+	default:
+		return nil, false
+	}
+
+	if (p.proto == protoTCP || p.proto == protoUDP) && len(payload) >= 4 {
+		p.srcPort, p.dstPort = binary.BigEndian.Uint16(payload[0:2]), binary.BigEndian.Uint16(payload[2:4])
+		p.hasPorts = true
+	}
+
+	return p, true
+}
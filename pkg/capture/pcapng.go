@@ -0,0 +1,129 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrLimit is returned by Writer when the size cap is reached, the packet is not written.
+var ErrLimit = errors.New("capture size limit reached")
+
+// Direction of a captured packet relative to the host.
+type Direction uint32
+
+// Packet directions, values of pcap-ng epb_flags.
+const (
+	Inbound  Direction = 1
+	Outbound Direction = 2
+)
+
+// pcap-ng block types and options.
+const (
+	blockSHB      = 0x0a0d0d0a
+	blockIDB      = 0x00000001
+	blockEPB      = 0x00000006
+	byteOrder     = 0x1a2b3c4d
+	linkTypeRaw   = 101 // LINKTYPE_RAW, IPv4 or IPv6 packets without link layer header.
+	optEPBFlags   = 2
+	optEndOfOpt   = 0
+	maxPacketSize = 65535
+)
+
+// WriterOptions configure Writer.
+type WriterOptions struct {
+	// SnapLen truncates packets to the length (default: not truncated).
+	SnapLen int
+	// MaxBytes caps the size of the written data, including the data written before if the file is appended to
+	// (see Offset). Packets over the cap are not written (default: unlimited).
+	MaxBytes int64
+	// Offset is the size of the data written to the output before, counted against MaxBytes.
+	Offset int64
+	// Filter selects written packets (default: all).
+	Filter *Filter
+}
+
+// Writer writes IP packets as pcap-ng section of one raw IP interface, it is safe for concurrent use.
+// Each Writer starts a new section, so it may append to an existing pcap-ng file.
+type Writer struct {
+	w    io.Writer
+	opts WriterOptions
+
+	mu      sync.Mutex
+	written int64
+}
+
+// NewWriter writes pcap-ng section and interface headers to w.
+func NewWriter(w io.Writer, opts WriterOptions) (*Writer, error) {
+	if opts.SnapLen <= 0 || opts.SnapLen > maxPacketSize {
+		opts.SnapLen = maxPacketSize
+	}
+	pw := &Writer{w: w, opts: opts, written: opts.Offset}
+
+	shb := binary.LittleEndian.AppendUint32(nil, byteOrder)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // Major version.
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // Minor version.
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	idb := binary.LittleEndian.AppendUint16(nil, linkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, uint32(opts.SnapLen))
+	if err := pw.write(append(block(blockSHB, shb), block(blockIDB, idb)...)); err != nil {
+		return nil, fmt.Errorf("write pcap-ng header: %w", err)
+	}
+
+	return pw, nil
+}
+
+// WritePacket writes IP packet pkt captured at t if it matches the filter.
+func (w *Writer) WritePacket(t time.Time, dir Direction, pkt []byte) error {
+	if !w.opts.Filter.Match(pkt) {
+		return nil
+	}
+
+	data := pkt[:min(len(pkt), w.opts.SnapLen)]
+	// Interface ID, timestamp in microseconds (default if_tsresol), captured and original length.
+	ts := uint64(t.UnixMicro())
+	body := binary.LittleEndian.AppendUint32(make([]byte, 0, 32+len(data)), 0)
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(pkt)))
+	body = append(body, data...)
+	body = append(body, make([]byte, pad(len(data)))...)
+	body = binary.LittleEndian.AppendUint16(body, optEPBFlags)
+	body = binary.LittleEndian.AppendUint16(body, 4)
+	body = binary.LittleEndian.AppendUint32(body, uint32(dir))
+	body = binary.LittleEndian.AppendUint32(body, optEndOfOpt)
+
+	return w.write(block(blockEPB, body))
+}
+
+func (w *Writer) write(b []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.opts.MaxBytes > 0 && w.written+int64(len(b)) > w.opts.MaxBytes {
+		return ErrLimit
+	}
+	n, err := w.w.Write(b)
+	w.written += int64(n)
+
+	return err
+}
+
+// block returns pcap-ng block of type with body, body length must be a multiple of 4.
+func block(typ uint32, body []byte) []byte {
+	n := uint32(12 + len(body))
+	b := binary.LittleEndian.AppendUint32(make([]byte, 0, n), typ)
+	b = binary.LittleEndian.AppendUint32(b, n)
+	b = append(b, body...)
+
+	return binary.LittleEndian.AppendUint32(b, n)
+}
+
+// pad returns the number of bytes padding n to 32 bits.
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
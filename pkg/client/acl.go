@@ -0,0 +1,233 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+)
+
+// InboundACL restricts access to the inbound proxy, it is meant for the proxy bound to non-loopback
+// address (e.g. 0.0.0.0 to share the connection in LAN). Rules are enforced on accept, before SOCKS handshake.
+//
+// With InboundACL set XRay core (or Engine) listens on a private loopback port and the Client forwards
+// accepted connections to it. TUN traffic uses the private port directly and is not restricted.
+// SOCKS5 UDP ASSOCIATE is not available to the proxy clients.
+type InboundACL struct {
+	// Allow lists source networks allowed to connect, empty list allows any source.
+	// Loopback is not allowed implicitly, add 127.0.0.0/8 for local applications.
+	Allow []*net.IPNet
+	// MaxConns limits the number of concurrent connections (0: unlimited).
+	MaxConns int
+	// MaxConnsPerIP limits the number of concurrent connections from a single source IP (0: unlimited).
+	MaxConnsPerIP int
+}
+
+// Validate checks ACL values.
+func (a *InboundACL) Validate() error {
+	if a.MaxConns < 0 || a.MaxConnsPerIP < 0 {
+		return errors.New("connection limits must not be negative")
+	}
+	for _, n := range a.Allow {
+		if n == nil {
+			return errors.New("allow list contains nil network")
+		}
+	}
+
+	return nil
+}
+
+// allowed reports whether source ip is in the allow list.
+func (a *InboundACL) allowed(ip net.IP) bool {
+	if len(a.Allow) == 0 {
+		return true
+	}
+	for _, n := range a.Allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// aclListener accepts only connections permitted by InboundACL, others are closed right away.
+type aclListener struct {
+	net.Listener
+	acl *InboundACL
+	log *slog.Logger
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func newACLListener(ln net.Listener, acl *InboundACL, log *slog.Logger) *aclListener {
+	return &aclListener{Listener: ln, acl: acl, log: log, perIP: map[string]int{}}
+}
+
+func (l *aclListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(conn)
+		if reason := l.admit(ip); reason != "" {
+			l.log.Warn("inbound connection rejected", "src", conn.RemoteAddr(), "reason", reason)
+			_ = conn.Close()
+			continue
+		}
+
+		return &aclConn{Conn: conn, release: func() { l.release(ip) }}, nil
+	}
+}
+
+// admit reserves connection slot for ip, returns rejection reason if the connection is not allowed.
+func (l *aclListener) admit(ip net.IP) string {
+	if ip == nil || !l.acl.allowed(ip) {
+		return "source not allowed"
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.acl.MaxConns > 0 && l.total >= l.acl.MaxConns {
+		return "connection limit reached"
+	}
+	if l.acl.MaxConnsPerIP > 0 && l.perIP[ip.String()] >= l.acl.MaxConnsPerIP {
+		return "connection limit per ip reached"
+	}
+	l.total++
+	l.perIP[ip.String()]++
+
+	return ""
+}
+
+func (l *aclListener) release(ip net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.perIP[ip.String()]--; l.perIP[ip.String()] <= 0 {
+		delete(l.perIP, ip.String())
+	}
+}
+
+// aclConn releases its connection slot on Close.
+type aclConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *aclConn) Close() error {
+	c.once.Do(c.release)
+
+	return c.Conn.Close()
+}
+
+func remoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+
+	return nil
+}
+
+// aclInbound serves the public inbound address with InboundACL and forwards accepted
+// connections to the private inbound of the wrapped XRay core instance or Engine.
+type aclInbound struct {
+	inst   xrayproto.Instance
+	listen *Proxy
+	target *Proxy
+	acl    *InboundACL
+	log    *slog.Logger
+
+	ln    net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func newACLInbound(inst xrayproto.Instance, listen, target *Proxy, acl *InboundACL, log *slog.Logger) *aclInbound {
+	return &aclInbound{inst: inst, listen: listen, target: target, acl: acl, log: log}
+}
+
+// Start starts the wrapped instance and listens on the public address.
+func (a *aclInbound) Start() error {
+	if err := a.inst.Start(); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", a.listen.String())
+	if err != nil {
+		return errors.Join(fmt.Errorf("listen inbound: %w", err), a.inst.Close())
+	}
+	a.ln = newACLListener(ln, a.acl, a.log)
+	a.conns = map[net.Conn]struct{}{}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.serve()
+	}()
+
+	return nil
+}
+
+func (a *aclInbound) serve() {
+	for {
+		conn, err := a.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				a.log.Error("inbound accept failed", "err", err)
+			}
+			return
+		}
+
+		a.mu.Lock()
+		a.conns[conn] = struct{}{}
+		a.mu.Unlock()
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			defer func() {
+				a.mu.Lock()
+				delete(a.conns, conn)
+				a.mu.Unlock()
+			}()
+
+			a.forward(conn)
+		}()
+	}
+}
+
+func (a *aclInbound) forward(conn net.Conn) {
+	remote, err := net.Dial(a.target.Network(), a.target.String())
+	if err != nil {
+		a.log.Error("inbound forward failed", "err", err)
+		_ = conn.Close()
+		return
+	}
+
+	relayConns(conn, remote, 0)
+}
+
+// Close stops the public listener, closes forwarded connections and the wrapped instance.
+func (a *aclInbound) Close() error {
+	var err error
+	if a.ln != nil {
+		err = a.ln.Close()
+		a.mu.Lock()
+		for conn := range a.conns {
+			_ = conn.Close()
+		}
+		a.mu.Unlock()
+		a.wg.Wait()
+		a.ln = nil
+	}
+
+	return errors.Join(err, a.inst.Close())
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInboundACL_Validate(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	acl := &InboundACL{Allow: []*net.IPNet{lan}}
+	require.NoError(t, acl.Validate())
+	require.True(t, acl.allowed(net.IPv4(192, 168, 1, 20)))
+	require.False(t, acl.allowed(net.IPv4(127, 0, 0, 1)))
+	require.True(t, (&InboundACL{}).allowed(net.IPv4(10, 0, 0, 1)))
+
+	require.Error(t, (&InboundACL{MaxConns: -1}).Validate())
+	require.Error(t, (&InboundACL{Allow: []*net.IPNet{nil}}).Validate())
+}
+
+func TestACLListener(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	ln := startTestACLListener(t, &InboundACL{Allow: []*net.IPNet{lan}})
+	accepted := acceptTestConns(ln)
+
+	conn := dialTestACL(t, ln)
+	requireTestConnClosed(t, conn)
+	select {
+	case <-accepted:
+		t.Fatal("connection from not allowed source accepted")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ln = startTestACLListener(t, &InboundACL{MaxConns: 1})
+	accepted = acceptTestConns(ln)
+
+	dialTestACL(t, ln)
+	first := <-accepted
+	requireTestConnClosed(t, dialTestACL(t, ln))
+
+	require.NoError(t, first.Close())
+	_ = first.Close() // Double close must release the slot once.
+	dialTestACL(t, ln)
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not accepted after slot released")
+	}
+	requireTestConnClosed(t, dialTestACL(t, ln))
+}
+
+func TestACLInbound(t *testing.T) {
+	listen := &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()}
+	echo, err := net.ResolveTCPAddr("tcp", startTestEchoServer(t))
+	require.NoError(t, err)
+	target := &Proxy{IP: echo.IP, Port: echo.Port}
+
+	in := newACLInbound(&testInstance{}, listen, target, &InboundACL{MaxConnsPerIP: 2}, slog.New(slog.DiscardHandler))
+	require.NoError(t, in.Start())
+
+	conn, err := net.Dial("tcp", listen.String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+
+	require.NoError(t, in.Close())
+	requireTestConnClosed(t, conn)
+	require.True(t, in.inst.(*testInstance).closed)
+}
+
+func TestClient_createProxy_InboundACL(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.InboundACL = &InboundACL{MaxConns: 10}
+
+	inst, _, err := cl.createProxy("trojan://pass@127.0.0.12:443")
+	require.NoError(t, err)
+	require.IsType(t, &aclInbound{}, inst)
+	require.NotEqual(t, cl.cfg.InboundProxy.Port, cl.aclTarget.Port)
+	require.Equal(t, cl.aclTarget.Port, int(cl.xJSON.InboundConfigs[0].PortList.Range[0].From))
+
+	cl.cfg.InboundProxy = &Proxy{Path: "/run/goxray.sock"}
+	_, _, err = cl.createProxy("trojan://pass@127.0.0.12:443")
+	require.ErrorContains(t, err, "not supported for unix socket")
+}
+
+type testInstance struct{ closed bool }
+
+func (i *testInstance) Start() error { return nil }
+
+func (i *testInstance) Close() error {
+	i.closed = true
+	return nil
+}
+
+func startTestACLListener(t *testing.T, acl *InboundACL) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	return newACLListener(ln, acl, slog.New(slog.DiscardHandler))
+}
+
+func acceptTestConns(ln net.Listener) chan net.Conn {
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	return accepted
+}
+
+func dialTestACL(t *testing.T, ln net.Listener) net.Conn {
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func requireTestConnClosed(t *testing.T, conn net.Conn) {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err := conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
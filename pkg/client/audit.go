@@ -0,0 +1,143 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goxray/core/network/route"
+)
+
+// Actions of AuditRecord.
+const (
+	AuditTUNCreate          = "tun_create"
+	AuditTUNDelete          = "tun_delete"
+	AuditTUNHandover        = "tun_handover" // TUN device kept for another process, see Client.Handover.
+	AuditTUNAdopt           = "tun_adopt"    // TUN device of another process, see Client.Adopt.
+	AuditRouteAdd           = "route_add"
+	AuditRouteDelete        = "route_delete"
+	AuditRuleAdd            = "rule_add"    // Policy routing rule of Config.RouteIsolation.
+	AuditRuleDelete         = "rule_delete" // Policy routing rule of Config.RouteIsolation.
+	AuditDeviceRegister     = "device_register"
+	AuditDNSSet             = "dns_set"
+	AuditSystemProxySet     = "system_proxy_set"
+	AuditSystemProxyRestore = "system_proxy_restore"
+	AuditForwardingEnable   = "ip_forwarding_enable"
+	AuditForwardingRestore  = "ip_forwarding_restore"
+)
+
+// AuditRecord is a line of the audit log of system changes, see Config.AuditLog.
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	PID    int       `json:"pid"`
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"` // The changed object, e.g. "0.0.0.0/1 dev tun0".
+	OK     bool      `json:"ok"`
+	Error  string    `json:"error,omitempty"` // Why the change failed.
+}
+
+// auditLog appends AuditRecord json lines to the file, it is never truncated by the client.
+type auditLog struct {
+	path   string
+	logger *slog.Logger
+	mu     sync.Mutex
+}
+
+// record appends record of action on target with its outcome err. Like journal it is best effort,
+// failures to write are logged.
+func (a *auditLog) record(action, target string, err error) {
+	if a == nil {
+		return
+	}
+
+	rec := AuditRecord{Time: time.Now(), PID: os.Getpid(), Action: action, Target: target, OK: err == nil}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err = appendLine(a.path, b); err != nil {
+		a.logger.Warn("audit log not written", "path", a.path, "action", action, "target", target, "err", err)
+	}
+}
+
+// appendLine appends line b to the file at path, it is opened for every line so it can be rotated.
+func appendLine(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+
+	return errors.Join(err, f.Close())
+}
+
+// device returns TUN device recording its removal.
+func (a *auditLog) device(tunnel io.ReadWriteCloser, target string) io.ReadWriteCloser {
+	if a == nil {
+		return tunnel
+	}
+
+	return &auditDevice{ReadWriteCloser: tunnel, a: a, target: target}
+}
+
+// auditDevice is TUN device recorded by auditLog.
+type auditDevice struct {
+	io.ReadWriteCloser
+	a      *auditLog
+	target string
+}
+
+func (d *auditDevice) Close() error {
+	err := d.ReadWriteCloser.Close()
+	d.a.record(AuditTUNDelete, d.target, err)
+
+	return err
+}
+
+// auditRoutes is RouteTable recording every route change to auditLog, routes are changed one at a time
+// so the outcome of each is known.
+type auditRoutes struct {
+	RouteTable
+	a *auditLog
+}
+
+func (r auditRoutes) Add(options route.Opts) error {
+	return r.apply(options, false)
+}
+
+func (r auditRoutes) Delete(options route.Opts) error {
+	return r.apply(options, true)
+}
+
+func (r auditRoutes) apply(options route.Opts, del bool) error {
+	op, action := r.RouteTable.Add, AuditRouteAdd
+	if del {
+		op, action = r.RouteTable.Delete, AuditRouteDelete
+	}
+	for _, addr := range options.Routes {
+		single := options
+		single.Routes = []*route.Addr{addr}
+		err := op(single)
+		_, target, _ := strings.Cut(routeOpsOf(single, false)[0].String(), " ") // Without "add".
+		r.a.record(action, target, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+// readAudit returns records of audit log at path.
+func readAudit(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		require.Equal(t, os.Getpid(), rec.PID)
+		require.False(t, rec.Time.IsZero())
+		records = append(records, rec)
+	}
+
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "tun.audit.log")
+	a := &auditLog{path: path}
+	routes := auditRoutes{RouteTable: &MemoryRouteTable{}, a: a}
+
+	require.NoError(t, routes.Add(route.Opts{IfName: "tun0", Routes: DefaultRoutesToTUN}))
+	gw := route.Opts{Gateway: []byte{192, 168, 1, 1}, Routes: []*route.Addr{hostRoute([]byte{203, 0, 113, 5})}}
+	require.Error(t, routes.Delete(gw))
+	dev := a.device(newMemTUN(), "tun0 192.18.0.1/32")
+	require.NoError(t, dev.Close())
+	a.record(AuditRuleAdd, RouteRule{Priority: 1000, Table: 100, Mark: 100}.String(), nil)
+
+	records := readAudit(t, path)
+	for i := range records {
+		records[i].Time, records[i].PID = records[0].Time, 0
+	}
+	at := records[0].Time
+	require.Equal(t, []AuditRecord{
+		{Time: at, Action: AuditRouteAdd, Target: "0.0.0.0/1 dev tun0", OK: true},
+		{Time: at, Action: AuditRouteAdd, Target: "128.0.0.0/1 dev tun0", OK: true},
+		{Time: at, Action: AuditRouteDelete, Target: "203.0.113.5/32 via 192.168.1.1", Error: "route add 203.0.113.5/32 via 192.168.1.1: no such route"},
+		{Time: at, Action: AuditTUNDelete, Target: "tun0 192.18.0.1/32", OK: true},
+		{Time: at, Action: AuditRuleAdd, Target: "priority 1000 fwmark 100 lookup 100", OK: true},
+	}, records)
+
+	// Records are appended by later runs.
+	(&auditLog{path: path}).record(AuditDNSSet, "/etc/netns/goxray/resolv.conf", nil)
+	require.Len(t, readAudit(t, path), 6)
+
+	var nilLog *auditLog
+	nilLog.record(AuditTUNCreate, "tun0", nil)
+	require.Equal(t, "lookup 100", RouteRule{Table: 100}.String())
+	require.Equal(t, "from 10.0.0.0/8 lookup 7", RouteRule{Table: 7, From: &net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}.String())
+}
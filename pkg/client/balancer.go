@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+const (
+	// DefaultBalancerSticky is how long flows to a destination stay on its server by default, see BalancerOptions.
+	DefaultBalancerSticky = 10 * time.Minute
+	// maxBalancerSticky limits BalancerOptions.Sticky.
+	maxBalancerSticky = 24 * time.Hour
+	// maxBalancerPins limits destinations pinned to servers, expired pins are dropped to make room.
+	maxBalancerPins = 16384
+)
+
+// BalancerOptions configure spreading of TUN flows over the servers of Client.ConnectBalanced.
+//
+// New flows are assigned to the servers in turn, but flows to a destination IP seen recently go to the server
+// of the previous flows, so sites tying sessions to the client IP (banking, streaming) see a single exit IP.
+type BalancerOptions struct {
+	// Sticky pins a destination to its server until no new flow is made to it for Sticky, up to 24h
+	// (default: DefaultBalancerSticky). Negative disables pinning, every new flow goes to the next server.
+	Sticky time.Duration
+}
+
+// Validate checks options values.
+func (o *BalancerOptions) Validate() error {
+	if o.Sticky > maxBalancerSticky {
+		return fmt.Errorf("sticky must be at most %s", maxBalancerSticky)
+	}
+
+	return nil
+}
+
+func (o *BalancerOptions) sticky() time.Duration {
+	if o == nil || o.Sticky == 0 {
+		return DefaultBalancerSticky
+	}
+
+	return max(o.Sticky, 0)
+}
+
+// ConnectBalanced connects like Connect to the first of links and spreads TUN flows over the servers of all
+// links, see Config.Balancer. The servers must be served by XRay core, the first one carries the inbound proxy
+// traffic and Routing rules sending to the proxy. Connections to the other servers bypass TUN device via the
+// gateway interface.
+func (c *Client) ConnectBalanced(links []string) error {
+	if len(links) == 0 {
+		return fmt.Errorf("%w: balancer: no links", ErrInvalidConfig)
+	}
+	c.balanced = links[1:]
+	defer func() { c.balanced = nil }()
+
+	return c.Connect(links[0])
+}
+
+// balancerTagPrefix is the prefix of XRay config tags created for balanced servers.
+const balancerTagPrefix = "balancer-"
+
+// addBalancer adds outbounds of the balanced links into XRay config, each gets a loopback socks inbound routed
+// to it like QoS classes. It returns socks5 addresses of the servers, empty for the first one served by the
+// Client inbound, nil if there are no balanced links.
+func (c *Client) addBalancer(cfg *conf.Config, links []string) ([]string, error) {
+	if len(links) == 0 {
+		return nil, nil
+	}
+	switch {
+	case c.cfg.Dialer != nil || c.xTLS != nil:
+		return nil, errors.New("not supported with Dialer, ECH or client certificate")
+	case len(c.qosClasses) > 0:
+		return nil, errors.New("not supported with dscp classes")
+	case c.cfg.InboundProxy.Path != "":
+		return nil, errors.New("not supported for unix socket inbound")
+	case c.cfg.InboundACL != nil: // Server inbounds are loopback TCP ports any local process could use.
+		return nil, errors.New("not supported with inbound acl")
+	}
+	iface, err := c.gatewayInterface()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.RouterConfig == nil {
+		cfg.RouterConfig = &conf.RouterConfig{}
+	}
+	servers := []string{""}
+	for i, link := range links {
+		out, err := c.balancerOutbound(link)
+		if err != nil {
+			return nil, fmt.Errorf("link %d: %w", i+1, err)
+		}
+		tag := balancerTagPrefix + strconv.Itoa(i+1)
+		out.Tag = tag
+		// Bound to the gateway interface, otherwise connections to the server would be routed back to TUN.
+		if out.StreamSetting == nil {
+			out.StreamSetting = &conf.StreamConfig{}
+		}
+		if out.StreamSetting.SocketSettings == nil {
+			out.StreamSetting.SocketSettings = &conf.SocketConfig{}
+		}
+		out.StreamSetting.SocketSettings.Interface = iface
+		cfg.OutboundConfigs = append(cfg.OutboundConfigs, *out)
+
+		port := getFreePort()
+		var inbound conf.InboundDetourConfig
+		b := fmt.Sprintf(`{"tag":%q,"listen":"127.0.0.1","port":%d,"protocol":"socks","settings":{"udp":true}}`, tag, port)
+		if err = json.Unmarshal([]byte(b), &inbound); err != nil {
+			return nil, fmt.Errorf("link %d: %w", i+1, err)
+		}
+		cfg.InboundConfigs = append(cfg.InboundConfigs, inbound)
+
+		rule, err := json.Marshal(map[string]any{"type": "field", "inboundTag": []string{tag}, "outboundTag": tag})
+		if err != nil {
+			return nil, fmt.Errorf("link %d: %w", i+1, err)
+		}
+		cfg.RouterConfig.RuleList = append(cfg.RouterConfig.RuleList, rule)
+		servers = append(servers, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	}
+
+	return servers, nil
+}
+
+// balancerOutbound builds XRay outbound of balanced link, the server host is resolved now, before TUN device
+// is set up.
+func (c *Client) balancerOutbound(link string) (*conf.OutboundDetourConfig, error) {
+	link, err := normalizeTrojanGoLink(link)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := lookupEngine(u.Scheme); ok || engineOnlyLinks[u.Scheme] != nil {
+		return nil, fmt.Errorf("not supported for %s", u.Scheme)
+	}
+
+	protocol, err := xray.NewXrayService(true, c.cfg.TLSAllowInsecure).CreateProtocol(link)
+	if err != nil {
+		return nil, fmt.Errorf("protocol create: %w", err)
+	}
+	if err = protocol.Parse(); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	out, err := protocol.(xray.Protocol).BuildOutboundDetourConfig(c.cfg.TLSAllowInsecure)
+	if err != nil {
+		return nil, fmt.Errorf("build outbound: %w", err)
+	}
+	if err = c.fixOutbound(protocol.(xray.Protocol), out); err != nil {
+		return nil, fmt.Errorf("build outbound: %w", err)
+	}
+	if out.MuxSettings, err = linkMux(u.Query()); err != nil {
+		return nil, fmt.Errorf("build outbound: %w", err)
+	}
+
+	general := protocol.ConvertToGeneralConfig()
+	host := general.Address
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), happyEyeballsTimeout)
+	defer cancel()
+	ip, err := c.lookupServer(ctx, host, general.Port)
+	if err != nil {
+		return nil, fmt.Errorf("server address not resolvable: %w", err)
+	}
+	pinned := &conf.Config{OutboundConfigs: []conf.OutboundDetourConfig{*out}}
+	if err = useServerIP(pinned, host, ip); err != nil {
+		return nil, fmt.Errorf("server ip: %w", err)
+	}
+
+	return &pinned.OutboundConfigs[0], nil
+}
+
+// balancerTable assigns new flows to the servers of the connection by destination, see BalancerOptions.
+type balancerTable struct {
+	mu      sync.Mutex
+	servers []string // Socks5 addresses of the servers, empty for the Client inbound.
+	sticky  time.Duration
+	next    int
+	pins    map[netip.Addr]balancerPin
+}
+
+// balancerPin is the server of a destination and the time of the last flow to it.
+type balancerPin struct {
+	server int
+	at     time.Time
+}
+
+func newBalancerTable() *balancerTable {
+	return &balancerTable{pins: make(map[netip.Addr]balancerPin)}
+}
+
+// setServers sets server addresses of the next connection, nil disables balancing.
+func (b *balancerTable) setServers(servers []string, sticky time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.servers, b.sticky, b.next = servers, sticky, 0
+	clear(b.pins)
+}
+
+func (b *balancerTable) enabled() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.servers) > 1
+}
+
+// pick returns socks5 address of the server of new flow to dst, false if the flow goes via the Client inbound.
+func (b *balancerTable) pick(dst net.Addr) (string, bool) {
+	return b.pickAt(dst, time.Now())
+}
+
+func (b *balancerTable) pickAt(dst net.Addr, now time.Time) (string, bool) {
+	if b == nil {
+		return "", false
+	}
+	ip := addrPort(dst).Addr()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.servers) < 2 {
+		return "", false
+	}
+	pin, ok := b.pins[ip]
+	if !ok || b.sticky == 0 || now.Sub(pin.at) > b.sticky {
+		pin.server = b.next
+		b.next = (b.next + 1) % len(b.servers)
+	}
+	if b.sticky > 0 {
+		if !ok && len(b.pins) >= maxBalancerPins {
+			maps.DeleteFunc(b.pins, func(_ netip.Addr, p balancerPin) bool { return now.Sub(p.at) > b.sticky })
+		}
+		if ok || len(b.pins) < maxBalancerPins {
+			pin.at = now
+			b.pins[ip] = pin
+		}
+	}
+	addr := b.servers[pin.server]
+
+	return addr, addr != ""
+}
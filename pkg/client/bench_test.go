@@ -0,0 +1,276 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Benchmarks of the packet path run the real Client with the loopback harness, see newLoopbackClient.
+// Run them with "make bench" and compare runs with benchstat (golang.org/x/perf/cmd/benchstat).
+
+// benchPayload is the payload of generated TCP segments, it fits the default MTU.
+const benchPayload = 1400
+
+// benchTUN is TUN device of benchmarks generating packets of the app without allocations: queued packets are
+// read first, then the data segments of stream are generated within the TCP window of the Client.
+// Packets written by the Client are parsed in place, SYN-ACKs are sent to synAcks.
+type benchTUN struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  [][]byte
+	stream *tcpSegment // Next data segment, nil if there is no stream.
+	left   int         // Data segments left to generate.
+	acked  uint32      // Highest acknowledged sequence number of the stream.
+	window uint32
+	closed bool
+
+	synAcks chan benchSynAck
+}
+
+// benchSynAck is SYN-ACK of the Client to the app port.
+type benchSynAck struct {
+	port   uint16
+	seq    uint32
+	window uint16
+}
+
+func newBenchTUN() *benchTUN {
+	t := &benchTUN{synAcks: make(chan benchSynAck, 1)}
+	t.cond = sync.NewCond(&t.mu)
+
+	return t
+}
+
+// send queues packets of the app.
+func (t *benchTUN) send(pkts ...[]byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queue = append(t.queue, pkts...)
+	t.cond.Broadcast()
+}
+
+// startStream generates n data segments following seg within the initial window.
+func (t *benchTUN) startStream(seg tcpSegment, n int, window uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seg.flags, seg.payload = tcpACK|tcpPSH, make([]byte, benchPayload)
+	t.stream, t.left, t.acked, t.window = &seg, n, seg.seq, uint32(window)
+	t.cond.Broadcast()
+}
+
+func (t *benchTUN) Read(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for {
+		switch {
+		case len(t.queue) > 0:
+			n := copy(p, t.queue[0])
+			t.queue = t.queue[1:]
+			return n, nil
+		case t.closed:
+			return 0, io.EOF
+		case t.left > 0 && t.stream.seq-t.acked+benchPayload <= t.window:
+			n := t.stream.marshalTo(p)
+			t.stream.seq += benchPayload
+			t.left--
+			return n, nil
+		}
+		t.cond.Wait()
+	}
+}
+
+func (t *benchTUN) Write(p []byte) (int, error) {
+	seg, ok := parseTCPSegment(p)
+	if !ok {
+		return len(p), nil
+	}
+	window := binary.BigEndian.Uint16(p[int(p[0]&0x0f)*4+14:])
+	if seg.flags&(tcpSYN|tcpACK) == tcpSYN|tcpACK {
+		t.synAcks <- benchSynAck{port: seg.dstPort, seq: seg.seq, window: window}
+		return len(p), nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stream != nil && seg.dstPort == t.stream.srcPort && seg.flags&tcpACK != 0 && int32(seg.ack-t.acked) >= 0 {
+		t.acked, t.window = seg.ack, uint32(window)
+		t.cond.Broadcast()
+	}
+
+	return len(p), nil
+}
+
+func (t *benchTUN) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	t.cond.Broadcast()
+
+	return nil
+}
+
+// newBenchClient returns the loopback Client connected through benchTUN to the sink engine.
+func newBenchClient(b *testing.B) (*Client, *benchTUN, *loopbackEngine) {
+	b.Helper()
+
+	c, _, _, engine := newLoopbackClient(b)
+	tun := newBenchTUN()
+	c.openTUN = func() (io.ReadWriteCloser, error) { return tun, nil }
+	require.NoError(b, c.Connect(loopbackScheme+"://sink"))
+	b.Cleanup(func() { _ = c.Disconnect(context.Background()) })
+
+	return c, tun, engine()
+}
+
+// handshake opens TCP connection of the app from port, it returns the segment following the handshake and
+// the window of the Client.
+func (t *benchTUN) handshake(b *testing.B, port uint16) (tcpSegment, uint16) {
+	b.Helper()
+
+	app := tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: port, dstPort: 80, seq: 1000}
+	app.flags = tcpSYN
+	t.send(app.marshal())
+	var synAck benchSynAck
+	select {
+	case synAck = <-t.synAcks:
+		require.Equal(b, port, synAck.port)
+		app.seq, app.ack = app.seq+1, synAck.seq+1
+	case <-time.After(5 * time.Second):
+		b.Fatal("no SYN-ACK from the client")
+	}
+	app.flags = tcpACK
+	t.send(app.marshal())
+
+	return app, synAck.window
+}
+
+// benchPoll is the poll interval of benchmark conditions. Polling must sleep, busy loops delay the network
+// poller and so the SOCKS connections of the pipe.
+const benchPoll = 20 * time.Microsecond
+
+// waitFlows waits until the Client has n TCP flows.
+func waitFlows(b *testing.B, c *Client, n int) {
+	b.Helper()
+	for deadline := time.Now().Add(5 * time.Second); c.flows.count("tcp") != n; time.Sleep(benchPoll) {
+		if time.Now().After(deadline) {
+			b.Fatalf("%d flows, want %d", c.flows.count("tcp"), n)
+		}
+	}
+}
+
+// BenchmarkPipe_TCPUpload measures throughput of TUN read to SOCKS write of a TCP connection, an op is
+// a segment of benchPayload bytes.
+func BenchmarkPipe_TCPUpload(b *testing.B) {
+	c, tun, engine := newBenchClient(b)
+	app, window := tun.handshake(b, 40000)
+	waitFlows(b, c, 1)
+
+	b.SetBytes(benchPayload)
+	b.ReportAllocs()
+	b.ResetTimer()
+	tun.startStream(app, b.N, window)
+	want := int64(b.N) * benchPayload
+	for deadline := time.Now().Add(time.Minute); engine.received.Load() < want; time.Sleep(benchPoll) {
+		if time.Now().After(deadline) {
+			b.Fatalf("sink received %d bytes, want %d", engine.received.Load(), want)
+		}
+	}
+	b.StopTimer()
+	require.Equal(b, want, engine.received.Load())
+}
+
+// BenchmarkPipe_FlowSetup measures TCP flow setup of the app: handshake, SOCKS connect of the pipe and
+// teardown by reset.
+func BenchmarkPipe_FlowSetup(b *testing.B) {
+	c, tun, _ := newBenchClient(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		app, _ := tun.handshake(b, uint16(10000+i%50000))
+		waitFlows(b, c, 1)
+		app.flags = tcpRST
+		tun.send(app.marshal())
+		waitFlows(b, c, 0)
+	}
+}
+
+// BenchmarkTCPSegment_marshalTo measures the packet generator, it is the baseline of the pipe benchmarks.
+func BenchmarkTCPSegment_marshalTo(b *testing.B) {
+	seg := tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: 40000, dstPort: 80,
+		flags: tcpACK | tcpPSH, payload: make([]byte, benchPayload)}
+	pkt := make([]byte, tunMTU)
+
+	b.SetBytes(benchPayload)
+	b.ReportAllocs()
+	for range b.N {
+		seg.marshalTo(pkt)
+		seg.seq += benchPayload
+	}
+}
+
+// BenchmarkReaderMetrics measures metrics of the tunnel counting every packet read and written.
+func BenchmarkReaderMetrics(b *testing.B) {
+	m := newReaderMetrics(nopRWC{})
+	pkt := make([]byte, benchPayload)
+
+	b.SetBytes(2 * benchPayload)
+	b.ReportAllocs()
+	for range b.N {
+		_, _ = m.Read(pkt)
+		_, _ = m.Write(pkt)
+	}
+}
+
+// BenchmarkFlowTable measures flow table bookkeeping of a flow: reserve, add and remove.
+func BenchmarkFlowTable(b *testing.B) {
+	flows := newFlowTable()
+	flows.setLimit(&FlowOptions{MaxFlows: 1024})
+	src, dst := &net.TCPAddr{IP: net.IPv4(192, 18, 0, 1), Port: 40000}, &net.TCPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 80}
+
+	b.ReportAllocs()
+	for range b.N {
+		require.NoError(b, flows.reserve(b.Context()))
+		flows.remove(flows.add("tcp", src, dst, func() {}))
+	}
+}
+
+// BenchmarkPacketRing measures the handoff of packets from the TUN reader to a pipe worker.
+func BenchmarkPacketRing(b *testing.B) {
+	r := newPacketRing(DefaultPipeQueueSize, tunMTU)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for buf := r.peek(); buf != nil; buf = r.peek() {
+			r.release()
+		}
+	}()
+	scratch := make([]byte, tunMTU)
+	buf := &scratch
+
+	b.ReportAllocs()
+	for range b.N {
+		next, ok := r.push(buf)
+		for !ok {
+			r.waitWritable(nil)
+			next, ok = r.push(buf)
+		}
+		buf = next
+	}
+	r.close()
+	<-done
+}
+
+// nopRWC reads and writes nothing.
+type nopRWC struct{}
+
+func (nopRWC) Read(p []byte) (int, error)  { return len(p), nil }
+func (nopRWC) Write(p []byte) (int, error) { return len(p), nil }
+func (nopRWC) Close() error                { return nil }
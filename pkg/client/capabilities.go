@@ -0,0 +1,57 @@
+package client
+
+import (
+	"runtime"
+	"slices"
+
+	"github.com/goxray/core/network/route"
+)
+
+// Capabilities are the features available on the platform of the binary, so frontends can disable options
+// which can not work instead of failing on Connect. Supported link schemes are listed by SupportedProtocols.
+type Capabilities struct {
+	// IPv6 reports whether IPv6 traffic is routed to the TUN device, IPv6 destinations bypass the tunnel otherwise.
+	// Servers are reachable from IPv6-only networks regardless, see Config.NAT64Prefix.
+	IPv6 bool `json:"ipv6"`
+	// UDPRelay reports whether UDP flows of the TUN device are tunneled, see UDPOptions.
+	UDPRelay bool `json:"udp_relay"`
+	// KillSwitch reports whether traffic can be blocked while the tunnel is down, it is not implemented
+	// on any platform yet.
+	KillSwitch bool `json:"kill_switch"`
+	// SystemProxy reports whether Config.SystemProxy is supported.
+	SystemProxy bool `json:"system_proxy"`
+	// RouteIsolation reports whether Config.RouteIsolation is supported.
+	RouteIsolation bool `json:"route_isolation"`
+	// Netns reports whether Config.Netns is supported.
+	Netns bool `json:"netns"`
+	// GatewayMode reports whether Config.GatewayMode is supported.
+	GatewayMode bool `json:"gateway_mode"`
+	// QoS reports whether DSCP marking of QoSOptions is supported.
+	QoS bool `json:"qos"`
+	// Keystore reports whether client certificates can be read from the OS keystore, see ClientCertOptions.
+	Keystore bool `json:"keystore"`
+	// Handover reports whether TUN device can be handed over to another process, see Client.Handover.
+	Handover bool `json:"handover"`
+}
+
+// SupportedProtocols returns sorted link schemes served by XRay core and the registered engines.
+func SupportedProtocols() []string {
+	return protocols()
+}
+
+// SupportedCapabilities returns the features available on this platform, see Capabilities.
+func SupportedCapabilities() Capabilities {
+	goos := runtime.GOOS
+
+	return Capabilities{
+		IPv6:           slices.ContainsFunc(DefaultRoutesToTUN, func(a *route.Addr) bool { return a.IP.To4() == nil }),
+		UDPRelay:       true,
+		SystemProxy:    goos == "linux" || goos == "darwin" || goos == "windows",
+		RouteIsolation: goos == "linux",
+		Netns:          goos == "linux",
+		GatewayMode:    goos == "linux",
+		QoS:            goos == "linux" || goos == "darwin",
+		Keystore:       goos == "darwin" || goos == "windows",
+		Handover:       goos == "linux",
+	}
+}
@@ -0,0 +1,20 @@
+package client
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportedCapabilities(t *testing.T) {
+	require.Equal(t, protocols(), SupportedProtocols())
+
+	caps := SupportedCapabilities()
+	require.True(t, caps.UDPRelay)
+	require.False(t, caps.IPv6)
+	require.False(t, caps.KillSwitch)
+	require.Equal(t, runtime.GOOS == "linux", caps.Netns)
+	require.Equal(t, runtime.GOOS == "linux", caps.GatewayMode)
+	require.Equal(t, runtime.GOOS == "linux", caps.Handover)
+}
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/goxray/core/network/route"
+)
+
+// DefaultCaptivePortalProbeURL is requested directly over plain HTTP to detect captive portals by default.
+const DefaultCaptivePortalProbeURL = "http://connectivitycheck.gstatic.com/generate_204"
+
+// captivePortalPollInterval is the interval of probes while waiting for the portal login.
+var captivePortalPollInterval = 3 * time.Second
+
+// ErrCaptivePortal is returned by Connect if a captive portal blocks the network.
+var ErrCaptivePortal = errors.New("captive portal detected")
+
+// CaptivePortalOptions enable captive portal detection (hotel or airport Wi-Fi requiring login in browser).
+//
+// The probe URL is requested directly, bypassing the tunnel, before connecting and after failed health checks
+// (see CheckOptions.Interval), e.g. after switching to another network. The probe response must be 204 status,
+// redirects or other content mean the network is captive. EventCaptivePortal is emitted with the portal URL,
+// so the user can be prompted to log in.
+type CaptivePortalOptions struct {
+	// ProbeURL is plain HTTP URL responding with 204 status (default: DefaultCaptivePortalProbeURL).
+	ProbeURL string
+	// Wait holds off connecting until the portal is gone, Connect fails with ErrCaptivePortal after Wait
+	// (default: 0, fail right away).
+	Wait time.Duration
+	// Bypass routes the portal addresses to the default gateway instead of waiting, so the portal stays
+	// reachable for login while connected.
+	Bypass bool
+}
+
+// Validate checks options values.
+func (o *CaptivePortalOptions) Validate() error {
+	if o.Wait < 0 {
+		return errors.New("wait must not be negative")
+	}
+	if o.ProbeURL != "" {
+		u, err := url.Parse(o.ProbeURL)
+		if err != nil {
+			return fmt.Errorf("invalid probe url: %w", err)
+		}
+		if u.Scheme != "http" {
+			return errors.New("probe url must be plain http, portals can not intercept https")
+		}
+	}
+
+	return nil
+}
+
+func (o *CaptivePortalOptions) probeURL() string {
+	if o.ProbeURL == "" {
+		return DefaultCaptivePortalProbeURL
+	}
+
+	return o.ProbeURL
+}
+
+// probeCaptivePortal requests probeURL directly and returns the portal URL if the network is captive,
+// empty string if the probe succeeded.
+func probeCaptivePortal(ctx context.Context, probeURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("captive portal probe: %w", err)
+	}
+	cl := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true}, // Direct, ignoring proxy environment.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("captive portal probe: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxExitInfoSize))
+	_ = resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return "", nil
+	}
+	if loc, err := resp.Location(); err == nil {
+		return loc.String(), nil
+	}
+
+	return probeURL, nil // Content is replaced by the portal, the probe URL itself leads to the login page.
+}
+
+// awaitCaptivePortal is called before connecting, it returns nil if there is no captive portal,
+// the portal is gone within Wait or the portal addresses are bypassed.
+func (c *Client) awaitCaptivePortal() error {
+	opts := c.cfg.CaptivePortal
+	portal, err := c.probeCaptivePortal(context.Background())
+	if err != nil || portal == "" {
+		// Unreachable probe is not a portal, the network may be just down or the probe blocked.
+		return nil
+	}
+	c.emitCaptivePortal(portal)
+
+	if opts.Bypass {
+		return c.bypassCaptivePortal(portal)
+	}
+
+	deadline := time.Now().Add(opts.Wait)
+	for time.Now().Before(deadline) {
+		time.Sleep(min(captivePortalPollInterval, time.Until(deadline)))
+		if portal, err = c.probeCaptivePortal(context.Background()); err == nil && portal == "" {
+			c.emit(Event{Type: EventCaptivePortalCleared, Message: "captive portal is gone"})
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: log in at %s", ErrCaptivePortal, portal)
+}
+
+// checkCaptivePortal is called while connected after health check failure. The probe host is routed
+// to the default gateway for the time of the probe, the portal is bypassed if it is detected.
+func (c *Client) checkCaptivePortal(ctx context.Context) {
+	if c.cfg.GatewayIP == nil {
+		return // IPv6-only network, IPv4 probe host is not reachable directly.
+	}
+	u, err := url.Parse(c.cfg.CaptivePortal.probeURL())
+	if err != nil {
+		return
+	}
+	probeRoutes, err := lookupRoutes(ctx, u.Hostname())
+	if err != nil {
+		c.cfg.Logger.Debug("captive portal probe host lookup failed", "err", err)
+		return
+	}
+	probeOpts := route.Opts{Gateway: *c.cfg.GatewayIP, Routes: probeRoutes}
+	if err = c.routes.Add(probeOpts); err != nil {
+		c.cfg.Logger.Warn("captive portal probe route failed", "err", err)
+		return
+	}
+	portal, err := c.probeCaptivePortal(ctx)
+	if delErr := c.routes.Delete(probeOpts); delErr != nil {
+		c.cfg.Logger.Warn("captive portal probe route removal failed", "err", delErr)
+	}
+	if err != nil || portal == "" {
+		return
+	}
+
+	c.captive = true
+	c.emitCaptivePortal(portal)
+	c.portalMu.Lock()
+	bypassed := len(c.portalBypass) > 0
+	c.portalMu.Unlock()
+	if bypassed {
+		return
+	}
+	if err = c.bypassCaptivePortal(portal); err != nil {
+		c.cfg.Logger.Warn("captive portal bypass failed", "err", err)
+	}
+}
+
+func (c *Client) probeCaptivePortal(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Check.timeout())
+	defer cancel()
+
+	portal, err := probeCaptivePortal(ctx, c.cfg.CaptivePortal.probeURL())
+	if err != nil {
+		c.cfg.Logger.Debug("captive portal probe failed", "err", err)
+	}
+
+	return portal, err
+}
+
+func (c *Client) emitCaptivePortal(portal string) {
+	c.cfg.Logger.Warn("captive portal detected, log in to use the network", "url", portal)
+	c.emit(Event{
+		Type:    EventCaptivePortal,
+		Message: "captive portal detected, log in to use the network",
+		Attrs:   map[string]string{"url": portal},
+	})
+}
+
+// bypassCaptivePortal routes the portal host addresses to the default gateway until Disconnect.
+func (c *Client) bypassCaptivePortal(portal string) error {
+	if c.cfg.GatewayIP == nil {
+		return nil // IPv6-only network, IPv6 traffic is not routed to TUN.
+	}
+	u, err := url.Parse(portal)
+	if err != nil {
+		return fmt.Errorf("captive portal bypass: %w", err)
+	}
+	routes, err := lookupRoutes(context.Background(), u.Hostname())
+	if err != nil {
+		return fmt.Errorf("captive portal bypass: %w", err)
+	}
+	opts := route.Opts{Gateway: *c.cfg.GatewayIP, Routes: routes}
+	if err = c.routes.Add(opts); err != nil {
+		return fmt.Errorf("captive portal bypass: %w", err)
+	}
+	c.portalMu.Lock()
+	c.portalBypass = append(c.portalBypass, opts)
+	c.portalMu.Unlock()
+	c.cfg.Logger.Info("captive portal bypassed", "host", u.Hostname())
+
+	return nil
+}
+
+// removeCaptivePortalBypass deletes routes added by bypassCaptivePortal.
+func (c *Client) removeCaptivePortalBypass() error {
+	c.portalMu.Lock()
+	defer c.portalMu.Unlock()
+
+	var err error
+	for _, opts := range c.portalBypass {
+		err = errors.Join(err, c.routes.Delete(opts))
+	}
+	c.portalBypass = nil
+
+	return err
+}
+
+// lookupRoutes resolves host into /32 routes of its IPv4 addresses.
+func lookupRoutes(ctx context.Context, host string) ([]*route.Addr, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]*route.Addr, 0, len(ips))
+	for _, ip := range ips {
+		routes = append(routes, route.MustParseAddr(ip.String()+"/32"))
+	}
+
+	return routes, nil
+}
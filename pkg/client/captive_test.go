@@ -0,0 +1,98 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/goxray/tun/pkg/client/mocks"
+)
+
+// startTestPortal starts probe server redirecting to the login page the first captive requests,
+// all requests if captive is negative.
+func startTestPortal(t *testing.T, captive int32) *httptest.Server {
+	var n atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if captive >= 0 && n.Add(1) > captive {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestProbeCaptivePortal(t *testing.T) {
+	portal, err := probeCaptivePortal(t.Context(), startTestPortal(t, 0).URL)
+	require.NoError(t, err)
+	require.Empty(t, portal)
+
+	srv := startTestPortal(t, -1)
+	portal, err = probeCaptivePortal(t.Context(), srv.URL+"/generate_204")
+	require.NoError(t, err)
+	require.Equal(t, srv.URL+"/login", portal)
+
+	// Portal replacing the content.
+	html := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("<html>Log in</html>"))
+	}))
+	defer html.Close()
+	portal, err = probeCaptivePortal(t.Context(), html.URL)
+	require.NoError(t, err)
+	require.Equal(t, html.URL, portal)
+
+	_, err = probeCaptivePortal(t.Context(), "http://127.0.0.1:1")
+	require.Error(t, err)
+}
+
+func TestClient_awaitCaptivePortal(t *testing.T) {
+	prev := captivePortalPollInterval
+	t.Cleanup(func() { captivePortalPollInterval = prev })
+	captivePortalPollInterval = 10 * time.Millisecond
+
+	var events []EventType
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.OnEvent = func(ev Event) { events = append(events, ev.Type) }
+
+	// Portal is gone while waiting.
+	cl.cfg.CaptivePortal = &CaptivePortalOptions{ProbeURL: startTestPortal(t, 2).URL, Wait: time.Second}
+	require.NoError(t, cl.awaitCaptivePortal())
+	require.Equal(t, []EventType{EventCaptivePortal, EventCaptivePortalCleared}, events)
+
+	events = nil
+	cl.cfg.CaptivePortal = &CaptivePortalOptions{ProbeURL: startTestPortal(t, -1).URL, Wait: 30 * time.Millisecond}
+	require.ErrorIs(t, cl.awaitCaptivePortal(), ErrCaptivePortal)
+	require.Equal(t, []EventType{EventCaptivePortal}, events)
+
+	// Unreachable probe is not a portal.
+	cl.cfg.CaptivePortal = &CaptivePortalOptions{ProbeURL: "http://127.0.0.1:1"}
+	require.NoError(t, cl.awaitCaptivePortal())
+}
+
+func TestClient_awaitCaptivePortal_Bypass(t *testing.T) {
+	routes := mocks.NewMockRouteTable(gomock.NewController(t))
+	cl := newTestClient(nil, nil, routes, nil, nil)
+	cl.cfg.CaptivePortal = &CaptivePortalOptions{ProbeURL: startTestPortal(t, -1).URL, Bypass: true}
+
+	bypass := route.Opts{Gateway: *cl.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr("127.0.0.1/32")}}
+	routes.EXPECT().Add(bypass).Return(nil)
+	require.NoError(t, cl.awaitCaptivePortal())
+
+	routes.EXPECT().Delete(bypass).Return(nil)
+	require.NoError(t, cl.removeCaptivePortalBypass())
+	require.NoError(t, cl.removeCaptivePortalBypass(), "routes are deleted once")
+}
+
+func TestCaptivePortalOptions_Validate(t *testing.T) {
+	require.NoError(t, (&CaptivePortalOptions{}).Validate())
+	require.Error(t, (&CaptivePortalOptions{Wait: -time.Second}).Validate())
+	require.ErrorContains(t, (&CaptivePortalOptions{ProbeURL: "https://example.com"}).Validate(), "plain http")
+}
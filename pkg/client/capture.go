@@ -0,0 +1,257 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goxray/tun/pkg/capture"
+)
+
+// CaptureOptions export tunneled traffic for analysis: closed flows as JSON Lines and packets of TUN device
+// as pcap-ng (readable by Wireshark). Files are appended to, each connection starts a new pcap-ng section.
+// Export stops when a file reaches MaxBytes or fails to write.
+type CaptureOptions struct {
+	// FlowLog is the path of JSON Lines file closed flows are appended to.
+	FlowLog string
+	// Packets is the path of pcap-ng file packets are appended to.
+	Packets string
+	// Filter selects captured packets, BPF-style expression like "udp and port 53", see capture.Filter (default: all).
+	Filter string
+	// SnapLen truncates captured packets to the length, e.g. 128 for headers only (default: not truncated).
+	SnapLen int
+	// MaxBytes caps size of each file (default: unlimited).
+	MaxBytes int64
+}
+
+// Validate checks options values.
+func (o *CaptureOptions) Validate() error {
+	if o.FlowLog == "" && o.Packets == "" {
+		return errors.New("flow log or packets path is required")
+	}
+	if (o.Filter != "" || o.SnapLen != 0) && o.Packets == "" {
+		return errors.New("filter and snap length require packets path")
+	}
+	if _, err := capture.ParseFilter(o.Filter); err != nil {
+		return err
+	}
+	if o.SnapLen < 0 {
+		return errors.New("snap length must not be negative")
+	}
+	if o.MaxBytes < 0 {
+		return errors.New("max bytes must not be negative")
+	}
+
+	return nil
+}
+
+// captureSession holds the capture files of a connection.
+type captureSession struct {
+	logger  *slog.Logger
+	flowLog *flowLog
+	file    *os.File // Packets file.
+	packets *capture.Writer
+
+	stopped sync.Once
+	off     chan struct{} // Closed by stopped when packet capture stops.
+}
+
+// openCapture opens capture files of opts, nil opts returns nil session.
+func openCapture(opts *CaptureOptions, logger *slog.Logger) (*captureSession, error) {
+	if opts == nil {
+		return nil, nil
+	}
+
+	s := &captureSession{logger: logger, off: make(chan struct{})}
+	if opts.FlowLog != "" {
+		f, size, err := openAppend(opts.FlowLog)
+		if err != nil {
+			return nil, fmt.Errorf("open flow log: %w", err)
+		}
+		s.flowLog = &flowLog{w: f, written: size, max: opts.MaxBytes, logger: logger}
+	}
+	if opts.Packets == "" {
+		return s, nil
+	}
+
+	f, size, err := openAppend(opts.Packets)
+	if err != nil {
+		_ = s.close()
+		return nil, fmt.Errorf("open packet capture: %w", err)
+	}
+	s.file = f
+	filter, _ := capture.ParseFilter(opts.Filter)
+	s.packets, err = capture.NewWriter(f, capture.WriterOptions{
+		SnapLen: opts.SnapLen, MaxBytes: opts.MaxBytes, Offset: size, Filter: filter,
+	})
+	if errors.Is(err, capture.ErrLimit) {
+		logger.Warn("packet capture file is full, not capturing", "path", opts.Packets)
+		s.packets = nil
+		return s, nil
+	}
+	if err != nil {
+		_ = s.close()
+		return nil, fmt.Errorf("open packet capture: %w", err)
+	}
+
+	return s, nil
+}
+
+// openAppend opens file at path for appending, it returns the current file size.
+func openAppend(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+
+	return f, st.Size(), nil
+}
+
+// wrap returns tunnel capturing packets it reads and writes, it returns tunnel itself if packets are not captured.
+func (s *captureSession) wrap(tunnel io.ReadWriteCloser) io.ReadWriteCloser {
+	if s == nil || s.packets == nil {
+		return tunnel
+	}
+
+	return &captureTunnel{ReadWriteCloser: tunnel, s: s}
+}
+
+// log returns the flow log, nil if flows are not logged.
+func (s *captureSession) log() *flowLog {
+	if s == nil {
+		return nil
+	}
+
+	return s.flowLog
+}
+
+func (s *captureSession) capture(dir capture.Direction, pkt []byte) {
+	select {
+	case <-s.off:
+		return
+	default:
+	}
+
+	err := s.packets.WritePacket(time.Now(), dir, pkt)
+	if err == nil {
+		return
+	}
+	s.stopped.Do(func() {
+		close(s.off)
+		if errors.Is(err, capture.ErrLimit) {
+			s.logger.Warn("packet capture file is full, capture stopped")
+		} else {
+			s.logger.Warn("packet capture failed, capture stopped", "err", err)
+		}
+	})
+}
+
+// close closes capture files, nil session is a no-op.
+func (s *captureSession) close() error {
+	if s == nil {
+		return nil
+	}
+
+	var err error
+	if s.flowLog != nil {
+		err = s.flowLog.close()
+	}
+	if s.file != nil {
+		s.stopped.Do(func() { close(s.off) })
+		err = errors.Join(err, s.file.Close())
+	}
+
+	return err
+}
+
+// captureTunnel captures packets read from TUN device (outbound) and written to it (inbound).
+type captureTunnel struct {
+	io.ReadWriteCloser
+	s *captureSession
+}
+
+func (t *captureTunnel) Read(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Read(p)
+	if n > 0 {
+		t.s.capture(capture.Outbound, p[:n])
+	}
+
+	return n, err
+}
+
+func (t *captureTunnel) Write(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(p)
+	if err == nil {
+		t.s.capture(capture.Inbound, p)
+	}
+
+	return n, err
+}
+
+// flowLogRecord is a JSON Lines record of the flow log.
+type flowLogRecord struct {
+	ID          uint64    `json:"id"`
+	Network     string    `json:"network"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Started     time.Time `json:"started"`
+	Ended       time.Time `json:"ended"`
+}
+
+// flowLog writes closed flows as JSON Lines up to max bytes (if not zero), it stops on the first failure.
+type flowLog struct {
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	w       io.WriteCloser
+	written int64
+	max     int64
+	stopped bool
+}
+
+// write logs flow closed at ended, nil flowLog is a no-op.
+func (l *flowLog) write(f Flow, ended time.Time) {
+	if l == nil {
+		return
+	}
+
+	b, _ := json.Marshal(flowLogRecord{
+		ID: f.ID, Network: f.Network, Source: f.Source, Destination: f.Destination, Started: f.Started, Ended: ended,
+	})
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return
+	}
+	if l.max > 0 && l.written+int64(len(b)) > l.max {
+		l.stopped = true
+		l.logger.Warn("flow log file is full, flow log stopped")
+		return
+	}
+	n, err := l.w.Write(b)
+	l.written += int64(n)
+	if err != nil {
+		l.stopped = true
+		l.logger.Warn("flow log failed, flow log stopped", "err", err)
+	}
+}
+
+func (l *flowLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopped = true
+
+	return l.w.Close()
+}
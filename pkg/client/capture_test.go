@@ -0,0 +1,97 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/capture"
+)
+
+func TestCaptureOptions_Validate(t *testing.T) {
+	require.NoError(t, (&CaptureOptions{FlowLog: "flows.jsonl"}).Validate())
+	require.NoError(t, (&CaptureOptions{Packets: "tun.pcapng", Filter: "udp and port 53", SnapLen: 128, MaxBytes: 1 << 20}).Validate())
+
+	for _, opts := range []CaptureOptions{
+		{},
+		{FlowLog: "flows.jsonl", Filter: "udp"},
+		{Packets: "tun.pcapng", Filter: "port dns"},
+		{Packets: "tun.pcapng", SnapLen: -1},
+		{Packets: "tun.pcapng", MaxBytes: -1},
+	} {
+		require.Error(t, opts.Validate(), opts)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+	opts := &CaptureOptions{FlowLog: filepath.Join(dir, "flows.jsonl"), Packets: filepath.Join(dir, "tun.pcapng")}
+	s, err := openCapture(opts, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	tun := newTestTUN()
+	tunnel := s.wrap(tun)
+	pkt := make([]byte, 20)
+	pkt[0] = 0x45
+	tun.in <- pkt
+	n, err := tunnel.Read(make([]byte, tunMTU))
+	require.NoError(t, err)
+	require.Equal(t, 20, n)
+	_, err = tunnel.Write(pkt)
+	require.NoError(t, err)
+
+	flows := newFlowTable()
+	flows.setLog(s.log())
+	require.NoError(t, flows.reserve(t.Context()))
+	e := flows.add("tcp", &net.TCPAddr{IP: net.IPv4(192, 18, 0, 1), Port: 40000},
+		&net.TCPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 443}, func() {})
+	flows.remove(e)
+	flows.remove(e) // Logged once.
+	require.NoError(t, s.close())
+
+	st, err := os.Stat(opts.Packets)
+	require.NoError(t, err)
+	require.EqualValues(t, 28+20+2*(32+20+12), st.Size(), "headers and two packets")
+
+	f, err := os.Open(opts.FlowLog)
+	require.NoError(t, err)
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	require.True(t, sc.Scan())
+	var rec flowLogRecord
+	require.NoError(t, json.Unmarshal(sc.Bytes(), &rec))
+	require.Equal(t, "tcp", rec.Network)
+	require.Equal(t, "192.18.0.1:40000", rec.Source)
+	require.Equal(t, "1.1.1.1:443", rec.Destination)
+	require.False(t, rec.Ended.Before(rec.Started))
+	require.False(t, sc.Scan())
+}
+
+func TestCapture_maxBytes(t *testing.T) {
+	dir := t.TempDir()
+	opts := &CaptureOptions{Packets: filepath.Join(dir, "tun.pcapng"), MaxBytes: 28 + 20 + 32 + 20 + 12}
+	s, err := openCapture(opts, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	pkt := make([]byte, 20)
+	for range 3 {
+		s.capture(capture.Outbound, pkt)
+	}
+	require.NoError(t, s.close())
+	st, err := os.Stat(opts.Packets)
+	require.NoError(t, err)
+	require.Equal(t, opts.MaxBytes, st.Size())
+
+	// The full file is kept as is on the next connection.
+	s, err = openCapture(opts, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	tun := newTestTUN()
+	require.Equal(t, io.ReadWriteCloser(tun), s.wrap(tun))
+	require.NoError(t, s.close())
+}
@@ -0,0 +1,259 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCheckURL is the URL requested by connectivity checks by default, it responds with 204 status.
+	DefaultCheckURL = "https://www.gstatic.com/generate_204"
+	// DefaultCheckTimeout limits a single connectivity check or exit info request by default.
+	DefaultCheckTimeout = 10 * time.Second
+)
+
+// CheckOptions configure connectivity checks made through the proxy: Client.Check,
+// periodic health checks while connected and Client.ExitInfo (timeout only).
+// The defaults may be blocked in censored environments, any reachable URL can be used instead.
+type CheckOptions struct {
+	// URL requested by the check (default: DefaultCheckURL).
+	URL string
+	// ExpectedStatus is HTTP status code of successful check
+	// (default: 204 for DefaultCheckURL, any 2xx status for other URLs).
+	ExpectedStatus int
+	// Timeout of a single check (default: DefaultCheckTimeout).
+	Timeout time.Duration
+	// Interval of health checks while connected, failures and recoveries are logged (default: 0, disabled).
+	Interval time.Duration
+}
+
+// Validate checks options values.
+func (o *CheckOptions) Validate() error {
+	if o.ExpectedStatus != 0 && (o.ExpectedStatus < 100 || o.ExpectedStatus > 599) {
+		return fmt.Errorf("invalid expected status %d", o.ExpectedStatus)
+	}
+	if o.Timeout < 0 || o.Interval < 0 {
+		return errors.New("timeout and interval must not be negative")
+	}
+
+	return nil
+}
+
+func (o *CheckOptions) url() string {
+	if o == nil || o.URL == "" {
+		return DefaultCheckURL
+	}
+
+	return o.URL
+}
+
+func (o *CheckOptions) timeout() time.Duration {
+	if o == nil || o.Timeout == 0 {
+		return DefaultCheckTimeout
+	}
+
+	return o.Timeout
+}
+
+// statusOK reports whether status is the expected status of the check.
+func (o *CheckOptions) statusOK(status int) bool {
+	expected := 0
+	if o != nil {
+		expected = o.ExpectedStatus
+	}
+	if expected == 0 && o.url() == DefaultCheckURL {
+		expected = http.StatusNoContent
+	}
+	if expected == 0 {
+		return status >= 200 && status <= 299
+	}
+
+	return status == expected
+}
+
+// CheckResult is the result of a connectivity check.
+type CheckResult struct {
+	Time    time.Time     // When the check was started.
+	Latency time.Duration // Time to response headers.
+	Err     error         // Nil if the check succeeded.
+}
+
+// healthState holds the result of the last health check.
+type healthState struct {
+	mu   sync.Mutex
+	last CheckResult
+}
+
+// Check requests Config.Check URL through the proxy and verifies the response status.
+// The result is also available via LastCheck.
+func (c *Client) Check(ctx context.Context) CheckResult {
+	res := CheckResult{Time: time.Now()}
+	if c.stopTunnel == nil {
+		res.Err = ErrNotConnected
+		return res
+	}
+
+	res.Err = c.check(ctx)
+	res.Latency = time.Since(res.Time)
+	c.health.mu.Lock()
+	c.health.last = res
+	c.health.mu.Unlock()
+
+	return res
+}
+
+// LastCheck returns the result of the last connectivity check, zero if there were no checks.
+func (c *Client) LastCheck() CheckResult {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+
+	return c.health.last
+}
+
+func (c *Client) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Check.timeout())
+	defer cancel()
+
+	cl, closeClient, err := c.proxyHTTPClient()
+	if err != nil {
+		return fmt.Errorf("check: %w", err)
+	}
+	defer closeClient()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.Check.url(), nil)
+	if err != nil {
+		return fmt.Errorf("check: %w", err)
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return fmt.Errorf("check: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxExitInfoSize))
+	_ = resp.Body.Close()
+
+	if !c.cfg.Check.statusOK(resp.StatusCode) {
+		return fmt.Errorf("check: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// runHealthChecks checks connectivity every Config.Check.Interval until ctx is done.
+func (c *Client) runHealthChecks(ctx context.Context) {
+	if c.cfg.Check == nil || c.cfg.Check.Interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.cfg.Check.Interval)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		res := c.Check(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case res.Err != nil && healthy:
+			c.cfg.Logger.Warn("health check failed", "url", c.cfg.Check.url(), "err", res.Err)
+			if c.cfg.CaptivePortal != nil {
+				c.checkCaptivePortal(ctx) // The network might have changed.
+			}
+		case res.Err == nil && !healthy:
+			c.cfg.Logger.Info("health check recovered", "url", c.cfg.Check.url(), "latency", res.Latency)
+			if c.captive {
+				c.captive = false
+				c.emit(Event{Type: EventCaptivePortalCleared, Message: "captive portal is gone"})
+			}
+		}
+		healthy = res.Err == nil
+	}
+}
+
+// proxyHTTPClient returns HTTP client connecting through the proxy, close releases its connections.
+func (c *Client) proxyHTTPClient() (cl *http.Client, close func(), err error) {
+	dialer, err := c.proxyDialer()
+	if err != nil {
+		return nil, nil, err
+	}
+	transport := &http.Transport{DialContext: dialer.DialContext, ForceAttemptHTTP2: true}
+
+	return &http.Client{Transport: transport}, transport.CloseIdleConnections, nil
+}
+
+// Probe measures latency of link without connecting to it: the proxy of link is started on a private inbound,
+// without TUN device and routes, and Config.Check URL is requested through it. The server is connected to
+// around the tunnel (Config.Dialer if it is set), so links can be compared while connected, e.g. to pick
+// the fastest one.
+func (c *Client) Probe(ctx context.Context, link string) (time.Duration, error) {
+	latency, _, err := c.probe(ctx, link, false)
+
+	return latency, err
+}
+
+// ProbeExit is Probe also querying Config.ExitInfoURL through the proxy of link, e.g. to pick a server
+// by exit country. Latency is measured by the check only.
+func (c *Client) ProbeExit(ctx context.Context, link string) (time.Duration, *ExitInfo, error) {
+	return c.probe(ctx, link, true)
+}
+
+func (c *Client) probe(ctx context.Context, link string, exit bool) (time.Duration, *ExitInfo, error) {
+	if c.cfg.Upstream != nil {
+		return 0, nil, fmt.Errorf("%w: probe: not supported with upstream proxy", ErrInvalidConfig)
+	}
+	cfg := c.cfg
+	cfg.InboundProxy = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()}
+	cfg.InboundACL = nil
+	if cfg.Dialer == nil {
+		cfg.Dialer = c.directDial
+	}
+	p := &Client{cfg: cfg}
+
+	inst, _, err := p.createProxy(link)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err = inst.Start(); err != nil {
+		return 0, nil, fmt.Errorf("probe: start proxy: %w", err)
+	}
+	defer func() {
+		if p.xDialed != nil {
+			dropXrayDialer(p.xDialed)
+		}
+		_ = inst.Close()
+	}()
+
+	start := time.Now()
+	if err = p.check(ctx); err != nil {
+		return 0, nil, fmt.Errorf("probe: %w", err)
+	}
+	latency := time.Since(start)
+	if !exit {
+		return latency, nil, nil
+	}
+
+	cl, closeClient, err := p.proxyHTTPClient()
+	if err != nil {
+		return 0, nil, fmt.Errorf("probe: %w", err)
+	}
+	defer closeClient()
+	ctx, cancel := context.WithTimeout(ctx, cfg.Check.timeout())
+	defer cancel()
+	info, err := fetchExitInfo(ctx, cl, cmp.Or(cfg.ExitInfoURL, DefaultExitInfoURL))
+	if err != nil {
+		return 0, nil, fmt.Errorf("probe: %w", err)
+	}
+
+	return latency, info, nil
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/internal/socks5"
+)
+
+func TestClient_Check(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	require.ErrorIs(t, cl.Check(t.Context()).Err, ErrNotConnected)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := socks5.NewServer((&net.Dialer{}).DialContext)
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+	defer ln.Close()
+
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/slow":
+			time.Sleep(time.Second)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer probe.Close()
+
+	cl.cfg.InboundProxy = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: ln.Addr().(*net.TCPAddr).Port}
+	_, cl.stopTunnel = context.WithCancel(context.Background())
+
+	// Any 2xx status is fine by default for custom URLs.
+	cl.cfg.Check = &CheckOptions{URL: probe.URL + "/ok"}
+	res := cl.Check(t.Context())
+	require.NoError(t, res.Err)
+	require.Positive(t, res.Latency)
+	require.Equal(t, res, cl.LastCheck())
+
+	cl.cfg.Check.ExpectedStatus = http.StatusNoContent
+	require.ErrorContains(t, cl.Check(t.Context()).Err, "unexpected status 200")
+	require.Error(t, cl.LastCheck().Err)
+
+	cl.cfg.Check = &CheckOptions{URL: probe.URL + "/slow", Timeout: 50 * time.Millisecond}
+	require.ErrorIs(t, cl.Check(t.Context()).Err, context.DeadlineExceeded)
+
+	// Periodic checks run until the context is done.
+	cl.cfg.Check = &CheckOptions{URL: probe.URL, ExpectedStatus: http.StatusNoContent, Interval: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		cl.runHealthChecks(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return cl.LastCheck().Err == nil }, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestCheckOptions(t *testing.T) {
+	var o *CheckOptions
+	require.Equal(t, DefaultCheckURL, o.url())
+	require.Equal(t, DefaultCheckTimeout, o.timeout())
+	require.True(t, o.statusOK(http.StatusNoContent))
+	require.False(t, o.statusOK(http.StatusOK), "captive portals respond with 200 instead of 204")
+
+	require.Error(t, (&CheckOptions{ExpectedStatus: 1000}).Validate())
+	require.Error(t, (&CheckOptions{Interval: -time.Second}).Validate())
+	require.NoError(t, (&CheckOptions{URL: "http://example.com", ExpectedStatus: 200, Interval: time.Minute}).Validate())
+}
+
+func TestLoopback_Probe(t *testing.T) {
+	c, _, routes, _ := newLoopbackClient(t)
+	c.cfg.Check = &CheckOptions{URL: "http://probe.invalid/"}
+
+	latency, err := c.Probe(t.Context(), loopbackScheme+"://http")
+	require.NoError(t, err)
+	require.Positive(t, latency)
+	c.cfg.ExitInfoURL = "http://probe.invalid/json"
+	latency, exit, err := c.ProbeExit(t.Context(), loopbackScheme+"://http")
+	require.NoError(t, err)
+	require.Positive(t, latency)
+	require.Equal(t, "NL", exit.Country)
+	_, err = c.Probe(t.Context(), loopbackScheme+"://test")
+	require.ErrorContains(t, err, "probe: check:", "echo server is not HTTP")
+	_, err = c.Probe(t.Context(), loopbackScheme+"://fail-start")
+	require.ErrorContains(t, err, "engine start err")
+	_, err = c.Probe(t.Context(), "vless://example.com")
+	require.ErrorIs(t, err, ErrInvalidConfig)
+	require.Empty(t, routes.Ops(), "probes do not touch routes")
+
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	defer c.Disconnect(context.Background())
+	_, err = c.Probe(t.Context(), loopbackScheme+"://http")
+	require.NoError(t, err, "probed while connected")
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"syscall"
+
+	"github.com/goxray/tun/pkg/sysproxy"
+)
+
+// ErrJournalInUse is returned by Client.Cleanup if the process which made the changes is still running.
+var ErrJournalInUse = errors.New("journal is in use by running process")
+
+// restoreSystemProxySnapshot applies saved OS proxy settings, replaced in tests.
+var restoreSystemProxySnapshot = sysproxy.Restore
+
+// CleanupReport describes leftovers of a crashed run removed by Client.Cleanup.
+type CleanupReport struct {
+	PID         int      `json:"pid"`                    // Process which made the changes.
+	TUN         string   `json:"tun,omitempty"`          // Deleted stale TUN device, empty if it was gone.
+	Routes      []string `json:"routes,omitempty"`       // Deleted routes.
+	Gone        []string `json:"gone,omitempty"`         // Routes which were gone already, e.g. with TUN device.
+	SystemProxy bool     `json:"system_proxy,omitempty"` // Whether system proxy settings were restored.
+}
+
+// Cleanup undoes system changes of a run which did not disconnect, e.g. it crashed or was killed:
+// the stale TUN device and routes are deleted, the system proxy settings are restored. The changes are read
+// from Config.Journal, nil report is returned if there are none. Connect does it on its own before connecting.
+//
+// Routes to TUN device are gone with the device unless it is still there, the device is deleted only if
+// it has Config.TUNAddress of the crashed run. Deleting the device is supported on Linux only.
+func (c *Client) Cleanup() (*CleanupReport, error) {
+	if c.cfg.Journal == "" {
+		return nil, errors.New("journal is not configured")
+	}
+	if c.stopTunnel != nil {
+		return nil, errors.New("client is connected")
+	}
+	rec, err := readJournal(c.cfg.Journal)
+	if rec == nil || err != nil {
+		return nil, err
+	}
+	if rec.PID != os.Getpid() && processAlive(rec.PID) {
+		return nil, fmt.Errorf("%w: %s, pid %d", ErrJournalInUse, c.cfg.Journal, rec.PID)
+	}
+
+	report := &CleanupReport{PID: rec.PID}
+	var errs []error
+	devices := make(map[string]bool) // Whether routes of the device are there.
+	for _, op := range rec.Routes {
+		if _, ok := devices[op.IfName]; op.IfName == "" || ok {
+			continue
+		}
+		deleted, err := deleteStaleTUN(op.IfName, rec.TUNAddress)
+		if deleted || err != nil {
+			c.audit.record(AuditTUNDelete, op.IfName+" "+rec.TUNAddress, err)
+		}
+		if deleted {
+			report.TUN = op.IfName
+		}
+		if err != nil {
+			c.log(LogTUN).Warn("stale TUN device not deleted, deleting its routes", "name", op.IfName, "err", err)
+		}
+		devices[op.IfName] = err != nil
+	}
+
+	for _, op := range rec.Routes {
+		if op.IfName != "" && !devices[op.IfName] {
+			report.Gone = append(report.Gone, op.String())
+			continue
+		}
+		opts, err := op.opts()
+		if err == nil {
+			err = auditRoutes{RouteTable: c.cfg.Routes, a: c.audit}.Delete(opts)
+		}
+		if err != nil {
+			// OS routing tables do not tell a missing route from other failures.
+			c.log(LogRoute).Debug("route not deleted", "route", op, "err", err)
+			report.Gone = append(report.Gone, op.String())
+			continue
+		}
+		report.Routes = append(report.Routes, op.String())
+	}
+
+	if rec.SystemProxy != nil {
+		err = restoreSystemProxySnapshot(rec.SystemProxy)
+		c.audit.record(AuditSystemProxyRestore, "", err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restore system proxy: %w", err))
+		} else {
+			report.SystemProxy = true
+			rec.SystemProxy = nil
+		}
+	}
+
+	// The journal is kept if system proxy is not restored, so Cleanup can be retried.
+	j := &journal{path: c.cfg.Journal, logger: c.cfg.Logger, rec: journalRecord{SystemProxy: rec.SystemProxy}}
+	if err = j.save(); err != nil {
+		errs = append(errs, fmt.Errorf("save journal: %w", err))
+	}
+	if c.journal != nil {
+		c.journal.mu.Lock()
+		c.journal.rec = j.rec // Changes of a failed Disconnect are undone.
+		c.journal.mu.Unlock()
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// JournalOwner returns pid of the running process with system changes recorded in journal at path (see
+// Config.Journal), e.g. to stop it before Client.Cleanup. Zero is returned if there is none.
+func JournalOwner(path string) (int, error) {
+	rec, err := readJournal(path)
+	if rec == nil || err != nil {
+		return 0, err
+	}
+	if rec.PID == os.Getpid() || !processAlive(rec.PID) {
+		return 0, nil
+	}
+
+	return rec.PID, nil
+}
+
+// cleanupCrashed undoes changes of a crashed run before connecting, only a running process stops it.
+func (c *Client) cleanupCrashed() error {
+	report, err := c.Cleanup()
+	if errors.Is(err, ErrJournalInUse) {
+		return err
+	}
+	if err != nil {
+		c.cfg.Logger.Warn("cleanup of crashed run failed", "err", err)
+	}
+	if report != nil {
+		c.cfg.Logger.Info("cleaned up crashed run", "pid", report.PID, "tun", report.TUN, "routes", report.Routes,
+			"system_proxy", report.SystemProxy)
+	}
+
+	return nil
+}
+
+// deleteStaleTUN deletes TUN device name if it has IP addr, it returns whether it was deleted.
+func deleteStaleTUN(name, addr string) (bool, error) {
+	ifc, err := net.InterfaceByName(name)
+	if err != nil {
+		return false, nil // gone
+	}
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		return false, err
+	}
+	if !slices.ContainsFunc(addrs, func(a net.Addr) bool {
+		ipNet, ok := a.(*net.IPNet)
+		return ok && ipNet.IP.String() == addr
+	}) {
+		return false, nil // The name is reused by another device.
+	}
+	if err = deleteLink(name); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// processAlive returns whether process pid is running.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
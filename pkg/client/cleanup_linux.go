@@ -0,0 +1,15 @@
+package client
+
+import (
+	"github.com/vishvananda/netlink"
+)
+
+// deleteLink deletes network device name.
+func deleteLink(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	return netlink.LinkDel(link)
+}
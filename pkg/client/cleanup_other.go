@@ -0,0 +1,12 @@
+//go:build !linux
+
+package client
+
+import (
+	"errors"
+)
+
+// deleteLink deletes network device name, TUN devices of other platforms can not be deleted by another process.
+func deleteLink(string) error {
+	return errors.ErrUnsupported
+}
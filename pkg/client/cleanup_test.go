@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/sysproxy"
+)
+
+// useJournal records system changes of c to a journal in a temporary dir, like NewClientWithOpts does.
+func useJournal(t *testing.T, c *Client) string {
+	t.Helper()
+	c.cfg.Journal = filepath.Join(t.TempDir(), "tun.journal.json")
+	c.journal = &journal{path: c.cfg.Journal, logger: c.cfg.Logger}
+	c.routes = journalRoutes{RouteTable: c.routes, j: c.journal}
+
+	return c.cfg.Journal
+}
+
+func writeJournal(t *testing.T, path string, rec journalRecord) {
+	t.Helper()
+	j := &journal{path: path, rec: rec}
+	require.NoError(t, j.save())
+}
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+	j := &journal{path: path}
+	routes := journalRoutes{RouteTable: &MemoryRouteTable{}, j: j}
+
+	require.NoError(t, routes.Add(route.Opts{IfName: "tun0", Routes: DefaultRoutesToTUN}))
+	require.NoError(t, routes.Add(route.Opts{Gateway: []byte{192, 168, 1, 1}, Routes: []*route.Addr{hostRoute([]byte{203, 0, 113, 5})}}))
+	require.Error(t, routes.Delete(route.Opts{IfName: "tun1", Routes: DefaultRoutesToTUN}), "failed changes are not recorded")
+	rec, err := readJournal(path)
+	require.NoError(t, err)
+	require.Equal(t, os.Getpid(), rec.PID)
+	require.Equal(t, []RouteOp{
+		{Addr: "0.0.0.0/1", IfName: "tun0"},
+		{Addr: "128.0.0.0/1", IfName: "tun0"},
+		{Addr: "203.0.113.5/32", Gateway: "192.168.1.1"},
+	}, rec.Routes)
+
+	// Interface routes are removed with the device.
+	dev := j.device(newMemTUN(), "192.18.0.1")
+	require.NoError(t, dev.Close())
+	rec, err = readJournal(path)
+	require.NoError(t, err)
+	require.Equal(t, "192.18.0.1", rec.TUNAddress)
+	require.Equal(t, []RouteOp{{Addr: "203.0.113.5/32", Gateway: "192.168.1.1"}}, rec.Routes)
+
+	j.systemProxy(&sysproxy.Snapshot{})
+	require.NoError(t, routes.Delete(route.Opts{Gateway: []byte{192, 168, 1, 1}, Routes: []*route.Addr{hostRoute([]byte{203, 0, 113, 5})}}))
+	rec, err = readJournal(path)
+	require.NoError(t, err)
+	require.Empty(t, rec.Routes)
+	require.NotNil(t, rec.SystemProxy)
+
+	j.systemProxy(nil)
+	rec, err = readJournal(path)
+	require.NoError(t, err)
+	require.Nil(t, rec, "journal is removed once changes are undone")
+}
+
+func TestLoopback_Journal(t *testing.T) {
+	c, _, routes, _ := newLoopbackClient(t)
+	path := useJournal(t, c)
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	rec, err := readJournal(path)
+	require.NoError(t, err)
+	require.Equal(t, routes.Routes(), rec.Routes)
+
+	require.NoError(t, c.Disconnect(context.Background()))
+	rec, err = readJournal(path)
+	require.NoError(t, err)
+	require.Nil(t, rec)
+}
+
+func TestClient_Cleanup(t *testing.T) {
+	var restored *sysproxy.Snapshot
+	restoreErr := errors.New("restore err")
+	prev := restoreSystemProxySnapshot
+	t.Cleanup(func() { restoreSystemProxySnapshot = prev })
+	restoreSystemProxySnapshot = func(s *sysproxy.Snapshot) error {
+		restored = s
+		return restoreErr
+	}
+
+	c, _, routes, _ := newLoopbackClient(t)
+	path := useJournal(t, c)
+	report, err := c.Cleanup()
+	require.NoError(t, err)
+	require.Nil(t, report, "nothing to clean up")
+
+	// Crashed run, its TUN device is gone.
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	installed := routes.Routes()
+	require.NotEmpty(t, installed)
+	_, err = c.Cleanup()
+	require.ErrorContains(t, err, "client is connected")
+	require.NoError(t, c.Disconnect(context.Background()))
+	for _, op := range installed {
+		opts, err := op.opts()
+		require.NoError(t, err)
+		require.NoError(t, routes.Add(opts))
+	}
+	writeJournal(t, path, journalRecord{
+		TUNAddress:  "192.18.0.1",
+		Routes:      append([]RouteOp{{Addr: "0.0.0.0/1", IfName: "goxray-gone0"}}, installed...),
+		SystemProxy: &sysproxy.Snapshot{},
+	})
+
+	report, err = c.Cleanup()
+	require.ErrorIs(t, err, restoreErr)
+	require.Equal(t, os.Getpid(), report.PID)
+	require.Empty(t, report.TUN)
+	require.Equal(t, []string{"add 0.0.0.0/1 dev goxray-gone0"}, report.Gone)
+	require.Len(t, report.Routes, len(installed))
+	require.Empty(t, routes.Routes())
+	require.False(t, report.SystemProxy)
+	rec, err := readJournal(path)
+	require.NoError(t, err)
+	require.Empty(t, rec.Routes)
+	require.NotNil(t, rec.SystemProxy, "kept to retry")
+
+	restoreErr = nil
+	report, err = c.Cleanup()
+	require.NoError(t, err)
+	require.True(t, report.SystemProxy)
+	require.NotNil(t, restored)
+	rec, err = readJournal(path)
+	require.NoError(t, err)
+	require.Nil(t, rec)
+
+	// Changes of a running process are left alone.
+	b, err := json.Marshal(journalRecord{PID: os.Getppid(), Routes: installed})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+	require.ErrorIs(t, c.Connect(loopbackScheme+"://test"), ErrJournalInUse)
+	_, err = c.Cleanup()
+	require.ErrorIs(t, err, ErrJournalInUse)
+	pid, err := JournalOwner(path)
+	require.NoError(t, err)
+	require.Equal(t, os.Getppid(), pid)
+
+	writeJournal(t, path, journalRecord{Routes: installed})
+	pid, err = JournalOwner(path)
+	require.NoError(t, err)
+	require.Zero(t, pid, "changes of this process")
+	pid, err = JournalOwner(filepath.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+	require.Zero(t, pid)
+}
@@ -1,34 +1,51 @@
 package client
 
 import (
+	"cmp"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
-	"os"
+	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/goxray/core/network/route"
 	"github.com/goxray/core/network/tun"
-	"github.com/goxray/core/pipe2socks"
-	"github.com/jackpal/gateway"
 
 	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
 	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
 	xapplog "github.com/xtls/xray-core/app/log"
 	xcommlog "github.com/xtls/xray-core/common/log"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
 )
 
-const disconnectTimeout = 30 * time.Second
+const (
+	disconnectTimeout = 30 * time.Second
+	tunMTU            = 1500
+)
+
+var (
+	// ErrInvalidConfig is returned if Config or the connection link is invalid, or the link protocol does not
+	// support a configured feature. Fixing the configuration is needed, retrying does not help.
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrAuth is returned if the server rejected the credentials of the link.
+	ErrAuth = errors.New("authentication failed")
+)
 
 var (
 	// defaultTUNAddress is the address new TUN device will be set up with.
 	defaultTUNAddress = &net.IPNet{IP: net.IPv4(192, 18, 0, 1), Mask: net.IPv4Mask(255, 255, 255, 255)}
+	// defaultTUNNetwork has default addresses of Clients connected at once, see Client.claim.
+	defaultTUNNetwork = &net.IPNet{IP: net.IPv4(192, 18, 0, 0), Mask: net.IPv4Mask(255, 255, 255, 0)}
 	// defaultInboundProxy default proxy will be set up for listening on 127.0.0.1.
 	defaultInboundProxy = &Proxy{
 		IP:   net.IPv4(127, 0, 0, 1),
@@ -52,43 +69,325 @@ type Config struct {
 	// (default: will be dynamically detected from your default gateway).
 	//
 	// Client will determine the system gateway IP automatically,
-	// and you don't have to set this field explicitly.
+	// and you don't have to set this field explicitly. Discovered gateway is refreshed on every Connect.
 	GatewayIP *net.IP
-	// Socks proxy address on which XRay creates inbound proxy (default: 127.0.0.1:10808).
+	// GatewayInterface is the interface whose default gateway is used, e.g. "eth0" when there are several
+	// (default: the gateway of the system default route). It is ignored if GatewayIP is set.
+	GatewayInterface string
+	// GatewayWait is the longest wait for the gateway discovery in Connect, e.g. for a daemon started before
+	// the network is up (default: no wait, no IPv4 gateway means IPv6-only network).
+	GatewayWait time.Duration
+	// NAT64Prefix is used to reach IPv4-only XRay server on IPv6-only network, i.e. if there is no IPv4 gateway
+	// (default: discovered via DNS64, RFC 7050). The TUN device keeps IPv4 address, so IPv4 traffic is tunneled
+	// over the IPv6 connection to the server. It is supported for protocols served by XRay core only.
+	NAT64Prefix *net.IPNet
+	// Socks proxy address on which XRay creates inbound proxy (default: 127.0.0.1:10808),
+	// see Proxy.Path to use Unix domain socket.
 	InboundProxy *Proxy
+	// InboundACL restricts access to the InboundProxy bound to non-loopback address (default: no restrictions).
+	// It is not supported for Unix domain socket proxy.
+	InboundACL *InboundACL
 	// TUN device address (default: 192.18.0.1).
 	TUNAddress *net.IPNet
+	// TUN device MTU (default: 1500).
+	MTU int
 	// List of routes to be pointed to TUN device (default: DefaultRoutesToTUN).
 	//
 	// One exception is explicitly added for XRay remote server IP and can not be altered.
 	RoutesToTUN []*route.Addr
+	// Routes adds and deletes OS routes (default: OS routing table). MemoryRouteTable records route changes
+	// without applying them, e.g. for tests and dry runs.
+	Routes RouteTable
+	// RouteMetric is the metric (priority) of routes added to OS routing table, lower metric wins over the same
+	// routes of other VPN software (default: 1). It is supported on Linux only, the most specific route wins
+	// on other platforms. It is not applied to custom Routes.
+	RouteMetric int
+	// RouteIsolation installs routes into a dedicated routing table selected by policy routing rule instead of
+	// the main table, see RouteIsolationOptions (default: main table). It is supported on Linux only,
+	// custom Routes must implement RuleTable and add routes to the table.
+	RouteIsolation *RouteIsolationOptions
+	// Netns creates TUN device in the Linux network namespace of the name (e.g. DefaultNetns) instead of changing
+	// routes of the system: only programs run in the namespace (see ExecNetns) are tunneled, the rest of the system
+	// is untouched. The namespace is created if it does not exist and kept after Disconnect.
+	Netns string
+	// GatewayMode tunnels traffic of other hosts using this one as their gateway, e.g. containers of a Docker
+	// network routed via the client container (sidecar). IPv4 forwarding is enabled while connected, it is
+	// supported on Linux only.
+	GatewayMode bool
+	// NetworkManager registers the TUN device with NetworkManager and systemd-resolved, see NetworkManagerOptions
+	// (default: not registered). It is supported on Linux only and ignored with Netns.
+	NetworkManager *NetworkManagerOptions
+	// AuditLog is the path of file system changes of the client (TUN device, routes, routing rules, DNS, system proxy,
+	// IP forwarding) are appended to as AuditRecord json lines with their outcome, including the failed ones
+	// (default: not recorded). Unlike Journal it is kept, it is the history of changes made to the machine.
+	AuditLog string
+	// Journal is the path of file recording system changes of the connection (routes, TUN device, system proxy)
+	// until they are undone, so Client.Cleanup can undo them if the process crashes (default: not recorded).
+	Journal string
+	// Upstream is an external SOCKS5 proxy the TUN device is piped into, e.g. run by another program or on
+	// another host of LAN: no XRay core instance or Engine is created and the Connect link is not used
+	// (default: none). UDP is relayed only if the proxy supports UDP ASSOCIATE. XRay core features
+	// (Routing, Reverse, Sniffing, QoS), InboundACL and Dialer are not supported with it.
+	Upstream *Proxy
+	// ECH enables Encrypted Client Hello of TLS connection to the server (default: link parameters "ech" and
+	// "echOuterSni"). It is supported for protocols served by XRay core only.
+	ECH *ECHOptions
+	// ClientCert is the client certificate of mutual TLS authentication to the server (default: none).
+	// It is supported for protocols served by XRay core only.
+	ClientCert *ClientCertOptions
+	// Resolver controls resolving of the server host name: the domain strategy and bootstrap DNS servers
+	// (default: system resolver, any address family).
+	Resolver *ResolverOptions
+	// Obfuscation pads and fragments the connection to the server against blocking by traffic analysis,
+	// at the cost of throughput (default: none). It is supported for protocols served by XRay core only.
+	Obfuscation *ObfuscationOptions
+	// Dialer connects to the server instead of the system dialer, e.g. via an existing corporate proxy,
+	// over a specific interface or through an in-memory transport in tests (default: system dialer).
+	// It is used by XRay core outbound to the server and passed to Engine, see EngineOpts.Dial.
+	Dialer DialFunc
 	// Whether to allow self-signed certificates or not.
 	TLSAllowInsecure bool
 	// Pass logger with debug level to observe debug logs (default: slog.TextHandler).
 	Logger *slog.Logger
+	// LogDedup is the window identical log records (same level, message and attributes) are counted in after
+	// the first one instead of being logged, the count is logged as "message repeated N times" when the window
+	// ends (default: DefaultLogDedupWindow). Negative disables deduplication.
+	LogDedup time.Duration
+	// Logs customize records of the components (default: Logger records with "component" attribute),
+	// see LogOptions.
+	Logs map[LogComponent]*LogOptions
 	// XRayLogType is used to redefine xray core log type (default: LogType_None).
 	XRayLogType xapplog.LogType
+	// TUIC overrides congestion control and UDP relay settings of "tuic://" links (default: link values).
+	TUIC *TUICOptions
+	// VMess overrides cipher and legacy alterId compatibility of "vmess://" links (default: link values).
+	VMess *VMessOptions
+	// SystemProxy points OS proxy settings (macOS networksetup, GNOME gsettings, Windows WinINET)
+	// to the InboundProxy while connected, previous settings are restored on Disconnect.
+	SystemProxy bool
+	// PAC starts PAC file server while connected, see PACOptions (default: disabled).
+	PAC *PACOptions
+	// Check configures connectivity checks, see CheckOptions (default: DefaultCheckURL, no periodic checks).
+	Check *CheckOptions
+	// CaptivePortal enables captive portal detection before connecting and after failed health checks,
+	// see CaptivePortalOptions (default: disabled).
+	CaptivePortal *CaptivePortalOptions
+	// Flows configures idle timeouts of tunneled connections, see FlowOptions (default: DefaultTCPIdleTimeout
+	// and DefaultUDPIdleTimeout).
+	Flows *FlowOptions
+	// QoS marks tunneled traffic with DSCP, see QoSOptions (default: not marked).
+	QoS *QoSOptions
+	// Balancer spreads flows over the servers of ConnectBalanced, see BalancerOptions (default: a destination
+	// stays on its server for DefaultBalancerSticky).
+	Balancer *BalancerOptions
+	// MemoryBudget sizes buffers and flow limit to the memory of the device, see MemoryOptions
+	// (default: sized for desktop, no flow limit).
+	MemoryBudget *MemoryOptions
+	// Pipe configures workers processing packets read from the TUN device, see PipeOptions
+	// (default: processed by the reader).
+	Pipe *PipeOptions
+	// UDP configures relaying of UDP flows to the inbound proxy, see UDPOptions (default: associations are
+	// cached for DefaultUDPSessionCache, none are established ahead).
+	UDP *UDPOptions
+	// QUIC controls QUIC (UDP port 443) of the TUN device, see QUICOptions (default: relayed).
+	QUIC *QUICOptions
+	// Timeouts configure timeouts of proxied connections and TCP keepalive of the server connections,
+	// see TimeoutOptions (default: XRay defaults, connections without data are closed after 5 minutes).
+	Timeouts *TimeoutOptions
+	// Warmup keeps connections to the server warm while connected, see WarmupOptions (default: disabled).
+	Warmup *WarmupOptions
+	// OnEvent is called on Client events (e.g. EventCaptivePortal), it must not block.
+	OnEvent func(Event)
+	// ExitInfoURL is the endpoint queried by Client.ExitInfo (default: DefaultExitInfoURL).
+	// It must return JSON with "ip", "country" and "asn" or "org" fields (like ipinfo.io) or just the IP.
+	ExitInfoURL string
+	// Reverse exposes local services on the remote server via XRay reverse proxy, see ReverseForward.
+	// It is supported for protocols served by XRay core only.
+	Reverse []ReverseForward
+	// Routing routes tunneled connections by destination port, protocol, domain or IP, see RoutingRule.
+	// It is supported for protocols served by XRay core only.
+	Routing []RoutingRule
+	// Reconnect limits retries of OnDemand connection after failures, see ReconnectOptions
+	// (default: backoff from DefaultReconnectMinBackoff, cool-down after DefaultReconnectFailures).
+	Reconnect *ReconnectOptions
+	// RouteVerify verifies routes of the connection read back from RouteLister Config.Routes, see RouteVerifyOptions
+	// (default: verified after connect, missing routes are reported only).
+	RouteVerify *RouteVerifyOptions
+	// ControlRoute routes control traffic of the application made with Client.ControlDialer, see ControlRoute
+	// (default: ControlRouteTunnel).
+	ControlRoute ControlRoute
+	// OnDemand keeps TUN device and routes in standby with the proxy stopped, the connection is established
+	// when the first packet arrives (EventOnDemandConnected is emitted), like "connect on demand" of macOS VPNs.
+	OnDemand bool
+	// Sniffing configures XRay inbound sniffing of destination domains, see SniffingOptions (default: disabled).
+	Sniffing *SniffingOptions
+	// Capture exports the flow log and packets of the tunnel to files, see CaptureOptions (default: disabled).
+	Capture *CaptureOptions
+	// StrictLinkParams makes unknown link query parameters an error, by default they are passed
+	// into the outbound stream settings having the same json key or ignored.
+	StrictLinkParams bool
 }
 
 func (c *Config) apply(new *Config) {
 	if new.GatewayIP != nil {
 		c.GatewayIP = new.GatewayIP
 	}
+	if new.GatewayInterface != "" {
+		c.GatewayInterface = new.GatewayInterface
+	}
+	if new.GatewayWait != 0 {
+		c.GatewayWait = new.GatewayWait
+	}
+	if new.NAT64Prefix != nil {
+		c.NAT64Prefix = new.NAT64Prefix
+	}
 	if new.InboundProxy != nil {
 		c.InboundProxy = new.InboundProxy
 	}
+	if new.InboundACL != nil {
+		c.InboundACL = new.InboundACL
+	}
 	if new.TUNAddress != nil {
 		c.TUNAddress = new.TUNAddress
 	}
+	if new.MTU > 0 {
+		c.MTU = new.MTU
+	}
 	if new.Logger != nil {
 		c.Logger = new.Logger
 	}
+	if new.LogDedup != 0 {
+		c.LogDedup = new.LogDedup
+	}
+	if new.Logs != nil {
+		c.Logs = new.Logs
+	}
 	if new.RoutesToTUN != nil {
 		c.RoutesToTUN = new.RoutesToTUN
 	}
+	if new.Routes != nil {
+		c.Routes = new.Routes
+	}
+	if new.RouteMetric != 0 {
+		c.RouteMetric = new.RouteMetric
+	}
+	if new.RouteIsolation != nil {
+		c.RouteIsolation = new.RouteIsolation
+	}
+	if new.Netns != "" {
+		c.Netns = new.Netns
+	}
+	if new.GatewayMode {
+		c.GatewayMode = true
+	}
+	if new.NetworkManager != nil {
+		c.NetworkManager = new.NetworkManager
+	}
+	if new.Journal != "" {
+		c.Journal = new.Journal
+	}
+	if new.AuditLog != "" {
+		c.AuditLog = new.AuditLog
+	}
 	if new.XRayLogType != xapplog.LogType_None {
 		c.XRayLogType = new.XRayLogType
 	}
+	if new.TUIC != nil {
+		c.TUIC = new.TUIC
+	}
+	if new.VMess != nil {
+		c.VMess = new.VMess
+	}
+	if new.SystemProxy {
+		c.SystemProxy = true
+	}
+	if new.PAC != nil {
+		c.PAC = new.PAC
+	}
+	if new.Check != nil {
+		c.Check = new.Check
+	}
+	if new.CaptivePortal != nil {
+		c.CaptivePortal = new.CaptivePortal
+	}
+	if new.Flows != nil {
+		c.Flows = new.Flows
+	}
+	if new.QoS != nil {
+		c.QoS = new.QoS
+	}
+	if new.Balancer != nil {
+		c.Balancer = new.Balancer
+	}
+	if new.MemoryBudget != nil {
+		c.MemoryBudget = new.MemoryBudget
+	}
+	if new.Pipe != nil {
+		c.Pipe = new.Pipe
+	}
+	if new.UDP != nil {
+		c.UDP = new.UDP
+	}
+	if new.QUIC != nil {
+		c.QUIC = new.QUIC
+	}
+	if new.Timeouts != nil {
+		c.Timeouts = new.Timeouts
+	}
+	if new.Warmup != nil {
+		c.Warmup = new.Warmup
+	}
+	if new.OnEvent != nil {
+		c.OnEvent = new.OnEvent
+	}
+	if new.ExitInfoURL != "" {
+		c.ExitInfoURL = new.ExitInfoURL
+	}
+	if new.Reverse != nil {
+		c.Reverse = new.Reverse
+	}
+	if new.Routing != nil {
+		c.Routing = new.Routing
+	}
+	if new.Sniffing != nil {
+		c.Sniffing = new.Sniffing
+	}
+	if new.Capture != nil {
+		c.Capture = new.Capture
+	}
+	if new.Reconnect != nil {
+		c.Reconnect = new.Reconnect
+	}
+	if new.RouteVerify != nil {
+		c.RouteVerify = new.RouteVerify
+	}
+	if new.ControlRoute != "" {
+		c.ControlRoute = new.ControlRoute
+	}
+	if new.OnDemand {
+		c.OnDemand = true
+	}
+	if new.StrictLinkParams {
+		c.StrictLinkParams = true
+	}
+	if new.Dialer != nil {
+		c.Dialer = new.Dialer
+	}
+	if new.ECH != nil {
+		c.ECH = new.ECH
+	}
+	if new.ClientCert != nil {
+		c.ClientCert = new.ClientCert
+	}
+	if new.Resolver != nil {
+		c.Resolver = new.Resolver
+	}
+	if new.Obfuscation != nil {
+		c.Obfuscation = new.Obfuscation
+	}
+	if new.Upstream != nil {
+		c.Upstream = new.Upstream
+	}
 }
 
 // Client is the actual VPN cl. It manages connections, routing and tunneling of the requests.
@@ -96,57 +395,146 @@ func (c *Config) apply(new *Config) {
 // just adds on existing infrastructure.
 type Client struct {
 	cfg Config
+	// gatewayAuto is set if Config.GatewayIP is discovered rather than set by user, see resolveGateway.
+	gatewayAuto bool
 
 	xInst  runnable
 	xCfg   *xrayproto.GeneralConfig
+	xJSON  *conf.Config // XRay core config, nil for Engine protocols.
 	xSrvIP *net.IPAddr
-	tunnel io.ReadWriteCloser
-	pipe   pipe
-	routes ipTable
+	// xTLS is TLS of the outbound done by the Client dialer, see dialerTLS.
+	xTLS *tls.Config
+	// xDialed is XRay core instance dialing with Config.Dialer or xTLS, see useXrayDialer.
+	xDialed *core.Instance
+	// xStandby is set while xInst is not started yet with Config.OnDemand.
+	xStandby bool
+	xMu      sync.Mutex
+	// aclTarget is the private inbound address XRay core or Engine listens on if InboundACL is set.
+	aclTarget *Proxy
+	// forwardingRestore restores IPv4 forwarding if it was enabled by Config.GatewayMode.
+	forwardingRestore func() error
+	// sysProxyRestore restores OS proxy settings if they were changed by Config.SystemProxy.
+	sysProxyRestore func() error
+	pacServer       *http.Server
+	pacAddr         net.Addr
+	forwards        map[*Forward]struct{}
+	forwardsMu      sync.Mutex
+	health          healthState
+	warmup          warmupState
+	timing          connectTimer
+	reconnect       ReconnectBackoff
+	routeHealth     routeHealth
+	direct          directRoutes
+	flows           *flowTable
+	qos             *qosTable
+	balancer        *balancerTable
+	shards          *packetShards
+	udp             *udpRelay
+	quic            *quicPolicy
+	capture         *captureSession
+	mirror          atomic.Pointer[mirror]
+	// qosClasses are class socks5 inbound addresses of inner DSCP values, see QoSOptions.Classes.
+	qosClasses map[uint8]string
+	// balanced are the links of ConnectBalanced after the first one, balancerServers their socks5 inbound
+	// addresses, see balancerTable.
+	balanced        []string
+	balancerServers []string
+	// portalBypass are routes of captive portal addresses to the default gateway.
+	portalBypass []route.Opts
+	portalMu     sync.Mutex
+	// captive is set while health checks fail because of captive portal.
+	captive bool
+	tunnel  io.ReadWriteCloser
+	tunName string // Name of TUN device created by setupTunnel.
+	// openTUN creates TUN device with routes to it (setupTunnel if nil), tests use in-memory device instead.
+	openTUN func() (io.ReadWriteCloser, error)
+	pipe    pipe
+	routes  RouteTable
+	journal *journal  // Set if Config.Journal is.
+	audit   *auditLog // Set if Config.AuditLog is.
+	// routeRule is the rule selecting routing table of Config.RouteIsolation while connected.
+	routeRule *RouteRule
+	// handover is TUN device handed over by another process, adopted by the next Connect, see Adopt.
+	handover *Handover
 
 	tunnelStopped chan error
 	stopTunnel    func()
+	tornDown      atomic.Bool // Set by tearDown.
+	ending        endState
+	history       historyState
 }
 
 // Proxy will set up XRay inbound.
 type Proxy struct {
 	IP   net.IP // Inbound proxy IP (e.g. 127.0.0.1)
 	Port int    // Inbound proxy port (e.g. 1080)
+	// Path of Unix domain socket to listen on instead of IP and Port (e.g. /run/goxray.sock),
+	// names starting with "@" are Linux abstract sockets.
+	//
+	// The socket is not reachable over the network and can be protected by file permissions,
+	// but UDP can not be relayed over it: DNS falls back to TCP and other UDP traffic is dropped.
+	Path string
+}
+
+// Network returns the network name of the proxy address ("tcp" or "unix").
+func (p *Proxy) Network() string {
+	if p.Path != "" {
+		return "unix"
+	}
+
+	return "tcp"
 }
 
 func (p *Proxy) String() string {
+	if p.Path != "" {
+		return p.Path
+	}
+
 	return fmt.Sprintf("%s:%d", p.IP, p.Port)
 }
 
 // NewClient initializes default Client with default proxy address.
 // If you want more options use Client struct.
 func NewClient() (*Client, error) {
-	gatewayIP, err := gateway.DiscoverGateway()
-	if err != nil {
-		return nil, fmt.Errorf("discover gateway: %w", err)
+	// No IPv4 gateway on IPv6-only networks, the server is connected via NAT64 then (see Config.NAT64Prefix).
+	var gatewayIP *net.IP
+	if ip, err := discoverGateway(""); err == nil {
+		gatewayIP = &ip
 	}
 
-	p, err := pipe2socks.NewPipe(pipe2socks.DefaultOpts)
-	if err != nil {
-		return nil, fmt.Errorf("tun2socks new pipe: %w", err)
-	}
-
-	r, err := route.New()
+	r, err := newOSRouteTable(0, 0)
 	if err != nil {
 		return nil, fmt.Errorf("route new: %w", err)
 	}
 
+	flows := newFlowTable()
+	qos := newQoSTable()
+	balancer := newBalancerTable()
+	shards := newPacketShards()
+	udp := newUDPRelay()
+	quic := newQUICPolicy()
+
 	return &Client{
 		cfg: Config{
-			GatewayIP:    &gatewayIP,
-			InboundProxy: defaultInboundProxy,
+			GatewayIP:    gatewayIP,
+			InboundProxy: &Proxy{IP: defaultInboundProxy.IP, Port: getFreePort()}, // Distinct for every Client.
 			TUNAddress:   defaultTUNAddress,
+			MTU:          tunMTU,
 			RoutesToTUN:  DefaultRoutesToTUN,
-			Logger:       slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			Routes:       r,
+			Logger:       dedupLogger(defaultLogger(), DefaultLogDedupWindow),
+			ExitInfoURL:  DefaultExitInfoURL,
 		},
+		gatewayAuto:   true,
 		tunnelStopped: make(chan error),
-		pipe:          p,
+		pipe:          newSocksPipe(tunMTU, DefaultUDPIdleTimeout, flows, qos, balancer, shards, udp, quic),
 		routes:        r,
+		flows:         flows,
+		qos:           qos,
+		balancer:      balancer,
+		shards:        shards,
+		udp:           udp,
+		quic:          quic,
 	}, nil
 }
 
@@ -158,13 +546,50 @@ func NewClientWithOpts(cfg Config) (*Client, error) {
 	}
 
 	client.cfg.apply(&cfg)
+	if cfg.Logger != nil || cfg.LogDedup != 0 {
+		client.cfg.Logger = dedupLogger(cmp.Or(cfg.Logger, defaultLogger()), cfg.LogDedup)
+	}
+	client.gatewayAuto = cfg.GatewayIP == nil
+	if client.gatewayAuto && cfg.GatewayInterface != "" {
+		client.cfg.GatewayIP = nil // Discovered by Connect if it is not there yet, see Config.GatewayWait.
+		if ip, err := discoverGateway(cfg.GatewayInterface); err == nil {
+			client.cfg.GatewayIP = &ip
+		}
+	}
+	if (cfg.RouteMetric != 0 || cfg.RouteIsolation != nil) && cfg.Routes == nil {
+		var table int
+		if cfg.RouteIsolation != nil {
+			table = cfg.RouteIsolation.Table
+		}
+		if client.cfg.Routes, err = newOSRouteTable(cfg.RouteMetric, table); err != nil {
+			return nil, err
+		}
+	}
+	client.routes = client.cfg.Routes
+	if client.cfg.AuditLog != "" {
+		client.audit = &auditLog{path: client.cfg.AuditLog, logger: client.cfg.Logger}
+		client.routes = auditRoutes{RouteTable: client.routes, a: client.audit}
+	}
+	if client.cfg.Journal != "" {
+		client.journal = &journal{path: client.cfg.Journal, logger: client.cfg.Logger}
+		client.routes = journalRoutes{RouteTable: client.routes, j: client.journal}
+	}
+	client.pipe = newSocksPipe(client.cfg.MTU, client.cfg.Flows.udpIdleTimeout(), client.flows, client.qos, client.balancer,
+		client.shards, client.udp, client.quic)
+	if client.cfg.InboundProxy.Path != "" {
+		client.pipe = newUnixPipe(client.cfg.MTU, client.flows, client.shards)
+	}
 
 	return client, nil
 }
 
 // GatewayIP returns gateway IP used to route outbound traffic through.
-// It is used to route packets destined to XRay remote server.
+// It is used to route packets destined to XRay remote server, nil if there is no IPv4 gateway (IPv6-only network).
 func (c *Client) GatewayIP() net.IP {
+	if c.cfg.GatewayIP == nil {
+		return nil
+	}
+
 	return *c.cfg.GatewayIP
 }
 
@@ -180,78 +605,318 @@ func (c *Client) InboundProxy() Proxy {
 	return *c.cfg.InboundProxy
 }
 
+// instanceInbound returns the address XRay core or Engine serves SOCKS5 on, it is a private loopback
+// address if InboundACL is set (the configured InboundProxy is served by aclInbound then) and
+// Config.Upstream if it is set.
+func (c *Client) instanceInbound() *Proxy {
+	if c.cfg.Upstream != nil {
+		return c.cfg.Upstream
+	}
+	if c.cfg.InboundACL == nil {
+		return c.cfg.InboundProxy
+	}
+	if c.aclTarget == nil {
+		c.aclTarget = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()}
+	}
+
+	return c.aclTarget
+}
+
 // Connect creates a global tunnel and routes all incoming connections (or traffic specified in Config.RoutesToTUN)
-// to the VPN server via newly created defaultInboundProxy.
-func (c *Client) Connect(link string) error {
-	var err error
+// to the VPN server via newly created defaultInboundProxy, or to Config.Upstream (link is not used then).
+func (c *Client) Connect(link string) (err error) {
 	c.cfg.Logger.Debug("Connecting to tunnel", "cfg", c.cfg)
+	c.timing.begin(c.cfg.Logger)
+	c.history.setServer(c.linkServer(link))
+	defer func(start time.Time) {
+		err = withHint(err)
+		if err != nil {
+			c.dropHandover()
+		}
+		// With OnDemand the attempt is made by the first packet, see startOnDemand.
+		if err != nil || !c.cfg.OnDemand {
+			c.recordConnect(start, err)
+		}
+	}(time.Now())
+	// Completed steps are undone in reverse order if a later one fails, so the system is left as it was.
+	var undo rollback
+	if c.journal != nil {
+		if err = c.cleanupCrashed(); err != nil {
+			return err
+		}
+	}
+	if err = c.resolveGateway(); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rbErr := undo.run(); rbErr != nil {
+			c.cfg.Logger.Error("connect rollback failed", "err", rbErr)
+			err = errors.Join(err, fmt.Errorf("rollback: %w", rbErr))
+		}
+	}()
 
-	c.xInst, c.xCfg, err = c.createXrayProxy(link)
+	start := time.Now()
+	c.xInst, c.xCfg, err = c.createProxy(link)
 	if err != nil {
-		c.cfg.Logger.Error("xray core creation failed", "err", err, "xray_config", c.xCfg)
+		c.log(LogXRay).Error("xray core creation failed", "err", err, "xray_config", c.xCfg)
 
 		return fmt.Errorf("create xray core instance: %w", err)
 	}
-	c.cfg.Logger.Debug("xray core instance created", "xray_config", c.xCfg)
-
-	c.cfg.Logger.Debug("starting xray core instance")
-	if err = c.xInst.Start(); err != nil {
-		c.cfg.Logger.Error("xray core instance startup failed", "err", err)
+	c.timing.phase(phaseParse, start)
+	if err = c.claim(); err != nil {
+		return err
+	}
+	undo.add(c.release)
+	if c.capture, err = openCapture(c.cfg.Capture, c.cfg.Logger); err != nil {
+		return fmt.Errorf("capture: %w", err)
+	}
+	undo.add(c.capture.close)
+	c.log(LogXRay).Debug("xray core instance created", "xray_config", c.xCfg)
 
-		return fmt.Errorf("start xray core instance: %w", err)
+	c.xStandby = c.cfg.OnDemand
+	if !c.cfg.OnDemand {
+		if err = c.startProxy(context.Background()); err != nil {
+			return err
+		}
 	}
-	time.Sleep(100 * time.Millisecond) // Sometimes XRay instance should have a bit more time to set up.
-	c.cfg.Logger.Debug("xray core instance started")
+	undo.add(c.closeProxy)
 
-	c.cfg.Logger.Debug("Setting up TUN device")
+	c.log(LogTUN).Debug("Setting up TUN device")
+	start = time.Now()
 	// Create TUN and route all traffic to it.
-	c.tunnel, err = c.setupTunnel()
+	openTUN := c.openTUN
+	if openTUN == nil {
+		openTUN = c.setupTunnel
+	}
+	c.tunnel, err = openTUN()
 	if err != nil {
-		c.cfg.Logger.Error("TUN creation failed", "err", err)
+		c.log(LogTUN).Error("TUN creation failed", "err", err)
 
 		return fmt.Errorf("setup TUN device: %w", err)
 	}
-	c.tunnel = newReaderMetrics(c.tunnel)
-	c.cfg.Logger.Debug("TUN device created")
+	c.tunnel = c.journal.device(c.tunnel, c.cfg.TUNAddress.IP.String())
+	undo.add(c.tunnel.Close) // Routes to TUN device are removed with it.
+	c.tunnel = newReaderMetrics(c.capture.wrap(&mirrorTunnel{ReadWriteCloser: c.tunnel, mirror: &c.mirror}))
+	c.log(LogTUN).Debug("TUN device created")
+	start = c.timing.phase(phaseCreateTUN, start)
 
-	c.cfg.Logger.Debug("adding routes for TUN device")
+	c.log(LogRoute).Debug("adding routes for TUN device")
 	// Set XRay remote address to be routed through the default gateway, so that we don't get a loop.
-	_ = c.routes.Delete(c.xrayToGatewayRoute()) // In case previous run failed.
-	c.cfg.Logger.Debug("deleted dangling routes")
-	err = c.routes.Add(c.xrayToGatewayRoute())
-	if err != nil {
-		c.cfg.Logger.Error("routing xray server IP to default route failed", "err", err, "route", c.xrayToGatewayRoute())
+	if c.serverRouteNeeded(c.xSrvIP.IP) && !c.serverRouteShared() {
+		_ = c.routes.Delete(c.xrayToGatewayRoute()) // In case previous run failed.
+		c.log(LogRoute).Debug("deleted dangling routes")
+		err = c.routes.Add(c.xrayToGatewayRoute())
+		if err != nil {
+			c.log(LogRoute).Error("routing xray server IP to default route failed", "err", err, "route", c.xrayToGatewayRoute())
+
+			return fmt.Errorf("add xray server route exception: %w", err)
+		}
+		c.log(LogRoute).Debug("routing xray server IP to default route")
+	}
+	undo.add(c.deleteServerRoute)
+
+	if c.cfg.RouteIsolation != nil {
+		if err = c.addRouteRule(); err != nil {
+			return err
+		}
+		undo.add(c.deleteRouteRule)
+	}
 
-		return fmt.Errorf("add xray server route exception: %w", err)
+	if c.cfg.GatewayMode {
+		c.forwardingRestore, err = enableForwarding()
+		c.audit.record(AuditForwardingEnable, "net.ipv4.ip_forward", err)
+		if err != nil {
+			return fmt.Errorf("gateway mode: ip forwarding: %w", err)
+		}
+		undo.add(c.restoreForwarding)
+		c.cfg.Logger.Info("gateway mode, traffic of hosts routed via this one is tunneled")
 	}
-	c.cfg.Logger.Debug("routing xray server IP to default route")
+	c.timing.phase(phaseAddRoutes, start)
 
+	c.flows.setLimit(c.cfg.Flows)
+	c.flows.setMemory(c.cfg.MemoryBudget.plan())
+	c.setGoMemLimit()
+	c.shards.setOptions(c.cfg.Pipe)
+	c.udp.setOptions(c.cfg.UDP)
+	c.udp.setTimeouts(c.cfg.Flows.socksTimeouts())
+	c.quic.setOptions(c.cfg.QUIC)
+	c.reconnect.SetOptions(c.cfg.Reconnect)
+	c.flows.setLog(c.capture.log())
+	c.flows.setTimer(&c.timing)
+	if c.xJSON != nil {
+		c.qos.setClasses(c.qosClasses)
+		c.balancer.setServers(c.balancerServers, c.cfg.Balancer.sticky())
+	} else {
+		c.qos.setClasses(nil) // Engine protocols do not support QoS.
+		c.balancer.setServers(nil, 0)
+	}
 	var wg sync.WaitGroup
 	wg.Add(1)
 	var ctx context.Context
 	ctx, c.stopTunnel = context.WithCancel(context.Background())
+	c.tornDown.Store(false)
+	c.begin()
+	guard := &tunnelGuard{c: c, cancel: c.stopTunnel}
+	tunnel := c.tunnel
+	if c.cfg.OnDemand {
+		tunnel = newDemandTunnel(c.tunnel, c.cfg.MTU, func() error { return c.startOnDemand(ctx, guard) }, &c.reconnect)
+		c.cfg.Logger.Info("standing by, connecting on the first packet")
+	}
 	go func() {
 		wg.Done()
-		c.tunnelStopped <- c.pipe.Copy(ctx, c.tunnel, c.cfg.InboundProxy.String())
-		c.cfg.Logger.Debug("tunnel pipe closed", "err", err)
+		err := c.copyTunnel(ctx, tunnel, guard)
+		if ctx.Err() == nil {
+			c.log(LogPipe).Warn("tunnel stopped unexpectedly", "err", err)
+			c.end(DisconnectTunnelEOF, err)
+		}
+		c.tunnelStopped <- err
+		c.log(LogPipe).Debug("tunnel pipe closed", "err", err)
 	}()
 	wg.Wait()
+	go func() {
+		defer guard.recover("flow reaper")
+		c.flows.runReaper(ctx, c.cfg.Flows.tcpIdleTimeout(), c.cfg.Flows.udpIdleTimeout(), c.log(LogPipe))
+	}()
+	c.verifyRoutes(ctx, guard)
+	c.watchRoutes(ctx, guard)
+	if !c.cfg.OnDemand {
+		c.startServices(ctx, guard)
+	}
+	c.timing.phase(phaseConnected, time.Time{})
 	c.cfg.Logger.Debug("client connected")
 
 	return nil
 }
 
+// copyTunnel copies packets between tunnel and the proxy until ctx is done or tunnel is closed,
+// a panic of the pipe is recovered by guard and returned as error.
+func (c *Client) copyTunnel(ctx context.Context, tunnel io.ReadWriteCloser, guard *tunnelGuard) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = guard.panicked("tunnel pipe", v)
+		}
+	}()
+
+	return c.pipe.Copy(ctx, tunnel, c.instanceInbound().String())
+}
+
+// startProxy starts XRay core instance or Engine, after the captive portal is gone if Config.CaptivePortal is set.
+// It fails if ctx is done, e.g. the client disconnected meanwhile.
+func (c *Client) startProxy(ctx context.Context) error {
+	if c.cfg.CaptivePortal != nil {
+		if err := c.awaitCaptivePortal(); err != nil {
+			return err
+		}
+	}
+
+	c.xMu.Lock()
+	defer c.xMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.log(LogXRay).Debug("starting xray core instance")
+	start := time.Now()
+	if err := c.xInst.Start(); err != nil {
+		c.log(LogXRay).Error("xray core instance startup failed", "err", err)
+
+		return fmt.Errorf("start xray core instance: %w", err)
+	}
+	c.xStandby = false
+	time.Sleep(100 * time.Millisecond) // Sometimes XRay instance should have a bit more time to set up.
+	c.timing.phase(phaseStartProxy, start)
+	c.log(LogXRay).Debug("xray core instance started")
+
+	return nil
+}
+
+// startServices starts services of the established connection.
+func (c *Client) startServices(ctx context.Context, guard *tunnelGuard) {
+	go func() {
+		defer guard.recover("health checks")
+		c.runHealthChecks(ctx)
+	}()
+	go func() {
+		defer guard.recover("warm-up")
+		c.runWarmup(ctx)
+	}()
+	// PAC and system proxy are for applications using the proxy directly, traffic is tunneled anyway.
+	if err := c.startPAC(); err != nil {
+		c.cfg.Logger.Warn("pac server setup failed", "err", err)
+	}
+	if c.cfg.SystemProxy {
+		if err := c.enableSystemProxy(); err != nil {
+			c.cfg.Logger.Warn("system proxy setup failed", "err", err)
+		}
+	}
+}
+
+// startOnDemand establishes connection of Config.OnDemand on the first packet, ctx is the tunnel context.
+func (c *Client) startOnDemand(ctx context.Context, guard *tunnelGuard) error {
+	c.cfg.Logger.Info("traffic arrived, connecting on demand")
+	start := time.Now()
+	if err := c.startProxy(ctx); err != nil {
+		c.recordConnect(start, err)
+		delay, failures, coolDown := c.reconnect.Failed(time.Now())
+		c.emit(Event{Type: EventOnDemandFailed, Message: "connect on demand failed", Attrs: map[string]string{
+			"err": err.Error(), "retry_in": delay.String(),
+		}})
+		if coolDown {
+			c.cfg.Logger.Warn("connecting keeps failing, cooling down", "failures", failures, "retry_in", delay)
+			c.emit(Event{Type: EventReconnectCoolDown, Message: "connecting keeps failing, cooling down", Attrs: map[string]string{
+				"failures": strconv.Itoa(failures), "retry_in": delay.String(),
+			}})
+		}
+
+		return err
+	}
+	c.reconnect.Succeeded()
+	c.recordConnect(start, nil)
+	c.startServices(ctx, guard)
+	c.emit(Event{Type: EventOnDemandConnected, Message: "connected on demand"})
+
+	return nil
+}
+
+// closeProxy stops XRay core instance or Engine unless it is in standby.
+func (c *Client) closeProxy() error {
+	c.xMu.Lock()
+	defer c.xMu.Unlock()
+	if c.xDialed != nil {
+		dropXrayDialer(c.xDialed)
+		c.xDialed = nil
+	}
+	if c.xStandby {
+		return nil
+	}
+
+	return c.xInst.Close()
+}
+
 // Disconnect stops all listeners and cleans up route for XRay server.
+// Active TCP connections are given FlowOptions.DrainTimeout to finish first, see FlowStats.ForceClosed.
+// Resources are released in the reverse order of Connect: services of the connection, route for XRay server,
+// TUN device with its routes, XRay core instance (or Engine) and capture files. Every step is attempted
+// even if a previous one fails, the errors are joined.
 //
 // It will block till all resources are done processing or
-// context is cancelled (method also enforces timeout of disconnectTimeout)
+// context is cancelled (method also enforces timeout of disconnectTimeout).
+// The connection ends with DisconnectUser reason, see DisconnectWithReason.
 func (c *Client) Disconnect(ctx context.Context) error {
-	if c.stopTunnel == nil {
-		return nil // not connected
-	}
+	return c.DisconnectWithReason(ctx, DisconnectUser, nil)
+}
 
+// disconnect releases resources of the connection, see Disconnect.
+func (c *Client) disconnect(ctx context.Context) error {
+	drained, closed := c.flows.drain(ctx, c.cfg.Flows.drainTimeout())
+	if drained+closed > 0 {
+		c.log(LogPipe).Info("flows drained", "finished", drained, "closed", closed)
+	}
 	c.stopTunnel()
-	err := errors.Join(c.xInst.Close(), c.tunnel.Close(), c.routes.Delete(c.xrayToGatewayRoute()))
+	c.stopTunnel = nil
+	err := errors.Join(c.closeForwards(), c.restoreSystemProxy(), c.stopPAC(ctx), c.restoreForwarding(), c.tearDown())
 
 	// Waiting till the tunnel actually done with processing connections.
 	ctx, cancel := context.WithTimeout(ctx, disconnectTimeout)
@@ -262,6 +927,8 @@ func (c *Client) Disconnect(ctx context.Context) error {
 	case <-ctx.Done():
 		err = errors.Join(ctx.Err(), err)
 	}
+	c.flows.setLog(nil)
+	err = errors.Join(err, c.capture.close())
 
 	if err != nil {
 		c.cfg.Logger.Error("client disconnect encountered failures", "err", err)
@@ -292,54 +959,395 @@ func (c *Client) BytesWritten() int {
 	return c.tunnel.(*readerMetrics).BytesWritten()
 }
 
+// tearDown removes routes and closes TUN device and the proxy of the connection. It is done once per connection,
+// by Disconnect or right after a panic of the connection goroutines (see tunnelGuard).
+func (c *Client) tearDown() error {
+	if !c.tornDown.CompareAndSwap(false, true) {
+		return nil
+	}
+	c.routeHealth.stop()
+
+	return errors.Join(c.deleteRouteRule(), c.removeCaptivePortalBypass(), c.direct.close(c.routes), c.deleteServerRoute(), c.tunnel.Close(),
+		c.closeProxy(), c.release())
+}
+
+// restoreForwarding restores IPv4 forwarding changed by Config.GatewayMode.
+func (c *Client) restoreForwarding() error {
+	if c.forwardingRestore == nil {
+		return nil
+	}
+	err := c.forwardingRestore()
+	c.forwardingRestore = nil
+	c.audit.record(AuditForwardingRestore, "net.ipv4.ip_forward", err)
+	if err != nil {
+		return fmt.Errorf("restore ip forwarding: %w", err)
+	}
+
+	return nil
+}
+
+// rollback undoes completed steps of Connect.
+type rollback []func() error
+
+// add adds undo of a completed step.
+func (r *rollback) add(undo func() error) {
+	*r = append(*r, undo)
+}
+
+// run undoes the steps in reverse order, every step is undone even if a previous one fails.
+func (r rollback) run() error {
+	var err error
+	for i := len(r) - 1; i >= 0; i-- {
+		err = errors.Join(err, r[i]())
+	}
+
+	return err
+}
+
 // xrayToGatewayRoute is a setup to route VPN requests to gateway.
 // Used as exception to not interfere with traffic going to remote XRay instance.
 func (c *Client) xrayToGatewayRoute() route.Opts {
-	// Append "/32" to match only the XRay server route.
-	return route.Opts{Gateway: *c.cfg.GatewayIP, Routes: []*route.Addr{route.MustParseAddr(c.xSrvIP.String() + "/32")}}
+	// Host route ("/32" or "/128") to match only the XRay server route.
+	return route.Opts{Gateway: *c.cfg.GatewayIP, Routes: []*route.Addr{hostRoute(c.xSrvIP.IP)}}
+}
+
+// deleteServerRoute deletes the route added by Connect, see xrayToGatewayRoute.
+func (c *Client) deleteServerRoute() error {
+	if !c.serverRouteNeeded(c.xSrvIP.IP) || c.serverRouteShared() {
+		return nil
+	}
+
+	return c.routes.Delete(c.xrayToGatewayRoute())
+}
+
+// createProxy creates proxy instance from connection link, either by registered Engine or by XRay core.
+func (c *Client) createProxy(link string) (xrayproto.Instance, *xrayproto.GeneralConfig, error) {
+	spec, err := c.parseLink(link)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ip, synthesized, err := c.resolveServer(spec.general.Address, spec.general.Port)
+	if err != nil {
+		return nil, nil, fmt.Errorf("server address not resolvable: %w", err)
+	}
+	if synthesized && spec.engine != nil {
+		return nil, nil, fmt.Errorf("%w: nat64: not supported for %s", ErrInvalidConfig, spec.general.Protocol)
+	}
+
+	var inst xrayproto.Instance = spec.engine
+	if spec.xray != nil {
+		if synthesized || c.cfg.Resolver.pinIP() {
+			if err = useServerIP(spec.xray, spec.general.Address, ip); err != nil {
+				return nil, nil, fmt.Errorf("server ip: %w", err)
+			}
+		}
+		if c.cfg.Resolver.strategy() != DomainStrategyAsIs {
+			pinServerFamily(spec.xray, spec.general.Address, ip)
+		}
+		x, err := newXrayInstance(spec.xray)
+		if err != nil {
+			return nil, nil, fmt.Errorf("make instance: %w", err)
+		}
+		inst = x
+		if c.cfg.Dialer != nil || c.xTLS != nil {
+			useXrayDialer(x, xrayDialer{tag: spec.xray.OutboundConfigs[0].Tag, dial: c.cfg.Dialer, tls: c.xTLS})
+			c.xDialed = x
+		}
+		if c.cfg.InboundProxy.Path != "" {
+			inst = newUnixInbound(x, c.cfg.InboundProxy.Path)
+		}
+	}
+	if c.cfg.InboundACL != nil {
+		inst = newACLInbound(inst, c.cfg.InboundProxy, c.instanceInbound(), c.cfg.InboundACL, c.cfg.Logger)
+	}
+
+	c.xSrvIP = &net.IPAddr{IP: ip}
+	c.xJSON = spec.xray
+
+	return inst, spec.general, nil
 }
 
-// createXrayProxy creates XRay instance from connection link with additional proxy listening on {addr}:{port}.
-func (c *Client) createXrayProxy(link string) (xrayproto.Instance, *xrayproto.GeneralConfig, error) {
+// proxySpec is connection link parsed into either Engine or XRay core config.
+type proxySpec struct {
+	engine  Engine       // Set for protocols served by registered Engine.
+	xray    *conf.Config // Set for protocols served by XRay core.
+	general *xrayproto.GeneralConfig
+}
+
+// parseLink parses connection link into proxy spec, nothing is started.
+func (c *Client) parseLink(link string) (*proxySpec, error) {
+	if acl := c.cfg.InboundACL; acl != nil {
+		if err := acl.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: inbound acl: %w", ErrInvalidConfig, err)
+		}
+		if c.cfg.InboundProxy.Path != "" {
+			return nil, fmt.Errorf("%w: inbound acl: not supported for unix socket inbound", ErrInvalidConfig)
+		}
+	}
+
+	if c.cfg.Check != nil {
+		if err := c.cfg.Check.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: check: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.CaptivePortal != nil {
+		if err := c.cfg.CaptivePortal.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: captive portal: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.Flows != nil {
+		if err := c.cfg.Flows.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: flows: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.MemoryBudget != nil {
+		if err := c.cfg.MemoryBudget.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: memory budget: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.Pipe != nil {
+		if err := c.cfg.Pipe.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: pipe: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.UDP != nil {
+		if err := c.cfg.UDP.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: udp: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.QUIC != nil {
+		if err := c.cfg.QUIC.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: quic: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.Timeouts != nil {
+		if err := c.cfg.Timeouts.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: timeouts: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.Reconnect != nil {
+		if err := c.cfg.Reconnect.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: reconnect: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.Warmup != nil {
+		if err := c.cfg.Warmup.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: warmup: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if err := c.cfg.ControlRoute.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	if c.cfg.RouteVerify != nil {
+		if err := c.cfg.RouteVerify.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: route verify: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.QoS != nil {
+		if err := c.cfg.QoS.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: qos: %w", ErrInvalidConfig, err)
+		}
+		// Class inbounds are loopback TCP ports any local process could use.
+		if len(c.cfg.QoS.Classes) > 0 && c.cfg.InboundProxy.Path != "" {
+			return nil, fmt.Errorf("%w: qos: dscp classes are not supported for unix socket inbound", ErrInvalidConfig)
+		}
+		if len(c.cfg.QoS.Classes) > 0 && c.cfg.InboundACL != nil {
+			return nil, fmt.Errorf("%w: qos: dscp classes are not supported with inbound acl", ErrInvalidConfig)
+		}
+	}
+
+	if c.cfg.Balancer != nil {
+		if err := c.cfg.Balancer.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: balancer: %w", ErrInvalidConfig, err)
+		}
+	}
+	if len(c.balanced) > 0 && c.cfg.Upstream != nil {
+		return nil, fmt.Errorf("%w: balancer: not supported with upstream proxy", ErrInvalidConfig)
+	}
+
+	if c.cfg.RouteIsolation != nil {
+		if err := c.cfg.RouteIsolation.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: route isolation: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.Capture != nil {
+		if err := c.cfg.Capture.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: capture: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.ECH != nil {
+		if err := c.cfg.ECH.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: ech: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.ClientCert != nil {
+		if err := c.cfg.ClientCert.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: client cert: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.Resolver != nil {
+		if err := c.cfg.Resolver.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: resolver: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.Obfuscation != nil {
+		if err := c.cfg.Obfuscation.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: obfuscation: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.cfg.Sniffing != nil {
+		if err := c.cfg.Sniffing.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: sniffing: %w", ErrInvalidConfig, err)
+		}
+		if c.cfg.Sniffing.Enabled && c.cfg.InboundProxy.Path != "" {
+			return nil, fmt.Errorf("%w: sniffing: not supported for unix socket inbound", ErrInvalidConfig)
+		}
+	}
+
+	if c.cfg.Upstream != nil {
+		return c.upstreamSpec()
+	}
+
+	link, err := normalizeWireGuardLink(strings.TrimSpace(link))
+	if err != nil {
+		return nil, fmt.Errorf("%w: wireguard: %w", ErrInvalidConfig, err)
+	}
+	scheme, _, _ := strings.Cut(link, "://")
+
+	factory, ok := lookupEngine(scheme)
+	if !ok {
+		if validate, ok := engineOnlyLinks[scheme]; ok {
+			// Protocol is not implemented by XRay core, validate the link to give a meaningful error.
+			if err := validate(link); err != nil {
+				return nil, fmt.Errorf("%w: parse: %w", ErrInvalidConfig, err)
+			}
+
+			return nil, fmt.Errorf("%w: protocol create: %s: %w", ErrInvalidConfig, scheme, ErrNoEngine)
+		}
+
+		xCfg, general, err := c.buildXrayConfig(link)
+		if err != nil {
+			return nil, err
+		}
+
+		return &proxySpec{xray: xCfg, general: general}, nil
+	}
+
+	if err := c.engineUnsupported(scheme); err != nil {
+		return nil, err
+	}
+
+	eng, err := c.createEngine(factory, link)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxySpec{
+		engine:  eng,
+		general: &xrayproto.GeneralConfig{Protocol: scheme, Address: eng.ServerAddr(), OrigLink: link},
+	}, nil
+}
+
+// engineUnsupported returns error if the Config has XRay core only features, protocol is served without it.
+func (c *Client) engineUnsupported(protocol string) error {
+	if len(c.cfg.Reverse) > 0 {
+		return fmt.Errorf("%w: reverse: not supported for %s", ErrInvalidConfig, protocol)
+	}
+	if len(c.cfg.Routing) > 0 {
+		return fmt.Errorf("%w: routing: not supported for %s", ErrInvalidConfig, protocol)
+	}
+	if c.cfg.Sniffing != nil && c.cfg.Sniffing.Enabled {
+		return fmt.Errorf("%w: sniffing: not supported for %s", ErrInvalidConfig, protocol)
+	}
+	if q := c.cfg.QoS; q != nil && (q.DSCP > 0 || len(q.Classes) > 0) {
+		return fmt.Errorf("%w: qos: not supported for %s", ErrInvalidConfig, protocol)
+	}
+	if c.cfg.ECH != nil {
+		return fmt.Errorf("%w: ech: not supported for %s", ErrInvalidConfig, protocol)
+	}
+	if c.cfg.ClientCert != nil {
+		return fmt.Errorf("%w: client cert: not supported for %s", ErrInvalidConfig, protocol)
+	}
+	if c.cfg.Obfuscation != nil {
+		return fmt.Errorf("%w: obfuscation: not supported for %s", ErrInvalidConfig, protocol)
+	}
+	if len(c.balanced) > 0 {
+		return fmt.Errorf("%w: balancer: not supported for %s", ErrInvalidConfig, protocol)
+	}
+
+	return nil
+}
+
+// buildXrayConfig parses XRay link and builds XRay core config for it.
+func (c *Client) buildXrayConfig(link string) (*conf.Config, *xrayproto.GeneralConfig, error) {
+	if c.cfg.VMess != nil {
+		if err := c.cfg.VMess.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("%w: vmess options: %w", ErrInvalidConfig, err)
+		}
+	}
+
 	// Make the inbound for local proxy.
 	// We will later use it to redirect all traffic from TUN device to this proxy.
 	inbound := &xray.Socks{
 		Remark:  "GoXRay-TUN-Listener",
-		Address: c.cfg.InboundProxy.IP.String(),
-		Port:    strconv.Itoa(c.cfg.InboundProxy.Port),
+		Address: c.instanceInbound().IP.String(),
+		Port:    strconv.Itoa(c.instanceInbound().Port),
 	}
 
-	svc := xray.NewXrayService(true,
-		c.cfg.TLSAllowInsecure,
-		xray.WithCustomLogLevel(c.cfg.XRayLogType, xRayLogLevel(c.cfg.Logger.Handler())),
-		xray.WithInbound(inbound),
-	)
+	svc := xray.NewXrayService(true, c.cfg.TLSAllowInsecure)
+
+	link, err := normalizeTrojanGoLink(link)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: parse: %w", ErrInvalidConfig, err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: parse: %w", ErrInvalidConfig, err)
+	}
 
-	link = strings.TrimSpace(link)
 	protocol, err := svc.CreateProtocol(link)
 	if err != nil {
-		return nil, nil, fmt.Errorf("invalid config: protocol create: %w", err)
+		return nil, nil, fmt.Errorf("%w: protocol create: %w", ErrInvalidConfig, err)
 	}
 
 	if err := protocol.Parse(); err != nil {
-		return nil, nil, fmt.Errorf("invalid config: parse: %w", err)
+		return nil, nil, fmt.Errorf("%w: parse: %w", ErrInvalidConfig, err)
 	}
 
 	cfg := protocol.ConvertToGeneralConfig()
-
-	inst, err := svc.MakeInstance(protocol)
-	if err != nil {
-		return nil, nil, fmt.Errorf("make instance: %w", err)
+	if cfg.Port == "" {
+		// Some protocols (e.g. wireguard) report server address as "host:port".
+		if host, port, err := net.SplitHostPort(cfg.Address); err == nil {
+			cfg.Address, cfg.Port = host, port
+		}
 	}
+	cfg.Address = strings.TrimSuffix(strings.TrimPrefix(cfg.Address, "["), "]")
 
-	// Validate xray proto addr.
-	ip, err := net.ResolveIPAddr("ip", cfg.Address)
+	xCfg, err := c.xrayConfig(protocol.(xray.Protocol), inbound, u)
 	if err != nil {
-		return nil, nil, fmt.Errorf("xray address not resolvable: %w", err)
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
 	}
-	c.xSrvIP = ip
 
-	return inst, &cfg, nil
+	return xCfg, &cfg, nil
 }
 
 // xRayLogLevel maps slog.Level to xray core log level (xcommlog.Severity) by checking Config.Logger level.
@@ -359,22 +1367,53 @@ func xRayLogLevel(h slog.Handler) xcommlog.Severity {
 	return xcommlog.Severity_Unknown
 }
 
-// setupTunnel creates new TUN interface in the system and routes all traffic to it.
-func (c *Client) setupTunnel() (*tun.Interface, error) {
-	ifc, err := tun.New("", 1500)
+// setupTunnel creates new TUN interface in the system (or Config.Netns), or adopts the one handed over to
+// the Client (see Adopt), and routes all traffic to it.
+func (c *Client) setupTunnel() (io.ReadWriteCloser, error) {
+	if c.handover != nil {
+		return c.adoptTunnel()
+	}
+	if c.cfg.Netns != "" {
+		return c.netnsTunnel()
+	}
+
+	return c.createTunnel()
+}
+
+// createTunnel creates TUN device with routes to it in the network namespace of the calling thread.
+func (c *Client) createTunnel() (io.ReadWriteCloser, error) {
+	if err := prepareTUN(); err != nil {
+		return nil, err
+	}
+	ifc, err := tun.New("", c.cfg.MTU)
 	if err != nil {
-		return nil, fmt.Errorf("create tun: %w", err)
+		err = fmt.Errorf("create tun: %w%s", err, containerHint("run with --cap-add NET_ADMIN"))
+		c.audit.record(AuditTUNCreate, c.cfg.TUNAddress.String(), err)
+		// The cause is not wrapped by the TUN package, it is missing privileges mostly.
+		return nil, &HintError{Err: err, Hint: privilegesHint()}
 	}
 
+	target := ifc.Name() + " " + c.cfg.TUNAddress.String()
 	if err = ifc.Up(c.cfg.TUNAddress, c.cfg.TUNAddress.IP); err != nil {
-		return nil, fmt.Errorf("setup interface: %w", err)
+		err = fmt.Errorf("setup interface: %w", err)
+		c.audit.record(AuditTUNCreate, target, err)
+		return nil, errors.Join(err, ifc.Close())
+	}
+	c.audit.record(AuditTUNCreate, target, nil)
+	dev := c.audit.device(ifc, target)
+	// Registered before routes are added, managed device routes are removed by NetworkManager.
+	if c.cfg.NetworkManager != nil && c.cfg.Netns == "" {
+		if err = c.registerDevice(ifc.Name()); err != nil {
+			return nil, errors.Join(err, dev.Close())
+		}
 	}
 
 	if err = c.routes.Add(route.Opts{IfName: ifc.Name(), Routes: c.cfg.RoutesToTUN}); err != nil {
-		return nil, fmt.Errorf("add route: %w", err)
+		return nil, errors.Join(fmt.Errorf("add route: %w", err), dev.Close())
 	}
+	c.tunName = ifc.Name()
 
-	return ifc, nil
+	return dev, nil
 }
 
 func getFreePort() int {
@@ -31,6 +31,7 @@ func TestConnect_InvalidLink(t *testing.T) {
 
 	err = cl.Connect("vless://example.com") // no port
 	require.ErrorContains(t, err, "invalid config: parse:")
+	require.ErrorIs(t, err, ErrInvalidConfig)
 }
 
 func TestDisconnect_NonConnected(t *testing.T) {
@@ -42,7 +43,7 @@ func TestDisconnect_CtxTimeout(t *testing.T) {
 	tests := []struct {
 		name        string
 		stopTunFunc func(stopped chan error)
-		setupMocks  func(*Client, *mocks.Mockrunnable, *mocks.Mockpipe, *mocks.MockipTable, *mocks.MockioReadWriteCloser)
+		setupMocks  func(*Client, *mocks.Mockrunnable, *mocks.Mockpipe, *mocks.MockRouteTable, *mocks.MockioReadWriteCloser)
 		assert      func(ctx context.Context, cl *Client, t *testing.T)
 	}{
 		{
@@ -50,7 +51,7 @@ func TestDisconnect_CtxTimeout(t *testing.T) {
 			stopTunFunc: func(stopped chan error) {
 				stopped <- nil
 			},
-			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockipTable, rwc *mocks.MockioReadWriteCloser) {
+			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockRouteTable, rwc *mocks.MockioReadWriteCloser) {
 				r.EXPECT().Close().Return(nil)
 				rwc.EXPECT().Close().Return(nil)
 				mockSuccessDisconnectIP(t, cl, ip)
@@ -62,7 +63,7 @@ func TestDisconnect_CtxTimeout(t *testing.T) {
 		{
 			name:        "ctx timeout",
 			stopTunFunc: func(stopped chan error) {},
-			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockipTable, rwc *mocks.MockioReadWriteCloser) {
+			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockRouteTable, rwc *mocks.MockioReadWriteCloser) {
 				r.EXPECT().Close().Return(nil)
 				rwc.EXPECT().Close().Return(nil)
 				mockSuccessDisconnectIP(t, cl, ip)
@@ -76,7 +77,7 @@ func TestDisconnect_CtxTimeout(t *testing.T) {
 			stopTunFunc: func(stopped chan error) {
 				stopped <- nil
 			},
-			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockipTable, rwc *mocks.MockioReadWriteCloser) {
+			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockRouteTable, rwc *mocks.MockioReadWriteCloser) {
 				r.EXPECT().Close().Return(errors.New("instance close err"))
 				rwc.EXPECT().Close().Return(nil)
 				mockSuccessDisconnectIP(t, cl, ip)
@@ -90,7 +91,7 @@ func TestDisconnect_CtxTimeout(t *testing.T) {
 			stopTunFunc: func(stopped chan error) {
 				stopped <- nil
 			},
-			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockipTable, rwc *mocks.MockioReadWriteCloser) {
+			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockRouteTable, rwc *mocks.MockioReadWriteCloser) {
 				r.EXPECT().Close().Return(nil)
 				rwc.EXPECT().Close().Return(errors.New("tun close err"))
 				mockSuccessDisconnectIP(t, cl, ip)
@@ -99,12 +100,28 @@ func TestDisconnect_CtxTimeout(t *testing.T) {
 				require.ErrorContains(t, cl.Disconnect(ctx), "tun close err")
 			},
 		},
+		{
+			name: "error from system proxy restore",
+			stopTunFunc: func(stopped chan error) {
+				stopped <- nil
+			},
+			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockRouteTable, rwc *mocks.MockioReadWriteCloser) {
+				cl.sysProxyRestore = func() error { return errors.New("gsettings err") }
+				r.EXPECT().Close().Return(nil)
+				rwc.EXPECT().Close().Return(nil)
+				mockSuccessDisconnectIP(t, cl, ip)
+			},
+			assert: func(ctx context.Context, cl *Client, t *testing.T) {
+				require.ErrorContains(t, cl.Disconnect(ctx), "restore system proxy: gsettings err")
+				require.Nil(t, cl.sysProxyRestore)
+			},
+		},
 		{
 			name: "error from everything",
 			stopTunFunc: func(stopped chan error) {
 				stopped <- errors.New("stop err")
 			},
-			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockipTable, rwc *mocks.MockioReadWriteCloser) {
+			setupMocks: func(cl *Client, r *mocks.Mockrunnable, _ *mocks.Mockpipe, ip *mocks.MockRouteTable, rwc *mocks.MockioReadWriteCloser) {
 				r.EXPECT().Close().Return(errors.New("instance close err"))
 				rwc.EXPECT().Close().Return(errors.New("tun close err"))
 				mockSuccessDisconnectIP(t, cl, ip)
@@ -124,7 +141,7 @@ func TestDisconnect_CtxTimeout(t *testing.T) {
 
 			xInstMock := mocks.NewMockrunnable(gomock.NewController(t))
 			pipeMock := mocks.NewMockpipe(gomock.NewController(t))
-			routesMock := mocks.NewMockipTable(gomock.NewController(t))
+			routesMock := mocks.NewMockRouteTable(gomock.NewController(t))
 			tunMock := mocks.NewMockioReadWriteCloser(gomock.NewController(t))
 
 			cl := newTestClient(xInstMock, tunMock, routesMock, pipeMock, test.stopTunFunc)
@@ -138,7 +155,7 @@ func TestDisconnect_CtxTimeout(t *testing.T) {
 	}
 }
 
-func newTestClient(xInst runnable, tun io.ReadWriteCloser, routes ipTable, pipe pipe, stopTunnel func(chan error)) *Client {
+func newTestClient(xInst runnable, tun io.ReadWriteCloser, routes RouteTable, pipe pipe, stopTunnel func(chan error)) *Client {
 	expGateway := &net.IP{127, 0, 0, 2}
 	expProxy := &Proxy{IP: net.IP{127, 0, 0, 1}, Port: 10234}
 	expGeneralConfig := &xkp.GeneralConfig{Address: "127.0.0.3"}
@@ -148,6 +165,7 @@ func newTestClient(xInst runnable, tun io.ReadWriteCloser, routes ipTable, pipe
 			Logger:       slog.New(slog.NewTextHandler(os.Stdout, nil)),
 			InboundProxy: expProxy,
 			GatewayIP:    expGateway,
+			MTU:          tunMTU,
 		},
 		tunnelStopped: make(chan error),
 		xInst:         xInst,
@@ -155,6 +173,13 @@ func newTestClient(xInst runnable, tun io.ReadWriteCloser, routes ipTable, pipe
 		routes:        routes,
 		pipe:          pipe,
 		xCfg:          expGeneralConfig,
+		xSrvIP:        &net.IPAddr{IP: net.ParseIP(expGeneralConfig.Address)},
+		flows:         newFlowTable(),
+		qos:           newQoSTable(),
+		balancer:      newBalancerTable(),
+		shards:        newPacketShards(),
+		udp:           newUDPRelay(),
+		quic:          newQUICPolicy(),
 	}
 	if stopTunnel != nil {
 		cl.stopTunnel = func() {
@@ -167,7 +192,7 @@ func newTestClient(xInst runnable, tun io.ReadWriteCloser, routes ipTable, pipe
 	return cl
 }
 
-func mockSuccessDisconnectIP(t *testing.T, cl *Client, ip *mocks.MockipTable) {
+func mockSuccessDisconnectIP(t *testing.T, cl *Client, ip *mocks.MockRouteTable) {
 	ip.EXPECT().Delete(gomock.Any()).DoAndReturn(func(opts route.Opts) error {
 		require.Empty(t, opts.IfName)
 		require.Equal(t, *cl.cfg.GatewayIP, opts.Gateway)
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/xtls/xray-core/infra/conf"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// ClientCertOptions is the client certificate of mutual TLS authentication to the server, exactly one of CertFile,
+// Keystore and Certificate is set.
+//
+// XRay core does not send client certificates, TLS of the outbound is done by the Client dialer then: it is supported
+// for "tls" security over tcp, raw, ws, httpupgrade and grpc transports, fingerprint ("fp") is not applied.
+type ClientCertOptions struct {
+	// CertFile is PEM file with the certificate chain and optionally the key, or PKCS#12 file (.p12, .pfx).
+	CertFile string
+	// KeyFile is PEM file with the private key (default: the key of CertFile).
+	KeyFile string
+	// Password of PKCS#12 CertFile.
+	Password string
+	// Keystore is the subject common name of the certificate in the OS keystore: the keychains on macOS or
+	// the personal store of the current user on Windows. The private key must be exportable.
+	Keystore string
+	// Certificate is the certificate with its key, e.g. crypto.Signer of a hardware token.
+	Certificate *tls.Certificate
+}
+
+// Validate checks options values.
+func (o *ClientCertOptions) Validate() error {
+	sources := 0
+	for _, set := range []bool{o.CertFile != "", o.Keystore != "", o.Certificate != nil} {
+		if set {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		return errors.New("certificate file, keystore name or certificate is required")
+	case sources > 1:
+		return errors.New("certificate file, keystore name and certificate are exclusive")
+	case o.CertFile == "" && (o.KeyFile != "" || o.Password != ""):
+		return errors.New("key file and password require certificate file")
+	}
+
+	return nil
+}
+
+// certificate loads the certificate with its key.
+func (o *ClientCertOptions) certificate() (tls.Certificate, error) {
+	switch {
+	case o.Certificate != nil:
+		return *o.Certificate, nil
+	case o.Keystore != "":
+		password := make([]byte, 16)
+		_, _ = rand.Read(password)
+		data, err := keystoreExport(o.Keystore, hex.EncodeToString(password))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("keystore: %w", err)
+		}
+
+		return pkcs12Certificate(data, hex.EncodeToString(password), o.Keystore)
+	}
+
+	data, err := os.ReadFile(o.CertFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if !bytes.Contains(data, []byte("-----BEGIN ")) {
+		return pkcs12Certificate(data, o.Password, "")
+	}
+	key := data
+	if o.KeyFile != "" {
+		if key, err = os.ReadFile(o.KeyFile); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	return tls.X509KeyPair(data, key)
+}
+
+// pkcs12Certificate returns the certificate with its key of PKCS#12 data, the other certificates without keys are
+// its chain. The certificate has the subject common name name, if it is set, there must be the single one otherwise.
+func pkcs12Certificate(data []byte, password, name string) (tls.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("pkcs12: %w", err)
+	}
+
+	keys := map[string]*pem.Block{}
+	for _, b := range blocks {
+		if b.Type == "PRIVATE KEY" {
+			keys[b.Headers["localKeyId"]] = b
+		}
+	}
+	var leaf, key *pem.Block
+	var chain []byte
+	for _, b := range blocks {
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		k, ok := keys[b.Headers["localKeyId"]]
+		if !ok {
+			chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: b.Type, Bytes: b.Bytes})...)
+			continue
+		}
+		if name != "" {
+			cert, err := x509.ParseCertificate(b.Bytes)
+			if err != nil || cert.Subject.CommonName != name {
+				continue
+			}
+		}
+		if leaf != nil {
+			return tls.Certificate{}, fmt.Errorf("pkcs12: several certificates with keys named %q", name)
+		}
+		leaf, key = b, k
+	}
+	if leaf == nil {
+		return tls.Certificate{}, fmt.Errorf("pkcs12: no certificate with key named %q", name)
+	}
+
+	certs := append(pem.EncodeToMemory(&pem.Block{Type: leaf.Type, Bytes: leaf.Bytes}), chain...)
+
+	return tls.X509KeyPair(certs, pem.EncodeToMemory(&pem.Block{Type: key.Type, Bytes: key.Bytes}))
+}
+
+// clientCertTLS adds the client certificate of opts to cfg, TLS of the outbound with server host is moved to
+// the Client dialer if cfg is nil, see dialerTLS.
+func clientCertTLS(out *conf.OutboundDetourConfig, cfg *tls.Config, opts *ClientCertOptions, host string) (*tls.Config, error) {
+	cert, err := opts.certificate()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		if cfg, err = dialerTLS(out, host); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Certificates = []tls.Certificate{cert}
+
+	return cfg, nil
+}
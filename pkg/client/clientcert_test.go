@@ -0,0 +1,202 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+	"golang.org/x/net/proxy"
+)
+
+// testPKCS12 is PKCS#12 of self-signed certificate "tun client" with EC key encrypted with "secret":
+//
+//	openssl pkcs12 -export -legacy -inkey key.pem -in cert.pem -passout pass:secret
+const testPKCS12 = "MIIDggIBAzCCA0gGCSqGSIb3DQEHAaCCAzkEggM1MIIDMTCCAicGCSqGSIb3DQEHBqCCAhgwggIUAgEAMIICDQYJKoZIhvcNAQcB" +
+	"MBwGCiqGSIb3DQEMAQYwDgQIoi98vdwLDqgCAggAgIIB4CK2f5z9raL/RZAM3UjS14I28wnKXN+sI5aneSrlQ2+xIfCvLQmaEBr1" +
+	"JjjFHPjIFIG22T8LZvhXJo7QV7i2uIUo1skYVKjPqM02JRKb0T6+3xTw3l7biawZkH99wNUXajirLXxXtOO6ybYu3tSdJ5H2ZmSe" +
+	"HHtG0Fjvniq0dGq58bjG9ii47zXgibaMQnSAFSyUXx73FQZKvOE6bgIP+v11/gSy96ZfWhKFRe5PYdYJlA90emNXFDrwua+cM5lt" +
+	"0JZ4LZCSJ+F4D0qY4VFeHYIj66oRRlPq/26c/Az57O/WGA9jTxNuF/E/DjlyXQYdDPob7GdeIq+h5nE5IGi7MF/ERrmZ+RtmGBpm" +
+	"q+cnXkp+voN9ol4ywRRNYk/Kv6m1LKfN3dcS0XhSOgOpua0UptTX0WaMMU2d+AP7NGZVW2ZSlH+BeOGL/18PcFJi7DNmCQfMXifq" +
+	"C5G0AYK3ZQX9k6ZNaiMUtQL32wVJqhDtSMBfMikKwxqXQoN4BXaWnCnv9591GQG/caSFIXRfrSPINePTb4QjyeKpmgjr8+BYBvW2" +
+	"+TPL31X6ntBKr8PDd+ecTFxpm91j2/zI0U9evjWcWlXCElQORgls1csKlylaP0khYpD+FMs/a7WLmP1V6nFL7DCCAQIGCSqGSIb3" +
+	"DQEHAaCB9ASB8TCB7jCB6wYLKoZIhvcNAQwKAQKggbQwgbEwHAYKKoZIhvcNAQwBAzAOBAi/OTTUXYocWQICCAAEgZAInXLYERvi" +
+	"UF/1UuLxh8XnNeX7qU7ZBC1uF3HWjzhn5YLWF6pxE5RfRs1u2rpuzAQhku15UjSWClR5n2C5fZQRekYa8HfkkGrg1nbygMwUyp4R" +
+	"HKkHD+Xw0E4ZlLQsePFdD57Q2ANv9tolc+9X9G/Stv7Tbrw7LqSjtFQ9BRgW3DhUOeGV7NLfcx01jaR4rDQxJTAjBgkqhkiG9w0B" +
+	"CRUxFgQUD29EuiGlQvWLcS29zNH8qdsL+A0wMTAhMAkGBSsOAwIaBQAEFDNU/7AcFA5RutWzxuieB3QhmJjIBAgSQR9YVsxj0AIC" +
+	"CAA="
+
+// newTestCert returns self-signed certificate of common name cn valid for DNS names.
+func newTestCert(t *testing.T, cn string, names ...string) tls.Certificate {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: cn}, DNSNames: names,
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv, Leaf: leaf}
+}
+
+// writeTestCert writes cert and its key into PEM files of dir.
+func writeTestCert(t *testing.T, dir string, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+
+	return certFile, keyFile
+}
+
+func TestClientCertOptions_certificate(t *testing.T) {
+	dir := t.TempDir()
+	cert := newTestCert(t, "tun client")
+	certFile, keyFile := writeTestCert(t, dir, cert)
+
+	got, err := (&ClientCertOptions{CertFile: certFile, KeyFile: keyFile}).certificate()
+	require.NoError(t, err)
+	require.Equal(t, cert.Certificate, got.Certificate)
+
+	both := filepath.Join(dir, "both.pem")
+	certPEM, _ := os.ReadFile(certFile)
+	keyPEM, _ := os.ReadFile(keyFile)
+	require.NoError(t, os.WriteFile(both, append(certPEM, keyPEM...), 0o600))
+	got, err = (&ClientCertOptions{CertFile: both}).certificate()
+	require.NoError(t, err)
+	require.Equal(t, cert.Certificate, got.Certificate, "the key is read from the certificate file")
+
+	p12 := filepath.Join(dir, "client.p12")
+	data, _ := base64.StdEncoding.DecodeString(testPKCS12)
+	require.NoError(t, os.WriteFile(p12, data, 0o600))
+	got, err = (&ClientCertOptions{CertFile: p12, Password: "secret"}).certificate()
+	require.NoError(t, err)
+	require.Equal(t, "tun client", got.Leaf.Subject.CommonName)
+	got, err = pkcs12Certificate(data, "secret", "tun client")
+	require.NoError(t, err)
+	require.Equal(t, "tun client", got.Leaf.Subject.CommonName)
+	_, err = pkcs12Certificate(data, "secret", "other")
+	require.ErrorContains(t, err, `no certificate with key named "other"`)
+	_, err = (&ClientCertOptions{CertFile: p12, Password: "wrong"}).certificate()
+	require.ErrorContains(t, err, "pkcs12: pkcs12: decryption password incorrect")
+
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" {
+		_, err = (&ClientCertOptions{Keystore: "tun client"}).certificate()
+		require.ErrorContains(t, err, "keystore: not supported on "+runtime.GOOS)
+	}
+
+	for _, o := range []ClientCertOptions{
+		{},
+		{CertFile: certFile, Keystore: "tun client"},
+		{Keystore: "tun client", Certificate: &cert},
+		{Keystore: "tun client", Password: "secret"},
+		{KeyFile: keyFile, Certificate: &cert},
+	} {
+		require.Error(t, o.Validate(), o)
+	}
+}
+
+func TestClient_ClientCert(t *testing.T) {
+	cert := newTestCert(t, "tun client")
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.ClientCert = &ClientCertOptions{Certificate: &cert}
+
+	spec, err := cl.parseLink("trojan://pass@secret.example.com:443?security=tls&type=ws&path=%2Fws&alpn=h2")
+	require.NoError(t, err)
+	stream := spec.xray.OutboundConfigs[0].StreamSetting
+	require.Equal(t, "none", stream.Security, "tls is done by the dialer")
+	require.Equal(t, "secret.example.com", cl.xTLS.ServerName)
+	require.Equal(t, []string{"h2"}, cl.xTLS.NextProtos)
+	require.Equal(t, []tls.Certificate{cert}, cl.xTLS.Certificates)
+
+	key := newTestECHKey(t, 1, "cdn.example.com")
+	cl.cfg.ECH = &ECHOptions{ConfigList: testECHConfigList(key.Config)}
+	_, err = cl.parseLink("trojan://pass@secret.example.com:443?security=tls")
+	require.NoError(t, err)
+	require.NotEmpty(t, cl.xTLS.EncryptedClientHelloConfigList)
+	require.Equal(t, []tls.Certificate{cert}, cl.xTLS.Certificates, "client certificate is sent with ech")
+	cl.cfg.ECH = nil
+
+	_, err = cl.parseLink("vless://b831381d-6324-4d53-ad4f-8cda48b30811@127.0.0.5:443?security=reality&sni=hidden.example.com&pbk=" +
+		testRealityKey)
+	require.ErrorContains(t, err, "invalid config: client cert: requires tls security")
+	cl.cfg.ClientCert = &ClientCertOptions{CertFile: filepath.Join(t.TempDir(), "missing.pem")}
+	_, err = cl.parseLink("trojan://pass@secret.example.com:443?security=tls")
+	require.ErrorContains(t, err, "invalid config: client cert:")
+}
+
+func TestXraySystemDialer_ClientCert(t *testing.T) {
+	server, client := newTestCert(t, "server", "secret.example.com"), newTestCert(t, "tun client")
+	clients := x509.NewCertPool()
+	clients.AddCert(client.Leaf)
+
+	accepted := make(chan tls.ConnectionState, 1)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clients,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if err = conn.(*tls.Conn).Handshake(); err != nil {
+			return
+		}
+		accepted <- conn.(*tls.Conn).ConnectionState()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	socket := filepath.Join(t.TempDir(), "inbound.sock")
+	x, err := newXrayInstance(&conf.Config{OutboundConfigs: []conf.OutboundDetourConfig{{Protocol: "freedom", Tag: "proxy"}}})
+	require.NoError(t, err)
+	useXrayDialer(x, xrayDialer{tag: "proxy", tls: &tls.Config{
+		ServerName: "secret.example.com", InsecureSkipVerify: true, Certificates: []tls.Certificate{client},
+	}})
+	t.Cleanup(func() { dropXrayDialer(x) })
+	in := newUnixInbound(x, socket)
+	require.NoError(t, in.Start())
+	t.Cleanup(func() { _ = in.Close() })
+
+	socks, err := proxy.SOCKS5("unix", socket, nil, &net.Dialer{})
+	require.NoError(t, err)
+	conn, err := socks.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+
+	state := <-accepted
+	require.Equal(t, "tun client", state.PeerCertificates[0].Subject.CommonName)
+}
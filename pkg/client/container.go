@@ -0,0 +1,45 @@
+package client
+
+import (
+	"os"
+	"strings"
+)
+
+// containerMarkers are files present in containers: Docker and Podman.
+var containerMarkers = []string{"/.dockerenv", "/run/.containerenv"}
+
+// cgroupPath lists cgroups of the process, container runtimes name them after the container.
+var cgroupPath = "/proc/1/cgroup"
+
+// InContainer reports whether the process runs in a container (Docker, Podman, Kubernetes, LXC).
+// Errors of the client then suggest container settings, like "--device /dev/net/tun".
+func InContainer() bool {
+	if os.Getenv("container") != "" { // Set by Podman, LXC and systemd-nspawn.
+		return true
+	}
+	for _, path := range containerMarkers {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	b, err := os.ReadFile(cgroupPath)
+	if err != nil {
+		return false
+	}
+	for _, runtime := range []string{"docker", "kubepods", "containerd", "libpod", "lxc"} {
+		if strings.Contains(string(b), runtime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containerHint returns hint appended to errors of missing container permissions, empty outside of containers.
+func containerHint(hint string) string {
+	if !InContainer() {
+		return ""
+	}
+
+	return " (container: " + hint + ")"
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// tunDevicePath is the TUN clone device, it is missing in containers unless passed with "--device".
+	tunDevicePath = "/dev/net/tun"
+	// ipForwardPath is the IPv4 forwarding sysctl, see Config.GatewayMode.
+	ipForwardPath = "/proc/sys/net/ipv4/ip_forward"
+	// ipv4RoutesPath is the IPv4 routing table, see hasDefaultRoute.
+	ipv4RoutesPath = "/proc/net/route"
+)
+
+// prepareTUN creates TUN clone device if it is missing, it requires CAP_MKNOD (granted to Docker containers
+// by default). With a pre-created device only NET_ADMIN is needed.
+func prepareTUN() error {
+	if _, err := os.Stat(tunDevicePath); !errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(tunDevicePath), 0o755)
+	if err == nil {
+		err = unix.Mknod(tunDevicePath, unix.S_IFCHR|0o600, int(unix.Mkdev(10, 200)))
+	}
+	if err != nil {
+		return fmt.Errorf("create %s: %w%s", tunDevicePath, err, containerHint("run with --device /dev/net/tun --cap-add NET_ADMIN"))
+	}
+
+	return nil
+}
+
+// enableForwarding enables IPv4 forwarding and returns function restoring the previous setting.
+func enableForwarding() (restore func() error, err error) {
+	b, err := os.ReadFile(ipForwardPath)
+	if err != nil {
+		return nil, err
+	}
+	prev := strings.TrimSpace(string(b))
+	if prev == "1" {
+		return func() error { return nil }, nil
+	}
+	if err = os.WriteFile(ipForwardPath, []byte("1"), 0o644); err != nil {
+		return nil, fmt.Errorf("%w%s", err, containerHint("run with --sysctl net.ipv4.ip_forward=1"))
+	}
+
+	return func() error { return os.WriteFile(ipForwardPath, []byte(prev), 0o644) }, nil
+}
+
+// hasDefaultRoute reports whether there is an IPv4 default route. Containers on internal networks have none,
+// they reach the server via a specific route. It is assumed there is one if the table cannot be read.
+func hasDefaultRoute() bool {
+	b, err := os.ReadFile(ipv4RoutesPath)
+	if err != nil {
+		return true
+	}
+	for _, line := range strings.Split(string(b), "\n")[1:] {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		if f := strings.Fields(line); len(f) > 7 && f[1] == "00000000" && f[7] == "00000000" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareTUN(t *testing.T) {
+	prev := tunDevicePath
+	t.Cleanup(func() { tunDevicePath = prev })
+
+	tunDevicePath = filepath.Join(t.TempDir(), "tun")
+	require.NoError(t, os.WriteFile(tunDevicePath, nil, 0o600))
+	require.NoError(t, prepareTUN(), "pre-created device is used")
+
+	tunDevicePath = filepath.Join(t.TempDir(), "net", "tun")
+	if err := prepareTUN(); err != nil {
+		require.ErrorContains(t, err, "create "+tunDevicePath)
+		return
+	}
+	info, err := os.Stat(tunDevicePath)
+	require.NoError(t, err)
+	require.NotZero(t, info.Mode()&os.ModeCharDevice)
+}
+
+func TestEnableForwarding(t *testing.T) {
+	prev := ipForwardPath
+	t.Cleanup(func() { ipForwardPath = prev })
+	ipForwardPath = filepath.Join(t.TempDir(), "ip_forward")
+
+	require.NoError(t, os.WriteFile(ipForwardPath, []byte("0\n"), 0o600))
+	restore, err := enableForwarding()
+	require.NoError(t, err)
+	b, _ := os.ReadFile(ipForwardPath)
+	require.Equal(t, "1", string(b))
+	require.NoError(t, restore())
+	b, _ = os.ReadFile(ipForwardPath)
+	require.Equal(t, "0", string(b))
+
+	require.NoError(t, os.WriteFile(ipForwardPath, []byte("1\n"), 0o600))
+	restore, err = enableForwarding()
+	require.NoError(t, err)
+	require.NoError(t, restore())
+	b, _ = os.ReadFile(ipForwardPath)
+	require.Equal(t, "1\n", string(b), "already enabled, left as is")
+}
+
+func TestHasDefaultRoute(t *testing.T) {
+	prev := ipv4RoutesPath
+	t.Cleanup(func() { ipv4RoutesPath = prev })
+	ipv4RoutesPath = filepath.Join(t.TempDir(), "route")
+
+	require.True(t, hasDefaultRoute(), "unknown")
+
+	const header = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"
+	internal := header + "eth0\t000012AC\t00000000\t0001\t0\t0\t0\t0000FFFF\t0\t0\t0\n"
+	require.NoError(t, os.WriteFile(ipv4RoutesPath, []byte(internal), 0o600))
+	require.False(t, hasDefaultRoute())
+
+	bridge := internal + "eth0\t00000000\t010012AC\t0003\t0\t0\t0\t00000000\t0\t0\t0\n"
+	require.NoError(t, os.WriteFile(ipv4RoutesPath, []byte(bridge), 0o600))
+	require.True(t, hasDefaultRoute())
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package client
+
+import (
+	"errors"
+)
+
+func prepareTUN() error {
+	return nil
+}
+
+func enableForwarding() (restore func() error, err error) {
+	return nil, errors.New("gateway mode is supported on Linux only")
+}
+
+func hasDefaultRoute() bool {
+	return true
+}
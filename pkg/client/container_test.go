@@ -0,0 +1,35 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInContainer(t *testing.T) {
+	dir := t.TempDir()
+	prevMarkers, prevCgroup := containerMarkers, cgroupPath
+	t.Cleanup(func() { containerMarkers, cgroupPath = prevMarkers, prevCgroup })
+	t.Setenv("container", "")
+	containerMarkers = []string{filepath.Join(dir, ".dockerenv")}
+	cgroupPath = filepath.Join(dir, "cgroup")
+
+	require.False(t, InContainer())
+	require.Empty(t, containerHint("hint"))
+
+	require.NoError(t, os.WriteFile(cgroupPath, []byte("0::/init.scope\n"), 0o600))
+	require.False(t, InContainer())
+	require.NoError(t, os.WriteFile(cgroupPath, []byte("0::/kubepods/besteffort/pod1\n"), 0o600))
+	require.True(t, InContainer())
+	require.Equal(t, " (container: hint)", containerHint("hint"))
+
+	require.NoError(t, os.Remove(cgroupPath))
+	require.NoError(t, os.WriteFile(containerMarkers[0], nil, 0o600))
+	require.True(t, InContainer())
+
+	require.NoError(t, os.Remove(containerMarkers[0]))
+	t.Setenv("container", "podman")
+	require.True(t, InContainer())
+}
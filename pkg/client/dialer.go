@@ -0,0 +1,140 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// DialFunc dials the upstream connection to the server, e.g. (&net.Dialer{}).DialContext,
+// DialContext of golang.org/x/net/proxy dialers or an in-memory transport in tests.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// xrayDialers are Config.Dialer and TLS of XRay core instances by the instance, see xraySystemDialer.
+var (
+	xrayDialersMu   sync.RWMutex
+	xrayDialers     = map[*core.Instance]xrayDialer{}
+	xrayDialerSetup sync.Once
+)
+
+type xrayDialer struct {
+	tag  string      // Outbound to the server, other outbounds (e.g. direct routing) are dialed as usual.
+	dial DialFunc    // Config.Dialer, nil: the system dialer.
+	tls  *tls.Config // TLS the connection is secured with, see dialerTLS.
+}
+
+// xraySystemDialer is XRay core system dialer passing outbound connections of the instances with Config.Dialer
+// to it and securing them with the dialer TLS. XRay core has the single system dialer for the process, the instance is told
+// by the dial context.
+type xraySystemDialer struct {
+	*internet.DefaultSystemDialer
+}
+
+func (d xraySystemDialer) Dial(ctx context.Context, src xnet.Address, dest xnet.Destination, sockopt *internet.SocketConfig) (net.Conn, error) {
+	xrayDialersMu.RLock()
+	dialer, ok := xrayDialers[core.FromContext(ctx)]
+	xrayDialersMu.RUnlock()
+	if outbounds := session.OutboundsFromContext(ctx); !ok || len(outbounds) == 0 || outbounds[len(outbounds)-1].Tag != dialer.tag {
+		return d.DefaultSystemDialer.Dial(ctx, src, dest, sockopt)
+	}
+
+	var conn net.Conn
+	var err error
+	if dialer.dial != nil {
+		conn, err = dialer.dial(ctx, dest.Network.SystemString(), dest.NetAddr())
+	} else {
+		conn, err = d.DefaultSystemDialer.Dial(ctx, src, dest, sockopt)
+	}
+	if err != nil || dialer.tls == nil {
+		return conn, err
+	}
+
+	return dialerHandshake(ctx, conn, dialer.tls)
+}
+
+// useXrayDialer makes instance dial outbound dialer.tag with dialer until the instance is forgotten by dropXrayDialer.
+func useXrayDialer(inst *core.Instance, dialer xrayDialer) {
+	xrayDialerSetup.Do(func() {
+		internet.UseAlternativeSystemDialer(xraySystemDialer{DefaultSystemDialer: &internet.DefaultSystemDialer{}})
+	})
+	xrayDialersMu.Lock()
+	xrayDialers[inst] = dialer
+	xrayDialersMu.Unlock()
+}
+
+func dropXrayDialer(inst *core.Instance) {
+	xrayDialersMu.Lock()
+	delete(xrayDialers, inst)
+	xrayDialersMu.Unlock()
+}
+
+// dialerNetworkALPN are transports supported with TLS of the dialer by the default ALPN they negotiate.
+var dialerNetworkALPN = map[string][]string{
+	"tcp":         {"h2", "http/1.1"},
+	"raw":         {"h2", "http/1.1"},
+	"ws":          {"http/1.1"},
+	"httpupgrade": {"http/1.1"},
+	"grpc":        {"h2"},
+}
+
+// dialerTLS moves TLS of the outbound with server host to the Client dialer for the TLS features XRay core
+// does not have (ECH, client certificates), the outbound sends its stream over the connection secured by the dialer
+// then. TLS settings of the outbound are used for the handshake, fingerprint ("fp") is not applied.
+func dialerTLS(out *conf.OutboundDetourConfig, host string) (*tls.Config, error) {
+	s := out.StreamSetting
+	if s == nil || s.Security != "tls" || s.TLSSettings == nil {
+		return nil, errors.New("requires tls security")
+	}
+	network := "tcp"
+	if s.Network != nil && *s.Network != "" {
+		network = string(*s.Network)
+	}
+	alpn, ok := dialerNetworkALPN[network]
+	if !ok {
+		return nil, fmt.Errorf("not supported for %s transport", network)
+	}
+
+	t := s.TLSSettings
+	if t.PinnedPeerCertificateChainSha256 != nil || t.PinnedPeerCertificatePublicKeySha256 != nil ||
+		len(t.VerifyPeerCertInNames) > 0 || len(t.Certs) > 0 {
+		return nil, errors.New("pinned and custom certificates are not supported")
+	}
+	if t.ALPN != nil && len(*t.ALPN) > 0 {
+		alpn = *t.ALPN
+	}
+
+	cfg := &tls.Config{
+		ServerName:         cmp.Or(t.ServerName, host),
+		NextProtos:         alpn,
+		InsecureSkipVerify: t.Insecure,
+	}
+	s.Security, s.TLSSettings = "none", nil
+
+	return cfg, nil
+}
+
+// dialerHandshake secures conn with cfg, ECH rejected by the server is an error.
+func dialerHandshake(ctx context.Context, conn net.Conn, cfg *tls.Config) (net.Conn, error) {
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+
+		var rejected *tls.ECHRejectionError
+		if errors.As(err, &rejected) {
+			return nil, fmt.Errorf("ech rejected by the server, the config list may be outdated: %w", err)
+		}
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+
+	return tc, nil
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+	"golang.org/x/net/proxy"
+)
+
+func TestXraySystemDialer(t *testing.T) {
+	echo := startTestEchoServer(t)
+	var (
+		mu     sync.Mutex
+		dialed []string
+	)
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, network+" "+address)
+		mu.Unlock()
+		return (&net.Dialer{}).DialContext(ctx, "tcp", echo) // Chained upstream.
+	}
+
+	socket := filepath.Join(t.TempDir(), "inbound.sock")
+	x, err := newXrayInstance(&conf.Config{OutboundConfigs: []conf.OutboundDetourConfig{{Protocol: "freedom", Tag: "proxy"}}})
+	require.NoError(t, err)
+	useXrayDialer(x, xrayDialer{tag: "proxy", dial: dial})
+	in := newUnixInbound(x, socket)
+	require.NoError(t, in.Start())
+	t.Cleanup(func() { _ = in.Close() })
+
+	socks, err := proxy.SOCKS5("unix", socket, nil, &net.Dialer{})
+	require.NoError(t, err)
+	ping := func(addr string) {
+		conn, err := socks.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		got := make([]byte, 4)
+		_, err = io.ReadFull(conn, got)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(got))
+	}
+
+	ping("upstream.invalid:443")
+	mu.Lock()
+	require.Equal(t, []string{"tcp upstream.invalid:443"}, dialed)
+	mu.Unlock()
+
+	dropXrayDialer(x)
+	ping(echo)
+	mu.Lock()
+	require.Len(t, dialed, 1, "system dialer is used once the instance is forgotten")
+	mu.Unlock()
+}
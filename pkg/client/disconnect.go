@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DisconnectReason tells why a connection ended, see EventDisconnected and Client.LastDisconnect.
+type DisconnectReason string
+
+// Disconnect reasons detected by the Client.
+const (
+	// DisconnectUser is the reason of Client.Disconnect.
+	DisconnectUser DisconnectReason = "user"
+	// DisconnectTunnelEOF is the reason if TUN device was closed or failed to read, e.g. it was removed
+	// by another program. Routes to it are gone with it, Client.Disconnect releases the rest.
+	DisconnectTunnelEOF DisconnectReason = "tunnel_eof"
+	// DisconnectPanic is the reason if a goroutine of the connection panicked, see EventTunnelPanic.
+	DisconnectPanic DisconnectReason = "panic"
+	// DisconnectHandover is the reason of Client.Handover, TUN device of the connection is kept for another process.
+	DisconnectHandover DisconnectReason = "handover"
+)
+
+// Disconnect reasons of applications disconnecting with Client.DisconnectWithReason.
+const (
+	// DisconnectXRayError is the reason if XRay core or Engine failed.
+	DisconnectXRayError DisconnectReason = "xray_error"
+	// DisconnectHealthCheck is the reason if connectivity checks failed, see Client.Check.
+	DisconnectHealthCheck DisconnectReason = "health_check"
+	// DisconnectQuota is the reason if a traffic or time quota is exceeded.
+	DisconnectQuota DisconnectReason = "quota_exceeded"
+	// DisconnectNetworkChange is the reason if the network changed (e.g. switched to another Wi-Fi).
+	DisconnectNetworkChange DisconnectReason = "network_change"
+	// DisconnectWatchdog is the reason if the connection is torn down to be rebuilt because it passes no traffic
+	// and health checks keep failing.
+	DisconnectWatchdog DisconnectReason = "watchdog"
+)
+
+// DisconnectInfo describes how a connection ended.
+type DisconnectInfo struct {
+	Reason DisconnectReason `json:"reason"`
+	Err    string           `json:"err,omitempty"` // The cause, empty if there is none.
+	Time   time.Time        `json:"time"`
+}
+
+// endState holds how the connection ended.
+type endState struct {
+	mu    sync.Mutex
+	ended bool // The current connection ended, the first reason is kept.
+	last  *DisconnectInfo
+}
+
+// DisconnectWithReason is Disconnect telling why the connection ends, e.g. DisconnectQuota,
+// cause is the error which led to it (may be nil). See EventDisconnected and LastDisconnect.
+func (c *Client) DisconnectWithReason(ctx context.Context, reason DisconnectReason, cause error) error {
+	if c.stopTunnel == nil {
+		return nil // not connected
+	}
+
+	err := c.disconnect(ctx)
+	c.end(reason, cause)
+
+	return err
+}
+
+// LastDisconnect returns how the last connection ended, nil if none ended yet.
+func (c *Client) LastDisconnect() *DisconnectInfo {
+	c.ending.mu.Lock()
+	defer c.ending.mu.Unlock()
+	if c.ending.last == nil {
+		return nil
+	}
+	info := *c.ending.last
+
+	return &info
+}
+
+// end records why the current connection ended and emits EventDisconnected, once per connection.
+func (c *Client) end(reason DisconnectReason, cause error) {
+	info := &DisconnectInfo{Reason: reason, Time: time.Now()}
+	if cause != nil {
+		info.Err = cause.Error()
+	}
+
+	c.ending.mu.Lock()
+	if c.ending.ended {
+		c.ending.mu.Unlock()
+		return
+	}
+	c.ending.ended, c.ending.last = true, info
+	c.ending.mu.Unlock()
+	c.recordDisconnect(info)
+
+	attrs := map[string]string{"reason": string(reason)}
+	if info.Err != "" {
+		attrs["err"] = info.Err
+	}
+	c.emit(Event{Type: EventDisconnected, Message: "disconnected", Attrs: attrs})
+}
+
+// begin starts recording how the new connection ends.
+func (c *Client) begin() {
+	c.ending.mu.Lock()
+	defer c.ending.mu.Unlock()
+	c.ending.ended = false
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_DisconnectWithReason(t *testing.T) {
+	c, _, _, _ := newLoopbackClient(t)
+	var events []Event
+	c.cfg.OnEvent = func(ev Event) { events = append(events, ev) }
+	require.Nil(t, c.LastDisconnect())
+
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	require.NoError(t, c.DisconnectWithReason(context.Background(), DisconnectQuota, errors.New("2 GiB used")))
+	require.Equal(t, []Event{{
+		Type: EventDisconnected, Time: events[0].Time, Message: "disconnected",
+		Attrs: map[string]string{"reason": "quota_exceeded", "err": "2 GiB used"},
+	}}, events)
+	last := c.LastDisconnect()
+	require.Equal(t, &DisconnectInfo{Reason: DisconnectQuota, Err: "2 GiB used", Time: last.Time}, last)
+
+	require.NoError(t, c.Disconnect(context.Background()), "not connected")
+	require.Len(t, events, 1)
+
+	c.openTUN = func() (io.ReadWriteCloser, error) { return newMemTUN(), nil }
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	require.NoError(t, c.Disconnect(context.Background()))
+	require.Len(t, events, 2)
+	require.Equal(t, DisconnectUser, c.LastDisconnect().Reason)
+}
+
+func TestClient_tunnelEOF(t *testing.T) {
+	c, tun, _, _ := newLoopbackClient(t)
+	events := make(chan Event, 1)
+	c.cfg.OnEvent = func(ev Event) { events <- ev }
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+
+	require.NoError(t, tun.Close()) // E.g. removed by another program.
+	select {
+	case ev := <-events:
+		require.Equal(t, EventDisconnected, ev.Type)
+		require.Equal(t, "tunnel_eof", ev.Attrs["reason"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no disconnected event")
+	}
+	require.NoError(t, c.Disconnect(context.Background()))
+	require.Equal(t, DisconnectTunnelEOF, c.LastDisconnect().Reason)
+}
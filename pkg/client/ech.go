@@ -0,0 +1,149 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// echConfigVersion is the ECHConfig version supported by crypto/tls, configs of other versions are skipped.
+const echConfigVersion = 0xfe0d
+
+// ECHOptions enables Encrypted Client Hello of TLS connection to the server: the server name, ALPN and the rest
+// of the handshake are encrypted, only the outer SNI (public name of the config, e.g. of the CDN) is visible.
+//
+// XRay core does not support ECH, TLS of the outbound is done by the Client dialer then: it is supported for
+// "tls" security over tcp, raw, ws, httpupgrade and grpc transports, fingerprint ("fp") is not applied.
+type ECHOptions struct {
+	// ConfigList is the ECHConfigList of the server in base64, e.g. "ech" parameter of its HTTPS DNS record.
+	ConfigList string
+	// OuterSNI selects the configs of ConfigList with the public name (default: any config of the list).
+	OuterSNI string
+}
+
+// Validate checks options values.
+func (o *ECHOptions) Validate() error {
+	_, err := o.configList()
+
+	return err
+}
+
+// configList returns decoded ConfigList, only with the configs of OuterSNI if it is set.
+func (o *ECHOptions) configList() ([]byte, error) {
+	raw, err := decodeBase64(o.ConfigList)
+	if err != nil {
+		return nil, fmt.Errorf("config list: %w", err)
+	}
+	configs, err := parseECHConfigList(raw)
+	if err != nil {
+		return nil, fmt.Errorf("config list: %w", err)
+	}
+
+	var b cryptobyte.Builder
+	var kept int
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, cfg := range configs {
+			if cfg.version == echConfigVersion && (o.OuterSNI == "" || strings.EqualFold(cfg.publicName, o.OuterSNI)) {
+				b.AddBytes(cfg.raw)
+				kept++
+			}
+		}
+	})
+	switch {
+	case kept == 0 && o.OuterSNI != "":
+		return nil, fmt.Errorf("config list: no config with outer sni %q", o.OuterSNI)
+	case kept == 0:
+		return nil, errors.New("config list: no supported config")
+	}
+
+	return b.Bytes()
+}
+
+// echConfig is an ECHConfig of ECHConfigList.
+type echConfig struct {
+	version    uint16
+	publicName string // Outer SNI, set for echConfigVersion.
+	raw        []byte // The whole config with version and length.
+}
+
+// parseECHConfigList parses ECHConfigList, see "Encoding the ECH Configuration" of the TLS ECH draft.
+func parseECHConfigList(b []byte) ([]echConfig, error) {
+	s := cryptobyte.String(b)
+	var list cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&list) || !s.Empty() || list.Empty() {
+		return nil, errors.New("malformed")
+	}
+
+	var configs []echConfig
+	for !list.Empty() {
+		start := list
+		var cfg echConfig
+		var contents cryptobyte.String
+		if !list.ReadUint16(&cfg.version) || !list.ReadUint16LengthPrefixed(&contents) {
+			return nil, errors.New("malformed config")
+		}
+		cfg.raw = start[:len(start)-len(list)]
+		if cfg.version == echConfigVersion {
+			var (
+				configID, maxNameLen uint8
+				kem                  uint16
+				key, suites, name    cryptobyte.String
+			)
+			if !contents.ReadUint8(&configID) || !contents.ReadUint16(&kem) || !contents.ReadUint16LengthPrefixed(&key) ||
+				!contents.ReadUint16LengthPrefixed(&suites) || !contents.ReadUint8(&maxNameLen) ||
+				!contents.ReadUint8LengthPrefixed(&name) || name.Empty() {
+				return nil, errors.New("malformed config")
+			}
+			cfg.publicName = string(name)
+		}
+		configs = append(configs, cfg)
+	}
+
+	return configs, nil
+}
+
+// decodeBase64 decodes standard or URL base64 with or without padding, as the config lists are shared both ways.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if b, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// linkECH returns ECH options of link query parameters "ech" (config list) and "echOuterSni", nil if there are none.
+func linkECH(q url.Values) *ECHOptions {
+	if !q.Has("ech") {
+		return nil
+	}
+
+	// Query unescaping turns "+" of standard base64 into spaces.
+	return &ECHOptions{ConfigList: strings.ReplaceAll(q.Get("ech"), " ", "+"), OuterSNI: q.Get("echOuterSni")}
+}
+
+// echTLS moves TLS of the outbound with server host to the Client dialer with ECH, see dialerTLS.
+func echTLS(out *conf.OutboundDetourConfig, opts *ECHOptions, host string) (*tls.Config, error) {
+	list, err := opts.configList()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := dialerTLS(out, host)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(cfg.ServerName) != nil {
+		return nil, errors.New("server name (sni) is required")
+	}
+	cfg.MinVersion = tls.VersionTLS13 // ECH requires TLS 1.3.
+	cfg.EncryptedClientHelloConfigList = list
+
+	return cfg, nil
+}
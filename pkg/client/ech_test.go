@@ -0,0 +1,170 @@
+package client
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/net/proxy"
+)
+
+// newTestECHKey returns ECH key of X25519 with public name, its config is the ECHConfig of the key.
+func newTestECHKey(t *testing.T, id uint8, publicName string) tls.EncryptedClientHelloKey {
+	t.Helper()
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	var b cryptobyte.Builder
+	b.AddUint16(echConfigVersion)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(id)
+		b.AddUint16(0x0020) // DHKEM(X25519, HKDF-SHA256).
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(key.PublicKey().Bytes()) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(0x0001) // HKDF-SHA256.
+			b.AddUint16(0x0001) // AES-128-GCM.
+		})
+		b.AddUint8(0)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(publicName)) })
+		b.AddUint16(0) // No extensions.
+	})
+
+	return tls.EncryptedClientHelloKey{Config: b.BytesOrPanic(), PrivateKey: key.Bytes(), SendAsRetry: true}
+}
+
+// testECHConfigList returns base64 ECHConfigList of configs.
+func testECHConfigList(configs ...[]byte) string {
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, cfg := range configs {
+			b.AddBytes(cfg)
+		}
+	})
+
+	return base64.StdEncoding.EncodeToString(b.BytesOrPanic())
+}
+
+func TestECHOptions_configList(t *testing.T) {
+	cdn, other := newTestECHKey(t, 1, "cdn.example.com"), newTestECHKey(t, 2, "other.example.com")
+	unknown := []byte{0xfe, 0x0a, 0x00, 0x01, 0x00} // Older draft version.
+	list := testECHConfigList(unknown, cdn.Config, other.Config)
+
+	got, err := (&ECHOptions{ConfigList: list}).configList()
+	require.NoError(t, err)
+	require.Equal(t, testECHConfigList(cdn.Config, other.Config), base64.StdEncoding.EncodeToString(got),
+		"unsupported versions are skipped")
+	got, err = (&ECHOptions{ConfigList: list, OuterSNI: "other.example.com"}).configList()
+	require.NoError(t, err)
+	require.Equal(t, testECHConfigList(other.Config), base64.StdEncoding.EncodeToString(got))
+
+	raw, _ := base64.StdEncoding.DecodeString(list)
+	require.NoError(t, (&ECHOptions{ConfigList: base64.RawURLEncoding.EncodeToString(raw)}).Validate())
+
+	for _, o := range []ECHOptions{
+		{ConfigList: list, OuterSNI: "cdn.example.org"},
+		{ConfigList: testECHConfigList(unknown)},
+		{ConfigList: "AAA"},
+		{ConfigList: "not base64!"},
+		{},
+	} {
+		require.Error(t, o.Validate(), o)
+	}
+}
+
+func TestClient_ECH(t *testing.T) {
+	key := newTestECHKey(t, 1, "cdn.example.com")
+	list := testECHConfigList(key.Config)
+	link := "vless://b831381d-6324-4d53-ad4f-8cda48b30811@127.0.0.5:443?type=ws&security=tls&path=%2Fws&sni=hidden.example.com&ech=" +
+		list // Unescaped "+" must survive.
+
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	spec, err := cl.parseLink(link)
+	require.NoError(t, err)
+	stream := spec.xray.OutboundConfigs[0].StreamSetting
+	require.Equal(t, "none", stream.Security, "tls is done by the dialer")
+	require.Nil(t, stream.TLSSettings)
+	require.Equal(t, "hidden.example.com", cl.xTLS.ServerName)
+	require.Equal(t, []string{"http/1.1"}, cl.xTLS.NextProtos)
+	require.Equal(t, list, base64.StdEncoding.EncodeToString(cl.xTLS.EncryptedClientHelloConfigList))
+
+	cl.cfg.ECH = &ECHOptions{ConfigList: list, OuterSNI: "cdn.example.com"}
+	spec, err = cl.parseLink("trojan://pass@secret.example.com:443?security=tls&type=grpc&serviceName=tun")
+	require.NoError(t, err)
+	require.Equal(t, "secret.example.com", cl.xTLS.ServerName, "server host is the default sni")
+	require.Equal(t, []string{"h2"}, cl.xTLS.NextProtos)
+
+	for _, link := range []string{
+		"trojan://pass@127.0.0.5:443?security=tls",
+		"trojan://pass@secret.example.com:443?security=tls&type=kcp",
+		"vless://b831381d-6324-4d53-ad4f-8cda48b30811@127.0.0.5:443?security=reality&sni=hidden.example.com&pbk=jY_Lrg226NLJ2tGbLyEAaznQUTJfvoVSchob3DmyCj8",
+	} {
+		_, err = cl.parseLink(link)
+		require.ErrorContains(t, err, "invalid config: ech:", link)
+	}
+	cl.cfg.ECH.OuterSNI = "cdn.example.org"
+	_, err = cl.parseLink("trojan://pass@secret.example.com:443?security=tls")
+	require.ErrorContains(t, err, "no config with outer sni")
+}
+
+func TestXraySystemDialer_ECH(t *testing.T) {
+	key := newTestECHKey(t, 1, "cdn.example.com")
+	cert := newTestCert(t, "server", "hidden.example.com", "cdn.example.com")
+
+	accepted := make(chan tls.ConnectionState, 1)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{key},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if err = conn.(*tls.Conn).Handshake(); err != nil {
+			return
+		}
+		accepted <- conn.(*tls.Conn).ConnectionState()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	list, err := (&ECHOptions{ConfigList: testECHConfigList(key.Config)}).configList()
+	require.NoError(t, err)
+	socket := filepath.Join(t.TempDir(), "inbound.sock")
+	x, err := newXrayInstance(&conf.Config{OutboundConfigs: []conf.OutboundDetourConfig{{Protocol: "freedom", Tag: "proxy"}}})
+	require.NoError(t, err)
+	useXrayDialer(x, xrayDialer{tag: "proxy", tls: &tls.Config{
+		ServerName: "hidden.example.com", InsecureSkipVerify: true, EncryptedClientHelloConfigList: list,
+	}})
+	t.Cleanup(func() { dropXrayDialer(x) })
+	in := newUnixInbound(x, socket)
+	require.NoError(t, in.Start())
+	t.Cleanup(func() { _ = in.Close() })
+
+	socks, err := proxy.SOCKS5("unix", socket, nil, &net.Dialer{})
+	require.NoError(t, err)
+	conn, err := socks.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got), "plain stream of the outbound is secured by the dialer")
+
+	state := <-accepted
+	require.True(t, state.ECHAccepted)
+	require.Equal(t, "hidden.example.com", state.ServerName)
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// ErrNoEngine is returned when link protocol is recognized, but there is no Engine able to serve it.
+var ErrNoEngine = errors.New("no engine registered for protocol")
+
+// Engine is an alternative proxy backend for protocols XRay core can not handle.
+//
+// Engine must serve SOCKS5 inbound on EngineOpts.Inbound address once started,
+// traffic from TUN device is piped to this inbound the same way as it is done for XRay.
+type Engine interface {
+	// Start starts the engine, inbound proxy must accept connections when Start returns.
+	Start() error
+	// Close stops the engine and releases all of its resources.
+	Close() error
+	// ServerAddr returns remote server host (IP or domain name) the engine connects to.
+	// It is used to route server traffic around the TUN device.
+	ServerAddr() string
+}
+
+// EngineOpts are Client settings passed to EngineFactory.
+type EngineOpts struct {
+	// Inbound is the address Engine must listen for SOCKS5 connections on,
+	// use Inbound.Network() and Inbound.String() as net.Listen arguments.
+	Inbound Proxy
+	// Whether to allow self-signed certificates or not.
+	TLSAllowInsecure bool
+	// Logger is the Client logger.
+	Logger *slog.Logger
+	// TUIC overrides for "tuic://" links, see TUICLink.Apply (nil if not configured).
+	TUIC *TUICOptions
+	// Dial is Config.Dialer resolving host names with Config.Resolver, Engine should connect to the server with it
+	// if it is set (nil: net.Dialer).
+	Dial DialFunc
+}
+
+// EngineFactory creates new Engine from connection link.
+// It must not start anything, the Engine may be discarded without Start (e.g. by Client.Plan).
+type EngineFactory func(link string, opts EngineOpts) (Engine, error)
+
+var (
+	enginesMu sync.RWMutex
+	engines   = map[string]EngineFactory{}
+)
+
+// engineOnlyLinks are known link schemes XRay core can not serve, mapped to their link validators. They are
+// rejected with ErrNoEngine by builds leaving their built-in engine out (goxray_nossh, goxray_nohysteria2 and
+// goxray_notuic tags).
+var engineOnlyLinks = map[string]func(link string) error{
+	sshScheme:            func(link string) error { _, err := parseSSHLink(link); return err },
+	hysteria2Scheme:      func(link string) error { _, err := ParseHysteria2Link(link); return err },
+	hysteria2ShortScheme: func(link string) error { _, err := ParseHysteria2Link(link); return err },
+	tuicScheme:           func(link string) error { _, err := ParseTUICLink(link); return err },
+}
+
+// RegisterEngine makes Engine available for links with specified scheme (e.g. "hysteria2").
+// Registering a factory for an already registered scheme replaces it.
+//
+// Engines take precedence over XRay core, so it is also possible to override built-in protocols.
+func RegisterEngine(scheme string, factory EngineFactory) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+
+	engines[strings.ToLower(scheme)] = factory
+}
+
+// lookupEngine returns EngineFactory registered for scheme, "hy2" links are also served by "hysteria2" engine.
+func lookupEngine(scheme string) (EngineFactory, bool) {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+
+	scheme = strings.ToLower(scheme)
+	f, ok := engines[scheme]
+	if !ok && scheme == hysteria2ShortScheme {
+		f, ok = engines[hysteria2Scheme]
+	}
+
+	return f, ok
+}
+
+// createEngine creates Engine for link using registered EngineFactory.
+func (c *Client) createEngine(factory EngineFactory, link string) (Engine, error) {
+	if c.cfg.TUIC != nil {
+		if err := c.cfg.TUIC.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: tuic options: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	eng, err := factory(link, EngineOpts{
+		Inbound:          *c.instanceInbound(),
+		TLSAllowInsecure: c.cfg.TLSAllowInsecure,
+		Logger:           c.log(LogXRay),
+		TUIC:             c.cfg.TUIC,
+		Dial:             c.serverDial(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: engine create: %w", ErrInvalidConfig, err)
+	}
+	if eng.ServerAddr() == "" {
+		return nil, fmt.Errorf("%w: engine returned empty server address", ErrInvalidConfig)
+	}
+
+	return eng, nil
+}
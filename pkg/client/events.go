@@ -0,0 +1,60 @@
+package client
+
+import (
+	"time"
+)
+
+// EventType identifies the kind of Event.
+type EventType string
+
+// Event types emitted by the Client.
+const (
+	// EventCaptivePortal is emitted when a captive portal is detected, Attrs["url"] is the portal login page.
+	EventCaptivePortal EventType = "captive_portal"
+	// EventCaptivePortalCleared is emitted when the captive portal is gone (e.g. the user logged in).
+	EventCaptivePortalCleared EventType = "captive_portal_cleared"
+	// EventOnDemandConnected is emitted when Config.OnDemand connection is established by the first packet.
+	EventOnDemandConnected EventType = "on_demand_connected"
+	// EventOnDemandFailed is emitted when Config.OnDemand connection failed, Attrs["err"] is the error.
+	// The client stays in standby and retries on the next packet after Attrs["retry_in"], see ReconnectOptions.
+	EventOnDemandFailed EventType = "on_demand_failed"
+	// EventReconnectCoolDown is emitted when connecting failed ReconnectOptions.Failures times in a row,
+	// Attrs["failures"] is the count and Attrs["retry_in"] the cool-down before the next attempt.
+	EventReconnectCoolDown EventType = "reconnect_cool_down"
+	// EventTunnelPanic is emitted when a goroutine of the connection panicked, Attrs["goroutine"] names it and
+	// Attrs["err"] is the panic. Routes are removed and TUN device is closed, Disconnect releases the rest.
+	EventTunnelPanic EventType = "tunnel_panic"
+	// EventDisconnected is emitted when the connection ended, Attrs["reason"] is DisconnectReason and
+	// Attrs["err"] is the cause if there is one, see Client.LastDisconnect.
+	EventDisconnected EventType = "disconnected"
+	// EventRouteRemoved is emitted when a route added by the Client is deleted by another program while connected,
+	// Attrs["route"] is the route. Traffic may bypass the tunnel then. It requires RouteSubscriber or RouteLister Config.Routes.
+	EventRouteRemoved EventType = "route_removed"
+	// EventRouteRepaired is emitted when a route deleted by another program was added back, Attrs["route"] is
+	// the route. It requires RouteVerifyOptions.Repair.
+	EventRouteRepaired EventType = "route_repaired"
+	// EventRoutesFlushed is emitted when all routes of the Client were deleted at once, e.g. by `ip route flush`,
+	// NetworkManager restart or DHCP client, Attrs["routes"] is their count. They are added back then.
+	// It requires RouteSubscriber or RouteLister Config.Routes.
+	EventRoutesFlushed EventType = "routes_flushed"
+	// EventRouteOverridden is emitted when another program added a route of the same destination as a route of
+	// the Client, Attrs["route"] is the route and Attrs["by"] the other one. It requires RouteLister Config.Routes.
+	EventRouteOverridden EventType = "route_overridden"
+)
+
+// Event notifies about Client state changes the user may need to act upon, see Config.OnEvent.
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Message string            // Human-readable description.
+	Attrs   map[string]string // Event specific details.
+}
+
+// emit sets event time and passes the event to Config.OnEvent.
+func (c *Client) emit(ev Event) {
+	ev.Time = time.Now()
+	c.cfg.Logger.Debug("event", "type", ev.Type, "msg", ev.Message, "attrs", ev.Attrs)
+	if c.cfg.OnEvent != nil {
+		c.cfg.OnEvent(ev)
+	}
+}
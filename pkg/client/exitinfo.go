@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultExitInfoURL is the endpoint queried by ExitInfo by default.
+const DefaultExitInfoURL = "https://ipinfo.io/json"
+
+// maxExitInfoSize limits the exit info response body.
+const maxExitInfoSize = 64 << 10
+
+// ExitInfo describes where the traffic exits to the internet, as seen by the exit info endpoint.
+type ExitInfo struct {
+	IP      net.IP `json:"ip"`
+	Country string `json:"country,omitempty"` // Country code (e.g. "NL") or name, as reported by the endpoint.
+	ASN     string `json:"asn,omitempty"`     // Autonomous system number, e.g. "AS15169".
+	Org     string `json:"org,omitempty"`     // Autonomous system organization.
+}
+
+// ExitInfo queries Config.ExitInfoURL through the proxy and returns the exit IP, country and ASN,
+// so it can be verified where the traffic actually exits. The request is limited by Config.Check timeout.
+func (c *Client) ExitInfo(ctx context.Context) (*ExitInfo, error) {
+	if c.stopTunnel == nil {
+		return nil, ErrNotConnected
+	}
+
+	cl, closeClient, err := c.proxyHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient()
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Check.timeout())
+	defer cancel()
+
+	return fetchExitInfo(ctx, cl, c.cfg.ExitInfoURL)
+}
+
+// LookupExitInfo queries url (DefaultExitInfoURL if empty) using the system network settings,
+// the traffic goes through the tunnel if the client is connected and routes it to the TUN device.
+func LookupExitInfo(ctx context.Context, url string) (*ExitInfo, error) {
+	if url == "" {
+		url = DefaultExitInfoURL
+	}
+
+	return fetchExitInfo(ctx, http.DefaultClient, url)
+}
+
+// fetchExitInfo requests exit info from url. The response is either JSON object with the fields
+// of the common IP info services (ipinfo.io, ip-api.com, ipapi.co, ifconfig.co) or plain text IP.
+func fetchExitInfo(ctx context.Context, cl *http.Client, url string) (*ExitInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("exit info: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("exit info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("exit info: unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxExitInfoSize))
+	if err != nil {
+		return nil, fmt.Errorf("exit info: read response: %w", err)
+	}
+
+	info, err := parseExitInfo(body)
+	if err != nil {
+		return nil, fmt.Errorf("exit info: %w", err)
+	}
+
+	return info, nil
+}
+
+func parseExitInfo(body []byte) (*ExitInfo, error) {
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		ip := net.ParseIP(strings.TrimSpace(string(body)))
+		if ip == nil {
+			return nil, errors.New("response is neither JSON nor IP address")
+		}
+
+		return &ExitInfo{IP: ip}, nil
+	}
+
+	info := &ExitInfo{
+		IP:      net.ParseIP(firstField(fields, "ip", "query")),
+		Country: firstField(fields, "country_code", "countryCode", "country_iso", "country"),
+		ASN:     firstField(fields, "asn", "as", "org"),
+		Org:     firstField(fields, "asn_org", "isp"),
+	}
+	if info.IP == nil {
+		return nil, errors.New("no IP address in response")
+	}
+	// Services report ASN as "AS15169 Google LLC" (ipinfo.io "org", ip-api.com "as") or just a number.
+	if asn, org, ok := strings.Cut(info.ASN, " "); ok {
+		info.ASN = asn
+		if info.Org == "" {
+			info.Org = org
+		}
+	}
+	if _, err := strconv.Atoi(info.ASN); err == nil {
+		info.ASN = "AS" + info.ASN
+	}
+	if !strings.HasPrefix(strings.ToUpper(info.ASN), "AS") {
+		info.ASN = ""
+	}
+
+	return info, nil
+}
+
+// firstField returns the first non-empty value of keys in fields as string.
+func firstField(fields map[string]any, keys ...string) string {
+	for _, k := range keys {
+		switch v := fields[k].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+
+	return ""
+}
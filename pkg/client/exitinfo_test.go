@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/internal/socks5"
+)
+
+func TestClient_ExitInfo(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	_, err := cl.ExitInfo(t.Context())
+	require.ErrorIs(t, err, ErrNotConnected)
+
+	var proxied atomic.Bool
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := socks5.NewServer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		proxied.Store(true)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	})
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+	defer ln.Close()
+
+	info := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ip": "203.0.113.7", "country": "NL", "org": "AS64500 Example Hosting"}`))
+	}))
+	defer info.Close()
+
+	cl.cfg.InboundProxy = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: ln.Addr().(*net.TCPAddr).Port}
+	cl.cfg.ExitInfoURL = info.URL
+	_, cl.stopTunnel = context.WithCancel(context.Background())
+
+	got, err := cl.ExitInfo(t.Context())
+	require.NoError(t, err)
+	require.True(t, proxied.Load(), "request must go through the proxy")
+	require.Equal(t, &ExitInfo{IP: net.ParseIP("203.0.113.7"), Country: "NL", ASN: "AS64500", Org: "Example Hosting"}, got)
+
+	cl.cfg.ExitInfoURL = info.URL + "/fail"
+	_, err = cl.ExitInfo(t.Context())
+	require.ErrorContains(t, err, "unexpected status 429")
+}
+
+func TestParseExitInfo(t *testing.T) {
+	for body, exp := range map[string]*ExitInfo{
+		// ip-api.com
+		`{"query": "203.0.113.7", "country": "Netherlands", "countryCode": "NL", "as": "AS64500 Example Hosting", "isp": "Example"}`: {
+			IP: net.ParseIP("203.0.113.7"), Country: "NL", ASN: "AS64500", Org: "Example",
+		},
+		// ifconfig.co
+		`{"ip": "2001:db8::7", "country_iso": "DE", "asn": "AS64501", "asn_org": "Example GmbH"}`: {
+			IP: net.ParseIP("2001:db8::7"), Country: "DE", ASN: "AS64501", Org: "Example GmbH",
+		},
+		`{"ip": "203.0.113.7", "asn": 64502}`: {IP: net.ParseIP("203.0.113.7"), ASN: "AS64502"},
+		"203.0.113.7\n":                       {IP: net.ParseIP("203.0.113.7")},
+	} {
+		got, err := parseExitInfo([]byte(body))
+		require.NoError(t, err, body)
+		require.Equal(t, exp, got, body)
+	}
+
+	_, err := parseExitInfo([]byte("<html></html>"))
+	require.Error(t, err)
+	_, err = parseExitInfo([]byte(`{"country": "NL"}`))
+	require.ErrorContains(t, err, "no IP address")
+}
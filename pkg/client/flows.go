@@ -0,0 +1,799 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/eycorsican/go-tun2socks/core"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// DefaultTCPIdleTimeout is the idle timeout of tunneled TCP connections,
+	// the minimum for established connections of RFC 5382.
+	DefaultTCPIdleTimeout = 2*time.Hour + 4*time.Minute
+	// DefaultUDPIdleTimeout is the idle timeout of tunneled UDP flows.
+	DefaultUDPIdleTimeout = 30 * time.Second
+	// DefaultProxyDialTimeout limits connecting of new flow to the inbound proxy, see FlowOptions.ProxyDialTimeout.
+	DefaultProxyDialTimeout = 4 * time.Second
+	// DefaultSOCKSHandshakeTimeout limits SOCKS5 handshake of new flow, see FlowOptions.SOCKSHandshakeTimeout.
+	DefaultSOCKSHandshakeTimeout = 10 * time.Second
+	// flowDialRetries is how many times new TCP connection is dialed again after a transient proxy failure.
+	flowDialRetries = 2
+	// flowRetryDelay is the delay of the first retry, it grows with every retry.
+	flowRetryDelay = 100 * time.Millisecond
+)
+
+// ErrFlowNotFound is returned by Client.CloseFlow for unknown or already closed flow.
+var ErrFlowNotFound = errors.New("flow not found")
+
+// errFlowLimit rejects new flow if FlowOptions.MaxFlows are active.
+var errFlowLimit = errors.New("flow limit reached")
+
+// errFlowDraining rejects new flow while Client.Disconnect drains active flows.
+var errFlowDraining = errors.New("disconnecting")
+
+// FlowOptions configure tunneled connections (flows) handling.
+//
+// Flows without data in both directions for the idle timeout are closed, so connections to dead hosts
+// (half-open connections) do not accumulate over long sessions.
+type FlowOptions struct {
+	// TCPIdleTimeout is the idle timeout of TCP connections (default: DefaultTCPIdleTimeout).
+	TCPIdleTimeout time.Duration
+	// UDPIdleTimeout is the idle timeout of UDP flows (default: DefaultUDPIdleTimeout).
+	UDPIdleTimeout time.Duration
+	// MaxFlows limits concurrent flows, protecting low-memory devices from connection floods (default: unlimited).
+	// New TCP connections over the limit are reset and UDP flows dropped, see FlowStats.Rejected.
+	MaxFlows int
+	// QueueTimeout is the longest wait of new flow for a free slot if MaxFlows are active,
+	// the flow is rejected after it (default: 0, rejected right away).
+	QueueTimeout time.Duration
+	// DrainTimeout is the grace period Client.Disconnect gives active TCP connections to finish, new flows are
+	// rejected meanwhile. Connections still active after it are closed, see FlowStats.Drained and ForceClosed.
+	// UDP flows have no end, they are closed right away (default: 0, all closed right away).
+	DrainTimeout time.Duration
+	// ProxyDialTimeout limits connecting of new flow to the inbound proxy, or Config.Upstream
+	// (default: DefaultProxyDialTimeout). Flows failing it are counted by FlowStats.TimedOut.
+	ProxyDialTimeout time.Duration
+	// SOCKSHandshakeTimeout limits SOCKS5 handshake of new flow with the proxy once connected, including UDP
+	// associations, so hung handshakes do not tie flows up. TCP handshakes may use the rest of ProxyDialTimeout
+	// too (default: DefaultSOCKSHandshakeTimeout).
+	SOCKSHandshakeTimeout time.Duration
+}
+
+// Validate checks options values.
+func (o *FlowOptions) Validate() error {
+	if o.TCPIdleTimeout < 0 {
+		return errors.New("tcp idle timeout must not be negative")
+	}
+	if o.UDPIdleTimeout < 0 {
+		return errors.New("udp idle timeout must not be negative")
+	}
+	if o.MaxFlows < 0 {
+		return errors.New("max flows must not be negative")
+	}
+	if o.QueueTimeout < 0 {
+		return errors.New("queue timeout must not be negative")
+	}
+	if o.QueueTimeout > 0 && o.MaxFlows == 0 {
+		return errors.New("queue timeout requires max flows")
+	}
+	if o.DrainTimeout < 0 {
+		return errors.New("drain timeout must not be negative")
+	}
+	if o.ProxyDialTimeout < 0 {
+		return errors.New("proxy dial timeout must not be negative")
+	}
+	if o.SOCKSHandshakeTimeout < 0 {
+		return errors.New("socks handshake timeout must not be negative")
+	}
+
+	return nil
+}
+
+func (o *FlowOptions) tcpIdleTimeout() time.Duration {
+	if o == nil || o.TCPIdleTimeout == 0 {
+		return DefaultTCPIdleTimeout
+	}
+
+	return o.TCPIdleTimeout
+}
+
+func (o *FlowOptions) udpIdleTimeout() time.Duration {
+	if o == nil || o.UDPIdleTimeout == 0 {
+		return DefaultUDPIdleTimeout
+	}
+
+	return o.UDPIdleTimeout
+}
+
+func (o *FlowOptions) drainTimeout() time.Duration {
+	if o == nil {
+		return 0
+	}
+
+	return o.DrainTimeout
+}
+
+// socksTimeouts limit connecting of new flow to the socks5 proxy, see FlowOptions.ProxyDialTimeout
+// and SOCKSHandshakeTimeout.
+type socksTimeouts struct {
+	dial      time.Duration
+	handshake time.Duration
+}
+
+func (o *FlowOptions) socksTimeouts() socksTimeouts {
+	t := socksTimeouts{dial: DefaultProxyDialTimeout, handshake: DefaultSOCKSHandshakeTimeout}
+	if o != nil {
+		t.dial = cmp.Or(o.ProxyDialTimeout, t.dial)
+		t.handshake = cmp.Or(o.SOCKSHandshakeTimeout, t.handshake)
+	}
+
+	return t
+}
+
+// dialer returns socks5 dialer of proxy addr, its DialContext connects within t.dial.
+func (t socksTimeouts) dialer(addr string) (proxy.ContextDialer, error) {
+	d, err := proxy.SOCKS5("tcp", addr, nil, &net.Dialer{Timeout: t.dial})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.(proxy.ContextDialer), nil
+}
+
+// Flow is a tunneled TCP connection or UDP flow.
+type Flow struct {
+	ID          uint64
+	Network     string // "tcp" or "udp".
+	Source      string // Application address, host:port.
+	Destination string // host:port.
+	Started     time.Time
+	LastActive  time.Time
+}
+
+// FlowStats are flow counters of the Client.
+type FlowStats struct {
+	Active   int    // Currently tunneled flows.
+	Queued   int    // New flows waiting for a free slot, see FlowOptions.QueueTimeout.
+	Rejected uint64 // Flows rejected because of FlowOptions.MaxFlows since the Client is created.
+	// Drained are TCP connections finished within FlowOptions.DrainTimeout on the last Disconnect.
+	Drained int
+	// ForceClosed are flows closed by the last Disconnect, TCP connections still active after
+	// FlowOptions.DrainTimeout and all UDP flows.
+	ForceClosed int
+	// TimedOut are TCP connections failed since the Client is created because the proxy did not accept them within
+	// FlowOptions.ProxyDialTimeout or did not complete SOCKS5 handshake within SOCKSHandshakeTimeout.
+	TimedOut uint64
+	// Retried are dials of TCP connections repeated since the Client is created because the proxy refused or reset
+	// them, e.g. XRay core restarting on a server switch. The application sees the failure after the last retry only.
+	Retried uint64
+}
+
+// FlowStats returns flow counters.
+func (c *Client) FlowStats() FlowStats {
+	return c.flows.stats()
+}
+
+// Flows returns currently tunneled connections ordered by ID.
+func (c *Client) Flows() []Flow {
+	return c.flows.list()
+}
+
+// CloseFlow tears down the flow with id, e.g. a stuck connection.
+func (c *Client) CloseFlow(id uint64) error {
+	if !c.flows.close(id) {
+		return fmt.Errorf("%w: %d", ErrFlowNotFound, id)
+	}
+
+	return nil
+}
+
+// flowTable tracks flows of the pipe.
+//
+// New flow reserves a slot first (see reserve), so the limit applies before connecting to the proxy.
+type flowTable struct {
+	mu       sync.Mutex
+	nextID   uint64
+	flows    map[uint64]*flowEntry
+	reserved int
+	// freed is closed and replaced when a slot is freed, waking up queued flows.
+	freed chan struct{}
+
+	maxFlows     int
+	queueTimeout time.Duration
+	queued       int
+	rejected     uint64
+	timeouts     socksTimeouts
+	timedOut     uint64
+	retried      uint64
+	// draining rejects new flows, finished counts TCP connections closed meanwhile, see drain.
+	draining    bool
+	finished    int
+	drained     int
+	forceClosed int
+	// budgetFlows limits flows if maxFlows is not set, relayBuffer is the copy buffer of TCP connections,
+	// see MemoryOptions.
+	budgetFlows int
+	relayBuffer int
+	// log records closed flows, nil if they are not logged.
+	log *flowLog
+	// timer records the first byte received by flows, see ConnectStats.FirstByte.
+	timer atomic.Pointer[connectTimer]
+}
+
+type flowEntry struct {
+	flow       Flow
+	lastActive atomic.Int64 // Unix nano.
+	closeOnce  sync.Once
+	closeFn    func()
+}
+
+func newFlowTable() *flowTable {
+	return &flowTable{
+		flows: make(map[uint64]*flowEntry), freed: make(chan struct{}), timeouts: (*FlowOptions)(nil).socksTimeouts(),
+	}
+}
+
+// setLimit sets flow limit and proxy timeouts for new flows, see FlowOptions. New flows are accepted again
+// after drain.
+func (t *flowTable) setLimit(opts *FlowOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.draining = false
+	t.maxFlows, t.queueTimeout = 0, 0
+	if opts != nil {
+		t.maxFlows, t.queueTimeout = opts.MaxFlows, opts.QueueTimeout
+	}
+	t.timeouts = opts.socksTimeouts()
+}
+
+// socksTimeouts returns proxy timeouts of new flows.
+func (t *flowTable) socksTimeouts() socksTimeouts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.timeouts
+}
+
+// dialRetried counts dial of new flow repeated after a transient proxy failure.
+func (t *flowTable) dialRetried() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.retried++
+}
+
+// dialFailed counts new flow failed to connect through the proxy with err, it reports whether it timed out.
+func (t *flowTable) dialFailed(err error) bool {
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timedOut++
+
+	return true
+}
+
+// setMemory sizes the flow limit and relay buffers of new flows to the plan.
+func (t *flowTable) setMemory(p memoryPlan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.budgetFlows, t.relayBuffer = p.maxFlows, p.relayBuffer
+}
+
+// limit is the flow limit, 0 if unlimited. t.mu must be held.
+func (t *flowTable) limit() int {
+	if t.maxFlows > 0 {
+		return t.maxFlows
+	}
+
+	return t.budgetFlows
+}
+
+// bufferSize is the relay buffer size of new TCP connection, 0 for io.Copy default.
+func (t *flowTable) bufferSize() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.relayBuffer
+}
+
+// reserve reserves a slot for new flow, waiting up to queueTimeout if maxFlows are active.
+// The slot is taken by add or returned by unreserve.
+func (t *flowTable) reserve(ctx context.Context) error {
+	var deadline <-chan time.Time
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return errFlowDraining
+	}
+	for limit := t.limit(); limit > 0 && len(t.flows)+t.reserved >= limit; limit = t.limit() {
+		if deadline == nil {
+			if t.queueTimeout <= 0 {
+				t.rejected++
+				return errFlowLimit
+			}
+			timer := time.NewTimer(t.queueTimeout)
+			defer timer.Stop()
+			deadline = timer.C
+			t.queued++
+			defer func() { t.queued-- }()
+		}
+
+		freed := t.freed
+		t.mu.Unlock()
+		select {
+		case <-freed:
+			t.mu.Lock()
+			if t.draining {
+				return errFlowDraining
+			}
+		case <-deadline:
+			t.mu.Lock()
+			t.rejected++
+			return errFlowLimit
+		case <-ctx.Done():
+			t.mu.Lock()
+			return ctx.Err()
+		}
+	}
+	t.reserved++
+
+	return nil
+}
+
+// unreserve returns slot reserved for the flow failed to connect.
+func (t *flowTable) unreserve() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reserved--
+	t.free()
+}
+
+// free wakes up queued flows, t.mu must be held.
+func (t *flowTable) free() {
+	close(t.freed)
+	t.freed = make(chan struct{})
+}
+
+// setLog sets the log of closed flows, nil stops logging.
+func (t *flowTable) setLog(log *flowLog) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.log = log
+}
+
+// setTimer sets the timer of the connection, see ConnectStats.FirstByte.
+func (t *flowTable) setTimer(timer *connectTimer) {
+	t.timer.Store(timer)
+}
+
+// add registers new flow taking the reserved slot, closeFn tears it down.
+func (t *flowTable) add(network string, src, dst net.Addr, closeFn func()) *flowEntry {
+	now := time.Now()
+	e := &flowEntry{
+		flow:    Flow{Network: network, Source: addrString(src), Destination: addrString(dst), Started: now},
+		closeFn: closeFn,
+	}
+	e.lastActive.Store(now.UnixNano())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reserved--
+	t.nextID++
+	e.flow.ID = t.nextID
+	t.flows[e.flow.ID] = e
+
+	return e
+}
+
+// remove unregisters closed flow freeing its slot, the flow is logged if the log is set.
+func (t *flowTable) remove(e *flowEntry) {
+	t.mu.Lock()
+	_, ok := t.flows[e.flow.ID]
+	if ok {
+		delete(t.flows, e.flow.ID)
+		t.free()
+		if t.draining && e.flow.Network == "tcp" {
+			t.finished++
+		}
+	}
+	log := t.log
+	t.mu.Unlock()
+
+	if ok {
+		log.write(e.flow, time.Now())
+	}
+}
+
+func (t *flowTable) stats() FlowStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return FlowStats{
+		Active: len(t.flows), Queued: t.queued, Rejected: t.rejected, Drained: t.drained, ForceClosed: t.forceClosed,
+		TimedOut: t.timedOut, Retried: t.retried,
+	}
+}
+
+// drain rejects new flows and waits up to timeout (or until ctx is done) for active TCP connections to finish,
+// then closes the remaining flows. It returns the number of finished and closed flows, see FlowStats.
+func (t *flowTable) drain(ctx context.Context, timeout time.Duration) (drained, closed int) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	t.mu.Lock()
+	t.draining, t.finished = true, 0
+	t.free() // Queued flows are rejected.
+	for t.count("tcp") > 0 {
+		freed := t.freed
+		t.mu.Unlock()
+		select {
+		case <-freed:
+			t.mu.Lock()
+			continue
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		t.mu.Lock()
+		break
+	}
+	remaining := make([]*flowEntry, 0, len(t.flows))
+	for _, e := range t.flows {
+		remaining = append(remaining, e)
+	}
+	drained, closed = t.finished, len(remaining)
+	t.drained, t.forceClosed = drained, closed
+	t.mu.Unlock()
+
+	for _, e := range remaining {
+		e.close()
+	}
+
+	return drained, closed
+}
+
+// count returns the number of active flows of network, t.mu must be held.
+func (t *flowTable) count(network string) int {
+	n := 0
+	for _, e := range t.flows {
+		if e.flow.Network == network {
+			n++
+		}
+	}
+
+	return n
+}
+
+// close tears down flow with id, it reports false if there is no such flow.
+func (t *flowTable) close(id uint64) bool {
+	t.mu.Lock()
+	e, ok := t.flows[id]
+	t.mu.Unlock()
+	if ok {
+		e.close()
+	}
+
+	return ok
+}
+
+func (t *flowTable) list() []Flow {
+	t.mu.Lock()
+	flows := make([]Flow, 0, len(t.flows))
+	for _, e := range t.flows {
+		f := e.flow
+		f.LastActive = time.Unix(0, e.lastActive.Load())
+		flows = append(flows, f)
+	}
+	t.mu.Unlock()
+
+	slices.SortFunc(flows, func(a, b Flow) int { return cmp.Compare(a.ID, b.ID) })
+
+	return flows
+}
+
+// reap closes flows idle longer than their network timeout and returns the number of closed flows.
+func (t *flowTable) reap(now time.Time, tcpIdle, udpIdle time.Duration) int {
+	var idle []*flowEntry
+	t.mu.Lock()
+	for _, e := range t.flows {
+		timeout := tcpIdle
+		if e.flow.Network == "udp" {
+			timeout = udpIdle
+		}
+		if now.Sub(time.Unix(0, e.lastActive.Load())) > timeout {
+			idle = append(idle, e)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, e := range idle {
+		e.close()
+	}
+
+	return len(idle)
+}
+
+// runReaper reaps idle flows until ctx is done.
+func (t *flowTable) runReaper(ctx context.Context, tcpIdle, udpIdle time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(max(time.Second, min(tcpIdle, udpIdle)/4))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := t.reap(now, tcpIdle, udpIdle); n > 0 {
+				logger.Debug("idle flows closed", "count", n)
+			}
+		}
+	}
+}
+
+func (e *flowEntry) touch() {
+	e.lastActive.Store(time.Now().UnixNano())
+}
+
+func (e *flowEntry) close() {
+	e.closeOnce.Do(e.closeFn)
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+
+	return a.String()
+}
+
+// flowConn marks the flow active on reads and writes, reads are reported to timer if it is set.
+type flowConn struct {
+	net.Conn
+	flow  *flowEntry
+	timer *connectTimer
+}
+
+func (c *flowConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.flow.touch()
+		c.timer.received()
+	}
+
+	return n, err
+}
+
+func (c *flowConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.flow.touch()
+	}
+
+	return n, err
+}
+
+// flowTCPHandler proxies TCP connections of the TCP/IP stack through socks5 dialer, tracking them in flows.
+// Connections classified by qos are dialed via socks5 inbound of their class, other ones via the inbound
+// of the server picked by balancer.
+type flowTCPHandler struct {
+	dialer   proxy.ContextDialer
+	ctx      context.Context
+	flows    *flowTable
+	qos      *qosTable
+	balancer *balancerTable
+}
+
+func (h *flowTCPHandler) Handle(conn net.Conn, target *net.TCPAddr) error {
+	if err := h.flows.reserve(h.ctx); err != nil {
+		return fmt.Errorf("tcp %s: %w", target, err)
+	}
+	dialer := h.dialer
+	addr, ok := h.qos.take("tcp", conn.LocalAddr(), target)
+	if !ok {
+		addr, ok = h.balancer.pick(target)
+	}
+	timeouts := h.flows.socksTimeouts()
+	if ok {
+		d, err := timeouts.dialer(addr)
+		if err != nil {
+			h.flows.unreserve()
+			return fmt.Errorf("flow dialer %s: %w", addr, err)
+		}
+		dialer = d
+	}
+	remote, err := h.dial(dialer, timeouts, target.String())
+	if err != nil {
+		h.flows.unreserve()
+		if h.flows.dialFailed(err) {
+			return fmt.Errorf("dial %s: proxy timed out: %w", target, err)
+		}
+		return fmt.Errorf("dial %s: %w", target, err)
+	}
+
+	e := h.flows.add("tcp", conn.LocalAddr(), target, func() {
+		if a, ok := conn.(interface{ Abort() }); ok {
+			a.Abort() // Reset, the peer may be gone.
+		} else {
+			_ = conn.Close()
+		}
+		_ = remote.Close()
+	})
+	size := h.flows.bufferSize()
+	go func() {
+		relayConns(&flowConn{Conn: conn, flow: e}, &flowConn{Conn: remote, flow: e, timer: h.flows.timer.Load()}, size)
+		h.flows.remove(e)
+	}()
+
+	return nil
+}
+
+// dial connects to target through the proxy of dialer. Transient failures of the proxy, e.g. refused or reset
+// connections while XRay core restarts on a server switch, are retried flowDialRetries times before the
+// application sees them. Nothing of the application is sent before the handshake completes, retries are safe.
+func (h *flowTCPHandler) dial(dialer proxy.ContextDialer, timeouts socksTimeouts, target string) (net.Conn, error) {
+	for retry := 0; ; retry++ {
+		// The dial of the dialer is limited by timeouts.dial, the rest is left to the handshake.
+		ctx, cancel := context.WithTimeout(h.ctx, timeouts.dial+timeouts.handshake)
+		remote, err := dialer.DialContext(ctx, "tcp", target)
+		cancel()
+		if err == nil || retry == flowDialRetries || !transientProxyErr(err) {
+			return remote, err
+		}
+
+		h.flows.dialRetried()
+		select {
+		case <-h.ctx.Done():
+			return nil, err
+		case <-time.After(time.Duration(retry+1) * flowRetryDelay):
+		}
+	}
+}
+
+// transientProxyErr reports whether err of dialing through the proxy is the proxy going away, not a failure
+// reported by it (e.g. the target is unreachable) or a timeout.
+func transientProxyErr(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// flowUDPHandler tracks UDP flows of the wrapped handler. Flows classified by qos or assigned to a server
+// by balancer are handled by the handler of their class or server created with classHandler. QUIC flows are admitted by quic, rejected ones
+// are answered with ICMP written by reject.
+type flowUDPHandler struct {
+	core.UDPConnHandler
+	flows        *flowTable
+	qos          *qosTable
+	balancer     *balancerTable
+	classHandler func(addr string) core.UDPConnHandler
+	quic         *quicPolicy
+	reject       func(pkt []byte) (int, error)
+
+	mu      sync.Mutex
+	conns   map[core.UDPConn]*flowUDPConn
+	classes map[string]core.UDPConnHandler
+}
+
+// udpCloser is implemented by go-tun2socks UDP handlers releasing the flow resources.
+type udpCloser interface {
+	Close(conn core.UDPConn)
+}
+
+func newFlowUDPHandler(h core.UDPConnHandler, flows *flowTable) *flowUDPHandler {
+	return &flowUDPHandler{
+		UDPConnHandler: h,
+		flows:          flows,
+		conns:          make(map[core.UDPConn]*flowUDPConn),
+		classes:        make(map[string]core.UDPConnHandler),
+	}
+}
+
+// handler returns UDP handler of the new flow.
+func (h *flowUDPHandler) handler(conn core.UDPConn, target *net.UDPAddr) core.UDPConnHandler {
+	if h.classHandler == nil || target == nil {
+		return h.UDPConnHandler
+	}
+	addr, ok := h.qos.take("udp", conn.LocalAddr(), target)
+	if !ok {
+		addr, ok = h.balancer.pick(target)
+	}
+	if !ok {
+		return h.UDPConnHandler
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch, ok := h.classes[addr]
+	if !ok {
+		ch = h.classHandler(addr)
+		h.classes[addr] = ch
+	}
+
+	return ch
+}
+
+func (h *flowUDPHandler) Connect(conn core.UDPConn, target *net.UDPAddr) error {
+	if err := h.quic.admit(target); err != nil {
+		if pkt := portUnreachable(conn.LocalAddr(), target); pkt != nil && h.reject != nil {
+			_, _ = h.reject(pkt)
+		}
+		return fmt.Errorf("udp %s: %w", target, err)
+	}
+	if err := h.flows.reserve(context.Background()); err != nil {
+		return fmt.Errorf("udp %s: %w", target, err)
+	}
+	fc := &flowUDPConn{UDPConn: conn, h: h, handler: h.handler(conn, target)}
+	var dst net.Addr
+	if target != nil {
+		dst = target
+	}
+	fc.flow = h.flows.add("udp", conn.LocalAddr(), dst, func() {
+		if c, ok := fc.handler.(udpCloser); ok {
+			c.Close(fc)
+		} else {
+			_ = fc.Close()
+		}
+	})
+	h.mu.Lock()
+	h.conns[conn] = fc
+	h.mu.Unlock()
+
+	if err := fc.handler.Connect(fc, target); err != nil {
+		h.release(fc)
+		return err
+	}
+
+	return nil
+}
+
+func (h *flowUDPHandler) ReceiveTo(conn core.UDPConn, data []byte, addr *net.UDPAddr) error {
+	h.mu.Lock()
+	fc, ok := h.conns[conn]
+	h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("udp flow %s -> %s does not exist", conn.LocalAddr(), addr)
+	}
+	fc.flow.touch()
+
+	return fc.handler.ReceiveTo(fc, data, addr)
+}
+
+func (h *flowUDPHandler) release(fc *flowUDPConn) {
+	h.mu.Lock()
+	delete(h.conns, fc.UDPConn)
+	h.mu.Unlock()
+	h.flows.remove(fc.flow)
+}
+
+// flowUDPConn marks the flow active on writes to TUN and releases it on Close.
+type flowUDPConn struct {
+	core.UDPConn
+	h       *flowUDPHandler
+	handler core.UDPConnHandler
+	flow    *flowEntry
+}
+
+func (c *flowUDPConn) WriteFrom(data []byte, addr *net.UDPAddr) (int, error) {
+	c.flow.touch()
+	c.h.flows.timer.Load().received()
+
+	return c.UDPConn.WriteFrom(data, addr)
+}
+
+func (c *flowUDPConn) Close() error {
+	c.h.release(c)
+
+	return c.UDPConn.Close()
+}
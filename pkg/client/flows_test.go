@@ -0,0 +1,333 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/eycorsican/go-tun2socks/core"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+
+	"github.com/goxray/tun/internal/socks5"
+)
+
+func TestFlowTCPHandler(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "socks.sock")
+	ln, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	srv := socks5.NewServer((&net.Dialer{}).DialContext)
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	echo := startTestEchoServer(t)
+	dialer, err := proxy.SOCKS5("unix", socket, nil, &net.Dialer{})
+	require.NoError(t, err)
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	h := &flowTCPHandler{dialer: dialer.(proxy.ContextDialer), ctx: context.Background(), flows: cl.flows}
+
+	local, conn := net.Pipe()
+	defer local.Close()
+	target, err := net.ResolveTCPAddr("tcp", echo)
+	require.NoError(t, err)
+	require.NoError(t, h.Handle(conn, target))
+
+	_, err = local.Write([]byte("ping"))
+	require.NoError(t, err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(local, got)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+
+	flows := cl.Flows()
+	require.Len(t, flows, 1)
+	require.Equal(t, "tcp", flows[0].Network)
+	require.Equal(t, echo, flows[0].Destination)
+
+	require.NoError(t, cl.CloseFlow(flows[0].ID))
+	_, err = local.Read(got)
+	require.Error(t, err, "flow is torn down")
+	require.Eventually(t, func() bool { return len(cl.Flows()) == 0 }, time.Second, 10*time.Millisecond)
+	require.ErrorIs(t, cl.CloseFlow(flows[0].ID), ErrFlowNotFound)
+
+	require.Error(t, h.Handle(conn, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}))
+	require.Empty(t, cl.Flows())
+}
+
+func TestFlowTable_reap(t *testing.T) {
+	flows := newFlowTable()
+	var closed []string
+	require.NoError(t, flows.reserve(t.Context()))
+	require.NoError(t, flows.reserve(t.Context()))
+	tcp := flows.add("tcp", nil, nil, func() { closed = append(closed, "tcp") })
+	flows.add("udp", nil, nil, func() { closed = append(closed, "udp") })
+
+	now := time.Now()
+	require.Zero(t, flows.reap(now, time.Minute, time.Minute))
+	require.Equal(t, 1, flows.reap(now.Add(2*time.Minute), time.Hour, time.Minute))
+	require.Equal(t, []string{"udp"}, closed)
+
+	tcp.lastActive.Store(now.Add(-2 * time.Hour).UnixNano())
+	require.Equal(t, 1, flows.reap(now, time.Hour, time.Minute))
+	require.Equal(t, []string{"udp", "tcp"}, closed)
+
+	// Flows are removed by their handlers, closing is idempotent.
+	require.Equal(t, 2, flows.reap(now.Add(3*time.Hour), time.Hour, time.Minute))
+	require.Equal(t, []string{"udp", "tcp"}, closed)
+}
+
+// testUDPConn is core.UDPConn recording its state.
+type testUDPConn struct {
+	closed  bool
+	written []byte
+}
+
+func (c *testUDPConn) LocalAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(192, 18, 0, 1), Port: 5353}
+}
+
+func (c *testUDPConn) ReceiveTo([]byte, *net.UDPAddr) error { return nil }
+
+func (c *testUDPConn) WriteFrom(data []byte, _ *net.UDPAddr) (int, error) {
+	c.written = append(c.written, data...)
+	return len(data), nil
+}
+
+func (c *testUDPConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+// testUDPHandler echoes received data back.
+type testUDPHandler struct{}
+
+func (testUDPHandler) Connect(core.UDPConn, *net.UDPAddr) error { return nil }
+
+func (testUDPHandler) ReceiveTo(conn core.UDPConn, data []byte, addr *net.UDPAddr) error {
+	_, err := conn.WriteFrom(data, addr)
+	return err
+}
+
+func TestFlowUDPHandler(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	h := newFlowUDPHandler(testUDPHandler{}, cl.flows)
+	conn := &testUDPConn{}
+	target := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 53}
+
+	require.NoError(t, h.Connect(conn, target))
+	require.NoError(t, h.ReceiveTo(conn, []byte("query"), target))
+	require.Equal(t, "query", string(conn.written))
+
+	flows := cl.Flows()
+	require.Len(t, flows, 1)
+	require.Equal(t, Flow{
+		ID: flows[0].ID, Network: "udp", Source: "192.18.0.1:5353", Destination: "1.1.1.1:53",
+		Started: flows[0].Started, LastActive: flows[0].LastActive,
+	}, flows[0])
+
+	require.NoError(t, cl.CloseFlow(flows[0].ID))
+	require.True(t, conn.closed)
+	require.Empty(t, cl.Flows())
+	require.Error(t, h.ReceiveTo(conn, []byte("query"), target))
+
+	cl.flows.setLimit(&FlowOptions{MaxFlows: 1})
+	require.NoError(t, h.Connect(&testUDPConn{}, target))
+	require.ErrorContains(t, h.Connect(&testUDPConn{}, target), "flow limit reached")
+	require.Equal(t, FlowStats{Active: 1, Rejected: 1}, cl.FlowStats())
+}
+
+func TestFlowTable_reserve(t *testing.T) {
+	flows := newFlowTable()
+	flows.setLimit(&FlowOptions{MaxFlows: 1})
+	require.NoError(t, flows.reserve(t.Context()))
+	require.ErrorIs(t, flows.reserve(t.Context()), errFlowLimit)
+	flows.unreserve()
+	require.NoError(t, flows.reserve(t.Context()))
+	e := flows.add("tcp", nil, nil, func() {})
+	require.ErrorIs(t, flows.reserve(t.Context()), errFlowLimit)
+	require.Equal(t, FlowStats{Active: 1, Rejected: 2}, flows.stats())
+
+	// Queued flow gets the slot once it is freed.
+	flows.setLimit(&FlowOptions{MaxFlows: 1, QueueTimeout: time.Second})
+	go func() {
+		require.Eventually(t, func() bool { return flows.stats().Queued == 1 }, time.Second, time.Millisecond)
+		flows.remove(e)
+	}()
+	require.NoError(t, flows.reserve(t.Context()))
+	flows.add("tcp", nil, nil, func() {})
+
+	flows.setLimit(&FlowOptions{MaxFlows: 1, QueueTimeout: 20 * time.Millisecond})
+	require.ErrorIs(t, flows.reserve(t.Context()), errFlowLimit)
+	require.Equal(t, FlowStats{Active: 1, Rejected: 3}, flows.stats())
+
+	flows.setLimit(nil)
+	require.NoError(t, flows.reserve(t.Context()), "unlimited")
+}
+
+func TestFlowTable_drain(t *testing.T) {
+	flows := newFlowTable()
+	var closed []string
+	add := func(network string) *flowEntry {
+		require.NoError(t, flows.reserve(t.Context()))
+		var e *flowEntry
+		e = flows.add(network, nil, nil, func() {
+			closed = append(closed, e.flow.Network)
+			flows.remove(e)
+		})
+		return e
+	}
+	finished := add("tcp")
+	add("tcp") // Still active after the drain timeout.
+	add("udp")
+
+	// Queued flow is rejected once draining starts.
+	flows.setLimit(&FlowOptions{MaxFlows: 3, QueueTimeout: time.Minute})
+	queued := make(chan error)
+	go func() { queued <- flows.reserve(t.Context()) }()
+	require.Eventually(t, func() bool { return flows.stats().Queued == 1 }, time.Second, time.Millisecond)
+
+	go func() {
+		require.ErrorIs(t, <-queued, errFlowDraining)
+		require.ErrorIs(t, flows.reserve(t.Context()), errFlowDraining)
+		flows.remove(finished)
+	}()
+	drained, forced := flows.drain(t.Context(), 100*time.Millisecond)
+	require.Equal(t, 1, drained)
+	require.Equal(t, 2, forced)
+	require.ElementsMatch(t, []string{"tcp", "udp"}, closed)
+	require.Equal(t, FlowStats{Drained: 1, ForceClosed: 2}, flows.stats())
+
+	// Without TCP connections drain does not wait.
+	start := time.Now()
+	flows.setLimit(nil)
+	require.NoError(t, flows.reserve(t.Context()), "accepted again")
+	flows.unreserve()
+	drained, forced = flows.drain(t.Context(), time.Minute)
+	require.Zero(t, drained+forced)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestFlowOptions_Validate(t *testing.T) {
+	require.NoError(t, (&FlowOptions{}).Validate())
+	require.Error(t, (&FlowOptions{TCPIdleTimeout: -time.Second}).Validate())
+	require.Error(t, (&FlowOptions{UDPIdleTimeout: -time.Second}).Validate())
+	require.Error(t, (&FlowOptions{MaxFlows: -1}).Validate())
+	require.Error(t, (&FlowOptions{QueueTimeout: time.Second}).Validate())
+	require.Error(t, (&FlowOptions{DrainTimeout: -time.Second}).Validate())
+	require.NoError(t, (&FlowOptions{MaxFlows: 64, QueueTimeout: time.Second}).Validate())
+
+	var o *FlowOptions
+	require.Equal(t, DefaultTCPIdleTimeout, o.tcpIdleTimeout())
+	require.Equal(t, time.Minute, (&FlowOptions{UDPIdleTimeout: time.Minute}).udpIdleTimeout())
+}
+
+func TestFlowOptions_SOCKSTimeouts(t *testing.T) {
+	require.Equal(t, socksTimeouts{dial: DefaultProxyDialTimeout, handshake: DefaultSOCKSHandshakeTimeout},
+		(*FlowOptions)(nil).socksTimeouts())
+	require.Equal(t, socksTimeouts{dial: time.Second, handshake: DefaultSOCKSHandshakeTimeout},
+		(&FlowOptions{ProxyDialTimeout: time.Second}).socksTimeouts())
+	require.ErrorContains(t, (&FlowOptions{SOCKSHandshakeTimeout: -1}).Validate(), "socks handshake timeout")
+}
+
+func TestFlowTCPHandler_HandshakeTimeout(t *testing.T) {
+	// The proxy accepts connections, but never answers the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	flows := newFlowTable()
+	flows.setLimit(&FlowOptions{ProxyDialTimeout: 50 * time.Millisecond, SOCKSHandshakeTimeout: 50 * time.Millisecond})
+	dialer, err := flows.socksTimeouts().dialer(ln.Addr().String())
+	require.NoError(t, err)
+	h := &flowTCPHandler{dialer: dialer, ctx: context.Background(), flows: flows, qos: newQoSTable(), balancer: newBalancerTable()}
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	start := time.Now()
+	err = h.Handle(local, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443})
+	require.ErrorContains(t, err, "proxy timed out")
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, uint64(1), flows.stats().TimedOut)
+	require.Zero(t, flows.stats().Active)
+}
+
+// flakyListener closes the first drop connections, like XRay core restarting on a server switch.
+type flakyListener struct {
+	net.Listener
+	drop atomic.Int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || l.drop.Add(-1) < 0 {
+			l.drop.Store(0)
+			return conn, err
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestFlowTCPHandler_Retry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	flaky := &flakyListener{Listener: ln}
+	flaky.drop.Store(flowDialRetries)
+	srv := socks5.NewServer((&net.Dialer{}).DialContext)
+	go func() { _ = srv.Serve(flaky) }()
+	defer srv.Close()
+
+	echo := startTestEchoServer(t)
+	target, err := net.ResolveTCPAddr("tcp", echo)
+	require.NoError(t, err)
+	flows := newFlowTable()
+	dialer, err := flows.socksTimeouts().dialer(ln.Addr().String())
+	require.NoError(t, err)
+	h := &flowTCPHandler{dialer: dialer, ctx: context.Background(), flows: flows, qos: newQoSTable(), balancer: newBalancerTable()}
+
+	// Connections reset by the proxy are retried transparently.
+	local, conn := net.Pipe()
+	defer local.Close()
+	require.NoError(t, h.Handle(conn, target))
+	_, err = local.Write([]byte("ping"))
+	require.NoError(t, err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(local, got)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+	require.Equal(t, uint64(flowDialRetries), flows.stats().Retried)
+
+	// The failure is surfaced when retries are exhausted.
+	flaky.drop.Store(flowDialRetries + 1)
+	_, conn = net.Pipe()
+	require.Error(t, h.Handle(conn, target))
+	require.Equal(t, uint64(2*flowDialRetries), flows.stats().Retried)
+
+	// Failures reported by the proxy are not retried.
+	_, conn = net.Pipe()
+	require.Error(t, h.Handle(conn, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}))
+	require.Equal(t, uint64(2*flowDialRetries), flows.stats().Retried)
+}
+
+func TestTransientProxyErr(t *testing.T) {
+	require.True(t, transientProxyErr(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}))
+	require.True(t, transientProxyErr(&net.OpError{Op: "socks connect", Err: io.EOF}))
+	require.False(t, transientProxyErr(context.DeadlineExceeded))
+	require.False(t, transientProxyErr(errors.New("host unreachable")))
+}
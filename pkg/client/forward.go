@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"golang.org/x/net/proxy"
+)
+
+// ErrNotConnected is returned by methods requiring established connection.
+var ErrNotConnected = errors.New("client is not connected")
+
+// Forward is a local TCP listener forwarding accepted connections through the tunnel
+// to the remote address, like "ssh -L". It is created by Client.Forward.
+type Forward struct {
+	ln     net.Listener
+	remote string
+	dialer proxy.ContextDialer
+	log    *slog.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	wg      sync.WaitGroup
+	onClose func()
+}
+
+// Forward listens on localAddr and forwards TCP connections through the proxy to remoteAddr,
+// e.g. Forward("127.0.0.1:8022", "10.0.0.5:22"). Forwards are closed on Disconnect.
+func (c *Client) Forward(localAddr, remoteAddr string) (*Forward, error) {
+	if c.stopTunnel == nil {
+		return nil, ErrNotConnected
+	}
+	if _, _, err := net.SplitHostPort(remoteAddr); err != nil {
+		return nil, fmt.Errorf("invalid remote address: %w", err)
+	}
+
+	dialer, err := c.proxyDialer()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	f := &Forward{
+		ln:     ln,
+		remote: remoteAddr,
+		dialer: dialer,
+		log:    c.cfg.Logger.With("forward", ln.Addr().String()+"->"+remoteAddr),
+		conns:  map[net.Conn]struct{}{},
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+
+	c.forwardsMu.Lock()
+	if c.forwards == nil {
+		c.forwards = map[*Forward]struct{}{}
+	}
+	c.forwards[f] = struct{}{}
+	c.forwardsMu.Unlock()
+	f.onClose = func() {
+		c.forwardsMu.Lock()
+		delete(c.forwards, f)
+		c.forwardsMu.Unlock()
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.serve()
+	}()
+	f.log.Debug("forward started")
+
+	return f, nil
+}
+
+// proxyDialer returns dialer connecting through the proxy.
+func (c *Client) proxyDialer() (proxy.ContextDialer, error) {
+	inbound := c.instanceInbound()
+	dialer, err := proxy.SOCKS5(inbound.Network(), inbound.String(), nil, &net.Dialer{})
+	if err != nil {
+		return nil, fmt.Errorf("socks5 dialer: %w", err)
+	}
+
+	return dialer.(proxy.ContextDialer), nil
+}
+
+// closeForwards closes all forwards of the client.
+func (c *Client) closeForwards() error {
+	c.forwardsMu.Lock()
+	forwards := make([]*Forward, 0, len(c.forwards))
+	for f := range c.forwards {
+		forwards = append(forwards, f)
+	}
+	c.forwardsMu.Unlock()
+
+	var err error
+	for _, f := range forwards {
+		err = errors.Join(err, f.Close())
+	}
+
+	return err
+}
+
+// Addr returns the local address the forward listens on.
+func (f *Forward) Addr() net.Addr {
+	return f.ln.Addr()
+}
+
+// Close stops listening and closes forwarded connections.
+func (f *Forward) Close() error {
+	err := f.ln.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil // Already closed.
+	}
+	f.cancel()
+	f.mu.Lock()
+	for conn := range f.conns {
+		_ = conn.Close()
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+	f.onClose()
+
+	return err
+}
+
+func (f *Forward) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				f.log.Error("forward accept failed", "err", err)
+			}
+			return
+		}
+
+		f.mu.Lock()
+		f.conns[conn] = struct{}{}
+		f.mu.Unlock()
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			defer func() {
+				f.mu.Lock()
+				delete(f.conns, conn)
+				f.mu.Unlock()
+			}()
+
+			f.handle(conn)
+		}()
+	}
+}
+
+func (f *Forward) handle(conn net.Conn) {
+	remote, err := f.dialer.DialContext(f.ctx, "tcp", f.remote)
+	if err != nil {
+		f.log.Warn("forward dial failed", "err", err)
+		_ = conn.Close()
+		return
+	}
+
+	relayConns(conn, remote, 0)
+}
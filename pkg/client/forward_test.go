@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/internal/socks5"
+)
+
+func TestClient_Forward(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	_, err := cl.Forward("127.0.0.1:0", "127.0.0.1:22")
+	require.ErrorIs(t, err, ErrNotConnected)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := socks5.NewServer((&net.Dialer{}).DialContext)
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+	defer ln.Close()
+
+	cl.cfg.InboundProxy = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: ln.Addr().(*net.TCPAddr).Port}
+	_, cl.stopTunnel = context.WithCancel(context.Background())
+
+	_, err = cl.Forward("127.0.0.1:0", "no-port")
+	require.ErrorContains(t, err, "invalid remote address")
+
+	f, err := cl.Forward("127.0.0.1:0", startTestEchoServer(t))
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", f.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+
+	require.NoError(t, cl.closeForwards())
+	require.Empty(t, cl.forwards)
+	_, err = conn.Read(got)
+	require.ErrorIs(t, err, io.EOF)
+	require.NoError(t, f.Close())
+}
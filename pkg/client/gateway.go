@@ -0,0 +1,77 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/jackpal/gateway"
+)
+
+// gatewayPollInterval is the interval of gateway discovery while waiting for it, see Config.GatewayWait.
+const gatewayPollInterval = time.Second
+
+// discoverGateways returns default gateways of the system, replaced in tests.
+var discoverGateways = gateway.DiscoverGateways
+
+// discoverGateway returns the default gateway, the one in the network of interface ifName if it is set.
+func discoverGateway(ifName string) (net.IP, error) {
+	gws, err := discoverGateways()
+	if err != nil {
+		return nil, err
+	}
+	if ifName == "" {
+		return gws[0], nil
+	}
+
+	ifc, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("gateway interface: %w", err)
+	}
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("gateway interface %s: %w", ifName, err)
+	}
+	for _, gw := range gws {
+		if slices.ContainsFunc(addrs, func(a net.Addr) bool {
+			ipNet, ok := a.(*net.IPNet)
+			return ok && ipNet.Contains(gw)
+		}) {
+			return gw, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no gateway on interface %s", ifName)
+}
+
+// resolveGateway discovers the gateway at Connect, as the network may have changed since NewClient
+// (e.g. daemon started at boot). It retries for Config.GatewayWait, no gateway after that means
+// IPv6-only network unless Config.GatewayInterface is set. Config.GatewayIP set by user is kept.
+func (c *Client) resolveGateway() error {
+	if !c.gatewayAuto {
+		return nil
+	}
+
+	deadline := time.Now().Add(c.cfg.GatewayWait)
+	for {
+		ip, err := discoverGateway(c.cfg.GatewayInterface)
+		if err == nil {
+			if c.cfg.GatewayIP == nil || !c.cfg.GatewayIP.Equal(ip) {
+				c.log(LogRoute).Info("gateway discovered", "ip", ip, "interface", c.cfg.GatewayInterface)
+			}
+			c.cfg.GatewayIP = &ip
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			if c.cfg.GatewayInterface != "" {
+				return fmt.Errorf("discover gateway: %w", err)
+			}
+			c.log(LogRoute).Debug("no gateway discovered", "err", err)
+			c.cfg.GatewayIP = nil
+			return nil
+		}
+		c.log(LogRoute).Debug("waiting for gateway", "err", err)
+		time.Sleep(min(gatewayPollInterval, time.Until(deadline)))
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stubGateways(t *testing.T, fn func() ([]net.IP, error)) {
+	t.Helper()
+	prev := discoverGateways
+	t.Cleanup(func() { discoverGateways = prev })
+	discoverGateways = fn
+}
+
+func TestDiscoverGateway(t *testing.T) {
+	gws := []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(127, 0, 0, 254)}
+	stubGateways(t, func() ([]net.IP, error) { return gws, nil })
+
+	gw, err := discoverGateway("")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", gw.String())
+
+	lo, err := net.InterfaceByIndex(1)
+	require.NoError(t, err)
+	gw, err = discoverGateway(lo.Name)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.254", gw.String(), "gateway in the network of the interface")
+
+	gws = gws[:1]
+	_, err = discoverGateway(lo.Name)
+	require.ErrorContains(t, err, "no gateway on interface "+lo.Name)
+	_, err = discoverGateway("missing0")
+	require.Error(t, err)
+}
+
+func TestClient_resolveGateway(t *testing.T) {
+	var calls int
+	stubGateways(t, func() ([]net.IP, error) {
+		if calls++; calls == 1 {
+			return nil, errors.New("network is down")
+		}
+		return []net.IP{net.IPv4(192, 168, 1, 1)}, nil
+	})
+
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	require.NoError(t, cl.resolveGateway())
+	require.Equal(t, "127.0.0.2", cl.GatewayIP().String(), "gateway set by user is kept")
+	require.Zero(t, calls)
+
+	cl.gatewayAuto = true
+	cl.cfg.GatewayWait = 5 * time.Second
+	require.NoError(t, cl.resolveGateway())
+	require.Equal(t, "192.168.1.1", cl.GatewayIP().String(), "discovered after retry")
+	require.Equal(t, 2, calls)
+
+	stubGateways(t, func() ([]net.IP, error) { return nil, errors.New("no gateway") })
+	cl.cfg.GatewayWait = 0
+	require.NoError(t, cl.resolveGateway())
+	require.Nil(t, cl.GatewayIP(), "IPv6-only network")
+
+	cl.cfg.GatewayInterface = "eth0"
+	require.ErrorContains(t, cl.resolveGateway(), "discover gateway")
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/goxray/core/network/route"
+)
+
+// Handover is the TUN device of a connection handed over to another process, e.g. an upgraded binary executed
+// in place of the daemon, so the device and routes to it stay up while the connection is established again.
+// Packets sent meanwhile are queued or dropped by the device, they are not leaked past the tunnel.
+// It is supported on Linux.
+type Handover struct {
+	// TUNName is the name of the TUN device.
+	TUNName string `json:"tun_name"`
+	// TUNFd is the file descriptor of the TUN device, inherited by the process executed in place of this one.
+	TUNFd int `json:"tun_fd"`
+}
+
+// Inherit makes the TUN device inherited by the process executed next, it must be called right before
+// the execution. The device is closed on exec until then.
+func (h *Handover) Inherit() error {
+	return inheritFd(h.TUNFd)
+}
+
+// Close closes the TUN device if it is not adopted, routes to it are gone with it.
+func (h *Handover) Close() error {
+	return os.NewFile(uintptr(h.TUNFd), "tun").Close()
+}
+
+// Handover hands TUN device of the connection over to another process and disconnects with DisconnectHandover
+// reason. The device and routes to it are kept, the rest is released like by Disconnect, including the inbound
+// proxy. The process adopts the device with Adopt, it must close the Handover if it does not.
+// The connection is kept if the device can not be handed over, the Handover is returned even if the disconnect
+// fails.
+func (c *Client) Handover(ctx context.Context) (*Handover, error) {
+	if c.stopTunnel == nil {
+		return nil, ErrNotConnected
+	}
+	if c.cfg.Netns != "" {
+		return nil, errors.New("handover of TUN device in network namespace is not supported")
+	}
+	h, err := handoverTUN(c.tunName)
+	c.audit.record(AuditTUNHandover, c.tunName, err)
+	if err != nil {
+		return nil, fmt.Errorf("hand over tun %s: %w", c.tunName, err)
+	}
+	c.cfg.Logger.Info("TUN device handed over", "tun", h.TUNName, "fd", h.TUNFd)
+
+	return h, c.DisconnectWithReason(ctx, DisconnectHandover, nil)
+}
+
+// Adopt makes the next Connect use TUN device of h handed over by another process instead of creating one.
+// The device is closed if the Connect fails.
+func (c *Client) Adopt(h *Handover) {
+	c.handover = h
+}
+
+// adoptTunnel adopts TUN device handed over to the Client and adds routes to it missing, e.g. RoutesToTUN
+// of the previous process differ.
+func (c *Client) adoptTunnel() (io.ReadWriteCloser, error) {
+	h := c.handover
+	c.handover = nil
+	target := h.TUNName + " " + c.cfg.TUNAddress.String()
+	f, err := adoptTUN(h)
+	c.audit.record(AuditTUNAdopt, target, err)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("adopt tun %s: %w", h.TUNName, err), h.Close())
+	}
+	c.cfg.Logger.Info("TUN device adopted", "tun", h.TUNName)
+
+	// Routes are added one at a time, adding the kept ones fails.
+	for _, op := range routeOpsOf(route.Opts{IfName: h.TUNName, Routes: c.cfg.RoutesToTUN}, false) {
+		if opts, err := op.opts(); err == nil {
+			_ = c.routes.Add(opts)
+		}
+	}
+	c.tunName = h.TUNName
+
+	return c.audit.device(f, target), nil
+}
+
+// dropHandover closes TUN device handed over to the Client if it is not adopted.
+func (c *Client) dropHandover() {
+	if c.handover == nil {
+		return
+	}
+	if err := c.handover.Close(); err != nil {
+		c.log(LogTUN).Warn("closing handed over TUN device failed", "err", err)
+	}
+	c.handover = nil
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// handoverTUN returns Handover of TUN device name opened by this process, its file descriptor is duplicated
+// to outlive the device closed by Disconnect. The duplicate is closed on exec until Handover.Inherit, so other
+// processes executed meanwhile do not keep the device.
+func handoverTUN(name string) (*Handover, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		fd, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if target, err := os.Readlink("/proc/self/fd/" + e.Name()); err != nil || target != "/dev/net/tun" {
+			continue
+		}
+		if dev, err := tunDeviceName(fd); err != nil || dev != name {
+			continue
+		}
+		dup, err := unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Handover{TUNName: name, TUNFd: dup}, nil
+	}
+
+	return nil, errors.New("device file not found")
+}
+
+// inheritFd makes file descriptor fd inherited by executed processes.
+func inheritFd(fd int) error {
+	_, err := unix.FcntlInt(uintptr(fd), unix.F_SETFD, 0)
+
+	return err
+}
+
+// adoptTUN returns TUN device of h, it fails if the device is gone.
+func adoptTUN(h *Handover) (*os.File, error) {
+	if name, err := tunDeviceName(h.TUNFd); err != nil || name != h.TUNName {
+		return nil, errors.New("device is gone")
+	}
+	unix.CloseOnExec(h.TUNFd)
+
+	return os.NewFile(uintptr(h.TUNFd), "/dev/net/tun"), nil
+}
+
+// tunDeviceName returns name of TUN device attached to file descriptor fd of /dev/net/tun.
+func tunDeviceName(fd int) (string, error) {
+	ifr, err := unix.NewIfreq("")
+	if err != nil {
+		return "", err
+	}
+	if err = unix.IoctlIfreq(fd, unix.TUNGETIFF, ifr); err != nil {
+		return "", err
+	}
+
+	return ifr.Name(), nil
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// pipeHandover returns Handover of a pipe instead of TUN device.
+func pipeHandover(t *testing.T) *Handover {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+	fd, err := unix.Dup(int(r.Fd()))
+	require.NoError(t, err)
+
+	return &Handover{TUNName: "tun0", TUNFd: fd}
+}
+
+// closed reports whether file descriptor fd is closed.
+func closed(fd int) bool {
+	_, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0)
+
+	return err == unix.EBADF
+}
+
+func TestClient_Handover(t *testing.T) {
+	c := &Client{cfg: Config{Logger: slog.New(slog.DiscardHandler)}}
+	_, err := c.Handover(context.Background())
+	require.ErrorIs(t, err, ErrNotConnected)
+
+	_, err = handoverTUN("goxray-missing")
+	require.ErrorContains(t, err, "device file not found")
+}
+
+func TestClient_Adopt(t *testing.T) {
+	c, _, _, _ := newLoopbackClient(t)
+
+	// Something else than TUN device is not adopted.
+	h := pipeHandover(t)
+	c.Adopt(h)
+	_, err := c.adoptTunnel()
+	require.ErrorContains(t, err, "adopt tun tun0: device is gone")
+	require.Nil(t, c.handover)
+	require.True(t, closed(h.TUNFd))
+
+	// The device is closed if Connect fails.
+	h = pipeHandover(t)
+	c.Adopt(h)
+	require.Error(t, c.Connect("invalid://link"))
+	require.Nil(t, c.handover)
+	require.True(t, closed(h.TUNFd))
+}
+
+func TestHandover_Inherit(t *testing.T) {
+	h := pipeHandover(t)
+	defer h.Close()
+	unix.CloseOnExec(h.TUNFd)
+
+	require.NoError(t, h.Inherit())
+	flags, err := unix.FcntlInt(uintptr(h.TUNFd), unix.F_GETFD, 0)
+	require.NoError(t, err)
+	require.Zero(t, flags&unix.FD_CLOEXEC)
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// handoverTUN is not supported, TUN devices of other platforms are gone with the process which created them.
+func handoverTUN(string) (*Handover, error) {
+	return nil, fmt.Errorf("not supported on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
+
+// inheritFd is not supported, see handoverTUN.
+func inheritFd(int) error {
+	return fmt.Errorf("not supported on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
+
+// adoptTUN is not supported, see handoverTUN.
+func adoptTUN(*Handover) (*os.File, error) {
+	return nil, fmt.Errorf("not supported on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/goxray/core/network/route"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// happyEyeballsDelay is the head start of IPv6 connection attempt (RFC 8305 Connection Attempt Delay).
+var happyEyeballsDelay = 250 * time.Millisecond
+
+// happyEyeballsTimeout limits server address lookup and the connection race.
+const happyEyeballsTimeout = 5 * time.Second
+
+// defaultRouteExists reports whether there is an IPv4 default route, replaced in tests.
+var defaultRouteExists = hasDefaultRoute
+
+// resolveServer resolves server host into the IP the Client connects to and routes to the gateway,
+// with the family and bootstrap DNS servers of Config.Resolver.
+//
+// If host has both IPv4 and IPv6 addresses and port is known, TCP connections to both are raced (RFC 8305):
+// IPv6 goes first, IPv4 starts after happyEyeballsDelay, the first established connection wins.
+// IPv4 is used if the race fails (e.g. UDP based protocols) or IPv6 can not bypass the TUN device.
+//
+// If there is no IPv4 gateway (IPv6-only network), IPv6 is used and IPv4-only server address is synthesized
+// for NAT64, synthesized is set then. Without any default route (e.g. container on internal network) IPv4 is kept.
+func (c *Client) resolveServer(host, port string) (ip net.IP, synthesized bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), happyEyeballsTimeout)
+	defer cancel()
+
+	if ip, err = c.lookupServer(ctx, host, port); err != nil {
+		return nil, false, err
+	}
+	if ip.To4() == nil || c.cfg.GatewayIP != nil {
+		return ip, false, nil
+	}
+	if !defaultRouteExists() {
+		// Not an IPv6-only network: there is no default route at all, e.g. Docker internal network.
+		c.log(LogRoute).Info("no default route, server is connected via its own route", "ip", ip)
+		return ip, false, nil
+	}
+	if ip, err = c.synthesizeServer(ctx, ip); err != nil {
+		return nil, false, err
+	}
+
+	return ip, true, nil
+}
+
+func (c *Client) lookupServer(ctx context.Context, host, port string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+
+	ips, err := c.lookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var v4, v6 net.IP
+	for _, ip := range ips {
+		switch {
+		case ip.To4() != nil && v4 == nil:
+			v4 = ip.To4()
+		case ip.To4() == nil && v6 == nil:
+			v6 = ip
+		}
+	}
+	switch {
+	case v4 == nil || v6 != nil && c.cfg.GatewayIP == nil:
+		return v6, nil
+	case v6 == nil || port == "" || !c.ipv6Bypass():
+		return v4, nil
+	}
+
+	winner, err := raceDial(ctx, c.directDial, []net.IP{v6, v4}, port)
+	if err != nil {
+		c.log(LogDNS).Debug("server connection race failed, using IPv4", "host", host, "err", err)
+		return v4, nil
+	}
+	c.log(LogDNS).Debug("server connection race won", "host", host, "ip", winner)
+
+	return winner, nil
+}
+
+// raceDial dials TCP port of ips with dial, each next attempt is started happyEyeballsDelay later than
+// the previous one. It returns IP of the first established connection.
+func raceDial(ctx context.Context, dial DialFunc, ips []net.IP, port string) (net.IP, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		ip  net.IP
+		err error
+	}
+	results := make(chan result, len(ips))
+	for i, ip := range ips {
+		go func() {
+			select {
+			case <-time.After(time.Duration(i) * happyEyeballsDelay):
+			case <-ctx.Done():
+				results <- result{ip, ctx.Err()}
+				return
+			}
+
+			conn, err := dial(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				_ = conn.Close()
+			}
+			results <- result{ip, err}
+		}()
+	}
+
+	var errs error
+	for range ips {
+		r := <-results
+		if r.err == nil {
+			return r.ip, nil
+		}
+		errs = errors.Join(errs, r.err)
+	}
+
+	return nil, errs
+}
+
+// ipv6Bypass reports whether traffic to IPv6 server address can bypass the TUN device:
+// either it is not routed to TUN or the gateway is IPv6 and the server route exception can be added.
+func (c *Client) ipv6Bypass() bool {
+	if c.cfg.GatewayIP != nil && c.cfg.GatewayIP.To4() == nil {
+		return true
+	}
+	for _, r := range c.cfg.RoutesToTUN {
+		if r.IP.To4() == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// serverRouteNeeded reports whether the route exception is needed for server ip, it is not for IPv6 server
+// with IPv4 gateway or without gateway (IPv6-only network) as IPv6 traffic is not routed to TUN (see ipv6Bypass).
+// Without gateway IPv4 server is reached via its own route, which is more specific than the TUN device routes.
+func (c *Client) serverRouteNeeded(ip net.IP) bool {
+	if c.cfg.Netns != "" {
+		return false // XRay core connects from the host namespace, TUN device routes are in Netns.
+	}
+	if c.cfg.Upstream != nil && ip.IsLoopback() {
+		return false // Upstream proxy runs on this host.
+	}
+
+	return c.cfg.GatewayIP != nil && (ip.To4() != nil || c.cfg.GatewayIP.To4() == nil)
+}
+
+// hostRoute returns "/32" or "/128" route of ip.
+func hostRoute(ip net.IP) *route.Addr {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &route.Addr{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}
+	}
+
+	return &route.Addr{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}
+}
+
+// pinServerFamily makes XRay core connect to the server host using the address family of ip,
+// so the connection goes via the server route exception.
+func pinServerFamily(cfg *conf.Config, host string, ip net.IP) {
+	if net.ParseIP(host) != nil || len(cfg.OutboundConfigs) == 0 {
+		return
+	}
+
+	out := &cfg.OutboundConfigs[0]
+	if out.StreamSetting == nil {
+		out.StreamSetting = &conf.StreamConfig{}
+	}
+	if out.StreamSetting.SocketSettings == nil {
+		out.StreamSetting.SocketSettings = &conf.SocketConfig{}
+	}
+	if out.StreamSetting.SocketSettings.DomainStrategy != "" {
+		return // Set explicitly.
+	}
+	out.StreamSetting.SocketSettings.DomainStrategy = "UseIPv6"
+	if ip.To4() != nil {
+		out.StreamSetting.SocketSettings.DomainStrategy = "UseIPv4"
+	}
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func TestRaceDial(t *testing.T) {
+	prev := happyEyeballsDelay
+	t.Cleanup(func() { happyEyeballsDelay = prev })
+	happyEyeballsDelay = 50 * time.Millisecond
+
+	v4, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer v4.Close()
+	port := strconv.Itoa(v4.Addr().(*net.TCPAddr).Port)
+
+	// IPv6 is not listening, IPv4 wins.
+	ip, err := raceDial(t.Context(), (&net.Dialer{}).DialContext, []net.IP{net.IPv6loopback, net.IPv4(127, 0, 0, 1)}, port)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", ip.String())
+
+	_, err = raceDial(t.Context(), (&net.Dialer{}).DialContext, []net.IP{net.IPv6loopback, net.IPv4(127, 0, 0, 2)}, "1")
+	require.Error(t, err)
+
+	v6, err := net.Listen("tcp6", net.JoinHostPort("::1", port))
+	if err != nil {
+		t.Skip("IPv6 loopback is not available:", err)
+	}
+	defer v6.Close()
+
+	// Both are listening, IPv6 wins with the head start.
+	ip, err = raceDial(t.Context(), (&net.Dialer{}).DialContext, []net.IP{net.IPv6loopback, net.IPv4(127, 0, 0, 1)}, port)
+	require.NoError(t, err)
+	require.Equal(t, "::1", ip.String())
+}
+
+func TestClient_ipv6Bypass(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.RoutesToTUN = DefaultRoutesToTUN
+	require.True(t, cl.ipv6Bypass())
+	require.False(t, cl.serverRouteNeeded(net.IPv6loopback), "IPv6 is not routed to TUN")
+	require.True(t, cl.serverRouteNeeded(net.IPv4(127, 0, 0, 1)))
+
+	cl.cfg.RoutesToTUN = []*route.Addr{route.MustParseAddr("0.0.0.0/1"), route.MustParseAddr("::/1")}
+	require.False(t, cl.ipv6Bypass())
+}
+
+func TestHostRoute(t *testing.T) {
+	require.Equal(t, route.MustParseAddr("203.0.113.1/32"), hostRoute(net.ParseIP("203.0.113.1")))
+	require.Equal(t, "2001:db8::1/128", hostRoute(net.ParseIP("2001:db8::1")).String())
+}
+
+func TestPinServerFamily(t *testing.T) {
+	cfg := &conf.Config{OutboundConfigs: []conf.OutboundDetourConfig{{Protocol: "vless"}}}
+	pinServerFamily(cfg, "203.0.113.1", net.ParseIP("203.0.113.1"))
+	require.Nil(t, cfg.OutboundConfigs[0].StreamSetting, "IP address needs no pinning")
+
+	pinServerFamily(cfg, "example.com", net.ParseIP("2001:db8::1"))
+	require.Equal(t, "UseIPv6", cfg.OutboundConfigs[0].StreamSetting.SocketSettings.DomainStrategy)
+
+	// Explicit strategy is kept.
+	pinServerFamily(cfg, "example.com", net.ParseIP("203.0.113.1"))
+	require.Equal(t, "UseIPv6", cfg.OutboundConfigs[0].StreamSetting.SocketSettings.DomainStrategy)
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// HintError is an error of a common failure with a hint for the user how to fix it, see Hint.
+type HintError struct {
+	Err error
+	// Hint is the remediation, e.g. how to get the missing privileges.
+	Hint string
+}
+
+func (e *HintError) Error() string { return e.Err.Error() }
+func (e *HintError) Unwrap() error { return e.Err }
+
+// Hint returns the remediation hint of err returned by Client.Connect, empty if it is not a common failure.
+func Hint(err error) string {
+	var h *HintError
+	if errors.As(err, &h) {
+		return h.Hint
+	}
+
+	return ""
+}
+
+// withHint returns err with the hint of the common failure it is, err is returned as is if it is not recognized
+// or it has a hint already.
+func withHint(err error) error {
+	if err == nil || Hint(err) != "" {
+		return err
+	}
+
+	var (
+		dnsErr       *net.DNSError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		certErr      x509.CertificateInvalidError
+	)
+	msg := err.Error()
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return &HintError{Err: err, Hint: privilegesHint()}
+	// XRay core errors are not wrapped, they are recognized by the message.
+	case errors.Is(err, syscall.EADDRINUSE), strings.Contains(msg, "address already in use"),
+		strings.Contains(msg, "Only one usage of each socket address"):
+		return &HintError{Err: err, Hint: "another program listens on the port: stop it or choose another port " +
+			"of the inbound proxy, a free one is picked by default"}
+	case errors.As(err, &dnsErr):
+		return &HintError{Err: err, Hint: "check the server host name of the link and that DNS works on this network, " +
+			"or resolve it with bootstrap DNS servers (e.g. 1.1.1.1)"}
+	case errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &certErr),
+		strings.Contains(msg, "x509: "):
+		return &HintError{Err: err, Hint: "the server certificate is not trusted: check the sni of the link and " +
+			"the system clock, self-signed certificates need allowInsecure=1 in the link"}
+	}
+
+	return err
+}
+
+// privilegesHint returns the hint of missing privileges to set up TUN device and routes on this platform.
+func privilegesHint() string {
+	switch runtime.GOOS {
+	case "windows":
+		return "run as Administrator"
+	case "linux":
+		hint := "run as root (e.g. with sudo) or grant the network capabilities: sudo setcap cap_net_admin,cap_net_raw+ep "
+		if exe, err := os.Executable(); err == nil {
+			return hint + exe
+		}
+		return hint + "<binary>"
+	default:
+		return "run as root (e.g. with sudo)"
+	}
+}
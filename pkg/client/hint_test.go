@@ -0,0 +1,41 @@
+package client
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithHint(t *testing.T) {
+	require.NoError(t, withHint(nil))
+	plain := errors.New("boom")
+	require.Equal(t, plain, withHint(plain))
+	require.Empty(t, Hint(plain))
+
+	for _, tt := range []struct {
+		err  error
+		hint string
+	}{
+		{fmt.Errorf("create tun: %w", os.ErrPermission), "run as"},
+		{&net.OpError{Op: "listen", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}, "another port"},
+		{errors.New("failed to listen TCP on 127.0.0.1:1080 > listen tcp: bind: address already in use"), "another port"},
+		{fmt.Errorf("server address not resolvable: %w", &net.DNSError{Err: "no such host", Name: "srv.example"}), "bootstrap DNS"},
+		{fmt.Errorf("tls: %w", x509.UnknownAuthorityError{}), "allowInsecure"},
+		{errors.New("transport failed > x509: certificate has expired or is not yet valid"), "system clock"},
+	} {
+		err := withHint(tt.err)
+		require.ErrorIs(t, err, tt.err)
+		require.Equal(t, tt.err.Error(), err.Error())
+		require.Contains(t, Hint(err), tt.hint, tt.err)
+	}
+
+	// The hint of the failure is kept.
+	err := withHint(fmt.Errorf("connect: %w", &HintError{Err: os.ErrPermission, Hint: "use the force"}))
+	require.Equal(t, "use the force", Hint(err))
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistorySize is the number of recent attempts kept by Client.History.
+const HistorySize = 100
+
+// AttemptKind is the kind of Attempt.
+type AttemptKind string
+
+// Attempt kinds of Client.History.
+const (
+	// AttemptConnect is Client.Connect, or connecting on the first packet with Config.OnDemand.
+	AttemptConnect AttemptKind = "connect"
+	// AttemptReconnect is a connect attempt after a failed one or after the previous connection ended for other
+	// reason than DisconnectUser.
+	AttemptReconnect AttemptKind = "reconnect"
+	// AttemptDisconnect is the end of a connection, see DisconnectInfo.
+	AttemptDisconnect AttemptKind = "disconnect"
+)
+
+// Attempt is a connect, reconnect or disconnect of Client.History.
+type Attempt struct {
+	Kind AttemptKind `json:"kind"`
+	// Time is the start of connect attempts and the end of the connection of disconnects.
+	Time time.Time `json:"time"`
+	// Duration is how long connect attempts took and how long the connection lasted for disconnects.
+	Duration time.Duration `json:"duration"`
+	// Server is the server host and port of the link or Config.Upstream, empty if it is not known.
+	Server string           `json:"server,omitempty"`
+	Reason DisconnectReason `json:"reason,omitempty"` // Set for disconnects.
+	Err    string           `json:"err,omitempty"`    // Empty if the attempt succeeded.
+}
+
+// historyState holds recent attempts of the Client.
+type historyState struct {
+	mu       sync.Mutex
+	attempts []Attempt // The oldest first, at most HistorySize.
+	server   string    // Server of the current Connect.
+	// connected is the connect attempt of the current connection.
+	connected *Attempt
+}
+
+// History returns recent connect, reconnect and disconnect attempts, the oldest first,
+// so intermittent failures can be investigated after the fact.
+func (c *Client) History() []Attempt {
+	c.history.mu.Lock()
+	defer c.history.mu.Unlock()
+
+	return append([]Attempt(nil), c.history.attempts...)
+}
+
+// setServer sets the server of the following attempts.
+func (h *historyState) setServer(server string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.server = server
+}
+
+// recordConnect records connect attempt started at start, err is the failure.
+func (c *Client) recordConnect(start time.Time, err error) {
+	h := &c.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	a := Attempt{Kind: AttemptConnect, Time: start, Duration: time.Since(start), Server: h.server}
+	if n := len(h.attempts); n > 0 {
+		if last := h.attempts[n-1]; last.Kind != AttemptDisconnect && last.Err != "" ||
+			last.Kind == AttemptDisconnect && last.Reason != DisconnectUser {
+			a.Kind = AttemptReconnect
+		}
+	}
+	h.connected = nil
+	if err != nil {
+		a.Err = err.Error()
+	} else {
+		h.connected = &a
+	}
+	h.add(a)
+}
+
+// recordDisconnect records the end of the current connection, see DisconnectInfo.
+func (c *Client) recordDisconnect(info *DisconnectInfo) {
+	h := &c.history
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	a := Attempt{Kind: AttemptDisconnect, Time: info.Time, Reason: info.Reason, Err: info.Err}
+	if h.connected != nil {
+		a.Server = h.connected.Server
+		a.Duration = info.Time.Sub(h.connected.Time.Add(h.connected.Duration))
+		h.connected = nil
+	}
+	h.add(a)
+}
+
+// add appends the attempt, the oldest one is dropped if there are HistorySize attempts.
+func (h *historyState) add(a Attempt) {
+	if len(h.attempts) == HistorySize {
+		h.attempts = append(h.attempts[:0], h.attempts[1:]...)
+	}
+	h.attempts = append(h.attempts, a)
+}
+
+// linkServer returns the server host and port of link, Config.Upstream if it is set.
+func (c *Client) linkServer(link string) string {
+	if c.cfg.Upstream != nil {
+		return c.cfg.Upstream.String()
+	}
+	link = strings.TrimSpace(link)
+	if strings.HasPrefix(link, "vmess://") {
+		if l, err := ParseVMessLink(link); err == nil {
+			return net.JoinHostPort(l.Host, l.Port)
+		}
+	}
+	if u, err := url.Parse(link); err == nil {
+		return u.Host
+	}
+
+	return ""
+}
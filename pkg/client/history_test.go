@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_History(t *testing.T) {
+	c, _, _, _ := newLoopbackClient(t)
+	require.Empty(t, c.History())
+	connect := func(err error) {
+		c.openTUN = func() (io.ReadWriteCloser, error) { return newMemTUN(), err }
+		if err != nil {
+			require.Error(t, c.Connect(loopbackScheme+"://server:443"))
+			return
+		}
+		require.NoError(t, c.Connect(loopbackScheme+"://server:443"))
+	}
+
+	connect(nil)
+	require.NoError(t, c.DisconnectWithReason(context.Background(), DisconnectHealthCheck, errors.New("timeout")))
+	connect(errors.New("no tun"))
+	connect(nil)
+	require.NoError(t, c.Disconnect(context.Background()))
+	connect(nil)
+
+	var got []Attempt
+	for _, a := range c.History() {
+		require.False(t, a.Time.IsZero())
+		require.GreaterOrEqual(t, a.Duration, time.Duration(0))
+		a.Time, a.Duration = time.Time{}, 0
+		got = append(got, a)
+	}
+	require.Equal(t, []Attempt{
+		{Kind: AttemptConnect, Server: "server:443"},
+		{Kind: AttemptDisconnect, Server: "server:443", Reason: DisconnectHealthCheck, Err: "timeout"},
+		{Kind: AttemptReconnect, Server: "server:443", Err: "setup TUN device: no tun"},
+		{Kind: AttemptReconnect, Server: "server:443"},
+		{Kind: AttemptDisconnect, Server: "server:443", Reason: DisconnectUser},
+		{Kind: AttemptConnect, Server: "server:443"},
+	}, got)
+	require.NoError(t, c.Disconnect(context.Background()))
+
+	for range HistorySize {
+		c.recordConnect(time.Now(), nil)
+	}
+	history := c.History()
+	require.Len(t, history, HistorySize)
+	require.False(t, slices.ContainsFunc(history, func(a Attempt) bool { return a.Kind == AttemptDisconnect }),
+		"the oldest attempts are dropped")
+}
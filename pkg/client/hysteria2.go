@@ -0,0 +1,136 @@
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Hysteria2 link schemes, "hy2" is a commonly used short alias.
+const (
+	hysteria2Scheme      = "hysteria2"
+	hysteria2ShortScheme = "hy2"
+)
+
+// Hysteria2Link is a parsed "hysteria2://" (or "hy2://") share link.
+//
+// XRay core does not implement Hysteria2, such links are served by the built-in engine registered
+// for "hysteria2" scheme (left out of builds with goxray_nohysteria2 tag), it may be replaced with RegisterEngine.
+type Hysteria2Link struct {
+	Auth         string // Authentication string, "user:pass" for userpass auth.
+	Host         string // Server host (IP or domain name).
+	Ports        string // Server port or port hopping range (e.g. "443" or "443,5000-6000").
+	SNI          string // TLS server name (default: Host).
+	Insecure     bool   // Whether to allow insecure TLS.
+	PinSHA256    string // Hex encoded SHA-256 fingerprint of the server certificate.
+	Obfs         string // Obfuscation type, only "salamander" is defined.
+	ObfsPassword string // Obfuscation password.
+	Remark       string
+}
+
+// ParseHysteria2Link parses and validates Hysteria2 share link as described in
+// https://v2.hysteria.network/docs/developers/URI-Scheme/.
+func ParseHysteria2Link(link string) (*Hysteria2Link, error) {
+	link = strings.TrimSpace(link)
+
+	// Port hopping ranges ("443,5000-6000") are not valid url ports, so cut them out before parsing.
+	hostPort, ports := splitLinkPorts(link)
+	u, err := url.Parse(hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if u.Scheme != hysteria2Scheme && u.Scheme != hysteria2ShortScheme {
+		return nil, fmt.Errorf("unexpected scheme %q", u.Scheme)
+	}
+
+	h := &Hysteria2Link{Host: u.Hostname(), Ports: ports, Remark: u.Fragment}
+	if u.User != nil {
+		h.Auth = u.User.Username()
+		if pass, ok := u.User.Password(); ok {
+			h.Auth += ":" + pass
+		}
+	}
+	if h.Host == "" {
+		return nil, errors.New("empty host")
+	}
+	if h.Ports == "" {
+		h.Ports = "443"
+	}
+	if err = validatePortRanges(h.Ports); err != nil {
+		return nil, fmt.Errorf("invalid port %q: %w", h.Ports, err)
+	}
+
+	q := u.Query()
+	h.SNI = q.Get("sni")
+	if h.SNI == "" && net.ParseIP(h.Host) == nil {
+		h.SNI = h.Host
+	}
+	h.Insecure = q.Get("insecure") == "1" || q.Get("insecure") == "true"
+	h.PinSHA256 = strings.ToLower(strings.ReplaceAll(q.Get("pinSHA256"), ":", ""))
+	if h.PinSHA256 != "" {
+		if b, err := hex.DecodeString(h.PinSHA256); err != nil || len(b) != 32 {
+			return nil, errors.New("pinSHA256 must be hex encoded SHA-256 hash")
+		}
+	}
+
+	h.Obfs = q.Get("obfs")
+	h.ObfsPassword = q.Get("obfs-password")
+	switch h.Obfs {
+	case "":
+	case "salamander":
+		if h.ObfsPassword == "" {
+			return nil, errors.New("obfs-password is required for salamander obfuscation")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported obfs type %q", h.Obfs)
+	}
+
+	return h, nil
+}
+
+// splitLinkPorts cuts port part out of the link authority, returning the link without port and the port part itself.
+func splitLinkPorts(link string) (string, string) {
+	scheme, rest, ok := strings.Cut(link, "://")
+	if !ok {
+		return link, ""
+	}
+
+	end := strings.IndexAny(rest, "/?#")
+	if end == -1 {
+		end = len(rest)
+	}
+	authority := rest[:end]
+
+	hostStart := strings.LastIndex(authority, "@") + 1
+	portStart := strings.LastIndex(authority, ":")
+	if portStart < hostStart || strings.HasSuffix(authority, "]") {
+		return link, ""
+	}
+
+	return scheme + "://" + authority[:portStart] + rest[end:], authority[portStart+1:]
+}
+
+// validatePortRanges checks comma separated list of ports and port ranges, e.g. "443,5000-6000".
+func validatePortRanges(ports string) error {
+	for _, p := range strings.Split(ports, ",") {
+		from, to, isRange := strings.Cut(p, "-")
+		if !isRange {
+			to = from
+		}
+
+		start, err := strconv.ParseUint(from, 10, 16)
+		if err != nil || start == 0 {
+			return fmt.Errorf("bad port %q", from)
+		}
+		end, err := strconv.ParseUint(to, 10, 16)
+		if err != nil || end < start {
+			return fmt.Errorf("bad port range %q", p)
+		}
+	}
+
+	return nil
+}
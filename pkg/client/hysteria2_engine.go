@@ -0,0 +1,63 @@
+//go:build !goxray_nohysteria2
+
+package client
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/sagernet/sing-quic/hysteria2"
+	"github.com/sagernet/sing/common/logger"
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+func init() {
+	RegisterEngine(hysteria2Scheme, newHysteria2Engine)
+}
+
+// newHysteria2Engine creates Engine relaying TCP connections and UDP datagrams over Hysteria2 connection,
+// congestion control is chosen by the server (Brutal or BBR).
+func newHysteria2Engine(link string, opts EngineOpts) (Engine, error) {
+	l, err := ParseHysteria2Link(link)
+	if err != nil {
+		return nil, err
+	}
+	var ports []string
+	for _, p := range strings.Split(l.Ports, ",") {
+		from, to, isRange := strings.Cut(p, "-")
+		if !isRange {
+			to = from
+		}
+		ports = append(ports, from+":"+to)
+	}
+	first, _, _ := strings.Cut(ports[0], ":")
+	port, _ := strconv.ParseUint(first, 10, 16)
+	if len(ports) == 1 && !strings.Contains(l.Ports, "-") {
+		ports = nil // Port hopping is not used.
+	}
+	var salamander string
+	if l.Obfs == "salamander" {
+		salamander = l.ObfsPassword
+	}
+
+	return &quicEngine{
+		name: hysteria2Scheme, host: l.Host, inbound: opts.Inbound, log: opts.Logger,
+		newClient: func(ctx context.Context) (quicClient, error) {
+			c, err := hysteria2.NewClient(hysteria2.ClientOptions{
+				Context:            ctx,
+				Dialer:             newQUICDialer(opts.Dial),
+				Logger:             logger.NOP(),
+				ServerAddress:      M.ParseSocksaddrHostPort(l.Host, uint16(port)),
+				ServerPorts:        ports,
+				SalamanderPassword: salamander,
+				Password:           l.Auth,
+				TLSConfig:          newQUICTLSConfig(l.SNI, true, nil, l.Insecure || opts.TLSAllowInsecure, l.PinSHA256),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return c, nil
+		},
+	}, nil
+}
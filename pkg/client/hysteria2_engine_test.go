@@ -0,0 +1,77 @@
+//go:build !goxray_nohysteria2
+
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/sagernet/sing-quic/hysteria2"
+	"github.com/sagernet/sing/common/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateProxy_Hysteria2(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+
+	inst, cfg, err := cl.createProxy("hy2://auth@127.0.0.8:443")
+	require.NoError(t, err)
+	require.IsType(t, &quicEngine{}, inst)
+	require.Equal(t, "hy2", cfg.Protocol)
+	require.Equal(t, "127.0.0.8", cl.xSrvIP.String())
+
+	err = cl.Connect("hy2://auth@example.com:0")
+	require.ErrorContains(t, err, "invalid config: engine create:")
+}
+
+func TestHysteria2Engine(t *testing.T) {
+	cert := newTestCert(t, "hysteria2.test", "hysteria2.test")
+	pc, tlsCfg := listenTestQUIC(t, cert)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc, err := hysteria2.NewService[int](hysteria2.ServiceOptions{
+		Context: ctx, Logger: logger.NOP(), SalamanderPassword: "obfs", TLSConfig: tlsCfg, Handler: testQUICHandler{},
+	})
+	require.NoError(t, err)
+	svc.UpdateUsers([]int{1}, []string{"user:pass"})
+	require.NoError(t, svc.Start(pc))
+	defer svc.Close()
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pin := sha256.Sum256(cert.Certificate[0])
+
+	tests := []struct {
+		name  string
+		auth  string
+		query string
+		ok    bool
+	}{
+		{name: "insecure", auth: "user:pass", query: "insecure=1&obfs=salamander&obfs-password=obfs", ok: true},
+		{name: "pin", auth: "user:pass", query: "obfs=salamander&obfs-password=obfs&pinSHA256=" + hex.EncodeToString(pin[:]), ok: true},
+		{name: "port hopping", auth: "user:pass", query: "insecure=1&obfs=salamander&obfs-password=obfs", ok: true},
+		{name: "pin mismatch", auth: "user:pass", query: "obfs=salamander&obfs-password=obfs&pinSHA256=" + hex.EncodeToString(make([]byte, 32))},
+		{name: "not verified", auth: "user:pass", query: "sni=hysteria2.test&obfs=salamander&obfs-password=obfs"},
+		{name: "wrong auth", auth: "user:wrong", query: "insecure=1&obfs=salamander&obfs-password=obfs"},
+		{name: "no obfs", auth: "user:pass", query: "insecure=1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ports := fmt.Sprint(port)
+			if test.name == "port hopping" {
+				ports = fmt.Sprintf("%d-%d", port, port)
+			}
+			inbound := Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()}
+			link := fmt.Sprintf("hysteria2://%s@127.0.0.1:%s?%s", test.auth, ports, test.query)
+			eng, err := newHysteria2Engine(link, EngineOpts{Inbound: inbound, Logger: slog.New(slog.DiscardHandler)})
+			require.NoError(t, err)
+			require.Equal(t, "127.0.0.1", eng.ServerAddr())
+
+			checkTestQUICEngine(t, eng, inbound, test.ok)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+)
+
+// ErrConflict is returned by Connect if the Client would share a resource with another connected Client
+// of the process: inbound proxy address, route to TUN device, system proxy settings, IP forwarding or journal.
+var ErrConflict = errors.New("conflicts with another connected client")
+
+// claims are resources of connected Clients of the process, see Client.claim.
+var (
+	claimsMu sync.Mutex
+	claims   = map[*Client]claim{}
+)
+
+// claim is resources of a connected Client. Several Clients can run concurrently, e.g. work and personal tunnels
+// with distinct routes: the more specific route wins, like "10.0.0.0/8" of work tunnel over the default routes.
+type claim struct {
+	tunAddr     string
+	inbound     string
+	netns       string   // Routes to TUN device are in the namespace.
+	table       int      // Routes to TUN device are in the table, see Config.RouteIsolation.
+	routes      []string // Routes to TUN device.
+	serverRoute string   // Route exception of the server, shared by Clients of the same server.
+	exclusive   []string // Resources of the system held by one Client at a time.
+}
+
+// claim registers resources of the Client until release, it fails with ErrConflict if another
+// connected Client holds them. Default TUN address is moved to the next free one if it is taken.
+func (c *Client) claim() error {
+	claimsMu.Lock()
+	defer claimsMu.Unlock()
+
+	if c.cfg.TUNAddress == defaultTUNAddress {
+		for tunAddressTaken(c.cfg.TUNAddress.IP, c) {
+			next := nextIP(c.cfg.TUNAddress.IP)
+			if !defaultTUNNetwork.Contains(next) {
+				return fmt.Errorf("%w: no free tun address in %s", ErrConflict, defaultTUNNetwork)
+			}
+			c.cfg.TUNAddress = &net.IPNet{IP: next, Mask: defaultTUNAddress.Mask}
+		}
+	}
+
+	cl := c.claimed()
+	for other, o := range claims {
+		if other == c {
+			continue
+		}
+		switch {
+		case cl.tunAddr == o.tunAddr:
+			return fmt.Errorf("%w: tun address %s", ErrConflict, cl.tunAddr)
+		case cl.inbound == o.inbound:
+			return fmt.Errorf("%w: inbound proxy %s", ErrConflict, cl.inbound)
+		}
+		if cl.netns == o.netns && cl.table == o.table {
+			if i := slices.IndexFunc(cl.routes, func(r string) bool { return slices.Contains(o.routes, r) }); i >= 0 {
+				return fmt.Errorf("%w: route %s", ErrConflict, cl.routes[i])
+			}
+		}
+		if i := slices.IndexFunc(cl.exclusive, func(r string) bool { return slices.Contains(o.exclusive, r) }); i >= 0 {
+			return fmt.Errorf("%w: %s", ErrConflict, cl.exclusive[i])
+		}
+	}
+	claims[c] = cl
+
+	return nil
+}
+
+// release unregisters resources of the Client.
+func (c *Client) release() error {
+	claimsMu.Lock()
+	delete(claims, c)
+	claimsMu.Unlock()
+
+	return nil
+}
+
+func (c *Client) claimed() claim {
+	cl := claim{tunAddr: c.cfg.TUNAddress.IP.String(), inbound: c.cfg.InboundProxy.String(), netns: c.cfg.Netns}
+	for _, r := range c.cfg.RoutesToTUN {
+		cl.routes = append(cl.routes, r.String())
+	}
+	if c.xSrvIP != nil && c.serverRouteNeeded(c.xSrvIP.IP) {
+		cl.serverRoute = routeOpsOf(c.xrayToGatewayRoute(), false)[0].String()
+	}
+	if c.cfg.RouteIsolation != nil {
+		cl.table = c.cfg.RouteIsolation.Table
+		cl.exclusive = append(cl.exclusive, fmt.Sprintf("routing table %d", cl.table))
+		if cl.serverRoute != "" {
+			cl.serverRoute += fmt.Sprintf(" table %d", cl.table)
+		}
+	}
+	if c.cfg.SystemProxy {
+		cl.exclusive = append(cl.exclusive, "system proxy")
+	}
+	if c.cfg.GatewayMode {
+		cl.exclusive = append(cl.exclusive, "ip forwarding")
+	}
+	if c.cfg.Journal != "" {
+		cl.exclusive = append(cl.exclusive, "journal "+c.cfg.Journal)
+	}
+
+	return cl
+}
+
+// serverRouteShared reports whether another connected Client holds the server route, it is added
+// by the first Client and deleted by the last one.
+func (c *Client) serverRouteShared() bool {
+	claimsMu.Lock()
+	defer claimsMu.Unlock()
+
+	r := claims[c].serverRoute
+	for other, o := range claims {
+		if other != c && r != "" && o.serverRoute == r {
+			return true
+		}
+	}
+
+	return false
+}
+
+func tunAddressTaken(ip net.IP, c *Client) bool {
+	for other, o := range claims {
+		if other != c && o.tunAddr == ip.String() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextIP returns IPv4 address following ip.
+func nextIP(ip net.IP) net.IP {
+	next := slices.Clone(ip.To4())
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i]++; next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
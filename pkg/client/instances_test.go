@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoopback_MultipleClients(t *testing.T) {
+	// The engine of a Client is created by the loopback Engine registered with it, so each Client is
+	// connected before the next one registers its own.
+	personal, personalTUN, routes, personalEngine := newLoopbackClient(t)
+	require.NoError(t, personal.Connect(loopbackScheme+"://test"))
+	work, workTUN, _, workEngine := newLoopbackClient(t)
+	work.cfg.Routes, work.routes = routes, routes // One OS routing table.
+	work.cfg.RoutesToTUN = []*route.Addr{route.MustParseAddr("10.0.0.0/8")}
+	require.NoError(t, work.Connect(loopbackScheme+"://test"))
+	require.Equal(t, "192.18.0.1", personal.TUNAddress().String())
+	require.Equal(t, "192.18.0.2", work.TUNAddress().String(), "default TUN address is taken")
+	require.Equal(t, []string{"delete 127.0.0.3/32 via 127.0.0.2", "add 127.0.0.3/32 via 127.0.0.2"}, routeOps(routes),
+		"server route is shared")
+
+	// Connections of both Clients at the same time reach the proxy of their Client and come back
+	// through their TUN device.
+	flows := []struct {
+		tun  *memTUN
+		app  tcpSegment
+		data string
+	}{
+		{personalTUN, tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: 40000, dstPort: 80}, "personal"},
+		{workTUN, tcpSegment{src: net.IPv4(192, 18, 0, 2), dst: net.IPv4(10, 1, 2, 3), srcPort: 40000, dstPort: 443}, "work"},
+	}
+	for i := range flows {
+		flows[i].app.seq, flows[i].app.flags = 1000, tcpSYN
+		flows[i].tun.in <- flows[i].app.marshal()
+	}
+	for i := range flows {
+		f := &flows[i]
+		synAck := readSegment(t, f.tun)
+		require.Equal(t, byte(tcpSYN|tcpACK), synAck.flags&(tcpSYN|tcpACK), f.data)
+		require.True(t, synAck.src.Equal(f.app.dst), f.data)
+		f.app.seq, f.app.ack, f.app.flags = f.app.seq+1, synAck.seq+1, tcpACK
+		f.tun.in <- f.app.marshal()
+		f.app.flags, f.app.payload = tcpACK|tcpPSH, []byte(f.data)
+		f.tun.in <- f.app.marshal()
+	}
+	for _, f := range flows {
+		var echoed []byte
+		for len(echoed) < len(f.data) {
+			seg := readSegment(t, f.tun)
+			require.Zero(t, seg.flags&tcpRST, "connection reset")
+			require.True(t, seg.dst.Equal(f.app.src), "segment of the other Client")
+			echoed = append(echoed, seg.payload...)
+		}
+		require.Equal(t, f.data, string(echoed))
+	}
+	require.Equal(t, []string{"198.51.100.7:80"}, personalEngine().dialed)
+	require.Equal(t, []string{"10.1.2.3:443"}, workEngine().dialed)
+
+	third, _, _, _ := newLoopbackClient(t)
+	third.cfg.InboundProxy = work.cfg.InboundProxy
+	require.ErrorIs(t, third.Connect(loopbackScheme+"://test"), ErrConflict)
+	third.cfg.InboundProxy = &Proxy{Path: "/run/goxray-third.sock"}
+	require.ErrorIs(t, third.Connect(loopbackScheme+"://test"), ErrConflict, "default routes of personal")
+
+	require.NoError(t, personal.Disconnect(context.Background()))
+	require.Len(t, routes.Routes(), 1, "server route is kept for work")
+	require.NoError(t, work.Disconnect(context.Background()))
+	require.Empty(t, routes.Routes())
+	require.Empty(t, claims)
+}
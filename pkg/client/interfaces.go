@@ -14,7 +14,8 @@ type pipe interface {
 	Copy(ctx context.Context, pipe io.ReadWriteCloser, socks5 string) error
 }
 
-type ipTable interface {
+// RouteTable adds and deletes OS routes, see Config.Routes.
+type RouteTable interface {
 	// Add adds route to ip table.
 	Add(options route.Opts) error
 	// Delete deletes route from ip table.
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Routing tables reserved by Linux.
+const (
+	rtTableDefault = 253
+	rtTableMain    = 254
+	rtTableLocal   = 255
+)
+
+// RouteIsolationOptions installs routes of the Client into a dedicated routing table selected by policy
+// routing rule ("ip rule") instead of the main table, so routes of other clients and VPN software can not
+// shadow them silently: the rule priority decides which table is looked up first. Traffic falls through
+// to the next rules (and the main table) if the table has no route for it. It is supported on Linux only.
+type RouteIsolationOptions struct {
+	// Table is the routing table of the Client routes, e.g. 100. Every Client must have its own table.
+	Table int
+	// Priority of the rule, lower is looked up first (default: chosen by kernel, before the main table).
+	Priority int
+	// Mark limits the rule to packets with the firewall mark, e.g. set by iptables for some programs
+	// (default: all packets).
+	Mark uint32
+}
+
+// Validate checks options values.
+func (o *RouteIsolationOptions) Validate() error {
+	switch o.Table {
+	case 0:
+		return errors.New("table is required")
+	case rtTableDefault, rtTableMain, rtTableLocal:
+		return fmt.Errorf("table %d is reserved", o.Table)
+	}
+	if o.Table < 0 || int64(o.Table) > math.MaxUint32 {
+		return fmt.Errorf("invalid table %d", o.Table)
+	}
+	if o.Priority < 0 {
+		return errors.New("priority must not be negative")
+	}
+
+	return nil
+}
+
+func (o *RouteIsolationOptions) rule() RouteRule {
+	return RouteRule{Priority: o.Priority, Table: o.Table, Mark: o.Mark}
+}
+
+// addRouteRule adds the rule selecting the table of Config.RouteIsolation.
+func (c *Client) addRouteRule() error {
+	rules, ok := c.cfg.Routes.(RuleTable)
+	if !ok {
+		return errors.New("route isolation: routes do not support rules")
+	}
+	rule := c.cfg.RouteIsolation.rule()
+	_ = rules.DeleteRule(rule) // In case previous run failed.
+	err := rules.AddRule(rule)
+	c.audit.record(AuditRuleAdd, rule.String(), err)
+	if err != nil {
+		return fmt.Errorf("route isolation: %w", err)
+	}
+	c.routeRule = &rule
+	c.log(LogRoute).Debug("routes isolated", "table", rule.Table, "priority", rule.Priority, "mark", rule.Mark)
+
+	return nil
+}
+
+// deleteRouteRule deletes the rule added by addRouteRule.
+func (c *Client) deleteRouteRule() error {
+	if c.routeRule == nil {
+		return nil
+	}
+	err := c.cfg.Routes.(RuleTable).DeleteRule(*c.routeRule)
+	c.audit.record(AuditRuleDelete, c.routeRule.String(), err)
+	c.routeRule = nil
+	if err != nil {
+		return fmt.Errorf("route isolation: %w", err)
+	}
+
+	return nil
+}
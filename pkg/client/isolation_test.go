@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteIsolationOptions_Validate(t *testing.T) {
+	require.NoError(t, (&RouteIsolationOptions{Table: 100, Priority: 1000, Mark: 0x1}).Validate())
+	require.ErrorContains(t, (&RouteIsolationOptions{}).Validate(), "table is required")
+	require.ErrorContains(t, (&RouteIsolationOptions{Table: 254}).Validate(), "reserved")
+	require.Error(t, (&RouteIsolationOptions{Table: -1}).Validate())
+	require.Error(t, (&RouteIsolationOptions{Table: 100, Priority: -1}).Validate())
+}
+
+func TestMemoryRouteTable_rules(t *testing.T) {
+	var table MemoryRouteTable
+	rule := RouteRule{Table: 100, Mark: 0x1}
+	require.NoError(t, table.AddRule(rule))
+	require.Error(t, table.AddRule(rule), "rule exists")
+	require.NoError(t, table.AddRule(RouteRule{Table: 101}))
+	require.Equal(t, []RouteRule{rule, {Table: 101}}, table.Rules())
+
+	require.NoError(t, table.DeleteRule(rule))
+	require.Error(t, table.DeleteRule(rule), "no such rule")
+	require.Equal(t, []RouteRule{{Table: 101}}, table.Rules())
+}
+
+func TestLoopback_RouteIsolation(t *testing.T) {
+	work, _, routes, _ := newLoopbackClient(t)
+	work.cfg.RouteIsolation = &RouteIsolationOptions{Table: 100, Priority: 1000}
+	personal, _, _, _ := newLoopbackClient(t)
+
+	require.NoError(t, work.Connect(loopbackScheme+"://test"))
+	require.Equal(t, []RouteRule{{Table: 100, Priority: 1000}}, routes.Rules())
+	require.NoError(t, personal.Connect(loopbackScheme+"://test"), "the same routes in another table")
+
+	other, _, _, _ := newLoopbackClient(t)
+	other.cfg.RoutesToTUN = nil
+	other.cfg.RouteIsolation = &RouteIsolationOptions{Table: 100}
+	require.ErrorIs(t, other.Connect(loopbackScheme+"://test"), ErrConflict)
+
+	require.NoError(t, personal.Disconnect(context.Background()))
+	require.NoError(t, work.Disconnect(context.Background()))
+	require.Empty(t, routes.Rules())
+}
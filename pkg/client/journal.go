@@ -0,0 +1,176 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/goxray/core/network/route"
+
+	"github.com/goxray/tun/pkg/sysproxy"
+)
+
+// journal persists system changes of the connection (see Config.Journal), so they can be undone
+// by Client.Cleanup if the process crashes before Disconnect. The file is removed once all changes are undone.
+type journal struct {
+	path   string
+	logger *slog.Logger
+	mu     sync.Mutex
+	rec    journalRecord
+}
+
+// journalRecord is the journal file content.
+type journalRecord struct {
+	PID int `json:"pid"` // Process which made the changes.
+	// TUNAddress is the address of TUN device, it tells the device of interface routes is ours.
+	TUNAddress  string             `json:"tun_address,omitempty"`
+	Routes      []RouteOp          `json:"routes,omitempty"`       // Installed routes.
+	SystemProxy *sysproxy.Snapshot `json:"system_proxy,omitempty"` // System proxy settings before the change.
+}
+
+func (r *journalRecord) empty() bool {
+	return len(r.Routes) == 0 && r.SystemProxy == nil
+}
+
+// readJournal reads journal file, nil is returned if it does not exist.
+func readJournal(path string) (*journalRecord, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+
+	rec := &journalRecord{}
+	if err = json.Unmarshal(b, rec); err != nil {
+		return nil, fmt.Errorf("parse journal %s: %w", path, err)
+	}
+
+	return rec, nil
+}
+
+// update changes the record by fn and saves it. Journal is best effort, failures to save are logged.
+func (j *journal) update(fn func(rec *journalRecord)) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.rec)
+	if err := j.save(); err != nil {
+		j.logger.Warn("journal not saved, run cleanup if the process crashes", "path", j.path, "err", err)
+	}
+}
+
+// save writes the record, the file is replaced atomically. Empty record removes the file.
+func (j *journal) save() error {
+	if j.rec.empty() {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		return nil
+	}
+
+	j.rec.PID = os.Getpid()
+	b, err := json.MarshalIndent(&j.rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(j.path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(append(b, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), j.path)
+}
+
+// routes records route changes.
+func (j *journal) routes(ops []RouteOp) {
+	j.update(func(rec *journalRecord) {
+		for _, op := range ops {
+			installed := op
+			installed.Delete = false
+			rec.Routes = slices.DeleteFunc(rec.Routes, func(o RouteOp) bool { return o == installed })
+			if !op.Delete {
+				rec.Routes = append(rec.Routes, installed)
+			}
+		}
+	})
+}
+
+// systemProxy records system proxy settings saved before the change, nil once they are restored.
+func (j *journal) systemProxy(s *sysproxy.Snapshot) {
+	j.update(func(rec *journalRecord) { rec.SystemProxy = s })
+}
+
+// device returns TUN device recording its address, interface routes are forgotten once it is closed
+// as they are removed with the device.
+func (j *journal) device(tunnel io.ReadWriteCloser, addr string) io.ReadWriteCloser {
+	if j == nil {
+		return tunnel
+	}
+	j.update(func(rec *journalRecord) { rec.TUNAddress = addr })
+
+	return &journalDevice{ReadWriteCloser: tunnel, j: j}
+}
+
+// journalDevice is TUN device recorded by journal.
+type journalDevice struct {
+	io.ReadWriteCloser
+	j *journal
+}
+
+func (d *journalDevice) Close() error {
+	if err := d.ReadWriteCloser.Close(); err != nil {
+		return err
+	}
+	d.j.update(func(rec *journalRecord) {
+		rec.Routes = slices.DeleteFunc(rec.Routes, func(o RouteOp) bool { return o.IfName != "" })
+	})
+
+	return nil
+}
+
+// journalRoutes is RouteTable recording successful changes to journal.
+type journalRoutes struct {
+	RouteTable
+	j *journal
+}
+
+func (r journalRoutes) Add(options route.Opts) error {
+	if err := r.RouteTable.Add(options); err != nil {
+		return err
+	}
+	r.j.routes(routeOpsOf(options, false))
+
+	return nil
+}
+
+func (r journalRoutes) Delete(options route.Opts) error {
+	if err := r.RouteTable.Delete(options); err != nil {
+		return err
+	}
+	r.j.routes(routeOpsOf(options, true))
+
+	return nil
+}
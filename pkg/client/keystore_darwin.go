@@ -0,0 +1,22 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// keystoreExport returns PKCS#12 data with the identities of the keychains encrypted with password, exported with
+// security tool. The certificate of name is selected by pkcs12Certificate.
+func keystoreExport(name, password string) ([]byte, error) {
+	out, err := exec.Command("security", "export", "-t", "identities", "-f", "pkcs12", "-P", password).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("security export: %w: %s", err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("security export: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !windows
+
+package client
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// keystoreExport is not supported, there is no OS keystore of client certificates.
+func keystoreExport(string, string) ([]byte, error) {
+	return nil, fmt.Errorf("not supported on %s, use certificate file", runtime.GOOS)
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// keystoreExportScript exports the certificate of the personal store of the current user with exportable key,
+// the name and the password are passed by the environment to avoid quoting.
+const keystoreExportScript = `$c = Get-ChildItem Cert:\CurrentUser\My | Where-Object {
+  $_.HasPrivateKey -and $_.GetNameInfo('SimpleName', $false) -eq $env:GOXRAY_KEYSTORE_NAME } | Select-Object -First 1
+if (-not $c) { [Console]::Error.Write('certificate not found'); exit 2 }
+[Convert]::ToBase64String($c.Export('Pfx', $env:GOXRAY_KEYSTORE_PASSWORD))`
+
+// keystoreExport returns PKCS#12 data with the certificate of name and its key encrypted with password,
+// exported with PowerShell.
+func keystoreExport(name, password string) ([]byte, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", keystoreExportScript)
+	cmd.Env = append(os.Environ(), "GOXRAY_KEYSTORE_NAME="+name, "GOXRAY_KEYSTORE_PASSWORD="+password)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("export %q: %w: %s", name, err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("export %q: %w", name, err)
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
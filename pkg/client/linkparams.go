@@ -0,0 +1,131 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// passthroughSchemes are link schemes with transport and security settings in query parameters.
+var passthroughSchemes = map[string]bool{"vless": true, "vmess": true, trojanScheme: true}
+
+// knownLinkParams are query parameters handled by XRay-knife link parsers or by this package.
+var knownLinkParams = map[string]bool{
+	// vless, trojan.
+	"encryption": true, "security": true, "sni": true, "alpn": true, "fp": true, "type": true, "host": true,
+	"path": true, "flow": true, "pbk": true, "sid": true, "spx": true, "headerType": true, "serviceName": true,
+	"mode": true, "allowInsecure": true, "quicSecurity": true, "key": true, "authority": true,
+	// vmess (Shadowrocket notation).
+	"remarks": true, "tls": true, "obfs": true, "obfsParam": true, "peer": true, "alterId": true,
+	// See linkMux.
+	"mux": true, "muxConcurrency": true, "mux_concurrency": true,
+	// See linkECH.
+	"ech": true, "echOuterSni": true,
+}
+
+// linkParamAliases map share link parameter names to XRay json config keys they stand for.
+var linkParamAliases = map[string]string{
+	"pcs": "pinnedPeerCertificateChainSha256",
+	"vcn": "verifyPeerCertInNames",
+}
+
+// passthroughLinkParams applies link query parameters unknown to link parsers to outbound stream settings.
+//
+// Parameter is matched by its json key against the settings of the transport in use, then against
+// the security (tls or reality) settings, e.g. "xPaddingBytes=100-1000" of "xhttp" links.
+// Parameters without a match are returned, in strict mode any unknown parameter is an error.
+func passthroughLinkParams(q url.Values, s *conf.StreamConfig, strict bool) (dropped []string, err error) {
+	var unknown []string
+	for k := range q {
+		if !knownLinkParams[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+
+	if len(unknown) > 0 && strict {
+		return nil, fmt.Errorf("unknown link parameters: %s", strings.Join(unknown, ", "))
+	}
+	if s == nil {
+		return unknown, nil
+	}
+
+	for _, k := range unknown {
+		key := k
+		if alias, ok := linkParamAliases[k]; ok {
+			key = alias
+		}
+
+		ok, err := setStreamParam(s, key, q.Get(k))
+		if err != nil {
+			return nil, fmt.Errorf("link parameter %q: %w", k, err)
+		}
+		if !ok {
+			dropped = append(dropped, k)
+		}
+	}
+
+	return dropped, nil
+}
+
+// setStreamParam sets the field with json key in the first of stream transport and security settings having it.
+func setStreamParam(s *conf.StreamConfig, key, value string) (bool, error) {
+	for _, settings := range []any{
+		s.TCPSettings, s.RAWSettings, s.KCPSettings, s.WSSettings, s.GRPCSettings, s.HTTPUPGRADESettings,
+		s.XHTTPSettings, s.SplitHTTPSettings, s.TLSSettings, s.REALITYSettings,
+	} {
+		v := reflect.ValueOf(settings)
+		if v.IsNil() {
+			continue
+		}
+		if ok, err := setJSONField(v.Elem(), key, value); ok || err != nil {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+// setJSONField decodes value into the struct field with json key. Value is decoded as json,
+// falling back to plain string (comma separated list for list fields).
+func setJSONField(v reflect.Value, key, value string) (bool, error) {
+	t := v.Type()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != key {
+			continue
+		}
+
+		ptr := v.Field(i).Addr().Interface()
+		if json.Unmarshal([]byte(value), ptr) == nil {
+			return true, nil
+		}
+
+		var raw []byte
+		if isListType(t.Field(i).Type) {
+			raw, _ = json.Marshal(strings.Split(value, ","))
+		} else {
+			raw, _ = json.Marshal(value)
+		}
+		if err := json.Unmarshal(raw, ptr); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func isListType(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func TestPassthroughLinkParams(t *testing.T) {
+	q, err := url.ParseQuery("type=xhttp&sni=example.com&xPaddingBytes=100-1000&noGRPCHeader=true&extra=%7B%22noSSEHeader%22%3Atrue%7D" +
+		"&pcs=aa,bb&minVersion=1.3&pqv=key&mux=1")
+	require.NoError(t, err)
+
+	s := &conf.StreamConfig{XHTTPSettings: &conf.SplitHTTPConfig{}, TLSSettings: &conf.TLSConfig{}}
+	dropped, err := passthroughLinkParams(q, s, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"pqv"}, dropped)
+
+	require.Equal(t, conf.Int32Range{Left: 100, Right: 1000, From: 100, To: 1000}, s.XHTTPSettings.XPaddingBytes)
+	require.True(t, s.XHTTPSettings.NoGRPCHeader)
+	require.JSONEq(t, `{"noSSEHeader":true}`, string(s.XHTTPSettings.Extra))
+	require.Equal(t, &[]string{"aa", "bb"}, s.TLSSettings.PinnedPeerCertificateChainSha256)
+	require.Equal(t, "1.3", s.TLSSettings.MinVersion)
+
+	_, err = passthroughLinkParams(q, s, true)
+	require.EqualError(t, err, "unknown link parameters: extra, minVersion, noGRPCHeader, pcs, pqv, xPaddingBytes")
+
+	q, err = url.ParseQuery("noGRPCHeader=maybe")
+	require.NoError(t, err)
+	_, err = passthroughLinkParams(q, &conf.StreamConfig{XHTTPSettings: &conf.SplitHTTPConfig{}}, false)
+	require.ErrorContains(t, err, `link parameter "noGRPCHeader"`)
+}
+
+func TestCreateProxy_LinkParams(t *testing.T) {
+	link := "vless://b831381d-6324-4d53-ad4f-8cda48b30811@127.0.0.5:443?type=ws&security=tls&path=%2Fws&heartbeatPeriod=30&unknown=1"
+
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	xCfg, _, err := cl.buildXrayConfig(link)
+	require.NoError(t, err)
+	require.EqualValues(t, 30, xCfg.OutboundConfigs[0].StreamSetting.WSSettings.HeartbeatPeriod)
+	_, err = newXrayInstance(xCfg)
+	require.NoError(t, err)
+
+	cl.cfg.StrictLinkParams = true
+	_, _, err = cl.buildXrayConfig(link)
+	require.ErrorContains(t, err, "unknown link parameters: heartbeatPeriod, unknown")
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// LogComponent is a subsystem of the Client, its records carry "component" attribute with the name
+// and can be configured apart from the rest by Config.Logs.
+type LogComponent string
+
+const (
+	// LogTUN logs TUN device setup and removal.
+	LogTUN LogComponent = "tun"
+	// LogRoute logs route changes, verification and isolation, and gateway discovery.
+	LogRoute LogComponent = "route"
+	// LogXRay logs XRay core instance and Engine, its level is the XRay core log level too.
+	LogXRay LogComponent = "xray"
+	// LogPipe logs the packet pipe of TUN device and its flows.
+	LogPipe LogComponent = "pipe"
+	// LogDNS logs resolving of the server, NAT64 discovery and DNS of the network namespace.
+	LogDNS LogComponent = "dns"
+)
+
+// Validate checks the component name.
+func (c LogComponent) Validate() error {
+	switch c {
+	case LogTUN, LogRoute, LogXRay, LogPipe, LogDNS:
+		return nil
+	default:
+		return fmt.Errorf("unknown log component %q", c)
+	}
+}
+
+// LogOptions customize records of a LogComponent, see Config.Logs.
+type LogOptions struct {
+	// Level of the component records, it replaces the level of Config.Logger both ways, e.g. slog.LevelError
+	// silences XRay debug output and slog.LevelDebug keeps route changes at debug level with info Logger
+	// (default: Logger level). The Logger handler must check the level in Enabled only, like slog handlers do.
+	Level slog.Leveler
+	// Attrs are added to the component records (default: "component" attribute with the component name).
+	Attrs []slog.Attr
+	// Group nests the attributes of the component records in the group (default: none).
+	Group string
+}
+
+// log returns logger of the component configured by Config.Logs.
+func (c *Client) log(component LogComponent) *slog.Logger {
+	o := c.cfg.Logs[component]
+	if o == nil {
+		return c.cfg.Logger.With("component", string(component))
+	}
+
+	logger := c.cfg.Logger
+	if o.Level != nil {
+		logger = slog.New(&levelHandler{next: logger.Handler(), level: o.Level})
+	}
+	if o.Attrs != nil {
+		logger = slog.New(logger.Handler().WithAttrs(o.Attrs))
+	} else {
+		logger = logger.With("component", string(component))
+	}
+	if o.Group != "" {
+		logger = logger.WithGroup(o.Group)
+	}
+
+	return logger
+}
+
+// levelHandler passes records of level to next handler regardless of its level.
+type levelHandler struct {
+	next  slog.Handler
+	level slog.Leveler
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{next: h.next.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{next: h.next.WithGroup(name), level: h.level}
+}
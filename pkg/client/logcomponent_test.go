@@ -0,0 +1,49 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	xcommlog "github.com/xtls/xray-core/common/log"
+)
+
+func TestClient_log(t *testing.T) {
+	out := &bytes.Buffer{}
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.Logger = slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{
+		Level:       slog.LevelInfo,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr { return dropTime(a) },
+	}))
+
+	cl.log(LogPipe).Info("flows drained", "finished", 2)
+	cl.log(LogRoute).Debug("route added")
+	require.Equal(t, "level=INFO msg=\"flows drained\" component=pipe finished=2\n", out.String())
+
+	out.Reset()
+	cl.cfg.Logs = map[LogComponent]*LogOptions{
+		LogRoute: {Level: slog.LevelDebug},
+		LogXRay:  {Level: slog.LevelError},
+		LogPipe:  {Attrs: []slog.Attr{slog.String("subsystem", "tun2socks")}, Group: "pipe"},
+	}
+	cl.log(LogRoute).Debug("route added")
+	cl.log(LogXRay).Warn("unsupported link parameters ignored")
+	cl.log(LogPipe).Info("flows drained", "finished", 2)
+	require.Equal(t, "level=DEBUG msg=\"route added\" component=route\n"+
+		"level=INFO msg=\"flows drained\" subsystem=tun2socks pipe.finished=2\n", out.String())
+
+	require.Equal(t, xcommlog.Severity_Error, xRayLogLevel(cl.log(LogXRay).Handler()))
+	require.True(t, cl.log(LogRoute).Enabled(context.Background(), slog.LevelDebug))
+	require.NoError(t, LogDNS.Validate())
+	require.Error(t, LogComponent("xray-core").Validate())
+}
+
+func dropTime(a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+
+	return a
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLogDedupWindow is the window repeated log records are counted in instead of logged, see Config.LogDedup.
+const DefaultLogDedupWindow = 10 * time.Second
+
+// maxLogDedupRecords limits distinct records counted in a window, further records are logged as they are.
+const maxLogDedupRecords = 1024
+
+// dedupLogger returns logger which logs the first of identical records (same level, message and attributes)
+// in window and counts the rest, logged as "message repeated N times" once the window ends, so failures repeated
+// thousands of times per second do not flood the log. Logger is returned as it is if window is negative,
+// zero window is DefaultLogDedupWindow.
+func dedupLogger(logger *slog.Logger, window time.Duration) *slog.Logger {
+	if window < 0 {
+		return logger
+	}
+
+	return slog.New(&dedupHandler{
+		next:  logger.Handler(),
+		state: &dedupState{window: cmp.Or(window, DefaultLogDedupWindow), seen: make(map[string]*int)},
+	})
+}
+
+// defaultLogger is Config.Logger by default.
+func defaultLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stdout, nil))
+}
+
+// dedupHandler is slog.Handler of dedupLogger.
+type dedupHandler struct {
+	next slog.Handler
+	// scope are the attributes and groups of WithAttrs and WithGroup, records of other scopes are different.
+	scope string
+	state *dedupState
+}
+
+// dedupState is shared by the handler and its WithAttrs and WithGroup handlers.
+type dedupState struct {
+	window time.Duration
+	mu     sync.Mutex
+	seen   map[string]*int // Repeats of the records logged in the window.
+}
+
+func (h *dedupHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *dedupHandler) Handle(ctx context.Context, r slog.Record) error {
+	key := h.key(r)
+	s := h.state
+	s.mu.Lock()
+	if repeated, ok := s.seen[key]; ok {
+		*repeated++
+		s.mu.Unlock()
+		return nil
+	}
+	if len(s.seen) < maxLogDedupRecords {
+		repeated := new(int)
+		s.seen[key] = repeated
+		first := r.Clone()
+		time.AfterFunc(s.window, func() { h.flush(key, repeated, first) })
+	}
+	s.mu.Unlock()
+
+	return h.next.Handle(ctx, r)
+}
+
+// flush ends the window of record r, its repeats are logged.
+func (h *dedupHandler) flush(key string, repeated *int, r slog.Record) {
+	s := h.state
+	s.mu.Lock()
+	delete(s.seen, key)
+	n := *repeated
+	s.mu.Unlock()
+	if n == 0 {
+		return
+	}
+
+	summary := slog.NewRecord(time.Now(), r.Level, fmt.Sprintf("%s (message repeated %d times)", r.Message, n), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		summary.AddAttrs(a)
+		return true
+	})
+	_ = h.next.Handle(context.Background(), summary)
+}
+
+// key identifies identical records.
+func (h *dedupHandler) key(r slog.Record) string {
+	var b strings.Builder
+	b.WriteString(r.Level.String())
+	b.WriteString(h.scope)
+	b.WriteString("\x00" + r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		b.WriteString("\x00" + a.String())
+		return true
+	})
+
+	return b.String()
+}
+
+func (h *dedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scope := h.scope
+	for _, a := range attrs {
+		scope += "\x00" + a.String()
+	}
+
+	return &dedupHandler{next: h.next.WithAttrs(attrs), scope: scope, state: h.state}
+}
+
+func (h *dedupHandler) WithGroup(name string) slog.Handler {
+	return &dedupHandler{next: h.next.WithGroup(name), scope: h.scope + "\x00[" + name + "]", state: h.state}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is bytes.Buffer safe for the log records flushed by timers.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestDedupLogger(t *testing.T) {
+	out := &syncBuffer{}
+	logger := dedupLogger(slog.New(slog.NewTextHandler(out, nil)), 50*time.Millisecond)
+
+	for range 1000 {
+		logger.Error("relay failed", "err", "connection refused")
+	}
+	logger.Error("relay failed", "err", "timeout")
+	logger.With("flow", "udp").Error("relay failed", "err", "connection refused")
+	logger.Warn("relay failed", "err", "connection refused")
+	require.Equal(t, 4, strings.Count(out.String(), "\n"), "only distinct records must be logged in the window")
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(out.String(), `msg="relay failed (message repeated 999 times)" err="connection refused"`)
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, 5, strings.Count(out.String(), "\n"), "records logged once must not be summarized")
+
+	logger.Error("relay failed", "err", "connection refused")
+	require.Equal(t, 6, strings.Count(out.String(), "\n"), "record must be logged again after the window")
+
+	plain := slog.New(slog.NewTextHandler(out, nil))
+	require.Same(t, plain, dedupLogger(plain, -1))
+}
@@ -0,0 +1,462 @@
+package client
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/internal/socks5"
+)
+
+// Loopback harness runs the real Client (Connect, tun2socks pipe, Disconnect) without privileges, TUN devices
+// or network access: packets are exchanged with memTUN, routes are recorded by MemoryRouteTable and the "loopback"
+// Engine serves SOCKS5 on a loopback port, relaying connections to in-memory echo servers.
+
+const loopbackScheme = "loopback"
+
+// memTUN is an in-memory TUN device: packets sent to in are read by the Client, written packets go to out.
+type memTUN struct {
+	in        chan []byte
+	out       chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMemTUN() *memTUN {
+	return &memTUN{in: make(chan []byte, 64), out: make(chan []byte, 64), closed: make(chan struct{})}
+}
+
+func (t *memTUN) Read(p []byte) (int, error) {
+	select {
+	case pkt := <-t.in:
+		return copy(p, pkt), nil
+	case <-t.closed:
+		return 0, io.EOF
+	}
+}
+
+func (t *memTUN) Write(p []byte) (int, error) {
+	select {
+	case t.out <- append([]byte(nil), p...):
+		return len(p), nil
+	case <-t.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (t *memTUN) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+// loopbackEngine serves SOCKS5 on EngineOpts.Inbound, connections are relayed to in-memory echo servers.
+type loopbackEngine struct {
+	inbound   Proxy
+	failStart bool // Start fails, set by "loopback://fail-start" link.
+	// sink discards the data of connections instead of echoing it, counting it in received.
+	// It is set by "loopback://sink" link.
+	sink     bool
+	received atomic.Int64
+	// http answers HTTP requests of connections with 204 status, "/json" with exit info of NL,
+	// it is set by "loopback://http" link.
+	http bool
+	ln   net.Listener
+	srv  *socks5.Server
+
+	mu     sync.Mutex
+	dialed []string
+	closed bool
+}
+
+func (e *loopbackEngine) Start() error {
+	if e.failStart {
+		return errors.New("engine start err")
+	}
+
+	var err error
+	if e.ln, err = net.Listen(e.inbound.Network(), e.inbound.String()); err != nil {
+		return err
+	}
+	e.srv = socks5.NewServer(func(_ context.Context, _, addr string) (net.Conn, error) {
+		e.mu.Lock()
+		e.dialed = append(e.dialed, addr)
+		e.mu.Unlock()
+
+		client, server := net.Pipe()
+		go func() {
+			if e.http {
+				br := bufio.NewReader(server)
+				for {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						break
+					}
+					_ = req.Body.Close()
+					if req.URL.Path == "/json" {
+						body := `{"ip":"192.0.2.1","country":"NL"}`
+						_, _ = fmt.Fprintf(server, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+						continue
+					}
+					_, _ = io.WriteString(server, "HTTP/1.1 204 No Content\r\n\r\n")
+				}
+			} else if e.sink {
+				buf := make([]byte, 32<<10)
+				for {
+					n, err := server.Read(buf)
+					e.received.Add(int64(n))
+					if err != nil {
+						break
+					}
+				}
+			} else {
+				_, _ = io.Copy(server, server)
+			}
+			_ = server.Close()
+		}()
+		return client, nil
+	})
+	go func() { _ = e.srv.Serve(e.ln) }()
+
+	return nil
+}
+
+func (e *loopbackEngine) Close() error {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+	if e.ln == nil {
+		return nil
+	}
+	_ = e.ln.Close()
+	return e.srv.Close()
+}
+
+func (e *loopbackEngine) ServerAddr() string { return "127.0.0.3" }
+
+// newLoopbackClient returns Client connecting "loopback://" links through memTUN, routes and the created
+// engine are returned with it once connected.
+func newLoopbackClient(t testing.TB) (*Client, *memTUN, *MemoryRouteTable, func() *loopbackEngine) {
+	t.Helper()
+
+	var (
+		mu  sync.Mutex
+		eng *loopbackEngine
+	)
+	RegisterEngine(loopbackScheme, func(link string, opts EngineOpts) (Engine, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		eng = &loopbackEngine{
+			inbound:   opts.Inbound,
+			failStart: strings.HasSuffix(link, "://fail-start"),
+			sink:      strings.HasSuffix(link, "://sink"),
+			http:      strings.HasSuffix(link, "://http"),
+		}
+		return eng, nil
+	})
+	t.Cleanup(func() {
+		enginesMu.Lock()
+		delete(engines, loopbackScheme)
+		enginesMu.Unlock()
+	})
+
+	tun, routes := newMemTUN(), &MemoryRouteTable{}
+	flows, qos, balancer := newFlowTable(), newQoSTable(), newBalancerTable()
+	shards, udp, quic := newPacketShards(), newUDPRelay(), newQUICPolicy()
+	gateway := net.IPv4(127, 0, 0, 2)
+	c := &Client{
+		cfg: Config{
+			GatewayIP:    &gateway,
+			InboundProxy: &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()},
+			TUNAddress:   defaultTUNAddress,
+			MTU:          tunMTU,
+			RoutesToTUN:  DefaultRoutesToTUN,
+			Routes:       routes,
+			Logger:       slog.New(slog.DiscardHandler),
+		},
+		tunnelStopped: make(chan error),
+		openTUN:       func() (io.ReadWriteCloser, error) { return tun, nil },
+		pipe:          newSocksPipe(tunMTU, DefaultUDPIdleTimeout, flows, qos, balancer, shards, udp, quic),
+		routes:        routes,
+		flows:         flows,
+		qos:           qos,
+		balancer:      balancer,
+		shards:        shards,
+		udp:           udp,
+		quic:          quic,
+	}
+
+	return c, tun, routes, func() *loopbackEngine {
+		mu.Lock()
+		defer mu.Unlock()
+		return eng
+	}
+}
+
+// routeOps returns ops recorded by routes as strings.
+func routeOps(routes *MemoryRouteTable) []string {
+	var ops []string
+	for _, op := range routes.Ops() {
+		ops = append(ops, op.String())
+	}
+
+	return ops
+}
+
+// TCP flags in addition to tcpSYN and tcpACK.
+const (
+	tcpRST = 0x04
+	tcpPSH = 0x08
+)
+
+// tcpSegment is a TCP segment in IPv4 packet.
+type tcpSegment struct {
+	src, dst         net.IP
+	srcPort, dstPort uint16
+	seq, ack         uint32
+	flags            byte
+	payload          []byte
+}
+
+// marshal returns IPv4 packet of the segment with valid checksums.
+func (s tcpSegment) marshal() []byte {
+	pkt := make([]byte, 40+len(s.payload))
+	s.marshalTo(pkt)
+
+	return pkt
+}
+
+// marshalTo writes IPv4 packet of the segment with valid checksums into pkt without allocations,
+// it returns the packet length. pkt must fit the packet.
+func (s tcpSegment) marshalTo(pkt []byte) int {
+	ip, tcp := pkt[:20], pkt[20:40+len(s.payload)]
+	clear(pkt[:40])
+	binary.BigEndian.PutUint16(tcp[0:2], s.srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], s.dstPort)
+	binary.BigEndian.PutUint32(tcp[4:8], s.seq)
+	binary.BigEndian.PutUint32(tcp[8:12], s.ack)
+	tcp[12] = 5 << 4
+	tcp[13] = s.flags
+	binary.BigEndian.PutUint16(tcp[14:16], 65535)
+	copy(tcp[20:], s.payload)
+
+	var pseudo [12]byte
+	copy(pseudo[0:4], s.src.To4())
+	copy(pseudo[4:8], s.dst.To4())
+	pseudo[9] = protoTCP
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:18], foldChecksum(sumChecksum(sumChecksum(0, pseudo[:]), tcp)))
+
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(tcp)))
+	ip[8] = 64
+	ip[9] = protoTCP
+	copy(ip[12:16], s.src.To4())
+	copy(ip[16:20], s.dst.To4())
+	binary.BigEndian.PutUint16(ip[10:12], checksum(ip))
+
+	return len(ip) + len(tcp)
+}
+
+// parseTCPSegment parses IPv4 packet with TCP segment.
+func parseTCPSegment(pkt []byte) (tcpSegment, bool) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != protoTCP {
+		return tcpSegment{}, false
+	}
+	tcp := pkt[int(pkt[0]&0x0f)*4:]
+	if len(tcp) < 20 {
+		return tcpSegment{}, false
+	}
+
+	return tcpSegment{
+		src: net.IP(pkt[12:16]), dst: net.IP(pkt[16:20]),
+		srcPort: binary.BigEndian.Uint16(tcp[0:2]), dstPort: binary.BigEndian.Uint16(tcp[2:4]),
+		seq: binary.BigEndian.Uint32(tcp[4:8]), ack: binary.BigEndian.Uint32(tcp[8:12]),
+		flags: tcp[13], payload: tcp[int(tcp[12]>>4)*4:],
+	}, true
+}
+
+// checksum is the Internet checksum (RFC 1071).
+func checksum(b []byte) uint16 {
+	return foldChecksum(sumChecksum(0, b))
+}
+
+// sumChecksum adds b to the Internet checksum sum, only the last b may be of odd length.
+func sumChecksum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+
+	return sum
+}
+
+func foldChecksum(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+
+	return ^uint16(sum)
+}
+
+// readSegment returns the next TCP segment the Client writes to tun.
+func readSegment(t *testing.T, tun *memTUN) tcpSegment {
+	t.Helper()
+	for {
+		select {
+		case pkt := <-tun.out:
+			if seg, ok := parseTCPSegment(pkt); ok {
+				return seg
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no segment from the client")
+		}
+	}
+}
+
+func TestLoopback_ConnectCopyDisconnect(t *testing.T) {
+	c, tun, routes, engine := newLoopbackClient(t)
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	st := c.ConnectStats()
+	require.False(t, st.Started.IsZero())
+	require.GreaterOrEqual(t, st.StartProxy, 100*time.Millisecond, "includes the XRay setup delay")
+	require.GreaterOrEqual(t, st.Connected, st.Parse+st.StartProxy+st.CreateTUN+st.AddRoutes)
+	require.Zero(t, st.FirstByte, "nothing received yet")
+
+	app := tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: 40000, dstPort: 80, seq: 1000}
+	app.flags = tcpSYN
+	tun.in <- app.marshal()
+	synAck := readSegment(t, tun)
+	require.Equal(t, byte(tcpSYN|tcpACK), synAck.flags&(tcpSYN|tcpACK))
+	require.Equal(t, app.seq+1, synAck.ack)
+	require.True(t, synAck.src.Equal(app.dst))
+
+	app.seq, app.ack = app.seq+1, synAck.seq+1
+	app.flags = tcpACK
+	tun.in <- app.marshal()
+	app.flags, app.payload = tcpACK|tcpPSH, []byte("ping")
+	tun.in <- app.marshal()
+
+	var echoed []byte
+	for len(echoed) < len("ping") {
+		seg := readSegment(t, tun)
+		require.Zero(t, seg.flags&tcpRST, "connection reset")
+		echoed = append(echoed, seg.payload...)
+	}
+	require.Equal(t, "ping", string(echoed))
+	require.Greater(t, c.ConnectStats().FirstByte, st.Connected)
+
+	require.Eventually(t, func() bool { return len(c.Flows()) == 1 }, time.Second, time.Millisecond)
+	flow := c.Flows()[0]
+	require.Equal(t, "tcp", flow.Network)
+	require.Equal(t, "198.51.100.7:80", flow.Destination)
+	require.Equal(t, []string{"198.51.100.7:80"}, engine().dialed)
+	require.Positive(t, c.BytesRead())
+	require.Positive(t, c.BytesWritten())
+
+	require.NoError(t, c.Disconnect(context.Background()))
+	require.Equal(t, 1, c.FlowStats().ForceClosed, "echo connection is not closed by the app")
+	require.Equal(t, []string{
+		"delete 127.0.0.3/32 via 127.0.0.2", // Dangling route of a failed run, it is missing.
+		"add 127.0.0.3/32 via 127.0.0.2",
+		"delete 127.0.0.3/32 via 127.0.0.2",
+	}, routeOps(routes))
+	require.Empty(t, routes.Routes())
+	_, err := tun.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF, "TUN is closed")
+}
+
+// failingRoutes fails to add routes.
+type failingRoutes struct{ *MemoryRouteTable }
+
+func (failingRoutes) Add(route.Opts) error { return errors.New("add route err") }
+
+func TestLoopback_ConnectRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		link    string
+		setup   func(c *Client, dir string)
+		err     string
+		started bool // Engine is started before the failure.
+		opened  bool // TUN device is opened before the failure.
+	}{
+		{
+			name: "capture",
+			setup: func(c *Client, dir string) {
+				c.cfg.Capture = &CaptureOptions{FlowLog: filepath.Join(dir, "missing", "flows.jsonl")}
+			},
+			err: "capture: open flow log",
+		},
+		{
+			name: "engine start",
+			link: loopbackScheme + "://fail-start",
+			err:  "engine start err",
+		},
+		{
+			name: "TUN device",
+			setup: func(c *Client, _ string) {
+				c.openTUN = func() (io.ReadWriteCloser, error) { return nil, errors.New("tun err") }
+			},
+			err:     "setup TUN device: tun err",
+			started: true,
+		},
+		{
+			name:    "server route",
+			setup:   func(c *Client, _ string) { c.routes = failingRoutes{&MemoryRouteTable{}} },
+			err:     "add xray server route exception: add route err",
+			started: true,
+			opened:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, tun, routes, engine := newLoopbackClient(t)
+			dir := t.TempDir()
+			c.cfg.Capture = &CaptureOptions{FlowLog: filepath.Join(dir, "flows.jsonl")}
+			if tt.setup != nil {
+				tt.setup(c, dir)
+			}
+			link := cmp.Or(tt.link, loopbackScheme+"://test")
+
+			require.ErrorContains(t, c.Connect(link), tt.err)
+			e := engine()
+			require.Equal(t, tt.started, e.ln != nil, "engine started")
+			require.Equal(t, tt.started, e.closed, "started engine is closed")
+			select {
+			case <-tun.closed:
+				require.True(t, tt.opened, "TUN device is closed")
+			default:
+				require.False(t, tt.opened, "opened TUN device is closed")
+			}
+			if c.capture != nil {
+				require.True(t, c.capture.log().stopped, "capture is closed")
+			}
+			require.Empty(t, routes.Routes())
+			require.Nil(t, c.stopTunnel)
+			require.NoError(t, c.Disconnect(context.Background()), "not connected")
+
+			// The failure does not affect the next connection.
+			c, _, _, _ = newLoopbackClient(t)
+			require.NoError(t, c.Connect(loopbackScheme+"://test"))
+			require.NoError(t, c.Disconnect(context.Background()))
+			require.NoError(t, c.Disconnect(context.Background()), "disconnected already")
+		})
+	}
+}
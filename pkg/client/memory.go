@@ -0,0 +1,99 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+
+	"github.com/xtls/xray-core/features/policy"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+const (
+	// minMemoryBudget is the smallest MemoryOptions.Budget, XRay core alone takes about as much.
+	minMemoryBudget = 16 << 20
+	// Relay buffer bounds of each direction of tunneled TCP connection, io.Copy uses the maximum.
+	minRelayBuffer = 4 << 10
+	maxRelayBuffer = 32 << 10
+	// Per connection buffer bounds of XRay core, the maximum is XRay default on 64-bit desktop platforms.
+	minXrayBuffer = 4 << 10
+	maxXrayBuffer = 512 << 10
+	// flowOverhead is the memory of tunneled connection besides buffers: goroutines, TCP/IP stack and socks state.
+	flowOverhead = 32 << 10
+	// minBudgetFlows is the least flow limit derived from the budget.
+	minBudgetFlows = 64
+)
+
+// MemoryOptions size buffers and tables of the Client to the memory of the device, so it runs reliably on
+// router-class devices with 128–256 MB of RAM. Smaller buffers cost throughput of a single connection.
+type MemoryOptions struct {
+	// Budget is the memory the Client may use in bytes, at least 16 MiB. Half of it is given to tunneled
+	// connections: relay buffers and XRay core per connection buffers are sized to the budget and FlowOptions.MaxFlows
+	// defaults to the number of connections fitting into it.
+	Budget int64
+	// GoMemLimit sets the soft memory limit of the Go runtime (GOMEMLIMIT) to Budget, so the garbage collector
+	// runs more often instead of the device running out of memory. The limit is process-wide and it is kept
+	// after Disconnect. It is not set if GOMEMLIMIT environment variable is.
+	GoMemLimit bool
+}
+
+// Validate checks options values.
+func (o *MemoryOptions) Validate() error {
+	if o.Budget < minMemoryBudget {
+		return fmt.Errorf("budget must be at least %d MiB", minMemoryBudget>>20)
+	}
+
+	return nil
+}
+
+// memoryPlan is sizing of the Client derived from MemoryOptions, zero values are defaults.
+type memoryPlan struct {
+	relayBuffer int   // Copy buffer of each direction of tunneled TCP connection.
+	xrayBuffer  int32 // XRay core buffer of each connection.
+	maxFlows    int   // Flow limit if FlowOptions.MaxFlows is not set.
+}
+
+// plan sizes buffers and tables to the budget, nil options are defaults.
+func (o *MemoryOptions) plan() memoryPlan {
+	if o == nil {
+		return memoryPlan{}
+	}
+
+	p := memoryPlan{
+		relayBuffer: int(min(max(o.Budget>>12, minRelayBuffer), maxRelayBuffer)),
+		xrayBuffer:  int32(min(max(o.Budget>>10, minXrayBuffer), maxXrayBuffer)),
+	}
+	// XRay default is smaller on 32-bit and ARM platforms, it is not raised.
+	if d := policy.SessionDefault().Buffer.PerConnection; d >= 0 && d < p.xrayBuffer {
+		p.xrayBuffer = d
+	}
+	flowCost := int64(2*p.relayBuffer) + int64(p.xrayBuffer) + flowOverhead
+	p.maxFlows = int(max(o.Budget/2/flowCost, minBudgetFlows))
+
+	return p
+}
+
+// addMemoryPolicy limits XRay core per connection buffers of cfg to the plan.
+func addMemoryPolicy(cfg *conf.Config, p memoryPlan) {
+	if p == (memoryPlan{}) {
+		return
+	}
+
+	kib := p.xrayBuffer >> 10
+	xrayPolicyLevel(cfg).BufferSize = &kib
+}
+
+// setGoMemLimit sets Go runtime memory limit to Config.MemoryBudget if MemoryOptions.GoMemLimit is set.
+func (c *Client) setGoMemLimit() {
+	o := c.cfg.MemoryBudget
+	if o == nil || !o.GoMemLimit {
+		return
+	}
+	if os.Getenv("GOMEMLIMIT") != "" {
+		c.cfg.Logger.Debug("GOMEMLIMIT is set, memory budget is not applied to Go runtime")
+		return
+	}
+
+	debug.SetMemoryLimit(o.Budget)
+	c.cfg.Logger.Debug("Go runtime memory limit set", "bytes", o.Budget)
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/features/policy"
+)
+
+func TestMemoryOptions_plan(t *testing.T) {
+	require.Equal(t, memoryPlan{}, (*MemoryOptions)(nil).plan())
+	require.NoError(t, (&MemoryOptions{Budget: 64 << 20}).Validate())
+	require.ErrorContains(t, (&MemoryOptions{Budget: 8 << 20}).Validate(), "at least 16 MiB")
+
+	xrayDefault := policy.SessionDefault().Buffer.PerConnection
+	xrayBuffer := func(size int32) int32 {
+		if xrayDefault >= 0 && xrayDefault < size {
+			return xrayDefault
+		}
+		return size
+	}
+	for budget, want := range map[int64]memoryPlan{
+		16 << 20:  {relayBuffer: 4 << 10, xrayBuffer: xrayBuffer(16 << 10)},
+		128 << 20: {relayBuffer: 32 << 10, xrayBuffer: xrayBuffer(128 << 10)},
+		4 << 30:   {relayBuffer: 32 << 10, xrayBuffer: xrayBuffer(512 << 10)},
+	} {
+		got := (&MemoryOptions{Budget: budget}).plan()
+		require.Equal(t, want.relayBuffer, got.relayBuffer, budget)
+		require.Equal(t, want.xrayBuffer, got.xrayBuffer, budget)
+		flowCost := int64(2*got.relayBuffer) + int64(got.xrayBuffer) + flowOverhead
+		require.Equal(t, int(max(budget/2/flowCost, minBudgetFlows)), got.maxFlows, budget)
+	}
+}
+
+func TestClient_MemoryBudget(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.MemoryBudget = &MemoryOptions{Budget: 128 << 20}
+
+	spec, err := cl.parseLink("trojan://pass@127.0.0.5:443?security=tls")
+	require.NoError(t, err)
+	level := spec.xray.Policy.Levels[0]
+	require.NotNil(t, level)
+	require.Equal(t, cl.cfg.MemoryBudget.plan().xrayBuffer>>10, *level.BufferSize)
+	_, err = newXrayInstance(spec.xray)
+	require.NoError(t, err)
+
+	cl.cfg.MemoryBudget = &MemoryOptions{Budget: 1 << 20}
+	_, err = cl.parseLink("trojan://pass@127.0.0.5:443?security=tls")
+	require.ErrorContains(t, err, "invalid config: memory budget: budget must be at least 16 MiB")
+}
+
+func TestFlowTable_setMemory(t *testing.T) {
+	flows := newFlowTable()
+	flows.setMemory(memoryPlan{maxFlows: 1, relayBuffer: 8 << 10})
+	require.Equal(t, 8<<10, flows.bufferSize())
+	require.NoError(t, flows.reserve(context.Background()))
+	require.ErrorIs(t, flows.reserve(context.Background()), errFlowLimit, "budget limits flows")
+
+	flows.setLimit(&FlowOptions{MaxFlows: 2})
+	require.NoError(t, flows.reserve(context.Background()), "MaxFlows overrides the budget")
+	require.ErrorIs(t, flows.reserve(context.Background()), errFlowLimit)
+	flows.setMemory(memoryPlan{})
+	flows.setLimit(nil)
+	require.NoError(t, flows.reserve(context.Background()))
+	require.Zero(t, flows.bufferSize())
+}
+
+func TestClient_setGoMemLimit(t *testing.T) {
+	prev := debug.SetMemoryLimit(-1)
+	t.Cleanup(func() { debug.SetMemoryLimit(prev) })
+	t.Setenv("GOMEMLIMIT", "")
+
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.MemoryBudget = &MemoryOptions{Budget: 1 << 40}
+	cl.setGoMemLimit()
+	require.Equal(t, prev, debug.SetMemoryLimit(-1), "not set without GoMemLimit")
+
+	cl.cfg.MemoryBudget.GoMemLimit = true
+	cl.setGoMemLimit()
+	require.EqualValues(t, 1<<40, debug.SetMemoryLimit(-1))
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultMirrorQueue is the default number of mirrored packets waiting to be written.
+const DefaultMirrorQueue = 256
+
+// ErrMirrorActive is returned by Client.StartMirror if the traffic is mirrored already.
+var ErrMirrorActive = errors.New("mirror is active")
+
+// MirrorOptions configure the traffic mirror, see Client.StartMirror.
+type MirrorOptions struct {
+	// BytesPerSecond limits mirrored traffic, packets over the limit are dropped (default: unlimited).
+	BytesPerSecond int
+	// Queue is the number of packets waiting to be written, packets are dropped when it is full,
+	// so a slow writer does not slow down the tunnel (default: DefaultMirrorQueue).
+	Queue int
+}
+
+// Validate checks options values.
+func (o *MirrorOptions) Validate() error {
+	if o.BytesPerSecond < 0 {
+		return errors.New("bytes per second must not be negative")
+	}
+	if o.Queue < 0 {
+		return errors.New("queue must not be negative")
+	}
+
+	return nil
+}
+
+// MirrorStats are packet counters of the traffic mirror.
+type MirrorStats struct {
+	Mirrored uint64 // Packets written to the mirror writer.
+	Dropped  uint64 // Packets dropped over the rate limit, because of full queue or failed to write.
+}
+
+// StartMirror duplicates IP packets traversing TUN device (in both directions) to w, e.g. for IDS integration
+// or debugging. Each packet is written with a single Write call, so w may be a second TUN device, a vsock
+// or a datagram connection. Packets are written asynchronously and dropped rather than delaying the tunnel.
+//
+// The mirror is kept across reconnects until StopMirror, nil opts use defaults.
+func (c *Client) StartMirror(w io.Writer, opts *MirrorOptions) error {
+	if opts == nil {
+		opts = &MirrorOptions{}
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	m := &mirror{
+		w:       w,
+		packets: make(chan []byte, cmp.Or(opts.Queue, DefaultMirrorQueue)),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	if opts.BytesPerSecond > 0 {
+		m.limiter = rate.NewLimiter(rate.Limit(opts.BytesPerSecond), max(opts.BytesPerSecond, maxPacketSize))
+	}
+	if !c.mirror.CompareAndSwap(nil, m) {
+		return ErrMirrorActive
+	}
+	go m.run()
+
+	return nil
+}
+
+// StopMirror stops the traffic mirror and returns its counters, packets waiting in the queue are dropped.
+func (c *Client) StopMirror() MirrorStats {
+	m := c.mirror.Swap(nil)
+	if m == nil {
+		return MirrorStats{}
+	}
+	close(m.done)
+	<-m.exited
+
+	return MirrorStats{Mirrored: m.mirrored.Load(), Dropped: m.dropped.Load() + uint64(len(m.packets))}
+}
+
+// maxPacketSize is the largest IP packet, the rate limiter burst is at least the size.
+const maxPacketSize = 65535
+
+// mirror writes copies of packets to w in its own goroutine.
+type mirror struct {
+	w       io.Writer
+	limiter *rate.Limiter // Nil if unlimited.
+	packets chan []byte
+	done    chan struct{} // Closed by StopMirror.
+	exited  chan struct{} // Closed when run exits.
+
+	mirrored, dropped atomic.Uint64
+}
+
+// copy queues copy of pkt unless it is over the rate limit or the queue is full.
+func (m *mirror) copy(pkt []byte) {
+	if m.limiter != nil && !m.limiter.AllowN(time.Now(), len(pkt)) {
+		m.dropped.Add(1)
+		return
+	}
+	select {
+	case m.packets <- bytes.Clone(pkt):
+	default:
+		m.dropped.Add(1)
+	}
+}
+
+func (m *mirror) run() {
+	defer close(m.exited)
+	for {
+		select {
+		case <-m.done:
+			return
+		case pkt := <-m.packets:
+			if _, err := m.w.Write(pkt); err != nil {
+				m.dropped.Add(1)
+				continue
+			}
+			m.mirrored.Add(1)
+		}
+	}
+}
+
+// mirrorTunnel mirrors packets read from TUN device and written to it while the mirror is set.
+// Without the mirror it costs an atomic load per packet.
+type mirrorTunnel struct {
+	io.ReadWriteCloser
+	mirror *atomic.Pointer[mirror]
+}
+
+func (t *mirrorTunnel) Read(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Read(p)
+	if m := t.mirror.Load(); m != nil && n > 0 {
+		m.copy(p[:n])
+	}
+
+	return n, err
+}
+
+func (t *mirrorTunnel) Write(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(p)
+	if m := t.mirror.Load(); m != nil && err == nil {
+		m.copy(p)
+	}
+
+	return n, err
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// chanWriter sends written packets to the channel.
+type chanWriter chan []byte
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- p
+	return len(p), nil
+}
+
+func TestClient_Mirror(t *testing.T) {
+	c := &Client{}
+	tun := newTestTUN()
+	tunnel := &mirrorTunnel{ReadWriteCloser: tun, mirror: &c.mirror}
+
+	// Not mirrored without the mirror.
+	_, err := tunnel.Write([]byte("before"))
+	require.NoError(t, err)
+
+	out := make(chanWriter, 8)
+	require.NoError(t, c.StartMirror(out, nil))
+	require.ErrorIs(t, c.StartMirror(out, nil), ErrMirrorActive)
+
+	tun.in <- []byte("outbound")
+	buf := make([]byte, tunMTU)
+	n, err := tunnel.Read(buf)
+	require.NoError(t, err)
+	buf[0] = 'X' // The mirror has its own copy.
+	_, err = tunnel.Write([]byte("inbound"))
+	require.NoError(t, err)
+	require.Equal(t, "outbound", string(<-out))
+	require.Equal(t, 8, n)
+	require.Equal(t, "inbound", string(<-out))
+
+	require.Equal(t, MirrorStats{Mirrored: 2}, c.StopMirror())
+	require.Equal(t, MirrorStats{}, c.StopMirror())
+	_, err = tunnel.Write([]byte("after"))
+	require.NoError(t, err)
+	require.Empty(t, out)
+}
+
+func TestClient_Mirror_drops(t *testing.T) {
+	c := &Client{}
+	tunnel := &mirrorTunnel{ReadWriteCloser: newTestTUN(), mirror: &c.mirror}
+
+	// Packets over the rate limit burst are dropped.
+	out := make(chanWriter, 1024)
+	require.NoError(t, c.StartMirror(out, &MirrorOptions{BytesPerSecond: 1}))
+	big := make([]byte, maxPacketSize)
+	for range 3 {
+		_, err := tunnel.Write(big)
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return len(out) == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, MirrorStats{Mirrored: 1, Dropped: 2}, c.StopMirror())
+
+	// Slow writer does not block the tunnel, packets over the queue are dropped.
+	var failed atomic.Bool
+	block := make(chan struct{})
+	require.NoError(t, c.StartMirror(writerFunc(func(p []byte) (int, error) {
+		<-block
+		if failed.CompareAndSwap(false, true) {
+			return 0, errors.New("mirror gone")
+		}
+		return len(p), nil
+	}), &MirrorOptions{Queue: 2}))
+	for range 6 {
+		_, err := tunnel.Write([]byte("pkt"))
+		require.NoError(t, err)
+	}
+	close(block)
+	stats := c.StopMirror()
+	require.EqualValues(t, 6, stats.Mirrored+stats.Dropped)
+	require.GreaterOrEqual(t, stats.Dropped, uint64(3))
+
+	require.Error(t, c.StartMirror(out, &MirrorOptions{Queue: -1}))
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -80,32 +80,32 @@ func (c *MockpipeCopyCall) DoAndReturn(f func(context.Context, io.ReadWriteClose
 	return c
 }
 
-// MockipTable is a mock of ipTable interface.
-type MockipTable struct {
+// MockRouteTable is a mock of RouteTable interface.
+type MockRouteTable struct {
 	ctrl     *gomock.Controller
-	recorder *MockipTableMockRecorder
+	recorder *MockRouteTableMockRecorder
 	isgomock struct{}
 }
 
-// MockipTableMockRecorder is the mock recorder for MockipTable.
-type MockipTableMockRecorder struct {
-	mock *MockipTable
+// MockRouteTableMockRecorder is the mock recorder for MockRouteTable.
+type MockRouteTableMockRecorder struct {
+	mock *MockRouteTable
 }
 
-// NewMockipTable creates a new mock instance.
-func NewMockipTable(ctrl *gomock.Controller) *MockipTable {
-	mock := &MockipTable{ctrl: ctrl}
-	mock.recorder = &MockipTableMockRecorder{mock}
+// NewMockRouteTable creates a new mock instance.
+func NewMockRouteTable(ctrl *gomock.Controller) *MockRouteTable {
+	mock := &MockRouteTable{ctrl: ctrl}
+	mock.recorder = &MockRouteTableMockRecorder{mock}
 	return mock
 }
 
 // EXPECT returns an object that allows the caller to indicate expected use.
-func (m *MockipTable) EXPECT() *MockipTableMockRecorder {
+func (m *MockRouteTable) EXPECT() *MockRouteTableMockRecorder {
 	return m.recorder
 }
 
 // Add mocks base method.
-func (m *MockipTable) Add(options route.Opts) error {
+func (m *MockRouteTable) Add(options route.Opts) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Add", options)
 	ret0, _ := ret[0].(error)
@@ -113,37 +113,37 @@ func (m *MockipTable) Add(options route.Opts) error {
 }
 
 // Add indicates an expected call of Add.
-func (mr *MockipTableMockRecorder) Add(options any) *MockipTableAddCall {
+func (mr *MockRouteTableMockRecorder) Add(options any) *MockRouteTableAddCall {
 	mr.mock.ctrl.T.Helper()
-	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockipTable)(nil).Add), options)
-	return &MockipTableAddCall{Call: call}
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockRouteTable)(nil).Add), options)
+	return &MockRouteTableAddCall{Call: call}
 }
 
-// MockipTableAddCall wrap *gomock.Call
-type MockipTableAddCall struct {
+// MockRouteTableAddCall wrap *gomock.Call
+type MockRouteTableAddCall struct {
 	*gomock.Call
 }
 
 // Return rewrite *gomock.Call.Return
-func (c *MockipTableAddCall) Return(arg0 error) *MockipTableAddCall {
+func (c *MockRouteTableAddCall) Return(arg0 error) *MockRouteTableAddCall {
 	c.Call = c.Call.Return(arg0)
 	return c
 }
 
 // Do rewrite *gomock.Call.Do
-func (c *MockipTableAddCall) Do(f func(route.Opts) error) *MockipTableAddCall {
+func (c *MockRouteTableAddCall) Do(f func(route.Opts) error) *MockRouteTableAddCall {
 	c.Call = c.Call.Do(f)
 	return c
 }
 
 // DoAndReturn rewrite *gomock.Call.DoAndReturn
-func (c *MockipTableAddCall) DoAndReturn(f func(route.Opts) error) *MockipTableAddCall {
+func (c *MockRouteTableAddCall) DoAndReturn(f func(route.Opts) error) *MockRouteTableAddCall {
 	c.Call = c.Call.DoAndReturn(f)
 	return c
 }
 
 // Delete mocks base method.
-func (m *MockipTable) Delete(options route.Opts) error {
+func (m *MockRouteTable) Delete(options route.Opts) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Delete", options)
 	ret0, _ := ret[0].(error)
@@ -151,31 +151,31 @@ func (m *MockipTable) Delete(options route.Opts) error {
 }
 
 // Delete indicates an expected call of Delete.
-func (mr *MockipTableMockRecorder) Delete(options any) *MockipTableDeleteCall {
+func (mr *MockRouteTableMockRecorder) Delete(options any) *MockRouteTableDeleteCall {
 	mr.mock.ctrl.T.Helper()
-	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockipTable)(nil).Delete), options)
-	return &MockipTableDeleteCall{Call: call}
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockRouteTable)(nil).Delete), options)
+	return &MockRouteTableDeleteCall{Call: call}
 }
 
-// MockipTableDeleteCall wrap *gomock.Call
-type MockipTableDeleteCall struct {
+// MockRouteTableDeleteCall wrap *gomock.Call
+type MockRouteTableDeleteCall struct {
 	*gomock.Call
 }
 
 // Return rewrite *gomock.Call.Return
-func (c *MockipTableDeleteCall) Return(arg0 error) *MockipTableDeleteCall {
+func (c *MockRouteTableDeleteCall) Return(arg0 error) *MockRouteTableDeleteCall {
 	c.Call = c.Call.Return(arg0)
 	return c
 }
 
 // Do rewrite *gomock.Call.Do
-func (c *MockipTableDeleteCall) Do(f func(route.Opts) error) *MockipTableDeleteCall {
+func (c *MockRouteTableDeleteCall) Do(f func(route.Opts) error) *MockRouteTableDeleteCall {
 	c.Call = c.Call.Do(f)
 	return c
 }
 
 // DoAndReturn rewrite *gomock.Call.DoAndReturn
-func (c *MockipTableDeleteCall) DoAndReturn(f func(route.Opts) error) *MockipTableDeleteCall {
+func (c *MockRouteTableDeleteCall) DoAndReturn(f func(route.Opts) error) *MockRouteTableDeleteCall {
 	c.Call = c.Call.DoAndReturn(f)
 	return c
 }
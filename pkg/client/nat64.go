@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/xtls/xray-core/infra/conf"
+
+	"github.com/goxray/tun/pkg/nat64"
+)
+
+// synthesizeServer returns IPv6 address of IPv4 server ip reachable via NAT64 on IPv6-only network,
+// i.e. if there is no IPv4 gateway. The prefix is Config.NAT64Prefix or discovered via DNS64 (RFC 7050).
+func (c *Client) synthesizeServer(ctx context.Context, ip net.IP) (net.IP, error) {
+	prefix := c.cfg.NAT64Prefix
+	if prefix == nil {
+		var err error
+		if prefix, err = nat64.DiscoverPrefix(ctx, nil); err != nil {
+			return nil, fmt.Errorf("no IPv4 gateway, nat64 prefix discovery: %w", err)
+		}
+	}
+
+	v6, err := nat64.Synthesize(prefix, ip)
+	if err != nil {
+		return nil, fmt.Errorf("nat64: %w", err)
+	}
+	c.log(LogDNS).Info("IPv6-only network, connecting to server via NAT64", "prefix", prefix, "ip", ip, "nat64_ip", v6)
+
+	return v6, nil
+}
+
+// useServerIP replaces server host in the XRay outbound settings with ip (e.g. synthesized NAT64 address),
+// so XRay core does not resolve or dial IPv4 address. Host name is kept as TLS server name.
+func useServerIP(cfg *conf.Config, host string, ip net.IP) error {
+	if len(cfg.OutboundConfigs) == 0 || cfg.OutboundConfigs[0].Settings == nil {
+		return nil
+	}
+
+	out := &cfg.OutboundConfigs[0]
+	var v any
+	if err := json.Unmarshal(*out.Settings, &v); err != nil {
+		return fmt.Errorf("outbound settings: %w", err)
+	}
+	b, err := json.Marshal(replaceHost(v, host, ip.String()))
+	if err != nil {
+		return fmt.Errorf("outbound settings: %w", err)
+	}
+	raw := json.RawMessage(b)
+	out.Settings = &raw
+
+	if net.ParseIP(host) == nil && out.StreamSetting != nil && out.StreamSetting.TLSSettings != nil &&
+		out.StreamSetting.TLSSettings.ServerName == "" {
+		out.StreamSetting.TLSSettings.ServerName = host
+	}
+
+	return nil
+}
+
+// replaceHost replaces json string values equal to host or "host:port" with ip.
+func replaceHost(v any, host, ip string) any {
+	switch v := v.(type) {
+	case string:
+		if v == host {
+			return ip
+		}
+		if h, port, err := net.SplitHostPort(v); err == nil && h == host {
+			return net.JoinHostPort(ip, port)
+		}
+	case map[string]any:
+		for k, val := range v {
+			v[k] = replaceHost(val, host, ip)
+		}
+	case []any:
+		for i := range v {
+			v[i] = replaceHost(v[i], host, ip)
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+
+	"github.com/goxray/tun/pkg/nat64"
+)
+
+func TestClient_createProxy_NAT64(t *testing.T) {
+	prev := defaultRouteExists
+	t.Cleanup(func() { defaultRouteExists = prev })
+	defaultRouteExists = func() bool { return true }
+
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.GatewayIP = nil
+	cl.cfg.NAT64Prefix = nat64.WellKnownPrefix
+
+	_, _, err := cl.createProxy("vless://b831381d-6324-4d53-ad4f-8cda48b30811@192.0.2.33:443?type=tcp&security=tls")
+	require.NoError(t, err)
+	require.Equal(t, "64:ff9b::c000:221", cl.xSrvIP.String())
+	require.False(t, cl.serverRouteNeeded(cl.xSrvIP.IP), "IPv6 is not routed to TUN")
+	require.Contains(t, string(*cl.xJSON.OutboundConfigs[0].Settings), `"address":"64:ff9b::c000:221"`)
+
+	// IPv4 gateway, no NAT64.
+	cl = newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.NAT64Prefix = nat64.WellKnownPrefix
+	ip, synthesized, err := cl.resolveServer("192.0.2.33", "443")
+	require.NoError(t, err)
+	require.False(t, synthesized)
+	require.Equal(t, "192.0.2.33", ip.String())
+}
+
+func TestClient_resolveServer_noDefaultRoute(t *testing.T) {
+	prev := defaultRouteExists
+	t.Cleanup(func() { defaultRouteExists = prev })
+	defaultRouteExists = func() bool { return false }
+
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.GatewayIP = nil
+	ip, synthesized, err := cl.resolveServer("172.18.0.9", "443")
+	require.NoError(t, err)
+	require.False(t, synthesized)
+	require.Equal(t, "172.18.0.9", ip.String())
+	require.False(t, cl.serverRouteNeeded(ip), "no gateway to route via")
+}
+
+func TestUseServerIP(t *testing.T) {
+	settings := json.RawMessage(`{"vnext":[{"address":"example.com","port":443}],"peers":[{"endpoint":"example.com:51820"}]}`)
+	cfg := &conf.Config{OutboundConfigs: []conf.OutboundDetourConfig{{
+		Settings:      &settings,
+		StreamSetting: &conf.StreamConfig{TLSSettings: &conf.TLSConfig{}},
+	}}}
+
+	require.NoError(t, useServerIP(cfg, "example.com", net.ParseIP("64:ff9b::c000:221")))
+	require.JSONEq(t, `{"vnext":[{"address":"64:ff9b::c000:221","port":443}],"peers":[{"endpoint":"[64:ff9b::c000:221]:51820"}]}`,
+		string(*cfg.OutboundConfigs[0].Settings))
+	require.Equal(t, "example.com", cfg.OutboundConfigs[0].StreamSetting.TLSSettings.ServerName)
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/goxray/tun/pkg/netmanager"
+)
+
+// registerNetwork registers TUN device with NetworkManager, replaced in tests.
+var registerNetwork = netmanager.Register
+
+// NetworkManagerOptions register the TUN device with NetworkManager and systemd-resolved on Linux,
+// see Config.NetworkManager.
+type NetworkManagerOptions struct {
+	// DNS are DNS servers set for the TUN device with systemd-resolved, queries of all domains go to them
+	// through the tunnel (default: DNS settings of the system are kept).
+	DNS []net.IP
+}
+
+// registerDevice registers TUN device ifname as configured by Config.NetworkManager. NetworkManager not running
+// is not an error, there is nothing to fight over the device then.
+func (c *Client) registerDevice(ifname string) error {
+	err := registerNetwork(ifname, c.cfg.NetworkManager.DNS)
+	if !errors.Is(err, netmanager.ErrNotRunning) {
+		target := ifname
+		for _, ip := range c.cfg.NetworkManager.DNS {
+			target += " dns " + ip.String()
+		}
+		c.audit.record(AuditDeviceRegister, target, err)
+	}
+	if errors.Is(err, netmanager.ErrNotRunning) {
+		c.log(LogTUN).Debug("NetworkManager is not running, device not registered", "device", ifname)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("network manager: %w", err)
+	}
+	c.log(LogTUN).Debug("device registered with NetworkManager", "device", ifname, "dns", c.cfg.NetworkManager.DNS)
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/netmanager"
+)
+
+func TestClient_registerDevice(t *testing.T) {
+	var gotName string
+	var gotDNS []net.IP
+	regErr := error(nil)
+	prev := registerNetwork
+	t.Cleanup(func() { registerNetwork = prev })
+	registerNetwork = func(ifname string, dns []net.IP) error {
+		gotName, gotDNS = ifname, dns
+		return regErr
+	}
+
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.NetworkManager = &NetworkManagerOptions{DNS: []net.IP{net.IPv4(1, 1, 1, 1)}}
+	require.NoError(t, cl.registerDevice("tun0"))
+	require.Equal(t, "tun0", gotName)
+	require.Equal(t, cl.cfg.NetworkManager.DNS, gotDNS)
+
+	regErr = netmanager.ErrNotRunning
+	require.NoError(t, cl.registerDevice("tun0"), "missing NetworkManager must not fail connect")
+
+	regErr = errors.New("resolvectl: not found")
+	require.ErrorContains(t, cl.registerDevice("tun0"), "network manager: resolvectl")
+}
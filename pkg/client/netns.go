@@ -0,0 +1,8 @@
+package client
+
+// DefaultNetns is the network namespace name of Config.Netns used by the command line.
+const DefaultNetns = "goxray"
+
+// netnsResolvConf is resolv.conf of programs in Config.Netns written if there is none, like for "ip netns exec".
+// Loopback resolvers of the host (e.g. systemd-resolved) are not reachable there, queries go through the tunnel.
+const netnsResolvConf = "nameserver 1.1.1.1\nnameserver 8.8.8.8\n"
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// netnsEtc is the directory of per network namespace files bind mounted over /etc, like for "ip netns exec".
+const netnsEtc = "/etc/netns"
+
+// inNetns runs fn with the calling goroutine in network namespace name, the namespace is created
+// (like "ip netns add") if it does not exist.
+func inNetns(name string, fn func() error) error {
+	runtime.LockOSThread()
+	host, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("host network namespace: %w", err)
+	}
+	defer host.Close()
+	defer func() {
+		// The thread is left locked if it is not switched back, so it exits with the goroutine.
+		if netns.Set(host) == nil {
+			runtime.UnlockOSThread()
+		}
+	}()
+
+	ns, err := netns.GetFromName(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		ns, err = netns.NewNamed(name) // Switches to the namespace.
+	case err == nil:
+		err = netns.Set(ns)
+	}
+	if err != nil {
+		return fmt.Errorf("network namespace %s: %w", name, err)
+	}
+	defer ns.Close()
+
+	return fn()
+}
+
+// netnsTunnel creates TUN device with its routes in Config.Netns, the namespace is kept after Disconnect:
+// programs in it stay cut off from the network until the next Connect.
+func (c *Client) netnsTunnel() (io.ReadWriteCloser, error) {
+	var ifc io.ReadWriteCloser
+	err := inNetns(c.cfg.Netns, func() error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		if err = netlink.LinkSetUp(lo); err != nil {
+			return fmt.Errorf("loopback up: %w", err)
+		}
+		ifc, err = c.createTunnel()
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resolvConf := filepath.Join(netnsEtc, c.cfg.Netns, "resolv.conf")
+	if _, err = os.Stat(resolvConf); errors.Is(err, os.ErrNotExist) {
+		err = errors.Join(os.MkdirAll(filepath.Dir(resolvConf), 0o755), os.WriteFile(resolvConf, []byte(netnsResolvConf), 0o644))
+		c.audit.record(AuditDNSSet, resolvConf, err)
+	}
+	if err != nil {
+		c.log(LogDNS).Warn("netns resolv.conf not written, DNS may not work in the namespace", "path", resolvConf, "err", err)
+	}
+
+	return ifc, nil
+}
+
+// ExecNetns replaces the process with program argv run in network namespace name (see Config.Netns),
+// like "ip netns exec": /etc/netns/<name>/resolv.conf is bind mounted over /etc/resolv.conf for the program.
+// It returns only on failure.
+func ExecNetns(name string, argv []string) error {
+	if len(argv) == 0 {
+		return errors.New("no program")
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+
+	runtime.LockOSThread() // Not unlocked, the thread is replaced by the program.
+	ns, err := netns.GetFromName(name)
+	if err != nil {
+		return fmt.Errorf("network namespace %s: %w", name, err)
+	}
+	if err = netns.Set(ns); err != nil {
+		return fmt.Errorf("network namespace %s: %w", name, err)
+	}
+
+	// Mounts are private to the program, the host does not see them.
+	if err = unix.Unshare(unix.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("mount namespace: %w", err)
+	}
+	if err = unix.Mount("", "/", "", unix.MS_SLAVE|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("mount namespace: %w", err)
+	}
+	resolvConf := filepath.Join(netnsEtc, name, "resolv.conf")
+	if _, err = os.Stat(resolvConf); err == nil {
+		if err = unix.Mount(resolvConf, "/etc/resolv.conf", "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("bind %s: %w", resolvConf, err)
+		}
+	}
+
+	return syscall.Exec(path, argv, os.Environ())
+}
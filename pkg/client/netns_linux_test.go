@@ -0,0 +1,46 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func TestInNetns(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("network namespaces require root")
+	}
+	name := fmt.Sprintf("goxray-test-%d", os.Getpid())
+	t.Cleanup(func() { _ = netns.DeleteNamed(name) })
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	host, err := netns.Get()
+	require.NoError(t, err)
+	defer host.Close()
+
+	var inside netns.NsHandle
+	for range 2 { // Created, then reused.
+		require.NoError(t, inNetns(name, func() error {
+			inside, err = netns.Get()
+			if err != nil {
+				return err
+			}
+			_, err = netlink.LinkByName("lo")
+			return err
+		}))
+		require.False(t, inside.Equal(host))
+		require.NoError(t, inside.Close())
+	}
+	current, err := netns.Get()
+	require.NoError(t, err)
+	defer current.Close()
+	require.True(t, current.Equal(host), "switched back")
+
+	require.ErrorContains(t, ExecNetns(name+"-missing", []string{"true"}), "network namespace")
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package client
+
+import (
+	"errors"
+	"io"
+)
+
+var errNetnsUnsupported = errors.New("network namespace is supported on Linux only")
+
+func (c *Client) netnsTunnel() (io.ReadWriteCloser, error) {
+	return nil, errNetnsUnsupported
+}
+
+// ExecNetns runs program in network namespace, it is supported on Linux only.
+func ExecNetns(string, []string) error {
+	return errNetnsUnsupported
+}
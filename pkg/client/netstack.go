@@ -0,0 +1,382 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/eycorsican/go-tun2socks/core"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// netStackNIC is the NIC of netStack packets.
+	netStackNIC tcpip.NICID = 1
+	// netStackQueue is the number of packets queued for output by netStack.
+	netStackQueue = 512
+	// netStackMaxInFlight limits TCP connections being established by netStack, further SYNs are dropped.
+	netStackMaxInFlight = 1024
+	// udpPendingSize is the number of datagrams of a UDP flow queued until its handler connects.
+	udpPendingSize = 64
+)
+
+// netStack is the userspace TCP/IP stack of a pipe (gVisor netstack): TCP connections and UDP flows of IP packets
+// written to it are passed to the handlers, packets it sends are passed to output. Unlike the lwip stack of
+// tun2socks, which is one per process, each pipe has its own stack, so several Clients run concurrently.
+//
+// Handlers see the same connections as with tun2socks: core.TCPConnHandler gets TCP connections with the
+// application address as LocalAddr, core.UDPConnHandler gets UDP flows keyed by the application address.
+type netStack struct {
+	tcp    core.TCPConnHandler
+	udp    core.UDPConnHandler
+	output func([]byte) (int, error)
+
+	stack  *stack.Stack
+	ep     *channel.Endpoint
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	udpConn map[string]*stackUDPConn // UDP flows by application address.
+	closed  bool
+}
+
+func newNetStack(mtu int, tcpHandler core.TCPConnHandler, udpHandler core.UDPConnHandler,
+	output func([]byte) (int, error),
+) (*netStack, error) {
+	s := &netStack{
+		tcp: tcpHandler, udp: udpHandler, output: output,
+		stack: stack.New(stack.Options{
+			NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+			TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+		}),
+		ep:      channel.New(netStackQueue, uint32(mtu), ""),
+		udpConn: make(map[string]*stackUDPConn),
+	}
+	sack := tcpip.TCPSACKEnabled(true)
+	if err := s.stack.SetTransportProtocolOption(tcp.ProtocolNumber, &sack); err != nil {
+		s.stack.Destroy()
+		return nil, fmt.Errorf("tcp sack: %s", err)
+	}
+	if err := s.stack.CreateNIC(netStackNIC, s.ep); err != nil {
+		s.stack.Destroy()
+		return nil, fmt.Errorf("create nic: %s", err)
+	}
+	// Packets of all addresses are accepted and replied from their destination.
+	if err := s.stack.SetPromiscuousMode(netStackNIC, true); err != nil {
+		s.stack.Destroy()
+		return nil, fmt.Errorf("promiscuous mode: %s", err)
+	}
+	if err := s.stack.SetSpoofing(netStackNIC, true); err != nil {
+		s.stack.Destroy()
+		return nil, fmt.Errorf("spoofing: %s", err)
+	}
+	s.stack.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: netStackNIC},
+		{Destination: header.IPv6EmptySubnet, NIC: netStackNIC},
+	})
+	fwd := tcp.NewForwarder(s.stack, 0, netStackMaxInFlight, s.handleTCP)
+	s.stack.SetTransportProtocolHandler(tcp.ProtocolNumber, fwd.HandlePacket)
+	s.stack.SetTransportProtocolHandler(udp.ProtocolNumber, s.handleUDP)
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.writeOutput(ctx)
+	}()
+
+	return s, nil
+}
+
+// Write passes IP packet pkt to the stack, packets other than IPv4 and IPv6 are dropped.
+func (s *netStack) Write(pkt []byte) (int, error) {
+	var proto tcpip.NetworkProtocolNumber
+	switch {
+	case len(pkt) > 0 && pkt[0]>>4 == 4:
+		proto = ipv4.ProtocolNumber
+	case len(pkt) > 0 && pkt[0]>>4 == 6:
+		proto = ipv6.ProtocolNumber
+	default:
+		return len(pkt), nil
+	}
+	p := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(pkt)})
+	s.ep.InjectInbound(proto, p)
+	p.DecRef()
+
+	return len(pkt), nil
+}
+
+// Close stops the stack, its TCP connections are reset and UDP flows can not be written anymore.
+func (s *netStack) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.cancel()
+	s.stack.Close()
+	s.stack.Wait()
+	s.ep.Close()
+	s.wg.Wait()
+
+	return nil
+}
+
+// writeOutput passes packets sent by the stack to output until ctx is done.
+func (s *netStack) writeOutput(ctx context.Context) {
+	for {
+		pkt := s.ep.ReadContext(ctx)
+		if pkt == nil {
+			return
+		}
+		v := pkt.ToView()
+		_, _ = s.output(v.AsSlice())
+		v.Release()
+		pkt.DecRef()
+	}
+}
+
+// handleTCP establishes connection of request r and passes it to the TCP handler, the connection is reset
+// if the handler fails.
+func (s *netStack) handleTCP(r *tcp.ForwarderRequest) {
+	id := r.ID()
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		r.Complete(true)
+		return
+	}
+	r.Complete(false)
+
+	conn := &stackTCPConn{
+		TCPConn: gonet.NewTCPConn(&wq, ep),
+		ep:      ep,
+		local:   &net.TCPAddr{IP: net.IP(id.RemoteAddress.AsSlice()), Port: int(id.RemotePort)},
+		remote:  &net.TCPAddr{IP: net.IP(id.LocalAddress.AsSlice()), Port: int(id.LocalPort)},
+	}
+	if err := s.tcp.Handle(conn, conn.remote); err != nil {
+		conn.Abort()
+	}
+}
+
+// handleUDP passes UDP datagram pkt to the flow of its source, the flow is created on the first datagram.
+func (s *netStack) handleUDP(id stack.TransportEndpointID, pkt *stack.PacketBuffer) bool {
+	src := &net.UDPAddr{IP: net.IP(id.RemoteAddress.AsSlice()), Port: int(id.RemotePort)}
+	dst := &net.UDPAddr{IP: net.IP(id.LocalAddress.AsSlice()), Port: int(id.LocalPort)}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return true
+	}
+	conn, ok := s.udpConn[src.String()]
+	if !ok {
+		conn = &stackUDPConn{stack: s, local: src}
+		s.udpConn[src.String()] = conn
+		go conn.connect(dst)
+	}
+	s.mu.Unlock()
+
+	_ = conn.ReceiveTo(pkt.Data().AsRange().ToSlice(), dst)
+
+	return true
+}
+
+// stackTCPConn is TCP connection of netStack, LocalAddr is the application address like in core.TCPConn.
+type stackTCPConn struct {
+	*gonet.TCPConn
+	ep            tcpip.Endpoint
+	local, remote *net.TCPAddr
+}
+
+// LocalAddr returns the application address.
+func (c *stackTCPConn) LocalAddr() net.Addr { return c.local }
+
+// RemoteAddr returns the destination address.
+func (c *stackTCPConn) RemoteAddr() net.Addr { return c.remote }
+
+// Abort resets the connection.
+func (c *stackTCPConn) Abort() { c.ep.Abort() }
+
+// udpConnState is the state of stackUDPConn.
+type udpConnState int
+
+const (
+	udpConnecting udpConnState = iota
+	udpConnected
+	udpClosed
+)
+
+// udpDatagram is a datagram of the application queued until its flow connects.
+type udpDatagram struct {
+	data []byte
+	addr *net.UDPAddr
+}
+
+// stackUDPConn is core.UDPConn of UDP flow from application address local, datagrams received before
+// the handler connects are queued.
+type stackUDPConn struct {
+	stack *netStack
+	local *net.UDPAddr
+
+	mu      sync.Mutex
+	state   udpConnState
+	pending []udpDatagram
+}
+
+// connect connects the flow with the UDP handler, the flow is closed if it fails.
+func (c *stackUDPConn) connect(target *net.UDPAddr) {
+	if err := c.stack.udp.Connect(c, target); err != nil {
+		_ = c.Close()
+		return
+	}
+
+	c.mu.Lock()
+	if c.state == udpClosed {
+		c.mu.Unlock()
+		return
+	}
+	c.state = udpConnected
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	for _, d := range pending {
+		if err := c.stack.udp.ReceiveTo(c, d.data, d.addr); err != nil {
+			return
+		}
+	}
+}
+
+// LocalAddr implements core.UDPConn.
+func (c *stackUDPConn) LocalAddr() *net.UDPAddr { return c.local }
+
+// ReceiveTo implements core.UDPConn.
+func (c *stackUDPConn) ReceiveTo(data []byte, addr *net.UDPAddr) error {
+	c.mu.Lock()
+	switch c.state {
+	case udpConnecting:
+		if len(c.pending) < udpPendingSize {
+			c.pending = append(c.pending, udpDatagram{data: append([]byte(nil), data...), addr: addr})
+		}
+		c.mu.Unlock()
+		return nil
+	case udpClosed:
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.mu.Unlock()
+
+	if err := c.stack.udp.ReceiveTo(c, data, addr); err != nil {
+		return fmt.Errorf("write proxy: %w", err)
+	}
+
+	return nil
+}
+
+// WriteFrom implements core.UDPConn.
+func (c *stackUDPConn) WriteFrom(data []byte, addr *net.UDPAddr) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	c.mu.Lock()
+	state := c.state
+	c.mu.Unlock()
+	switch state {
+	case udpConnecting:
+		return 0, errors.New("not connected")
+	case udpClosed:
+		return 0, net.ErrClosed
+	}
+
+	c.stack.mu.Lock()
+	closed := c.stack.closed
+	c.stack.mu.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	pkt, err := udpPacket(addr, c.local, data)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = c.stack.output(pkt); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// Close implements core.UDPConn.
+func (c *stackUDPConn) Close() error {
+	c.mu.Lock()
+	c.state, c.pending = udpClosed, nil
+	c.mu.Unlock()
+
+	c.stack.mu.Lock()
+	if c.stack.udpConn[c.local.String()] == c {
+		delete(c.stack.udpConn, c.local.String())
+	}
+	c.stack.mu.Unlock()
+
+	return nil
+}
+
+// udpPacket returns IPv4 or IPv6 packet of UDP datagram data from src to dst with checksums.
+func udpPacket(src, dst *net.UDPAddr, data []byte) ([]byte, error) {
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		pkt := make([]byte, 20+8+len(data))
+		pkt[0], pkt[8], pkt[9] = 0x45, 64, protoUDP
+		binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+		copy(pkt[12:], src4)
+		copy(pkt[16:], dst4)
+		binary.BigEndian.PutUint16(pkt[10:], inetChecksum(pkt[:20], 0))
+		putUDPDatagram(pkt[20:], pkt[12:20], src, dst, data)
+
+		return pkt, nil
+	}
+	if src.IP.To4() != nil || dst.IP.To4() != nil || src.IP.To16() == nil || dst.IP.To16() == nil {
+		return nil, fmt.Errorf("udp from %s to %s: address families differ", src, dst)
+	}
+
+	pkt := make([]byte, 40+8+len(data))
+	pkt[0], pkt[6], pkt[7] = 0x60, protoUDP, 64
+	binary.BigEndian.PutUint16(pkt[4:], uint16(8+len(data)))
+	copy(pkt[8:], src.IP.To16())
+	copy(pkt[24:], dst.IP.To16())
+	putUDPDatagram(pkt[40:], pkt[8:40], src, dst, data)
+
+	return pkt, nil
+}
+
+// putUDPDatagram writes UDP datagram data from src to dst into b with checksum, addrs are the source and
+// destination addresses of the IP header.
+func putUDPDatagram(b, addrs []byte, src, dst *net.UDPAddr, data []byte) {
+	putUDPHeader(b, src, dst)
+	binary.BigEndian.PutUint16(b[4:], uint16(8+len(data)))
+	copy(b[8:], data)
+
+	// Pseudo-header: addresses, protocol and UDP length.
+	pseudo := uint32(protoUDP) + uint32(len(b))
+	for i := 0; i < len(addrs); i += 2 {
+		pseudo += uint32(binary.BigEndian.Uint16(addrs[i:]))
+	}
+	sum := inetChecksum(b, pseudo)
+	if sum == 0 {
+		sum = 0xffff // Zero is no checksum.
+	}
+	binary.BigEndian.PutUint16(b[6:], sum)
+}
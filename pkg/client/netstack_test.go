@@ -0,0 +1,71 @@
+package client
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eycorsican/go-tun2socks/core"
+	"github.com/stretchr/testify/require"
+)
+
+// echoUDPHandler echoes datagrams of UDP flows, targets of the flows are sent to connected.
+type echoUDPHandler struct {
+	connected chan *net.UDPAddr
+}
+
+func (h echoUDPHandler) Connect(_ core.UDPConn, target *net.UDPAddr) error {
+	h.connected <- target
+	return nil
+}
+
+func (echoUDPHandler) ReceiveTo(conn core.UDPConn, data []byte, addr *net.UDPAddr) error {
+	_, err := conn.WriteFrom(data, addr)
+	return err
+}
+
+func TestNetStack_UDP(t *testing.T) {
+	out := make(chan []byte, 8)
+	h := echoUDPHandler{connected: make(chan *net.UDPAddr, 1)}
+	s, err := newNetStack(tunMTU, nil, h, func(pkt []byte) (int, error) {
+		out <- append([]byte(nil), pkt...)
+		return len(pkt), nil
+	})
+	require.NoError(t, err)
+
+	for _, tt := range []struct{ app, dst *net.UDPAddr }{
+		{&net.UDPAddr{IP: net.IPv4(192, 18, 0, 1), Port: 5353}, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 53}},
+		{&net.UDPAddr{IP: net.ParseIP("fd00::1"), Port: 5353}, &net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 53}},
+	} {
+		pkt, err := udpPacket(tt.app, tt.dst, []byte("ping"))
+		require.NoError(t, err)
+		_, err = s.Write(pkt)
+		require.NoError(t, err)
+		require.Equal(t, tt.dst.String(), (<-h.connected).String())
+
+		select {
+		case reply := <-out:
+			want, err := udpPacket(tt.dst, tt.app, []byte("ping"))
+			require.NoError(t, err)
+			require.Equal(t, want, reply, "echoed from the destination")
+
+			// Checksums of valid packets add up to zero.
+			hdr, addrs := 20, reply[12:20]
+			if tt.app.IP.To4() == nil {
+				hdr, addrs = 40, reply[8:40]
+			} else {
+				require.Zero(t, inetChecksum(reply[:hdr], 0))
+			}
+			pseudo := uint32(protoUDP) + uint32(len(reply)-hdr)
+			for i := 0; i < len(addrs); i += 2 {
+				pseudo += uint32(binary.BigEndian.Uint16(addrs[i:]))
+			}
+			require.Zero(t, inetChecksum(reply[hdr:], pseudo))
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply")
+		}
+	}
+
+	require.NoError(t, s.Close())
+}
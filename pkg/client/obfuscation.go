@@ -0,0 +1,132 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// obfsFragmentTag is the XRay freedom outbound splitting the connection to the server, see ObfuscationOptions.Fragment.
+const obfsFragmentTag = "obfs-fragment"
+
+// ObfuscationOptions change packet sizes and timing of the connection to the server against blocking by traffic
+// analysis, using XRay core options. Obfuscation costs throughput and latency: padding adds bytes to every request,
+// fragments are sent in more packets and delayed by Jitter. It is supported for protocols served by XRay core only.
+type ObfuscationOptions struct {
+	// Padding is the range of random padding bytes of each request, e.g. "100-1000" (default: none).
+	// It is supported for xhttp transport only (XRay "xPaddingBytes").
+	Padding string
+	// Fragment splits the packets of the connection to the server into fragments of the range of bytes,
+	// e.g. "10-100" (default: none). It is supported for TCP based transports.
+	Fragment string
+	// FragmentPackets selects the packets to split: "tlshello" or the range of packet numbers, e.g. "1-3"
+	// (default: "tlshello").
+	FragmentPackets string
+	// Jitter is the range of delay between fragments in milliseconds, e.g. "10-50" (default: no delay).
+	Jitter string
+}
+
+// Validate checks options values.
+func (o *ObfuscationOptions) Validate() error {
+	if o.Padding == "" && o.Fragment == "" {
+		return errors.New("padding or fragment is required")
+	}
+	if o.Padding != "" {
+		if _, err := parseXrayRange(o.Padding, 1); err != nil {
+			return fmt.Errorf("invalid padding: %w", err)
+		}
+	}
+	if o.Fragment == "" {
+		if o.FragmentPackets != "" || o.Jitter != "" {
+			return errors.New("fragment packets and jitter require fragment")
+		}
+		return nil
+	}
+	if _, err := parseXrayRange(o.Fragment, 1); err != nil {
+		return fmt.Errorf("invalid fragment: %w", err)
+	}
+	if o.FragmentPackets != "" && o.FragmentPackets != "tlshello" {
+		if _, err := parseXrayRange(o.FragmentPackets, 1); err != nil {
+			return fmt.Errorf("invalid fragment packets: %w", err)
+		}
+	}
+	if o.Jitter != "" {
+		if _, err := parseXrayRange(o.Jitter, 0); err != nil {
+			return fmt.Errorf("invalid jitter: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// parseXrayRange parses XRay range "N" or "N-M" of numbers not less than min.
+func parseXrayRange(s string, min int32) (*conf.Int32Range, error) {
+	r := new(conf.Int32Range)
+	if err := json.Unmarshal([]byte(strconv.Quote(s)), r); err != nil {
+		return nil, fmt.Errorf("range %q: %w", s, err)
+	}
+	if r.From < min || r.To < r.From {
+		return nil, fmt.Errorf("range %q: numbers from %d expected", s, min)
+	}
+
+	return r, nil
+}
+
+// addObfuscation applies obfuscation to the outbound to the server of cfg: padding of xhttp transport and
+// fragmenting of the connection by freedom outbound the server is dialed through.
+func addObfuscation(cfg *conf.Config, out *conf.OutboundDetourConfig, o *ObfuscationOptions) error {
+	if o == nil {
+		return nil
+	}
+
+	if out.StreamSetting == nil {
+		out.StreamSetting = &conf.StreamConfig{}
+	}
+	s := out.StreamSetting
+	network := "tcp"
+	if s.Network != nil && *s.Network != "" {
+		network = string(*s.Network)
+	}
+	if o.Padding != "" {
+		if network != "xhttp" && network != "splithttp" {
+			return fmt.Errorf("padding is not supported for %s transport, only for xhttp", network)
+		}
+		if s.XHTTPSettings == nil {
+			s.XHTTPSettings = &conf.SplitHTTPConfig{}
+		}
+		padding, _ := parseXrayRange(o.Padding, 1)
+		s.XHTTPSettings.XPaddingBytes = *padding
+	}
+	if o.Fragment == "" {
+		return nil
+	}
+
+	if network == "kcp" || network == "mkcp" || network == "quic" {
+		return fmt.Errorf("fragment is not supported for %s transport, only for TCP based ones", network)
+	}
+	fragment := &conf.Fragment{Packets: o.FragmentPackets, Interval: &conf.Int32Range{}}
+	if fragment.Packets == "" {
+		fragment.Packets = "tlshello"
+	}
+	fragment.Length, _ = parseXrayRange(o.Fragment, 1)
+	if o.Jitter != "" {
+		fragment.Interval, _ = parseXrayRange(o.Jitter, 0)
+	}
+	settings, err := json.Marshal(conf.FreedomConfig{Fragment: fragment})
+	if err != nil {
+		return fmt.Errorf("fragment: %w", err)
+	}
+	raw := json.RawMessage(settings)
+	cfg.OutboundConfigs = append(cfg.OutboundConfigs, conf.OutboundDetourConfig{
+		Protocol: "freedom", Tag: obfsFragmentTag, Settings: &raw,
+	})
+	if s.SocketSettings == nil {
+		s.SocketSettings = &conf.SocketConfig{}
+	}
+	s.SocketSettings.DialerProxy = obfsFragmentTag
+
+	return nil
+}
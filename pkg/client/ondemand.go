@@ -0,0 +1,141 @@
+package client
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// onDemandBuffer is the number of packets held while the connection is established on demand,
+// later packets are dropped until it is done (TCP retransmits them).
+const onDemandBuffer = 64
+
+// demandState is the state of demandTunnel.
+type demandState int
+
+const (
+	demandStandby demandState = iota
+	demandStarting
+	demandStarted
+)
+
+// demandTunnel holds packets read from TUN in standby until start establishes the connection,
+// which is triggered by the first packet (see Config.OnDemand). If start fails, buffered packets
+// are dropped and the tunnel goes back to standby, packets do not trigger start until backoff allows it.
+type demandTunnel struct {
+	io.ReadWriteCloser
+	start   func() error
+	mtu     int
+	backoff *ReconnectBackoff
+
+	mu    sync.Mutex
+	state demandState
+	ready chan struct{} // Closed once started.
+
+	packets chan []byte   // Packets read in standby, closed when TUN reader hands over to Read.
+	done    chan struct{} // Closed when TUN reader exits.
+	err     error         // TUN read error, set before done is closed.
+	direct  bool          // Read reads TUN directly, accessed by Read only.
+}
+
+func newDemandTunnel(tun io.ReadWriteCloser, mtu int, start func() error, backoff *ReconnectBackoff) *demandTunnel {
+	t := &demandTunnel{
+		ReadWriteCloser: tun,
+		start:           start,
+		mtu:             mtu,
+		backoff:         backoff,
+		ready:           make(chan struct{}),
+		packets:         make(chan []byte, onDemandBuffer),
+		done:            make(chan struct{}),
+	}
+	go t.readStandby()
+
+	return t
+}
+
+// readStandby reads TUN until the connection is started, the first packet read after it
+// is the last one passed through packets.
+func (t *demandTunnel) readStandby() {
+	defer close(t.done)
+	defer close(t.packets)
+
+	for {
+		buf := make([]byte, t.mtu)
+		n, err := t.ReadWriteCloser.Read(buf)
+		if err != nil {
+			t.err = err
+			return
+		}
+
+		t.mu.Lock()
+		state := t.state
+		if state == demandStandby {
+			if !t.backoff.Allowed(time.Now()) {
+				t.mu.Unlock()
+				continue // Dropped, TCP retransmits it.
+			}
+			t.state = demandStarting
+			go t.connect()
+		}
+		t.mu.Unlock()
+
+		if state == demandStarted {
+			t.packets <- buf[:n]
+			return
+		}
+		select {
+		case t.packets <- buf[:n]:
+		default: // Buffer is full.
+		}
+	}
+}
+
+// connect starts the connection, the tunnel is back in standby if it fails.
+func (t *demandTunnel) connect() {
+	err := t.start()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.state = demandStandby
+		for len(t.packets) > 0 {
+			<-t.packets
+		}
+		return
+	}
+	t.state = demandStarted
+	close(t.ready)
+}
+
+// Read returns packets buffered in standby once the connection is started, then reads TUN directly.
+func (t *demandTunnel) Read(p []byte) (int, error) {
+	for !t.direct {
+		select {
+		case <-t.ready:
+		case <-t.done:
+			if !t.started() {
+				return 0, t.err
+			}
+		}
+		pkt, ok := <-t.packets
+		if ok {
+			return copy(p, pkt), nil
+		}
+		if t.err != nil {
+			return 0, t.err
+		}
+		t.direct = true
+	}
+
+	return t.ReadWriteCloser.Read(p)
+}
+
+// started reports whether the connection is established.
+func (t *demandTunnel) started() bool {
+	select {
+	case <-t.ready:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testTUN returns packets sent to in on Read.
+type testTUN struct {
+	in     chan []byte
+	closed chan struct{}
+}
+
+func newTestTUN() *testTUN {
+	return &testTUN{in: make(chan []byte, 16), closed: make(chan struct{})}
+}
+
+func (t *testTUN) Read(p []byte) (int, error) {
+	select {
+	case pkt := <-t.in:
+		return copy(p, pkt), nil
+	case <-t.closed:
+		return 0, io.EOF
+	}
+}
+
+func (t *testTUN) Write(p []byte) (int, error) { return len(p), nil }
+
+func (t *testTUN) Close() error {
+	close(t.closed)
+	return nil
+}
+
+func TestDemandTunnel(t *testing.T) {
+	tun := newTestTUN()
+	release := make(chan struct{})
+	var starts atomic.Int32
+	dt := newDemandTunnel(tun, tunMTU, func() error {
+		if starts.Add(1) == 1 {
+			return errors.New("server unreachable")
+		}
+		<-release
+		return nil
+	}, nil)
+
+	read := make(chan string, 8)
+	go func() {
+		buf := make([]byte, tunMTU)
+		for {
+			n, err := dt.Read(buf)
+			if err != nil {
+				close(read)
+				return
+			}
+			read <- string(buf[:n])
+		}
+	}()
+
+	// Failed start drops the packet, the next one starts again.
+	tun.in <- []byte("lost")
+	require.Eventually(t, func() bool { return starts.Load() == 1 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		dt.mu.Lock()
+		defer dt.mu.Unlock()
+		return dt.state == demandStandby
+	}, time.Second, time.Millisecond)
+
+	tun.in <- []byte("syn")
+	tun.in <- []byte("dns")
+	require.Eventually(t, func() bool { return starts.Load() == 2 }, time.Second, time.Millisecond)
+	require.Never(t, func() bool { return len(read) > 0 }, 50*time.Millisecond, time.Millisecond, "held until started")
+
+	close(release)
+	require.Equal(t, "syn", <-read)
+	require.Equal(t, "dns", <-read)
+	tun.in <- []byte("after")
+	require.Equal(t, "after", <-read)
+	require.EqualValues(t, 2, starts.Load())
+
+	require.NoError(t, dt.Close())
+	_, ok := <-read
+	require.False(t, ok, "read fails once TUN is closed")
+}
+
+func TestDemandTunnel_closeInStandby(t *testing.T) {
+	tun := newTestTUN()
+	dt := newDemandTunnel(tun, tunMTU, func() error { return nil }, nil)
+
+	require.NoError(t, dt.Close())
+	_, err := dt.Read(make([]byte, tunMTU))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestDemandTunnel_backoff(t *testing.T) {
+	tun := newTestTUN()
+	var (
+		b      ReconnectBackoff
+		starts atomic.Int32
+	)
+	b.SetOptions(&ReconnectOptions{MinBackoff: 200 * time.Millisecond, MaxBackoff: 200 * time.Millisecond})
+	dt := newDemandTunnel(tun, tunMTU, func() error {
+		starts.Add(1)
+		b.Failed(time.Now())
+		return errors.New("server unreachable")
+	}, &b)
+	defer dt.Close()
+
+	tun.in <- []byte("syn")
+	require.Eventually(t, func() bool { return starts.Load() == 1 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		dt.mu.Lock()
+		defer dt.mu.Unlock()
+		return dt.state == demandStandby
+	}, time.Second, time.Millisecond)
+
+	tun.in <- []byte("retransmit")
+	require.Never(t, func() bool { return starts.Load() > 1 }, 50*time.Millisecond, time.Millisecond, "in backoff")
+	require.Eventually(t, func() bool {
+		tun.in <- []byte("retransmit")
+		return starts.Load() == 2
+	}, time.Second, 20*time.Millisecond, "retried after backoff")
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/goxray/tun/pkg/pac"
+)
+
+const pacPath = "/proxy.pac"
+
+// PACOptions configure PAC file server started on Connect, browsers configured with Client.PACURL
+// send through the inbound proxy the same traffic the TUN device gets (see Client.PACScript).
+type PACOptions struct {
+	// Listen is the server address (default: 127.0.0.1 with random port).
+	Listen string
+	// ProxyDomains are domains (with subdomains) sent through the proxy.
+	ProxyDomains []string
+	// DirectDomains are domains (with subdomains) connected directly, they take precedence over ProxyDomains.
+	DirectDomains []string
+}
+
+// PACScript returns PAC file mirroring the Client routing: addresses of Config.RoutesToTUN go via the inbound
+// proxy except local networks, domains are matched by PACOptions. The PAC file is empty for Unix socket inbound.
+func (c *Client) PACScript() []byte {
+	addr, err := c.localInboundAddr()
+	if err != nil {
+		return nil
+	}
+
+	proxy := fmt.Sprintf("SOCKS5 %[1]s; SOCKS %[1]s", addr)
+	if c.xJSON != nil {
+		proxy += "; PROXY " + addr // XRay socks inbound serves HTTP proxy requests as well.
+	}
+	r := pac.Rules{Proxy: proxy, DirectNets: pac.LocalNets}
+	for _, a := range c.cfg.RoutesToTUN {
+		r.ProxyNets = append(r.ProxyNets, (*net.IPNet)(a))
+	}
+	if opts := c.cfg.PAC; opts != nil {
+		r.ProxyDomains, r.DirectDomains = opts.ProxyDomains, opts.DirectDomains
+	}
+
+	return pac.Script(r)
+}
+
+// PACURL returns URL of the PAC file served while connected, empty if PAC server is not running.
+func (c *Client) PACURL() string {
+	if c.pacServer == nil {
+		return ""
+	}
+
+	return "http://" + c.pacAddr.String() + pacPath
+}
+
+// startPAC starts PAC file server if Config.PAC is set.
+func (c *Client) startPAC() error {
+	if c.cfg.PAC == nil {
+		return nil
+	}
+	if _, err := c.localInboundAddr(); err != nil {
+		return fmt.Errorf("pac: %w", err)
+	}
+
+	listen := c.cfg.PAC.Listen
+	if listen == "" {
+		listen = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("pac: listen: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(pacPath, pac.Handler(c.PACScript))
+	c.pacServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	c.pacAddr = ln.Addr()
+	go func() {
+		if err := c.pacServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			c.cfg.Logger.Error("pac server stopped", "err", err)
+		}
+	}()
+	c.cfg.Logger.Debug("pac server started", "url", c.PACURL())
+
+	return nil
+}
+
+// stopPAC stops PAC file server.
+func (c *Client) stopPAC(ctx context.Context) error {
+	if c.pacServer == nil {
+		return nil
+	}
+	err := c.pacServer.Shutdown(ctx)
+	c.pacServer = nil
+	if err != nil {
+		return fmt.Errorf("stop pac server: %w", err)
+	}
+
+	return nil
+}
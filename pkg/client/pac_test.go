@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_PAC(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.RoutesToTUN = DefaultRoutesToTUN
+	cl.cfg.PAC = &PACOptions{DirectDomains: []string{"intranet.example.com"}}
+	_, _, err := cl.createProxy("trojan://pass@127.0.0.14:443")
+	require.NoError(t, err)
+
+	require.Empty(t, cl.PACURL())
+	require.NoError(t, cl.startPAC())
+	require.NotEmpty(t, cl.PACURL())
+
+	resp, err := http.Get(cl.PACURL())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "application/x-ns-proxy-autoconfig", resp.Header.Get("Content-Type"))
+	require.Contains(t, string(body), `var proxy = "SOCKS5 127.0.0.1:10234; SOCKS 127.0.0.1:10234; PROXY 127.0.0.1:10234";`)
+	require.Contains(t, string(body), `dnsDomainIs(host, ".intranet.example.com")`)
+	require.Contains(t, string(body), `isInNet(ip, "192.168.0.0", "255.255.0.0")`)
+
+	require.NoError(t, cl.stopPAC(context.Background()))
+	require.Empty(t, cl.PACURL())
+	require.NoError(t, cl.stopPAC(context.Background()))
+
+	cl.cfg.InboundProxy = &Proxy{Path: "/run/goxray.sock"}
+	require.Empty(t, cl.PACScript())
+	require.ErrorContains(t, cl.startPAC(), "not supported for unix socket")
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// tunnelGuard recovers panics of the connection goroutines (the tunnel pipe with tun2socks and proxy callbacks,
+// flow reaper and health checks). Instead of crashing the process with the traffic routed into a dead tunnel,
+// the connection is torn down right away (routes are removed, TUN device and the proxy are closed) and
+// EventTunnelPanic is emitted. Client.Disconnect releases the rest as usual.
+type tunnelGuard struct {
+	c      *Client
+	cancel context.CancelFunc // Stops the connection goroutines.
+}
+
+// recover recovers panic of goroutine name, it must be deferred directly.
+func (g *tunnelGuard) recover(name string) {
+	if v := recover(); v != nil {
+		_ = g.panicked(name, v)
+	}
+}
+
+// panicked tears down the connection after goroutine name panicked with v, the panic is returned as error.
+func (g *tunnelGuard) panicked(name string, v any) error {
+	err := fmt.Errorf("%s panic: %v", name, v)
+	g.c.cfg.Logger.Error("tunnel goroutine panicked, tearing down the tunnel",
+		"goroutine", name, "panic", v, "stack", string(debug.Stack()))
+
+	g.cancel()
+	if tdErr := g.c.tearDown(); tdErr != nil {
+		g.c.cfg.Logger.Warn("tunnel teardown after panic failed", "err", tdErr)
+	}
+	g.c.emit(Event{
+		Type:    EventTunnelPanic,
+		Message: "tunnel stopped after internal error",
+		Attrs:   map[string]string{"goroutine": name, "err": err.Error()},
+	})
+	g.c.end(DisconnectPanic, err)
+
+	return err
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// panicPipe panics on the first packet.
+type panicPipe struct{}
+
+func (panicPipe) Copy(_ context.Context, pipe io.ReadWriteCloser, _ string) error {
+	_, _ = pipe.Read(make([]byte, tunMTU))
+	panic("pipe bug")
+}
+
+func TestTunnelGuard_pipePanic(t *testing.T) {
+	c, tun, routes, engine := newLoopbackClient(t)
+	c.pipe = panicPipe{}
+	events := make(chan Event, 2)
+	c.cfg.OnEvent = func(ev Event) { events <- ev }
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	require.NotEmpty(t, routes.Routes())
+
+	tun.in <- []byte{0x45}
+	var ev Event
+	select {
+	case ev = <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no panic event")
+	}
+	require.Equal(t, EventTunnelPanic, ev.Type)
+	require.Equal(t, "tunnel pipe", ev.Attrs["goroutine"])
+	require.Equal(t, "tunnel pipe panic: pipe bug", ev.Attrs["err"])
+
+	// Torn down before Disconnect.
+	require.Empty(t, routes.Routes())
+	require.True(t, engine().closed)
+	_, err := tun.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	ev = <-events
+	require.Equal(t, EventDisconnected, ev.Type)
+	require.Equal(t, string(DisconnectPanic), ev.Attrs["reason"])
+
+	ops := len(routes.Ops())
+	require.ErrorContains(t, c.Disconnect(context.Background()), "tunnel pipe panic: pipe bug")
+	require.Len(t, routes.Ops(), ops, "routes are not deleted twice")
+	require.Equal(t, DisconnectPanic, c.LastDisconnect().Reason, "the first reason is kept")
+	require.Empty(t, events)
+}
+
+func TestTunnelGuard_recover(t *testing.T) {
+	c, _, routes, _ := newLoopbackClient(t)
+	var events []Event
+	c.cfg.OnEvent = func(ev Event) { events = append(events, ev) }
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+
+	stopped := false
+	stop := c.stopTunnel
+	func() {
+		defer (&tunnelGuard{c: c, cancel: func() { stopped = true; stop() }}).recover("health checks")
+		panic("check bug")
+	}()
+	require.True(t, stopped, "connection goroutines are stopped")
+	require.Len(t, events, 2)
+	require.Equal(t, "health checks", events[0].Attrs["goroutine"])
+	require.Equal(t, EventDisconnected, events[1].Type)
+	require.Equal(t, string(DisconnectPanic), events[1].Attrs["reason"])
+	require.Empty(t, routes.Routes())
+	require.NoError(t, c.Disconnect(context.Background()))
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/eycorsican/go-tun2socks/core"
+)
+
+// socksPipe routes IP packets from TUN device to socks5 proxy and back (tun2socks),
+// TCP connections and UDP flows are tracked in flows, classified by qos and spread over servers by balancer.
+type socksPipe struct {
+	mtu        int
+	udpTimeout time.Duration
+	flows      *flowTable
+	qos        *qosTable
+	balancer   *balancerTable
+	shards     *packetShards
+	udp        *udpRelay
+	quic       *quicPolicy
+}
+
+func newSocksPipe(mtu int, udpTimeout time.Duration, flows *flowTable, qos *qosTable, balancer *balancerTable,
+	shards *packetShards, udp *udpRelay, quic *quicPolicy,
+) *socksPipe {
+	return &socksPipe{
+		mtu: mtu, udpTimeout: udpTimeout, flows: flows, qos: qos, balancer: balancer, shards: shards, udp: udp, quic: quic,
+	}
+}
+
+// Copy reads IP packets from pipe and routes them to socks5 proxy address and back.
+// It blocks until ctx is cancelled or pipe is closed.
+func (p *socksPipe) Copy(ctx context.Context, pipe io.ReadWriteCloser, socks5 string) error {
+	if _, _, err := net.SplitHostPort(socks5); err != nil {
+		return fmt.Errorf("parse socks addr: %w", err)
+	}
+	dialer, err := p.flows.socksTimeouts().dialer(socks5)
+	if err != nil {
+		return fmt.Errorf("socks5 dialer: %w", err)
+	}
+
+	defer p.udp.close()
+	udp := newFlowUDPHandler(p.udp.handler(socks5, p.udpTimeout), p.flows)
+	udp.quic, udp.reject = p.quic, pipe.Write
+	var observe func([]byte)
+	if p.qos.enabled() {
+		udp.qos = p.qos
+		observe = p.qos.observe
+	}
+	if p.balancer.enabled() {
+		udp.balancer = p.balancer
+	}
+	if udp.qos != nil || udp.balancer != nil {
+		udp.classHandler = func(addr string) core.UDPConnHandler {
+			return p.udp.handler(addr, p.udpTimeout)
+		}
+	}
+
+	tcp := &flowTCPHandler{dialer: dialer, ctx: ctx, flows: p.flows, qos: p.qos, balancer: p.balancer}
+
+	return copyToStack(ctx, pipe, p.mtu, tcp, udp, observe, p.shards)
+}
+
+// copyToStack writes IP packets read from pipe into new netStack of the handlers until ctx is cancelled or pipe
+// is closed, packets of the stack are written to pipe. Packets are passed to observe if not nil.
+// They are processed by workers of shards if there are several, see PipeOptions.
+func copyToStack(ctx context.Context, pipe io.ReadWriter, mtu int, tcp core.TCPConnHandler, udp core.UDPConnHandler,
+	observe func([]byte), shards *packetShards,
+) error {
+	stack, err := newNetStack(mtu, tcp, udp, pipe.Write)
+	if err != nil {
+		return fmt.Errorf("tcp/ip stack: %w", err)
+	}
+	defer stack.Close()
+
+	if shards.parallel() {
+		return shards.copy(ctx, pipe, mtu, func(pkt []byte) error {
+			if observe != nil {
+				observe(pkt)
+			}
+			if _, err := stack.Write(pkt); err != nil {
+				return fmt.Errorf("write tcp/ip stack: %w", err)
+			}
+			return nil
+		})
+	}
+
+	buf := make([]byte, mtu)
+	for {
+		n, err := pipe.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return fmt.Errorf("read pipe: %w", err)
+		}
+		if observe != nil {
+			observe(buf[:n])
+		}
+		if _, err = stack.Write(buf[:n]); err != nil {
+			return fmt.Errorf("write tcp/ip stack: %w", err)
+		}
+	}
+}
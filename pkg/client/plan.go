@@ -0,0 +1,95 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// Plan describes the changes Connect makes to the system, see Client.Plan.
+type Plan struct {
+	// Protocol of the connection link, e.g. "vless".
+	Protocol string `json:"protocol"`
+	// Server is the remote server address, ServerIP is the address it resolves to.
+	Server   string `json:"server"`
+	ServerIP net.IP `json:"server_ip"`
+	// Engine is set if the link is served by registered Engine instead of XRay core.
+	Engine bool `json:"engine,omitempty"`
+	// XrayConfig is XRay core json config with secrets redacted (empty for Engine protocols).
+	XrayConfig json.RawMessage `json:"xray_config,omitempty"`
+	// InboundProxy is the socks proxy TUN device traffic is passed to, Config.Upstream if it is set.
+	InboundProxy string `json:"inbound_proxy"`
+	// TUN is the TUN device created on Connect.
+	TUN PlanTUN `json:"tun"`
+	// Routes are added on Connect and deleted on Disconnect.
+	Routes []PlanRoute `json:"routes"`
+}
+
+// PlanTUN describes TUN device settings.
+type PlanTUN struct {
+	Address string `json:"address"`
+	MTU     int    `json:"mtu"`
+	Netns   string `json:"netns,omitempty"` // Network namespace of the device, see Config.Netns.
+}
+
+// PlanRoute describes a route, either via Gateway or via the TUN device.
+type PlanRoute struct {
+	Destination string `json:"destination"`
+	Gateway     net.IP `json:"gateway,omitempty"`
+	Device      string `json:"device,omitempty"`
+	Metric      int    `json:"metric,omitempty"` // Config.RouteMetric, zero is the default.
+	Table       int    `json:"table,omitempty"`  // Table of Config.RouteIsolation, zero is the main table.
+}
+
+// planTUNDevice is the device name in PlanRoute, the actual name is assigned by OS on Connect.
+const planTUNDevice = "tun"
+
+// Plan parses link and returns the changes Connect(link) would make without touching the system,
+// so they can be reviewed before running with root privileges.
+func (c *Client) Plan(link string) (*Plan, error) {
+	spec, err := c.parseLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	inbound := c.cfg.InboundProxy
+	if c.cfg.Upstream != nil {
+		inbound = c.cfg.Upstream
+	}
+	p := &Plan{
+		Protocol:     spec.general.Protocol,
+		Server:       spec.general.Address,
+		Engine:       spec.engine != nil,
+		InboundProxy: inbound.String(),
+		TUN:          PlanTUN{Address: c.cfg.TUNAddress.String(), MTU: c.cfg.MTU, Netns: c.cfg.Netns},
+	}
+	if spec.xray != nil {
+		if p.XrayConfig, err = marshalXrayConfig(spec.xray, true); err != nil {
+			return nil, err
+		}
+	}
+
+	ip, _, err := c.resolveServer(spec.general.Address, spec.general.Port)
+	if err != nil {
+		return nil, fmt.Errorf("server address not resolvable: %w", err)
+	}
+	p.ServerIP = ip
+
+	var table int
+	if c.cfg.RouteIsolation != nil {
+		table = c.cfg.RouteIsolation.Table
+	}
+	for _, r := range c.cfg.RoutesToTUN {
+		p.Routes = append(p.Routes, PlanRoute{Destination: r.String(), Device: planTUNDevice, Metric: c.cfg.RouteMetric, Table: table})
+	}
+	var gw net.IP
+	if c.cfg.GatewayIP != nil {
+		gw = *c.cfg.GatewayIP
+	}
+	// Server route exception, see xrayToGatewayRoute.
+	if c.serverRouteNeeded(ip) {
+		p.Routes = append(p.Routes, PlanRoute{Destination: hostRoute(ip).String(), Gateway: gw, Metric: c.cfg.RouteMetric, Table: table})
+	}
+
+	return p, nil
+}
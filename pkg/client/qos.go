@@ -0,0 +1,301 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// errQoSUnsupported is returned for DSCP marking on platforms without IP_TOS socket option support.
+var errQoSUnsupported = errors.New("dscp marking is not supported on this platform")
+
+// QoSOptions mark tunneled traffic with DSCP (Differentiated Services Code Point), so upstream network
+// equipment can prioritize it, e.g. VoIP.
+//
+// Marks are set on the XRay server connections, application packets are not seen by the network.
+// With Classes application marks are carried over: flows are connected to the server via separate
+// connections marked according to the class. It requires a server connection per flow, so the link
+// must not use mux. QoS is supported for protocols served by XRay core on Linux and macOS.
+type QoSOptions struct {
+	// DSCP marks server connections, 0-63 (default: 0, not marked), e.g. 10 (AF11) for low priority.
+	DSCP int
+	// Classes maps DSCP of application packets to DSCP of the server connections carrying them,
+	// e.g. {46: 46} preserves Expedited Forwarding of VoIP, {34: 46} remaps AF41 video to it.
+	// Flows with other marks use DSCP.
+	Classes map[int]int
+}
+
+// Validate checks options values.
+func (o *QoSOptions) Validate() error {
+	if o.DSCP < 0 || o.DSCP > 63 {
+		return fmt.Errorf("invalid dscp %d, must be 0-63", o.DSCP)
+	}
+	for in, out := range o.Classes {
+		if in < 0 || in > 63 || out < 0 || out > 63 {
+			return fmt.Errorf("invalid class %d: %d, dscp must be 0-63", in, out)
+		}
+	}
+
+	return nil
+}
+
+// qosTagPrefix is the prefix of XRay config tags created for QoS classes.
+const qosTagPrefix = "qos-"
+
+// addQoS marks XRay proxy outbound with DSCP, each class gets a loopback socks inbound routed to the copy
+// of the proxy outbound marked with the class DSCP. It returns inbound addresses of inner DSCP values.
+func addQoS(cfg *conf.Config, opts *QoSOptions) (map[uint8]string, error) {
+	if opts == nil || opts.DSCP == 0 && len(opts.Classes) == 0 {
+		return nil, nil
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if len(opts.Classes) > 0 && cfg.OutboundConfigs[0].MuxSettings != nil && cfg.OutboundConfigs[0].MuxSettings.Enabled {
+		return nil, errors.New("dscp classes are not supported with mux")
+	}
+
+	proxy := &cfg.OutboundConfigs[0]
+	base := cloneOutbound(proxy) // Classes are marked with their DSCP only.
+	if opts.DSCP > 0 {
+		if err := markOutbound(proxy, opts.DSCP); err != nil {
+			return nil, err
+		}
+	}
+
+	inbounds := make(map[int]string) // Class DSCP to inbound address.
+	classes := make(map[uint8]string, len(opts.Classes))
+	if cfg.RouterConfig == nil && len(opts.Classes) > 0 {
+		cfg.RouterConfig = &conf.RouterConfig{}
+	}
+	for _, in := range slices.Sorted(maps.Keys(opts.Classes)) {
+		dscp := opts.Classes[in]
+		if addr, ok := inbounds[dscp]; ok {
+			classes[uint8(in)] = addr
+			continue
+		}
+
+		tag := qosTagPrefix + strconv.Itoa(dscp)
+		out := cloneOutbound(&base)
+		out.Tag = tag
+		if err := markOutbound(&out, dscp); err != nil {
+			return nil, err
+		}
+		cfg.OutboundConfigs = append(cfg.OutboundConfigs, out)
+
+		port := getFreePort()
+		addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+		var inbound conf.InboundDetourConfig
+		b := fmt.Sprintf(`{"tag":%q,"listen":"127.0.0.1","port":%d,"protocol":"socks","settings":{"udp":true}}`, tag, port)
+		if err := json.Unmarshal([]byte(b), &inbound); err != nil {
+			return nil, fmt.Errorf("dscp class %d: %w", dscp, err)
+		}
+		cfg.InboundConfigs = append(cfg.InboundConfigs, inbound)
+
+		rule, err := json.Marshal(map[string]any{"type": "field", "inboundTag": []string{tag}, "outboundTag": tag})
+		if err != nil {
+			return nil, fmt.Errorf("dscp class %d: %w", dscp, err)
+		}
+		cfg.RouterConfig.RuleList = append(cfg.RouterConfig.RuleList, rule)
+
+		inbounds[dscp] = addr
+		classes[uint8(in)] = addr
+	}
+
+	return classes, nil
+}
+
+// cloneOutbound copies outbound, so that its socket options can be changed.
+func cloneOutbound(out *conf.OutboundDetourConfig) conf.OutboundDetourConfig {
+	c := *out
+	if c.StreamSetting != nil {
+		ss := *c.StreamSetting
+		if ss.SocketSettings != nil {
+			sock := *ss.SocketSettings
+			ss.SocketSettings = &sock
+		}
+		c.StreamSetting = &ss
+	}
+
+	return c
+}
+
+// markOutbound sets socket options marking outbound connections with dscp.
+func markOutbound(out *conf.OutboundDetourConfig, dscp int) error {
+	opts, err := dscpSockopts(dscp)
+	if err != nil {
+		return err
+	}
+	if out.StreamSetting == nil {
+		out.StreamSetting = &conf.StreamConfig{}
+	}
+	if out.StreamSetting.SocketSettings == nil {
+		out.StreamSetting.SocketSettings = &conf.SocketConfig{}
+	}
+	sock := out.StreamSetting.SocketSettings
+	sock.CustomSockopt = append(slices.Clip(sock.CustomSockopt), opts...)
+
+	return nil
+}
+
+// maxQoSSeen limits flows classified but not yet connected, seen entries older than qosSeenTTL are dropped
+// to make room.
+const (
+	maxQoSSeen = 4096
+	qosSeenTTL = 5 * time.Second
+)
+
+// qosTable classifies new flows by DSCP of their first packet read from TUN, see QoSOptions.Classes.
+type qosTable struct {
+	mu      sync.Mutex
+	classes map[uint8]string // Inner DSCP to class socks5 address.
+	seen    map[qosFlowKey]qosSeen
+}
+
+type qosFlowKey struct {
+	proto    uint8
+	src, dst netip.AddrPort
+}
+
+type qosSeen struct {
+	addr string
+	at   time.Time
+}
+
+func newQoSTable() *qosTable {
+	return &qosTable{seen: make(map[qosFlowKey]qosSeen)}
+}
+
+// setClasses sets inner DSCP to class socks5 address mapping, nil disables classification.
+func (q *qosTable) setClasses(classes map[uint8]string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.classes = classes
+	clear(q.seen)
+}
+
+func (q *qosTable) enabled() bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.classes) > 0
+}
+
+// observe records class of the flow if pkt is TCP SYN or UDP packet marked with class DSCP.
+func (q *qosTable) observe(pkt []byte) {
+	key, dscp, ok := parseFlowPacket(pkt)
+	if !ok {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	addr, ok := q.classes[dscp]
+	if !ok {
+		return
+	}
+	if _, ok = q.seen[key]; ok {
+		return
+	}
+	now := time.Now()
+	if len(q.seen) >= maxQoSSeen {
+		maps.DeleteFunc(q.seen, func(_ qosFlowKey, s qosSeen) bool { return now.Sub(s.at) > qosSeenTTL })
+		if len(q.seen) >= maxQoSSeen {
+			return
+		}
+	}
+	q.seen[key] = qosSeen{addr: addr, at: now}
+}
+
+// take returns class socks5 address of new flow, false if the flow is not classified.
+func (q *qosTable) take(network string, src, dst net.Addr) (string, bool) {
+	if q == nil {
+		return "", false
+	}
+	key := qosFlowKey{proto: protoTCP, src: addrPort(src), dst: addrPort(dst)}
+	if network == "udp" {
+		key.proto = protoUDP
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s, ok := q.seen[key]
+	delete(q.seen, key)
+
+	return s.addr, ok
+}
+
+func addrPort(a net.Addr) netip.AddrPort {
+	var ap netip.AddrPort
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		ap = a.AddrPort()
+	case *net.UDPAddr:
+		ap = a.AddrPort()
+	}
+
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+const (
+	protoTCP = 6
+	protoUDP = 17
+	tcpSYN   = 0x02
+	tcpACK   = 0x10
+)
+
+// parseFlowPacket returns flow key and DSCP of IPv4 or IPv6 packet starting TCP connection (SYN) or UDP packet.
+func parseFlowPacket(pkt []byte) (qosFlowKey, uint8, bool) {
+	var key qosFlowKey
+	var dscp uint8
+	var l4 []byte
+	switch {
+	case len(pkt) >= 20 && pkt[0]>>4 == 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		if ihl < 20 || len(pkt) < ihl {
+			return key, 0, false
+		}
+		dscp = pkt[1] >> 2
+		key.proto = pkt[9]
+		key.src = netip.AddrPortFrom(netip.AddrFrom4([4]byte(pkt[12:16])), 0)
+		key.dst = netip.AddrPortFrom(netip.AddrFrom4([4]byte(pkt[16:20])), 0)
+		l4 = pkt[ihl:]
+	case len(pkt) >= 40 && pkt[0]>>4 == 6:
+		dscp = (pkt[0]&0x0f)<<2 | pkt[1]>>6
+		key.proto = pkt[6]
+		key.src = netip.AddrPortFrom(netip.AddrFrom16([16]byte(pkt[8:24])), 0)
+		key.dst = netip.AddrPortFrom(netip.AddrFrom16([16]byte(pkt[24:40])), 0)
+		l4 = pkt[40:]
+	default:
+		return key, 0, false
+	}
+
+	switch key.proto {
+	case protoTCP:
+		if len(l4) < 14 || l4[13]&(tcpSYN|tcpACK) != tcpSYN {
+			return key, 0, false
+		}
+	case protoUDP:
+		if len(l4) < 8 {
+			return key, 0, false
+		}
+	default:
+		return key, 0, false
+	}
+	key.src = netip.AddrPortFrom(key.src.Addr(), uint16(l4[0])<<8|uint16(l4[1]))
+	key.dst = netip.AddrPortFrom(key.dst.Addr(), uint16(l4[2])<<8|uint16(l4[3]))
+
+	return key, dscp, true
+}
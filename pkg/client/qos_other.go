@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package client
+
+import (
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func dscpSockopts(int) ([]*conf.CustomSockoptConfig, error) {
+	return nil, errQoSUnsupported
+}
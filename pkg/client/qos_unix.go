@@ -0,0 +1,28 @@
+//go:build linux || darwin
+
+package client
+
+import (
+	"strconv"
+	"syscall"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// dscpSockopts returns XRay socket options setting IPv4 TOS and IPv6 traffic class to dscp.
+func dscpSockopts(dscp int) ([]*conf.CustomSockoptConfig, error) {
+	tos := strconv.Itoa(dscp << 2) // DSCP is the upper 6 bits, ECN bits are left to the kernel.
+	ip4 := []string{strconv.Itoa(syscall.IPPROTO_IP), strconv.Itoa(syscall.IP_TOS)}
+	ip6 := []string{strconv.Itoa(syscall.IPPROTO_IPV6), strconv.Itoa(syscall.IPV6_TCLASS)}
+
+	var opts []*conf.CustomSockoptConfig
+	for _, network := range []string{"tcp4", "udp4", "tcp6", "udp6"} {
+		level, opt := ip4[0], ip4[1]
+		if network[3] == '6' {
+			level, opt = ip6[0], ip6[1]
+		}
+		opts = append(opts, &conf.CustomSockoptConfig{Network: network, Level: level, Opt: opt, Value: tos, Type: "int"})
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QUICMode is how UDP flows to port 443 (QUIC, HTTP/3) are tunneled, see QUICOptions.
+type QUICMode string
+
+const (
+	// QUICRelay relays QUIC like any UDP flow.
+	QUICRelay QUICMode = "relay"
+	// QUICBlock rejects QUIC flows with ICMP port unreachable, so browsers fall back to HTTP over TCP right away.
+	QUICBlock QUICMode = "block"
+	// QUICDeprioritize delays new QUIC flows by QUICOptions.Delay, so browsers racing QUIC with TCP mostly pick
+	// TCP while QUIC still works where TCP does not.
+	QUICDeprioritize QUICMode = "deprioritize"
+)
+
+const (
+	// DefaultQUICDelay is the delay of new QUIC flows with QUICDeprioritize.
+	DefaultQUICDelay = 300 * time.Millisecond
+	// quicPort is the server port of QUIC flows.
+	quicPort = 443
+)
+
+// errQUICBlocked rejects QUIC flows with QUICBlock.
+var errQUICBlocked = errors.New("quic is blocked")
+
+// QUICOptions control QUIC (UDP port 443) of the TUN device. Some servers relay QUIC poorly (e.g. throttled
+// UDP over the transport), browsers are faster over TCP then.
+type QUICOptions struct {
+	// Mode of QUIC flows (default: QUICRelay).
+	Mode QUICMode
+	// Delay of new flows with QUICDeprioritize (default: DefaultQUICDelay).
+	Delay time.Duration
+}
+
+// Validate checks options values.
+func (o *QUICOptions) Validate() error {
+	switch o.Mode {
+	case "", QUICRelay, QUICBlock, QUICDeprioritize:
+	default:
+		return fmt.Errorf("unknown mode %q", o.Mode)
+	}
+	if o.Delay < 0 {
+		return errors.New("delay must not be negative")
+	}
+	if o.Delay > 0 && o.Mode != QUICDeprioritize {
+		return errors.New("delay requires deprioritize mode")
+	}
+
+	return nil
+}
+
+func (o *QUICOptions) mode() QUICMode {
+	if o == nil || o.Mode == "" {
+		return QUICRelay
+	}
+
+	return o.Mode
+}
+
+func (o *QUICOptions) delay() time.Duration {
+	if o == nil || o.Delay == 0 {
+		return DefaultQUICDelay
+	}
+
+	return o.Delay
+}
+
+// QUICStats are counters of QUIC flows by mode since the Client is created, see QUICOptions.
+type QUICStats struct {
+	Relayed uint64 // Flows relayed right away.
+	Blocked uint64 // Flows rejected.
+	Delayed uint64 // Flows relayed after the delay.
+}
+
+// QUICStats returns counters of QUIC flows.
+func (c *Client) QUICStats() QUICStats {
+	return c.quic.stats()
+}
+
+// quicPolicy applies QUICOptions to new UDP flows of the pipe.
+type quicPolicy struct {
+	mu    sync.Mutex
+	mode  QUICMode
+	delay time.Duration
+
+	relayed atomic.Uint64
+	blocked atomic.Uint64
+	delayed atomic.Uint64
+}
+
+func newQUICPolicy() *quicPolicy {
+	return &quicPolicy{mode: QUICRelay, delay: DefaultQUICDelay}
+}
+
+// setOptions sets mode of new flows, see QUICOptions.
+func (p *quicPolicy) setOptions(opts *QUICOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mode, p.delay = opts.mode(), opts.delay()
+}
+
+// admit applies the mode to new UDP flow to target, it returns errQUICBlocked if the flow is rejected.
+// Deprioritized flows are delayed, it is called for every flow in its own goroutine.
+func (p *quicPolicy) admit(target *net.UDPAddr) error {
+	if p == nil || target == nil || target.Port != quicPort {
+		return nil
+	}
+	p.mu.Lock()
+	mode, delay := p.mode, p.delay
+	p.mu.Unlock()
+
+	switch mode {
+	case QUICBlock:
+		p.blocked.Add(1)
+		return errQUICBlocked
+	case QUICDeprioritize:
+		p.delayed.Add(1)
+		time.Sleep(delay)
+	default:
+		p.relayed.Add(1)
+	}
+
+	return nil
+}
+
+func (p *quicPolicy) stats() QUICStats {
+	return QUICStats{Relayed: p.relayed.Load(), Blocked: p.blocked.Load(), Delayed: p.delayed.Load()}
+}
+
+// portUnreachable returns ICMP port unreachable packet of target to UDP datagram from src, nil if the
+// addresses are of different families. It quotes the IP and UDP headers of the datagram.
+func portUnreachable(src, target *net.UDPAddr) []byte {
+	if src4, dst4 := src.IP.To4(), target.IP.To4(); src4 != nil && dst4 != nil {
+		quote := make([]byte, 20+8)
+		quote[0], quote[8], quote[9] = 0x45, 64, protoUDP
+		binary.BigEndian.PutUint16(quote[2:], 20+8)
+		copy(quote[12:], src4)
+		copy(quote[16:], dst4)
+		binary.BigEndian.PutUint16(quote[10:], inetChecksum(quote[:20], 0))
+		putUDPHeader(quote[20:], src, target)
+
+		pkt := make([]byte, 20+8+len(quote))
+		pkt[0], pkt[8], pkt[9] = 0x45, 64, 1 // ICMP.
+		binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+		copy(pkt[12:], dst4)
+		copy(pkt[16:], src4)
+		binary.BigEndian.PutUint16(pkt[10:], inetChecksum(pkt[:20], 0))
+		icmp := pkt[20:]
+		icmp[0], icmp[1] = 3, 3 // Destination unreachable, port unreachable.
+		copy(icmp[8:], quote)
+		binary.BigEndian.PutUint16(icmp[2:], inetChecksum(icmp, 0))
+
+		return pkt
+	}
+	if src.IP.To4() != nil || target.IP.To4() != nil {
+		return nil
+	}
+
+	quote := make([]byte, 40+8)
+	quote[0], quote[6], quote[7] = 0x60, protoUDP, 64
+	binary.BigEndian.PutUint16(quote[4:], 8)
+	copy(quote[8:], src.IP.To16())
+	copy(quote[24:], target.IP.To16())
+	putUDPHeader(quote[40:], src, target)
+
+	pkt := make([]byte, 40+8+len(quote))
+	pkt[0], pkt[6], pkt[7] = 0x60, 58, 64 // ICMPv6.
+	binary.BigEndian.PutUint16(pkt[4:], uint16(8+len(quote)))
+	copy(pkt[8:], target.IP.To16())
+	copy(pkt[24:], src.IP.To16())
+	icmp := pkt[40:]
+	icmp[0], icmp[1] = 1, 4 // Destination unreachable, port unreachable.
+	copy(icmp[8:], quote)
+	// Pseudo-header: addresses, upper-layer length and next header.
+	pseudo := uint32(len(icmp)) + 58
+	for i := 8; i < 40; i += 2 {
+		pseudo += uint32(binary.BigEndian.Uint16(pkt[i:]))
+	}
+	binary.BigEndian.PutUint16(icmp[2:], inetChecksum(icmp, pseudo))
+
+	return pkt
+}
+
+// putUDPHeader writes UDP header of empty datagram from src to dst into b, without checksum.
+func putUDPHeader(b []byte, src, dst *net.UDPAddr) {
+	binary.BigEndian.PutUint16(b[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(b[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(b[4:], 8)
+}
+
+// inetChecksum returns the Internet checksum (RFC 1071) of b added to partial sum.
+func inetChecksum(b []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+
+	return ^uint16(sum)
+}
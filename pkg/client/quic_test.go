@@ -0,0 +1,102 @@
+package client
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestQUICOptions_Validate(t *testing.T) {
+	require.NoError(t, (&QUICOptions{}).Validate())
+	require.NoError(t, (&QUICOptions{Mode: QUICBlock}).Validate())
+	require.NoError(t, (&QUICOptions{Mode: QUICDeprioritize, Delay: time.Second}).Validate())
+	require.ErrorContains(t, (&QUICOptions{Mode: "drop"}).Validate(), `unknown mode "drop"`)
+	require.ErrorContains(t, (&QUICOptions{Mode: QUICDeprioritize, Delay: -1}).Validate(), "delay must not be negative")
+	require.ErrorContains(t, (&QUICOptions{Mode: QUICBlock, Delay: time.Second}).Validate(), "delay requires deprioritize mode")
+}
+
+func TestQUICPolicy_admit(t *testing.T) {
+	quic := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 443}
+	require.NoError(t, (*quicPolicy)(nil).admit(quic))
+
+	p := newQUICPolicy()
+	require.NoError(t, p.admit(quic))
+	require.NoError(t, p.admit(&net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 53}), "not QUIC")
+	require.NoError(t, p.admit(nil))
+	require.Equal(t, QUICStats{Relayed: 1}, p.stats())
+
+	p.setOptions(&QUICOptions{Mode: QUICBlock})
+	require.ErrorIs(t, p.admit(quic), errQUICBlocked)
+
+	p.setOptions(&QUICOptions{Mode: QUICDeprioritize, Delay: 20 * time.Millisecond})
+	start := time.Now()
+	require.NoError(t, p.admit(quic))
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	require.Equal(t, QUICStats{Relayed: 1, Blocked: 1, Delayed: 1}, p.stats())
+}
+
+func TestPortUnreachable(t *testing.T) {
+	src, dst := &net.UDPAddr{IP: net.IPv4(192, 18, 0, 1), Port: 50000}, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 443}
+	pkt := portUnreachable(src, dst)
+	h, err := ipv4.ParseHeader(pkt)
+	require.NoError(t, err)
+	require.True(t, h.Src.Equal(dst.IP))
+	require.True(t, h.Dst.Equal(src.IP))
+	require.Equal(t, len(pkt), h.TotalLen)
+	require.Zero(t, inetChecksum(pkt[:20], 0), "header checksum")
+	require.Zero(t, inetChecksum(pkt[20:], 0), "icmp checksum")
+	msg, err := icmp.ParseMessage(1, pkt[20:])
+	require.NoError(t, err)
+	require.Equal(t, ipv4.ICMPTypeDestinationUnreachable, msg.Type)
+	require.Equal(t, 3, msg.Code)
+	quote := msg.Body.(*icmp.DstUnreach).Data
+	require.Zero(t, inetChecksum(quote[:20], 0), "quoted header checksum")
+	require.Equal(t, uint16(50000), binary.BigEndian.Uint16(quote[20:]))
+	require.Equal(t, uint16(443), binary.BigEndian.Uint16(quote[22:]))
+
+	src6, dst6 := &net.UDPAddr{IP: net.ParseIP("fd00::2"), Port: 50000}, &net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 443}
+	pkt = portUnreachable(src6, dst6)
+	h6, err := ipv6.ParseHeader(pkt)
+	require.NoError(t, err)
+	require.True(t, h6.Src.Equal(dst6.IP))
+	require.True(t, h6.Dst.Equal(src6.IP))
+	require.Equal(t, len(pkt)-40, h6.PayloadLen)
+	pseudo := uint32(len(pkt)-40) + 58
+	for i := 8; i < 40; i += 2 {
+		pseudo += uint32(binary.BigEndian.Uint16(pkt[i:]))
+	}
+	require.Zero(t, inetChecksum(pkt[40:], pseudo), "icmpv6 checksum")
+	msg, err = icmp.ParseMessage(58, pkt[40:])
+	require.NoError(t, err)
+	require.Equal(t, ipv6.ICMPTypeDestinationUnreachable, msg.Type)
+	require.Equal(t, 4, msg.Code)
+
+	require.Nil(t, portUnreachable(src, dst6), "mixed families")
+}
+
+func TestFlowUDPHandler_QUIC(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	h := newFlowUDPHandler(testUDPHandler{}, cl.flows)
+	var rejected [][]byte
+	h.quic, h.reject = newQUICPolicy(), func(pkt []byte) (int, error) {
+		rejected = append(rejected, pkt)
+		return len(pkt), nil
+	}
+	h.quic.setOptions(&QUICOptions{Mode: QUICBlock})
+	conn := &testUDPConn{}
+
+	require.ErrorIs(t, h.Connect(conn, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 443}), errQUICBlocked)
+	require.Len(t, rejected, 1)
+	require.Equal(t, byte(3), rejected[0][20], "ICMP destination unreachable")
+	require.Empty(t, cl.Flows())
+
+	require.NoError(t, h.Connect(conn, &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 53}))
+	require.Len(t, cl.Flows(), 1)
+	require.Equal(t, QUICStats{Blocked: 1}, h.quic.stats())
+}
@@ -0,0 +1,197 @@
+//go:build !goxray_nohysteria2 || !goxray_notuic
+
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	M "github.com/sagernet/sing/common/metadata"
+	aTLS "github.com/sagernet/sing/common/tls"
+
+	"github.com/goxray/tun/internal/socks5"
+)
+
+// quicClient is a sing-quic client (Hysteria2 or TUIC) connecting to the server lazily, on the first request.
+type quicClient interface {
+	DialConn(ctx context.Context, destination M.Socksaddr) (net.Conn, error)
+	ListenPacket(ctx context.Context) (net.PacketConn, error)
+	CloseWithError(err error) error
+}
+
+// quicEngine serves SOCKS5 inbound with quicClient, TCP connections and UDP associations are relayed over
+// the QUIC connection to the server.
+type quicEngine struct {
+	name    string // Protocol name for logs.
+	host    string
+	inbound Proxy
+	log     *slog.Logger
+	// newClient creates the client, ctx is done when the engine is closed.
+	newClient func(ctx context.Context) (quicClient, error)
+
+	cancel context.CancelFunc
+	client quicClient
+	ln     net.Listener
+	socks  *socks5.Server
+	wg     sync.WaitGroup
+}
+
+// ServerAddr implements Engine.
+func (e *quicEngine) ServerAddr() string {
+	return e.host
+}
+
+// Start starts SOCKS5 inbound, the server is connected by the first request.
+func (e *quicEngine) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := e.newClient(ctx)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("%s client: %w", e.name, err)
+	}
+
+	e.ln, err = net.Listen(e.inbound.Network(), e.inbound.String())
+	if err != nil {
+		cancel()
+		return errors.Join(fmt.Errorf("listen inbound: %w", err), client.CloseWithError(err))
+	}
+
+	e.cancel, e.client = cancel, client
+	e.socks = socks5.NewServer(func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return client.DialConn(ctx, M.ParseSocksaddr(addr))
+	})
+	e.socks.ListenPacket = client.ListenPacket
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		if err := e.socks.Serve(e.ln); err != nil {
+			e.log.Error(e.name+" engine socks server stopped", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// Close stops SOCKS5 inbound and closes QUIC connection.
+func (e *quicEngine) Close() error {
+	if e.client == nil {
+		return nil
+	}
+
+	err := errors.Join(e.ln.Close(), e.socks.Close(), e.client.CloseWithError(net.ErrClosed))
+	e.cancel()
+	e.wg.Wait()
+
+	return err
+}
+
+// quicDialer is sing N.Dialer of the QUIC connection to the server, it dials with EngineOpts.Dial.
+type quicDialer struct {
+	dial DialFunc
+}
+
+func newQUICDialer(dial DialFunc) quicDialer {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	return quicDialer{dial: dial}
+}
+
+// DialContext implements N.Dialer.
+func (d quicDialer) DialContext(ctx context.Context, network string, destination M.Socksaddr) (net.Conn, error) {
+	return d.dial(ctx, network, destination.String())
+}
+
+// ListenPacket implements N.Dialer.
+func (d quicDialer) ListenPacket(ctx context.Context, _ M.Socksaddr) (net.PacketConn, error) {
+	return (&net.ListenConfig{}).ListenPacket(ctx, "udp", "")
+}
+
+// quicTLSConfig is sing TLS config of the QUIC connection, only Config is used by sing-quic clients.
+type quicTLSConfig struct {
+	config *tls.Config
+}
+
+// newQUICTLSConfig returns TLS config verifying the server as serverName, the name is not sent if !sni. The server
+// is verified by its certificate SHA-256 hash pinSHA256 (hex encoded) instead if set, or not verified if insecure.
+func newQUICTLSConfig(serverName string, sni bool, alpn []string, insecure bool, pinSHA256 string) *quicTLSConfig {
+	cfg := &tls.Config{ServerName: serverName, NextProtos: alpn}
+	switch {
+	case pinSHA256 != "":
+		cfg.InsecureSkipVerify = true //nolint:gosec // Verified by the certificate hash.
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) > 0 {
+				if sum := sha256.Sum256(rawCerts[0]); hex.EncodeToString(sum[:]) == pinSHA256 {
+					return nil
+				}
+			}
+			return errors.New("server certificate does not match pinSHA256")
+		}
+	case insecure:
+		cfg.InsecureSkipVerify = true //nolint:gosec // Explicitly requested by the link or Config.
+	case !sni:
+		cfg.InsecureSkipVerify = true //nolint:gosec // Verified without the name in ClientHello.
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyServerCert(rawCerts, serverName)
+		}
+	}
+	if !sni {
+		cfg.ServerName = ""
+	}
+
+	return &quicTLSConfig{config: cfg}
+}
+
+// verifyServerCert verifies certificate chain rawCerts of server name with the system roots.
+func verifyServerCert(rawCerts [][]byte, name string) error {
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("parse server certificate: %w", err)
+		}
+		certs[i] = cert
+	}
+	if len(certs) == 0 {
+		return errors.New("no server certificate")
+	}
+	opts := x509.VerifyOptions{DNSName: name, Intermediates: x509.NewCertPool()}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+
+	return err
+}
+
+// ServerName implements aTLS.Config.
+func (c *quicTLSConfig) ServerName() string { return c.config.ServerName }
+
+// SetServerName implements aTLS.Config.
+func (c *quicTLSConfig) SetServerName(serverName string) { c.config.ServerName = serverName }
+
+// NextProtos implements aTLS.Config.
+func (c *quicTLSConfig) NextProtos() []string { return c.config.NextProtos }
+
+// SetNextProtos implements aTLS.Config.
+func (c *quicTLSConfig) SetNextProtos(nextProto []string) { c.config.NextProtos = nextProto }
+
+// Config implements aTLS.Config.
+func (c *quicTLSConfig) Config() (*tls.Config, error) { return c.config, nil }
+
+// Client implements aTLS.Config.
+func (c *quicTLSConfig) Client(conn net.Conn) (aTLS.Conn, error) {
+	return tls.Client(conn, c.config), nil
+}
+
+// Clone implements aTLS.Config.
+func (c *quicTLSConfig) Clone() aTLS.Config { return &quicTLSConfig{config: c.config.Clone()} }
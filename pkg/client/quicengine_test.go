@@ -0,0 +1,131 @@
+//go:build !goxray_nohysteria2 || !goxray_notuic
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+	aTLS "github.com/sagernet/sing/common/tls"
+	"github.com/stretchr/testify/require"
+)
+
+// testQUICServerTLS is sing server TLS config of test QUIC servers.
+type testQUICServerTLS struct {
+	*quicTLSConfig
+}
+
+func (testQUICServerTLS) Start() error { return nil }
+func (testQUICServerTLS) Close() error { return nil }
+
+func (c testQUICServerTLS) Server(conn net.Conn) (aTLS.Conn, error) {
+	return tls.Server(conn, c.config), nil
+}
+
+// testQUICHandler connects TCP streams of test QUIC servers to their destinations and echoes UDP datagrams.
+type testQUICHandler struct{}
+
+func (testQUICHandler) NewConnectionEx(_ context.Context, conn net.Conn, _, dst M.Socksaddr, _ N.CloseHandlerFunc) {
+	defer conn.Close()
+	remote, err := net.Dial("tcp", dst.String())
+	if err != nil {
+		return
+	}
+	defer remote.Close()
+	go func() { _, _ = io.Copy(remote, conn) }()
+	_, _ = io.Copy(conn, remote)
+}
+
+func (testQUICHandler) NewPacketConnectionEx(_ context.Context, conn N.PacketConn, _, _ M.Socksaddr, _ N.CloseHandlerFunc) {
+	defer conn.Close()
+	for {
+		b := buf.NewPacket()
+		dst, err := conn.ReadPacket(b)
+		if err != nil {
+			b.Release()
+			return
+		}
+		if err = conn.WritePacket(b, dst); err != nil {
+			return
+		}
+	}
+}
+
+// listenTestQUIC returns UDP socket and TLS config with self-signed certificate cert for test QUIC server.
+func listenTestQUIC(t *testing.T, cert tls.Certificate) (net.PacketConn, aTLS.ServerConfig) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+
+	return pc, testQUICServerTLS{&quicTLSConfig{config: &tls.Config{Certificates: []tls.Certificate{cert}}}}
+}
+
+// checkTestQUICEngine checks that eng relays TCP connections and UDP datagrams of its inbound, it fails the test
+// if ok and the server can not be reached through eng, or if it can but not ok.
+func checkTestQUICEngine(t *testing.T, eng Engine, inbound Proxy, ok bool) {
+	require.NoError(t, eng.Start())
+	defer func() { require.NoError(t, eng.Close()) }()
+
+	echo := startTestEchoServer(t)
+	conn, err := net.Dial("tcp", inbound.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte{0x05, 0x01, 0x00})
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 2))
+	require.NoError(t, err)
+	dst := M.ParseSocksaddr(echo)
+	_, err = conn.Write(append([]byte{0x05, 0x01, 0x00, 0x01}, append(dst.Addr.AsSlice(), byte(dst.Port>>8), byte(dst.Port))...))
+	require.NoError(t, err)
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	if !ok && reply[1] != 0 {
+		return
+	}
+	require.Zero(t, reply[1], "socks connect failed")
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	b := make([]byte, 4)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.ReadFull(conn, b)
+	if !ok {
+		// The stream may be opened before the server authenticates the client, it is closed then.
+		require.ErrorIs(t, err, io.EOF, "server must not be reached")
+		return
+	}
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(b))
+
+	ctrl, err := net.Dial("tcp", inbound.String())
+	require.NoError(t, err)
+	defer ctrl.Close()
+	relay, err := socksUDPAssociate(ctrl, 5*time.Second)
+	require.NoError(t, err)
+	pc, err := net.DialUDP("udp", nil, relay)
+	require.NoError(t, err)
+	defer pc.Close()
+	target := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 9), Port: 53}
+	// Datagrams are not retransmitted, the first ones may be lost while the association is being established.
+	b = make([]byte, 1024)
+	var n int
+	require.Eventually(t, func() bool {
+		if _, err := pc.Write(append(appendSOCKSUDPHeader(nil, target), "ping"...)); err != nil {
+			return false
+		}
+		_ = pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err = pc.Read(b)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	payload, src, valid := parseSOCKSUDP(b[:n], nil)
+	require.True(t, valid)
+	require.Equal(t, "ping", string(payload), "echoed by the server")
+	require.Equal(t, target.String(), src.String())
+}
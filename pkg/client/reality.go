@@ -0,0 +1,146 @@
+package client
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/transport/internet/reality"
+	xtls "github.com/xtls/xray-core/transport/internet/tls"
+)
+
+var (
+	// ErrNotReality is returned by Client.VerifyReality for links without REALITY security.
+	ErrNotReality = errors.New("not a REALITY link")
+	// ErrRealityAuth is returned by Client.VerifyReality if the server did not accept the client as REALITY one,
+	// it got the certificate of the camouflage site instead.
+	ErrRealityAuth = errors.New("REALITY authentication failed")
+)
+
+// verifyTimeout limits each step of Client.VerifyReality.
+const verifyTimeout = 10 * time.Second
+
+// validateReality checks REALITY settings of share link parameters, XRay core reports them at start otherwise.
+func validateReality(r *conf.REALITYConfig) error {
+	key := cmp.Or(r.Password, r.PublicKey)
+	if key == "" {
+		return errors.New("public key (pbk) is required")
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(key); err != nil || len(b) != 32 {
+		return fmt.Errorf("invalid public key (pbk) %q: 32 bytes in URL-safe base64 without padding expected", key)
+	}
+	if _, err := hex.DecodeString(r.ShortId); err != nil || len(r.ShortId) > 16 {
+		return fmt.Errorf("invalid short id (sid) %q: up to 16 hex digits of even length expected", r.ShortId)
+	}
+	if r.SpiderX != "" && !strings.HasPrefix(r.SpiderX, "/") {
+		return fmt.Errorf("invalid spider path (spx) %q: must start with \"/\"", r.SpiderX)
+	}
+	if fp := strings.ToLower(r.Fingerprint); fp == "unsafe" || fp == "hellogolang" || xtls.GetFingerprint(fp) == nil {
+		return fmt.Errorf("invalid fingerprint (fp) %q", r.Fingerprint)
+	}
+
+	return nil
+}
+
+// VerifyReality checks the server of REALITY link step by step without connecting the tunnel: parameters of
+// the link, TCP connection to the server, TLS handshake with the camouflage site (sni) through the server and
+// the REALITY handshake. The error tells which step failed and the link parameters to check.
+func (c *Client) VerifyReality(ctx context.Context, link string) error {
+	spec, err := c.parseLink(link)
+	if err != nil {
+		return err
+	}
+	if spec.xray == nil {
+		return ErrNotReality
+	}
+	stream := spec.xray.OutboundConfigs[0].StreamSetting
+	if stream == nil || stream.Security != "reality" || stream.REALITYSettings == nil {
+		return ErrNotReality
+	}
+	built, err := stream.REALITYSettings.Build()
+	if err != nil {
+		return fmt.Errorf("reality: %w", err)
+	}
+	cfg := built.(*reality.Config)
+
+	ip, _, err := c.resolveServer(spec.general.Address, spec.general.Port)
+	if err != nil {
+		return fmt.Errorf("server address not resolvable: %w", err)
+	}
+	port, err := strconv.Atoi(spec.general.Port)
+	if err != nil {
+		return fmt.Errorf("invalid server port %q", spec.general.Port)
+	}
+	addr := net.JoinHostPort(ip.String(), spec.general.Port)
+	serverName := cmp.Or(cfg.ServerName, spec.general.Address)
+
+	// The server passes clients failing REALITY authentication to the camouflage site, a plain TLS handshake
+	// tells whether the site serves the server name.
+	conn, err := c.verifyDial(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("server %s unreachable, check address and port: %w", addr, err)
+	}
+	hsCtx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+	tc := tls.Client(conn, &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS13, InsecureSkipVerify: c.cfg.TLSAllowInsecure})
+	err = tc.HandshakeContext(hsCtx)
+	_ = conn.Close()
+	if err != nil {
+		return fmt.Errorf("camouflage site of the server does not serve TLS 1.3 for server name (sni) %q, "+
+			"check sni and the server target: %w", serverName, err)
+	}
+
+	if conn, err = c.verifyDial(ctx, addr); err != nil {
+		return fmt.Errorf("server %s unreachable, check address and port: %w", addr, err)
+	}
+	defer conn.Close()
+	hsCtx, cancel = context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+	rc, err := reality.UClient(conn, cfg, hsCtx, xnet.TCPDestination(xnet.IPAddress(ip), xnet.Port(port)))
+	if err != nil {
+		if realityAuthFailed(err) {
+			return fmt.Errorf("%w: check public key (pbk) and short id (sid), server name (sni) %q must be in "+
+				"serverNames of the server and the clocks must be in sync", ErrRealityAuth, serverName)
+		}
+		return fmt.Errorf("reality handshake: %w", err)
+	}
+	_ = rc.Close()
+	c.log(LogXRay).Debug("REALITY handshake succeeded", "server", addr, "sni", serverName)
+
+	return nil
+}
+
+// realityAuthFailed reports whether REALITY handshake err means the server passed the client to the camouflage site:
+// its certificate is either trusted (the connection is processed as invalid) or not valid for the server name.
+func realityAuthFailed(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+	)
+
+	return strings.Contains(err.Error(), "processed invalid connection") ||
+		errors.As(err, &unknownAuthority) || errors.As(err, &hostname)
+}
+
+// verifyDial connects to the server with Config.Dialer or the system dialer.
+func (c *Client) verifyDial(ctx context.Context, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+	dial := c.cfg.Dialer
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	return dial(ctx, "tcp", addr)
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/reality"
+)
+
+const testRealityKey = "jY_Lrg226NLJ2tGbLyEAaznQUTJfvoVSchob3DmyCj8"
+
+func TestValidateReality(t *testing.T) {
+	link := "vless://b831381d-6324-4d53-ad4f-8cda48b30811@127.0.0.6:443?type=tcp&security=reality&sni=example.com"
+	cl := newTestClient(nil, nil, nil, nil, nil)
+
+	_, err := cl.parseLink(link + "&pbk=" + testRealityKey + "&sid=ab12&spx=%2Fsearch&fp=chrome")
+	require.NoError(t, err)
+
+	for params, want := range map[string]string{
+		"":                                    "public key (pbk) is required",
+		"&pbk=key":                            "invalid public key (pbk)",
+		"&pbk=" + testRealityKey + "=":        "invalid public key (pbk)",
+		"&pbk=" + testRealityKey + "&sid=abc": "invalid short id (sid)",
+		"&pbk=" + testRealityKey + "&sid=0123456789abcdef01": "invalid short id (sid)",
+		"&pbk=" + testRealityKey + "&sid=zz":                 "invalid short id (sid)",
+		"&pbk=" + testRealityKey + "&spx=search":             "invalid spider path (spx)",
+	} {
+		_, err = cl.parseLink(link + params)
+		require.ErrorContains(t, err, "invalid config: reality: "+want, params)
+	}
+	_, err = cl.parseLink(link + "&pbk=" + testRealityKey + "&fp=netscape")
+	require.ErrorContains(t, err, "invalid fingerprint (fp)")
+}
+
+// startTestReality starts REALITY server with the camouflage site serving sni, it returns the server
+// address and the link parameters of its public key and short id.
+func startTestReality(t *testing.T, sni string) (addr, params string) {
+	t.Helper()
+
+	site, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{newTestCert(t, sni, sni)}})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = site.Close() })
+	go func() {
+		for {
+			conn, err := site.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*tls.Conn).Handshake()
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ln := reality.NewListener(inner, &reality.Config{
+		DialContext:            (&net.Dialer{}).DialContext,
+		Type:                   "tcp",
+		Dest:                   site.Addr().String(),
+		ServerNames:            map[string]bool{sni: true},
+		PrivateKey:             key.Bytes(),
+		ShortIds:               map[[8]byte]bool{{0xab, 0x12}: true},
+		SessionTicketsDisabled: true,
+	})
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return inner.Addr().String(), fmt.Sprintf("pbk=%s&sid=ab12", base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()))
+}
+
+func TestClient_VerifyReality(t *testing.T) {
+	addr, params := startTestReality(t, "camouflage.example.com")
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.TLSAllowInsecure = true // The camouflage site certificate is self-signed.
+	link := "vless://b831381d-6324-4d53-ad4f-8cda48b30811@" + addr + "?type=tcp&security=reality&fp=chrome&"
+
+	require.NoError(t, cl.VerifyReality(t.Context(), link+params+"&sni=camouflage.example.com"))
+
+	err := cl.VerifyReality(t.Context(), link+"pbk="+testRealityKey+"&sid=ab12&sni=camouflage.example.com")
+	require.ErrorIs(t, err, ErrRealityAuth, "wrong public key")
+	require.ErrorContains(t, err, "check public key (pbk) and short id (sid)")
+	err = cl.VerifyReality(t.Context(), link+params[:len(params)-2]+"34&sni=camouflage.example.com")
+	require.ErrorIs(t, err, ErrRealityAuth, "wrong short id")
+	err = cl.VerifyReality(t.Context(), link+params+"&sni=other.example.com")
+	require.ErrorContains(t, err, `server name (sni) "other.example.com"`)
+
+	require.ErrorIs(t, cl.VerifyReality(t.Context(), "trojan://pass@"+addr+"?security=tls"), ErrNotReality)
+	require.ErrorContains(t, cl.VerifyReality(t.Context(), "vless://b831381d-6324-4d53-ad4f-8cda48b30811@127.0.0.1:1?type=tcp&security=reality&"+
+		params+"&sni=camouflage.example.com"), "unreachable, check address and port")
+}
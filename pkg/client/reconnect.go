@@ -0,0 +1,141 @@
+package client
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReconnectMinBackoff is the delay of the attempt after the first failure, see ReconnectOptions.
+	DefaultReconnectMinBackoff = time.Second
+	// DefaultReconnectMaxBackoff limits the delay between attempts, see ReconnectOptions.
+	DefaultReconnectMaxBackoff = time.Minute
+	// DefaultReconnectFailures are consecutive failures starting the cool-down, see ReconnectOptions.
+	DefaultReconnectFailures = 5
+	// DefaultReconnectCoolDown is the delay of the attempt in cool-down, see ReconnectOptions.
+	DefaultReconnectCoolDown = 5 * time.Minute
+)
+
+// ReconnectOptions limit automatic connection attempts, the retries of Config.OnDemand on the next packet.
+//
+// After a failure the next attempt waits a jittered backoff doubling from MinBackoff up to MaxBackoff, packets
+// arriving meanwhile are dropped. After Failures consecutive failures the circuit breaker opens: attempts wait
+// CoolDown (EventReconnectCoolDown is emitted) until one succeeds, so a dead server is not hammered.
+type ReconnectOptions struct {
+	// MinBackoff is the delay of the attempt after the first failure (default: DefaultReconnectMinBackoff).
+	MinBackoff time.Duration
+	// MaxBackoff limits the delay between attempts before the cool-down (default: DefaultReconnectMaxBackoff).
+	MaxBackoff time.Duration
+	// Failures are consecutive failures starting the cool-down (default: DefaultReconnectFailures).
+	Failures int
+	// CoolDown is the delay of attempts in cool-down (default: DefaultReconnectCoolDown).
+	CoolDown time.Duration
+}
+
+// Validate checks options values.
+func (o *ReconnectOptions) Validate() error {
+	if o.MinBackoff < 0 || o.MaxBackoff < 0 {
+		return errors.New("backoff must not be negative")
+	}
+	if o.MaxBackoff > 0 && o.minBackoff() > o.MaxBackoff {
+		return errors.New("min backoff must not exceed max backoff")
+	}
+	if o.Failures < 0 {
+		return errors.New("failures must not be negative")
+	}
+	if o.CoolDown < 0 {
+		return errors.New("cool-down must not be negative")
+	}
+
+	return nil
+}
+
+func (o *ReconnectOptions) minBackoff() time.Duration {
+	if o == nil || o.MinBackoff == 0 {
+		return DefaultReconnectMinBackoff
+	}
+
+	return o.MinBackoff
+}
+
+func (o *ReconnectOptions) maxBackoff() time.Duration {
+	if o == nil || o.MaxBackoff == 0 {
+		return max(DefaultReconnectMaxBackoff, o.minBackoff())
+	}
+
+	return o.MaxBackoff
+}
+
+func (o *ReconnectOptions) failures() int {
+	if o == nil || o.Failures == 0 {
+		return DefaultReconnectFailures
+	}
+
+	return o.Failures
+}
+
+func (o *ReconnectOptions) coolDown() time.Duration {
+	if o == nil || o.CoolDown == 0 {
+		return DefaultReconnectCoolDown
+	}
+
+	return o.CoolDown
+}
+
+// ReconnectBackoff delays connection attempts after failures, see ReconnectOptions. The client uses it for
+// Config.OnDemand, connections managed outside the client (e.g. by a daemon) can use it too.
+// The zero value uses defaults.
+type ReconnectBackoff struct {
+	mu       sync.Mutex
+	opts     *ReconnectOptions
+	failures int
+	retryAt  time.Time
+}
+
+// SetOptions sets options and resets the failures.
+func (b *ReconnectBackoff) SetOptions(opts *ReconnectOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.opts, b.failures, b.retryAt = opts, 0, time.Time{}
+}
+
+// Allowed reports whether an attempt may be made at now, b may be nil.
+func (b *ReconnectBackoff) Allowed(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !now.Before(b.retryAt)
+}
+
+// Failed records failed attempt at now, it returns the delay of the next one, the consecutive failures and
+// whether the cool-down started.
+func (b *ReconnectBackoff) Failed(now time.Time) (delay time.Duration, failures int, coolDown bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= b.opts.failures() {
+		delay, coolDown = b.opts.coolDown(), true
+	} else {
+		// Doubling from the minimum, with jitter in its upper half so clients failing together spread out.
+		d := b.opts.minBackoff() << min(b.failures-1, 30)
+		if d <= 0 || d > b.opts.maxBackoff() {
+			d = b.opts.maxBackoff()
+		}
+		delay = d/2 + rand.N(d/2+1)
+	}
+	b.retryAt = now.Add(delay)
+
+	return delay, b.failures, coolDown
+}
+
+// Succeeded resets the failures.
+func (b *ReconnectBackoff) Succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.retryAt = 0, time.Time{}
+}
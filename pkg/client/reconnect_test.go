@@ -0,0 +1,49 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectOptions_Validate(t *testing.T) {
+	require.NoError(t, (&ReconnectOptions{}).Validate())
+	require.NoError(t, (&ReconnectOptions{MinBackoff: time.Second, MaxBackoff: time.Second, Failures: 1, CoolDown: time.Hour}).Validate())
+	require.NoError(t, (&ReconnectOptions{MinBackoff: 2 * time.Minute}).Validate(), "max backoff follows min backoff")
+	require.ErrorContains(t, (&ReconnectOptions{MinBackoff: -1}).Validate(), "backoff must not be negative")
+	require.ErrorContains(t, (&ReconnectOptions{MaxBackoff: time.Millisecond}).Validate(), "min backoff must not exceed max backoff")
+	require.ErrorContains(t, (&ReconnectOptions{Failures: -1}).Validate(), "failures must not be negative")
+	require.ErrorContains(t, (&ReconnectOptions{CoolDown: -1}).Validate(), "cool-down must not be negative")
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	require.True(t, (*ReconnectBackoff)(nil).Allowed(time.Now()))
+
+	var b ReconnectBackoff
+	b.SetOptions(&ReconnectOptions{MinBackoff: time.Second, MaxBackoff: 4 * time.Second, Failures: 5, CoolDown: time.Hour})
+	now := time.Now()
+	require.True(t, b.Allowed(now))
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		delay, failures, coolDown := b.Failed(now)
+		require.Equal(t, i+1, failures)
+		require.False(t, coolDown)
+		require.GreaterOrEqual(t, delay, want/2, "jitter in the upper half")
+		require.LessOrEqual(t, delay, want)
+		require.False(t, b.Allowed(now.Add(delay-time.Nanosecond)))
+		require.True(t, b.Allowed(now.Add(delay)))
+	}
+
+	delay, failures, coolDown := b.Failed(now)
+	require.Equal(t, 5, failures)
+	require.True(t, coolDown, "circuit breaker opened")
+	require.Equal(t, time.Hour, delay)
+	_, _, coolDown = b.Failed(now.Add(time.Hour))
+	require.True(t, coolDown, "still open after the next failure")
+
+	b.Succeeded()
+	require.True(t, b.Allowed(now))
+	delay, failures, _ = b.Failed(now)
+	require.Equal(t, 1, failures)
+	require.LessOrEqual(t, delay, time.Second)
+}
@@ -0,0 +1,346 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DomainStrategy is how the server host name is resolved and connected to, named after XRay "domainStrategy".
+type DomainStrategy string
+
+const (
+	// DomainStrategyAsIs leaves the host name in XRay outbound, XRay core resolves it with the system resolver on
+	// each connection. The address may differ from the one routed around the tunnel, e.g. with DNS round robin.
+	DomainStrategyAsIs DomainStrategy = "AsIs"
+	// DomainStrategyUseIP connects to the resolved address of any family, it replaces the host name in XRay outbound.
+	DomainStrategyUseIP DomainStrategy = "UseIP"
+	// DomainStrategyUseIPv4 resolves and connects to IPv4 address only.
+	DomainStrategyUseIPv4 DomainStrategy = "UseIPv4"
+	// DomainStrategyUseIPv6 resolves and connects to IPv6 address only.
+	DomainStrategyUseIPv6 DomainStrategy = "UseIPv6"
+)
+
+// ResolverOptions control resolving of the server host name, which is done before the tunnel exists.
+type ResolverOptions struct {
+	// DomainStrategy of the server host name (default: any family, XRay core connects to the family of
+	// the resolved address).
+	DomainStrategy DomainStrategy
+	// Bootstrap are DNS servers resolving the server host name instead of the system resolver, e.g. on networks
+	// with poisoned DNS, tried in order: "IP[:port]" over UDP, "tcp://IP[:port]" or DNS over HTTPS URL with IP host,
+	// e.g. "https://1.1.1.1/dns-query". The resolved address replaces the host name in XRay outbound.
+	Bootstrap []string
+}
+
+// Validate checks options values.
+func (o *ResolverOptions) Validate() error {
+	switch o.DomainStrategy {
+	case "", DomainStrategyAsIs, DomainStrategyUseIP, DomainStrategyUseIPv4, DomainStrategyUseIPv6:
+	default:
+		return fmt.Errorf("unknown domain strategy %q", o.DomainStrategy)
+	}
+	if o.DomainStrategy == DomainStrategyAsIs && len(o.Bootstrap) > 0 {
+		return errors.New("bootstrap dns is not used with AsIs domain strategy, XRay core resolves the host itself")
+	}
+	for _, s := range o.Bootstrap {
+		if _, err := parseDNSServer(s); err != nil {
+			return fmt.Errorf("bootstrap dns %q: %w", s, err)
+		}
+	}
+
+	return nil
+}
+
+// strategy returns DomainStrategy, empty for nil options.
+func (o *ResolverOptions) strategy() DomainStrategy {
+	if o == nil {
+		return ""
+	}
+
+	return o.DomainStrategy
+}
+
+// pinIP reports whether the resolved server address replaces the host name in XRay outbound.
+func (o *ResolverOptions) pinIP() bool {
+	return o != nil && (o.DomainStrategy == DomainStrategyUseIP || len(o.Bootstrap) > 0)
+}
+
+// lookupIP resolves host into addresses of the Config.Resolver domain strategy family.
+func (c *Client) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	network := "ip"
+	switch c.cfg.Resolver.strategy() {
+	case DomainStrategyUseIPv4:
+		network = "ip4"
+	case DomainStrategyUseIPv6:
+		network = "ip6"
+	}
+	if c.cfg.Resolver == nil || len(c.cfg.Resolver.Bootstrap) == 0 {
+		return net.DefaultResolver.LookupIP(ctx, network, host)
+	}
+
+	var errs []error
+	for _, s := range c.cfg.Resolver.Bootstrap {
+		srv, _ := parseDNSServer(s)
+		ips, err := c.lookupDNS(ctx, srv, network, host)
+		if err == nil {
+			return ips, nil
+		}
+		errs = append(errs, fmt.Errorf("bootstrap dns %s: %w", s, err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// serverDial returns Config.Dialer resolving server host names with Config.Resolver for Engine, which dials
+// the server by itself.
+func (c *Client) serverDial() DialFunc {
+	if c.cfg.Resolver == nil || c.cfg.Resolver.DomainStrategy == DomainStrategyAsIs {
+		return c.cfg.Dialer
+	}
+	dial := c.cfg.Dialer
+	if dial == nil {
+		dial = c.directDial
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, address)
+		}
+		racePort := port
+		if !strings.HasPrefix(network, "tcp") {
+			racePort = "" // Connection race is TCP only.
+		}
+		ip, err := c.lookupServer(ctx, host, racePort)
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", host, err)
+		}
+
+		return dial(ctx, network, net.JoinHostPort(ip.String(), port))
+	}
+}
+
+// dnsServer is parsed ResolverOptions.Bootstrap server.
+type dnsServer struct {
+	network string // "udp", "tcp" or "https".
+	addr    string // "IP:port" or DNS over HTTPS URL.
+}
+
+func parseDNSServer(s string) (dnsServer, error) {
+	if strings.HasPrefix(s, "https://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return dnsServer{}, err
+		}
+		if net.ParseIP(u.Hostname()) == nil {
+			return dnsServer{}, errors.New("IP address host expected, there is no resolver for the name yet")
+		}
+
+		return dnsServer{network: "https", addr: s}, nil
+	}
+
+	network := "udp"
+	if rest, ok := strings.CutPrefix(s, "tcp://"); ok {
+		network, s = "tcp", rest
+	} else if rest, ok = strings.CutPrefix(s, "udp://"); ok {
+		s = rest
+	}
+	host, port := strings.Trim(s, "[]"), "53"
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		host, port = h, p
+	}
+	if net.ParseIP(host) == nil {
+		return dnsServer{}, errors.New("IP address expected")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return dnsServer{}, fmt.Errorf("invalid port %q", port)
+	}
+
+	return dnsServer{network: network, addr: net.JoinHostPort(host, port)}, nil
+}
+
+// lookupDNS queries srv for A and AAAA records of host as network "ip", "ip4" or "ip6" requires.
+func (c *Client) lookupDNS(ctx context.Context, srv dnsServer, network, host string) ([]net.IP, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	var types []dnsmessage.Type
+	if network != "ip6" {
+		types = append(types, dnsmessage.TypeA)
+	}
+	if network != "ip4" {
+		types = append(types, dnsmessage.TypeAAAA)
+	}
+
+	var ips []net.IP
+	for _, typ := range types {
+		found, err := c.queryDNS(ctx, srv, dnsmessage.Question{Name: name, Type: typ, Class: dnsmessage.ClassINET})
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, found...)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no %s address of %s", network, host)
+	}
+
+	return ips, nil
+}
+
+// queryDNS sends question to srv and returns the addresses of the answer, truncated UDP answer is retried over TCP.
+func (c *Client) queryDNS(ctx context.Context, srv dnsServer, q dnsmessage.Question) ([]net.IP, error) {
+	var id uint16
+	if srv.network != "https" { // DNS over HTTPS uses 0 for HTTP caching (RFC 8484).
+		var b [2]byte
+		_, _ = rand.Read(b[:])
+		id = binary.BigEndian.Uint16(b[:])
+	}
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{q},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.exchangeDNS(ctx, srv, query)
+	if err != nil {
+		return nil, err
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return nil, fmt.Errorf("parse answer: %w", err)
+	}
+	switch {
+	case h.ID != id:
+		return nil, errors.New("answer id mismatch")
+	case h.Truncated && srv.network == "udp":
+		return c.queryDNS(ctx, dnsServer{network: "tcp", addr: srv.addr}, q)
+	case h.RCode == dnsmessage.RCodeNameError:
+		return nil, nil // The other record type may exist.
+	case h.RCode != dnsmessage.RCodeSuccess:
+		return nil, fmt.Errorf("answer %s", h.RCode)
+	}
+	if err = p.SkipAllQuestions(); err != nil {
+		return nil, fmt.Errorf("parse answer: %w", err)
+	}
+
+	var ips []net.IP
+	for {
+		ah, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			return ips, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse answer: %w", err)
+		}
+		switch {
+		case ah.Type == dnsmessage.TypeA && q.Type == dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, fmt.Errorf("parse answer: %w", err)
+			}
+			ips = append(ips, net.IP(r.A[:]))
+		case ah.Type == dnsmessage.TypeAAAA && q.Type == dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, fmt.Errorf("parse answer: %w", err)
+			}
+			ips = append(ips, net.IP(r.AAAA[:]))
+		default: // CNAME chain of the recursive resolver.
+			if err = p.SkipAnswer(); err != nil {
+				return nil, fmt.Errorf("parse answer: %w", err)
+			}
+		}
+	}
+}
+
+// exchangeDNS sends packed query to srv and returns the packed answer. TCP and HTTPS connections are made with
+// Config.Dialer, UDP and the connections without Config.Dialer go direct, see ControlRoute.
+func (c *Client) exchangeDNS(ctx context.Context, srv dnsServer, query []byte) ([]byte, error) {
+	dial := c.cfg.Dialer
+	if dial == nil {
+		dial = c.directDial
+	}
+
+	if srv.network == "https" {
+		client := &http.Client{Transport: &http.Transport{
+			DialContext:     dial,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: c.cfg.TLSAllowInsecure},
+		}}
+		defer client.CloseIdleConnections()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.addr, bytes.NewReader(query))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+
+		return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	}
+
+	var conn net.Conn
+	var err error
+	if srv.network == "udp" {
+		conn, err = c.directDial(ctx, "udp", srv.addr)
+	} else {
+		conn, err = dial(ctx, "tcp", srv.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if srv.network == "udp" {
+		if _, err = conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 64<<10)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		return buf[:n], nil
+	}
+
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err = conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err = io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err = io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
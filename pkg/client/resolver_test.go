@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testDNSAnswer answers query with the A and AAAA records of names, unknown names are NXDOMAIN.
+func testDNSAnswer(t *testing.T, query []byte, names map[string][]net.IP) []byte {
+	t.Helper()
+
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	require.NoError(t, err)
+	q, err := p.Question()
+	require.NoError(t, err)
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true})
+	ips, ok := names[strings.TrimSuffix(q.Name.String(), ".")]
+	if !ok {
+		b = dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RCode: dnsmessage.RCodeNameError})
+	}
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(q))
+	require.NoError(t, b.StartAnswers())
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60}
+	for _, ip := range ips {
+		switch {
+		case q.Type == dnsmessage.TypeA && ip.To4() != nil:
+			require.NoError(t, b.AResource(rh, dnsmessage.AResource{A: [4]byte(ip.To4())}))
+		case q.Type == dnsmessage.TypeAAAA && ip.To4() == nil:
+			require.NoError(t, b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())}))
+		}
+	}
+	resp, err := b.Finish()
+	require.NoError(t, err)
+
+	return resp
+}
+
+// startTestDNS starts DNS server over UDP and TCP on the same port answering with names, it returns the address.
+func startTestDNS(t *testing.T, names map[string][]net.IP) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(testDNSAnswer(t, buf[:n], names), addr)
+		}
+	}()
+
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var size [2]byte
+			if _, err = io.ReadFull(conn, size[:]); err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err = io.ReadFull(conn, query); err == nil {
+					resp := testDNSAnswer(t, query, names)
+					_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+				}
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return pc.LocalAddr().String()
+}
+
+var testDNSNames = map[string][]net.IP{"server.example.com": {net.ParseIP("203.0.113.7").To4(), net.ParseIP("2001:db8::7")}}
+
+func TestResolverOptions_Validate(t *testing.T) {
+	for _, o := range []ResolverOptions{
+		{},
+		{DomainStrategy: DomainStrategyAsIs},
+		{DomainStrategy: DomainStrategyUseIPv4, Bootstrap: []string{"1.1.1.1", "udp://9.9.9.9:53", "tcp://[2606:4700::1111]:53",
+			"[2606:4700::1111]", "https://1.1.1.1/dns-query"}},
+	} {
+		require.NoError(t, o.Validate(), o)
+	}
+
+	for _, o := range []ResolverOptions{
+		{DomainStrategy: "UseIPv4v6"},
+		{DomainStrategy: DomainStrategyAsIs, Bootstrap: []string{"1.1.1.1"}},
+		{Bootstrap: []string{"dns.google"}},
+		{Bootstrap: []string{"https://dns.google/dns-query"}},
+		{Bootstrap: []string{"1.1.1.1:0"}},
+		{Bootstrap: []string{"tcp://1.1.1.1:dns"}},
+	} {
+		require.Error(t, o.Validate(), o)
+	}
+}
+
+func TestClient_lookupIP(t *testing.T) {
+	addr := startTestDNS(t, testDNSNames)
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(testDNSAnswer(t, query, testDNSNames))
+	}))
+	t.Cleanup(doh.Close)
+
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.TLSAllowInsecure = true // Self-signed certificate of the DNS over HTTPS server.
+	for _, srv := range []string{addr, "udp://" + addr, "tcp://" + addr, doh.URL + "/dns-query"} {
+		cl.cfg.Resolver = &ResolverOptions{Bootstrap: []string{srv}}
+		ips, err := cl.lookupIP(t.Context(), "server.example.com")
+		require.NoError(t, err, srv)
+		require.Equal(t, []net.IP{net.ParseIP("203.0.113.7").To4(), net.ParseIP("2001:db8::7")}, ips, srv)
+	}
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+	cl.cfg.Resolver = &ResolverOptions{DomainStrategy: DomainStrategyUseIPv6, Bootstrap: []string{"tcp://" + closed.Addr().String(), addr}}
+	ips, err := cl.lookupIP(t.Context(), "server.example.com")
+	require.NoError(t, err, "the next server is tried")
+	require.Equal(t, []net.IP{net.ParseIP("2001:db8::7")}, ips)
+
+	cl.cfg.Resolver.DomainStrategy = DomainStrategyUseIPv4
+	ip, err := cl.lookupServer(t.Context(), "server.example.com", "443")
+	require.NoError(t, err)
+	require.Equal(t, "203.0.113.7", ip.String())
+
+	_, err = cl.lookupIP(t.Context(), "unknown.example.com")
+	require.ErrorContains(t, err, "no ip4 address of unknown.example.com")
+}
+
+func TestClient_createProxy_Resolver(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.Resolver = &ResolverOptions{DomainStrategy: DomainStrategyUseIPv4, Bootstrap: []string{startTestDNS(t, testDNSNames)}}
+
+	_, _, err := cl.createProxy("trojan://pass@server.example.com:443?security=tls")
+	require.NoError(t, err)
+	require.Equal(t, "203.0.113.7", cl.xSrvIP.String())
+	out := cl.xJSON.OutboundConfigs[0]
+	require.Contains(t, string(*out.Settings), `"address":"203.0.113.7"`, "XRay core does not resolve the host again")
+	require.Equal(t, "server.example.com", out.StreamSetting.TLSSettings.ServerName)
+
+	cl = newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.Resolver = &ResolverOptions{DomainStrategy: DomainStrategyAsIs}
+	_, _, err = cl.createProxy("trojan://pass@localhost:443?security=tls")
+	require.NoError(t, err)
+	out = cl.xJSON.OutboundConfigs[0]
+	require.Contains(t, string(*out.Settings), `"address":"localhost"`)
+	require.True(t, out.StreamSetting.SocketSettings == nil || out.StreamSetting.SocketSettings.DomainStrategy == "",
+		"XRay core resolves the host itself")
+}
+
+func TestClient_serverDial(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	require.Nil(t, cl.serverDial())
+
+	var dialed []string
+	cl.cfg.Dialer = func(_ context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, network+" "+address)
+		return nil, errors.New("test dialer")
+	}
+	cl.cfg.Resolver = &ResolverOptions{DomainStrategy: DomainStrategyUseIPv6, Bootstrap: []string{startTestDNS(t, testDNSNames)}}
+	dial := cl.serverDial()
+	_, _ = dial(t.Context(), "udp", "server.example.com:443")
+	_, _ = dial(t.Context(), "tcp", "198.51.100.1:22")
+	require.Equal(t, []string{"udp [2001:db8::7]:443", "tcp 198.51.100.1:22"}, dialed)
+}
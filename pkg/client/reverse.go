@@ -0,0 +1,88 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// ReverseForward exposes a local service on the remote server using XRay reverse proxy,
+// e.g. to reach a home machine behind NAT. The Client acts as the reverse proxy bridge,
+// the server must have a portal configured with the same Domain, e.g.:
+//
+//	"reverse": {"portals": [{"tag": "portal", "domain": "home.reverse"}]}
+//
+// Connections accepted by the portal on the server are delivered to Local. Local is dialed
+// from this machine as is, so it should not be routed to TUN (e.g. loopback address).
+type ReverseForward struct {
+	// Domain identifies the reverse connection, it must match the server portal domain (e.g. "home.reverse").
+	Domain string
+	// Local is the host:port of the local service (e.g. "127.0.0.1:22").
+	Local string
+}
+
+// Validate checks reverse forward values.
+func (r ReverseForward) Validate() error {
+	if r.Domain == "" {
+		return errors.New("empty domain")
+	}
+	if _, _, err := net.SplitHostPort(r.Local); err != nil {
+		return fmt.Errorf("invalid local address: %w", err)
+	}
+
+	return nil
+}
+
+// reverseTagPrefix is the prefix of XRay config tags created for reverse forwards.
+const reverseTagPrefix = "reverse-"
+
+// addReverse adds bridges for reverse forwards into XRay config. Each bridge connects to the portal
+// via proxy outbound (using the bridge domain as destination) and delivers portal connections
+// to its own freedom outbound redirected to the local service.
+func addReverse(cfg *conf.Config, proxyTag string, forwards []ReverseForward) error {
+	if len(forwards) == 0 {
+		return nil
+	}
+
+	cfg.Reverse = &conf.ReverseConfig{}
+	if cfg.RouterConfig == nil {
+		cfg.RouterConfig = &conf.RouterConfig{}
+	}
+	domains := make(map[string]bool, len(forwards))
+	for i, r := range forwards {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("reverse %q: %w", r.Domain, err)
+		}
+		if domains[r.Domain] {
+			return fmt.Errorf("reverse %q: duplicate domain", r.Domain)
+		}
+		domains[r.Domain] = true
+
+		bridgeTag := reverseTagPrefix + "bridge-" + strconv.Itoa(i)
+		localTag := reverseTagPrefix + "local-" + strconv.Itoa(i)
+		settings := json.RawMessage(fmt.Sprintf(`{"redirect":%q}`, r.Local))
+
+		cfg.Reverse.Bridges = append(cfg.Reverse.Bridges, conf.BridgeConfig{Tag: bridgeTag, Domain: r.Domain})
+		cfg.OutboundConfigs = append(cfg.OutboundConfigs, conf.OutboundDetourConfig{
+			Protocol: "freedom",
+			Tag:      localTag,
+			Settings: &settings,
+		})
+		for _, rule := range []map[string]any{
+			{"type": "field", "inboundTag": []string{bridgeTag}, "domain": []string{"full:" + r.Domain}, "outboundTag": proxyTag},
+			{"type": "field", "inboundTag": []string{bridgeTag}, "outboundTag": localTag},
+		} {
+			b, err := json.Marshal(rule)
+			if err != nil {
+				return fmt.Errorf("reverse %q: %w", r.Domain, err)
+			}
+			cfg.RouterConfig.RuleList = append(cfg.RouterConfig.RuleList, b)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"sync/atomic"
+)
+
+// packetRing is a fixed-size single-producer single-consumer queue of packet buffers. Buffers are allocated once:
+// the producer swaps its filled buffer with the slot buffer consumed before, so the memory of the queue is
+// bounded and the packet path does not allocate.
+//
+// Positions are atomic, the consumer and producer only block on wake channels when the queue is empty or full.
+type packetRing struct {
+	slots []*[]byte
+	mask  uint64
+	head  atomic.Uint64 // Next slot of the consumer.
+	tail  atomic.Uint64 // Next slot of the producer.
+
+	consumerWaits atomic.Bool
+	producerWaits atomic.Bool
+	readable      chan struct{}
+	writable      chan struct{}
+	closed        atomic.Bool
+}
+
+// newPacketRing returns the ring of size rounded up to a power of two with buffers of mtu bytes.
+func newPacketRing(size, mtu int) *packetRing {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	r := &packetRing{
+		slots:    make([]*[]byte, n),
+		mask:     uint64(n - 1),
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+	for i := range r.slots {
+		buf := make([]byte, mtu)
+		r.slots[i] = &buf
+	}
+
+	return r
+}
+
+// len returns the number of queued packets.
+func (r *packetRing) len() int {
+	h := r.head.Load() // Loaded first, the tail is not behind it.
+	return int(r.tail.Load() - h)
+}
+
+// push queues buf, it returns a free buffer to fill next and true. If the ring is full buf is returned and false.
+func (r *packetRing) push(buf *[]byte) (*[]byte, bool) {
+	t := r.tail.Load()
+	if t-r.head.Load() == uint64(len(r.slots)) {
+		return buf, false
+	}
+	slot := &r.slots[t&r.mask]
+	free := *slot
+	*slot = buf
+	r.tail.Store(t + 1)
+	if r.consumerWaits.Load() {
+		notify(r.readable)
+	}
+
+	return free, true
+}
+
+// waitWritable blocks the producer until the ring is not full, it returns false if done is closed first.
+func (r *packetRing) waitWritable(done <-chan struct{}) bool {
+	for {
+		r.producerWaits.Store(true)
+		if r.len() < len(r.slots) {
+			r.producerWaits.Store(false)
+			return true
+		}
+		select {
+		case <-r.writable:
+		case <-done:
+			r.producerWaits.Store(false)
+			return false
+		}
+	}
+}
+
+// peek blocks the consumer until a packet is queued and returns it, the buffer is owned by the consumer
+// until release. It returns nil if the ring is closed and empty.
+func (r *packetRing) peek() *[]byte {
+	for {
+		h := r.head.Load()
+		if h != r.tail.Load() {
+			return r.slots[h&r.mask]
+		}
+		// Packets pushed before close are seen once closed is.
+		if r.closed.Load() && h == r.tail.Load() {
+			return nil
+		}
+
+		r.consumerWaits.Store(true)
+		if h == r.tail.Load() && !r.closed.Load() {
+			<-r.readable
+		}
+		r.consumerWaits.Store(false)
+	}
+}
+
+// release frees the slot of the packet returned by peek.
+func (r *packetRing) release() {
+	r.head.Add(1)
+	if r.producerWaits.Load() {
+		notify(r.writable)
+	}
+}
+
+// close wakes the consumer, peek returns nil once queued packets are consumed. It is called by the producer.
+func (r *packetRing) close() {
+	r.closed.Store(true)
+	notify(r.readable)
+}
+
+// notify sends a wake up to ch without blocking, a pending wake up is enough.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketRing(t *testing.T) {
+	r := newPacketRing(3, 16)
+	require.Len(t, r.slots, 4, "rounded up to a power of two")
+	buf := &[]byte{1}
+	for i := range 4 {
+		var ok bool
+		pushed := buf
+		buf, ok = r.push(buf)
+		require.True(t, ok, i)
+		require.NotSame(t, pushed, buf)
+		*buf = (*buf)[:1]
+		(*buf)[0] = byte(i + 2)
+	}
+	require.Equal(t, 4, r.len())
+	full, ok := r.push(buf)
+	require.False(t, ok)
+	require.Same(t, buf, full, "the buffer is kept by the producer")
+
+	require.Equal(t, []byte{1}, *r.peek())
+	r.release()
+	require.Equal(t, 3, r.len())
+	_, ok = r.push(buf)
+	require.True(t, ok)
+
+	r.close()
+	var got []byte
+	for b := r.peek(); b != nil; b = r.peek() {
+		got = append(got, (*b)[0])
+		r.release()
+	}
+	require.Equal(t, []byte{2, 3, 4, 5}, got, "queued packets are consumed after close")
+	require.Zero(t, r.len())
+}
+
+func TestPacketRing_concurrent(t *testing.T) {
+	r := newPacketRing(8, 4)
+	const n = 100000
+
+	done := make(chan []uint32)
+	go func() {
+		var got []uint32
+		for b := r.peek(); b != nil; b = r.peek() {
+			got = append(got, binary.BigEndian.Uint32(*b))
+			r.release()
+		}
+		done <- got
+	}()
+
+	buf := &[]byte{0, 0, 0, 0}
+	for i := range uint32(n) {
+		binary.BigEndian.PutUint32(*buf, i)
+		next, ok := r.push(buf)
+		for !ok {
+			require.True(t, r.waitWritable(nil))
+			next, ok = r.push(buf)
+		}
+		buf = next
+	}
+	r.close()
+
+	got := <-done
+	require.Len(t, got, n)
+	for i, v := range got {
+		require.Equal(t, uint32(i), v)
+	}
+}
+
+func TestPacketRing_waitWritable(t *testing.T) {
+	r := newPacketRing(1, 4)
+	_, ok := r.push(&[]byte{0})
+	require.True(t, ok)
+
+	done := make(chan struct{})
+	close(done)
+	require.False(t, r.waitWritable(done), "done while the ring is full")
+
+	r.peek()
+	r.release()
+	require.True(t, r.waitWritable(nil))
+}
@@ -0,0 +1,310 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goxray/core/network/route"
+)
+
+// RouteOp is a route change recorded by MemoryRouteTable, it is a single address of route.Opts.
+type RouteOp struct {
+	Delete  bool   `json:"delete,omitempty"`  // The route is deleted, added otherwise.
+	Addr    string `json:"addr"`              // Destination address, like "0.0.0.0/1".
+	IfName  string `json:"if_name,omitempty"` // Interface the route points to, empty for gateway routes.
+	Gateway string `json:"gateway,omitempty"` // Gateway the route points to, empty for interface routes.
+}
+
+// routeOpsOf returns ops of options addresses.
+func routeOpsOf(options route.Opts, del bool) []RouteOp {
+	ops := make([]RouteOp, 0, len(options.Routes))
+	for _, addr := range options.Routes {
+		op := RouteOp{Delete: del, Addr: addr.String(), IfName: options.IfName}
+		if options.IfName == "" {
+			op.Gateway = options.Gateway.String()
+		}
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+// opts returns route options of the op.
+func (o RouteOp) opts() (route.Opts, error) {
+	addr, err := route.ParseAddr(o.Addr)
+	if err != nil {
+		return route.Opts{}, err
+	}
+	opts := route.Opts{IfName: o.IfName, Routes: []*route.Addr{addr}}
+	if o.IfName == "" {
+		if opts.Gateway = net.ParseIP(o.Gateway); opts.Gateway == nil {
+			return route.Opts{}, fmt.Errorf("invalid gateway %q", o.Gateway)
+		}
+	}
+
+	return opts, nil
+}
+
+// String returns the op in "ip route" notation, e.g. "add 0.0.0.0/1 dev tun0".
+func (o RouteOp) String() string {
+	action := "add"
+	if o.Delete {
+		action = "delete"
+	}
+	if o.IfName != "" {
+		return fmt.Sprintf("%s %s dev %s", action, o.Addr, o.IfName)
+	}
+
+	return fmt.Sprintf("%s %s via %s", action, o.Addr, o.Gateway)
+}
+
+// RouteRule is a policy routing rule ("ip rule"): packets with Mark or from From network are routed by Table.
+type RouteRule struct {
+	Priority int        // Rules are matched in order of priority, lower first (default: chosen by kernel).
+	Table    int        // Routing table of matched packets.
+	Mark     uint32     // Firewall mark to match, zero matches any.
+	From     *net.IPNet // Source network to match, nil matches any.
+}
+
+// String returns the rule in "ip rule" notation, e.g. "priority 1000 fwmark 100 lookup 100".
+func (r RouteRule) String() string {
+	var parts []string
+	if r.Priority != 0 {
+		parts = append(parts, "priority "+strconv.Itoa(r.Priority))
+	}
+	if r.Mark != 0 {
+		parts = append(parts, "fwmark "+strconv.FormatUint(uint64(r.Mark), 10))
+	}
+	if r.From != nil {
+		parts = append(parts, "from "+r.From.String())
+	}
+
+	return strings.Join(append(parts, "lookup "+strconv.Itoa(r.Table)), " ")
+}
+
+// RuleTable is RouteTable managing policy routing rules (e.g. NetlinkRouteTable), see Config.RouteIsolation.
+type RuleTable interface {
+	AddRule(r RouteRule) error
+	DeleteRule(r RouteRule) error
+}
+
+// RouteSubscriber is RouteTable reporting route changes, including the ones made by other programs
+// (e.g. NetlinkRouteTable). The Client emits EventRouteRemoved if its routes are deleted while connected.
+type RouteSubscriber interface {
+	// SubscribeRoutes sends route changes until ctx is done, the channel is closed then.
+	SubscribeRoutes(ctx context.Context) (<-chan RouteOp, error)
+}
+
+// watchRoutes reports routes of the connection deleted by another program until ctx is done, see routeMissing.
+// The routes are added back with RouteVerifyOptions.Repair only, not to fight with the other program, unless all
+// of them are deleted at once (see routesFlushed) or it is the server route.
+func (c *Client) watchRoutes(ctx context.Context, guard *tunnelGuard) {
+	sub, ok := c.cfg.Routes.(RouteSubscriber)
+	if !ok {
+		return
+	}
+	changes, err := sub.SubscribeRoutes(ctx)
+	if err != nil {
+		c.log(LogRoute).Warn("route changes not watched", "err", err)
+		return
+	}
+
+	want := c.installedRoutes()
+	watched := make(map[RouteOp]bool)
+	for _, op := range want {
+		watched[op] = true
+	}
+	go func() {
+		defer guard.recover("route watch")
+		// Deletions are collected for routeFlushWindow, a flush deletes the routes one by one.
+		var (
+			deleted []RouteOp
+			window  <-chan time.Time
+		)
+		for {
+			select {
+			case op, ok := <-changes:
+				if !ok {
+					return
+				}
+				installed := op
+				installed.Delete = false
+				if !watched[installed] || ctx.Err() != nil {
+					continue // Deleted by Disconnect.
+				}
+				if !op.Delete {
+					deleted = slices.DeleteFunc(deleted, func(d RouteOp) bool { return d == installed })
+					c.routeHealth.set(&c.routeHealth.missing, installed, false)
+					continue
+				}
+				if !slices.Contains(deleted, installed) {
+					deleted = append(deleted, installed)
+				}
+				if window == nil {
+					window = time.After(routeFlushWindow)
+				}
+			case <-window:
+				window = nil
+				if ctx.Err() != nil {
+					return
+				}
+				// A single route can not be told from a flush.
+				if len(want) > 1 && len(deleted) == len(want) {
+					go func() {
+						defer guard.recover("route flush")
+						c.routesFlushed(ctx, want)
+					}()
+				} else {
+					for _, op := range deleted {
+						c.routeMissing(op)
+					}
+				}
+				deleted = nil
+			}
+		}
+	}()
+}
+
+// memoryRouteSubs is the buffer of MemoryRouteTable subscriptions, changes are dropped if it is full.
+const memoryRouteSubs = 64
+
+// MemoryRouteTable is an in-memory RouteTable for tests and dry runs (see Config.Routes): OS routes are not
+// changed, changes are recorded instead. Like OS routing table, it fails to add an existing route and
+// to delete a missing one. The zero value is ready to use, it is safe for concurrent use.
+type MemoryRouteTable struct {
+	mu     sync.Mutex
+	ops    []RouteOp
+	routes []RouteOp // Installed routes, in order of adding.
+	rules  []RouteRule
+	subs   map[chan RouteOp]struct{}
+}
+
+// Add adds routes of options.
+func (t *MemoryRouteTable) Add(options route.Opts) error {
+	return t.apply(options, false)
+}
+
+// Delete deletes routes of options.
+func (t *MemoryRouteTable) Delete(options route.Opts) error {
+	return t.apply(options, true)
+}
+
+func (t *MemoryRouteTable) apply(options route.Opts, del bool) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, op := range routeOpsOf(options, del) {
+		t.ops = append(t.ops, op)
+
+		installed := op
+		installed.Delete = false
+		i := slices.Index(t.routes, installed)
+		switch {
+		case del && i < 0:
+			return fmt.Errorf("route %s: no such route", installed)
+		case del:
+			t.routes = slices.Delete(t.routes, i, i+1)
+		case i >= 0:
+			return fmt.Errorf("route %s: route exists", installed)
+		default:
+			t.routes = append(t.routes, installed)
+		}
+		for sub := range t.subs {
+			select {
+			case sub <- op:
+			default:
+			}
+		}
+	}
+
+	return nil
+}
+
+// SubscribeRoutes sends successful route changes until ctx is done, see RouteSubscriber.
+func (t *MemoryRouteTable) SubscribeRoutes(ctx context.Context) (<-chan RouteOp, error) {
+	sub := make(chan RouteOp, memoryRouteSubs)
+	t.mu.Lock()
+	if t.subs == nil {
+		t.subs = make(map[chan RouteOp]struct{})
+	}
+	t.subs[sub] = struct{}{}
+	t.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		t.mu.Lock()
+		delete(t.subs, sub)
+		close(sub)
+		t.mu.Unlock()
+	}()
+
+	return sub, nil
+}
+
+// AddRule adds policy routing rule, it fails if the rule exists.
+func (t *MemoryRouteTable) AddRule(r RouteRule) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if slices.ContainsFunc(t.rules, r.equal) {
+		return errors.New("rule exists")
+	}
+	t.rules = append(t.rules, r)
+
+	return nil
+}
+
+// DeleteRule deletes policy routing rule, it fails if there is no such rule.
+func (t *MemoryRouteTable) DeleteRule(r RouteRule) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := slices.IndexFunc(t.rules, r.equal)
+	if i < 0 {
+		return errors.New("no such rule")
+	}
+	t.rules = slices.Delete(t.rules, i, i+1)
+
+	return nil
+}
+
+// Rules returns policy routing rules currently in the table in order of adding.
+func (t *MemoryRouteTable) Rules() []RouteRule {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Clone(t.rules)
+}
+
+// Ops returns all recorded route changes in order, including failed ones.
+func (t *MemoryRouteTable) Ops() []RouteOp {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Clone(t.ops)
+}
+
+// List returns routes currently in the table, see RouteLister.
+func (t *MemoryRouteTable) List() ([]RouteOp, error) {
+	return t.Routes(), nil
+}
+
+// Routes returns routes currently in the table in order of adding.
+func (t *MemoryRouteTable) Routes() []RouteOp {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Clone(t.routes)
+}
+
+func (r RouteRule) equal(o RouteRule) bool {
+	return r.Priority == o.Priority && r.Table == o.Table && r.Mark == o.Mark && r.From.String() == o.From.String()
+}
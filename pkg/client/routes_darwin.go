@@ -0,0 +1,135 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/goxray/core/network/route"
+	xroute "golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+// scopedRouteTable is the routing table of macOS. Routes via gateway (the XRay server exception) are scoped
+// to the interface of the gateway, like "route add -ifscope en0": they do not depend on the primary route,
+// so they survive its changes and do not collide with other utun VPNs rewriting it. Routes to TUN device
+// are changed by goxray/core.
+type scopedRouteTable struct {
+	ifRoutes *route.Route
+}
+
+// newOSRouteTable returns the OS routing table, there are no route metrics and tables on macOS:
+// the most specific route wins.
+func newOSRouteTable(metric, table int) (RouteTable, error) {
+	if metric != 0 {
+		return nil, errors.New("route metric is not supported on this platform")
+	}
+	if table != 0 {
+		return nil, errors.New("route isolation is not supported on this platform")
+	}
+	r, err := route.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return &scopedRouteTable{ifRoutes: r}, nil
+}
+
+// Add adds routes of options.
+func (t *scopedRouteTable) Add(options route.Opts) error {
+	if options.IfName != "" {
+		return t.ifRoutes.Add(options)
+	}
+
+	return scopedGatewayRoutes(options, unix.RTM_ADD)
+}
+
+// Delete deletes routes of options.
+func (t *scopedRouteTable) Delete(options route.Opts) error {
+	if options.IfName != "" {
+		return t.ifRoutes.Delete(options)
+	}
+
+	return scopedGatewayRoutes(options, unix.RTM_DELETE)
+}
+
+// scopedGatewayRoutes adds or deletes routes via options gateway scoped to its interface. Without an interface
+// having the gateway in its network (e.g. point-to-point links) the routes are not scoped.
+func scopedGatewayRoutes(options route.Opts, action int) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	flags := unix.RTF_UP | unix.RTF_GATEWAY | unix.RTF_STATIC
+	var index int
+	if ifc := gatewayInterface(options.Gateway); ifc != nil {
+		flags |= unix.RTF_IFSCOPE
+		index = ifc.Index
+	}
+
+	socket, err := unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, unix.AF_UNSPEC)
+	if err != nil {
+		return fmt.Errorf("route socket: %w", err)
+	}
+	defer unix.Close(socket)
+
+	ops := routeOpsOf(options, action == unix.RTM_DELETE)
+	for i, dst := range options.Routes {
+		msg := xroute.RouteMessage{
+			Type:  action,
+			Flags: flags,
+			Index: index,
+			ID:    uintptr(os.Getpid()),
+			Seq:   i + 1,
+			Addrs: []xroute.Addr{unix.RTAX_DST: routeAddr(dst.IP), unix.RTAX_GATEWAY: routeAddr(options.Gateway)},
+		}
+		if ones, bits := net.IPMask(dst.Mask).Size(); dst.Mask == nil || ones == bits {
+			msg.Flags |= unix.RTF_HOST
+		} else {
+			msg.Addrs = append(msg.Addrs, routeAddr(net.IP(dst.Mask)))
+		}
+		b, err := msg.Marshal()
+		if err != nil {
+			return fmt.Errorf("route %s: %w", ops[i], err)
+		}
+		if _, err = unix.Write(socket, b); err != nil {
+			return fmt.Errorf("route %s: %w", ops[i], routeErr(err))
+		}
+	}
+
+	return nil
+}
+
+// gatewayInterface returns the interface having gw in its network, nil if there is none.
+func gatewayInterface(gw net.IP) *net.Interface {
+	ifcs, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, ifc := range ifcs {
+		if ifc.Flags&net.FlagUp == 0 || ifc.Flags&net.FlagPointToPoint != 0 {
+			continue
+		}
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.Contains(gw) {
+				return &ifc
+			}
+		}
+	}
+
+	return nil
+}
+
+// routeAddr returns route socket address of ip (or netmask).
+func routeAddr(ip net.IP) xroute.Addr {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &xroute.Inet4Addr{IP: [4]byte(ip4)}
+	}
+
+	return &xroute.Inet6Addr{IP: [16]byte(ip.To16())}
+}
@@ -0,0 +1,22 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	xroute "golang.org/x/net/route"
+)
+
+func TestRouteAddr(t *testing.T) {
+	require.Equal(t, &xroute.Inet4Addr{IP: [4]byte{203, 0, 113, 5}}, routeAddr(net.IPv4(203, 0, 113, 5)))
+	require.Equal(t, &xroute.Inet4Addr{IP: [4]byte{255, 255, 255, 0}}, routeAddr(net.IP(net.CIDRMask(24, 32))))
+	require.IsType(t, &xroute.Inet6Addr{}, routeAddr(net.ParseIP("2001:db8::1")))
+}
+
+func TestGatewayInterface(t *testing.T) {
+	lo := gatewayInterface(net.IPv4(127, 0, 0, 2))
+	require.NotNil(t, lo, "loopback network has the address")
+	require.NotZero(t, lo.Flags&net.FlagLoopback)
+	require.Nil(t, gatewayInterface(net.IPv4(203, 0, 113, 5)))
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/goxray/core/network/route"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// defaultRouteMetric is the metric of routes unless Config.RouteMetric is set.
+const defaultRouteMetric = 1
+
+// NetlinkRouteTable is the routing table of Linux changed via netlink, it is the default Config.Routes on Linux.
+// Besides RouteTable it lists routes, manages policy routing rules and reports route changes (see RouteSubscriber).
+type NetlinkRouteTable struct {
+	// Metric of added routes, lower wins (default: 1).
+	Metric int
+	// Table routes are added to, listed and subscribed from (default: main table).
+	Table int
+}
+
+func newOSRouteTable(metric, table int) (RouteTable, error) {
+	return &NetlinkRouteTable{Metric: metric, Table: table}, nil
+}
+
+// Add adds routes of options.
+func (t *NetlinkRouteTable) Add(options route.Opts) error {
+	return t.apply(options, false)
+}
+
+// Delete deletes routes of options.
+func (t *NetlinkRouteTable) Delete(options route.Opts) error {
+	return t.apply(options, true)
+}
+
+func (t *NetlinkRouteTable) apply(options route.Opts, del bool) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	r := netlink.Route{Gw: options.Gateway, Priority: t.metric(), Table: t.Table}
+	if options.IfName != "" {
+		link, err := netlink.LinkByName(options.IfName)
+		if err != nil {
+			return fmt.Errorf("route device %s: %w", options.IfName, err)
+		}
+		r.LinkIndex = link.Attrs().Index
+	}
+	op := netlink.RouteAdd
+	if del {
+		op = netlink.RouteDel
+	}
+	ops := routeOpsOf(options, del)
+	for i, addr := range options.Routes {
+		r.Dst = (*net.IPNet)(addr)
+		if err := op(&r); err != nil {
+			return fmt.Errorf("route %s metric %d: %w", ops[i], r.Priority, routeErr(err))
+		}
+	}
+
+	return nil
+}
+
+func (t *NetlinkRouteTable) metric() int {
+	if t.Metric == 0 {
+		return defaultRouteMetric
+	}
+
+	return t.Metric
+}
+
+func (t *NetlinkRouteTable) table() int {
+	if t.Table == 0 {
+		return unix.RT_TABLE_MAIN
+	}
+
+	return t.Table
+}
+
+// List returns IPv4 and IPv6 routes of the table.
+func (t *NetlinkRouteTable) List() ([]RouteOp, error) {
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: t.table()}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+
+	ops := make([]RouteOp, 0, len(routes))
+	for _, r := range routes {
+		ops = append(ops, netlinkRouteOp(r, false))
+	}
+
+	return ops, nil
+}
+
+// SubscribeRoutes sends changes of the table routes, made by any program, until ctx is done.
+func (t *NetlinkRouteTable) SubscribeRoutes(ctx context.Context) (<-chan RouteOp, error) {
+	updates := make(chan netlink.RouteUpdate)
+	done := make(chan struct{})
+	err := netlink.RouteSubscribeWithOptions(updates, done, netlink.RouteSubscribeOptions{ErrorCallback: func(error) {}})
+	if err != nil {
+		return nil, fmt.Errorf("subscribe routes: %w", err)
+	}
+
+	ops := make(chan RouteOp)
+	go func() {
+		defer close(ops)
+		defer close(done)
+		for {
+			var u netlink.RouteUpdate
+			var ok bool
+			select {
+			case u, ok = <-updates:
+			case <-ctx.Done():
+				return
+			}
+			if !ok {
+				return
+			}
+			if u.Table != t.table() || (u.Type != unix.RTM_NEWROUTE && u.Type != unix.RTM_DELROUTE) {
+				continue
+			}
+			select {
+			case ops <- netlinkRouteOp(u.Route, u.Type == unix.RTM_DELROUTE):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ops, nil
+}
+
+// netlinkRouteOp returns op of netlink route, routes via gateway have no interface name like route.Opts.
+func netlinkRouteOp(r netlink.Route, del bool) RouteOp {
+	op := RouteOp{Delete: del, Addr: "0.0.0.0/0"}
+	switch {
+	case r.Dst != nil:
+		op.Addr = r.Dst.String()
+	case r.Family == netlink.FAMILY_V6:
+		op.Addr = "::/0"
+	}
+	if r.Gw != nil {
+		op.Gateway = r.Gw.String()
+		return op
+	}
+	if link, err := netlink.LinkByIndex(r.LinkIndex); err == nil {
+		op.IfName = link.Attrs().Name
+	}
+
+	return op
+}
+
+// AddRule adds policy routing rule.
+func (t *NetlinkRouteTable) AddRule(r RouteRule) error {
+	if err := netlink.RuleAdd(r.netlink()); err != nil {
+		return fmt.Errorf("add rule: %w", routeErr(err))
+	}
+
+	return nil
+}
+
+// DeleteRule deletes policy routing rule.
+func (t *NetlinkRouteTable) DeleteRule(r RouteRule) error {
+	if err := netlink.RuleDel(r.netlink()); err != nil {
+		return fmt.Errorf("delete rule: %w", routeErr(err))
+	}
+
+	return nil
+}
+
+func (r RouteRule) netlink() *netlink.Rule {
+	rule := netlink.NewRule()
+	if r.Priority != 0 {
+		rule.Priority = r.Priority
+	}
+	rule.Table = r.Table
+	rule.Mark = r.Mark
+	rule.Src = r.From
+	if r.From != nil && r.From.IP.To4() == nil {
+		rule.Family = netlink.FAMILY_V6
+	}
+
+	return rule
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestNewClientWithOpts_routeMetric(t *testing.T) {
+	c, err := NewClientWithOpts(Config{})
+	require.NoError(t, err)
+	require.Equal(t, &NetlinkRouteTable{}, c.routes)
+	require.Equal(t, defaultRouteMetric, c.routes.(*NetlinkRouteTable).metric())
+
+	c, err = NewClientWithOpts(Config{RouteMetric: 50})
+	require.NoError(t, err)
+	require.Equal(t, &NetlinkRouteTable{Metric: 50}, c.routes)
+
+	routes := &MemoryRouteTable{}
+	c, err = NewClientWithOpts(Config{RouteMetric: 50, Routes: routes})
+	require.NoError(t, err)
+	require.Same(t, routes, c.routes, "not applied to custom routes")
+
+	c, err = NewClientWithOpts(Config{RouteIsolation: &RouteIsolationOptions{Table: 100}})
+	require.NoError(t, err)
+	require.Equal(t, &NetlinkRouteTable{Table: 100}, c.routes)
+}
+
+func TestNetlinkRouteTable_errors(t *testing.T) {
+	require.ErrorContains(t, routeErr(unix.EEXIST), "route exists")
+	require.ErrorContains(t, routeErr(unix.ESRCH), "no such route")
+	require.ErrorIs(t, routeErr(unix.EPERM), unix.EPERM)
+
+	err := (&NetlinkRouteTable{}).Add(route.Opts{IfName: "goxray-missing0", Routes: DefaultRoutesToTUN})
+	require.ErrorContains(t, err, "route device goxray-missing0")
+}
+
+func TestNetlinkRouteOp(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("203.0.113.5/32")
+	require.Equal(t, RouteOp{Addr: "203.0.113.5/32", Gateway: "192.168.1.1"},
+		netlinkRouteOp(netlink.Route{Dst: dst, Gw: net.IPv4(192, 168, 1, 1)}, false))
+	require.Equal(t, RouteOp{Delete: true, Addr: "0.0.0.0/0", Gateway: "192.168.1.1"},
+		netlinkRouteOp(netlink.Route{Gw: net.IPv4(192, 168, 1, 1)}, true))
+	require.Equal(t, RouteOp{Addr: "::/0", Gateway: "fe80::1"},
+		netlinkRouteOp(netlink.Route{Family: netlink.FAMILY_V6, Gw: net.ParseIP("fe80::1")}, false))
+}
+
+func TestRouteRule_netlink(t *testing.T) {
+	rule := RouteRule{Table: 100, Mark: 0x1}.netlink()
+	require.Equal(t, -1, rule.Priority, "chosen by kernel")
+	require.Equal(t, 100, rule.Table)
+	require.Equal(t, uint32(1), rule.Mark)
+
+	_, from, _ := net.ParseCIDR("fd00::/64")
+	rule = RouteRule{Priority: 100, Table: 100, From: from}.netlink()
+	require.Equal(t, 100, rule.Priority)
+	require.Equal(t, netlink.FAMILY_V6, rule.Family)
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !darwin
+
+package client
+
+import (
+	"errors"
+
+	"github.com/goxray/core/network/route"
+)
+
+// newOSRouteTable returns the OS routing table, there are no route metrics and tables on these platforms:
+// the most specific route wins.
+func newOSRouteTable(metric, table int) (RouteTable, error) {
+	if metric != 0 {
+		return nil, errors.New("route metric is not supported on this platform")
+	}
+	if table != 0 {
+		return nil, errors.New("route isolation is not supported on this platform")
+	}
+
+	return route.New()
+}
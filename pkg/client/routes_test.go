@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRouteTable(t *testing.T) {
+	var table MemoryRouteTable
+	tun := route.Opts{IfName: "tun0", Routes: DefaultRoutesToTUN}
+	server := route.Opts{Gateway: net.IPv4(192, 168, 1, 1), Routes: []*route.Addr{route.MustParseAddr("203.0.113.5/32")}}
+
+	require.NoError(t, table.Add(server))
+	require.NoError(t, table.Add(tun))
+	require.Error(t, table.Add(server), "route exists")
+	require.Error(t, table.Add(route.Opts{Routes: DefaultRoutesToTUN}), "invalid options")
+	require.Equal(t, []RouteOp{
+		{Addr: "203.0.113.5/32", Gateway: "192.168.1.1"},
+		{Addr: "0.0.0.0/1", IfName: "tun0"},
+		{Addr: "128.0.0.0/1", IfName: "tun0"},
+	}, table.Routes())
+
+	require.NoError(t, table.Delete(tun))
+	require.Error(t, table.Delete(tun), "no such route")
+	require.Equal(t, []RouteOp{{Addr: "203.0.113.5/32", Gateway: "192.168.1.1"}}, table.Routes())
+	require.Equal(t, []string{
+		"add 203.0.113.5/32 via 192.168.1.1",
+		"add 0.0.0.0/1 dev tun0",
+		"add 128.0.0.0/1 dev tun0",
+		"add 203.0.113.5/32 via 192.168.1.1",
+		"delete 0.0.0.0/1 dev tun0",
+		"delete 128.0.0.0/1 dev tun0",
+		"delete 0.0.0.0/1 dev tun0",
+	}, routeOps(&table))
+}
+
+func TestNewClientWithOpts_routes(t *testing.T) {
+	table := &MemoryRouteTable{}
+	c, err := NewClientWithOpts(Config{Routes: table})
+	require.NoError(t, err)
+	require.Same(t, table, c.routes)
+
+	c, err = NewClient()
+	require.NoError(t, err)
+	require.NotNil(t, c.routes, "OS routing table, see newOSRouteTable")
+}
+
+func TestMemoryRouteTable_SubscribeRoutes(t *testing.T) {
+	var table MemoryRouteTable
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := table.SubscribeRoutes(ctx)
+	require.NoError(t, err)
+
+	tun := route.Opts{IfName: "tun0", Routes: DefaultRoutesToTUN[:1]}
+	require.NoError(t, table.Add(tun))
+	require.Error(t, table.Add(tun))
+	require.NoError(t, table.Delete(tun))
+	require.Equal(t, RouteOp{Addr: "0.0.0.0/1", IfName: "tun0"}, <-changes)
+	require.Equal(t, RouteOp{Delete: true, Addr: "0.0.0.0/1", IfName: "tun0"}, <-changes, "failed changes are not sent")
+
+	cancel()
+	_, ok := <-changes
+	require.False(t, ok)
+	require.NoError(t, table.Add(tun), "no subscribers")
+}
+
+func TestLoopback_RouteRemoved(t *testing.T) {
+	c, _, routes, _ := newLoopbackClient(t)
+	events := make(chan Event, 1)
+	c.cfg.OnEvent = func(ev Event) { events <- ev }
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	installed := routes.Routes()
+	require.NotEmpty(t, installed)
+
+	// The only route of the loopback Client is the server route, it is added back regardless of Repair.
+	opts, err := installed[0].opts()
+	require.NoError(t, err)
+	require.NoError(t, routes.Delete(opts))
+	select {
+	case ev := <-events:
+		require.Equal(t, EventRouteRepaired, ev.Type)
+		require.Equal(t, installed[0].String(), ev.Attrs["route"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no route repaired event")
+	}
+	require.Equal(t, installed, routes.Routes())
+
+	require.NoError(t, c.Disconnect(context.Background()))
+	require.Equal(t, EventDisconnected, (<-events).Type, "routes deleted by Disconnect are not reported")
+	require.Empty(t, events)
+}
@@ -0,0 +1,26 @@
+//go:build linux || darwin
+
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// routeErr names errors of route changes like MemoryRouteTable does.
+func routeErr(err error) error {
+	switch {
+	case errors.Is(err, unix.EEXIST):
+		return fmt.Errorf("route exists: %w", err)
+	case errors.Is(err, unix.ESRCH):
+		return fmt.Errorf("no such route: %w", err)
+	case errors.Is(err, unix.ENETUNREACH):
+		return fmt.Errorf("gateway unreachable: %w", err)
+	case errors.Is(err, unix.EPERM):
+		return fmt.Errorf("root privileges required: %w", err)
+	}
+
+	return err
+}
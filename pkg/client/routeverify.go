@@ -0,0 +1,306 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goxray/core/network/route"
+)
+
+const (
+	// routeFlushWindow is how long route deletions are collected to tell a flush from removal of single routes.
+	routeFlushWindow = 200 * time.Millisecond
+	// routeFlushRetry is the interval of adding flushed routes back while it fails, e.g. until the network
+	// of the gateway is configured again.
+	routeFlushRetry = 2 * time.Second
+)
+
+// RouteLister is RouteTable listing its routes (e.g. NetlinkRouteTable, MemoryRouteTable). Routes of the Client
+// are read back after connect and verified while connected, see RouteVerifyOptions.
+type RouteLister interface {
+	List() ([]RouteOp, error)
+}
+
+// RouteVerifyOptions configure verification of the routes of the Client with RouteLister Config.Routes.
+//
+// Routes are read back from the table after connect and every Interval. Missing routes emit EventRouteRemoved
+// or are added back with Repair (EventRouteRepaired), routes of the same destination added by another program
+// emit EventRouteOverridden: traffic may bypass the tunnel then, which otherwise looks like a dead server.
+// All routes of the connection (two at least) missing at once, e.g. flushed by `ip route flush` or NetworkManager restart,
+// are added back regardless of Repair (EventRoutesFlushed). So is the route exception of the server alone, e.g. dropped
+// by DHCP client or NetworkManager replacing the default route, or the traffic of the proxy loops into the TUN device.
+type RouteVerifyOptions struct {
+	// Interval of verification while connected, e.g. 30s (default: 0, verified after connect only).
+	Interval time.Duration
+	// Repair adds missing routes back, also the ones reported by RouteSubscriber (default: false, reported only
+	// but the server route).
+	Repair bool
+}
+
+// Validate checks options values.
+func (o *RouteVerifyOptions) Validate() error {
+	if o.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+
+	return nil
+}
+
+func (o *RouteVerifyOptions) interval() time.Duration {
+	if o == nil {
+		return 0
+	}
+
+	return o.Interval
+}
+
+func (o *RouteVerifyOptions) repair() bool {
+	return o != nil && o.Repair
+}
+
+// routeHealth holds routes of the connection reported missing or overridden, so they are reported once.
+type routeHealth struct {
+	mu         sync.Mutex
+	missing    map[RouteOp]bool
+	overridden map[RouteOp]bool
+	// stopped is set when routes are deleted by tearDown, they must not be repaired then.
+	stopped bool
+	// flushing is set while flushed routes are added back, see Client.routesFlushed.
+	flushing bool
+}
+
+// reset forgets reported routes, it is called on connect.
+func (h *routeHealth) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.missing, h.overridden, h.stopped = map[RouteOp]bool{}, map[RouteOp]bool{}, false
+}
+
+// stop prevents repairs of the routes being deleted.
+func (h *routeHealth) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stopped = true
+}
+
+// flush marks flushed routes being added back, it reports false if they are already.
+func (h *routeHealth) flush() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.flushing {
+		return false
+	}
+	h.flushing = true
+
+	return true
+}
+
+// flushed marks flushed routes added back.
+func (h *routeHealth) flushed() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.flushing = false
+}
+
+// repair adds missing route op back by add unless the routes are stopped.
+func (h *routeHealth) repair(op RouteOp, add func(route.Opts) error) error {
+	opts, err := op.opts()
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopped {
+		return errors.New("connection stopped")
+	}
+	if err = add(opts); err != nil {
+		return err
+	}
+	delete(h.missing, op)
+
+	return nil
+}
+
+// set marks op in set m, it reports whether the mark changed.
+func (h *routeHealth) set(m *map[RouteOp]bool, op RouteOp, marked bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if *m == nil {
+		*m = map[RouteOp]bool{}
+	}
+	if (*m)[op] == marked {
+		return false
+	}
+	if marked {
+		(*m)[op] = true
+	} else {
+		delete(*m, op)
+	}
+
+	return true
+}
+
+// installedRoutes returns routes added by Connect to the table of Config.Routes.
+func (c *Client) installedRoutes() []RouteOp {
+	var ops []RouteOp
+	if c.tunName != "" {
+		ops = append(ops, routeOpsOf(route.Opts{IfName: c.tunName, Routes: c.cfg.RoutesToTUN}, false)...)
+	}
+	if c.serverRouteNeeded(c.xSrvIP.IP) {
+		ops = append(ops, routeOpsOf(c.xrayToGatewayRoute(), false)...)
+	}
+
+	return ops
+}
+
+// verifyRoutes reads routes of the connection back from RouteLister Config.Routes and verifies them every
+// RouteVerifyOptions.Interval until ctx is done.
+func (c *Client) verifyRoutes(ctx context.Context, guard *tunnelGuard) {
+	c.routeHealth.reset()
+	lister, ok := c.cfg.Routes.(RouteLister)
+	if !ok {
+		return
+	}
+	want := c.installedRoutes()
+	if len(want) == 0 {
+		return
+	}
+	c.checkRoutes(ctx, lister, want)
+
+	interval := c.cfg.RouteVerify.interval()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		defer guard.recover("route verify")
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.checkRoutes(ctx, lister, want)
+			}
+		}
+	}()
+}
+
+// checkRoutes compares routes of the table with want.
+func (c *Client) checkRoutes(ctx context.Context, lister RouteLister, want []RouteOp) {
+	have, err := lister.List()
+	if err != nil {
+		c.log(LogRoute).Warn("route verification failed", "err", err)
+		return
+	}
+	if len(want) > 1 && !slices.ContainsFunc(want, func(op RouteOp) bool { return slices.Contains(have, op) }) {
+		c.routesFlushed(ctx, want)
+		return
+	}
+
+	for _, op := range want {
+		if slices.Contains(have, op) {
+			c.routeHealth.set(&c.routeHealth.missing, op, false)
+		} else {
+			c.routeMissing(op)
+		}
+
+		i := slices.IndexFunc(have, func(r RouteOp) bool { return r.Addr == op.Addr && r != op })
+		if !c.routeHealth.set(&c.routeHealth.overridden, op, i >= 0) || i < 0 {
+			continue
+		}
+		c.log(LogRoute).Warn("route overridden by another program", "route", op, "by", have[i])
+		c.emit(Event{
+			Type:    EventRouteOverridden,
+			Message: "route of the same destination added by another program, traffic may bypass the tunnel",
+			Attrs:   map[string]string{"route": op.String(), "by": have[i].String()},
+		})
+	}
+}
+
+// routeMissing reports route op of the connection removed by another program or adds it back with
+// RouteVerifyOptions.Repair. The server route is added back regardless, see RouteVerifyOptions.
+func (c *Client) routeMissing(op RouteOp) {
+	if c.cfg.RouteVerify.repair() || c.isServerRoute(op) {
+		err := c.routeHealth.repair(op, c.routes.Add)
+		if err == nil {
+			c.log(LogRoute).Warn("route removed by another program, added back", "route", op)
+			c.emit(Event{
+				Type:    EventRouteRepaired,
+				Message: "route removed by another program, added back",
+				Attrs:   map[string]string{"route": op.String()},
+			})
+			return
+		}
+		c.log(LogRoute).Warn("route repair failed", "route", op, "err", err)
+	}
+
+	if !c.routeHealth.set(&c.routeHealth.missing, op, true) {
+		return // Reported already.
+	}
+	c.log(LogRoute).Warn("route removed by another program", "route", op)
+	c.emit(Event{
+		Type:    EventRouteRemoved,
+		Message: "route removed by another program, traffic may bypass the tunnel",
+		Attrs:   map[string]string{"route": op.String()},
+	})
+}
+
+// isServerRoute reports whether op is the route exception of the server, see Client.xrayToGatewayRoute.
+func (c *Client) isServerRoute(op RouteOp) bool {
+	return c.xSrvIP != nil && slices.Contains(routeOpsOf(c.xrayToGatewayRoute(), false), op)
+}
+
+// routesFlushed adds routes of the connection back after all of them were deleted, by a flush of the table
+// (`ip route flush`), NetworkManager restart or DHCP client replacing the routes of the network: unlike removal
+// of a single route it is not another program taking the destination over. Routes failing to be added are retried
+// every routeFlushRetry until ctx is done, the server route goes first so its traffic does not loop into the TUN device.
+func (c *Client) routesFlushed(ctx context.Context, want []RouteOp) {
+	if !c.routeHealth.flush() {
+		return // Being added back.
+	}
+	defer c.routeHealth.flushed()
+	c.log(LogRoute).Warn("routes of the connection flushed by another program, adding them back", "routes", len(want))
+	c.emit(Event{
+		Type:    EventRoutesFlushed,
+		Message: "routes flushed by another program, adding them back",
+		Attrs:   map[string]string{"routes": strconv.Itoa(len(want))},
+	})
+
+	// Routes of the TUN device go last.
+	toTUN := func(op RouteOp) bool { return op.IfName == c.tunName }
+	pending := slices.DeleteFunc(slices.Clone(want), toTUN)
+	pending = append(pending, slices.DeleteFunc(slices.Clone(want), func(op RouteOp) bool { return !toTUN(op) })...)
+	lister, _ := c.cfg.Routes.(RouteLister)
+	for {
+		var have []RouteOp
+		if lister != nil {
+			have, _ = lister.List()
+		}
+		for len(pending) > 0 {
+			op := pending[0]
+			if !slices.Contains(have, op) {
+				if err := c.routeHealth.repair(op, c.routes.Add); err != nil {
+					c.log(LogRoute).Debug("adding flushed route back failed", "route", op, "err", err)
+					break
+				}
+			}
+			c.routeHealth.set(&c.routeHealth.missing, op, false)
+			pending = pending[1:]
+		}
+		if len(pending) == 0 {
+			c.log(LogRoute).Info("flushed routes added back")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(routeFlushRetry):
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteVerifyOptions_Validate(t *testing.T) {
+	require.NoError(t, (&RouteVerifyOptions{}).Validate())
+	require.NoError(t, (&RouteVerifyOptions{Interval: time.Second, Repair: true}).Validate())
+	require.ErrorContains(t, (&RouteVerifyOptions{Interval: -1}).Validate(), "interval must not be negative")
+
+	var o *RouteVerifyOptions
+	require.Zero(t, o.interval())
+	require.False(t, o.repair())
+}
+
+// waitEvent returns the next event of type typ from events, other events are skipped.
+func waitEvent(t *testing.T, events <-chan Event, typ EventType) Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == typ {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", typ)
+		}
+	}
+}
+
+func TestLoopback_RouteVerify(t *testing.T) {
+	c, _, routes, _ := newLoopbackClient(t)
+	c.cfg.RouteVerify = &RouteVerifyOptions{Interval: 10 * time.Millisecond}
+	events := make(chan Event, 16)
+	c.cfg.OnEvent = func(ev Event) { events <- ev }
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	installed := routes.Routes()
+	require.NotEmpty(t, installed)
+
+	other := route.Opts{IfName: "wg0", Routes: []*route.Addr{route.MustParseAddr(installed[0].Addr)}}
+	require.NoError(t, routes.Add(other))
+	ev := waitEvent(t, events, EventRouteOverridden)
+	require.Equal(t, installed[0].String(), ev.Attrs["route"])
+	require.Equal(t, "add "+installed[0].Addr+" dev wg0", ev.Attrs["by"])
+	require.NoError(t, routes.Delete(other))
+
+	// The server route is added back regardless of Repair, see TestClient_RoutesFlushedVerify for other routes.
+	opts, err := installed[0].opts()
+	require.NoError(t, err)
+	require.NoError(t, routes.Delete(opts))
+	ev = waitEvent(t, events, EventRouteRepaired)
+	require.Equal(t, installed[0].String(), ev.Attrs["route"])
+	require.Equal(t, installed, routes.Routes())
+
+	require.NoError(t, c.Disconnect(context.Background()))
+}
+
+func TestLoopback_RouteRepair(t *testing.T) {
+	c, _, routes, _ := newLoopbackClient(t)
+	c.cfg.RouteVerify = &RouteVerifyOptions{Repair: true}
+	events := make(chan Event, 16)
+	c.cfg.OnEvent = func(ev Event) { events <- ev }
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	installed := routes.Routes()
+
+	opts, err := installed[0].opts()
+	require.NoError(t, err)
+	require.NoError(t, routes.Delete(opts))
+	ev := waitEvent(t, events, EventRouteRepaired)
+	require.Equal(t, installed[0].String(), ev.Attrs["route"])
+	require.ElementsMatch(t, installed, routes.Routes())
+
+	require.NoError(t, c.Disconnect(context.Background()))
+	require.Empty(t, routes.Routes(), "routes deleted by Disconnect are not repaired")
+}
+
+// newFlushClient returns Client connected with TUN device routes and the server route in routes.
+func newFlushClient(t *testing.T) (*Client, *MemoryRouteTable, <-chan Event) {
+	gateway := net.IPv4(192, 168, 1, 1)
+	routes := &MemoryRouteTable{}
+	events := make(chan Event, 16)
+	c := &Client{
+		cfg: Config{
+			GatewayIP:   &gateway,
+			RoutesToTUN: DefaultRoutesToTUN,
+			Routes:      routes,
+			Logger:      slog.New(slog.DiscardHandler),
+			OnEvent:     func(ev Event) { events <- ev },
+		},
+		tunName: "tun0",
+		xSrvIP:  &net.IPAddr{IP: net.IPv4(10, 0, 0, 1)},
+		routes:  routes,
+	}
+	c.routeHealth.reset()
+	require.NoError(t, routes.Add(c.xrayToGatewayRoute()))
+	require.NoError(t, routes.Add(route.Opts{IfName: c.tunName, Routes: c.cfg.RoutesToTUN}))
+	require.Len(t, c.installedRoutes(), 3)
+
+	return c, routes, events
+}
+
+func TestClient_RoutesFlushed(t *testing.T) {
+	c, routes, events := newFlushClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.watchRoutes(ctx, &tunnelGuard{c: c, cancel: cancel})
+	installed := c.installedRoutes()
+
+	// All routes deleted at once are added back without Repair, the server route first.
+	require.NoError(t, routes.Delete(route.Opts{IfName: c.tunName, Routes: c.cfg.RoutesToTUN}))
+	require.NoError(t, routes.Delete(c.xrayToGatewayRoute()))
+	ev := waitEvent(t, events, EventRoutesFlushed)
+	require.Equal(t, "3", ev.Attrs["routes"])
+	require.Eventually(t, func() bool { return len(routes.Routes()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	require.ElementsMatch(t, installed, routes.Routes())
+	ops := routes.Ops()
+	require.Empty(t, ops[len(ops)-3].IfName, "server route goes first")
+	for len(events) > 0 {
+		require.NotEqual(t, EventRouteRemoved, (<-events).Type, "flushed routes are not reported one by one")
+	}
+
+	// Removal of a single route is reported only.
+	require.NoError(t, routes.Delete(route.Opts{IfName: c.tunName, Routes: c.cfg.RoutesToTUN[:1]}))
+	waitEvent(t, events, EventRouteRemoved)
+	require.Len(t, routes.Routes(), 2)
+}
+
+func TestClient_RoutesFlushedVerify(t *testing.T) {
+	c, routes, events := newFlushClient(t)
+	installed := c.installedRoutes()
+
+	require.NoError(t, routes.Delete(route.Opts{IfName: c.tunName, Routes: c.cfg.RoutesToTUN}))
+	require.NoError(t, routes.Delete(c.xrayToGatewayRoute()))
+	c.checkRoutes(context.Background(), routes, installed)
+	waitEvent(t, events, EventRoutesFlushed)
+	require.ElementsMatch(t, installed, routes.Routes())
+
+	// Present routes are kept, the rest is single route removal.
+	require.NoError(t, routes.Delete(route.Opts{IfName: c.tunName, Routes: c.cfg.RoutesToTUN[:1]}))
+	c.checkRoutes(context.Background(), routes, installed)
+	waitEvent(t, events, EventRouteRemoved)
+	require.Len(t, routes.Routes(), 2)
+}
+
+func TestClient_ServerRouteRemoved(t *testing.T) {
+	c, routes, events := newFlushClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.watchRoutes(ctx, &tunnelGuard{c: c, cancel: cancel})
+	installed := c.installedRoutes()
+	server := routeOpsOf(c.xrayToGatewayRoute(), false)[0]
+
+	// The server route alone, e.g. dropped with the default route by DHCP client, is added back without Repair.
+	require.NoError(t, routes.Delete(c.xrayToGatewayRoute()))
+	ev := waitEvent(t, events, EventRouteRepaired)
+	require.Equal(t, server.String(), ev.Attrs["route"])
+	require.ElementsMatch(t, installed, routes.Routes())
+
+	for len(events) > 0 {
+		require.NotEqual(t, EventRouteRemoved, (<-events).Type, "repaired route is not reported removed")
+	}
+}
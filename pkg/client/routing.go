@@ -0,0 +1,180 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// RouteAction is what RoutingRule does with matching connections.
+type RouteAction string
+
+const (
+	// RouteProxy sends connections to the server, it exempts them from the following rules.
+	RouteProxy RouteAction = "proxy"
+	// RouteDirect connects to the destination from this machine, bypassing the server.
+	RouteDirect RouteAction = "direct"
+	// RouteBlock drops connections, e.g. UDP 443 to make browsers fall back from QUIC to TCP.
+	RouteBlock RouteAction = "block"
+)
+
+// RoutingRule routes tunneled connections by destination, e.g. {Network: "udp", Port: "443", Action: RouteBlock}
+// or {Port: "25", Action: RouteDirect}. Rules are compiled into XRay routing, the first matching rule applies
+// and the rest of connections go to the server. Routing is supported for protocols served by XRay core only.
+//
+// Domains match only if XRay knows the destination domain, i.e. for proxy clients or sniffed connections.
+type RoutingRule struct {
+	// Network is "tcp", "udp" or "tcp,udp" (default: any).
+	Network string
+	// Port is destination port, range or comma separated list of them, e.g. "443", "1000-2000,8443".
+	Port string
+	// Domains are XRay domain matchers, e.g. "example.com", "full:www.example.com", "regexp:\\.ru$".
+	Domains []string
+	// IPs are destination IPs or CIDR networks, e.g. "10.0.0.0/8".
+	IPs []string
+	// Action is done with the matching connections.
+	Action RouteAction
+}
+
+// Validate checks rule values.
+func (r RoutingRule) Validate() error {
+	switch r.Action {
+	case RouteProxy, RouteDirect, RouteBlock:
+	default:
+		return fmt.Errorf("invalid action %q, must be proxy, direct or block", r.Action)
+	}
+	switch r.Network {
+	case "", "tcp", "udp", "tcp,udp", "udp,tcp":
+	default:
+		return fmt.Errorf("invalid network %q, must be tcp, udp or tcp,udp", r.Network)
+	}
+	if r.Port != "" {
+		var ports conf.PortList
+		if err := json.Unmarshal([]byte(strconv.Quote(r.Port)), &ports); err != nil {
+			return fmt.Errorf("invalid port %q: %w", r.Port, err)
+		}
+	}
+	for _, ip := range r.IPs {
+		if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid ip %q", ip)
+		}
+	}
+	if r.Network == "" && r.Port == "" && len(r.Domains) == 0 && len(r.IPs) == 0 {
+		return errors.New("rule matches nothing, set network, port, domains or ips")
+	}
+
+	return nil
+}
+
+// Tags of XRay outbounds created for routing rules.
+const (
+	routeDirectTag = "route-direct"
+	routeBlockTag  = "route-block"
+)
+
+// addRouting adds routing rules into XRay config. Direct connections are bound to gatewayIface,
+// otherwise they would be routed back to TUN.
+func addRouting(cfg *conf.Config, proxyTag string, rules []RoutingRule, gatewayIface func() (string, error)) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	if cfg.RouterConfig == nil {
+		cfg.RouterConfig = &conf.RouterConfig{}
+	}
+	outbounds := make(map[RouteAction]bool)
+	for i, r := range rules {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+
+		rule := map[string]any{"type": "field"}
+		if r.Network != "" {
+			rule["network"] = r.Network
+		}
+		if r.Port != "" {
+			rule["port"] = r.Port
+		}
+		if len(r.Domains) > 0 {
+			rule["domain"] = r.Domains
+		}
+		if len(r.IPs) > 0 {
+			rule["ip"] = r.IPs
+		}
+		switch r.Action {
+		case RouteProxy:
+			rule["outboundTag"] = proxyTag
+		case RouteDirect:
+			rule["outboundTag"] = routeDirectTag
+		case RouteBlock:
+			rule["outboundTag"] = routeBlockTag
+		}
+		b, err := json.Marshal(rule)
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		cfg.RouterConfig.RuleList = append(cfg.RouterConfig.RuleList, b)
+		outbounds[r.Action] = true
+	}
+
+	if outbounds[RouteDirect] {
+		iface, err := gatewayIface()
+		if err != nil {
+			return fmt.Errorf("direct rules: %w", err)
+		}
+		cfg.OutboundConfigs = append(cfg.OutboundConfigs, conf.OutboundDetourConfig{
+			Protocol:      "freedom",
+			Tag:           routeDirectTag,
+			StreamSetting: &conf.StreamConfig{SocketSettings: &conf.SocketConfig{Interface: iface}},
+		})
+	}
+	if outbounds[RouteBlock] {
+		cfg.OutboundConfigs = append(cfg.OutboundConfigs, conf.OutboundDetourConfig{Protocol: "blackhole", Tag: routeBlockTag})
+	}
+
+	return nil
+}
+
+// gatewayInterface returns name of the network interface the default gateway is reachable on.
+func (c *Client) gatewayInterface() (string, error) {
+	gw := c.GatewayIP()
+	if gw == nil {
+		return "", errors.New("no IPv4 gateway")
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("list interfaces: %w", err)
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.Contains(gw) {
+				return iface.Name, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no interface for gateway %s", gw)
+}
+
+// GatewayMTU returns MTU of the network interface the default gateway is reachable on, it bounds the TUN
+// device MTU worth setting.
+func (c *Client) GatewayMTU() (int, error) {
+	name, err := c.gatewayInterface()
+	if err != nil {
+		return 0, err
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("gateway interface: %w", err)
+	}
+
+	return iface.MTU, nil
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/goxray/core/network/route"
+)
+
+// ControlRoute is the route of traffic originated by the Client itself or the application on its behalf
+// (control plane), e.g. bootstrap DNS queries, subscription refreshes or update checks.
+//
+// The Client routes its control traffic explicitly, it never relies on the routes of TUN device: health checks,
+// warm-up and ExitInfo go through the tunnel, captive portal probes, bootstrap DNS queries and the server
+// connections go direct. See Client.ControlDialer for the traffic of the application.
+type ControlRoute string
+
+const (
+	// ControlRouteTunnel sends the traffic through the proxy, it fails if the Client is not connected.
+	ControlRouteTunnel ControlRoute = "tunnel"
+	// ControlRouteDirect sends the traffic to the default gateway, bypassing TUN device while connected.
+	ControlRouteDirect ControlRoute = "direct"
+)
+
+// errNoDirectRoute is returned by direct dials if the destination can not be routed around TUN device.
+var errNoDirectRoute = errors.New("no gateway to route around the tunnel")
+
+// Validate checks the route is known, empty is the default.
+func (r ControlRoute) Validate() error {
+	switch r {
+	case "", ControlRouteTunnel, ControlRouteDirect:
+		return nil
+	default:
+		return fmt.Errorf("unknown control route %q", r)
+	}
+}
+
+// ControlDialer returns the dialer of control traffic of the application routed by route, empty route
+// is Config.ControlRoute. Use it for requests made on behalf of the Client (e.g. subscription refreshes),
+// so they do not loop through TUN device into a tunnel that may be down.
+func (c *Client) ControlDialer(route ControlRoute) DialFunc {
+	if route == "" {
+		route = c.cfg.ControlRoute
+	}
+	if route == ControlRouteDirect {
+		return c.directDial
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if c.stopTunnel == nil {
+			return nil, ErrNotConnected
+		}
+		dialer, err := c.proxyDialer()
+		if err != nil {
+			return nil, err
+		}
+
+		return dialer.DialContext(ctx, network, address)
+	}
+}
+
+// directDial dials address bypassing TUN device: while connected the destination is routed to the default
+// gateway until the connection is closed. It fails rather than loop through the tunnel if there is no gateway
+// of the address family.
+func (c *Client) directDial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = net.DefaultResolver.LookupIP(ctx, "ip", host); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, ip := range ips {
+		release, err := c.routeDirect(ip)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ip, err))
+			continue
+		}
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err != nil {
+			release()
+			errs = append(errs, err)
+			continue
+		}
+
+		return &directConn{Conn: conn, release: sync.OnceFunc(release)}, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// directConn releases the direct route when closed.
+type directConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *directConn) Close() error {
+	defer c.release()
+
+	return c.Conn.Close()
+}
+
+// routeDirect routes ip to the default gateway if it is routed to TUN device, release removes the route
+// after the last user.
+func (c *Client) routeDirect(ip net.IP) (release func(), err error) {
+	if !c.tunRouted(ip) {
+		return func() {}, nil
+	}
+	gw := c.cfg.GatewayIP
+	if gw == nil || (ip.To4() == nil) != (gw.To4() == nil) {
+		return nil, errNoDirectRoute
+	}
+
+	return c.direct.acquire(c.routes, route.Opts{Gateway: *gw, Routes: []*route.Addr{hostRoute(ip)}})
+}
+
+// tunRouted reports whether traffic to ip goes to TUN device of the connection.
+func (c *Client) tunRouted(ip net.IP) bool {
+	if c.tunName == "" || c.tornDown.Load() || c.cfg.Netns != "" {
+		return false
+	}
+	if c.xSrvIP != nil && ip.Equal(c.xSrvIP.IP) && c.serverRouteNeeded(ip) {
+		return false // Routed around by the server route.
+	}
+	for _, r := range c.cfg.RoutesToTUN {
+		if (&net.IPNet{IP: r.IP, Mask: r.Mask}).Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// directRoutes are host routes of direct connections counted by users, the zero value is ready to use.
+type directRoutes struct {
+	mu     sync.Mutex
+	users  map[string]int
+	routes map[string]route.Opts
+	gen    int // Incremented by close, releases of the closed routes are ignored.
+}
+
+// acquire adds route opts to table unless it is added already.
+func (d *directRoutes) acquire(table RouteTable, opts route.Opts) (release func(), err error) {
+	key := opts.Routes[0].String()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.users[key] == 0 {
+		if err = table.Add(opts); err != nil {
+			return nil, fmt.Errorf("direct route: %w", err)
+		}
+		if d.users == nil {
+			d.users, d.routes = map[string]int{}, map[string]route.Opts{}
+		}
+		d.routes[key] = opts
+	}
+	d.users[key]++
+	gen := d.gen
+
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.gen != gen {
+			return // Deleted by close.
+		}
+		if d.users[key]--; d.users[key] == 0 {
+			_ = table.Delete(d.routes[key])
+			delete(d.users, key)
+			delete(d.routes, key)
+		}
+	}, nil
+}
+
+// close deletes routes of open connections from table, they are routed to TUN device no more.
+func (d *directRoutes) close(table RouteTable) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var err error
+	for _, opts := range d.routes {
+		err = errors.Join(err, table.Delete(opts))
+	}
+	d.users, d.routes = nil, nil
+	d.gen++
+
+	return err
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/goxray/core/network/route"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlRoute_Validate(t *testing.T) {
+	require.NoError(t, ControlRoute("").Validate())
+	require.NoError(t, ControlRouteTunnel.Validate())
+	require.NoError(t, ControlRouteDirect.Validate())
+	require.ErrorContains(t, ControlRoute("lan").Validate(), `unknown control route "lan"`)
+}
+
+func TestLoopback_ControlDialer(t *testing.T) {
+	c, _, routes, _ := newLoopbackClient(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	_, err = c.ControlDialer(ControlRouteTunnel)(t.Context(), "tcp", ln.Addr().String())
+	require.ErrorIs(t, err, ErrNotConnected)
+	conn, err := c.ControlDialer(ControlRouteDirect)(t.Context(), "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.Empty(t, routes.Routes(), "not routed while disconnected")
+
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	c.tunName = "tun0" // The loopback TUN device has no routes.
+	direct := RouteOp{Addr: "127.0.0.1/32", Gateway: "127.0.0.2"}
+	first, err := c.ControlDialer(ControlRouteDirect)(t.Context(), "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	require.Contains(t, routes.Routes(), direct, "routed around TUN device while connected")
+	second, err := c.ControlDialer(ControlRouteDirect)(t.Context(), "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, first.Close())
+	_ = first.Close() // The route is released once.
+	require.Contains(t, routes.Routes(), direct, "route is kept for the other connection")
+	require.NoError(t, second.Close())
+	require.NotContains(t, routes.Routes(), direct)
+
+	tunneled, err := c.ControlDialer("")(t.Context(), "tcp", ln.Addr().String())
+	require.NoError(t, err, "tunnel is the default")
+	require.NoError(t, tunneled.Close())
+
+	open, err := c.ControlDialer(ControlRouteDirect)(t.Context(), "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, c.Disconnect(context.Background()))
+	require.Empty(t, routes.Routes(), "direct routes are deleted by Disconnect")
+	require.NoError(t, open.Close())
+}
+
+func TestClient_routeDirect(t *testing.T) {
+	gateway := net.IPv4(192, 168, 1, 1)
+	routes := &MemoryRouteTable{}
+	c := &Client{cfg: Config{GatewayIP: &gateway, RoutesToTUN: []*route.Addr{
+		route.MustParseAddr("0.0.0.0/1"), route.MustParseAddr("::/1"),
+	}}, routes: routes, tunName: "tun0"}
+
+	release, err := c.routeDirect(net.ParseIP("200.0.0.1"))
+	require.NoError(t, err, "not routed to TUN device")
+	release()
+	_, err = c.routeDirect(net.ParseIP("2001:db8::1"))
+	require.ErrorIs(t, err, errNoDirectRoute, "no IPv6 gateway")
+	release, err = c.routeDirect(net.ParseIP("1.1.1.1"))
+	require.NoError(t, err)
+	require.Equal(t, []RouteOp{{Addr: "1.1.1.1/32", Gateway: "192.168.1.1"}}, routes.Routes())
+	release()
+	require.Empty(t, routes.Routes())
+
+	c.cfg.Netns = "vpn"
+	release, err = c.routeDirect(net.ParseIP("1.1.1.1"))
+	require.NoError(t, err, "TUN device routes are in the namespace")
+	release()
+	require.Empty(t, routes.Ops()[2:])
+}
@@ -0,0 +1,298 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultPipeQueueSize is the number of packets queued per worker, see PipeOptions.QueueSize.
+	DefaultPipeQueueSize = 256
+	// maxPipeWorkers limits PipeOptions.Workers.
+	maxPipeWorkers = 256
+	// maxPipeQueueSize limits PipeOptions.QueueSize.
+	maxPipeQueueSize = 1 << 16
+)
+
+// PipeOverflow is what the reader of the TUN device does with a packet of a worker with full queue.
+type PipeOverflow string
+
+const (
+	// PipeOverflowDrop drops the packet, TCP retransmits it and the reader keeps serving other workers.
+	PipeOverflowDrop PipeOverflow = "drop"
+	// PipeOverflowBlock waits for the worker (backpressure), packets of all workers wait behind the slow one
+	// in the TUN device and the OS drops them once its queue is full.
+	PipeOverflowBlock PipeOverflow = "block"
+)
+
+// PipeOptions configure processing of packets read from the TUN device.
+//
+// With several Workers packets are sharded by hash of the flow 5-tuple (protocol, addresses and ports), so packets
+// of a flow keep their order. The shards only parallelise queueing: reading the TUN device runs apart from
+// classification (see QoSOptions) and the handoff to the TCP/IP stack, which is shared by the workers and
+// processes the packets itself. Throughput past the ring buffers is unchanged, more Workers absorb bursts but do
+// not make the stack or the proxy faster. Each worker has a ring buffer of QueueSize packets allocated on connect,
+// so the queues take Workers * QueueSize * Config.MTU bytes.
+type PipeOptions struct {
+	// Workers hand packets read from the TUN device to the TCP/IP stack (default: 1, packets are handed by
+	// the reader).
+	Workers int
+	// QueueSize is the number of packets queued per worker, up to 65536 and rounded up to a power of two
+	// (default: DefaultPipeQueueSize).
+	QueueSize int
+	// Overflow handles packets of a worker with full queue, see PipeStats (default: PipeOverflowDrop).
+	Overflow PipeOverflow
+}
+
+// Validate checks options values.
+func (o *PipeOptions) Validate() error {
+	if o.Workers < 0 || o.Workers > maxPipeWorkers {
+		return fmt.Errorf("workers must be from 0 to %d", maxPipeWorkers)
+	}
+	if o.QueueSize < 0 || o.QueueSize > maxPipeQueueSize {
+		return fmt.Errorf("queue size must be from 0 to %d", maxPipeQueueSize)
+	}
+	switch o.Overflow {
+	case "", PipeOverflowDrop, PipeOverflowBlock:
+	default:
+		return fmt.Errorf("unknown overflow %q", o.Overflow)
+	}
+
+	return nil
+}
+
+func (o *PipeOptions) workers() int {
+	if o == nil || o.Workers == 0 {
+		return 1
+	}
+
+	return o.Workers
+}
+
+func (o *PipeOptions) queueSize() int {
+	if o == nil || o.QueueSize == 0 {
+		return DefaultPipeQueueSize
+	}
+
+	return o.QueueSize
+}
+
+func (o *PipeOptions) overflow() PipeOverflow {
+	if o == nil || o.Overflow == "" {
+		return PipeOverflowDrop
+	}
+
+	return o.Overflow
+}
+
+// PipeStats are packet counters of the TUN device pipe, see PipeOptions.
+type PipeStats struct {
+	// Shards are the worker queues of the current connection, empty if packets are processed by the reader.
+	Shards []ShardStats
+	// Dropped are packets dropped because the queue of their worker was full since the Client is created.
+	Dropped uint64
+	// Waits are times the reader waited for a worker with full queue since the Client is created, see
+	// PipeOverflowBlock.
+	Waits uint64
+}
+
+// ShardStats are counters of a worker queue.
+type ShardStats struct {
+	Queued  int    // Packets in the queue, including the one processed by the worker.
+	Dropped uint64 // Packets dropped because the queue was full since the connection is established.
+	Waits   uint64 // Times the reader waited for the full queue since the connection is established.
+}
+
+// PipeStats returns packet counters of the pipe.
+func (c *Client) PipeStats() PipeStats {
+	return c.shards.stats()
+}
+
+// packetShards distributes packets read from the TUN device to workers by flow hash, see PipeOptions.
+type packetShards struct {
+	mu        sync.Mutex
+	workers   int
+	queueSize int
+	overflow  PipeOverflow
+	// rings are the worker queues of the running copy, nil otherwise.
+	rings  []*shardRing
+	total  atomic.Uint64
+	waited atomic.Uint64
+}
+
+// shardRing is the queue of a worker with its counters.
+type shardRing struct {
+	*packetRing
+	dropped atomic.Uint64
+	waits   atomic.Uint64
+}
+
+func newPacketShards() *packetShards {
+	return &packetShards{workers: 1, queueSize: DefaultPipeQueueSize, overflow: PipeOverflowDrop}
+}
+
+// setOptions sets workers of the next copy, see PipeOptions.
+func (s *packetShards) setOptions(opts *PipeOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workers, s.queueSize, s.overflow = opts.workers(), opts.queueSize(), opts.overflow()
+}
+
+// parallel reports whether packets are processed by workers.
+func (s *packetShards) parallel() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.workers > 1
+}
+
+func (s *packetShards) stats() PipeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := PipeStats{Dropped: s.total.Load(), Waits: s.waited.Load()}
+	for _, r := range s.rings {
+		st.Shards = append(st.Shards, ShardStats{Queued: r.len(), Dropped: r.dropped.Load(), Waits: r.waits.Load()})
+	}
+
+	return st
+}
+
+// workerPanic is a panic of the worker, it is raised again by the reader of the copy.
+type workerPanic struct{ v any }
+
+func (p *workerPanic) Error() string { return fmt.Sprintf("pipe worker panic: %v", p.v) }
+
+// copy reads packets of mtu from pipe and passes them to write by workers until ctx is cancelled or pipe is
+// closed. A write error stops the copy, it is returned (a panic raised) once the next packet is read.
+func (s *packetShards) copy(ctx context.Context, pipe io.Reader, mtu int, write func([]byte) error) error {
+	s.mu.Lock()
+	rings, overflow := make([]*shardRing, s.workers), s.overflow
+	for i := range rings {
+		rings[i] = &shardRing{packetRing: newPacketRing(s.queueSize, mtu)}
+	}
+	s.rings = rings
+	s.mu.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		werr    error
+		failed  atomic.Bool
+	)
+	fail := func(err error) {
+		errOnce.Do(func() { werr = err })
+		failed.Store(true)
+	}
+	for _, r := range rings {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if v := recover(); v != nil {
+					fail(&workerPanic{v: v})
+					r.release()
+					for r.peek() != nil { // The reader must not wait for the queue.
+						r.release()
+					}
+				}
+			}()
+			for buf := r.peek(); buf != nil; buf = r.peek() {
+				if !failed.Load() {
+					if err := write(*buf); err != nil {
+						fail(err)
+					}
+				}
+				r.release()
+			}
+		}()
+	}
+	defer func() {
+		for _, r := range rings {
+			r.close()
+		}
+		wg.Wait()
+		s.mu.Lock()
+		s.rings = nil
+		s.mu.Unlock()
+	}()
+
+	scratch := make([]byte, mtu)
+	buf := &scratch
+	for {
+		n, err := pipe.Read(*buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return fmt.Errorf("read pipe: %w", err)
+		}
+		if failed.Load() {
+			if p, ok := werr.(*workerPanic); ok {
+				panic(p.v)
+			}
+			return werr
+		}
+
+		*buf = (*buf)[:n]
+		r := rings[flowHash(*buf)%uint32(len(rings))]
+		free, ok := r.push(buf)
+		if !ok && overflow == PipeOverflowBlock {
+			r.waits.Add(1)
+			s.waited.Add(1)
+			if r.waitWritable(ctx.Done()) {
+				free, ok = r.push(buf)
+			}
+		}
+		if !ok {
+			r.dropped.Add(1)
+			s.total.Add(1)
+		}
+		buf = free
+		*buf = (*buf)[:cap(*buf)]
+	}
+}
+
+// flowHash returns FNV-1a hash of the flow 5-tuple of IPv4 or IPv6 packet. Ports are hashed for unfragmented
+// TCP and UDP packets only, other packets are hashed by protocol and addresses.
+func flowHash(pkt []byte) uint32 {
+	var proto byte
+	var addrs, l4 []byte
+	switch {
+	case len(pkt) >= 20 && pkt[0]>>4 == 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		proto, addrs = pkt[9], pkt[12:20]
+		if fragmented := pkt[6]&0x3f != 0 || pkt[7] != 0; !fragmented && ihl >= 20 && len(pkt) >= ihl+4 {
+			l4 = pkt[ihl:]
+		}
+	case len(pkt) >= 40 && pkt[0]>>4 == 6:
+		proto, addrs = pkt[6], pkt[8:40]
+		if len(pkt) >= 44 {
+			l4 = pkt[40:]
+		}
+	default:
+		return 0
+	}
+
+	const prime = 16777619
+	h := uint32(2166136261)
+	h = (h ^ uint32(proto)) * prime
+	for _, b := range addrs {
+		h = (h ^ uint32(b)) * prime
+	}
+	if (proto == protoTCP || proto == protoUDP) && l4 != nil {
+		for _, b := range l4[:4] {
+			h = (h ^ uint32(b)) * prime
+		}
+	}
+
+	return h
+}
@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeOptions_Validate(t *testing.T) {
+	require.NoError(t, (&PipeOptions{}).Validate())
+	require.NoError(t, (&PipeOptions{Workers: 8, QueueSize: 1024}).Validate())
+	require.ErrorContains(t, (&PipeOptions{Workers: -1}).Validate(), "workers must be from 0 to 256")
+	require.ErrorContains(t, (&PipeOptions{Workers: 257}).Validate(), "workers must be from 0 to 256")
+	require.ErrorContains(t, (&PipeOptions{QueueSize: -1}).Validate(), "queue size must be from 0 to 65536")
+	require.ErrorContains(t, (&PipeOptions{QueueSize: 1 << 20}).Validate(), "queue size must be from 0 to 65536")
+	require.NoError(t, (&PipeOptions{Overflow: PipeOverflowBlock}).Validate())
+	require.ErrorContains(t, (&PipeOptions{Overflow: "wait"}).Validate(), `unknown overflow "wait"`)
+
+	require.Equal(t, 1, (*PipeOptions)(nil).workers())
+	require.Equal(t, DefaultPipeQueueSize, (*PipeOptions)(nil).queueSize())
+	require.Equal(t, PipeOverflowDrop, (*PipeOptions)(nil).overflow())
+}
+
+func TestFlowHash(t *testing.T) {
+	seg := tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: 40000, dstPort: 80}
+	pkt := seg.marshal()
+	seg.seq, seg.flags, seg.payload = 1000, tcpACK|tcpPSH, []byte("ping")
+	require.Equal(t, flowHash(pkt), flowHash(seg.marshal()), "packets of a flow")
+	seg.srcPort++
+	require.NotEqual(t, flowHash(pkt), flowHash(seg.marshal()), "ports are hashed")
+
+	frag := append([]byte(nil), pkt...)
+	frag[6] = 0x20 // More fragments.
+	other := append([]byte(nil), frag...)
+	other[20]++ // Source port.
+	require.Equal(t, flowHash(frag), flowHash(other), "ports of fragments are not hashed")
+	require.Zero(t, flowHash([]byte{0x45}))
+}
+
+// packetReader reads packets, then it calls before of the next packet index and returns io.EOF.
+type packetReader struct {
+	pkts   [][]byte
+	before func(i int)
+	i      int
+}
+
+func (r *packetReader) Read(p []byte) (int, error) {
+	if r.before != nil {
+		r.before(r.i)
+	}
+	if r.i == len(r.pkts) {
+		return 0, io.EOF
+	}
+	r.i++
+
+	return copy(p, r.pkts[r.i-1]), nil
+}
+
+func TestPacketShards_copy(t *testing.T) {
+	s := newPacketShards()
+	s.setOptions(&PipeOptions{Workers: 4})
+	require.True(t, s.parallel())
+
+	// Segments of flows are interleaved, each flow must be written in order.
+	var pkts [][]byte
+	for seq := range uint32(100) {
+		for port := range uint16(8) {
+			seg := tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: 40000 + port, dstPort: 80, seq: seq}
+			pkts = append(pkts, seg.marshal())
+		}
+	}
+	var mu sync.Mutex
+	written := map[uint16][]uint32{}
+	err := s.copy(context.Background(), &packetReader{pkts: pkts}, tunMTU, func(pkt []byte) error {
+		seg, ok := parseTCPSegment(pkt)
+		require.True(t, ok)
+		mu.Lock()
+		defer mu.Unlock()
+		written[seg.srcPort] = append(written[seg.srcPort], seg.seq)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, written, 8)
+	for port, seqs := range written {
+		require.Len(t, seqs, 100, port)
+		require.IsIncreasing(t, seqs, port)
+	}
+	require.Equal(t, PipeStats{}, s.stats(), "no queues after copy")
+
+	s.setOptions(nil)
+	require.False(t, s.parallel())
+	require.False(t, (*packetShards)(nil).parallel())
+}
+
+func TestPacketShards_copyDrop(t *testing.T) {
+	s := newPacketShards()
+	s.setOptions(&PipeOptions{Workers: 2, QueueSize: 2})
+
+	seg := tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: 40000, dstPort: 80}
+	pkt := seg.marshal()
+	shard := int(flowHash(pkt) % 2)
+	started, release := make(chan struct{}), make(chan struct{})
+	r := &packetReader{pkts: [][]byte{pkt, pkt, pkt, pkt}, before: func(i int) {
+		switch i {
+		case 1:
+			<-started // The worker is busy with the first packet, the second one is queued.
+		case 4:
+			st := s.stats()
+			require.Len(t, st.Shards, 2)
+			require.Equal(t, ShardStats{Queued: 2, Dropped: 2}, st.Shards[shard])
+			require.Equal(t, uint64(2), st.Dropped)
+			close(release)
+		}
+	}}
+	var writes int
+	err := s.copy(context.Background(), r, tunMTU, func([]byte) error {
+		if writes++; writes == 1 {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, writes)
+	require.Equal(t, PipeStats{Dropped: 2}, s.stats())
+}
+
+func TestPacketShards_copyBlock(t *testing.T) {
+	s := newPacketShards()
+	s.setOptions(&PipeOptions{Workers: 2, QueueSize: 1, Overflow: PipeOverflowBlock})
+
+	var pkts [][]byte
+	for seq := range uint32(4) {
+		seg := tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: 40000, dstPort: 80, seq: seq}
+		pkts = append(pkts, seg.marshal())
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		<-started
+		// The reader waits for the worker busy with the first packet.
+		require.Eventually(t, func() bool { return s.stats().Waits == 1 }, 5*time.Second, time.Millisecond)
+		close(release)
+	}()
+	var seqs []uint32
+	err := s.copy(context.Background(), &packetReader{pkts: pkts}, tunMTU, func(pkt []byte) error {
+		seg, _ := parseTCPSegment(pkt)
+		if seqs = append(seqs, seg.seq); len(seqs) == 1 {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint32{0, 1, 2, 3}, seqs, "packets are not dropped")
+	st := s.stats()
+	require.Zero(t, st.Dropped)
+	require.GreaterOrEqual(t, st.Waits, uint64(1))
+}
+
+func TestPacketShards_copyError(t *testing.T) {
+	s := newPacketShards()
+	s.setOptions(&PipeOptions{Workers: 2})
+	pkt := (&tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: 40000, dstPort: 80}).marshal()
+
+	written := make(chan struct{})
+	r := &packetReader{pkts: [][]byte{pkt, pkt}, before: func(i int) {
+		if i == 1 {
+			<-written
+		}
+	}}
+	writeErr := errors.New("write err")
+	err := s.copy(context.Background(), r, tunMTU, func([]byte) error {
+		defer close(written)
+		return writeErr
+	})
+	require.ErrorIs(t, err, writeErr)
+
+	written = make(chan struct{})
+	r = &packetReader{pkts: [][]byte{pkt, pkt}, before: func(i int) {
+		if i == 1 {
+			<-written
+		}
+	}}
+	require.PanicsWithValue(t, "write panic", func() {
+		_ = s.copy(context.Background(), r, tunMTU, func([]byte) error {
+			defer close(written)
+			panic("write panic")
+		})
+	})
+}
+
+func TestLoopback_PipeWorkers(t *testing.T) {
+	c, tun, _, _ := newLoopbackClient(t)
+	c.cfg.Pipe = &PipeOptions{Workers: 4}
+	require.NoError(t, c.Connect(loopbackScheme+"://test"))
+	t.Cleanup(func() { _ = c.Disconnect(context.Background()) })
+	require.Len(t, c.PipeStats().Shards, 4)
+
+	app := tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: 40000, dstPort: 80, seq: 1000}
+	app.flags = tcpSYN
+	tun.in <- app.marshal()
+	synAck := readSegment(t, tun)
+	require.Equal(t, app.seq+1, synAck.ack)
+	app.seq, app.ack = app.seq+1, synAck.seq+1
+	app.flags = tcpACK
+	tun.in <- app.marshal()
+	app.flags, app.payload = tcpACK|tcpPSH, []byte("ping")
+	tun.in <- app.marshal()
+
+	var echoed []byte
+	for len(echoed) < len("ping") {
+		seg := readSegment(t, tun)
+		require.Zero(t, seg.flags&tcpRST, "connection reset")
+		echoed = append(echoed, seg.payload...)
+	}
+	require.Equal(t, "ping", string(echoed))
+	require.Zero(t, c.PipeStats().Dropped)
+}
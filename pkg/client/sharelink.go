@@ -0,0 +1,345 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// ShareLink parses link and serializes it back into the standard share link notation,
+// so that links in notations only this package understands (e.g. "trojan-go://", "wg://", WireGuard config)
+// can be imported by other XRay clients. The result is a single line of URL-safe characters, suitable for QR codes.
+func ShareLink(link string) (string, error) {
+	link, err := normalizeWireGuardLink(strings.TrimSpace(link))
+	if err != nil {
+		return "", fmt.Errorf("wireguard: %w", err)
+	}
+
+	scheme, _, _ := strings.Cut(link, "://")
+	if validate, ok := engineOnlyLinks[scheme]; ok {
+		// Engine protocols links are standard already.
+		if err := validate(link); err != nil {
+			return "", fmt.Errorf("parse: %w", err)
+		}
+
+		return link, nil
+	}
+	if _, ok := lookupEngine(scheme); ok {
+		return link, nil
+	}
+
+	c := &Client{cfg: Config{
+		InboundProxy: defaultInboundProxy,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		// Keep alterId of the link, it is not validated for share links.
+		VMess: &VMessOptions{Legacy: true},
+	}}
+	xCfg, gcfg, err := c.buildXrayConfig(link)
+	if err != nil {
+		return "", err
+	}
+
+	return OutboundShareLink(&xCfg.OutboundConfigs[0], gcfg.Remark)
+}
+
+// LinkRemark returns the remark (server name shown by clients) of share link, empty if it has none.
+// It is the URL fragment, or the "ps" field of base64 JSON "vmess://" links.
+func LinkRemark(link string) string {
+	link = strings.TrimSpace(link)
+	if strings.HasPrefix(link, "vmess://") {
+		if l, err := ParseVMessLink(link); err == nil && l.Remark != "" {
+			return l.Remark
+		}
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	return u.Fragment
+}
+
+// OutboundShareLink serializes XRay outbound config into share link with remark.
+//
+// Supported protocols are vless, vmess, trojan, shadowsocks and wireguard, only the first server
+// of the outbound is used.
+func OutboundShareLink(out *conf.OutboundDetourConfig, remark string) (string, error) {
+	if out.Settings == nil {
+		return "", errors.New("outbound settings are empty")
+	}
+
+	switch out.Protocol {
+	case "vless":
+		return vlessShareLink(out, remark)
+	case "vmess":
+		return vmessShareLink(out, remark)
+	case trojanScheme:
+		return trojanShareLink(out, remark)
+	case "shadowsocks":
+		return shadowsocksShareLink(out, remark)
+	case wireguardScheme:
+		return wireguardShareLink(out, remark)
+	default:
+		return "", fmt.Errorf("share link for %q protocol is not supported", out.Protocol)
+	}
+}
+
+func vlessShareLink(out *conf.OutboundDetourConfig, remark string) (string, error) {
+	var settings conf.VLessOutboundConfig
+	if err := json.Unmarshal(*out.Settings, &settings); err != nil {
+		return "", fmt.Errorf("vless settings: %w", err)
+	}
+	if len(settings.Vnext) == 0 || len(settings.Vnext[0].Users) == 0 {
+		return "", errors.New("vless settings: no servers")
+	}
+	srv := settings.Vnext[0]
+
+	var user struct {
+		ID         string `json:"id"`
+		Flow       string `json:"flow"`
+		Encryption string `json:"encryption"`
+	}
+	if err := json.Unmarshal(srv.Users[0], &user); err != nil {
+		return "", fmt.Errorf("vless user: %w", err)
+	}
+
+	q := streamShareParams(out)
+	q.Set("encryption", "none")
+	if user.Encryption != "" {
+		q.Set("encryption", user.Encryption)
+	}
+	if user.Flow != "" {
+		q.Set("flow", user.Flow)
+	}
+
+	return shareURL("vless", url.User(user.ID), srv.Address, srv.Port, q, remark), nil
+}
+
+func vmessShareLink(out *conf.OutboundDetourConfig, remark string) (string, error) {
+	var settings conf.VMessOutboundConfig
+	if err := json.Unmarshal(*out.Settings, &settings); err != nil {
+		return "", fmt.Errorf("vmess settings: %w", err)
+	}
+	if len(settings.Receivers) == 0 || len(settings.Receivers[0].Users) == 0 {
+		return "", errors.New("vmess settings: no servers")
+	}
+	srv := settings.Receivers[0]
+
+	var user struct {
+		conf.VMessAccount
+		AlterID int `json:"alterId"`
+	}
+	if err := json.Unmarshal(srv.Users[0], &user); err != nil {
+		return "", fmt.Errorf("vmess user: %w", err)
+	}
+
+	// v2rayN notation, the only one widely supported for vmess.
+	q := streamShareParams(out)
+	if srv.Address == nil || srv.Address.Address == nil {
+		return "", errors.New("vmess settings: empty address")
+	}
+	v := map[string]string{
+		"v": "2", "ps": remark, "add": srv.Address.String(), "port": strconv.Itoa(int(srv.Port)),
+		"id": user.ID, "aid": strconv.Itoa(user.AlterID), "scy": user.Security,
+		"net": q.Get("type"), "type": q.Get("headerType"), "host": q.Get("host"), "path": q.Get("path"),
+		"tls": q.Get("security"), "sni": q.Get("sni"), "alpn": q.Get("alpn"), "fp": q.Get("fp"),
+	}
+	if v["scy"] == "" {
+		v["scy"] = VMessSecurityAuto
+	}
+	if v["net"] == "grpc" {
+		v["path"], v["type"] = q.Get("serviceName"), q.Get("mode")
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("vmess link: %w", err)
+	}
+
+	return "vmess://" + base64.StdEncoding.EncodeToString(b), nil
+}
+
+func trojanShareLink(out *conf.OutboundDetourConfig, remark string) (string, error) {
+	var settings conf.TrojanClientConfig
+	if err := json.Unmarshal(*out.Settings, &settings); err != nil {
+		return "", fmt.Errorf("trojan settings: %w", err)
+	}
+	if len(settings.Servers) == 0 {
+		return "", errors.New("trojan settings: no servers")
+	}
+	srv := settings.Servers[0]
+
+	q := streamShareParams(out)
+	if srv.Flow != "" {
+		q.Set("flow", srv.Flow)
+	}
+
+	return shareURL(trojanScheme, url.User(srv.Password), srv.Address, srv.Port, q, remark), nil
+}
+
+func shadowsocksShareLink(out *conf.OutboundDetourConfig, remark string) (string, error) {
+	var settings conf.ShadowsocksClientConfig
+	if err := json.Unmarshal(*out.Settings, &settings); err != nil {
+		return "", fmt.Errorf("shadowsocks settings: %w", err)
+	}
+	if len(settings.Servers) == 0 {
+		return "", errors.New("shadowsocks settings: no servers")
+	}
+	srv := settings.Servers[0]
+
+	// SIP002 notation: base64 encoded "method:password" user info.
+	user := base64.RawURLEncoding.EncodeToString([]byte(srv.Cipher + ":" + srv.Password))
+
+	return shareURL("ss", url.User(user), srv.Address, srv.Port, nil, remark), nil
+}
+
+func wireguardShareLink(out *conf.OutboundDetourConfig, remark string) (string, error) {
+	var settings conf.WireGuardConfig
+	if err := json.Unmarshal(*out.Settings, &settings); err != nil {
+		return "", fmt.Errorf("wireguard settings: %w", err)
+	}
+	if len(settings.Peers) == 0 {
+		return "", errors.New("wireguard settings: no peers")
+	}
+	peer := settings.Peers[0]
+
+	q := url.Values{}
+	q.Set("publickey", peer.PublicKey)
+	q.Set("address", strings.Join(settings.Address, ","))
+	if peer.PreSharedKey != "" {
+		q.Set("presharedkey", peer.PreSharedKey)
+	}
+	if settings.MTU != 0 {
+		q.Set("mtu", strconv.Itoa(int(settings.MTU)))
+	}
+
+	u := url.URL{
+		Scheme:   wireguardScheme,
+		User:     url.User(settings.SecretKey),
+		Host:     peer.Endpoint,
+		RawQuery: q.Encode(),
+		Fragment: remark,
+	}
+
+	return u.String(), nil
+}
+
+// streamShareParams converts outbound stream and mux settings into share link query parameters.
+func streamShareParams(out *conf.OutboundDetourConfig) url.Values {
+	q := url.Values{}
+	if out.MuxSettings != nil && out.MuxSettings.Enabled {
+		q.Set("mux", "1")
+		q.Set("muxConcurrency", strconv.Itoa(int(out.MuxSettings.Concurrency)))
+	}
+
+	s := out.StreamSetting
+	if s == nil {
+		q.Set("type", "tcp")
+		return q
+	}
+
+	network := "tcp"
+	if s.Network != nil && *s.Network != "" && *s.Network != "raw" {
+		network = string(*s.Network)
+	}
+	q.Set("type", network)
+
+	setNonEmpty := func(key, value string) {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+
+	switch {
+	case s.TCPSettings != nil && len(s.TCPSettings.HeaderConfig) > 0:
+		var header struct {
+			Type    string `json:"type"`
+			Request struct {
+				Path    []string            `json:"path"`
+				Headers map[string][]string `json:"headers"`
+			} `json:"request"`
+		}
+		if json.Unmarshal(s.TCPSettings.HeaderConfig, &header) == nil && header.Type == "http" {
+			q.Set("headerType", "http")
+			setNonEmpty("path", strings.Join(header.Request.Path, ","))
+			setNonEmpty("host", strings.Join(header.Request.Headers["Host"], ","))
+		}
+	case s.WSSettings != nil:
+		setNonEmpty("path", s.WSSettings.Path)
+		setNonEmpty("host", s.WSSettings.Host)
+		if s.WSSettings.Host == "" {
+			setNonEmpty("host", s.WSSettings.Headers["Host"])
+		}
+	case s.GRPCSettings != nil:
+		setNonEmpty("serviceName", s.GRPCSettings.ServiceName)
+		setNonEmpty("authority", s.GRPCSettings.Authority)
+		q.Set("mode", "gun")
+		if s.GRPCSettings.MultiMode {
+			q.Set("mode", "multi")
+		}
+	case s.HTTPUPGRADESettings != nil:
+		setNonEmpty("path", s.HTTPUPGRADESettings.Path)
+		setNonEmpty("host", s.HTTPUPGRADESettings.Host)
+	case s.XHTTPSettings != nil:
+		setNonEmpty("path", s.XHTTPSettings.Path)
+		setNonEmpty("host", s.XHTTPSettings.Host)
+		setNonEmpty("mode", s.XHTTPSettings.Mode)
+	case s.SplitHTTPSettings != nil:
+		setNonEmpty("path", s.SplitHTTPSettings.Path)
+		setNonEmpty("host", s.SplitHTTPSettings.Host)
+	}
+
+	switch s.Security {
+	case "tls":
+		q.Set("security", "tls")
+		if tls := s.TLSSettings; tls != nil {
+			setNonEmpty("sni", tls.ServerName)
+			setNonEmpty("fp", tls.Fingerprint)
+			if tls.ALPN != nil {
+				setNonEmpty("alpn", strings.Join(*tls.ALPN, ","))
+			}
+			if tls.Insecure {
+				q.Set("allowInsecure", "1")
+			}
+		}
+	case "reality":
+		q.Set("security", "reality")
+		if r := s.REALITYSettings; r != nil {
+			setNonEmpty("sni", r.ServerName)
+			setNonEmpty("fp", r.Fingerprint)
+			setNonEmpty("pbk", r.PublicKey)
+			setNonEmpty("sid", r.ShortId)
+			setNonEmpty("spx", r.SpiderX)
+		}
+	default:
+		q.Set("security", "none")
+	}
+
+	return q
+}
+
+func shareURL(scheme string, user *url.Userinfo, addr *conf.Address, port uint16, q url.Values, remark string) string {
+	host := ""
+	if addr != nil && addr.Address != nil {
+		host = addr.String()
+	}
+
+	u := url.URL{
+		Scheme:   scheme,
+		User:     user,
+		Host:     net.JoinHostPort(host, strconv.Itoa(int(port))),
+		RawQuery: q.Encode(),
+		Fragment: remark,
+	}
+
+	return u.String()
+}
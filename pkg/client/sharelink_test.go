@@ -0,0 +1,98 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func TestShareLink(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{
+			name: "vless reality",
+			link: "vless://b831381d-6324-4d53-ad4f-8cda48b30811@127.0.0.6:443?type=tcp&security=reality&sni=example.com&fp=chrome&pbk=jY_Lrg226NLJ2tGbLyEAaznQUTJfvoVSchob3DmyCj8&sid=ab&flow=xtls-rprx-vision#my%20server",
+			want: "vless://b831381d-6324-4d53-ad4f-8cda48b30811@127.0.0.6:443?encryption=none&flow=xtls-rprx-vision&fp=chrome&pbk=jY_Lrg226NLJ2tGbLyEAaznQUTJfvoVSchob3DmyCj8&security=reality&sid=ab&sni=example.com&type=tcp#my%20server",
+		},
+		{
+			name: "trojan-go",
+			link: "trojan-go://p%40ss@127.0.0.6:443?type=ws&path=%2Fws&host=cdn.example.com&sni=example.com",
+			want: "trojan://p%40ss@127.0.0.6:443?fp=chrome&host=cdn.example.com&path=%2Fws&security=tls&sni=example.com&type=ws",
+		},
+		{
+			name: "grpc with mux",
+			link: "trojan://pass@127.0.0.6:443?type=grpc&serviceName=svc&security=tls&mux=1",
+			want: "trojan://pass@127.0.0.6:443?fp=chrome&mode=gun&mux=1&muxConcurrency=8&security=tls&serviceName=svc&type=grpc",
+		},
+		{
+			name: "wireguard config",
+			link: testWireGuardConfig,
+			want: "wireguard://yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=@203.0.113.1:51820?address=10.0.0.2%2F32%2Cfd00%3A%3A2%2F128" +
+				"&mtu=1420&presharedkey=1mG3sVUQeCgR8ohB3Hq6cOx3FXX4uKdMdfBAEeAEvpU%3D&publickey=xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg%3D",
+		},
+		{
+			name: "hysteria2",
+			link: " hysteria2://auth@example.com:443?sni=example.com ",
+			want: "hysteria2://auth@example.com:443?sni=example.com",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ShareLink(test.link)
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+
+			// Share link must be parsed into the same link.
+			again, err := ShareLink(got)
+			require.NoError(t, err)
+			require.Equal(t, got, again)
+		})
+	}
+}
+
+func TestShareLink_VMess(t *testing.T) {
+	link, err := ShareLink(testVMessLink(t, 2, "aes-128-gcm"))
+	require.NoError(t, err)
+
+	l, err := ParseVMessLink(link)
+	require.NoError(t, err)
+	require.Equal(t, &VMessLink{
+		ID: "b831381d-6324-4d53-ad4f-8cda48b30811", Host: "127.0.0.4", Port: "443",
+		AlterID: 2, Security: VMessSecurityAES128GCM, Remark: "remark",
+	}, l)
+}
+
+func TestLinkRemark(t *testing.T) {
+	require.Equal(t, "remark", LinkRemark(testVMessLink(t, 0, "auto")))
+	require.Equal(t, "DE Frankfurt", LinkRemark("vless://id@127.0.0.1:443?security=none#DE%20Frankfurt"))
+	require.Equal(t, "", LinkRemark("trojan://pass@127.0.0.1:443"))
+	require.Equal(t, "", LinkRemark("[Interface]\nPrivateKey = key"))
+}
+
+func TestOutboundShareLink(t *testing.T) {
+	var out conf.OutboundDetourConfig
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"protocol": "shadowsocks",
+		"settings": {"servers": [{"address": "127.0.0.6", "port": 8388, "method": "chacha20-ietf-poly1305", "password": "secret"}]}
+	}`), &out))
+
+	link, err := OutboundShareLink(&out, "ss")
+	require.NoError(t, err)
+	require.Equal(t, "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpzZWNyZXQ@127.0.0.6:8388#ss", link)
+
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	xCfg, gcfg, err := cl.buildXrayConfig(link)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.6", gcfg.Address)
+	require.Equal(t, "shadowsocks", xCfg.OutboundConfigs[0].Protocol)
+
+	out.Protocol = "freedom"
+	_, err = OutboundShareLink(&out, "")
+	require.ErrorContains(t, err, "not supported")
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// DefaultSniffingDestOverride are protocols sniffed if SniffingOptions.DestOverride is empty.
+var DefaultSniffingDestOverride = []string{"http", "tls", "quic"}
+
+// SniffingOptions configure XRay inbound sniffing: the destination domain is read from HTTP Host,
+// TLS SNI or QUIC of the first packets, so domain routing rules match connections made to IPs
+// (everything coming from TUN). Sniffing may break protocols looking like sniffed ones, those
+// destinations can be excluded with DomainsExcluded. It is supported for protocols served by XRay core only.
+type SniffingOptions struct {
+	// Enabled turns sniffing on.
+	Enabled bool
+	// DestOverride are sniffed protocols: "http", "tls", "quic" or "fakedns" (default: DefaultSniffingDestOverride).
+	DestOverride []string
+	// RouteOnly uses the sniffed domain for routing only, connections are made to the original IP
+	// instead of resolving the domain on the server.
+	RouteOnly bool
+	// DomainsExcluded are sniffed domains not overriding the destination, e.g. "courier.push.apple.com".
+	DomainsExcluded []string
+}
+
+// Validate checks options values.
+func (o *SniffingOptions) Validate() error {
+	for _, p := range o.DestOverride {
+		switch p {
+		case "http", "tls", "quic", "fakedns":
+		default:
+			return fmt.Errorf("invalid dest override %q, must be http, tls, quic or fakedns", p)
+		}
+	}
+
+	return nil
+}
+
+// xray returns XRay inbound sniffing config for options, nil if sniffing is disabled.
+func (o *SniffingOptions) xray() *conf.SniffingConfig {
+	if o == nil || !o.Enabled {
+		return nil
+	}
+
+	dest := o.DestOverride
+	if len(dest) == 0 {
+		dest = DefaultSniffingDestOverride
+	}
+	sniffing := &conf.SniffingConfig{Enabled: true, RouteOnly: o.RouteOnly, DestOverride: (*conf.StringList)(&dest)}
+	if len(o.DomainsExcluded) > 0 {
+		sniffing.DomainsExcluded = (*conf.StringList)(&o.DomainsExcluded)
+	}
+
+	return sniffing
+}
@@ -0,0 +1,239 @@
+//go:build !goxray_nossh
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/goxray/tun/internal/socks5"
+)
+
+const (
+	sshDialTimeout       = 15 * time.Second
+	sshKeepAliveInterval = 30 * time.Second
+)
+
+func init() {
+	RegisterEngine(sshScheme, newSSHEngine)
+}
+
+// sshEngine uses SSH connection as the proxy, just like "ssh -D" dynamic port forwarding.
+//
+// SSH does not forward UDP, so only TCP traffic is tunneled.
+type sshEngine struct {
+	link    *sshLink
+	inbound Proxy
+	log     *slog.Logger
+	dial    DialFunc
+
+	agent  net.Conn // ssh-agent connection, only used during authentication.
+	client *ssh.Client
+	ln     net.Listener
+	socks  *socks5.Server
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newSSHEngine(link string, opts EngineOpts) (Engine, error) {
+	l, err := parseSSHLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sshEngine{link: l, inbound: opts.Inbound, log: opts.Logger, dial: opts.Dial}, nil
+}
+
+// dialSSH connects to SSH server with the dialer of EngineOpts, like ssh.Dial.
+func (e *sshEngine) dialSSH(cfg *ssh.ClientConfig) (*ssh.Client, error) {
+	dial := e.dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(e.link.Host, e.link.Port)
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		return nil, errors.Join(err, conn.Close())
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// ServerAddr implements Engine.
+func (e *sshEngine) ServerAddr() string {
+	return e.link.Host
+}
+
+// Start connects to SSH server and starts SOCKS5 inbound.
+func (e *sshEngine) Start() error {
+	cfg, err := e.clientConfig()
+	if err != nil {
+		return fmt.Errorf("ssh config: %w", err)
+	}
+
+	e.client, err = e.dialSSH(cfg)
+	if e.agent != nil {
+		_ = e.agent.Close()
+	}
+	if err != nil && strings.Contains(err.Error(), "unable to authenticate") {
+		return fmt.Errorf("ssh dial: %w: %w", ErrAuth, err)
+	}
+	if err != nil {
+		return fmt.Errorf("ssh dial: %w", err)
+	}
+
+	e.ln, err = net.Listen(e.inbound.Network(), e.inbound.String())
+	if err != nil {
+		return errors.Join(fmt.Errorf("listen inbound: %w", err), e.client.Close())
+	}
+
+	e.stop = make(chan struct{})
+	e.socks = socks5.NewServer(e.client.DialContext)
+	e.wg.Add(2)
+	go func() {
+		defer e.wg.Done()
+		if err := e.socks.Serve(e.ln); err != nil {
+			e.log.Error("ssh engine socks server stopped", "err", err)
+		}
+	}()
+	go func() {
+		defer e.wg.Done()
+		e.keepAlive()
+	}()
+
+	return nil
+}
+
+// Close stops SOCKS5 inbound and closes SSH connection.
+func (e *sshEngine) Close() error {
+	if e.client == nil {
+		return nil
+	}
+
+	close(e.stop)
+	err := errors.Join(e.ln.Close(), e.socks.Close(), e.client.Close())
+	e.wg.Wait()
+
+	return err
+}
+
+// keepAlive periodically checks that SSH connection is still alive.
+func (e *sshEngine) keepAlive() {
+	t := time.NewTicker(sshKeepAliveInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-t.C:
+			if _, _, err := e.client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				e.log.Warn("ssh keepalive failed", "err", err)
+			}
+		}
+	}
+}
+
+func (e *sshEngine) clientConfig() (*ssh.ClientConfig, error) {
+	hostKeyCallback, err := e.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := e.authMethods()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            e.link.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshDialTimeout,
+	}, nil
+}
+
+// authMethods collects password, private key and ssh-agent authentication methods.
+func (e *sshEngine) authMethods() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if e.link.KeyFile != "" {
+		key, err := os.ReadFile(e.link.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parse key: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			e.agent = conn
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	if e.link.Password != "" {
+		methods = append(methods, ssh.Password(e.link.Password))
+	}
+
+	if len(methods) == 0 {
+		return nil, errors.New("no authentication method: provide password, key or ssh-agent")
+	}
+
+	return methods, nil
+}
+
+// hostKeyCallback verifies server by fingerprint, known_hosts file or skips verification if insecure.
+func (e *sshEngine) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	switch {
+	case e.link.Insecure:
+		return ssh.InsecureIgnoreHostKey(), nil //nolint:gosec // explicitly requested by the link.
+	case e.link.HostKey != "":
+		return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if fp := ssh.FingerprintSHA256(key); fp != e.link.HostKey {
+				return fmt.Errorf("host key mismatch: got %s", fp)
+			}
+
+			return nil
+		}, nil
+	}
+
+	path := e.link.KnownHosts
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("locate known_hosts: %w", err)
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	cb, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("load known_hosts (use hostkey or insecure link parameter to skip): %w", err)
+	}
+
+	return cb, nil
+}
@@ -0,0 +1,153 @@
+//go:build !goxray_nossh
+
+package client
+
+import (
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHEngine(t *testing.T) {
+	echo := startTestEchoServer(t)
+	srvAddr, hostKey := startTestSSHServer(t, "user", "pass")
+	host, port, _ := net.SplitHostPort(srvAddr)
+
+	tests := []struct {
+		name    string
+		query   string
+		dial    bool   // Server is reached via EngineOpts.Dial only.
+		pass    string // Password of the link (default: "pass").
+		wantErr string
+	}{
+		{name: "insecure", query: "insecure=1"},
+		{name: "dialer", query: "insecure=1", dial: true},
+		{name: "host key", query: "hostkey=" + url.QueryEscape(ssh.FingerprintSHA256(hostKey))},
+		{name: "unescaped host key", query: "hostkey=" + ssh.FingerprintSHA256(hostKey)},
+		{name: "host key mismatch", query: "hostkey=SHA256:wrong", wantErr: "host key mismatch"},
+		{name: "wrong password", query: "insecure=1", pass: "wrong", wantErr: ErrAuth.Error()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SSH_AUTH_SOCK", "")
+			inbound := Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()}
+			opts := EngineOpts{Inbound: inbound, Logger: slog.New(slog.NewTextHandler(os.Stdout, nil))}
+			linkHost := host
+			if test.dial {
+				linkHost = "upstream.invalid"
+				opts.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+					require.Equal(t, net.JoinHostPort(linkHost, port), address)
+					return (&net.Dialer{}).DialContext(ctx, network, srvAddr)
+				}
+			}
+			link := fmt.Sprintf("ssh://user:%s@%s:%s?%s", cmp.Or(test.pass, "pass"), linkHost, port, test.query)
+			eng, err := newSSHEngine(link, opts)
+			require.NoError(t, err)
+			require.Equal(t, linkHost, eng.ServerAddr())
+
+			err = eng.Start()
+			if test.wantErr != "" {
+				require.ErrorContains(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer func() { require.NoError(t, eng.Close()) }()
+
+			conn := dialTestSOCKS(t, inbound.String(), echo)
+			defer conn.Close()
+			_, err = conn.Write([]byte("ping"))
+			require.NoError(t, err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			require.NoError(t, err)
+			require.Equal(t, "ping", string(buf))
+		})
+	}
+}
+
+// startTestSSHServer starts SSH server with password auth that supports "direct-tcpip" channels only.
+func startTestSSHServer(t *testing.T, user, pass string) (string, ssh.PublicKey) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if c.User() == user && string(p) == pass {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("wrong password")
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					if nc.ChannelType() != "direct-tcpip" {
+						_ = nc.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+					// RFC 4254 7.2: host to connect, port to connect, originator IP, originator port.
+					var req struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					if err := ssh.Unmarshal(nc.ExtraData(), &req); err != nil {
+						_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					target, err := net.Dial("tcp", net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port))))
+					if err != nil {
+						_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					ch, chReqs, err := nc.Accept()
+					if err != nil {
+						_ = target.Close()
+						continue
+					}
+					go ssh.DiscardRequests(chReqs)
+					go func() {
+						defer ch.Close()
+						defer target.Close()
+						go func() { _, _ = io.Copy(target, ch) }()
+						_, _ = io.Copy(ch, target)
+					}()
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), signer.PublicKey()
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/goxray/tun/pkg/sysproxy"
+)
+
+// setSystemProxy applies OS proxy settings, replaced in tests.
+var setSystemProxy = sysproxy.Set
+
+// saveSystemProxy returns OS proxy settings, replaced in tests.
+var saveSystemProxy = sysproxy.Save
+
+// systemProxy returns OS proxy settings pointing to the inbound proxy.
+func (c *Client) systemProxy() (sysproxy.Proxy, error) {
+	addr, err := c.localInboundAddr()
+	if err != nil {
+		return sysproxy.Proxy{}, err
+	}
+	sp := sysproxy.Proxy{SOCKS: addr, Bypass: []string{"localhost", "127.0.0.0/8", "::1"}}
+	if c.xJSON != nil {
+		// XRay socks inbound serves HTTP proxy requests as well, Engine inbounds are SOCKS5 only.
+		sp.HTTP = addr
+	}
+
+	return sp, nil
+}
+
+// localInboundAddr returns "host:port" local applications can reach the inbound proxy on.
+func (c *Client) localInboundAddr() (string, error) {
+	p := c.cfg.InboundProxy
+	if p.Path != "" {
+		return "", errors.New("not supported for unix socket inbound")
+	}
+
+	ip := p.IP
+	if ip.IsUnspecified() {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.Itoa(p.Port)), nil
+}
+
+// enableSystemProxy points OS proxy settings to the inbound proxy until restoreSystemProxy.
+func (c *Client) enableSystemProxy() error {
+	p, err := c.systemProxy()
+	if err != nil {
+		return fmt.Errorf("system proxy: %w", err)
+	}
+	if c.journal != nil {
+		// Recorded before the change, a crash while setting leaves it partially applied.
+		saved, err := saveSystemProxy()
+		if err != nil {
+			return fmt.Errorf("system proxy: %w", err)
+		}
+		c.journal.systemProxy(saved)
+	}
+	restore, err := setSystemProxy(p)
+	c.audit.record(AuditSystemProxySet, "http "+p.HTTP+" socks "+p.SOCKS, err)
+	if err != nil {
+		c.journal.systemProxy(nil)
+		return fmt.Errorf("system proxy: %w", err)
+	}
+	c.sysProxyRestore = restore
+	c.cfg.Logger.Debug("system proxy set", "http", p.HTTP, "socks", p.SOCKS)
+
+	return nil
+}
+
+// restoreSystemProxy restores OS proxy settings changed by enableSystemProxy.
+func (c *Client) restoreSystemProxy() error {
+	if c.sysProxyRestore == nil {
+		return nil
+	}
+	err := c.sysProxyRestore()
+	c.sysProxyRestore = nil
+	c.audit.record(AuditSystemProxyRestore, "", err)
+	if err != nil {
+		return fmt.Errorf("restore system proxy: %w", err)
+	}
+	c.journal.systemProxy(nil)
+
+	return nil
+}
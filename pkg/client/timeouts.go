@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// TimeoutOptions configure timeouts of proxied connections of XRay core and TCP keepalive of the server
+// connections, zero values keep XRay defaults. They apply to protocols served by XRay core only.
+//
+// XRay closes connections without data for ConnIdle, 5 minutes by default, regardless of FlowOptions.TCPIdleTimeout:
+// long-lived idle connections (SSH, IMAP IDLE) need a longer one. Timeouts are rounded up to whole seconds.
+type TimeoutOptions struct {
+	// Handshake limits the protocol handshake of new connection (default: 4s).
+	Handshake time.Duration
+	// ConnIdle closes connections without data in both directions (default: 5m).
+	ConnIdle time.Duration
+	// UplinkOnly closes connections after the downlink is closed by the server (default: 1s).
+	UplinkOnly time.Duration
+	// DownlinkOnly closes connections after the uplink is closed by the application (default: 1s).
+	DownlinkOnly time.Duration
+	// KeepAlive is the idle time and interval of TCP keepalive probes of the server connections, it keeps NAT
+	// mappings of idle connections (default: OS settings).
+	KeepAlive time.Duration
+}
+
+// Validate checks options values.
+func (o *TimeoutOptions) Validate() error {
+	for _, t := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"handshake", o.Handshake},
+		{"conn idle", o.ConnIdle},
+		{"uplink only", o.UplinkOnly},
+		{"downlink only", o.DownlinkOnly},
+		{"keepalive", o.KeepAlive},
+	} {
+		if t.d < 0 || t.d > math.MaxInt32*time.Second {
+			return fmt.Errorf("%s must be from 0 to %d seconds", t.name, math.MaxInt32)
+		}
+	}
+
+	return nil
+}
+
+// seconds returns d rounded up to whole seconds.
+func seconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// addTimeouts sets the timeout policy of cfg and TCP keepalive of the server outbound out to opts.
+func addTimeouts(cfg *conf.Config, out *conf.OutboundDetourConfig, opts *TimeoutOptions) {
+	if opts == nil || *opts == (TimeoutOptions{}) {
+		return
+	}
+
+	level := xrayPolicyLevel(cfg)
+	for _, t := range []struct {
+		d time.Duration
+		v **uint32
+	}{
+		{opts.Handshake, &level.Handshake},
+		{opts.ConnIdle, &level.ConnectionIdle},
+		{opts.UplinkOnly, &level.UplinkOnly},
+		{opts.DownlinkOnly, &level.DownlinkOnly},
+	} {
+		if t.d > 0 {
+			s := uint32(seconds(t.d))
+			*t.v = &s
+		}
+	}
+
+	if opts.KeepAlive > 0 {
+		if out.StreamSetting == nil {
+			out.StreamSetting = &conf.StreamConfig{}
+		}
+		if out.StreamSetting.SocketSettings == nil {
+			out.StreamSetting.SocketSettings = &conf.SocketConfig{}
+		}
+		sock := out.StreamSetting.SocketSettings
+		sock.TCPKeepAliveIdle = int32(seconds(opts.KeepAlive))
+		sock.TCPKeepAliveInterval = sock.TCPKeepAliveIdle
+	}
+}
+
+// xrayPolicyLevel returns the policy of user level 0 of cfg, the level of all inbounds, adding it if needed.
+func xrayPolicyLevel(cfg *conf.Config) *conf.Policy {
+	if cfg.Policy == nil {
+		cfg.Policy = &conf.PolicyConfig{}
+	}
+	if cfg.Policy.Levels == nil {
+		cfg.Policy.Levels = map[uint32]*conf.Policy{}
+	}
+	if cfg.Policy.Levels[0] == nil {
+		cfg.Policy.Levels[0] = &conf.Policy{}
+	}
+
+	return cfg.Policy.Levels[0]
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeoutOptions_Validate(t *testing.T) {
+	require.NoError(t, (&TimeoutOptions{}).Validate())
+	require.NoError(t, (&TimeoutOptions{ConnIdle: 2 * time.Hour, KeepAlive: 30 * time.Second}).Validate())
+	require.ErrorContains(t, (&TimeoutOptions{UplinkOnly: -1}).Validate(), "uplink only must be from 0 to")
+	require.ErrorContains(t, (&TimeoutOptions{KeepAlive: 1 << 62}).Validate(), "keepalive must be from 0 to")
+	require.Equal(t, int64(2), seconds(1500*time.Millisecond))
+	require.Equal(t, int64(1), seconds(time.Second))
+}
+
+func TestClient_Timeouts(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	spec, err := cl.parseLink("trojan://pass@127.0.0.5:443?security=tls")
+	require.NoError(t, err)
+	require.Nil(t, spec.xray.Policy, "XRay defaults")
+
+	cl.cfg.MemoryBudget = &MemoryOptions{Budget: 128 << 20}
+	cl.cfg.Timeouts = &TimeoutOptions{ConnIdle: 2 * time.Hour, DownlinkOnly: 1500 * time.Millisecond, KeepAlive: 30 * time.Second}
+	spec, err = cl.parseLink("trojan://pass@127.0.0.5:443?security=tls")
+	require.NoError(t, err)
+	level := spec.xray.Policy.Levels[0]
+	require.NotNil(t, level.BufferSize, "memory policy is kept")
+	require.Nil(t, level.Handshake)
+	require.Nil(t, level.UplinkOnly)
+	require.Equal(t, uint32(7200), *level.ConnectionIdle)
+	require.Equal(t, uint32(2), *level.DownlinkOnly)
+	sock := spec.xray.OutboundConfigs[0].StreamSetting.SocketSettings
+	require.Equal(t, int32(30), sock.TCPKeepAliveIdle)
+	require.Equal(t, int32(30), sock.TCPKeepAliveInterval)
+	_, err = newXrayInstance(spec.xray)
+	require.NoError(t, err)
+
+	cl.cfg.Timeouts = &TimeoutOptions{Handshake: -time.Second}
+	_, err = cl.parseLink("trojan://pass@127.0.0.5:443?security=tls")
+	require.ErrorContains(t, err, "invalid config: timeouts: handshake must be from 0 to")
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectStats are timings of the phases of the last Client.Connect, zero for phases not reached.
+type ConnectStats struct {
+	// Started is the time Connect was called.
+	Started time.Time
+	// Parse is parsing of the link and creation of XRay core instance or Engine.
+	Parse time.Duration
+	// StartProxy is start of XRay core instance or Engine, with Config.OnDemand it is on the first packet.
+	StartProxy time.Duration
+	// CreateTUN is creation of TUN device with routes to it.
+	CreateTUN time.Duration
+	// AddRoutes is routing of the server past TUN device, route isolation rule and gateway mode setup.
+	AddRoutes time.Duration
+	// Connected is the time from Started until Connect returned.
+	Connected time.Duration
+	// FirstByte is the time from Started until the first byte of a flow was received through the proxy.
+	FirstByte time.Duration
+}
+
+// ConnectStats returns timings of the last Connect, e.g. to diagnose slow connecting.
+func (c *Client) ConnectStats() ConnectStats {
+	return c.timing.stats()
+}
+
+// connectTimer records ConnectStats of the Client, phases are logged at debug level.
+type connectTimer struct {
+	mu  sync.Mutex
+	log *slog.Logger
+	st  ConnectStats
+	// waiting is set until the first byte is received through the proxy.
+	waiting atomic.Bool
+}
+
+// begin starts timing of new connection logged to log.
+func (t *connectTimer) begin(log *slog.Logger) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.log, t.st = log, ConnectStats{Started: time.Now()}
+	t.waiting.Store(true)
+}
+
+// connectPhase is a phase of Connect timed by connectTimer.
+type connectPhase string
+
+const (
+	phaseParse      connectPhase = "parse"
+	phaseStartProxy connectPhase = "start proxy"
+	phaseCreateTUN  connectPhase = "create tun"
+	phaseAddRoutes  connectPhase = "add routes"
+	phaseConnected  connectPhase = "connected"
+	phaseFirstByte  connectPhase = "first byte"
+)
+
+// phase records the phase started at since, it returns the current time as the start of the next phase.
+// Connected and FirstByte are timed since ConnectStats.Started.
+func (t *connectTimer) phase(p connectPhase, since time.Time) time.Time {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p == phaseConnected || p == phaseFirstByte {
+		since = t.st.Started
+	}
+	d := now.Sub(since)
+	switch p {
+	case phaseParse:
+		t.st.Parse = d
+	case phaseStartProxy:
+		t.st.StartProxy = d
+	case phaseCreateTUN:
+		t.st.CreateTUN = d
+	case phaseAddRoutes:
+		t.st.AddRoutes = d
+	case phaseConnected:
+		t.st.Connected = d
+	case phaseFirstByte:
+		t.st.FirstByte = d
+	}
+	if t.log != nil {
+		t.log.Debug("connect phase done", "phase", string(p), "took", d)
+	}
+
+	return now
+}
+
+// received records the first byte received through the proxy, it is called on every read of flows
+// and returns right away after the first one. t may be nil.
+func (t *connectTimer) received() {
+	if t == nil || !t.waiting.Load() || !t.waiting.CompareAndSwap(true, false) {
+		return
+	}
+	t.phase(phaseFirstByte, time.Time{})
+}
+
+func (t *connectTimer) stats() ConnectStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.st
+}
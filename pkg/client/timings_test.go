@@ -0,0 +1,35 @@
+package client
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectTimer(t *testing.T) {
+	(*connectTimer)(nil).received()
+
+	var timer connectTimer
+	timer.received()
+	require.Zero(t, timer.stats().FirstByte, "not connecting")
+
+	timer.begin(slog.New(slog.DiscardHandler))
+	start := timer.stats().Started
+	next := timer.phase(phaseParse, start.Add(-time.Second))
+	require.GreaterOrEqual(t, timer.stats().Parse, time.Second)
+	timer.phase(phaseCreateTUN, next)
+	timer.phase(phaseConnected, time.Time{})
+	timer.received()
+	st := timer.stats()
+	require.Less(t, st.CreateTUN, time.Second)
+	require.Equal(t, start, st.Started)
+	require.NotZero(t, st.FirstByte)
+	require.GreaterOrEqual(t, st.FirstByte, st.Connected)
+
+	timer.received()
+	require.Equal(t, st.FirstByte, timer.stats().FirstByte, "recorded once")
+	timer.begin(nil)
+	require.Zero(t, timer.stats().FirstByte, "reset by the next connect")
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+const (
+	trojanScheme   = "trojan"
+	trojanGoScheme = "trojan-go"
+)
+
+// normalizeTrojanGoLink converts "trojan-go://" link into equivalent "trojan://" link, other links are returned unchanged.
+//
+// See https://p4gefau1t.github.io/trojan-go/developer/url/ for trojan-go link format.
+func normalizeTrojanGoLink(link string) (string, error) {
+	if !strings.HasPrefix(link, trojanGoScheme+"://") {
+		return link, nil
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("parse url: %w", err)
+	}
+
+	q := u.Query()
+	if enc := q.Get("encryption"); enc != "" && enc != "none" {
+		return "", fmt.Errorf("trojan-go encryption %q is not supported", enc)
+	}
+	if plugin := q.Get("plugin"); plugin != "" {
+		return "", fmt.Errorf("trojan-go plugin %q is not supported", plugin)
+	}
+	q.Del("encryption")
+	q.Del("plugin")
+
+	switch q.Get("type") {
+	case "", "original":
+		q.Set("type", "tcp")
+	case "ws":
+	default:
+		return "", fmt.Errorf("trojan-go transport %q is not supported", q.Get("type"))
+	}
+	q.Set("security", "tls") // trojan-go always uses TLS.
+
+	u.Scheme = trojanScheme
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// fixTrojanOutbound fixes trojan outbound settings XRay-knife builds incompletely:
+// escaped passwords, ALPN lists, websocket host and gRPC mode.
+func fixTrojanOutbound(t *xray.Trojan, out *conf.OutboundDetourConfig) error {
+	// XRay-knife keeps password url-escaped and injects it into json without escaping.
+	password, err := url.PathUnescape(t.Password)
+	if err != nil {
+		return fmt.Errorf("trojan password: %w", err)
+	}
+	port, err := strconv.ParseUint(t.Port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("trojan port %q: %w", t.Port, err)
+	}
+	settings, err := json.Marshal(conf.TrojanClientConfig{Servers: []*conf.TrojanServerTarget{{
+		Address:  &conf.Address{Address: xnet.ParseAddress(t.Address)},
+		Port:     uint16(port),
+		Password: password,
+		Flow:     t.Flow,
+	}}})
+	if err != nil {
+		return fmt.Errorf("trojan settings: %w", err)
+	}
+	raw := json.RawMessage(settings)
+	out.Settings = &raw
+
+	s := out.StreamSetting
+	if s == nil {
+		return nil
+	}
+
+	if s.TLSSettings != nil && t.ALPN != "" {
+		alpn := conf.StringList(strings.Split(t.ALPN, ","))
+		s.TLSSettings.ALPN = &alpn
+	}
+
+	if s.WSSettings != nil {
+		delete(s.WSSettings.Headers, "Host")
+		s.WSSettings.Host = t.Host
+	}
+
+	if s.GRPCSettings != nil {
+		// "gun" is the default gRPC mode, multi mode must be requested explicitly.
+		s.GRPCSettings.MultiMode = t.Mode == "multi"
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func TestNormalizeTrojanGoLink(t *testing.T) {
+	link, err := normalizeTrojanGoLink("trojan-go://pass@example.com:443?sni=sni.example.com&type=ws&host=cdn.example.com&path=%2Fws#remark")
+	require.NoError(t, err)
+	require.Equal(t, "trojan://pass@example.com:443?host=cdn.example.com&path=%2Fws&security=tls&sni=sni.example.com&type=ws#remark", link)
+
+	link, err = normalizeTrojanGoLink("trojan-go://pass@example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, "trojan://pass@example.com:443?security=tls&type=tcp", link)
+
+	_, err = normalizeTrojanGoLink("trojan-go://pass@example.com:443?encryption=ss%3Baes-128-gcm%3Bsecret")
+	require.ErrorContains(t, err, "encryption")
+
+	link, err = normalizeTrojanGoLink("vless://id@example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, "vless://id@example.com:443", link)
+}
+
+func TestCreateProxy_Trojan(t *testing.T) {
+	tests := []struct {
+		name   string
+		link   string
+		assert func(t *testing.T, out conf.OutboundDetourConfig)
+	}{
+		{
+			name: "websocket with mux",
+			link: "trojan://p%40ss%22word@127.0.0.3:443?type=ws&host=cdn.example.com&path=%2Fws&alpn=h2,http/1.1&mux=1&muxConcurrency=4#ws",
+			assert: func(t *testing.T, out conf.OutboundDetourConfig) {
+				require.Equal(t, "ws", string(*out.StreamSetting.Network))
+				require.Equal(t, "cdn.example.com", out.StreamSetting.WSSettings.Host)
+				require.Equal(t, "/ws", out.StreamSetting.WSSettings.Path)
+				require.NotContains(t, out.StreamSetting.WSSettings.Headers, "Host")
+				require.Equal(t, conf.StringList{"h2", "http/1.1"}, *out.StreamSetting.TLSSettings.ALPN)
+				require.Equal(t, &conf.MuxConfig{Enabled: true, Concurrency: 4}, out.MuxSettings)
+
+				var settings conf.TrojanClientConfig
+				require.NoError(t, json.Unmarshal(*out.Settings, &settings))
+				require.Equal(t, `p@ss"word`, settings.Servers[0].Password)
+				require.EqualValues(t, 443, settings.Servers[0].Port)
+			},
+		},
+		{
+			name: "grpc",
+			link: "trojan://pass@127.0.0.3:443?type=grpc&serviceName=svc&sni=example.com",
+			assert: func(t *testing.T, out conf.OutboundDetourConfig) {
+				require.Equal(t, "grpc", string(*out.StreamSetting.Network))
+				require.Equal(t, "svc", out.StreamSetting.GRPCSettings.ServiceName)
+				require.False(t, out.StreamSetting.GRPCSettings.MultiMode)
+				require.Nil(t, out.MuxSettings)
+			},
+		},
+		{
+			name: "trojan-go",
+			link: "trojan-go://pass@127.0.0.3:443?type=ws&path=%2Fgo",
+			assert: func(t *testing.T, out conf.OutboundDetourConfig) {
+				require.Equal(t, "ws", string(*out.StreamSetting.Network))
+				require.Equal(t, "tls", out.StreamSetting.Security)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cl := newTestClient(nil, nil, nil, nil, nil)
+
+			inst, gcfg, err := cl.createProxy(test.link)
+			require.NoError(t, err)
+			require.NotNil(t, inst)
+			require.Equal(t, "127.0.0.3", gcfg.Address)
+
+			xCfg, _, err := cl.buildXrayConfig(test.link)
+			require.NoError(t, err)
+			require.Len(t, xCfg.OutboundConfigs, 1)
+			test.assert(t, xCfg.OutboundConfigs[0])
+		})
+	}
+}
+
+func TestCreateProxy_InvalidMux(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+
+	_, _, err := cl.createProxy("trojan://pass@127.0.0.3:443?mux=1&muxConcurrency=many")
+	require.ErrorContains(t, err, "invalid mux concurrency")
+}
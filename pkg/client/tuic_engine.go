@@ -0,0 +1,57 @@
+//go:build !goxray_notuic
+
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sagernet/sing-quic/tuic"
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+func init() {
+	RegisterEngine(tuicScheme, newTUICEngine)
+}
+
+// newTUICEngine creates Engine relaying TCP connections and UDP datagrams over TUIC v5 connection,
+// link settings are overridden by EngineOpts.TUIC.
+func newTUICEngine(link string, opts EngineOpts) (Engine, error) {
+	l, err := ParseTUICLink(link)
+	if err != nil {
+		return nil, err
+	}
+	l.Apply(opts.TUIC)
+	uuid, err := hex.DecodeString(strings.ReplaceAll(l.UUID, "-", ""))
+	if err != nil || len(uuid) != 16 {
+		return nil, fmt.Errorf("invalid uuid %q", l.UUID)
+	}
+	port, _ := strconv.ParseUint(l.Port, 10, 16)
+	serverName := l.SNI
+	if serverName == "" {
+		serverName = l.Host
+	}
+
+	return &quicEngine{
+		name: tuicScheme, host: l.Host, inbound: opts.Inbound, log: opts.Logger,
+		newClient: func(ctx context.Context) (quicClient, error) {
+			c, err := tuic.NewClient(tuic.ClientOptions{
+				Context:           ctx,
+				Dialer:            newQUICDialer(opts.Dial),
+				ServerAddress:     M.ParseSocksaddrHostPort(l.Host, uint16(port)),
+				TLSConfig:         newQUICTLSConfig(serverName, !l.DisableSNI, l.ALPN, l.Insecure || opts.TLSAllowInsecure, ""),
+				UUID:              [16]byte(uuid),
+				Password:          l.Password,
+				CongestionControl: l.CongestionControl,
+				UDPStream:         l.UDPRelayMode == TUICUDPRelayQUIC,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return c, nil
+		},
+	}, nil
+}
@@ -0,0 +1,641 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eycorsican/go-tun2socks/core"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// DefaultUDPSessionCache is how long the association of a closed UDP flow is kept for its source,
+	// see UDPOptions.SessionCache.
+	DefaultUDPSessionCache = 30 * time.Second
+	// DefaultUDPBatch is the most datagrams sent to the inbound by one system call, see UDPOptions.Batch.
+	DefaultUDPBatch = 16
+	// maxUDPBatch limits UDPOptions.Batch, it is the iovec limit of sendmmsg.
+	maxUDPBatch = 1024
+	// maxUDPSpareSessions limits UDPOptions.SpareSessions.
+	maxUDPSpareSessions = 64
+	// maxUDPPacket is the largest SOCKS5 UDP packet.
+	maxUDPPacket = 65535
+)
+
+// UDPOptions configure relaying of UDP flows to the inbound proxy over SOCKS5 UDP ASSOCIATE.
+//
+// Every source address of the TUN device (e.g. a DNS query from a random port) needs an association: a TCP
+// connection with SOCKS5 handshake and a UDP socket. Associations of closed flows are kept for SessionCache and
+// reused by the next flow of their source, SpareSessions are established ahead for new sources.
+type UDPOptions struct {
+	// SessionCache is how long the association of a closed flow is kept for the next flow of its source
+	// (default: DefaultUDPSessionCache).
+	SessionCache time.Duration
+	// SpareSessions are associations established ahead for new sources, e.g. 4 for DNS-heavy workloads
+	// (default: 0, established by the first datagram of the source).
+	SpareSessions int
+	// Batch is the most datagrams of a flow sent to the inbound by one system call, sendmmsg on Linux,
+	// 1 sends them one by one (default: DefaultUDPBatch).
+	Batch int
+}
+
+// Validate checks options values.
+func (o *UDPOptions) Validate() error {
+	if o.SessionCache < 0 {
+		return errors.New("session cache must not be negative")
+	}
+	if o.SpareSessions < 0 || o.SpareSessions > maxUDPSpareSessions {
+		return fmt.Errorf("spare sessions must be from 0 to %d", maxUDPSpareSessions)
+	}
+	if o.Batch < 0 || o.Batch > maxUDPBatch {
+		return fmt.Errorf("batch must be from 0 to %d", maxUDPBatch)
+	}
+
+	return nil
+}
+
+func (o *UDPOptions) sessionCache() time.Duration {
+	if o == nil || o.SessionCache == 0 {
+		return DefaultUDPSessionCache
+	}
+
+	return o.SessionCache
+}
+
+func (o *UDPOptions) spareSessions() int {
+	if o == nil {
+		return 0
+	}
+
+	return o.SpareSessions
+}
+
+func (o *UDPOptions) batch() int {
+	if o == nil || o.Batch == 0 {
+		return DefaultUDPBatch
+	}
+
+	return o.Batch
+}
+
+// UDPStats are counters of SOCKS5 UDP associations, see UDPOptions.
+type UDPStats struct {
+	Active int // Associations of UDP flows.
+	Cached int // Associations of closed flows kept for their source.
+	Spare  int // Associations established ahead for new sources.
+	// Established are associations established since the Client is created.
+	Established uint64
+	// Reused are flows given a cached or spare association since the Client is created.
+	Reused uint64
+	// Dropped are datagrams dropped because the send queue of their association was full.
+	Dropped uint64
+}
+
+// UDPStats returns counters of UDP associations.
+func (c *Client) UDPStats() UDPStats {
+	return c.udp.stats()
+}
+
+// udpRelay creates UDP handlers of the pipe and keeps their counters, see UDPOptions.
+type udpRelay struct {
+	mu       sync.Mutex
+	opts     *UDPOptions
+	timeouts socksTimeouts
+	handlers []*socksUDPHandler
+
+	established atomic.Uint64
+	reused      atomic.Uint64
+	dropped     atomic.Uint64
+}
+
+func newUDPRelay() *udpRelay {
+	return &udpRelay{timeouts: (*FlowOptions)(nil).socksTimeouts()}
+}
+
+// setOptions sets options of the handlers created next.
+func (r *udpRelay) setOptions(opts *UDPOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.opts = opts
+}
+
+// setTimeouts sets proxy timeouts of new associations of the handlers created next, see FlowOptions.
+func (r *udpRelay) setTimeouts(t socksTimeouts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeouts = t
+}
+
+// handler returns new handler relaying UDP flows to socks5 proxy address, flows without datagrams from
+// the proxy for timeout are closed.
+func (r *udpRelay) handler(socks5 string, timeout time.Duration) *socksUDPHandler {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := &socksUDPHandler{
+		relay:    r,
+		proxy:    socks5,
+		timeout:  timeout,
+		timeouts: r.timeouts,
+		cache:    r.opts.sessionCache(),
+		spares:   r.opts.spareSessions(),
+		batch:    r.opts.batch(),
+		sessions: make(map[core.UDPConn]*udpSession),
+		cached:   make(map[string]*udpSession),
+	}
+	r.handlers = append(r.handlers, h)
+
+	return h
+}
+
+// close closes handlers and their associations, it is called when the pipe stops.
+func (r *udpRelay) close() {
+	r.mu.Lock()
+	handlers := r.handlers
+	r.handlers = nil
+	r.mu.Unlock()
+
+	for _, h := range handlers {
+		h.close()
+	}
+}
+
+func (r *udpRelay) stats() UDPStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st := UDPStats{Established: r.established.Load(), Reused: r.reused.Load(), Dropped: r.dropped.Load()}
+	for _, h := range r.handlers {
+		h.mu.Lock()
+		st.Active += len(h.sessions)
+		st.Cached += len(h.cached)
+		st.Spare += len(h.spare)
+		h.mu.Unlock()
+	}
+
+	return st
+}
+
+// udpBufs are SOCKS5 UDP packets sent to the inbound.
+var udpBufs = sync.Pool{New: func() any {
+	buf := make([]byte, 0, 2048)
+	return &buf
+}}
+
+// socksUDPHandler relays UDP flows of the pipe over SOCKS5 UDP associations, one per flow. It replaces
+// go-tun2socks handler establishing an association per flow and allocating headers per datagram.
+type socksUDPHandler struct {
+	relay   *udpRelay
+	proxy   string
+	timeout time.Duration
+	// timeouts limit establishing of new association.
+	timeouts socksTimeouts
+	cache    time.Duration
+	spares   int
+	batch    int
+
+	mu       sync.Mutex
+	sessions map[core.UDPConn]*udpSession
+	cached   map[string]*udpSession // Associations of closed flows by source address.
+	spare    []*udpSession
+	filling  bool
+	closed   bool
+}
+
+// udpSession is SOCKS5 UDP association: the TCP connection it lasts with and the UDP socket connected
+// to the relay address of the inbound.
+type udpSession struct {
+	ctrl  net.Conn
+	pc    *net.UDPConn
+	send  chan *[]byte
+	done  chan struct{}
+	close sync.Once
+
+	mu       sync.Mutex
+	owner    core.UDPConn // Flow of the association, nil if it is cached or spare.
+	src      string       // Source address of the owner or the cached flow.
+	deadline time.Time
+}
+
+func (h *socksUDPHandler) Connect(conn core.UDPConn, target *net.UDPAddr) error {
+	src := conn.LocalAddr().String()
+	h.mu.Lock()
+	s := h.cached[src]
+	delete(h.cached, src)
+	if n := len(h.spare); s == nil && n > 0 {
+		s, h.spare = h.spare[n-1], h.spare[:n-1]
+	}
+	h.mu.Unlock()
+	h.fillSpare()
+
+	if s != nil {
+		h.relay.reused.Add(1)
+	} else {
+		var err error
+		if s, err = h.associate(); err != nil {
+			return fmt.Errorf("udp associate %s: %w", target, err)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		s.shutdown()
+		return errors.New("udp relay is closed")
+	}
+	h.sessions[conn] = s
+	s.assign(conn, src, h.timeout)
+
+	return nil
+}
+
+func (h *socksUDPHandler) ReceiveTo(conn core.UDPConn, data []byte, addr *net.UDPAddr) error {
+	h.mu.Lock()
+	s, ok := h.sessions[conn]
+	h.mu.Unlock()
+	if !ok {
+		h.Close(conn)
+		return fmt.Errorf("udp flow %s -> %s does not exist", conn.LocalAddr(), addr)
+	}
+
+	buf := udpBufs.Get().(*[]byte)
+	*buf = append(appendSOCKSUDPHeader((*buf)[:0], addr), data...)
+	select {
+	case s.send <- buf:
+	default:
+		udpBufs.Put(buf)
+		h.relay.dropped.Add(1)
+	}
+
+	return nil
+}
+
+// Close closes the flow of conn, its association is cached for the source.
+func (h *socksUDPHandler) Close(conn core.UDPConn) {
+	_ = conn.Close()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[conn]
+	if !ok {
+		return
+	}
+	delete(h.sessions, conn)
+	src := s.park(h.cache)
+	if h.closed {
+		s.shutdown()
+		return
+	}
+	if old := h.cached[src]; old != nil {
+		old.shutdown()
+	}
+	h.cached[src] = s
+}
+
+// close shuts all associations down.
+func (h *socksUDPHandler) close() {
+	h.mu.Lock()
+	h.closed = true
+	sessions := h.spare
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	for _, s := range h.cached {
+		sessions = append(sessions, s)
+	}
+	h.sessions, h.cached, h.spare = map[core.UDPConn]*udpSession{}, map[string]*udpSession{}, nil
+	h.mu.Unlock()
+
+	for _, s := range sessions {
+		s.shutdown()
+	}
+}
+
+// drop removes the association s which is broken or expired, its flow is closed.
+func (h *socksUDPHandler) drop(s *udpSession) {
+	s.shutdown()
+
+	h.mu.Lock()
+	s.mu.Lock()
+	owner, src := s.owner, s.src
+	s.mu.Unlock()
+	if owner != nil && h.sessions[owner] == s {
+		delete(h.sessions, owner)
+	}
+	if h.cached[src] == s {
+		delete(h.cached, src)
+	}
+	for i, spare := range h.spare {
+		if spare == s {
+			h.spare = append(h.spare[:i], h.spare[i+1:]...)
+			break
+		}
+	}
+	h.mu.Unlock()
+
+	if owner != nil {
+		_ = owner.Close()
+	}
+}
+
+// fillSpare establishes spare associations in background.
+func (h *socksUDPHandler) fillSpare() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.filling || h.closed || len(h.spare) >= h.spares {
+		return
+	}
+	h.filling = true
+
+	go func() {
+		for {
+			h.mu.Lock()
+			if h.closed || len(h.spare) >= h.spares {
+				h.filling = false
+				h.mu.Unlock()
+				return
+			}
+			h.mu.Unlock()
+
+			s, err := h.associate()
+			h.mu.Lock()
+			if err != nil || h.closed {
+				h.filling = false
+				h.mu.Unlock()
+				if s != nil {
+					s.shutdown()
+				}
+				return // Retried by the next flow.
+			}
+			h.spare = append(h.spare, s)
+			h.mu.Unlock()
+		}
+	}()
+}
+
+// associate establishes new association with the proxy.
+func (h *socksUDPHandler) associate() (*udpSession, error) {
+	ctrl, err := net.DialTimeout("tcp", h.proxy, h.timeouts.dial)
+	if err != nil {
+		return nil, err
+	}
+	relay, err := socksUDPAssociate(ctrl, h.timeouts.handshake)
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, err
+	}
+	if relay.IP.IsUnspecified() { // The inbound listens on all addresses.
+		relay.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+	pc, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, fmt.Errorf("dial relay: %w", err)
+	}
+
+	s := &udpSession{ctrl: ctrl, pc: pc, send: make(chan *[]byte, 4*h.batch), done: make(chan struct{})}
+	go func() {
+		// The association lasts as long as the TCP connection.
+		_, _ = io.Copy(io.Discard, ctrl)
+		h.drop(s)
+	}()
+	go h.read(s)
+	go s.write(h.batch, relay.IP.To4() != nil)
+	h.relay.established.Add(1)
+
+	return s, nil
+}
+
+// read writes datagrams of the association to its flow. The flow is closed if there are none for the idle
+// timeout, the association is dropped if it is cached for longer than the session cache.
+func (h *socksUDPHandler) read(s *udpSession) {
+	buf := make([]byte, maxUDPPacket)
+	var from *net.UDPAddr
+	for {
+		n, err := s.pc.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			s.mu.Lock()
+			owner, expired := s.owner, !time.Now().Before(s.deadline)
+			s.mu.Unlock()
+			switch {
+			case !expired: // Reassigned meanwhile.
+			case owner != nil:
+				h.Close(owner)
+			default:
+				h.drop(s)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			h.drop(s)
+			return
+		}
+
+		s.mu.Lock()
+		owner := s.owner
+		if owner != nil {
+			s.extend(h.timeout)
+		}
+		s.mu.Unlock()
+		if owner == nil {
+			continue // Late datagram of the closed flow.
+		}
+		payload, addr, ok := parseSOCKSUDP(buf[:n], from)
+		if !ok {
+			continue
+		}
+		from = addr
+		if _, err := owner.WriteFrom(payload, addr); err != nil {
+			h.Close(owner)
+		}
+	}
+}
+
+// write sends queued datagrams to the relay address in batches of up to batch.
+func (s *udpSession) write(batch int, ip4 bool) {
+	var conn interface {
+		WriteBatch([]ipv4.Message, int) (int, error)
+	}
+	if ip4 {
+		conn = ipv4.NewPacketConn(s.pc)
+	} else {
+		conn = ipv6.NewPacketConn(s.pc)
+	}
+	msgs, bufs := make([]ipv4.Message, batch), make([]*[]byte, batch)
+	for i := range msgs {
+		msgs[i].Buffers = make([][]byte, 1)
+	}
+
+	for {
+		var n int
+		select {
+		case bufs[0] = <-s.send:
+			n = 1
+		case <-s.done:
+			return
+		}
+	drain:
+		for n < batch {
+			select {
+			case bufs[n] = <-s.send:
+				n++
+			default:
+				break drain
+			}
+		}
+
+		for i := range n {
+			msgs[i].Buffers[0] = *bufs[i]
+		}
+		for sent := 0; sent < n; {
+			k, err := conn.WriteBatch(msgs[sent:n], 0)
+			if err != nil {
+				break // Datagrams are lost, the flow is closed by the reader if the association is broken.
+			}
+			sent += k
+		}
+		for i := range n {
+			msgs[i].Buffers[0] = nil
+			udpBufs.Put(bufs[i])
+		}
+	}
+}
+
+// assign gives the association to the flow of conn from src.
+func (s *udpSession) assign(conn core.UDPConn, src string, timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owner, s.src = conn, src
+	s.extend(timeout)
+}
+
+// park releases the association of the flow for cache, it returns the source address of the flow.
+func (s *udpSession) park(cache time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owner = nil
+	s.extend(cache)
+
+	return s.src
+}
+
+// extend sets the read deadline d from now, s.mu must be held.
+func (s *udpSession) extend(d time.Duration) {
+	s.deadline = time.Now().Add(d)
+	_ = s.pc.SetReadDeadline(s.deadline)
+}
+
+// shutdown closes the association, it is idempotent.
+func (s *udpSession) shutdown() {
+	s.close.Do(func() {
+		close(s.done)
+		_ = s.ctrl.Close()
+		_ = s.pc.Close()
+	})
+}
+
+// socksUDPAssociate requests UDP association without authentication on conn within timeout, it returns the relay
+// address.
+func socksUDPAssociate(conn net.Conn, timeout time.Duration) (*net.UDPAddr, error) {
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	// VER NMETHODS METHODS.
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		return nil, fmt.Errorf("socks5 greeting: %w", err)
+	}
+	buf := make([]byte, net.IPv6len+2)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, fmt.Errorf("socks5 method: %w", err)
+	}
+	if buf[0] != 5 || buf[1] != 0 {
+		return nil, fmt.Errorf("socks5 method %d is not supported", buf[1])
+	}
+	// VER CMD RSV ATYP DST.ADDR DST.PORT of any source.
+	if _, err := conn.Write([]byte{5, 3, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, fmt.Errorf("socks5 request: %w", err)
+	}
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return nil, fmt.Errorf("socks5 reply: %w", err)
+	}
+	if buf[1] != 0 {
+		return nil, fmt.Errorf("socks5 udp associate failed: reply %d", buf[1])
+	}
+	var ipLen int
+	switch buf[3] {
+	case 1:
+		ipLen = net.IPv4len
+	case 4:
+		ipLen = net.IPv6len
+	default:
+		return nil, fmt.Errorf("socks5 relay address type %d is not supported", buf[3])
+	}
+	if _, err := io.ReadFull(conn, buf[:ipLen+2]); err != nil {
+		return nil, fmt.Errorf("socks5 reply: %w", err)
+	}
+
+	return &net.UDPAddr{IP: net.IP(buf[:ipLen]), Port: int(binary.BigEndian.Uint16(buf[ipLen:]))}, nil
+}
+
+// appendSOCKSUDPHeader appends SOCKS5 UDP request header of dst to b: RSV FRAG ATYP DST.ADDR DST.PORT.
+func appendSOCKSUDPHeader(b []byte, dst *net.UDPAddr) []byte {
+	b = append(b, 0, 0, 0)
+	if ip4 := dst.IP.To4(); ip4 != nil {
+		b = append(append(b, 1), ip4...)
+	} else {
+		b = append(append(b, 4), dst.IP.To16()...)
+	}
+
+	return binary.BigEndian.AppendUint16(b, uint16(dst.Port))
+}
+
+// parseSOCKSUDP returns the payload and source address of SOCKS5 UDP reply packet, fragments are not
+// supported. The address last is returned if it is the source, so replies of a peer do not allocate.
+func parseSOCKSUDP(pkt []byte, last *net.UDPAddr) ([]byte, *net.UDPAddr, bool) {
+	if len(pkt) < 4 || pkt[2] != 0 {
+		return nil, nil, false
+	}
+
+	var ip net.IP
+	var rest []byte
+	switch pkt[3] {
+	case 1:
+		if len(pkt) < 4+net.IPv4len+2 {
+			return nil, nil, false
+		}
+		ip, rest = pkt[4:4+net.IPv4len], pkt[4+net.IPv4len:]
+	case 4:
+		if len(pkt) < 4+net.IPv6len+2 {
+			return nil, nil, false
+		}
+		ip, rest = pkt[4:4+net.IPv6len], pkt[4+net.IPv6len:]
+	case 3: // Domain, it is resolved.
+		if len(pkt) < 5 || len(pkt) < 5+int(pkt[4])+2 {
+			return nil, nil, false
+		}
+		host, port := string(pkt[5:5+int(pkt[4])]), binary.BigEndian.Uint16(pkt[5+int(pkt[4]):])
+		addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err != nil {
+			return nil, nil, false
+		}
+		return pkt[5+int(pkt[4])+2:], addr, true
+	default:
+		return nil, nil, false
+	}
+
+	port := int(binary.BigEndian.Uint16(rest))
+	if last != nil && last.Port == port && last.IP.Equal(ip) {
+		return rest[2:], last, true
+	}
+
+	return rest[2:], &net.UDPAddr{IP: append(net.IP(nil), ip...), Port: port}, true
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func TestUDPOptions_Validate(t *testing.T) {
+	require.NoError(t, (&UDPOptions{}).Validate())
+	require.NoError(t, (&UDPOptions{SessionCache: time.Minute, SpareSessions: 4, Batch: 32}).Validate())
+	require.ErrorContains(t, (&UDPOptions{SessionCache: -1}).Validate(), "session cache must not be negative")
+	require.ErrorContains(t, (&UDPOptions{SpareSessions: 65}).Validate(), "spare sessions must be from 0 to 64")
+	require.ErrorContains(t, (&UDPOptions{Batch: -1}).Validate(), "batch must be from 0 to 1024")
+
+	var o *UDPOptions
+	require.Equal(t, DefaultUDPSessionCache, o.sessionCache())
+	require.Zero(t, o.spareSessions())
+	require.Equal(t, DefaultUDPBatch, o.batch())
+}
+
+func TestSOCKSUDPHeader(t *testing.T) {
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.IPv4(1, 1, 1, 1), Port: 53},
+		{IP: net.ParseIP("2606:4700:4700::1111"), Port: 443},
+	} {
+		pkt := append(appendSOCKSUDPHeader(nil, addr), "query"...)
+		payload, from, ok := parseSOCKSUDP(pkt, nil)
+		require.True(t, ok, addr)
+		require.Equal(t, "query", string(payload))
+		require.Equal(t, addr.String(), from.String())
+
+		_, last, ok := parseSOCKSUDP(pkt, from)
+		require.True(t, ok)
+		require.Same(t, from, last, "the address of the peer is reused")
+		require.Zero(t, testing.AllocsPerRun(100, func() { parseSOCKSUDP(pkt, from) }))
+		buf := make([]byte, 0, 64)
+		require.Zero(t, testing.AllocsPerRun(100, func() { appendSOCKSUDPHeader(buf, addr) }))
+	}
+
+	payload, from, ok := parseSOCKSUDP([]byte{0, 0, 0, 3, 9, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't', 0, 53, 'x'}, nil)
+	require.True(t, ok)
+	require.Equal(t, "x", string(payload))
+	require.Equal(t, 53, from.Port)
+
+	for _, pkt := range [][]byte{
+		{0, 0, 0},
+		{0, 0, 1, 1, 1, 1, 1, 1, 0, 53}, // Fragment.
+		{0, 0, 0, 1, 1, 1},
+		{0, 0, 0, 4, 1, 1, 1, 1, 0, 53},
+		{0, 0, 0, 3, 20, 'x'},
+		{0, 0, 0, 2, 1, 1, 1, 1, 0, 53},
+	} {
+		_, _, ok := parseSOCKSUDP(pkt, nil)
+		require.False(t, ok, pkt)
+	}
+}
+
+// relayUDPConn is core.UDPConn of the flow from local, datagrams written to it are sent to written.
+type relayUDPConn struct {
+	local   *net.UDPAddr
+	written chan string
+	closed  atomic.Bool
+}
+
+func newRelayUDPConn(port int) *relayUDPConn {
+	return &relayUDPConn{local: &net.UDPAddr{IP: net.IPv4(192, 18, 0, 1), Port: port}, written: make(chan string, 64)}
+}
+
+func (c *relayUDPConn) LocalAddr() *net.UDPAddr              { return c.local }
+func (c *relayUDPConn) ReceiveTo([]byte, *net.UDPAddr) error { return nil }
+
+func (c *relayUDPConn) WriteFrom(data []byte, addr *net.UDPAddr) (int, error) {
+	c.written <- addr.String() + " " + string(data)
+	return len(data), nil
+}
+
+func (c *relayUDPConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// startTestUDPEchoServer echoes UDP datagrams, it returns the server address.
+func startTestUDPEchoServer(t *testing.T) *net.UDPAddr {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	return pc.LocalAddr().(*net.UDPAddr)
+}
+
+// startTestSOCKSInbound starts XRay with socks inbound relaying UDP directly, it returns the inbound address.
+func startTestSOCKSInbound(t *testing.T) string {
+	port := getFreePort()
+	var in conf.InboundDetourConfig
+	b := fmt.Sprintf(`{"listen":"127.0.0.1","port":%d,"protocol":"socks","settings":{"udp":true,"ip":"127.0.0.1"}}`, port)
+	require.NoError(t, json.Unmarshal([]byte(b), &in))
+	x, err := newXrayInstance(&conf.Config{
+		InboundConfigs:  []conf.InboundDetourConfig{in},
+		OutboundConfigs: []conf.OutboundDetourConfig{{Protocol: "freedom"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, x.Start())
+	t.Cleanup(func() { _ = x.Close() })
+
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+// exchange sends data to echo over h and waits for the reply.
+func exchange(t *testing.T, h *socksUDPHandler, conn *relayUDPConn, echo *net.UDPAddr, data string) {
+	t.Helper()
+	require.NoError(t, h.ReceiveTo(conn, []byte(data), echo))
+	select {
+	case got := <-conn.written:
+		require.Equal(t, echo.String()+" "+data, got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply")
+	}
+}
+
+func TestSocksUDPHandler(t *testing.T) {
+	echo, inbound := startTestUDPEchoServer(t), startTestSOCKSInbound(t)
+	relay := newUDPRelay()
+	relay.setOptions(&UDPOptions{SpareSessions: 1, Batch: 4})
+	h := relay.handler(inbound, time.Minute)
+	t.Cleanup(relay.close)
+
+	conn := newRelayUDPConn(5353)
+	require.NoError(t, h.Connect(conn, echo))
+	exchange(t, h, conn, echo, "ping")
+	require.Eventually(t, func() bool { return relay.stats().Spare == 1 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, UDPStats{Active: 1, Spare: 1, Established: 2}, relay.stats())
+
+	// Datagrams sent at once are batched, only those over the queue are dropped.
+	for i := range 16 {
+		require.NoError(t, h.ReceiveTo(conn, []byte(fmt.Sprint(i)), echo))
+	}
+	for range 16 - int(relay.stats().Dropped) {
+		select {
+		case <-conn.written:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply")
+		}
+	}
+
+	// The association of the closed flow is reused by the next flow of the source.
+	h.Close(conn)
+	require.True(t, conn.closed.Load())
+	require.Equal(t, 1, relay.stats().Cached)
+	conn = newRelayUDPConn(5353)
+	require.NoError(t, h.Connect(conn, echo))
+	exchange(t, h, conn, echo, "cached")
+
+	// New source takes the spare association.
+	other := newRelayUDPConn(5354)
+	require.NoError(t, h.Connect(other, echo))
+	exchange(t, h, other, echo, "spare")
+	require.Eventually(t, func() bool { return relay.stats().Spare == 1 }, 5*time.Second, time.Millisecond, "refilled")
+	st := relay.stats()
+	require.Equal(t, UDPStats{Active: 2, Spare: 1, Established: 3, Reused: 2, Dropped: st.Dropped}, st)
+
+	relay.close()
+	require.Equal(t, UDPStats{Established: st.Established, Reused: 2, Dropped: st.Dropped}, relay.stats())
+	require.Error(t, h.Connect(newRelayUDPConn(5355), echo), "closed")
+}
+
+func TestSocksUDPHandler_timeouts(t *testing.T) {
+	echo, inbound := startTestUDPEchoServer(t), startTestSOCKSInbound(t)
+	relay := newUDPRelay()
+	relay.setOptions(&UDPOptions{SessionCache: 50 * time.Millisecond})
+	h := relay.handler(inbound, 50*time.Millisecond)
+	t.Cleanup(relay.close)
+
+	conn := newRelayUDPConn(5353)
+	require.NoError(t, h.Connect(conn, echo))
+	exchange(t, h, conn, echo, "ping")
+
+	// The idle flow is closed, then its cached association expires.
+	require.Eventually(t, conn.closed.Load, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return relay.stats() == UDPStats{Established: 1} }, 5*time.Second, time.Millisecond)
+
+	require.ErrorContains(t, h.ReceiveTo(conn, []byte("late"), echo), "does not exist")
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/eycorsican/go-tun2socks/proxy/dnsfallback"
+	xnet "github.com/xtls/xray-core/common/net"
+	xcore "github.com/xtls/xray-core/core"
+	"golang.org/x/net/proxy"
+
+	"github.com/goxray/tun/internal/socks5"
+)
+
+// unixInbound serves SOCKS5 inbound on Unix domain socket for XRay core instance,
+// XRay socks inbound can listen on TCP only. Connections are dispatched to the outbound with xcore.Dial.
+type unixInbound struct {
+	inst *xcore.Instance
+	path string
+
+	ln    net.Listener
+	socks *socks5.Server
+	wg    sync.WaitGroup
+}
+
+func newUnixInbound(inst *xcore.Instance, path string) *unixInbound {
+	return &unixInbound{inst: inst, path: path}
+}
+
+// Start starts XRay core instance and listens on the socket.
+func (u *unixInbound) Start() error {
+	if err := u.inst.Start(); err != nil {
+		return err
+	}
+
+	// Socket file left by a crashed process makes listen fail.
+	if !strings.HasPrefix(u.path, "@") {
+		if st, err := os.Stat(u.path); err == nil && st.Mode().Type() == os.ModeSocket {
+			_ = os.Remove(u.path)
+		}
+	}
+	ln, err := net.Listen("unix", u.path)
+	if err != nil {
+		return errors.Join(fmt.Errorf("listen inbound: %w", err), u.inst.Close())
+	}
+	u.ln = ln
+	u.socks = socks5.NewServer(u.dial)
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		_ = u.socks.Serve(ln)
+	}()
+
+	return nil
+}
+
+// Close stops the inbound and XRay core instance, the socket file is removed.
+func (u *unixInbound) Close() error {
+	var err error
+	if u.ln != nil {
+		err = errors.Join(u.ln.Close(), u.socks.Close())
+		u.wg.Wait()
+		u.ln = nil
+	}
+
+	return errors.Join(err, u.inst.Close())
+}
+
+func (u *unixInbound) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	dest, err := xnet.ParseDestination(network + ":" + addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return xcore.Dial(ctx, u.inst, dest)
+}
+
+// unixPipe routes IP packets from TUN device to socks5 proxy listening on Unix domain socket,
+// it is used instead of socksPipe which supports TCP proxy addresses only.
+//
+// SOCKS5 UDP ASSOCIATE can not work over Unix domain socket: DNS queries are answered
+// with truncated responses to make resolvers retry over TCP, other UDP traffic is dropped.
+type unixPipe struct {
+	mtu    int
+	flows  *flowTable
+	shards *packetShards
+}
+
+func newUnixPipe(mtu int, flows *flowTable, shards *packetShards) *unixPipe {
+	return &unixPipe{mtu: mtu, flows: flows, shards: shards}
+}
+
+// Copy connects pipe to socks5 server listening on socket path, see socksPipe Copy.
+func (p *unixPipe) Copy(ctx context.Context, pipe io.ReadWriteCloser, socket string) error {
+	dialer, err := proxy.SOCKS5("unix", socket, nil, &net.Dialer{})
+	if err != nil {
+		return fmt.Errorf("socks5 dialer: %w", err)
+	}
+
+	tcp := &flowTCPHandler{dialer: dialer.(proxy.ContextDialer), ctx: ctx, flows: p.flows}
+
+	return copyToStack(ctx, pipe, p.mtu, tcp, dnsfallback.NewUDPHandler(), nil, p.shards)
+}
+
+// relayConns copies data in both directions until either side is done, with buffers of size bytes
+// (0 for io.Copy default).
+func relayConns(a, b net.Conn, size int) {
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		var buf []byte
+		if size > 0 {
+			buf = make([]byte, size)
+		}
+		_, _ = io.CopyBuffer(dst, src, buf)
+		_ = dst.Close()
+		done <- struct{}{}
+	}
+
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	<-done
+}
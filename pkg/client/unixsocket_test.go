@@ -0,0 +1,62 @@
+package client
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+	"golang.org/x/net/proxy"
+)
+
+func TestUnixInbound(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "inbound.sock")
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	cl.cfg.InboundProxy = &Proxy{Path: socket}
+
+	inst, _, err := cl.createProxy("trojan://pass@127.0.0.11:443")
+	require.NoError(t, err)
+	require.IsType(t, &unixInbound{}, inst)
+	require.Empty(t, cl.xJSON.InboundConfigs)
+
+	// Direct outbound to check the whole path through the socket.
+	x, err := newXrayInstance(&conf.Config{OutboundConfigs: []conf.OutboundDetourConfig{{Protocol: "freedom"}}})
+	require.NoError(t, err)
+	in := newUnixInbound(x, socket)
+	require.NoError(t, os.WriteFile(socket, nil, 0o600))
+	require.Error(t, in.Start(), "regular file must not be replaced")
+	require.NoError(t, os.Remove(socket))
+	require.NoError(t, in.Start())
+
+	st, err := os.Stat(socket)
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSocket, st.Mode().Type())
+
+	dialer, err := proxy.SOCKS5("unix", socket, nil, &net.Dialer{})
+	require.NoError(t, err)
+	conn, err := dialer.Dial("tcp", startTestEchoServer(t))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+
+	require.NoError(t, in.Close())
+	_, err = os.Stat(socket)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestProxy_Network(t *testing.T) {
+	p := &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: 10808}
+	require.Equal(t, "tcp", p.Network())
+	require.Equal(t, "127.0.0.1:10808", p.String())
+
+	p.Path = "/run/goxray.sock"
+	require.Equal(t, "unix", p.Network())
+	require.Equal(t, "/run/goxray.sock", p.String())
+}
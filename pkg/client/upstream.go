@@ -0,0 +1,45 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+)
+
+// upstreamProtocol is the protocol of Config.Upstream reported by Plan and events.
+const upstreamProtocol = "socks5"
+
+// upstreamEngine is the Engine of Config.Upstream: the proxy is run elsewhere, there is nothing to start or stop.
+type upstreamEngine struct {
+	addr *Proxy
+}
+
+func (e upstreamEngine) Start() error { return nil }
+
+func (e upstreamEngine) Close() error { return nil }
+
+func (e upstreamEngine) ServerAddr() string { return e.addr.IP.String() }
+
+// upstreamSpec returns proxy spec of Config.Upstream, the connection link is not used.
+func (c *Client) upstreamSpec() (*proxySpec, error) {
+	u := c.cfg.Upstream
+	switch {
+	case u.Path != "":
+		return nil, fmt.Errorf("%w: upstream: unix socket is not supported", ErrInvalidConfig)
+	case u.IP == nil || u.Port <= 0 || u.Port > 65535:
+		return nil, fmt.Errorf("%w: upstream: invalid address %s", ErrInvalidConfig, u)
+	case c.cfg.InboundACL != nil:
+		return nil, fmt.Errorf("%w: upstream: inbound acl is not supported, there is no inbound proxy", ErrInvalidConfig)
+	case c.cfg.Dialer != nil:
+		return nil, fmt.Errorf("%w: upstream: dialer is not supported", ErrInvalidConfig)
+	}
+	if err := c.engineUnsupported(upstreamProtocol); err != nil {
+		return nil, err
+	}
+
+	return &proxySpec{
+		engine:  upstreamEngine{addr: u},
+		general: &xrayproto.GeneralConfig{Protocol: upstreamProtocol, Address: u.IP.String(), Port: strconv.Itoa(u.Port)},
+	}, nil
+}
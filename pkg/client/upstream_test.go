@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoopback_Upstream(t *testing.T) {
+	c, tun, routes, engine := newLoopbackClient(t)
+	upstream := &loopbackEngine{inbound: Proxy{IP: net.IPv4(127, 0, 0, 1), Port: getFreePort()}}
+	require.NoError(t, upstream.Start())
+	t.Cleanup(func() { _ = upstream.Close() })
+	c.cfg.Upstream = &upstream.inbound
+
+	require.NoError(t, c.Connect(""))
+	require.Nil(t, engine(), "no engine is created")
+
+	app := tcpSegment{src: net.IPv4(192, 18, 0, 1), dst: net.IPv4(198, 51, 100, 7), srcPort: 40000, dstPort: 80, seq: 1000}
+	app.flags = tcpSYN
+	tun.in <- app.marshal()
+	synAck := readSegment(t, tun)
+	require.Equal(t, byte(tcpSYN|tcpACK), synAck.flags&(tcpSYN|tcpACK))
+	app.seq, app.ack = app.seq+1, synAck.seq+1
+	app.flags = tcpACK
+	tun.in <- app.marshal()
+	app.flags, app.payload = tcpACK|tcpPSH, []byte("ping")
+	tun.in <- app.marshal()
+	var echoed []byte
+	for len(echoed) < len("ping") {
+		seg := readSegment(t, tun)
+		require.Zero(t, seg.flags&tcpRST, "connection reset")
+		echoed = append(echoed, seg.payload...)
+	}
+	require.Equal(t, "ping", string(echoed))
+	require.Equal(t, []string{"198.51.100.7:80"}, upstream.dialed)
+
+	require.NoError(t, c.Disconnect(context.Background()))
+	require.Empty(t, routeOps(routes), "upstream on this host needs no server route")
+	require.False(t, upstream.closed, "upstream is not owned by the client")
+}
+
+func TestClient_upstreamSpec(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+	gw := net.IPv4(192, 168, 1, 1)
+	cl.cfg.GatewayIP = &gw
+	cl.cfg.RoutesToTUN = DefaultRoutesToTUN
+	cl.cfg.Upstream = &Proxy{IP: net.IPv4(192, 168, 1, 5), Port: 1080}
+
+	p, err := cl.Plan("")
+	require.NoError(t, err)
+	require.Equal(t, upstreamProtocol, p.Protocol)
+	require.Equal(t, "192.168.1.5", p.Server)
+	require.True(t, p.Engine)
+	require.Equal(t, "192.168.1.5:1080", p.InboundProxy)
+	require.Equal(t, PlanRoute{Destination: "192.168.1.5/32", Gateway: gw}, p.Routes[len(p.Routes)-1],
+		"upstream on another host is routed around the tunnel")
+
+	for _, u := range []*Proxy{{Path: "/run/socks.sock"}, {IP: net.IPv4(127, 0, 0, 1)}, {Port: 1080}} {
+		cl.cfg.Upstream = u
+		_, err = cl.Plan("")
+		require.ErrorContains(t, err, "invalid config: upstream", u.String())
+	}
+
+	cl.cfg.Upstream = &Proxy{IP: net.IPv4(127, 0, 0, 1), Port: 1080}
+	cl.cfg.Routing = []RoutingRule{{}}
+	_, err = cl.Plan("")
+	require.ErrorContains(t, err, "invalid config: routing: not supported for socks5")
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"runtime"
+	"runtime/debug"
+	"slices"
+
+	xrayproto "github.com/lilendian0x00/xray-knife/v3/pkg/protocol"
+	"github.com/xtls/xray-core/core"
+)
+
+// modulePath is the path of this module in the build info of the binary.
+const modulePath = "github.com/goxray/tun"
+
+// xrayLinks are link schemes served by XRay core, including the notations normalized by this package.
+var xrayLinks = []string{
+	xrayproto.VmessIdentifier, xrayproto.VlessIdentifier, xrayproto.TrojanIdentifier, trojanGoScheme,
+	xrayproto.ShadowsocksIdentifier, xrayproto.SocksIdentifier, wireguardScheme, wireguardShortScheme,
+}
+
+// VersionInfo describes the build of the package, include it in bug reports.
+type VersionInfo struct {
+	// Version of the module, empty if unknown (e.g. built from a source checkout).
+	Version string `json:"version,omitempty"`
+	// XrayCore is the version of the embedded XRay core.
+	XrayCore string `json:"xray_core"`
+	// Protocols are the supported link schemes, with the ones of registered engines.
+	Protocols []string `json:"protocols"`
+	// GoVersion is the Go toolchain of the binary.
+	GoVersion string `json:"go_version"`
+	// Platform is "GOOS/GOARCH" of the binary.
+	Platform string `json:"platform"`
+	// Revision is the VCS commit the binary is built from, with its time and whether the work tree had
+	// uncommitted changes.
+	Revision     string `json:"revision,omitempty"`
+	RevisionTime string `json:"revision_time,omitempty"`
+	Modified     bool   `json:"modified,omitempty"`
+}
+
+// Version returns the build info of the package, see VersionInfo.
+func (c *Client) Version() VersionInfo {
+	return Version()
+}
+
+// Version returns the build info of the package, see VersionInfo.
+func Version() VersionInfo {
+	v := VersionInfo{
+		XrayCore:  core.Version(),
+		Protocols: protocols(),
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v.GoVersion = info.GoVersion
+
+	mod := &info.Main
+	if mod.Path != modulePath {
+		// The package is a dependency of the binary.
+		if i := slices.IndexFunc(info.Deps, func(m *debug.Module) bool { return m.Path == modulePath }); i >= 0 {
+			mod = info.Deps[i]
+		}
+	}
+	if mod.Replace != nil {
+		mod = mod.Replace
+	}
+	if mod.Version != "(devel)" {
+		v.Version = mod.Version
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v.Revision = s.Value
+		case "vcs.time":
+			v.RevisionTime = s.Value
+		case "vcs.modified":
+			v.Modified = s.Value == "true"
+		}
+	}
+
+	return v
+}
+
+// protocols returns sorted link schemes served by XRay core and the registered engines.
+func protocols() []string {
+	list := slices.Clone(xrayLinks)
+
+	enginesMu.RLock()
+	for scheme := range engines {
+		list = append(list, scheme)
+		if scheme == hysteria2Scheme {
+			list = append(list, hysteria2ShortScheme)
+		}
+	}
+	enginesMu.RUnlock()
+	slices.Sort(list)
+
+	return slices.Compact(list)
+}
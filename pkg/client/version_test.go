@@ -0,0 +1,28 @@
+package client
+
+import (
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion(t *testing.T) {
+	RegisterEngine("test-version", func(string, EngineOpts) (Engine, error) { return nil, ErrNoEngine })
+	defer func() {
+		enginesMu.Lock()
+		delete(engines, "test-version")
+		enginesMu.Unlock()
+	}()
+
+	v := (&Client{}).Version()
+	require.NotEmpty(t, v.XrayCore)
+	require.Equal(t, runtime.Version(), v.GoVersion)
+	require.Equal(t, runtime.GOOS+"/"+runtime.GOARCH, v.Platform)
+	for _, scheme := range []string{"vless", "vmess", "trojan", "trojan-go", "ss", "socks", "wireguard", "wg", "ssh", "hysteria2", "hy2", "tuic", "test-version"} {
+		require.Contains(t, v.Protocols, scheme)
+	}
+	require.True(t, slices.IsSorted(v.Protocols))
+	require.Len(t, slices.Compact(slices.Clone(v.Protocols)), len(v.Protocols))
+}
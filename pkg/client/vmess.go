@@ -0,0 +1,158 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// VMess ciphers supported by XRay core.
+const (
+	VMessSecurityAuto             = "auto"
+	VMessSecurityAES128GCM        = "aes-128-gcm"
+	VMessSecurityChacha20Poly1305 = "chacha20-poly1305"
+	VMessSecurityNone             = "none"
+	VMessSecurityZero             = "zero"
+)
+
+// VMessOptions override VMess settings received from "vmess://" link. Empty fields keep link values.
+type VMessOptions struct {
+	// Security is the cipher, one of VMessSecurity* constants.
+	Security string
+	// Legacy allows links with non-zero alterId.
+	//
+	// XRay core implements only the AEAD handshake (alterId 0) which is accepted by servers
+	// regardless of their alterId, the legacy MD5 handshake is not available. Links with non-zero
+	// alterId are rejected unless Legacy is set, in which case alterId is ignored.
+	Legacy bool
+}
+
+// Validate checks options values.
+func (o *VMessOptions) Validate() error {
+	return validateVMessSecurity(o.Security)
+}
+
+// VMessLink is a parsed "vmess://" link, both base64 JSON (v2rayN) and base64 URL (Shadowrocket) notations are supported.
+type VMessLink struct {
+	ID       string
+	Host     string // Server host (IP or domain name).
+	Port     string
+	AlterID  int
+	Security string // Cipher (default: VMessSecurityAuto).
+	Remark   string
+}
+
+// ParseVMessLink parses and validates VMess link.
+func ParseVMessLink(link string) (*VMessLink, error) {
+	v := xray.NewVmess(strings.TrimSpace(link)).(*xray.Vmess)
+	if err := v.Parse(); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+
+	return newVMessLink(v)
+}
+
+func newVMessLink(v *xray.Vmess) (*VMessLink, error) {
+	l := &VMessLink{
+		ID:       v.ID,
+		Host:     strings.TrimSuffix(strings.TrimPrefix(v.Address, "["), "]"),
+		Port:     jsonScalar(v.Port),
+		Security: strings.ToLower(v.Security),
+		Remark:   v.Remark,
+	}
+	if l.Security == "" {
+		l.Security = VMessSecurityAuto
+	}
+	if err := validateVMessSecurity(l.Security); err != nil {
+		return nil, err
+	}
+
+	if aid := jsonScalar(v.Aid); aid != "" {
+		n, err := strconv.Atoi(aid)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid alterId %q", aid)
+		}
+		l.AlterID = n
+	}
+
+	return l, nil
+}
+
+// Apply overrides link values with non-empty options.
+func (l *VMessLink) Apply(opts *VMessOptions) error {
+	if opts != nil && opts.Security != "" {
+		l.Security = opts.Security
+	}
+	if l.AlterID != 0 && (opts == nil || !opts.Legacy) {
+		return fmt.Errorf("alterId %d requires legacy VMess handshake which is not supported, "+
+			"enable legacy compatibility to connect with AEAD handshake", l.AlterID)
+	}
+
+	return nil
+}
+
+func validateVMessSecurity(security string) error {
+	switch security {
+	case "", VMessSecurityAuto, VMessSecurityAES128GCM, VMessSecurityChacha20Poly1305, VMessSecurityNone, VMessSecurityZero:
+		return nil
+	default:
+		// XRay core silently falls back to "auto" for unknown ciphers (e.g. legacy "aes-128-cfb").
+		return fmt.Errorf("unsupported vmess security %q", security)
+	}
+}
+
+// fixVmessOutbound rebuilds vmess outbound settings applying VMess options,
+// XRay-knife copies link values into json as is.
+func fixVmessOutbound(v *xray.Vmess, out *conf.OutboundDetourConfig, opts *VMessOptions) error {
+	l, err := newVMessLink(v)
+	if err != nil {
+		return err
+	}
+	if err = l.Apply(opts); err != nil {
+		return err
+	}
+
+	port, err := strconv.ParseUint(l.Port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("vmess port %q: %w", l.Port, err)
+	}
+	// XRay core ignores alterId, it is kept for reference (e.g. for share links).
+	user, err := json.Marshal(struct {
+		conf.VMessAccount
+		AlterID int `json:"alterId,omitempty"`
+	}{conf.VMessAccount{ID: l.ID, Security: l.Security}, l.AlterID})
+	if err != nil {
+		return fmt.Errorf("vmess user: %w", err)
+	}
+	settings, err := json.Marshal(conf.VMessOutboundConfig{Receivers: []*conf.VMessOutboundTarget{{
+		Address: &conf.Address{Address: xnet.ParseAddress(l.Host)},
+		Port:    uint16(port),
+		Users:   []json.RawMessage{user},
+	}}})
+	if err != nil {
+		return fmt.Errorf("vmess settings: %w", err)
+	}
+	raw := json.RawMessage(settings)
+	out.Settings = &raw
+
+	return nil
+}
+
+// jsonScalar formats loosely typed json value (string or number) as string.
+func jsonScalar(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// testVMessLink makes v2rayN style "vmess://" link with given alterId and cipher.
+func testVMessLink(t *testing.T, aid any, scy string) string {
+	b, err := json.Marshal(map[string]any{
+		"v": "2", "ps": "remark", "add": "127.0.0.4", "port": 443, "id": "b831381d-6324-4d53-ad4f-8cda48b30811",
+		"aid": aid, "scy": scy, "net": "tcp", "type": "none",
+	})
+	require.NoError(t, err)
+
+	return "vmess://" + base64.StdEncoding.EncodeToString(b)
+}
+
+func TestParseVMessLink(t *testing.T) {
+	l, err := ParseVMessLink(testVMessLink(t, "64", ""))
+	require.NoError(t, err)
+	require.Equal(t, &VMessLink{
+		ID: "b831381d-6324-4d53-ad4f-8cda48b30811", Host: "127.0.0.4", Port: "443",
+		AlterID: 64, Security: VMessSecurityAuto, Remark: "remark",
+	}, l)
+
+	l, err = ParseVMessLink(testVMessLink(t, 0, "AES-128-GCM"))
+	require.NoError(t, err)
+	require.Equal(t, 0, l.AlterID)
+	require.Equal(t, VMessSecurityAES128GCM, l.Security)
+
+	_, err = ParseVMessLink(testVMessLink(t, 0, "aes-128-cfb"))
+	require.ErrorContains(t, err, "unsupported vmess security")
+
+	_, err = ParseVMessLink(testVMessLink(t, "many", ""))
+	require.ErrorContains(t, err, "invalid alterId")
+}
+
+func TestCreateProxy_VMess(t *testing.T) {
+	tests := []struct {
+		name    string
+		link    string
+		opts    *VMessOptions
+		wantErr string
+		wantScy string
+	}{
+		{name: "aead", link: testVMessLink(t, 0, "chacha20-poly1305"), wantScy: "chacha20-poly1305"},
+		{name: "default security", link: testVMessLink(t, "0", ""), wantScy: "auto"},
+		{name: "security override", link: testVMessLink(t, 0, "auto"), opts: &VMessOptions{Security: "zero"}, wantScy: "zero"},
+		{name: "legacy alterId", link: testVMessLink(t, 4, ""), wantErr: "alterId 4 requires legacy"},
+		{name: "legacy compatibility", link: testVMessLink(t, 4, "aes-128-gcm"), opts: &VMessOptions{Legacy: true}, wantScy: "aes-128-gcm"},
+		{name: "invalid options", link: testVMessLink(t, 0, ""), opts: &VMessOptions{Security: "rc4"}, wantErr: "vmess options"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cl := newTestClient(nil, nil, nil, nil, nil)
+			cl.cfg.VMess = test.opts
+
+			xCfg, gcfg, err := cl.buildXrayConfig(test.link)
+			if test.wantErr != "" {
+				require.ErrorContains(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "127.0.0.4", gcfg.Address)
+
+			var settings conf.VMessOutboundConfig
+			require.NoError(t, json.Unmarshal(*xCfg.OutboundConfigs[0].Settings, &settings))
+			var user conf.VMessAccount
+			require.NoError(t, json.Unmarshal(settings.Receivers[0].Users[0], &user))
+			require.Equal(t, test.wantScy, user.Security)
+			require.EqualValues(t, 443, settings.Receivers[0].Port)
+
+			_, err = newXrayInstance(xCfg)
+			require.NoError(t, err)
+		})
+	}
+}
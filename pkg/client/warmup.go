@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// DefaultWarmupRecycle is how long a warm connection is kept before it is opened again,
+	// see WarmupOptions.Recycle.
+	DefaultWarmupRecycle = time.Minute
+	// maxWarmupConns limits WarmupOptions.Conns.
+	maxWarmupConns = 16
+	// warmupRetry is the delay of opening a warm connection again after it failed.
+	warmupRetry = 5 * time.Second
+)
+
+// WarmupOptions keep connections to the server warm while connected, so the first flows of applications
+// do not wait for the transport and protocol handshakes.
+//
+// Warm connections are opened through the proxy to Target right after connect and are kept idle. Transports
+// multiplexing flows over a connection (mux, gRPC, HTTP/2, XHTTP) serve new flows over the warm ones, other
+// transports reuse resolved server address and TLS sessions. Connections are opened again after Recycle, before
+// the server or Target closes them as idle.
+type WarmupOptions struct {
+	// Conns is the number of warm connections, up to 16 (default: 0, disabled).
+	Conns int
+	// Target is "host:port" the warm connections are opened to through the proxy
+	// (default: host of Config.Check URL).
+	Target string
+	// Recycle is how long a warm connection is kept before it is opened again (default: DefaultWarmupRecycle).
+	Recycle time.Duration
+}
+
+// Validate checks options values.
+func (o *WarmupOptions) Validate() error {
+	if o.Conns < 0 || o.Conns > maxWarmupConns {
+		return fmt.Errorf("conns must be from 0 to %d", maxWarmupConns)
+	}
+	if o.Target != "" {
+		if _, _, err := net.SplitHostPort(o.Target); err != nil {
+			return fmt.Errorf("invalid target: %w", err)
+		}
+	}
+	if o.Recycle < 0 {
+		return errors.New("recycle must not be negative")
+	}
+
+	return nil
+}
+
+func (o *WarmupOptions) conns() int {
+	if o == nil {
+		return 0
+	}
+
+	return o.Conns
+}
+
+// target returns Target or the host of check URL with the port of its scheme.
+func (o *WarmupOptions) target(check *CheckOptions) string {
+	if o != nil && o.Target != "" {
+		return o.Target
+	}
+	u, err := url.Parse(check.url())
+	if err != nil {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (o *WarmupOptions) recycle() time.Duration {
+	if o == nil || o.Recycle == 0 {
+		return DefaultWarmupRecycle
+	}
+
+	return o.Recycle
+}
+
+// WarmupStats are counters of warm connections, see WarmupOptions.
+type WarmupStats struct {
+	Active int // Warm connections open.
+	// Opened are warm connections opened since the Client is created.
+	Opened uint64
+	// Failed are warm connections which could not be opened since the Client is created.
+	Failed uint64
+}
+
+// WarmupStats returns counters of warm connections.
+func (c *Client) WarmupStats() WarmupStats {
+	return c.warmup.stats()
+}
+
+// warmupState counts warm connections of the Client.
+type warmupState struct {
+	active atomic.Int64
+	opened atomic.Uint64
+	failed atomic.Uint64
+}
+
+func (w *warmupState) stats() WarmupStats {
+	return WarmupStats{Active: int(w.active.Load()), Opened: w.opened.Load(), Failed: w.failed.Load()}
+}
+
+// runWarmup keeps Config.Warmup connections warm until ctx is done.
+func (c *Client) runWarmup(ctx context.Context) {
+	n := c.cfg.Warmup.conns()
+	if n == 0 {
+		return
+	}
+	dialer, err := c.proxyDialer()
+	if err != nil {
+		c.cfg.Logger.Warn("warm-up failed", "err", err)
+		return
+	}
+	target, recycle := c.cfg.Warmup.target(c.cfg.Check), c.cfg.Warmup.recycle()
+	c.cfg.Logger.Debug("keeping connections warm", "conns", n, "target", target, "recycle", recycle)
+
+	done := make(chan struct{}, n)
+	for range n {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			c.warmup.keep(ctx, dialer, target, recycle, c.cfg.Logger)
+		}()
+	}
+	for range n {
+		<-done
+	}
+}
+
+// keep opens a connection to target through dialer and opens it again after recycle, or after warmupRetry
+// if it fails or is closed, until ctx is done.
+func (w *warmupState) keep(ctx context.Context, dialer proxy.ContextDialer, target string, recycle time.Duration,
+	log *slog.Logger,
+) {
+	for ctx.Err() == nil {
+		wait := w.warm(ctx, dialer, target, recycle, log)
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+}
+
+// warm opens a connection to target and keeps it for recycle, it returns the delay of opening the next one.
+func (w *warmupState) warm(ctx context.Context, dialer proxy.ContextDialer, target string, recycle time.Duration,
+	log *slog.Logger,
+) time.Duration {
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		if ctx.Err() == nil {
+			w.failed.Add(1)
+			log.Debug("warm-up connection failed", "target", target, "err", err)
+		}
+		return warmupRetry
+	}
+	w.opened.Add(1)
+	w.active.Add(1)
+	defer w.active.Add(-1)
+	defer func() { _ = conn.Close() }()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	timer := time.NewTimer(recycle)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	case <-closed: // Closed by the server or target.
+		return warmupRetry
+	}
+
+	return 0
+}
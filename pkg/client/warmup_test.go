@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmupOptions_Validate(t *testing.T) {
+	require.NoError(t, (&WarmupOptions{}).Validate())
+	require.NoError(t, (&WarmupOptions{Conns: 16, Target: "example.com:443", Recycle: time.Second}).Validate())
+	require.ErrorContains(t, (&WarmupOptions{Conns: 17}).Validate(), "conns must be from 0 to 16")
+	require.ErrorContains(t, (&WarmupOptions{Conns: 1, Target: "example.com"}).Validate(), "invalid target")
+	require.ErrorContains(t, (&WarmupOptions{Conns: 1, Recycle: -1}).Validate(), "recycle must not be negative")
+}
+
+func TestWarmupOptions_defaults(t *testing.T) {
+	var o *WarmupOptions
+	require.Zero(t, o.conns())
+	require.Equal(t, DefaultWarmupRecycle, o.recycle())
+	require.Equal(t, "www.gstatic.com:443", o.target(nil))
+	require.Equal(t, "example.com:80", o.target(&CheckOptions{URL: "http://example.com/ping"}))
+	require.Equal(t, "example.com:8443", o.target(&CheckOptions{URL: "https://example.com:8443/"}))
+	require.Equal(t, "[2001:db8::1]:443", (&WarmupOptions{Target: "[2001:db8::1]:443"}).target(nil))
+}
+
+// warmupDialer dials TCP directly or fails with err.
+type warmupDialer struct{ err error }
+
+func (d warmupDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return (&net.Dialer{}).DialContext(ctx, network, addr)
+}
+
+func TestWarmupState_keep(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	var w warmupState
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.keep(ctx, warmupDialer{}, ln.Addr().String(), 50*time.Millisecond, slog.Default())
+	}()
+
+	// The connection is opened again after recycle.
+	first := <-accepted
+	defer first.Close()
+	second := <-accepted
+	defer second.Close()
+	require.Eventually(t, func() bool { return w.stats().Opened >= 2 }, time.Second, 10*time.Millisecond)
+	require.LessOrEqual(t, w.stats().Active, 1)
+
+	cancel()
+	<-done
+	require.Equal(t, WarmupStats{Opened: w.stats().Opened}, w.stats(), "no active connections after ctx is done")
+}
+
+func TestWarmupState_keepFailed(t *testing.T) {
+	var w warmupState
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.keep(ctx, warmupDialer{err: errors.New("refused")}, "example.com:443", time.Minute, slog.Default())
+	}()
+
+	require.Eventually(t, func() bool { return w.stats().Failed == 1 }, time.Second, 10*time.Millisecond)
+	cancel() // Interrupts the retry delay.
+	<-done
+	require.Equal(t, WarmupStats{Failed: 1}, w.stats())
+}
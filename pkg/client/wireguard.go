@@ -0,0 +1,111 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	wireguardScheme      = "wireguard"
+	wireguardShortScheme = "wg"
+	// wireguardConfigHeader is the first section of standard WireGuard (wg-quick) config.
+	wireguardConfigHeader = "[Interface]"
+)
+
+// IsWireGuardConfig reports whether s is a standard WireGuard (wg-quick) config rather than a link.
+func IsWireGuardConfig(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), wireguardConfigHeader)
+}
+
+// WireGuardLink converts standard WireGuard (wg-quick) config into "wireguard://" link understood by Client.
+//
+// Only the first [Peer] section is used, the following ones are ignored as a whole. wg-quick specific
+// keys (DNS, Table, PostUp e.t.c.) are ignored.
+func WireGuardLink(config []byte, remark string) (string, error) {
+	var section string
+	var peers int
+	iface, peer := map[string]string{}, map[string]string{}
+
+	sc := bufio.NewScanner(bytes.NewReader(config))
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "["):
+			section = strings.ToLower(line)
+			if section == "[peer]" {
+				if peers++; peers > 1 {
+					section = "" // Keys of the following peers must not fill in the first one.
+				}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return "", fmt.Errorf("malformed line %q", line)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		switch section {
+		case "[interface]":
+			iface[key] = value
+		case "[peer]":
+			peer[key] = value
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", fmt.Errorf("read config: %w", err)
+	}
+
+	switch {
+	case iface["privatekey"] == "":
+		return "", errors.New("interface PrivateKey is required")
+	case iface["address"] == "":
+		return "", errors.New("interface Address is required")
+	case peer["publickey"] == "":
+		return "", errors.New("peer PublicKey is required")
+	case peer["endpoint"] == "":
+		return "", errors.New("peer Endpoint is required")
+	}
+
+	q := url.Values{}
+	q.Set("publickey", peer["publickey"])
+	q.Set("address", strings.ReplaceAll(iface["address"], " ", ""))
+	if psk := peer["presharedkey"]; psk != "" {
+		q.Set("presharedkey", psk)
+	}
+	if mtu := iface["mtu"]; mtu != "" {
+		q.Set("mtu", mtu)
+	}
+
+	u := url.URL{
+		Scheme:   wireguardScheme,
+		User:     url.User(iface["privatekey"]),
+		Host:     peer["endpoint"],
+		RawQuery: q.Encode(),
+		Fragment: remark,
+	}
+
+	return u.String(), nil
+}
+
+// normalizeWireGuardLink converts WireGuard config or "wg://" link into "wireguard://" link,
+// other links are returned unchanged.
+func normalizeWireGuardLink(link string) (string, error) {
+	if IsWireGuardConfig(link) {
+		return WireGuardLink([]byte(link), "")
+	}
+
+	if rest, ok := strings.CutPrefix(link, wireguardShortScheme+"://"); ok {
+		return wireguardScheme + "://" + rest, nil
+	}
+
+	return link, nil
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	"github.com/stretchr/testify/require"
+)
+
+const testWireGuardConfig = `
+[Interface]
+# Client
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.0.0.2/32, fd00::2/128
+DNS = 1.1.1.1
+MTU = 1420
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+PresharedKey = 1mG3sVUQeCgR8ohB3Hq6cOx3FXX4uKdMdfBAEeAEvpU=
+Endpoint = 203.0.113.1:51820
+AllowedIPs = 0.0.0.0/0, ::/0
+`
+
+func TestWireGuardLink(t *testing.T) {
+	require.True(t, IsWireGuardConfig(testWireGuardConfig))
+	require.False(t, IsWireGuardConfig("wireguard://key@1.2.3.4:51820"))
+
+	link, err := WireGuardLink([]byte(testWireGuardConfig), "home")
+	require.NoError(t, err)
+
+	// Link must be understood by the xray link parser.
+	wg := xray.NewWireguard(link).(*xray.Wireguard)
+	require.NoError(t, wg.Parse())
+	require.Equal(t, "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=", wg.SecretKey)
+	require.Equal(t, "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", wg.PublicKey)
+	require.Equal(t, "1mG3sVUQeCgR8ohB3Hq6cOx3FXX4uKdMdfBAEeAEvpU=", wg.PreSharedKey)
+	require.Equal(t, "203.0.113.1:51820", wg.Endpoint)
+	require.Equal(t, "10.0.0.2/32,fd00::2/128", wg.LocalAddress)
+	require.EqualValues(t, 1420, wg.Mtu)
+	require.Equal(t, "home", wg.Remark)
+}
+
+func TestWireGuardLink_TwoPeers(t *testing.T) {
+	config := "[Interface]\nPrivateKey = a\nAddress = 10.0.0.2/32\n" +
+		"[Peer]\nPublicKey = first\nEndpoint = 203.0.113.1:51820\n" +
+		"[Peer]\nPublicKey = second\nPresharedKey = psk\nEndpoint = 203.0.113.2:51820\n"
+	link, err := WireGuardLink([]byte(config), "")
+	require.NoError(t, err)
+
+	wg := xray.NewWireguard(link).(*xray.Wireguard)
+	require.NoError(t, wg.Parse())
+	require.Equal(t, "first", wg.PublicKey)
+	require.Equal(t, "203.0.113.1:51820", wg.Endpoint)
+	require.Empty(t, wg.PreSharedKey, "keys of the second peer must not be merged")
+
+	// Endpoint of the second peer does not complete the first one.
+	_, err = WireGuardLink([]byte("[Interface]\nPrivateKey = a\nAddress = 10.0.0.2/32\n"+
+		"[Peer]\nPublicKey = first\n[Peer]\nPublicKey = second\nEndpoint = 203.0.113.2:51820\n"), "")
+	require.ErrorContains(t, err, "Endpoint is required")
+}
+
+func TestWireGuardLink_Invalid(t *testing.T) {
+	_, err := WireGuardLink([]byte("[Interface]\nAddress = 10.0.0.2/32\n"), "")
+	require.ErrorContains(t, err, "PrivateKey is required")
+
+	_, err = WireGuardLink([]byte("[Interface]\nPrivateKey = a\nAddress = 10.0.0.2/32\n[Peer]\nPublicKey = b\n"), "")
+	require.ErrorContains(t, err, "Endpoint is required")
+
+	_, err = WireGuardLink([]byte("[Interface]\nPrivateKey\n"), "")
+	require.ErrorContains(t, err, "malformed line")
+}
+
+func TestNormalizeWireGuardLink(t *testing.T) {
+	link, err := normalizeWireGuardLink("wg://key@1.2.3.4:51820?publickey=pk")
+	require.NoError(t, err)
+	require.Equal(t, "wireguard://key@1.2.3.4:51820?publickey=pk", link)
+
+	link, err = normalizeWireGuardLink("vless://id@1.2.3.4:443")
+	require.NoError(t, err)
+	require.Equal(t, "vless://id@1.2.3.4:443", link)
+}
+
+func TestCreateProxy_WireGuardServerAddress(t *testing.T) {
+	cl := newTestClient(nil, nil, nil, nil, nil)
+
+	_, cfg, err := cl.createProxy(testWireGuardConfig)
+	require.NoError(t, err)
+	require.Equal(t, "203.0.113.1", cfg.Address)
+	require.Equal(t, "51820", cfg.Port)
+	require.Equal(t, "203.0.113.1", cl.xSrvIP.String())
+}
@@ -0,0 +1,247 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/lilendian0x00/xray-knife/v3/pkg/xray"
+	xapplog "github.com/xtls/xray-core/app/log"
+	xcommlog "github.com/xtls/xray-core/common/log"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// ErrNoXrayConfig is returned when client is not connected or the connection is served by Engine.
+var ErrNoXrayConfig = errors.New("no xray config")
+
+// XrayConfigJSON returns XRay core json config of the last Connect, credentials are redacted.
+// See RawXrayConfigJSON for the config as is.
+func (c *Client) XrayConfigJSON() ([]byte, error) {
+	return c.xrayConfigJSON(true)
+}
+
+// RawXrayConfigJSON returns XRay core json config of the last Connect including credentials.
+func (c *Client) RawXrayConfigJSON() ([]byte, error) {
+	return c.xrayConfigJSON(false)
+}
+
+func (c *Client) xrayConfigJSON(redact bool) ([]byte, error) {
+	if c.xJSON == nil {
+		return nil, ErrNoXrayConfig
+	}
+
+	return marshalXrayConfig(c.xJSON, redact)
+}
+
+// xrayConfig builds XRay core config with the Client inbound and the single outbound built from protocol.
+//
+// The config is assembled here instead of xray.Core.MakeInstance, so that outbound settings
+// XRay-knife does not know about (e.g. mux) can be applied.
+func (c *Client) xrayConfig(protocol xray.Protocol, inbound xray.Protocol, link *url.URL) (*conf.Config, error) {
+	out, err := protocol.BuildOutboundDetourConfig(c.cfg.TLSAllowInsecure)
+	if err != nil {
+		return nil, fmt.Errorf("build outbound: %w", err)
+	}
+	if err = c.fixOutbound(protocol, out); err != nil {
+		return nil, fmt.Errorf("build outbound: %w", err)
+	}
+	if out.MuxSettings, err = linkMux(link.Query()); err != nil {
+		return nil, fmt.Errorf("build outbound: %w", err)
+	}
+	if passthroughSchemes[link.Scheme] {
+		dropped, err := passthroughLinkParams(link.Query(), out.StreamSetting, c.cfg.StrictLinkParams)
+		if err != nil {
+			return nil, fmt.Errorf("build outbound: %w", err)
+		}
+		if len(dropped) > 0 {
+			c.log(LogXRay).Warn("unsupported link parameters ignored", "params", dropped)
+		}
+	}
+
+	if s := out.StreamSetting; s != nil && s.Security == "reality" && s.REALITYSettings != nil {
+		if err = validateReality(s.REALITYSettings); err != nil {
+			return nil, fmt.Errorf("reality: %w", err)
+		}
+	}
+
+	ech := c.cfg.ECH
+	if ech == nil && passthroughSchemes[link.Scheme] {
+		ech = linkECH(link.Query())
+	}
+	c.xTLS = nil
+	if ech != nil {
+		if c.xTLS, err = echTLS(out, ech, link.Hostname()); err != nil {
+			return nil, fmt.Errorf("ech: %w", err)
+		}
+	}
+	if c.cfg.ClientCert != nil {
+		if c.xTLS, err = clientCertTLS(out, c.xTLS, c.cfg.ClientCert, link.Hostname()); err != nil {
+			return nil, fmt.Errorf("client cert: %w", err)
+		}
+	}
+
+	if out.Tag == "" {
+		out.Tag = "proxy"
+	}
+
+	cfg := &conf.Config{
+		LogConfig:       xrayLogConfig(c.cfg.XRayLogType, xRayLogLevel(c.log(LogXRay).Handler())),
+		OutboundConfigs: []conf.OutboundDetourConfig{*out},
+	}
+	if o := c.cfg.Obfuscation; o != nil && o.Fragment != "" && (c.cfg.Dialer != nil || c.xTLS != nil) {
+		// The fragment outbound dials the server by itself, neither with Dialer nor TLS of the dialer.
+		return nil, errors.New("obfuscation: fragment is not supported with Dialer, ECH or client certificate")
+	}
+	if err = addObfuscation(cfg, &cfg.OutboundConfigs[0], c.cfg.Obfuscation); err != nil {
+		return nil, fmt.Errorf("obfuscation: %w", err)
+	}
+	addMemoryPolicy(cfg, c.cfg.MemoryBudget.plan())
+	addTimeouts(cfg, &cfg.OutboundConfigs[0], c.cfg.Timeouts)
+	if err = addReverse(cfg, out.Tag, c.cfg.Reverse); err != nil {
+		return nil, err
+	}
+	if err = addRouting(cfg, out.Tag, c.cfg.Routing, c.gatewayInterface); err != nil {
+		return nil, fmt.Errorf("%w: routing: %w", ErrInvalidConfig, err)
+	}
+	if c.qosClasses, err = addQoS(cfg, c.cfg.QoS); err != nil {
+		return nil, fmt.Errorf("%w: qos: %w", ErrInvalidConfig, err)
+	}
+	if c.balancerServers, err = c.addBalancer(cfg, c.balanced); err != nil {
+		return nil, fmt.Errorf("balancer: %w", err)
+	}
+	// XRay socks inbound can not listen on Unix domain socket, unixInbound serves it instead.
+	if c.cfg.InboundProxy.Path == "" {
+		in, err := inbound.BuildInboundDetourConfig()
+		if err != nil {
+			return nil, fmt.Errorf("build inbound: %w", err)
+		}
+		cfg.InboundConfigs = append([]conf.InboundDetourConfig{*in}, cfg.InboundConfigs...)
+	}
+	for i := range cfg.InboundConfigs { // QoS class and balanced server inbounds carry TUN traffic too.
+		cfg.InboundConfigs[i].SniffingConfig = c.cfg.Sniffing.xray()
+	}
+
+	return cfg, nil
+}
+
+// newXrayInstance creates XRay core instance from config.
+func newXrayInstance(cfg *conf.Config) (*core.Instance, error) {
+	built, err := cfg.Build()
+	if err != nil {
+		return nil, fmt.Errorf("build config: %w", err)
+	}
+
+	inst, err := core.New(built)
+	if err != nil {
+		return nil, fmt.Errorf("new instance: %w", err)
+	}
+
+	return inst, nil
+}
+
+// redactedKeys are XRay json config keys holding credentials.
+var redactedKeys = map[string]bool{
+	"id": true, "password": true, "pass": true, "secretKey": true, "preSharedKey": true, "privateKey": true,
+	"seed": true, "key": true,
+}
+
+// redactedValue replaces credentials in redacted XRay json config.
+const redactedValue = "<redacted>"
+
+// marshalXrayConfig marshals XRay config into indented json without null values,
+// credentials are replaced with redactedValue if redact is set.
+func marshalXrayConfig(cfg *conf.Config, redact bool) ([]byte, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("marshal xray config: %w", err)
+	}
+
+	var v any
+	if err = json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("marshal xray config: %w", err)
+	}
+
+	return json.MarshalIndent(cleanXrayJSON(v, redact), "", "  ")
+}
+
+func cleanXrayJSON(v any, redact bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			switch {
+			case val == nil:
+				delete(v, k)
+			case redact && redactedKeys[k]:
+				v[k] = redactedValue
+			default:
+				v[k] = cleanXrayJSON(val, redact)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = cleanXrayJSON(v[i], redact)
+		}
+	}
+
+	return v
+}
+
+// fixOutbound amends outbound config built by XRay-knife for the settings it handles incompletely.
+func (c *Client) fixOutbound(protocol xray.Protocol, out *conf.OutboundDetourConfig) error {
+	switch p := protocol.(type) {
+	case *xray.Trojan:
+		return fixTrojanOutbound(p, out)
+	case *xray.Vmess:
+		return fixVmessOutbound(p, out, c.cfg.VMess)
+	}
+
+	return nil
+}
+
+// linkMux reads mux settings from link query: "mux=1" enables mux,
+// "muxConcurrency" (or "mux_concurrency") sets the number of concurrent streams per connection.
+func linkMux(q url.Values) (*conf.MuxConfig, error) {
+	enabled, _ := strconv.ParseBool(q.Get("mux"))
+	if !enabled {
+		return nil, nil
+	}
+
+	mux := &conf.MuxConfig{Enabled: true, Concurrency: 8}
+	concurrency := q.Get("muxConcurrency")
+	if concurrency == "" {
+		concurrency = q.Get("mux_concurrency")
+	}
+	if concurrency != "" {
+		n, err := strconv.ParseInt(concurrency, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mux concurrency %q: %w", concurrency, err)
+		}
+		mux.Concurrency = int16(n)
+	}
+
+	return mux, nil
+}
+
+// xrayLogConfig maps log type and level into XRay json log config.
+func xrayLogConfig(logType xapplog.LogType, level xcommlog.Severity) *conf.LogConfig {
+	if logType == xapplog.LogType_None || level == xcommlog.Severity_Unknown {
+		return &conf.LogConfig{AccessLog: "none", ErrorLog: "none", LogLevel: "none"}
+	}
+
+	cfg := &conf.LogConfig{}
+	switch level {
+	case xcommlog.Severity_Debug:
+		cfg.LogLevel = "debug"
+	case xcommlog.Severity_Info:
+		cfg.LogLevel = "info"
+	case xcommlog.Severity_Warning:
+		cfg.LogLevel = "warning"
+	case xcommlog.Severity_Error:
+		cfg.LogLevel = "error"
+	}
+
+	return cfg
+}
@@ -0,0 +1,360 @@
+// Package config implements tun configuration file with named connection profiles.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xtls/xray-core/infra/conf"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/schedule"
+)
+
+// ErrNotFound is returned when profile with requested name does not exist.
+var ErrNotFound = errors.New("profile not found")
+
+// File is the configuration file contents.
+type File struct {
+	// Version is the version of the file format, files of older versions are migrated by Load.
+	Version int `json:"version"`
+	// Active is the name of the profile or the group daemon mode connects to.
+	Active string `json:"active,omitempty"`
+	// Settings are client settings, environment and command line flags take precedence.
+	Settings Settings `json:"settings,omitzero"`
+	// Schedule limits daemon mode connection to recurring time windows (default: always connected).
+	Schedule *Schedule `json:"schedule,omitempty"`
+	// Rotation switches daemon mode connection between profiles periodically, it replaces the active profile.
+	Rotation *Rotation `json:"rotation,omitempty"`
+	// Groups are named proxy groups of profiles, a group can be made active like a profile.
+	Groups   []*Group   `json:"groups,omitempty"`
+	Profiles []*Profile `json:"profiles"`
+}
+
+// Rotation is a daemon mode policy changing the exit IP: the connection is switched to another
+// profile of the group every interval or amount of traffic, whichever comes first.
+type Rotation struct {
+	// Profiles are names of the group profiles.
+	Profiles []string `json:"profiles"`
+	// Every is the rotation interval, e.g. "30m".
+	Every string `json:"every,omitempty"`
+	// EveryGiB is the traffic of the connection in GiB (both directions) to rotate after.
+	EveryGiB float64 `json:"every_gib,omitempty"`
+	// Random picks random profile of the group, the next one in order by default.
+	Random bool `json:"random,omitempty"`
+}
+
+// RotationPolicy is Rotation with resolved profile links.
+type RotationPolicy struct {
+	Names    []string
+	Links    []string
+	Interval time.Duration // Zero if not rotated by time.
+	Bytes    int64         // Zero if not rotated by traffic.
+	Random   bool
+}
+
+// RotationPolicy returns rotation policy of the configuration, nil if rotation is not set.
+func (f *File) RotationPolicy() (*RotationPolicy, error) {
+	r := f.Rotation
+	if r == nil {
+		return nil, nil
+	}
+	if len(r.Profiles) == 0 {
+		return nil, errors.New("invalid rotation: no profiles")
+	}
+	if r.Every == "" && r.EveryGiB == 0 {
+		return nil, errors.New("invalid rotation: every or every_gib is required")
+	}
+
+	p := &RotationPolicy{Bytes: int64(r.EveryGiB * (1 << 30)), Random: r.Random}
+	if r.EveryGiB < 0 {
+		return nil, fmt.Errorf("invalid rotation: negative every_gib %v", r.EveryGiB)
+	}
+	if r.Every != "" {
+		var err error
+		if p.Interval, err = time.ParseDuration(r.Every); err != nil || p.Interval < time.Minute {
+			return nil, fmt.Errorf("invalid rotation: every %q, must be at least 1m", r.Every)
+		}
+	}
+	for _, name := range r.Profiles {
+		profile, err := f.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotation: %w", err)
+		}
+		link, err := profile.ConnectLink()
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotation: %w", err)
+		}
+		p.Names = append(p.Names, name)
+		p.Links = append(p.Links, link)
+	}
+
+	return p, nil
+}
+
+// Next returns index of the profile to rotate to from current, random picks another random profile.
+func (p *RotationPolicy) Next(current int, random func(n int) int) int {
+	n := len(p.Links)
+	if n < 2 {
+		return 0
+	}
+	if !p.Random {
+		return (current + 1) % n
+	}
+	if current < 0 || current >= n {
+		return random(n)
+	}
+
+	return (current + 1 + random(n-1)) % n // Any but current.
+}
+
+// Schedule is a daemon mode connection window of cron expressions in local time, see schedule.Cron,
+// e.g. Connect "0 9 * * 1-5" and Disconnect "0 18 * * 1-5" tunnel during work hours only.
+type Schedule struct {
+	Connect    string `json:"connect"`
+	Disconnect string `json:"disconnect"`
+}
+
+// Parse parses schedule expressions.
+func (s *Schedule) Parse() (*schedule.Schedule, error) {
+	sched, err := schedule.New(s.Connect, s.Disconnect)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+
+	return sched, nil
+}
+
+// Profile is a named connection config, either share link or XRay outbound json config.
+type Profile struct {
+	Name string `json:"name"`
+	// Link is share link (e.g. "vless://...") or WireGuard config.
+	Link string `json:"link,omitempty"`
+	// Outbound is XRay outbound json config, used if Link is empty.
+	Outbound json.RawMessage `json:"outbound,omitempty"`
+	// Settings override client settings of the file when connected to the profile, e.g. MTU, routes or DNS
+	// of the server network. Environment and command line flags still take precedence.
+	Settings *Settings `json:"settings,omitempty"`
+}
+
+// DefaultPath returns default configuration file path in user config directory.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("user config dir: %w", err)
+	}
+
+	return filepath.Join(dir, "goxray", "tun.json"), nil
+}
+
+// Load reads configuration file, missing file is loaded as empty configuration. File of older Version is
+// migrated and saved, the old file is kept at BackupPath.
+func Load(path string) (*File, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &File{Version: Version}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	f, version, err := parseFile(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if version < Version {
+		if err = migrateFile(path, b, version, f); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// Save writes configuration file of the current Version, the file is replaced atomically.
+func (f *File) Save(path string) error {
+	b, err := json.MarshalIndent(f.versioned(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal config: %w", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create config dir: %w", err)
+	}
+	if err = writeFile(path, append(b, '\n')); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+
+	return nil
+}
+
+// writeFile replaces file atomically with private file of b, as profiles contain credentials.
+func writeFile(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get returns profile by name.
+func (f *File) Get(name string) (*Profile, error) {
+	i := f.index(name)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+
+	return f.Profiles[i], nil
+}
+
+// ActiveProfile returns the profile daemon mode connects to.
+func (f *File) ActiveProfile() (*Profile, error) {
+	if f.Active == "" {
+		return nil, fmt.Errorf("%w: no active profile", ErrNotFound)
+	}
+
+	return f.Get(f.Active)
+}
+
+// ProfileSettings returns settings of the file overridden by settings of profile name,
+// the file settings if the profile does not exist or has no settings.
+func (f *File) ProfileSettings(name string) Settings {
+	p, err := f.Get(name)
+	if err != nil || p.Settings == nil {
+		return f.Settings
+	}
+
+	return f.Settings.Override(*p.Settings)
+}
+
+// SetActive makes existing profile or group active.
+func (f *File) SetActive(name string) error {
+	if _, err := f.Get(name); err != nil {
+		if _, groupErr := f.Group(name); groupErr != nil {
+			return err
+		}
+	}
+	f.Active = name
+
+	return nil
+}
+
+// Set adds profile or replaces the profile with the same name.
+func (f *File) Set(p *Profile) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	if i := f.index(p.Name); i >= 0 {
+		f.Profiles[i] = p
+	} else {
+		f.Profiles = append(f.Profiles, p)
+	}
+
+	return nil
+}
+
+// Remove deletes profile by name.
+func (f *File) Remove(name string) error {
+	i := f.index(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+	f.Profiles = slices.Delete(f.Profiles, i, i+1)
+	if f.Active == name {
+		f.Active = ""
+	}
+
+	return nil
+}
+
+// Match returns profiles matching query: the profile named query, otherwise the profiles with remark query,
+// otherwise the ones with name or remark containing query. Remarks and substrings are matched case-insensitively.
+func (f *File) Match(query string) []*Profile {
+	if i := f.index(query); i >= 0 {
+		return f.Profiles[i : i+1]
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+
+	var remarked, contained []*Profile
+	for _, p := range f.Profiles {
+		remark := strings.ToLower(p.Remark())
+		switch {
+		case remark == query:
+			remarked = append(remarked, p)
+		case strings.Contains(strings.ToLower(p.Name), query) || strings.Contains(remark, query):
+			contained = append(contained, p)
+		}
+	}
+	if len(remarked) > 0 {
+		return remarked
+	}
+
+	return contained
+}
+
+func (f *File) index(name string) int {
+	return slices.IndexFunc(f.Profiles, func(p *Profile) bool { return p.Name == name })
+}
+
+// Validate checks that profile is named and has a connection config.
+func (p *Profile) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("profile name is empty")
+	}
+	if p.Link == "" && len(p.Outbound) == 0 {
+		return fmt.Errorf("profile %q: link or outbound is required", p.Name)
+	}
+
+	return nil
+}
+
+// Remark returns the remark of the profile link, see client.LinkRemark.
+func (p *Profile) Remark() string {
+	return client.LinkRemark(p.Link)
+}
+
+// ConnectLink returns the link to pass to client.Client Connect.
+func (p *Profile) ConnectLink() (string, error) {
+	if p.Link != "" {
+		return p.Link, nil
+	}
+
+	return p.ShareLink()
+}
+
+// ShareLink returns standard share link of the profile, see client.ShareLink.
+func (p *Profile) ShareLink() (string, error) {
+	if p.Link != "" {
+		return client.ShareLink(p.Link)
+	}
+	if len(p.Outbound) == 0 {
+		return "", fmt.Errorf("profile %q: link or outbound is required", p.Name)
+	}
+
+	out := &conf.OutboundDetourConfig{}
+	if err := json.Unmarshal(p.Outbound, out); err != nil {
+		return "", fmt.Errorf("profile %q: parse outbound: %w", p.Name, err)
+	}
+
+	return client.OutboundShareLink(out, p.Name)
+}
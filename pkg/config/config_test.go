@@ -0,0 +1,262 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goxray", "tun.json")
+
+	f, err := Load(path)
+	require.NoError(t, err)
+	require.Empty(t, f.Profiles)
+
+	require.NoError(t, f.Set(&Profile{Name: "home", Link: "vless://id@127.0.0.1:443"}))
+	require.NoError(t, f.Set(&Profile{Name: "work", Link: "trojan://pass@127.0.0.1:443"}))
+	require.NoError(t, f.Set(&Profile{Name: "home", Link: "vless://id@127.0.0.2:443"}))
+	require.ErrorContains(t, f.Set(&Profile{Name: "empty"}), "link or outbound is required")
+	require.NoError(t, f.Save(path))
+
+	st, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+
+	f, err = Load(path)
+	require.NoError(t, err)
+	require.Len(t, f.Profiles, 2)
+	p, err := f.Get("home")
+	require.NoError(t, err)
+	require.Equal(t, "vless://id@127.0.0.2:443", p.Link)
+
+	_, err = f.ActiveProfile()
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, f.SetActive("missing"), ErrNotFound)
+	require.NoError(t, f.SetActive("home"))
+	p, err = f.ActiveProfile()
+	require.NoError(t, err)
+	require.Equal(t, "home", p.Name)
+
+	require.NoError(t, f.Remove("home"))
+	require.Empty(t, f.Active)
+	_, err = f.Get("home")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, f.Remove("home"), ErrNotFound)
+}
+
+func TestFile_Match(t *testing.T) {
+	f := &File{Profiles: []*Profile{
+		{Name: "de-1", Link: "vless://id@127.0.0.1:443#Germany%20Frankfurt"},
+		{Name: "de-2", Link: "vless://id@127.0.0.2:443#Germany%20Berlin"},
+		{Name: "nl", Link: "trojan://pass@127.0.0.3:443#Amsterdam"},
+		{Name: "amsterdam-backup", Link: "trojan://pass@127.0.0.4:443"},
+	}}
+	names := func(ps []*Profile) []string {
+		var list []string
+		for _, p := range ps {
+			list = append(list, p.Name)
+		}
+		return list
+	}
+
+	require.Equal(t, []string{"de-1"}, names(f.Match("de-1")), "name")
+	require.Equal(t, []string{"nl"}, names(f.Match("amsterdam")), "remark wins over substrings")
+	require.Equal(t, []string{"de-2"}, names(f.Match("berlin")))
+	require.Equal(t, []string{"de-1", "de-2"}, names(f.Match("GERMANY")))
+	require.Equal(t, []string{"amsterdam-backup"}, names(f.Match("backup")))
+	require.Empty(t, f.Match("paris"))
+	require.Empty(t, f.Match(" "))
+}
+
+func TestFile_ProfileSettings(t *testing.T) {
+	f := &File{
+		Settings: Settings{MTU: 1400, BootstrapDNS: []string{"1.1.1.1"}, InboundPort: 1080},
+		Profiles: []*Profile{
+			{Name: "full", Link: "vless://id@127.0.0.1:443"},
+			{Name: "work", Link: "vless://id@127.0.0.2:443", Settings: &Settings{
+				MTU:          1280,
+				BootstrapDNS: []string{"10.0.0.53"},
+				Routing:      []Route{{Domains: []string{"domain:corp.example"}, Action: "direct"}},
+			}},
+		},
+	}
+
+	require.Equal(t, f.Settings, f.ProfileSettings("full"))
+	require.Equal(t, f.Settings, f.ProfileSettings("missing"))
+	s := f.ProfileSettings("work")
+	require.Equal(t, 1280, s.MTU)
+	require.Equal(t, []string{"10.0.0.53"}, s.BootstrapDNS)
+	require.Equal(t, 1080, s.InboundPort, "file settings not overridden are kept")
+	require.Len(t, s.Routing, 1)
+	require.Equal(t, 1400, f.Settings.MTU, "file settings are not changed")
+}
+
+func TestProfile_ShareLink(t *testing.T) {
+	p := &Profile{Name: "json", Outbound: []byte(`{
+		"protocol": "vless",
+		"settings": {"vnext": [{"address": "127.0.0.7", "port": 443, "users": [{"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "encryption": "none"}]}]},
+		"streamSettings": {"network": "ws", "security": "tls", "wsSettings": {"path": "/ws", "host": "cdn.example.com"}, "tlsSettings": {"serverName": "example.com"}}
+	}`)}
+
+	link, err := p.ShareLink()
+	require.NoError(t, err)
+	require.Equal(t, "vless://b831381d-6324-4d53-ad4f-8cda48b30811@127.0.0.7:443?encryption=none&host=cdn.example.com&path=%2Fws&security=tls&sni=example.com&type=ws#json", link)
+
+	connect, err := p.ConnectLink()
+	require.NoError(t, err)
+	require.Equal(t, link, connect)
+
+	p = &Profile{Name: "link", Link: "trojan-go://pass@127.0.0.7:443"}
+	link, err = p.ShareLink()
+	require.NoError(t, err)
+	require.Equal(t, "trojan://pass@127.0.0.7:443?fp=chrome&security=tls&type=tcp", link)
+}
+
+func TestSchedule_Parse(t *testing.T) {
+	s, err := (&Schedule{Connect: "0 9 * * 1-5", Disconnect: "0 18 * * 1-5"}).Parse()
+	require.NoError(t, err)
+	require.True(t, s.Active(time.Date(2025, time.January, 15, 12, 0, 0, 0, time.Local)))
+
+	_, err = (&Schedule{Connect: "0 9 * * 1-5"}).Parse()
+	require.ErrorContains(t, err, "invalid schedule: disconnect")
+}
+
+func TestFile_RotationPolicy(t *testing.T) {
+	f := &File{Profiles: []*Profile{
+		{Name: "nl", Link: "vless://id@127.0.0.1:443"},
+		{Name: "de", Link: "vless://id@127.0.0.2:443"},
+		{Name: "fi", Link: "vless://id@127.0.0.3:443"},
+	}}
+	p, err := f.RotationPolicy()
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	f.Rotation = &Rotation{Profiles: []string{"nl", "de", "fi"}, Every: "30m", EveryGiB: 0.5}
+	p, err = f.RotationPolicy()
+	require.NoError(t, err)
+	require.Equal(t, &RotationPolicy{
+		Names:    []string{"nl", "de", "fi"},
+		Links:    []string{"vless://id@127.0.0.1:443", "vless://id@127.0.0.2:443", "vless://id@127.0.0.3:443"},
+		Interval: 30 * time.Minute,
+		Bytes:    512 << 20,
+	}, p)
+	require.Equal(t, 0, p.Next(-1, nil))
+	require.Equal(t, 0, p.Next(2, nil))
+
+	p.Random = true
+	last := func(n int) int { return n - 1 }
+	require.Equal(t, 2, p.Next(-1, last))
+	require.Equal(t, 1, p.Next(2, last), "current profile is skipped")
+
+	for _, r := range []*Rotation{
+		{Profiles: []string{"nl"}},
+		{Every: "30m"},
+		{Profiles: []string{"nl"}, Every: "10s"},
+		{Profiles: []string{"nl"}, EveryGiB: -1},
+		{Profiles: []string{"missing"}, Every: "30m"},
+	} {
+		f.Rotation = r
+		_, err = f.RotationPolicy()
+		require.Error(t, err, r)
+	}
+}
+
+func TestFile_GroupPolicy(t *testing.T) {
+	f := &File{
+		Active: "nl",
+		Profiles: []*Profile{
+			{Name: "nl", Link: "vless://id@127.0.0.1:443"},
+			{Name: "de", Link: "vless://id@127.0.0.2:443"},
+		},
+		Groups: []*Group{
+			{Name: "manual", Type: GroupSelect, Profiles: []string{"nl", "de"}},
+			{Name: "fastest", Type: GroupURLTest, Profiles: []string{"nl", "de"}, Every: "10m"},
+		},
+	}
+	p, err := f.GroupPolicy()
+	require.NoError(t, err)
+	require.Nil(t, p, "profile is active")
+
+	require.NoError(t, f.SetActive("manual"))
+	require.NoError(t, f.Select("manual", "de"))
+	p, err = f.GroupPolicy()
+	require.NoError(t, err)
+	require.Equal(t, &GroupPolicy{
+		Name:     "manual",
+		Type:     GroupSelect,
+		Names:    []string{"nl", "de"},
+		Links:    []string{"vless://id@127.0.0.1:443", "vless://id@127.0.0.2:443"},
+		Selected: 1,
+	}, p)
+	require.Equal(t, 1, p.Pick(nil, 0, nil))
+	require.ErrorIs(t, f.Select("manual", "fi"), ErrNotFound)
+	require.ErrorContains(t, f.Select("fastest", "nl"), "only select groups")
+	require.ErrorIs(t, f.SetActive("missing"), ErrNotFound)
+
+	require.NoError(t, f.SetActive("fastest"))
+	p, err = f.GroupPolicy()
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Minute, p.Interval)
+
+	for _, g := range []*Group{
+		{Name: "", Type: GroupSelect, Profiles: []string{"nl"}},
+		{Name: "g", Type: "round-robin", Profiles: []string{"nl"}},
+		{Name: "nl", Type: GroupSelect, Profiles: []string{"nl"}},
+		{Name: "g", Type: GroupFallback},
+		{Name: "g", Type: GroupSelect, Profiles: []string{"nl"}, Selected: "de"},
+		{Name: "g", Type: GroupFallback, Profiles: []string{"nl"}, Every: "10s"},
+		{Name: "g", Type: GroupFallback, Profiles: []string{"missing"}},
+		{Name: "g", Type: GroupSelect, Profiles: []string{"nl"}, Countries: []string{"NL"}},
+		{Name: "g", Type: GroupURLTest, Profiles: []string{"nl"}, Countries: []string{"NLD"}},
+		{Name: "g", Type: GroupURLTest, Profiles: []string{"nl"}, MaxLatency: "fast"},
+	} {
+		_, err = g.Policy(f)
+		require.Error(t, err, g)
+	}
+}
+
+func TestGroupPolicy_Pick(t *testing.T) {
+	ms := time.Millisecond
+	last := func(n int) int { return n - 1 }
+
+	p := &GroupPolicy{Type: GroupURLTest}
+	require.Equal(t, 2, p.Pick([]time.Duration{300 * ms, 0, 100 * ms}, -1, nil))
+	require.Equal(t, 0, p.Pick([]time.Duration{120 * ms, 0, 100 * ms}, 0, nil), "current is within tolerance")
+	require.Equal(t, 2, p.Pick([]time.Duration{300 * ms, 0, 100 * ms}, 1, nil), "current failed")
+	require.Equal(t, -1, p.Pick([]time.Duration{0, 0}, 0, nil))
+
+	p.Type = GroupFallback
+	require.Equal(t, 1, p.Pick([]time.Duration{0, 300 * ms, 100 * ms}, 2, nil))
+
+	p.Type = GroupLoadBalance
+	require.Equal(t, 0, p.Pick([]time.Duration{300 * ms, 0, 100 * ms}, 0, last), "current is kept")
+	require.Equal(t, 2, p.Pick([]time.Duration{300 * ms, 0, 100 * ms}, 1, last))
+}
+
+func TestGroupPolicy_Filter(t *testing.T) {
+	f := &File{
+		Profiles: []*Profile{{Name: "us", Link: "vless://id@127.0.0.1:443"}, {Name: "nl", Link: "vless://id@127.0.0.2:443"}},
+		Groups: []*Group{{
+			Name: "us", Type: GroupURLTest, Profiles: []string{"us", "nl"}, Countries: []string{"us"}, MaxLatency: "300ms",
+		}},
+	}
+	_, err := f.Groups[0].Policy(f)
+	require.ErrorContains(t, err, "profile with the same name exists")
+	f.Groups[0].Name = "fastest-us"
+	p, err := f.Groups[0].Policy(f)
+	require.NoError(t, err)
+	require.Equal(t, []string{"US"}, p.Countries)
+	require.Equal(t, 300*time.Millisecond, p.MaxLatency)
+
+	ms := time.Millisecond
+	require.Equal(t, []time.Duration{100 * ms, 0, 0, 0},
+		p.Filter([]time.Duration{100 * ms, 50 * ms, 400 * ms, 100 * ms}, []string{"us", "NL", "US", ""}))
+	require.Equal(t, 0, p.Pick(p.Filter([]time.Duration{100 * ms, 50 * ms}, []string{"US", "NL"}), -1, nil))
+
+	p.Countries = nil
+	require.Equal(t, []time.Duration{100 * ms, 50 * ms, 0}, p.Filter([]time.Duration{100 * ms, 50 * ms, 400 * ms}, nil))
+}
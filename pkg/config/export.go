@@ -0,0 +1,243 @@
+package config
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// exportFormat identifies exported configuration, it is the additional data of the encryption too.
+const exportFormat = "goxray-config"
+
+// exportVersion is the version of the export format.
+const exportVersion = 1
+
+// Key derivation parameters of encrypted exports, stored in the export so they can be raised later.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Maximum key derivation parameters accepted from an export, larger ones would exhaust memory or CPU.
+const (
+	maxScryptN = 1 << 20
+	maxScryptR = 8
+	maxScryptP = 1
+)
+
+var (
+	// ErrEncrypted is returned when importing encrypted configuration without password.
+	ErrEncrypted = errors.New("exported config is encrypted, password is required")
+	// ErrPassword is returned when exported configuration can not be decrypted with the password.
+	ErrPassword = errors.New("wrong password or corrupted export")
+)
+
+// export is the portable file of the configuration, with either Config or Encrypted set.
+type export struct {
+	Format    string          `json:"format"`
+	Version   int             `json:"version"`
+	Config    json.RawMessage `json:"config,omitempty"` // File of any supported Version.
+	Encrypted *sealed         `json:"encrypted,omitempty"`
+}
+
+// sealed is configuration json encrypted with XChaCha20-Poly1305 with the key derived from password by scrypt.
+type sealed struct {
+	KDF   string `json:"kdf"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// Export returns portable file of the configuration with profiles, groups, schedule, rotation and settings,
+// encrypted with password unless it is empty.
+func (f *File) Export(password string) ([]byte, error) {
+	plain, err := json.Marshal(f.versioned())
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	e := export{Format: exportFormat, Version: exportVersion, Config: plain}
+	if password != "" {
+		s := &sealed{KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 16)}
+		if _, err = rand.Read(s.Salt); err != nil {
+			return nil, err
+		}
+		aead, err := s.aead(password)
+		if err != nil {
+			return nil, err
+		}
+		s.Nonce = make([]byte, aead.NonceSize())
+		if _, err = rand.Read(s.Nonce); err != nil {
+			return nil, err
+		}
+		s.Data = aead.Seal(nil, s.Nonce, plain, []byte(exportFormat))
+		e.Config, e.Encrypted = nil, s
+	}
+
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal export: %w", err)
+	}
+
+	return append(b, '\n'), nil
+}
+
+// ParseExport reads configuration exported by File.Export, password decrypts encrypted export.
+func ParseExport(b []byte, password string) (*File, error) {
+	var e export
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("parse export: %w", err)
+	}
+	if e.Format != exportFormat {
+		return nil, errors.New("not an exported config")
+	}
+	if e.Version > exportVersion {
+		return nil, fmt.Errorf("export version %d is newer than supported, update the client", e.Version)
+	}
+	if e.Encrypted == nil {
+		if e.Config == nil {
+			return &File{}, nil
+		}
+		return parseExported(e.Config)
+	}
+
+	if password == "" {
+		return nil, ErrEncrypted
+	}
+	s := e.Encrypted
+	if s.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation %q", s.KDF)
+	}
+	if s.N > maxScryptN || s.R > maxScryptR || s.P > maxScryptP {
+		return nil, fmt.Errorf("key derivation parameters n=%d r=%d p=%d exceed the maximum", s.N, s.R, s.P)
+	}
+	aead, err := s.aead(password)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Nonce) != aead.NonceSize() {
+		return nil, ErrPassword
+	}
+	plain, err := aead.Open(nil, s.Nonce, s.Data, []byte(exportFormat))
+	if err != nil {
+		return nil, ErrPassword
+	}
+
+	return parseExported(plain)
+}
+
+// parseExported parses the configuration of the export, migrated to the current Version.
+func parseExported(b []byte) (*File, error) {
+	f, _, err := parseFile(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse export: %w", err)
+	}
+
+	return f, nil
+}
+
+func (s *sealed) aead(password string) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), s.Salt, s.N, s.R, s.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+
+	return chacha20poly1305.NewX(key)
+}
+
+// Sanitized returns copy of the configuration safe to share: profile links and outbounds with the server
+// credentials are removed (profile names and settings are kept, so groups and rotation still refer to them),
+// as are the client key password, client certificate paths, upstream proxy and metrics push credentials.
+func (f *File) Sanitized() *File {
+	s := *f
+	s.Settings = f.Settings.sanitized()
+	s.Profiles = nil
+	for _, p := range f.Profiles {
+		sp := &Profile{Name: p.Name}
+		if p.Settings != nil {
+			settings := p.Settings.sanitized()
+			sp.Settings = &settings
+		}
+		s.Profiles = append(s.Profiles, sp)
+	}
+
+	return &s
+}
+
+func (s Settings) sanitized() Settings {
+	s.ClientCert, s.ClientKey, s.ClientKeyPassword = "", "", ""
+	s.APIToken, s.APIReadToken, s.APICert, s.APIKey, s.APIClientCA = "", "", "", "", ""
+	if u, err := url.Parse(s.Upstream); err == nil && u.User != nil {
+		u.User = nil
+		s.Upstream = u.String()
+	}
+	if u, err := url.Parse(s.MetricsPush); err == nil && u.User != nil {
+		u.User = nil
+		s.MetricsPush = u.String()
+	}
+
+	return s
+}
+
+// Import applies imported configuration in: with merge its profiles and groups replace the ones of the same
+// name and its settings override the current ones, otherwise it replaces the whole configuration.
+// Profiles without link and outbound (see File.Sanitized) keep the link of the current profile of the same
+// name, names of the ones with no such profile are returned, they are not imported.
+func (f *File) Import(in *File, merge bool) (skipped []string) {
+	var profiles []*Profile
+	for _, p := range in.Profiles {
+		p := *p
+		if p.Link == "" && len(p.Outbound) == 0 {
+			current, err := f.Get(p.Name)
+			if err != nil {
+				skipped = append(skipped, p.Name)
+				continue
+			}
+			p.Link, p.Outbound = current.Link, current.Outbound
+		}
+		profiles = append(profiles, &p)
+	}
+
+	if !merge {
+		*f = File{Settings: in.Settings, Schedule: in.Schedule, Rotation: in.Rotation, Groups: in.Groups, Profiles: profiles}
+		_ = f.SetActive(in.Active) // Not kept if its profile was skipped.
+		return skipped
+	}
+
+	for _, p := range profiles {
+		if i := f.index(p.Name); i >= 0 {
+			f.Profiles[i] = p
+		} else {
+			f.Profiles = append(f.Profiles, p)
+		}
+	}
+	for _, g := range in.Groups {
+		if i := slices.IndexFunc(f.Groups, func(c *Group) bool { return c.Name == g.Name }); i >= 0 {
+			f.Groups[i] = g
+		} else {
+			f.Groups = append(f.Groups, g)
+		}
+	}
+	f.Settings = f.Settings.Override(in.Settings)
+	if in.Schedule != nil {
+		f.Schedule = in.Schedule
+	}
+	if in.Rotation != nil {
+		f.Rotation = in.Rotation
+	}
+	if in.Active != "" {
+		_ = f.SetActive(in.Active)
+	}
+
+	return skipped
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// GroupType is how a proxy group picks its profile.
+type GroupType string
+
+const (
+	// GroupSelect uses the profile selected by the user, the first one by default.
+	GroupSelect GroupType = "select"
+	// GroupURLTest uses the profile with the lowest latency of probes through it, probed every interval.
+	GroupURLTest GroupType = "url-test"
+	// GroupFallback uses the first profile in order passing probes, probed every interval.
+	GroupFallback GroupType = "fallback"
+	// GroupLoadBalance spreads connections over the profiles passing probes, a destination stays on its server
+	// (see client.BalancerOptions). The first server is a random one, kept as long as it passes probes.
+	GroupLoadBalance GroupType = "load-balance"
+)
+
+// DefaultGroupInterval is the probe interval of groups by default.
+const DefaultGroupInterval = 5 * time.Minute
+
+// Group is a named proxy group of profiles, the daemon connects to its pick when the group is active.
+type Group struct {
+	Name string    `json:"name"`
+	Type GroupType `json:"type"`
+	// Profiles are names of the group profiles, in order of preference for fallback groups.
+	Profiles []string `json:"profiles"`
+	// Selected is the profile of select group, the first one if empty.
+	Selected string `json:"selected,omitempty"`
+	// Every is the probe interval of url-test, fallback and load-balance groups, e.g. "10m"
+	// (default: DefaultGroupInterval).
+	Every string `json:"every,omitempty"`
+	// Countries limit url-test, fallback and load-balance groups to profiles exiting in the countries, codes
+	// like "US" as reported by the exit info endpoint of the probes (default: any country).
+	Countries []string `json:"countries,omitempty"`
+	// MaxLatency limits url-test, fallback and load-balance groups to profiles with lower probe latency,
+	// e.g. "300ms" (default: any latency).
+	MaxLatency string `json:"max_latency,omitempty"`
+}
+
+// GroupPolicy is Group with resolved profile links.
+type GroupPolicy struct {
+	Name     string
+	Type     GroupType
+	Names    []string
+	Links    []string
+	Selected int           // Index of the selected profile of select group.
+	Interval time.Duration // Probe interval, zero for select group.
+	// Countries are upper case exit country codes the profiles are limited to, empty for any country.
+	Countries  []string
+	MaxLatency time.Duration // Zero for any latency.
+}
+
+// Validate checks the group type.
+func (t GroupType) Validate() error {
+	switch t {
+	case GroupSelect, GroupURLTest, GroupFallback, GroupLoadBalance:
+		return nil
+	default:
+		return fmt.Errorf("unknown group type %q", t)
+	}
+}
+
+// Group returns group by name.
+func (f *File) Group(name string) (*Group, error) {
+	i := slices.IndexFunc(f.Groups, func(g *Group) bool { return g.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("%w: group %q", ErrNotFound, name)
+	}
+
+	return f.Groups[i], nil
+}
+
+// GroupPolicy returns policy of the active group, nil if the active profile is not a group.
+func (f *File) GroupPolicy() (*GroupPolicy, error) {
+	if f.Active == "" || f.index(f.Active) >= 0 {
+		return nil, nil
+	}
+	g, err := f.Group(f.Active)
+	if err != nil {
+		return nil, nil
+	}
+
+	return g.Policy(f)
+}
+
+// Policy resolves profiles of the group in f.
+func (g *Group) Policy(f *File) (*GroupPolicy, error) {
+	if err := g.validate(f); err != nil {
+		return nil, err
+	}
+
+	p := &GroupPolicy{Name: g.Name, Type: g.Type}
+	if g.Type != GroupSelect {
+		p.Interval = DefaultGroupInterval
+		if g.Every != "" {
+			p.Interval, _ = time.ParseDuration(g.Every)
+		}
+		if g.MaxLatency != "" {
+			p.MaxLatency, _ = time.ParseDuration(g.MaxLatency)
+		}
+		for _, c := range g.Countries {
+			p.Countries = append(p.Countries, strings.ToUpper(c))
+		}
+	}
+	for i, name := range g.Profiles {
+		profile, err := f.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group %q: %w", g.Name, err)
+		}
+		link, err := profile.ConnectLink()
+		if err != nil {
+			return nil, fmt.Errorf("invalid group %q: %w", g.Name, err)
+		}
+		p.Names = append(p.Names, name)
+		p.Links = append(p.Links, link)
+		if name == g.Selected {
+			p.Selected = i
+		}
+	}
+
+	return p, nil
+}
+
+// validate checks the group settings, f is checked for name conflicts.
+func (g *Group) validate(f *File) error {
+	if g.Name == "" {
+		return errors.New("invalid group: name is empty")
+	}
+	if err := g.Type.Validate(); err != nil {
+		return fmt.Errorf("invalid group %q: %w", g.Name, err)
+	}
+	if f.index(g.Name) >= 0 {
+		return fmt.Errorf("invalid group %q: profile with the same name exists", g.Name)
+	}
+	if len(g.Profiles) == 0 {
+		return fmt.Errorf("invalid group %q: no profiles", g.Name)
+	}
+	if g.Selected != "" && !slices.Contains(g.Profiles, g.Selected) {
+		return fmt.Errorf("invalid group %q: selected profile %q is not in the group", g.Name, g.Selected)
+	}
+	if g.Every != "" {
+		if d, err := time.ParseDuration(g.Every); err != nil || d < time.Minute {
+			return fmt.Errorf("invalid group %q: every %q, must be at least 1m", g.Name, g.Every)
+		}
+	}
+	if g.Type == GroupSelect && (len(g.Countries) > 0 || g.MaxLatency != "") {
+		return fmt.Errorf("invalid group %q: countries and max latency are not supported for select groups", g.Name)
+	}
+	if g.MaxLatency != "" {
+		if d, err := time.ParseDuration(g.MaxLatency); err != nil || d <= 0 {
+			return fmt.Errorf("invalid group %q: max latency %q", g.Name, g.MaxLatency)
+		}
+	}
+	for _, c := range g.Countries {
+		if len(c) != 2 || strings.ContainsFunc(c, func(r rune) bool { return !unicode.IsLetter(r) }) {
+			return fmt.Errorf("invalid group %q: country %q, two letter code expected", g.Name, c)
+		}
+	}
+
+	return nil
+}
+
+// Select makes profile the selection of select group name.
+func (f *File) Select(name, profile string) error {
+	g, err := f.Group(name)
+	if err != nil {
+		return err
+	}
+	if g.Type != GroupSelect {
+		return fmt.Errorf("group %q is %s, only select groups have a selection", name, g.Type)
+	}
+	if !slices.Contains(g.Profiles, profile) {
+		return fmt.Errorf("%w: %q in group %q", ErrNotFound, profile, name)
+	}
+	g.Selected = profile
+
+	return nil
+}
+
+// Filter returns latencies of the probes with zero (failed) latency of the profiles over MaxLatency or exiting
+// outside Countries, countries are the exit country codes of the probes (empty if unknown, such profiles are
+// filtered out if Countries are set).
+func (p *GroupPolicy) Filter(latencies []time.Duration, countries []string) []time.Duration {
+	filtered := slices.Clone(latencies)
+	for i, l := range filtered {
+		if p.MaxLatency > 0 && l > p.MaxLatency ||
+			len(p.Countries) > 0 && (i >= len(countries) || !slices.Contains(p.Countries, strings.ToUpper(countries[i]))) {
+			filtered[i] = 0
+		}
+	}
+
+	return filtered
+}
+
+// groupTolerance is the latency advantage a url-test group profile needs to replace the current one,
+// so the connection is not switched back and forth between profiles of similar latency.
+const groupTolerance = 50 * time.Millisecond
+
+// Pick returns index of the profile to use given latencies of the probes (zero if a probe failed) and
+// the index of the current profile (-1 if none), random picks a profile of load-balance group.
+// It returns -1 if no profile passed the probes.
+func (p *GroupPolicy) Pick(latencies []time.Duration, current int, random func(n int) int) int {
+	if p.Type == GroupSelect {
+		return p.Selected
+	}
+	var passed []int
+	for i, l := range latencies {
+		if l > 0 {
+			passed = append(passed, i)
+		}
+	}
+	if len(passed) == 0 {
+		return -1
+	}
+	currentPassed := slices.Contains(passed, current)
+
+	switch p.Type {
+	case GroupFallback:
+		return passed[0]
+	case GroupLoadBalance:
+		if currentPassed {
+			return current
+		}
+		return passed[random(len(passed))]
+	default: // GroupURLTest
+		best := slices.MinFunc(passed, func(a, b int) int { return cmp.Compare(latencies[a], latencies[b]) })
+		if currentPassed && latencies[current] <= latencies[best]+groupTolerance {
+			return current
+		}
+		return best
+	}
+}
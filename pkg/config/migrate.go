@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Version is the version of the configuration file format written by File.Save.
+const Version = 1
+
+// migrations upgrade configuration file json of version i to version i+1, a migration is added with every
+// change of the format which older files do not load correctly with.
+var migrations = []func(doc map[string]json.RawMessage) error{
+	// Files written before the format was versioned have no version, they are version 1 files otherwise.
+	func(map[string]json.RawMessage) error { return nil },
+}
+
+// parseFile parses configuration file json of any supported version, migrated to Version.
+// It returns the version of b.
+func parseFile(b []byte) (*File, int, error) {
+	doc := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, 0, err
+	}
+	var version int
+	if v, ok := doc["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil || version < 0 {
+			return nil, 0, fmt.Errorf("invalid version %s", v)
+		}
+	}
+	if version > Version {
+		return nil, 0, fmt.Errorf("config version %d is newer than supported, update the client", version)
+	}
+
+	if version < Version {
+		for i := version; i < Version; i++ {
+			if err := migrations[i](doc); err != nil {
+				return nil, 0, fmt.Errorf("migrate config from version %d: %w", i, err)
+			}
+		}
+		doc["version"] = json.RawMessage(strconv.Itoa(Version))
+		var err error
+		if b, err = json.Marshal(doc); err != nil {
+			return nil, 0, fmt.Errorf("migrate config: %w", err)
+		}
+	}
+	f := &File{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, 0, err
+	}
+
+	return f, version, nil
+}
+
+// BackupPath returns path of the backup of configuration file path of version, made before the file is migrated
+// to the current version, e.g. "tun.json.v1.bak".
+func BackupPath(path string, version int) string {
+	return path + ".v" + strconv.Itoa(version) + ".bak"
+}
+
+// migrateFile replaces configuration file path of old version with f migrated to Version, the old file is kept
+// at BackupPath.
+func migrateFile(path string, old []byte, version int, f *File) error {
+	if err := writeFile(BackupPath(path, version), old); err != nil {
+		return fmt.Errorf("back up config before migration: %w", err)
+	}
+	if err := f.Save(path); err != nil {
+		return fmt.Errorf("migrate config: %w", err)
+	}
+
+	return nil
+}
+
+// versioned returns copy of f with the current Version, to be written.
+func (f File) versioned() *File {
+	f.Version = Version
+
+	return &f
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_Migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tun.json")
+	old := []byte(`{"active": "home", "profiles": [{"name": "home", "link": "vless://id@127.0.0.1:443"}]}`)
+	require.NoError(t, os.WriteFile(path, old, 0o600))
+
+	f, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, Version, f.Version)
+	require.Equal(t, "home", f.Active)
+	require.Len(t, f.Profiles, 1)
+
+	backup, err := os.ReadFile(BackupPath(path, 0))
+	require.NoError(t, err)
+	require.Equal(t, old, backup)
+	st, err := os.Stat(BackupPath(path, 0))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(saved), `"version": 1`)
+
+	// The migrated file is loaded as is.
+	require.NoError(t, os.Remove(BackupPath(path, 0)))
+	_, err = Load(path)
+	require.NoError(t, err)
+	require.NoFileExists(t, BackupPath(path, 0))
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": 2, "profiles": []}`), 0o600))
+	_, err = Load(path)
+	require.ErrorContains(t, err, "newer than supported")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": "1"}`), 0o600))
+	_, err = Load(path)
+	require.ErrorContains(t, err, "invalid version")
+}
+
+func TestParseFile_Migrations(t *testing.T) {
+	defer func(m []func(map[string]json.RawMessage) error) { migrations = m }(migrations)
+	// A migration of unversioned files renaming the active profile field.
+	migrations = append(migrations[:0:0], func(doc map[string]json.RawMessage) error {
+		doc["active"] = doc["current"]
+		delete(doc, "current")
+		return nil
+	})
+
+	f, version, err := parseFile([]byte(`{"current": "home"}`))
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+	require.Equal(t, "home", f.Active)
+	require.Equal(t, Version, f.Version)
+
+	f, _, err = parseFile([]byte(`{"version": 1, "active": "work"}`))
+	require.NoError(t, err)
+	require.Equal(t, "work", f.Active)
+}
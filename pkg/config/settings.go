@@ -0,0 +1,1781 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goxray/tun/pkg/api"
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/metrics"
+	"github.com/goxray/tun/pkg/nat64"
+)
+
+// Environment variables configuring the client, see SettingsFromEnv.
+const (
+	EnvLink                 = "GOXRAY_LINK"                     // Connection link, used when no link argument is given.
+	EnvConfig               = "GOXRAY_CONFIG"                   // Configuration file path.
+	EnvInboundPort          = "GOXRAY_INBOUND_PORT"             // Settings.InboundPort.
+	EnvInboundAddress       = "GOXRAY_INBOUND_ADDRESS"          // Settings.InboundAddress.
+	EnvInboundSocket        = "GOXRAY_INBOUND_SOCKET"           // Settings.InboundSocket.
+	EnvInboundAllow         = "GOXRAY_INBOUND_ALLOW"            // Settings.InboundAllow, comma separated.
+	EnvInboundMaxConns      = "GOXRAY_INBOUND_MAX_CONNS"        // Settings.InboundMaxConns.
+	EnvInboundMaxConnsPerIP = "GOXRAY_INBOUND_MAX_CONNS_PER_IP" // Settings.InboundMaxConnsPerIP.
+	EnvUpstream             = "GOXRAY_UPSTREAM"                 // Settings.Upstream.
+	EnvSystemProxy          = "GOXRAY_SYSTEM_PROXY"             // Settings.SystemProxy, "true" or "1" to enable.
+	EnvPACListen            = "GOXRAY_PAC_LISTEN"               // Settings.PACListen.
+	EnvTUNAddress           = "GOXRAY_TUN_ADDRESS"              // Settings.TUNAddress.
+	EnvMTU                  = "GOXRAY_MTU"                      // Settings.MTU.
+	EnvRouteMetric          = "GOXRAY_ROUTE_METRIC"             // Settings.RouteMetric.
+	EnvRouteTable           = "GOXRAY_ROUTE_TABLE"              // Settings.RouteTable.
+	EnvRoutePriority        = "GOXRAY_ROUTE_PRIORITY"           // Settings.RoutePriority.
+	EnvRouteMark            = "GOXRAY_ROUTE_MARK"               // Settings.RouteMark.
+	EnvRouteVerifyInterval  = "GOXRAY_ROUTE_VERIFY_INTERVAL"    // Settings.RouteVerifyInterval.
+	EnvRouteRepair          = "GOXRAY_ROUTE_REPAIR"             // Settings.RouteRepair, "true" or "1" to enable.
+	EnvNetns                = "GOXRAY_NETNS"                    // Settings.Netns.
+	EnvGatewayMode          = "GOXRAY_GATEWAY_MODE"             // Settings.GatewayMode, "true" or "1" to enable.
+	EnvNetworkManager       = "GOXRAY_NETWORK_MANAGER"          // Settings.NetworkManager, "true" or "1" to enable.
+	EnvTunnelDNS            = "GOXRAY_TUNNEL_DNS"               // Settings.TunnelDNS, comma separated.
+	EnvGateway              = "GOXRAY_GATEWAY"                  // Settings.Gateway.
+	EnvGatewayWait          = "GOXRAY_GATEWAY_WAIT"             // Settings.GatewayWait.
+	EnvNAT64Prefix          = "GOXRAY_NAT64_PREFIX"             // Settings.NAT64Prefix.
+	EnvECH                  = "GOXRAY_ECH"                      // Settings.ECH.
+	EnvECHOuterSNI          = "GOXRAY_ECH_OUTER_SNI"            // Settings.ECHOuterSNI.
+	EnvClientCert           = "GOXRAY_CLIENT_CERT"              // Settings.ClientCert.
+	EnvClientKey            = "GOXRAY_CLIENT_KEY"               // Settings.ClientKey.
+	EnvClientKeyPassword    = "GOXRAY_CLIENT_KEY_PASSWORD"      // Settings.ClientKeyPassword.
+	EnvDomainStrategy       = "GOXRAY_DOMAIN_STRATEGY"          // Settings.DomainStrategy.
+	EnvBootstrapDNS         = "GOXRAY_BOOTSTRAP_DNS"            // Settings.BootstrapDNS, comma separated.
+	EnvObfsPadding          = "GOXRAY_OBFS_PADDING"             // Settings.ObfsPadding.
+	EnvObfsFragment         = "GOXRAY_OBFS_FRAGMENT"            // Settings.ObfsFragment.
+	EnvObfsPackets          = "GOXRAY_OBFS_PACKETS"             // Settings.ObfsPackets.
+	EnvObfsJitter           = "GOXRAY_OBFS_JITTER"              // Settings.ObfsJitter.
+	EnvLogLevel             = "GOXRAY_LOG_LEVEL"                // Settings.LogLevel.
+	EnvLogDedup             = "GOXRAY_LOG_DEDUP"                // Settings.LogDedup.
+	EnvLogLevels            = "GOXRAY_LOG_LEVELS"               // Settings.LogLevels, comma separated.
+	EnvCheckURL             = "GOXRAY_CHECK_URL"                // Settings.CheckURL.
+	EnvCheckStatus          = "GOXRAY_CHECK_STATUS"             // Settings.CheckStatus.
+	EnvCheckTimeout         = "GOXRAY_CHECK_TIMEOUT"            // Settings.CheckTimeout.
+	EnvCheckInterval        = "GOXRAY_CHECK_INTERVAL"           // Settings.CheckInterval.
+	EnvWatchdog             = "GOXRAY_WATCHDOG"                 // Settings.Watchdog.
+	EnvExitInfoURL          = "GOXRAY_EXIT_INFO_URL"            // Settings.ExitInfoURL.
+	EnvControlRoute         = "GOXRAY_CONTROL_ROUTE"            // Settings.ControlRoute.
+	EnvUpdateCheck          = "GOXRAY_UPDATE_CHECK"             // Settings.UpdateCheck.
+	EnvNotify               = "GOXRAY_NOTIFY"                   // Settings.Notify, "true" or "1" to enable.
+	EnvMetricsPush          = "GOXRAY_METRICS_PUSH"             // Settings.MetricsPush.
+	EnvMetricsInterval      = "GOXRAY_METRICS_INTERVAL"         // Settings.MetricsInterval.
+	EnvMetricsTags          = "GOXRAY_METRICS_TAGS"             // Settings.MetricsTags, comma separated.
+	EnvHealthListen         = "GOXRAY_HEALTH_LISTEN"            // Settings.HealthListen.
+	EnvAPIListen            = "GOXRAY_API_LISTEN"               // Settings.APIListen.
+	EnvAPIToken             = "GOXRAY_API_TOKEN"                // Settings.APIToken.
+	EnvAPIReadToken         = "GOXRAY_API_READ_TOKEN"           // Settings.APIReadToken.
+	EnvAPICert              = "GOXRAY_API_CERT"                 // Settings.APICert.
+	EnvAPIKey               = "GOXRAY_API_KEY"                  // Settings.APIKey.
+	EnvAPIClientCA          = "GOXRAY_API_CLIENT_CA"            // Settings.APIClientCA.
+	EnvCaptivePortal        = "GOXRAY_CAPTIVE_PORTAL"           // Settings.CaptivePortal.
+	EnvTCPIdleTimeout       = "GOXRAY_TCP_IDLE_TIMEOUT"         // Settings.TCPIdleTimeout.
+	EnvUDPIdleTimeout       = "GOXRAY_UDP_IDLE_TIMEOUT"         // Settings.UDPIdleTimeout.
+	EnvMaxFlows             = "GOXRAY_MAX_FLOWS"                // Settings.MaxFlows.
+	EnvFlowQueueTimeout     = "GOXRAY_FLOW_QUEUE_TIMEOUT"       // Settings.FlowQueueTimeout.
+	EnvDrainTimeout         = "GOXRAY_DRAIN_TIMEOUT"            // Settings.DrainTimeout.
+	EnvProxyDialTimeout     = "GOXRAY_PROXY_DIAL_TIMEOUT"       // Settings.ProxyDialTimeout.
+	EnvSOCKSTimeout         = "GOXRAY_SOCKS_TIMEOUT"            // Settings.SOCKSTimeout.
+	EnvHandshakeTimeout     = "GOXRAY_HANDSHAKE_TIMEOUT"        // Settings.HandshakeTimeout.
+	EnvConnIdleTimeout      = "GOXRAY_CONN_IDLE_TIMEOUT"        // Settings.ConnIdleTimeout.
+	EnvUplinkOnlyTimeout    = "GOXRAY_UPLINK_ONLY_TIMEOUT"      // Settings.UplinkOnlyTimeout.
+	EnvDownlinkOnlyTimeout  = "GOXRAY_DOWNLINK_ONLY_TIMEOUT"    // Settings.DownlinkOnlyTimeout.
+	EnvTCPKeepAlive         = "GOXRAY_TCP_KEEPALIVE"            // Settings.TCPKeepAlive.
+	EnvMemoryBudgetMiB      = "GOXRAY_MEMORY_BUDGET_MIB"        // Settings.MemoryBudgetMiB.
+	EnvGoMemLimit           = "GOXRAY_GO_MEM_LIMIT"             // Settings.GoMemLimit, "true" or "1" to enable.
+	EnvPipeWorkers          = "GOXRAY_PIPE_WORKERS"             // Settings.PipeWorkers.
+	EnvPipeQueue            = "GOXRAY_PIPE_QUEUE"               // Settings.PipeQueue.
+	EnvPipeOverflow         = "GOXRAY_PIPE_OVERFLOW"            // Settings.PipeOverflow.
+	EnvUDPSessionCache      = "GOXRAY_UDP_SESSION_CACHE"        // Settings.UDPSessionCache.
+	EnvUDPSpareSessions     = "GOXRAY_UDP_SPARE_SESSIONS"       // Settings.UDPSpareSessions.
+	EnvUDPBatch             = "GOXRAY_UDP_BATCH"                // Settings.UDPBatch.
+	EnvQUIC                 = "GOXRAY_QUIC"                     // Settings.QUIC.
+	EnvQUICDelay            = "GOXRAY_QUIC_DELAY"               // Settings.QUICDelay.
+	EnvWarmupConns          = "GOXRAY_WARMUP_CONNS"             // Settings.WarmupConns.
+	EnvWarmupTarget         = "GOXRAY_WARMUP_TARGET"            // Settings.WarmupTarget.
+	EnvWarmupRecycle        = "GOXRAY_WARMUP_RECYCLE"           // Settings.WarmupRecycle.
+	EnvDSCP                 = "GOXRAY_DSCP"                     // Settings.DSCP.
+	EnvDSCPClasses          = "GOXRAY_DSCP_CLASSES"             // Settings.DSCPClasses, comma separated.
+	EnvSniffing             = "GOXRAY_SNIFFING"                 // Settings.Sniffing, comma separated.
+	EnvSniffingRouteOnly    = "GOXRAY_SNIFFING_ROUTE_ONLY"      // Settings.SniffingRouteOnly, "true" or "1" to enable.
+	EnvOnDemand             = "GOXRAY_ON_DEMAND"                // Settings.OnDemand, "true" or "1" to enable.
+	EnvReconnectMinBackoff  = "GOXRAY_RECONNECT_MIN_BACKOFF"    // Settings.ReconnectMinBackoff.
+	EnvReconnectMaxBackoff  = "GOXRAY_RECONNECT_MAX_BACKOFF"    // Settings.ReconnectMaxBackoff.
+	EnvReconnectFailures    = "GOXRAY_RECONNECT_FAILURES"       // Settings.ReconnectFailures.
+	EnvReconnectCoolDown    = "GOXRAY_RECONNECT_COOL_DOWN"      // Settings.ReconnectCoolDown.
+	EnvFlowLog              = "GOXRAY_FLOW_LOG"                 // Settings.FlowLog.
+	EnvCapture              = "GOXRAY_CAPTURE"                  // Settings.Capture.
+	EnvCaptureFilter        = "GOXRAY_CAPTURE_FILTER"           // Settings.CaptureFilter.
+	EnvCaptureSnapLen       = "GOXRAY_CAPTURE_SNAPLEN"          // Settings.CaptureSnapLen.
+	EnvCaptureMaxMiB        = "GOXRAY_CAPTURE_MAX_MIB"          // Settings.CaptureMaxMiB.
+	EnvCaptivePortalWait    = "GOXRAY_CAPTIVE_PORTAL_WAIT"      // Settings.CaptivePortalWait.
+	EnvCaptivePortalURL     = "GOXRAY_CAPTIVE_PORTAL_URL"       // Settings.CaptivePortalURL.
+	EnvBalancerSticky       = "GOXRAY_BALANCER_STICKY"          // Settings.BalancerSticky.
+)
+
+// Settings are client settings shared by all profiles, empty fields keep client defaults.
+//
+// Settings are read from the configuration file, environment and command line flags,
+// later sources override earlier ones field by field (see Override).
+type Settings struct {
+	// InboundPort is the port of the local inbound proxy (default: random free port).
+	InboundPort int `json:"inbound_port,omitempty"`
+	// InboundAddress is the IP the local inbound proxy listens on (default: 127.0.0.1),
+	// e.g. "0.0.0.0" to share the connection in LAN. InboundPort is required then.
+	InboundAddress string `json:"inbound_address,omitempty"`
+	// InboundSocket is Unix domain socket path for the local inbound proxy, used instead of InboundPort.
+	InboundSocket string `json:"inbound_socket,omitempty"`
+	// InboundAllow lists IPs or CIDR networks allowed to connect to the inbound proxy (default: any).
+	InboundAllow []string `json:"inbound_allow,omitempty"`
+	// InboundMaxConns limits concurrent inbound proxy connections (default: unlimited).
+	InboundMaxConns int `json:"inbound_max_conns,omitempty"`
+	// InboundMaxConnsPerIP limits concurrent inbound proxy connections from a single IP (default: unlimited).
+	InboundMaxConnsPerIP int `json:"inbound_max_conns_per_ip,omitempty"`
+	// Upstream is "IP:port" of an external SOCKS5 proxy the TUN device is piped into instead of connecting
+	// to the server, the connection link is not needed then (default: none).
+	Upstream string `json:"upstream,omitempty"`
+	// SystemProxy points OS proxy settings to the inbound proxy while connected.
+	SystemProxy bool `json:"system_proxy,omitempty"`
+	// PACListen is the address of PAC file server, e.g. "127.0.0.1:8086".
+	// PAC server is started if any of PAC settings is set (default address: 127.0.0.1 with random port).
+	PACListen string `json:"pac_listen,omitempty"`
+	// PACProxyDomains are domains sent through the proxy by the PAC file.
+	PACProxyDomains []string `json:"pac_proxy_domains,omitempty"`
+	// PACDirectDomains are domains connected directly by the PAC file.
+	PACDirectDomains []string `json:"pac_direct_domains,omitempty"`
+	// TUNAddress is TUN device address in CIDR notation, e.g. "192.18.0.1/32".
+	TUNAddress string `json:"tun_address,omitempty"`
+	// MTU of the TUN device.
+	MTU int `json:"mtu,omitempty"`
+	// RouteMetric is the metric of added routes, lower wins over routes of other VPN software (Linux only).
+	RouteMetric int `json:"route_metric,omitempty"`
+	// RouteTable is the dedicated routing table routes are installed to, selected by policy routing rule
+	// instead of the main table (Linux only), e.g. 100.
+	RouteTable int `json:"route_table,omitempty"`
+	// RoutePriority is the priority of RouteTable rule, lower is looked up first (default: before the main table).
+	RoutePriority int `json:"route_priority,omitempty"`
+	// RouteMark limits RouteTable rule to packets with the firewall mark (default: all packets).
+	RouteMark int `json:"route_mark,omitempty"`
+	// RouteVerifyInterval is the interval of reading routes back from the routing table while connected,
+	// e.g. "30s" (default: verified after connect only).
+	RouteVerifyInterval string `json:"route_verify_interval,omitempty"`
+	// RouteRepair adds routes removed by another program back (default: removal is reported only).
+	RouteRepair bool `json:"route_repair,omitempty"`
+	// Netns is the Linux network namespace TUN device is created in, only programs run in it are tunneled.
+	Netns string `json:"netns,omitempty"`
+	// GatewayMode forwards IPv4 traffic of other hosts or containers routed via this one into the tunnel (Linux only).
+	GatewayMode bool `json:"gateway_mode,omitempty"`
+	// NetworkManager leaves the TUN device unmanaged by NetworkManager, so it does not remove the routes (Linux only).
+	NetworkManager bool `json:"network_manager,omitempty"`
+	// TunnelDNS are DNS server IPs set for the TUN device with systemd-resolved, queries of all domains go to them
+	// through the tunnel (Linux only, NetworkManager is enabled with them).
+	TunnelDNS []string `json:"tunnel_dns,omitempty"`
+	// Gateway is the gateway IP or the interface whose gateway is used, e.g. "192.168.1.1" or "eth0"
+	// (default: the gateway of the system default route).
+	Gateway string `json:"gateway,omitempty"`
+	// GatewayWait is the longest wait for the gateway on connect, e.g. "1m" for boot-time daemon (default: no wait).
+	GatewayWait string `json:"gateway_wait,omitempty"`
+	// NAT64Prefix is used to reach IPv4-only server on IPv6-only network, e.g. "64:ff9b::/96"
+	// (default: discovered via DNS64).
+	NAT64Prefix string `json:"nat64_prefix,omitempty"`
+	// ECH is base64 ECHConfigList of the server enabling Encrypted Client Hello of TLS connection to it
+	// (default: "ech" link parameter).
+	ECH string `json:"ech,omitempty"`
+	// ECHOuterSNI selects the ECH configs with the public name, e.g. "cdn.example.com" (default: any).
+	ECHOuterSNI string `json:"ech_outer_sni,omitempty"`
+	// ClientCert is the client certificate of mutual TLS authentication to the server: PEM or PKCS#12 file, or
+	// "keystore:" followed by the certificate common name in the OS keystore (macOS and Windows).
+	ClientCert string `json:"client_cert,omitempty"`
+	// ClientKey is PEM file with the private key of ClientCert (default: the key in ClientCert file).
+	ClientKey string `json:"client_key,omitempty"`
+	// ClientKeyPassword decrypts PKCS#12 ClientCert file.
+	ClientKeyPassword string `json:"client_key_password,omitempty"`
+	// DomainStrategy of the server host name: "AsIs", "UseIP", "UseIPv4" or "UseIPv6" (default: any family).
+	DomainStrategy string `json:"domain_strategy,omitempty"`
+	// BootstrapDNS are DNS servers resolving the server host name instead of the system resolver, tried in order:
+	// "IP[:port]", "tcp://IP[:port]" or DNS over HTTPS URL, e.g. "https://1.1.1.1/dns-query".
+	BootstrapDNS []string `json:"bootstrap_dns,omitempty"`
+	// ObfsPadding is the range of random padding bytes of xhttp requests, e.g. "100-1000".
+	// Obfuscation costs throughput, see client.ObfuscationOptions.
+	ObfsPadding string `json:"obfs_padding,omitempty"`
+	// ObfsFragment splits the packets of the connection to the server into fragments of the range of bytes,
+	// e.g. "10-100".
+	ObfsFragment string `json:"obfs_fragment,omitempty"`
+	// ObfsPackets selects the packets to fragment: "tlshello" or the range of packet numbers (default: "tlshello").
+	ObfsPackets string `json:"obfs_packets,omitempty"`
+	// ObfsJitter is the range of delay between fragments in milliseconds, e.g. "10-50".
+	ObfsJitter string `json:"obfs_jitter,omitempty"`
+	// LogLevel is one of "debug", "info", "warn" or "error".
+	LogLevel string `json:"log_level,omitempty"`
+	// LogDedup is the window identical log records are counted in instead of logged after the first one,
+	// e.g. "1m", "0s" logs every record (default: client.DefaultLogDedupWindow).
+	LogDedup string `json:"log_dedup,omitempty"`
+	// LogLevels override LogLevel of client components, "component:level" pairs, e.g. ["xray:error", "route:debug"],
+	// components are "tun", "route", "xray", "pipe" and "dns" (default: LogLevel).
+	LogLevels []string `json:"log_levels,omitempty"`
+	// CheckURL is requested through the tunnel by connectivity checks (default: client.DefaultCheckURL).
+	CheckURL string `json:"check_url,omitempty"`
+	// CheckStatus is HTTP status code of successful connectivity check (default: 204 or any 2xx for CheckURL).
+	CheckStatus int `json:"check_status,omitempty"`
+	// CheckTimeout limits connectivity checks and exit info requests, e.g. "5s" (default: 10s).
+	CheckTimeout string `json:"check_timeout,omitempty"`
+	// CheckInterval is the interval of health checks while connected, e.g. "30s" (default: disabled).
+	CheckInterval string `json:"check_interval,omitempty"`
+	// Watchdog is how long health checks of daemon mode connection may fail with no traffic arriving through
+	// the tunnel before the connection is rebuilt from scratch with a new client, e.g. "5m". It requires
+	// CheckInterval (default: disabled).
+	Watchdog string `json:"watchdog,omitempty"`
+	// ExitInfoURL is the endpoint reporting exit IP, country and ASN (default: client.DefaultExitInfoURL).
+	ExitInfoURL string `json:"exit_info_url,omitempty"`
+	// ControlRoute routes control traffic of the application, like update checks: "tunnel" or "direct"
+	// (default: tunnel while connected, direct otherwise).
+	ControlRoute string `json:"control_route,omitempty"`
+	// UpdateCheck is the interval of daemon mode checks for a new release, e.g. "24h" (default: disabled).
+	UpdateCheck string `json:"update_check,omitempty"`
+	// Notify shows desktop notifications of daemon mode connects, disconnects and failures
+	// (default: disabled).
+	Notify bool `json:"notify,omitempty"`
+	// MetricsPush is the sink daemon mode pushes its metrics to: Pushgateway "http://host:9091", "statsd://host:8125"
+	// or "dogstatsd://host:8125" (default: disabled).
+	MetricsPush string `json:"metrics_push,omitempty"`
+	// MetricsInterval is the interval of the metrics pushes, e.g. "1m" (default: metrics.DefaultInterval).
+	MetricsInterval string `json:"metrics_interval,omitempty"`
+	// MetricsTags are "key=value" tags of the pushed metrics, Pushgateway grouping labels or DogStatsD tags,
+	// e.g. ["host=pi"].
+	MetricsTags []string `json:"metrics_tags,omitempty"`
+	// HealthListen is the address serving /healthz and /readyz endpoints reflecting the tunnel health, e.g. ":8080",
+	// health checks are enabled by CheckInterval (default: disabled).
+	HealthListen string `json:"health_listen,omitempty"`
+	// APIListen is the address serving the daemon mode control API, e.g. "127.0.0.1:8081". Addresses other than
+	// loopback require APICert and a token or APIClientCA, see package api (default: disabled).
+	APIListen string `json:"api_listen,omitempty"`
+	// APIToken is the bearer token of the control API granting control of the daemon.
+	APIToken string `json:"api_token,omitempty"`
+	// APIReadToken is the bearer token of the control API granting read-only access.
+	APIReadToken string `json:"api_read_token,omitempty"`
+	// APICert and APIKey are PEM files of the control API server certificate and its key, TLS is enabled with them.
+	APICert string `json:"api_cert,omitempty"`
+	APIKey  string `json:"api_key,omitempty"`
+	// APIClientCA is PEM file of the CA verifying client certificates of the control API, certificates with
+	// "read-only" organizational unit are granted read-only access.
+	APIClientCA string `json:"api_client_ca,omitempty"`
+	// CaptivePortal enables captive portal detection: "detect" fails to connect behind a portal,
+	// "wait" holds off connecting until login (see CaptivePortalWait), "bypass" keeps the portal
+	// reachable outside the tunnel (default: disabled).
+	CaptivePortal string `json:"captive_portal,omitempty"`
+	// CaptivePortalWait is the longest wait for the portal login with "wait" mode, e.g. "10m" (default: 5m).
+	CaptivePortalWait string `json:"captive_portal_wait,omitempty"`
+	// CaptivePortalURL is plain HTTP probe URL responding with 204 status (default: client.DefaultCaptivePortalProbeURL).
+	CaptivePortalURL string `json:"captive_portal_url,omitempty"`
+	// BalancerSticky keeps flows to a destination on the same server of load-balance group while new flows
+	// are made to it within the duration, e.g. "30m", "off" spreads every flow (default: 10m).
+	BalancerSticky string `json:"balancer_sticky,omitempty"`
+	// TCPIdleTimeout closes tunneled TCP connections idle for the duration, e.g. "1h" (default: 2h4m).
+	TCPIdleTimeout string `json:"tcp_idle_timeout,omitempty"`
+	// UDPIdleTimeout closes tunneled UDP flows idle for the duration, e.g. "1m" (default: 30s).
+	UDPIdleTimeout string `json:"udp_idle_timeout,omitempty"`
+	// MaxFlows limits concurrent tunneled connections (default: unlimited).
+	MaxFlows int `json:"max_flows,omitempty"`
+	// FlowQueueTimeout is the longest wait of new connection over MaxFlows, e.g. "2s" (default: rejected right away).
+	FlowQueueTimeout string `json:"flow_queue_timeout,omitempty"`
+	// DrainTimeout is the grace period of active connections to finish on disconnect, e.g. "10s"
+	// (default: closed right away).
+	DrainTimeout string `json:"drain_timeout,omitempty"`
+	// ProxyDialTimeout limits connecting of new tunneled connection to the local proxy or the upstream proxy,
+	// e.g. "2s" (default: 4s).
+	ProxyDialTimeout string `json:"proxy_dial_timeout,omitempty"`
+	// SOCKSTimeout limits SOCKS5 handshake of new tunneled connection with the proxy, e.g. "30s" for slow
+	// upstream proxies (default: 10s).
+	SOCKSTimeout string `json:"socks_timeout,omitempty"`
+	// HandshakeTimeout limits the protocol handshake of proxied connections, e.g. "8s" (default: 4s).
+	HandshakeTimeout string `json:"handshake_timeout,omitempty"`
+	// ConnIdleTimeout closes proxied connections without data in both directions, e.g. "2h" for SSH
+	// (default: 5m).
+	ConnIdleTimeout string `json:"conn_idle_timeout,omitempty"`
+	// UplinkOnlyTimeout closes proxied connections after the server closed the downlink (default: 1s).
+	UplinkOnlyTimeout string `json:"uplink_only_timeout,omitempty"`
+	// DownlinkOnlyTimeout closes proxied connections after the application closed the uplink (default: 1s).
+	DownlinkOnlyTimeout string `json:"downlink_only_timeout,omitempty"`
+	// TCPKeepAlive is the idle time and interval of TCP keepalive probes of the server connections, e.g. "30s"
+	// (default: OS settings).
+	TCPKeepAlive string `json:"tcp_keepalive,omitempty"`
+	// MemoryBudgetMiB sizes buffers and the connection limit to the memory in MiB, e.g. 64 on a 128 MB router
+	// (default: sized for desktop).
+	MemoryBudgetMiB int `json:"memory_budget_mib,omitempty"`
+	// GoMemLimit sets Go runtime memory limit (GOMEMLIMIT) to MemoryBudgetMiB.
+	GoMemLimit bool `json:"go_mem_limit,omitempty"`
+	// PipeWorkers process packets of the TUN device sharded by connection, e.g. the number of CPU cores
+	// (default: 1).
+	PipeWorkers int `json:"pipe_workers,omitempty"`
+	// PipeQueue is the number of packets queued per pipe worker (default: 256).
+	PipeQueue int `json:"pipe_queue,omitempty"`
+	// PipeOverflow handles packets of a pipe worker with full queue: "drop" or "block" to wait for the worker
+	// (default: "drop").
+	PipeOverflow string `json:"pipe_overflow,omitempty"`
+	// UDPSessionCache is how long the SOCKS association of a closed UDP flow is kept for its source, e.g. "1m"
+	// (default: 30s).
+	UDPSessionCache string `json:"udp_session_cache,omitempty"`
+	// UDPSpareSessions are SOCKS associations established ahead for new UDP sources, e.g. 4 (default: 0).
+	UDPSpareSessions int `json:"udp_spare_sessions,omitempty"`
+	// UDPBatch is the most datagrams of a flow sent by one system call (default: 16).
+	UDPBatch int `json:"udp_batch,omitempty"`
+	// QUIC is the mode of UDP port 443 (QUIC, HTTP/3): "relay", "block" to make browsers use TCP or
+	// "deprioritize" to delay new QUIC flows (default: "relay").
+	QUIC string `json:"quic,omitempty"`
+	// QUICDelay is the delay of new QUIC flows in "deprioritize" mode, e.g. "500ms" (default: 300ms).
+	QUICDelay string `json:"quic_delay,omitempty"`
+	// WarmupConns is the number of connections to the server kept warm while connected, up to 16
+	// (default: 0, disabled).
+	WarmupConns int `json:"warmup_conns,omitempty"`
+	// WarmupTarget is "host:port" the warm connections are opened to (default: host of the check URL).
+	WarmupTarget string `json:"warmup_target,omitempty"`
+	// WarmupRecycle is how long a warm connection is kept before it is opened again, e.g. "30s" (default: 1m).
+	WarmupRecycle string `json:"warmup_recycle,omitempty"`
+	// DSCP marks server connections, 0-63, e.g. 46 for VoIP (default: not marked).
+	DSCP int `json:"dscp,omitempty"`
+	// DSCPClasses carry application DSCP marks over to server connections, "inner:outer" pairs,
+	// e.g. ["46:46", "34:46"] (default: none).
+	DSCPClasses []string `json:"dscp_classes,omitempty"`
+	// Sniffing are protocols sniffed for destination domains: "http", "tls", "quic", "fakedns" (default: disabled).
+	Sniffing []string `json:"sniffing,omitempty"`
+	// SniffingRouteOnly uses sniffed domains for routing only, connections are made to the original IPs.
+	SniffingRouteOnly bool `json:"sniffing_route_only,omitempty"`
+	// OnDemand keeps TUN device in standby and connects when the first packet arrives.
+	OnDemand bool `json:"on_demand,omitempty"`
+	// ReconnectMinBackoff is the delay of the retry after the first failure of OnDemand or daemon connection,
+	// doubled after every failure, e.g. "5s" (default: 1s).
+	ReconnectMinBackoff string `json:"reconnect_min_backoff,omitempty"`
+	// ReconnectMaxBackoff limits the delay of retries, e.g. "30s" (default: 1m).
+	ReconnectMaxBackoff string `json:"reconnect_max_backoff,omitempty"`
+	// ReconnectFailures are consecutive failures starting the cool-down (default: 5).
+	ReconnectFailures int `json:"reconnect_failures,omitempty"`
+	// ReconnectCoolDown is the delay of retries in cool-down, e.g. "15m" (default: 5m).
+	ReconnectCoolDown string `json:"reconnect_cool_down,omitempty"`
+	// FlowLog is the path of JSON Lines file closed connections are appended to (default: disabled).
+	FlowLog string `json:"flow_log,omitempty"`
+	// Capture is the path of pcap-ng file tunneled packets are appended to (default: disabled).
+	Capture string `json:"capture,omitempty"`
+	// CaptureFilter selects captured packets, BPF-style expression like "udp and port 53" (default: all).
+	CaptureFilter string `json:"capture_filter,omitempty"`
+	// CaptureSnapLen truncates captured packets to the length (default: not truncated).
+	CaptureSnapLen int `json:"capture_snaplen,omitempty"`
+	// CaptureMaxMiB caps the size of FlowLog and Capture files in MiB (default: unlimited).
+	CaptureMaxMiB int `json:"capture_max_mib,omitempty"`
+	// Reverse exposes local services on the remote server via XRay reverse proxy,
+	// it is set in the configuration file only.
+	Reverse []Reverse `json:"reverse,omitempty"`
+	// Routing routes tunneled connections by destination, the first matching rule applies,
+	// it is set in the configuration file only.
+	Routing []Route `json:"routing,omitempty"`
+}
+
+// Reverse is a local service exposed on the remote server, see client.ReverseForward.
+type Reverse struct {
+	// Domain must match the domain of the reverse proxy portal on the server.
+	Domain string `json:"domain"`
+	// Local is host:port of the local service, e.g. "127.0.0.1:22".
+	Local string `json:"local"`
+}
+
+// Route is a routing rule, see client.RoutingRule.
+type Route struct {
+	// Network is "tcp", "udp" or "tcp,udp" (default: any).
+	Network string `json:"network,omitempty"`
+	// Port is destination port, range or comma separated list of them, e.g. "25,465,587".
+	Port string `json:"port,omitempty"`
+	// Domains are XRay domain matchers, e.g. "example.com".
+	Domains []string `json:"domains,omitempty"`
+	// IPs are destination IPs or CIDR networks.
+	IPs []string `json:"ips,omitempty"`
+	// Action is "proxy", "direct" or "block".
+	Action string `json:"action"`
+}
+
+func (r Route) rule() client.RoutingRule {
+	return client.RoutingRule{Network: r.Network, Port: r.Port, Domains: r.Domains, IPs: r.IPs, Action: client.RouteAction(r.Action)}
+}
+
+// SettingsFromEnv reads settings from GOXRAY_* environment variables.
+func SettingsFromEnv() (Settings, error) {
+	s := Settings{
+		InboundAddress:      os.Getenv(EnvInboundAddress),
+		InboundSocket:       os.Getenv(EnvInboundSocket),
+		InboundAllow:        SplitList(os.Getenv(EnvInboundAllow)),
+		Upstream:            os.Getenv(EnvUpstream),
+		PACListen:           os.Getenv(EnvPACListen),
+		TUNAddress:          os.Getenv(EnvTUNAddress),
+		Netns:               os.Getenv(EnvNetns),
+		Gateway:             os.Getenv(EnvGateway),
+		GatewayWait:         os.Getenv(EnvGatewayWait),
+		NAT64Prefix:         os.Getenv(EnvNAT64Prefix),
+		ECH:                 os.Getenv(EnvECH),
+		ECHOuterSNI:         os.Getenv(EnvECHOuterSNI),
+		ClientCert:          os.Getenv(EnvClientCert),
+		ClientKey:           os.Getenv(EnvClientKey),
+		ClientKeyPassword:   os.Getenv(EnvClientKeyPassword),
+		DomainStrategy:      os.Getenv(EnvDomainStrategy),
+		BootstrapDNS:        SplitList(os.Getenv(EnvBootstrapDNS)),
+		TunnelDNS:           SplitList(os.Getenv(EnvTunnelDNS)),
+		ObfsPadding:         os.Getenv(EnvObfsPadding),
+		ObfsFragment:        os.Getenv(EnvObfsFragment),
+		ObfsPackets:         os.Getenv(EnvObfsPackets),
+		ObfsJitter:          os.Getenv(EnvObfsJitter),
+		LogLevel:            os.Getenv(EnvLogLevel),
+		LogDedup:            os.Getenv(EnvLogDedup),
+		LogLevels:           SplitList(os.Getenv(EnvLogLevels)),
+		CheckURL:            os.Getenv(EnvCheckURL),
+		CheckTimeout:        os.Getenv(EnvCheckTimeout),
+		CheckInterval:       os.Getenv(EnvCheckInterval),
+		Watchdog:            os.Getenv(EnvWatchdog),
+		ExitInfoURL:         os.Getenv(EnvExitInfoURL),
+		ControlRoute:        os.Getenv(EnvControlRoute),
+		UpdateCheck:         os.Getenv(EnvUpdateCheck),
+		MetricsPush:         os.Getenv(EnvMetricsPush),
+		MetricsInterval:     os.Getenv(EnvMetricsInterval),
+		MetricsTags:         SplitList(os.Getenv(EnvMetricsTags)),
+		HealthListen:        os.Getenv(EnvHealthListen),
+		APIListen:           os.Getenv(EnvAPIListen),
+		APIToken:            os.Getenv(EnvAPIToken),
+		APIReadToken:        os.Getenv(EnvAPIReadToken),
+		APICert:             os.Getenv(EnvAPICert),
+		APIKey:              os.Getenv(EnvAPIKey),
+		APIClientCA:         os.Getenv(EnvAPIClientCA),
+		CaptivePortal:       os.Getenv(EnvCaptivePortal),
+		CaptivePortalWait:   os.Getenv(EnvCaptivePortalWait),
+		CaptivePortalURL:    os.Getenv(EnvCaptivePortalURL),
+		BalancerSticky:      os.Getenv(EnvBalancerSticky),
+		TCPIdleTimeout:      os.Getenv(EnvTCPIdleTimeout),
+		UDPIdleTimeout:      os.Getenv(EnvUDPIdleTimeout),
+		FlowQueueTimeout:    os.Getenv(EnvFlowQueueTimeout),
+		DrainTimeout:        os.Getenv(EnvDrainTimeout),
+		ProxyDialTimeout:    os.Getenv(EnvProxyDialTimeout),
+		SOCKSTimeout:        os.Getenv(EnvSOCKSTimeout),
+		HandshakeTimeout:    os.Getenv(EnvHandshakeTimeout),
+		ConnIdleTimeout:     os.Getenv(EnvConnIdleTimeout),
+		UplinkOnlyTimeout:   os.Getenv(EnvUplinkOnlyTimeout),
+		DownlinkOnlyTimeout: os.Getenv(EnvDownlinkOnlyTimeout),
+		TCPKeepAlive:        os.Getenv(EnvTCPKeepAlive),
+		PipeOverflow:        os.Getenv(EnvPipeOverflow),
+		UDPSessionCache:     os.Getenv(EnvUDPSessionCache),
+		QUIC:                os.Getenv(EnvQUIC),
+		QUICDelay:           os.Getenv(EnvQUICDelay),
+		WarmupTarget:        os.Getenv(EnvWarmupTarget),
+		WarmupRecycle:       os.Getenv(EnvWarmupRecycle),
+		ReconnectMinBackoff: os.Getenv(EnvReconnectMinBackoff),
+		ReconnectMaxBackoff: os.Getenv(EnvReconnectMaxBackoff),
+		ReconnectCoolDown:   os.Getenv(EnvReconnectCoolDown),
+		DSCPClasses:         SplitList(os.Getenv(EnvDSCPClasses)),
+		Sniffing:            SplitList(os.Getenv(EnvSniffing)),
+		FlowLog:             os.Getenv(EnvFlowLog),
+		Capture:             os.Getenv(EnvCapture),
+		CaptureFilter:       os.Getenv(EnvCaptureFilter),
+		RouteVerifyInterval: os.Getenv(EnvRouteVerifyInterval),
+	}
+
+	for env, v := range map[string]*int{
+		EnvInboundPort:          &s.InboundPort,
+		EnvInboundMaxConns:      &s.InboundMaxConns,
+		EnvInboundMaxConnsPerIP: &s.InboundMaxConnsPerIP,
+		EnvMTU:                  &s.MTU,
+		EnvRouteMetric:          &s.RouteMetric,
+		EnvRouteTable:           &s.RouteTable,
+		EnvRoutePriority:        &s.RoutePriority,
+		EnvRouteMark:            &s.RouteMark,
+		EnvCheckStatus:          &s.CheckStatus,
+		EnvMaxFlows:             &s.MaxFlows,
+		EnvDSCP:                 &s.DSCP,
+		EnvCaptureSnapLen:       &s.CaptureSnapLen,
+		EnvCaptureMaxMiB:        &s.CaptureMaxMiB,
+		EnvMemoryBudgetMiB:      &s.MemoryBudgetMiB,
+		EnvPipeWorkers:          &s.PipeWorkers,
+		EnvPipeQueue:            &s.PipeQueue,
+		EnvUDPSpareSessions:     &s.UDPSpareSessions,
+		EnvUDPBatch:             &s.UDPBatch,
+		EnvWarmupConns:          &s.WarmupConns,
+		EnvReconnectFailures:    &s.ReconnectFailures,
+	} {
+		if os.Getenv(env) == "" {
+			continue
+		}
+		var err error
+		if *v, err = strconv.Atoi(os.Getenv(env)); err != nil {
+			return Settings{}, fmt.Errorf("%s: %w", env, err)
+		}
+	}
+
+	for env, v := range map[string]*bool{
+		EnvSystemProxy:       &s.SystemProxy,
+		EnvGatewayMode:       &s.GatewayMode,
+		EnvNetworkManager:    &s.NetworkManager,
+		EnvSniffingRouteOnly: &s.SniffingRouteOnly,
+		EnvOnDemand:          &s.OnDemand,
+		EnvRouteRepair:       &s.RouteRepair,
+		EnvGoMemLimit:        &s.GoMemLimit,
+		EnvNotify:            &s.Notify,
+	} {
+		if os.Getenv(env) == "" {
+			continue
+		}
+		var err error
+		if *v, err = strconv.ParseBool(os.Getenv(env)); err != nil {
+			return Settings{}, fmt.Errorf("%s: %w", env, err)
+		}
+	}
+
+	return s, s.Validate()
+}
+
+// SplitList splits comma separated list, empty items are skipped.
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Override returns s with non-empty fields replaced by the fields of o.
+func (s Settings) Override(o Settings) Settings {
+	if o.InboundPort != 0 {
+		s.InboundPort = o.InboundPort
+	}
+	if o.InboundAddress != "" {
+		s.InboundAddress = o.InboundAddress
+	}
+	if o.InboundSocket != "" {
+		s.InboundSocket = o.InboundSocket
+	}
+	if len(o.InboundAllow) > 0 {
+		s.InboundAllow = o.InboundAllow
+	}
+	if o.Upstream != "" {
+		s.Upstream = o.Upstream
+	}
+	if o.InboundMaxConns != 0 {
+		s.InboundMaxConns = o.InboundMaxConns
+	}
+	if o.InboundMaxConnsPerIP != 0 {
+		s.InboundMaxConnsPerIP = o.InboundMaxConnsPerIP
+	}
+	if o.SystemProxy {
+		s.SystemProxy = true
+	}
+	if o.PACListen != "" {
+		s.PACListen = o.PACListen
+	}
+	if len(o.PACProxyDomains) > 0 {
+		s.PACProxyDomains = o.PACProxyDomains
+	}
+	if len(o.PACDirectDomains) > 0 {
+		s.PACDirectDomains = o.PACDirectDomains
+	}
+	if o.TUNAddress != "" {
+		s.TUNAddress = o.TUNAddress
+	}
+	if o.MTU != 0 {
+		s.MTU = o.MTU
+	}
+	if o.RouteMetric != 0 {
+		s.RouteMetric = o.RouteMetric
+	}
+	if o.RouteTable != 0 {
+		s.RouteTable = o.RouteTable
+	}
+	if o.RoutePriority != 0 {
+		s.RoutePriority = o.RoutePriority
+	}
+	if o.RouteMark != 0 {
+		s.RouteMark = o.RouteMark
+	}
+	if o.RouteVerifyInterval != "" {
+		s.RouteVerifyInterval = o.RouteVerifyInterval
+	}
+	if o.RouteRepair {
+		s.RouteRepair = true
+	}
+	if o.Netns != "" {
+		s.Netns = o.Netns
+	}
+	if o.GatewayMode {
+		s.GatewayMode = true
+	}
+	if o.NetworkManager {
+		s.NetworkManager = true
+	}
+	if len(o.TunnelDNS) > 0 {
+		s.TunnelDNS = o.TunnelDNS
+	}
+	if o.Gateway != "" {
+		s.Gateway = o.Gateway
+	}
+	if o.GatewayWait != "" {
+		s.GatewayWait = o.GatewayWait
+	}
+	if o.NAT64Prefix != "" {
+		s.NAT64Prefix = o.NAT64Prefix
+	}
+	if o.ECH != "" {
+		s.ECH = o.ECH
+	}
+	if o.ECHOuterSNI != "" {
+		s.ECHOuterSNI = o.ECHOuterSNI
+	}
+	if o.ClientCert != "" {
+		s.ClientCert = o.ClientCert
+	}
+	if o.ClientKey != "" {
+		s.ClientKey = o.ClientKey
+	}
+	if o.ClientKeyPassword != "" {
+		s.ClientKeyPassword = o.ClientKeyPassword
+	}
+	if o.DomainStrategy != "" {
+		s.DomainStrategy = o.DomainStrategy
+	}
+	if len(o.BootstrapDNS) > 0 {
+		s.BootstrapDNS = o.BootstrapDNS
+	}
+	if o.ObfsPadding != "" {
+		s.ObfsPadding = o.ObfsPadding
+	}
+	if o.ObfsFragment != "" {
+		s.ObfsFragment = o.ObfsFragment
+	}
+	if o.ObfsPackets != "" {
+		s.ObfsPackets = o.ObfsPackets
+	}
+	if o.ObfsJitter != "" {
+		s.ObfsJitter = o.ObfsJitter
+	}
+	if o.LogLevel != "" {
+		s.LogLevel = o.LogLevel
+	}
+	if o.LogDedup != "" {
+		s.LogDedup = o.LogDedup
+	}
+	if len(o.LogLevels) > 0 {
+		s.LogLevels = o.LogLevels
+	}
+	if o.CheckURL != "" {
+		s.CheckURL = o.CheckURL
+	}
+	if o.CheckStatus != 0 {
+		s.CheckStatus = o.CheckStatus
+	}
+	if o.CheckTimeout != "" {
+		s.CheckTimeout = o.CheckTimeout
+	}
+	if o.CheckInterval != "" {
+		s.CheckInterval = o.CheckInterval
+	}
+	if o.Watchdog != "" {
+		s.Watchdog = o.Watchdog
+	}
+	if o.ExitInfoURL != "" {
+		s.ExitInfoURL = o.ExitInfoURL
+	}
+	if o.ControlRoute != "" {
+		s.ControlRoute = o.ControlRoute
+	}
+	if o.UpdateCheck != "" {
+		s.UpdateCheck = o.UpdateCheck
+	}
+	if o.Notify {
+		s.Notify = true
+	}
+	if o.MetricsPush != "" {
+		s.MetricsPush = o.MetricsPush
+	}
+	if o.MetricsInterval != "" {
+		s.MetricsInterval = o.MetricsInterval
+	}
+	if len(o.MetricsTags) > 0 {
+		s.MetricsTags = o.MetricsTags
+	}
+	if o.HealthListen != "" {
+		s.HealthListen = o.HealthListen
+	}
+	if o.APIListen != "" {
+		s.APIListen = o.APIListen
+	}
+	if o.APIToken != "" {
+		s.APIToken = o.APIToken
+	}
+	if o.APIReadToken != "" {
+		s.APIReadToken = o.APIReadToken
+	}
+	if o.APICert != "" {
+		s.APICert = o.APICert
+	}
+	if o.APIKey != "" {
+		s.APIKey = o.APIKey
+	}
+	if o.APIClientCA != "" {
+		s.APIClientCA = o.APIClientCA
+	}
+	if o.CaptivePortal != "" {
+		s.CaptivePortal = o.CaptivePortal
+	}
+	if o.CaptivePortalWait != "" {
+		s.CaptivePortalWait = o.CaptivePortalWait
+	}
+	if o.CaptivePortalURL != "" {
+		s.CaptivePortalURL = o.CaptivePortalURL
+	}
+	if o.BalancerSticky != "" {
+		s.BalancerSticky = o.BalancerSticky
+	}
+	if o.TCPIdleTimeout != "" {
+		s.TCPIdleTimeout = o.TCPIdleTimeout
+	}
+	if o.UDPIdleTimeout != "" {
+		s.UDPIdleTimeout = o.UDPIdleTimeout
+	}
+	if o.MaxFlows != 0 {
+		s.MaxFlows = o.MaxFlows
+	}
+	if o.FlowQueueTimeout != "" {
+		s.FlowQueueTimeout = o.FlowQueueTimeout
+	}
+	if o.DrainTimeout != "" {
+		s.DrainTimeout = o.DrainTimeout
+	}
+	if o.ProxyDialTimeout != "" {
+		s.ProxyDialTimeout = o.ProxyDialTimeout
+	}
+	if o.SOCKSTimeout != "" {
+		s.SOCKSTimeout = o.SOCKSTimeout
+	}
+	if o.HandshakeTimeout != "" {
+		s.HandshakeTimeout = o.HandshakeTimeout
+	}
+	if o.ConnIdleTimeout != "" {
+		s.ConnIdleTimeout = o.ConnIdleTimeout
+	}
+	if o.UplinkOnlyTimeout != "" {
+		s.UplinkOnlyTimeout = o.UplinkOnlyTimeout
+	}
+	if o.DownlinkOnlyTimeout != "" {
+		s.DownlinkOnlyTimeout = o.DownlinkOnlyTimeout
+	}
+	if o.TCPKeepAlive != "" {
+		s.TCPKeepAlive = o.TCPKeepAlive
+	}
+	if o.MemoryBudgetMiB != 0 {
+		s.MemoryBudgetMiB = o.MemoryBudgetMiB
+	}
+	if o.GoMemLimit {
+		s.GoMemLimit = true
+	}
+	if o.PipeWorkers != 0 {
+		s.PipeWorkers = o.PipeWorkers
+	}
+	if o.PipeQueue != 0 {
+		s.PipeQueue = o.PipeQueue
+	}
+	if o.PipeOverflow != "" {
+		s.PipeOverflow = o.PipeOverflow
+	}
+	if o.UDPSessionCache != "" {
+		s.UDPSessionCache = o.UDPSessionCache
+	}
+	if o.UDPSpareSessions != 0 {
+		s.UDPSpareSessions = o.UDPSpareSessions
+	}
+	if o.UDPBatch != 0 {
+		s.UDPBatch = o.UDPBatch
+	}
+	if o.QUIC != "" {
+		s.QUIC = o.QUIC
+	}
+	if o.QUICDelay != "" {
+		s.QUICDelay = o.QUICDelay
+	}
+	if o.WarmupConns != 0 {
+		s.WarmupConns = o.WarmupConns
+	}
+	if o.WarmupTarget != "" {
+		s.WarmupTarget = o.WarmupTarget
+	}
+	if o.WarmupRecycle != "" {
+		s.WarmupRecycle = o.WarmupRecycle
+	}
+	if o.DSCP != 0 {
+		s.DSCP = o.DSCP
+	}
+	if len(o.DSCPClasses) > 0 {
+		s.DSCPClasses = o.DSCPClasses
+	}
+	if len(o.Sniffing) > 0 {
+		s.Sniffing = o.Sniffing
+	}
+	if o.SniffingRouteOnly {
+		s.SniffingRouteOnly = true
+	}
+	if o.OnDemand {
+		s.OnDemand = true
+	}
+	if o.ReconnectMinBackoff != "" {
+		s.ReconnectMinBackoff = o.ReconnectMinBackoff
+	}
+	if o.ReconnectMaxBackoff != "" {
+		s.ReconnectMaxBackoff = o.ReconnectMaxBackoff
+	}
+	if o.ReconnectFailures != 0 {
+		s.ReconnectFailures = o.ReconnectFailures
+	}
+	if o.ReconnectCoolDown != "" {
+		s.ReconnectCoolDown = o.ReconnectCoolDown
+	}
+	if o.FlowLog != "" {
+		s.FlowLog = o.FlowLog
+	}
+	if o.Capture != "" {
+		s.Capture = o.Capture
+	}
+	if o.CaptureFilter != "" {
+		s.CaptureFilter = o.CaptureFilter
+	}
+	if o.CaptureSnapLen != 0 {
+		s.CaptureSnapLen = o.CaptureSnapLen
+	}
+	if o.CaptureMaxMiB != 0 {
+		s.CaptureMaxMiB = o.CaptureMaxMiB
+	}
+	if len(o.Reverse) > 0 {
+		s.Reverse = o.Reverse
+	}
+	if len(o.Routing) > 0 {
+		s.Routing = o.Routing
+	}
+
+	return s
+}
+
+// Validate checks settings values.
+func (s Settings) Validate() error {
+	if s.InboundPort < 0 || s.InboundPort > 65535 {
+		return fmt.Errorf("invalid inbound port %d", s.InboundPort)
+	}
+	if s.InboundAddress != "" {
+		if net.ParseIP(s.InboundAddress) == nil {
+			return fmt.Errorf("invalid inbound address %q", s.InboundAddress)
+		}
+		if s.InboundPort == 0 && s.InboundSocket == "" {
+			return errors.New("inbound address requires inbound port")
+		}
+	}
+	acl, err := s.inboundACL()
+	if err != nil {
+		return err
+	}
+	if acl != nil && s.InboundSocket != "" {
+		return errors.New("inbound allow list and limits are not supported for inbound socket")
+	}
+	if _, err := s.upstream(); err != nil {
+		return err
+	}
+	if acl != nil && s.Upstream != "" {
+		return errors.New("inbound allow list and limits are not supported for upstream")
+	}
+	if s.PACListen != "" {
+		if _, _, err := net.SplitHostPort(s.PACListen); err != nil {
+			return fmt.Errorf("invalid pac listen address: %w", err)
+		}
+	}
+	if s.MTU != 0 && (s.MTU < 576 || s.MTU > 65535) {
+		return fmt.Errorf("invalid mtu %d", s.MTU)
+	}
+	if s.RouteMetric < 0 {
+		return fmt.Errorf("invalid route metric %d", s.RouteMetric)
+	}
+	if _, err := s.routeIsolation(); err != nil {
+		return err
+	}
+	if _, err := s.routeVerify(); err != nil {
+		return err
+	}
+	if strings.ContainsAny(s.Netns, "/\x00") || s.Netns == "." || s.Netns == ".." {
+		return fmt.Errorf("invalid netns name %q", s.Netns)
+	}
+	if _, err := s.networkManager(); err != nil {
+		return err
+	}
+	if s.Gateway != "" && net.ParseIP(s.Gateway) == nil && (len(s.Gateway) > 15 || strings.ContainsAny(s.Gateway, "/ \t\x00")) {
+		return fmt.Errorf("invalid gateway %q, IP or interface name expected", s.Gateway)
+	}
+	if s.GatewayWait != "" {
+		if d, err := time.ParseDuration(s.GatewayWait); err != nil || d < 0 {
+			return fmt.Errorf("invalid gateway wait %q", s.GatewayWait)
+		}
+	}
+	if s.TUNAddress != "" {
+		if _, _, err := net.ParseCIDR(s.TUNAddress); err != nil {
+			return fmt.Errorf("invalid tun address: %w", err)
+		}
+	}
+	if s.NAT64Prefix != "" {
+		_, prefix, err := net.ParseCIDR(s.NAT64Prefix)
+		if err != nil {
+			return fmt.Errorf("invalid nat64 prefix: %w", err)
+		}
+		if _, err = nat64.Synthesize(prefix, net.IPv4zero); err != nil {
+			return fmt.Errorf("invalid nat64 prefix: %w", err)
+		}
+	}
+	if _, err := s.ech(); err != nil {
+		return err
+	}
+	if _, err := s.clientCert(); err != nil {
+		return err
+	}
+	if _, err := s.resolver(); err != nil {
+		return err
+	}
+	if _, err := s.obfuscation(); err != nil {
+		return err
+	}
+	if _, err := s.memoryBudget(); err != nil {
+		return err
+	}
+	if _, err := s.pipe(); err != nil {
+		return err
+	}
+	if _, err := s.udp(); err != nil {
+		return err
+	}
+	if _, err := s.quic(); err != nil {
+		return err
+	}
+	if _, err := s.warmup(); err != nil {
+		return err
+	}
+	if _, err := s.reconnect(); err != nil {
+		return err
+	}
+	if _, err := s.level(slog.LevelInfo); err != nil {
+		return err
+	}
+	if _, err := s.logDedup(); err != nil {
+		return err
+	}
+	if _, err := s.logs(); err != nil {
+		return err
+	}
+	if _, err := s.check(); err != nil {
+		return err
+	}
+	if err := client.ControlRoute(s.ControlRoute).Validate(); err != nil {
+		return err
+	}
+	if _, err := s.UpdateCheckInterval(); err != nil {
+		return err
+	}
+	if _, err := s.WatchdogPeriod(); err != nil {
+		return err
+	}
+	if _, err := s.MetricsPushOptions(); err != nil {
+		return err
+	}
+	if s.HealthListen != "" {
+		if _, _, err := net.SplitHostPort(s.HealthListen); err != nil {
+			return fmt.Errorf("invalid health listen address: %w", err)
+		}
+	}
+	if _, err := s.APIOptions(); err != nil {
+		return err
+	}
+	if _, err := s.captivePortal(); err != nil {
+		return err
+	}
+	if _, err := s.flows(); err != nil {
+		return err
+	}
+	if _, err := s.timeouts(); err != nil {
+		return err
+	}
+	if _, err := s.qos(); err != nil {
+		return err
+	}
+	if _, err := s.balancer(); err != nil {
+		return err
+	}
+	if err := s.sniffing().Validate(); err != nil {
+		return fmt.Errorf("invalid sniffing: %w", err)
+	}
+	if len(s.Sniffing) > 0 && s.InboundSocket != "" {
+		return errors.New("sniffing is not supported for inbound socket")
+	}
+	if _, err := s.capture(); err != nil {
+		return err
+	}
+	for _, r := range s.Reverse {
+		if err := (client.ReverseForward{Domain: r.Domain, Local: r.Local}).Validate(); err != nil {
+			return fmt.Errorf("invalid reverse %q: %w", r.Domain, err)
+		}
+	}
+	for i, r := range s.Routing {
+		if err := r.rule().Validate(); err != nil {
+			return fmt.Errorf("invalid routing rule %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// ClientConfig returns client.Config for the settings, logs are written to stdout
+// with defaultLevel if LogLevel is empty.
+func (s Settings) ClientConfig(defaultLevel slog.Level) (client.Config, error) {
+	if err := s.Validate(); err != nil {
+		return client.Config{}, err
+	}
+
+	level, _ := s.level(defaultLevel)
+	cfg := client.Config{
+		MTU:         s.MTU,
+		RouteMetric: s.RouteMetric,
+		Netns:       s.Netns,
+		GatewayMode: s.GatewayMode,
+		SystemProxy: s.SystemProxy,
+		OnDemand:    s.OnDemand,
+		Logger:      slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})),
+	}
+	switch {
+	case s.InboundSocket != "":
+		cfg.InboundProxy = &client.Proxy{Path: s.InboundSocket}
+	case s.InboundPort != 0:
+		ip := net.IPv4(127, 0, 0, 1)
+		if s.InboundAddress != "" {
+			ip = net.ParseIP(s.InboundAddress)
+		}
+		cfg.InboundProxy = &client.Proxy{IP: ip, Port: s.InboundPort}
+	}
+	cfg.InboundACL, _ = s.inboundACL()
+	cfg.Upstream, _ = s.upstream()
+	if s.PACListen != "" || len(s.PACProxyDomains) > 0 || len(s.PACDirectDomains) > 0 {
+		cfg.PAC = &client.PACOptions{Listen: s.PACListen, ProxyDomains: s.PACProxyDomains, DirectDomains: s.PACDirectDomains}
+	}
+	if s.TUNAddress != "" {
+		ip, ipNet, _ := net.ParseCIDR(s.TUNAddress)
+		ipNet.IP = ip
+		cfg.TUNAddress = ipNet
+	}
+	cfg.LogDedup, _ = s.logDedup()
+	cfg.Logs, _ = s.logs()
+	cfg.RouteIsolation, _ = s.routeIsolation()
+	cfg.NetworkManager, _ = s.networkManager()
+	cfg.RouteVerify, _ = s.routeVerify()
+	if ip := net.ParseIP(s.Gateway); ip != nil {
+		cfg.GatewayIP = &ip
+	} else {
+		cfg.GatewayInterface = s.Gateway
+	}
+	if s.GatewayWait != "" {
+		cfg.GatewayWait, _ = time.ParseDuration(s.GatewayWait)
+	}
+	if s.NAT64Prefix != "" {
+		_, cfg.NAT64Prefix, _ = net.ParseCIDR(s.NAT64Prefix)
+	}
+	cfg.ECH, _ = s.ech()
+	cfg.ClientCert, _ = s.clientCert()
+	cfg.Resolver, _ = s.resolver()
+	cfg.Obfuscation, _ = s.obfuscation()
+	cfg.Check, _ = s.check()
+	cfg.ExitInfoURL = s.ExitInfoURL
+	cfg.ControlRoute = client.ControlRoute(s.ControlRoute)
+	cfg.CaptivePortal, _ = s.captivePortal()
+	cfg.Flows, _ = s.flows()
+	cfg.Timeouts, _ = s.timeouts()
+	cfg.MemoryBudget, _ = s.memoryBudget()
+	cfg.Pipe, _ = s.pipe()
+	cfg.UDP, _ = s.udp()
+	cfg.QUIC, _ = s.quic()
+	cfg.Warmup, _ = s.warmup()
+	cfg.Reconnect, _ = s.reconnect()
+	cfg.QoS, _ = s.qos()
+	cfg.Balancer, _ = s.balancer()
+	if len(s.Sniffing) > 0 {
+		cfg.Sniffing = s.sniffing()
+	}
+	cfg.Capture, _ = s.capture()
+	for _, r := range s.Reverse {
+		cfg.Reverse = append(cfg.Reverse, client.ReverseForward{Domain: r.Domain, Local: r.Local})
+	}
+	for _, r := range s.Routing {
+		cfg.Routing = append(cfg.Routing, r.rule())
+	}
+
+	return cfg, nil
+}
+
+// networkManager returns client.NetworkManagerOptions of NetworkManager and TunnelDNS, nil if neither is set.
+func (s Settings) networkManager() (*client.NetworkManagerOptions, error) {
+	if !s.NetworkManager && len(s.TunnelDNS) == 0 {
+		return nil, nil
+	}
+	opts := &client.NetworkManagerOptions{}
+	for _, item := range s.TunnelDNS {
+		ip := net.ParseIP(item)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid tunnel dns %q, IP expected", item)
+		}
+		opts.DNS = append(opts.DNS, ip)
+	}
+
+	return opts, nil
+}
+
+// upstream returns client.Proxy of Upstream, nil if it is not set.
+func (s Settings) upstream() (*client.Proxy, error) {
+	if s.Upstream == "" {
+		return nil, nil
+	}
+	host, port, err := net.SplitHostPort(s.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream: %w", err)
+	}
+	ip := net.ParseIP(host)
+	n, err := strconv.Atoi(port)
+	if ip == nil || err != nil || n <= 0 || n > 65535 {
+		return nil, fmt.Errorf("invalid upstream %q, IP:port expected", s.Upstream)
+	}
+
+	return &client.Proxy{IP: ip, Port: n}, nil
+}
+
+// inboundACL returns client.InboundACL for inbound settings, nil if there are no restrictions.
+func (s Settings) inboundACL() (*client.InboundACL, error) {
+	if len(s.InboundAllow) == 0 && s.InboundMaxConns == 0 && s.InboundMaxConnsPerIP == 0 {
+		return nil, nil
+	}
+
+	acl := &client.InboundACL{MaxConns: s.InboundMaxConns, MaxConnsPerIP: s.InboundMaxConnsPerIP}
+	for _, item := range s.InboundAllow {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid inbound allow %q", item)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			acl.Allow = append(acl.Allow, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inbound allow: %w", err)
+		}
+		acl.Allow = append(acl.Allow, n)
+	}
+	if err := acl.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid inbound limits: %w", err)
+	}
+
+	return acl, nil
+}
+
+// check returns client.CheckOptions for check settings, nil if none of them is set.
+func (s Settings) check() (*client.CheckOptions, error) {
+	if s.CheckURL == "" && s.CheckStatus == 0 && s.CheckTimeout == "" && s.CheckInterval == "" {
+		return nil, nil
+	}
+
+	opts := &client.CheckOptions{URL: s.CheckURL, ExpectedStatus: s.CheckStatus}
+	var err error
+	if s.CheckTimeout != "" {
+		if opts.Timeout, err = time.ParseDuration(s.CheckTimeout); err != nil {
+			return nil, fmt.Errorf("invalid check timeout: %w", err)
+		}
+	}
+	if s.CheckInterval != "" {
+		if opts.Interval, err = time.ParseDuration(s.CheckInterval); err != nil {
+			return nil, fmt.Errorf("invalid check interval: %w", err)
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid check settings: %w", err)
+	}
+
+	return opts, nil
+}
+
+// minUpdateCheck limits the interval of update checks, not to hammer the release server.
+const minUpdateCheck = time.Hour
+
+// UpdateCheckInterval returns the interval of daemon mode update checks, zero if they are disabled.
+func (s Settings) UpdateCheckInterval() (time.Duration, error) {
+	if s.UpdateCheck == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s.UpdateCheck)
+	if err != nil {
+		return 0, fmt.Errorf("invalid update check interval: %w", err)
+	}
+	if d < minUpdateCheck {
+		return 0, fmt.Errorf("invalid update check interval: must be at least %s", minUpdateCheck)
+	}
+
+	return d, nil
+}
+
+// WatchdogPeriod returns the period of daemon mode watchdog, zero if it is disabled.
+func (s Settings) WatchdogPeriod() (time.Duration, error) {
+	if s.Watchdog == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s.Watchdog)
+	if err != nil {
+		return 0, fmt.Errorf("invalid watchdog period: %w", err)
+	}
+	check, err := s.check()
+	if err != nil {
+		return 0, err
+	}
+	if check == nil || check.Interval == 0 {
+		return 0, errors.New("watchdog requires health checks, set check interval")
+	}
+	if d < check.Interval {
+		return 0, fmt.Errorf("invalid watchdog period: must be at least the check interval %s", check.Interval)
+	}
+
+	return d, nil
+}
+
+// MetricsPushOptions returns metrics.Options of daemon mode metrics pushes, nil if they are disabled.
+func (s Settings) MetricsPushOptions() (*metrics.Options, error) {
+	if s.MetricsPush == "" {
+		if s.MetricsInterval != "" || len(s.MetricsTags) > 0 {
+			return nil, errors.New("metrics interval and tags require metrics push")
+		}
+		return nil, nil
+	}
+	opts := &metrics.Options{URL: s.MetricsPush}
+	if s.MetricsInterval != "" {
+		d, err := time.ParseDuration(s.MetricsInterval)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid metrics interval %q, must be at least 1s", s.MetricsInterval)
+		}
+		opts.Interval = d
+	}
+	for _, item := range s.MetricsTags {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid metrics tag %q, key=value expected", item)
+		}
+		if opts.Tags == nil {
+			opts.Tags = make(map[string]string, len(s.MetricsTags))
+		}
+		opts.Tags[k] = v
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// APIOptions returns api.Options of the daemon mode control API, nil if it is disabled.
+func (s Settings) APIOptions() (*api.Options, error) {
+	if s.APIListen == "" {
+		if s.APIToken != "" || s.APIReadToken != "" || s.APICert != "" || s.APIKey != "" || s.APIClientCA != "" {
+			return nil, errors.New("api tokens and certificates require api listen address")
+		}
+		return nil, nil
+	}
+	opts := &api.Options{
+		Addr: s.APIListen, Token: s.APIToken, ReadToken: s.APIReadToken,
+		CertFile: s.APICert, KeyFile: s.APIKey, ClientCA: s.APIClientCA,
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// flows returns client.FlowOptions for idle timeout, flow limit, drain and proxy timeout settings, nil if they
+// are not set.
+func (s Settings) flows() (*client.FlowOptions, error) {
+	if s.TCPIdleTimeout == "" && s.UDPIdleTimeout == "" && s.MaxFlows == 0 && s.FlowQueueTimeout == "" &&
+		s.DrainTimeout == "" && s.ProxyDialTimeout == "" && s.SOCKSTimeout == "" {
+		return nil, nil
+	}
+
+	opts := &client.FlowOptions{MaxFlows: s.MaxFlows}
+	var err error
+	if s.TCPIdleTimeout != "" {
+		if opts.TCPIdleTimeout, err = time.ParseDuration(s.TCPIdleTimeout); err != nil {
+			return nil, fmt.Errorf("invalid tcp idle timeout: %w", err)
+		}
+	}
+	if s.UDPIdleTimeout != "" {
+		if opts.UDPIdleTimeout, err = time.ParseDuration(s.UDPIdleTimeout); err != nil {
+			return nil, fmt.Errorf("invalid udp idle timeout: %w", err)
+		}
+	}
+	if s.FlowQueueTimeout != "" {
+		if opts.QueueTimeout, err = time.ParseDuration(s.FlowQueueTimeout); err != nil {
+			return nil, fmt.Errorf("invalid flow queue timeout: %w", err)
+		}
+	}
+	if s.DrainTimeout != "" {
+		if opts.DrainTimeout, err = time.ParseDuration(s.DrainTimeout); err != nil {
+			return nil, fmt.Errorf("invalid drain timeout: %w", err)
+		}
+	}
+	if s.ProxyDialTimeout != "" {
+		if opts.ProxyDialTimeout, err = time.ParseDuration(s.ProxyDialTimeout); err != nil {
+			return nil, fmt.Errorf("invalid proxy dial timeout: %w", err)
+		}
+	}
+	if s.SOCKSTimeout != "" {
+		if opts.SOCKSHandshakeTimeout, err = time.ParseDuration(s.SOCKSTimeout); err != nil {
+			return nil, fmt.Errorf("invalid socks timeout: %w", err)
+		}
+	}
+	if err = opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid flow settings: %w", err)
+	}
+
+	return opts, nil
+}
+
+// timeouts returns client.TimeoutOptions for proxied connection timeout and keepalive settings, nil if they are
+// not set.
+func (s Settings) timeouts() (*client.TimeoutOptions, error) {
+	opts := &client.TimeoutOptions{}
+	for _, t := range []struct {
+		name  string
+		value string
+		d     *time.Duration
+	}{
+		{"handshake timeout", s.HandshakeTimeout, &opts.Handshake},
+		{"conn idle timeout", s.ConnIdleTimeout, &opts.ConnIdle},
+		{"uplink only timeout", s.UplinkOnlyTimeout, &opts.UplinkOnly},
+		{"downlink only timeout", s.DownlinkOnlyTimeout, &opts.DownlinkOnly},
+		{"tcp keepalive", s.TCPKeepAlive, &opts.KeepAlive},
+	} {
+		if t.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", t.name, err)
+		}
+		*t.d = d
+	}
+	if *opts == (client.TimeoutOptions{}) {
+		return nil, nil
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid timeout settings: %w", err)
+	}
+
+	return opts, nil
+}
+
+// qos returns client.QoSOptions for DSCP settings, nil if they are not set.
+func (s Settings) qos() (*client.QoSOptions, error) {
+	if s.DSCP == 0 && len(s.DSCPClasses) == 0 {
+		return nil, nil
+	}
+
+	opts := &client.QoSOptions{DSCP: s.DSCP}
+	for _, class := range s.DSCPClasses {
+		in, out, ok := strings.Cut(class, ":")
+		inner, err := strconv.Atoi(in)
+		if err != nil || !ok {
+			return nil, fmt.Errorf("invalid dscp class %q, must be inner:outer", class)
+		}
+		outer, err := strconv.Atoi(out)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dscp class %q, must be inner:outer", class)
+		}
+		if opts.Classes == nil {
+			opts.Classes = make(map[int]int)
+		}
+		opts.Classes[inner] = outer
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid dscp settings: %w", err)
+	}
+
+	return opts, nil
+}
+
+// balancer returns client.BalancerOptions for balancer settings, nil if they are not set.
+func (s Settings) balancer() (*client.BalancerOptions, error) {
+	if s.BalancerSticky == "" {
+		return nil, nil
+	}
+
+	opts := &client.BalancerOptions{Sticky: -1}
+	if s.BalancerSticky != "off" {
+		d, err := time.ParseDuration(s.BalancerSticky)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid balancer sticky %q, duration or \"off\" expected", s.BalancerSticky)
+		}
+		opts.Sticky = d
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid balancer sticky: %w", err)
+	}
+
+	return opts, nil
+}
+
+// sniffing returns client.SniffingOptions for sniffing settings, enabled if any protocol is sniffed.
+func (s Settings) sniffing() *client.SniffingOptions {
+	return &client.SniffingOptions{Enabled: len(s.Sniffing) > 0, DestOverride: s.Sniffing, RouteOnly: s.SniffingRouteOnly}
+}
+
+// routeIsolation returns client.RouteIsolationOptions for route table settings, nil if RouteTable is not set.
+func (s Settings) routeIsolation() (*client.RouteIsolationOptions, error) {
+	if s.RouteTable == 0 {
+		if s.RoutePriority != 0 || s.RouteMark != 0 {
+			return nil, errors.New("route priority and mark require route table")
+		}
+		return nil, nil
+	}
+	if s.RouteMark < 0 || int64(s.RouteMark) > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid route mark %d", s.RouteMark)
+	}
+	opts := &client.RouteIsolationOptions{Table: s.RouteTable, Priority: s.RoutePriority, Mark: uint32(s.RouteMark)}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid route isolation: %w", err)
+	}
+
+	return opts, nil
+}
+
+// routeVerify returns client.RouteVerifyOptions for route verification settings, nil if they are not set.
+func (s Settings) routeVerify() (*client.RouteVerifyOptions, error) {
+	if s.RouteVerifyInterval == "" && !s.RouteRepair {
+		return nil, nil
+	}
+
+	opts := &client.RouteVerifyOptions{Repair: s.RouteRepair}
+	if s.RouteVerifyInterval != "" {
+		var err error
+		if opts.Interval, err = time.ParseDuration(s.RouteVerifyInterval); err != nil {
+			return nil, fmt.Errorf("invalid route verify interval: %w", err)
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid route verify: %w", err)
+	}
+
+	return opts, nil
+}
+
+// ech returns client.ECHOptions for ECH settings, nil if ECH is not set.
+func (s Settings) ech() (*client.ECHOptions, error) {
+	if s.ECH == "" {
+		if s.ECHOuterSNI != "" {
+			return nil, errors.New("ech outer sni requires ech")
+		}
+		return nil, nil
+	}
+	opts := &client.ECHOptions{ConfigList: s.ECH, OuterSNI: s.ECHOuterSNI}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid ech: %w", err)
+	}
+
+	return opts, nil
+}
+
+// keystorePrefix of Settings.ClientCert selects the certificate of the OS keystore by the common name.
+const keystorePrefix = "keystore:"
+
+// clientCert returns client.ClientCertOptions for client certificate settings, nil if ClientCert is not set.
+func (s Settings) clientCert() (*client.ClientCertOptions, error) {
+	if s.ClientCert == "" {
+		if s.ClientKey != "" || s.ClientKeyPassword != "" {
+			return nil, errors.New("client key and client key password require client cert")
+		}
+		return nil, nil
+	}
+	opts := &client.ClientCertOptions{CertFile: s.ClientCert, KeyFile: s.ClientKey, Password: s.ClientKeyPassword}
+	if name, ok := strings.CutPrefix(s.ClientCert, keystorePrefix); ok {
+		opts.CertFile, opts.Keystore = "", name
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid client cert: %w", err)
+	}
+
+	return opts, nil
+}
+
+// defaultCaptivePortalWait is the wait for the portal login with "wait" mode by default.
+const defaultCaptivePortalWait = 5 * time.Minute
+
+// captivePortal returns client.CaptivePortalOptions for captive portal settings, nil if detection is disabled.
+func (s Settings) captivePortal() (*client.CaptivePortalOptions, error) {
+	opts := &client.CaptivePortalOptions{ProbeURL: s.CaptivePortalURL}
+	switch s.CaptivePortal {
+	case "", "off":
+		if s.CaptivePortalWait != "" || s.CaptivePortalURL != "" {
+			return nil, errors.New("captive portal settings require captive portal mode")
+		}
+		return nil, nil
+	case "detect":
+	case "wait":
+		opts.Wait = defaultCaptivePortalWait
+	case "bypass":
+		opts.Bypass = true
+	default:
+		return nil, fmt.Errorf("invalid captive portal mode %q", s.CaptivePortal)
+	}
+	if s.CaptivePortalWait != "" {
+		if s.CaptivePortal != "wait" {
+			return nil, errors.New("captive portal wait requires \"wait\" mode")
+		}
+		var err error
+		if opts.Wait, err = time.ParseDuration(s.CaptivePortalWait); err != nil {
+			return nil, fmt.Errorf("invalid captive portal wait: %w", err)
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid captive portal settings: %w", err)
+	}
+
+	return opts, nil
+}
+
+func (s Settings) level(def slog.Level) (slog.Level, error) {
+	if s.LogLevel == "" {
+		return def, nil
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s.LogLevel))); err != nil {
+		return def, fmt.Errorf("invalid log level %q", s.LogLevel)
+	}
+
+	return level, nil
+}
+
+// logDedup returns client.Config.LogDedup of LogDedup, negative if it is zero.
+func (s Settings) logDedup() (time.Duration, error) {
+	if s.LogDedup == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s.LogDedup)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid log dedup %q", s.LogDedup)
+	}
+	if d == 0 {
+		return -1, nil
+	}
+
+	return d, nil
+}
+
+// logs returns client.LogOptions of LogLevels components, nil if none is set.
+func (s Settings) logs() (map[client.LogComponent]*client.LogOptions, error) {
+	if len(s.LogLevels) == 0 {
+		return nil, nil
+	}
+	logs := make(map[client.LogComponent]*client.LogOptions, len(s.LogLevels))
+	for _, item := range s.LogLevels {
+		component, name, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid log levels %q, component:level expected", item)
+		}
+		if err := client.LogComponent(component).Validate(); err != nil {
+			return nil, fmt.Errorf("invalid log levels: %w", err)
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q of %s", name, component)
+		}
+		logs[client.LogComponent(component)] = &client.LogOptions{Level: level}
+	}
+
+	return logs, nil
+}
+
+// capture returns client.CaptureOptions for flow log and packet capture settings, nil if they are not set.
+func (s Settings) capture() (*client.CaptureOptions, error) {
+	if s.FlowLog == "" && s.Capture == "" && s.CaptureFilter == "" && s.CaptureSnapLen == 0 && s.CaptureMaxMiB == 0 {
+		return nil, nil
+	}
+
+	opts := &client.CaptureOptions{
+		FlowLog:  s.FlowLog,
+		Packets:  s.Capture,
+		Filter:   s.CaptureFilter,
+		SnapLen:  s.CaptureSnapLen,
+		MaxBytes: int64(s.CaptureMaxMiB) << 20,
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid capture settings: %w", err)
+	}
+
+	return opts, nil
+}
+
+// resolver returns client.ResolverOptions for domain strategy and bootstrap DNS settings, nil if none is set.
+func (s Settings) resolver() (*client.ResolverOptions, error) {
+	if s.DomainStrategy == "" && len(s.BootstrapDNS) == 0 {
+		return nil, nil
+	}
+	opts := &client.ResolverOptions{DomainStrategy: client.DomainStrategy(s.DomainStrategy), Bootstrap: s.BootstrapDNS}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid resolver: %w", err)
+	}
+
+	return opts, nil
+}
+
+// obfuscation returns client.ObfuscationOptions for obfuscation settings, nil if none is set.
+func (s Settings) obfuscation() (*client.ObfuscationOptions, error) {
+	if s.ObfsPadding == "" && s.ObfsFragment == "" && s.ObfsPackets == "" && s.ObfsJitter == "" {
+		return nil, nil
+	}
+	opts := &client.ObfuscationOptions{
+		Padding:         s.ObfsPadding,
+		Fragment:        s.ObfsFragment,
+		FragmentPackets: s.ObfsPackets,
+		Jitter:          s.ObfsJitter,
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid obfuscation: %w", err)
+	}
+
+	return opts, nil
+}
+
+// memoryBudget returns client.MemoryOptions for memory budget settings, nil if MemoryBudgetMiB is not set.
+func (s Settings) memoryBudget() (*client.MemoryOptions, error) {
+	if s.MemoryBudgetMiB == 0 {
+		if s.GoMemLimit {
+			return nil, errors.New("invalid memory budget: go mem limit requires memory budget")
+		}
+		return nil, nil
+	}
+	opts := &client.MemoryOptions{Budget: int64(s.MemoryBudgetMiB) << 20, GoMemLimit: s.GoMemLimit}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid memory budget: %w", err)
+	}
+
+	return opts, nil
+}
+
+// pipe returns client.PipeOptions for pipe settings, nil if they are not set.
+func (s Settings) pipe() (*client.PipeOptions, error) {
+	if s.PipeWorkers == 0 && s.PipeQueue == 0 && s.PipeOverflow == "" {
+		return nil, nil
+	}
+	opts := &client.PipeOptions{Workers: s.PipeWorkers, QueueSize: s.PipeQueue, Overflow: client.PipeOverflow(s.PipeOverflow)}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pipe: %w", err)
+	}
+
+	return opts, nil
+}
+
+// udp returns client.UDPOptions for UDP relay settings, nil if they are not set.
+func (s Settings) udp() (*client.UDPOptions, error) {
+	if s.UDPSessionCache == "" && s.UDPSpareSessions == 0 && s.UDPBatch == 0 {
+		return nil, nil
+	}
+
+	opts := &client.UDPOptions{SpareSessions: s.UDPSpareSessions, Batch: s.UDPBatch}
+	var err error
+	if s.UDPSessionCache != "" {
+		if opts.SessionCache, err = time.ParseDuration(s.UDPSessionCache); err != nil {
+			return nil, fmt.Errorf("invalid udp session cache: %w", err)
+		}
+	}
+	if err = opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid udp settings: %w", err)
+	}
+
+	return opts, nil
+}
+
+// quic returns client.QUICOptions for QUIC settings, nil if they are not set.
+func (s Settings) quic() (*client.QUICOptions, error) {
+	if s.QUIC == "" && s.QUICDelay == "" {
+		return nil, nil
+	}
+
+	opts := &client.QUICOptions{Mode: client.QUICMode(s.QUIC)}
+	var err error
+	if s.QUICDelay != "" {
+		if opts.Delay, err = time.ParseDuration(s.QUICDelay); err != nil {
+			return nil, fmt.Errorf("invalid quic delay: %w", err)
+		}
+	}
+	if err = opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid quic: %w", err)
+	}
+
+	return opts, nil
+}
+
+// warmup returns client.WarmupOptions for warm-up settings, nil if they are not set.
+func (s Settings) warmup() (*client.WarmupOptions, error) {
+	if s.WarmupConns == 0 && s.WarmupTarget == "" && s.WarmupRecycle == "" {
+		return nil, nil
+	}
+
+	opts := &client.WarmupOptions{Conns: s.WarmupConns, Target: s.WarmupTarget}
+	var err error
+	if s.WarmupRecycle != "" {
+		if opts.Recycle, err = time.ParseDuration(s.WarmupRecycle); err != nil {
+			return nil, fmt.Errorf("invalid warmup recycle: %w", err)
+		}
+	}
+	if err = opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid warmup: %w", err)
+	}
+
+	return opts, nil
+}
+
+// reconnect returns client.ReconnectOptions for reconnect settings, nil if they are not set.
+func (s Settings) reconnect() (*client.ReconnectOptions, error) {
+	if s.ReconnectMinBackoff == "" && s.ReconnectMaxBackoff == "" && s.ReconnectFailures == 0 && s.ReconnectCoolDown == "" {
+		return nil, nil
+	}
+
+	opts := &client.ReconnectOptions{Failures: s.ReconnectFailures}
+	var err error
+	if s.ReconnectMinBackoff != "" {
+		if opts.MinBackoff, err = time.ParseDuration(s.ReconnectMinBackoff); err != nil {
+			return nil, fmt.Errorf("invalid reconnect min backoff: %w", err)
+		}
+	}
+	if s.ReconnectMaxBackoff != "" {
+		if opts.MaxBackoff, err = time.ParseDuration(s.ReconnectMaxBackoff); err != nil {
+			return nil, fmt.Errorf("invalid reconnect max backoff: %w", err)
+		}
+	}
+	if s.ReconnectCoolDown != "" {
+		if opts.CoolDown, err = time.ParseDuration(s.ReconnectCoolDown); err != nil {
+			return nil, fmt.Errorf("invalid reconnect cool-down: %w", err)
+		}
+	}
+	if err = opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid reconnect: %w", err)
+	}
+
+	return opts, nil
+}
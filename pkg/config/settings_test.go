@@ -0,0 +1,341 @@
+package config
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/api"
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/metrics"
+)
+
+func TestSettingsFromEnv(t *testing.T) {
+	t.Setenv(EnvInboundPort, "10900")
+	t.Setenv(EnvTUNAddress, "10.0.0.1/32")
+	t.Setenv(EnvMTU, "1400")
+	t.Setenv(EnvLogLevel, "debug")
+	t.Setenv(EnvInboundAllow, "192.168.1.0/24, 10.0.0.5,")
+	t.Setenv(EnvInboundMaxConnsPerIP, "4")
+	t.Setenv(EnvSystemProxy, "true")
+	t.Setenv(EnvGatewayMode, "1")
+	t.Setenv(EnvCheckStatus, "200")
+	t.Setenv(EnvCheckInterval, "30s")
+	t.Setenv(EnvNotify, "1")
+
+	s, err := SettingsFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, Settings{
+		InboundPort:          10900,
+		InboundAllow:         []string{"192.168.1.0/24", "10.0.0.5"},
+		InboundMaxConnsPerIP: 4,
+		SystemProxy:          true,
+		TUNAddress:           "10.0.0.1/32",
+		MTU:                  1400,
+		GatewayMode:          true,
+		LogLevel:             "debug",
+		CheckStatus:          200,
+		CheckInterval:        "30s",
+		Notify:               true,
+	}, s)
+
+	t.Setenv(EnvMTU, "big")
+	_, err = SettingsFromEnv()
+	require.ErrorContains(t, err, EnvMTU)
+
+	t.Setenv(EnvMTU, "")
+	t.Setenv(EnvLogLevel, "loud")
+	_, err = SettingsFromEnv()
+	require.ErrorContains(t, err, "invalid log level")
+}
+
+func TestSettings_Override(t *testing.T) {
+	file := Settings{InboundPort: 10800, MTU: 1400, LogLevel: "info"}
+	env := Settings{MTU: 1280}
+	flags := Settings{LogLevel: "debug"}
+
+	require.Equal(t, Settings{InboundPort: 10800, MTU: 1280, LogLevel: "debug"}, file.Override(env).Override(flags))
+}
+
+func TestSettings_ClientConfig(t *testing.T) {
+	cfg, err := Settings{}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Nil(t, cfg.InboundProxy)
+	require.Nil(t, cfg.TUNAddress)
+	require.Zero(t, cfg.MTU)
+	require.False(t, cfg.Logger.Enabled(t.Context(), slog.LevelWarn))
+
+	cfg, err = Settings{InboundPort: 10900, TUNAddress: "10.0.0.1/24", MTU: 1400, RouteMetric: 50, Netns: "vpn", GatewayMode: true, LogLevel: "warn"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.Proxy{IP: net.IPv4(127, 0, 0, 1), Port: 10900}, cfg.InboundProxy)
+	require.Equal(t, "10.0.0.1/24", cfg.TUNAddress.String())
+	require.True(t, cfg.TUNAddress.IP.Equal(net.IPv4(10, 0, 0, 1)))
+	require.Equal(t, 1400, cfg.MTU)
+	require.Equal(t, 50, cfg.RouteMetric)
+	require.Equal(t, "vpn", cfg.Netns)
+	require.True(t, cfg.GatewayMode)
+	require.True(t, cfg.Logger.Enabled(t.Context(), slog.LevelWarn))
+
+	cfg, err = Settings{InboundPort: 10900, InboundSocket: "/run/goxray.sock"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.Proxy{Path: "/run/goxray.sock"}, cfg.InboundProxy)
+
+	cfg, err = Settings{InboundAddress: "0.0.0.0", InboundPort: 10900, InboundAllow: []string{"192.168.1.0/24", "10.0.0.5"}, InboundMaxConnsPerIP: 4}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.Proxy{IP: net.IPv4zero, Port: 10900}, cfg.InboundProxy)
+	require.Len(t, cfg.InboundACL.Allow, 2)
+	require.Equal(t, "10.0.0.5/32", cfg.InboundACL.Allow[1].String())
+	require.Equal(t, 4, cfg.InboundACL.MaxConnsPerIP)
+
+	cfg, err = Settings{PACDirectDomains: []string{"example.com"}}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.PACOptions{DirectDomains: []string{"example.com"}}, cfg.PAC)
+
+	cfg, err = Settings{Reverse: []Reverse{{Domain: "home.reverse", Local: "127.0.0.1:22"}}}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, []client.ReverseForward{{Domain: "home.reverse", Local: "127.0.0.1:22"}}, cfg.Reverse)
+
+	cfg, err = Settings{Routing: []Route{{Network: "udp", Port: "443", Action: "block"}}}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, []client.RoutingRule{{Network: "udp", Port: "443", Action: client.RouteBlock}}, cfg.Routing)
+
+	cfg, err = Settings{CheckURL: "http://example.com/ok", CheckTimeout: "5s", CheckInterval: "5s", ExitInfoURL: "https://ifconfig.co/json"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.CheckOptions{URL: "http://example.com/ok", Timeout: 5 * time.Second, Interval: 5 * time.Second}, cfg.Check)
+	require.Equal(t, "https://ifconfig.co/json", cfg.ExitInfoURL)
+
+	cfg, err = Settings{CaptivePortal: "wait", CaptivePortalWait: "1m"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.CaptivePortalOptions{Wait: time.Minute}, cfg.CaptivePortal)
+	cfg, err = Settings{CaptivePortal: "bypass", CaptivePortalURL: "http://probe.example.com/204"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.CaptivePortalOptions{ProbeURL: "http://probe.example.com/204", Bypass: true}, cfg.CaptivePortal)
+	cfg, err = Settings{NAT64Prefix: "2001:db8:64::/96"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8:64::/96", cfg.NAT64Prefix.String())
+	cfg, err = Settings{UDPIdleTimeout: "1m"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.FlowOptions{UDPIdleTimeout: time.Minute}, cfg.Flows)
+	cfg, err = Settings{MaxFlows: 256, FlowQueueTimeout: "2s"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.FlowOptions{MaxFlows: 256, QueueTimeout: 2 * time.Second}, cfg.Flows)
+	cfg, err = Settings{MemoryBudgetMiB: 64, GoMemLimit: true}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.MemoryOptions{Budget: 64 << 20, GoMemLimit: true}, cfg.MemoryBudget)
+	cfg, err = Settings{PipeWorkers: 4, PipeQueue: 512, PipeOverflow: "block"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.PipeOptions{Workers: 4, QueueSize: 512, Overflow: client.PipeOverflowBlock}, cfg.Pipe)
+	cfg, err = Settings{UDPSessionCache: "1m", UDPSpareSessions: 4, UDPBatch: 32}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.UDPOptions{SessionCache: time.Minute, SpareSessions: 4, Batch: 32}, cfg.UDP)
+	cfg, err = Settings{QUIC: "deprioritize", QUICDelay: "500ms"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.QUICOptions{Mode: client.QUICDeprioritize, Delay: 500 * time.Millisecond}, cfg.QUIC)
+	cfg, err = Settings{WarmupConns: 2, WarmupTarget: "example.com:443", WarmupRecycle: "30s"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.WarmupOptions{Conns: 2, Target: "example.com:443", Recycle: 30 * time.Second}, cfg.Warmup)
+	cfg, err = Settings{DrainTimeout: "10s"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.FlowOptions{DrainTimeout: 10 * time.Second}, cfg.Flows)
+	cfg, err = Settings{ProxyDialTimeout: "2s", SOCKSTimeout: "30s"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.FlowOptions{ProxyDialTimeout: 2 * time.Second, SOCKSHandshakeTimeout: 30 * time.Second}, cfg.Flows)
+	require.Nil(t, cfg.Timeouts)
+	cfg, err = Settings{ConnIdleTimeout: "2h", DownlinkOnlyTimeout: "2s", TCPKeepAlive: "30s"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.TimeoutOptions{ConnIdle: 2 * time.Hour, DownlinkOnly: 2 * time.Second, KeepAlive: 30 * time.Second}, cfg.Timeouts)
+	cfg, err = Settings{DSCP: 10, DSCPClasses: []string{"46:46", "34:46"}}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.QoSOptions{DSCP: 10, Classes: map[int]int{46: 46, 34: 46}}, cfg.QoS)
+	cfg, err = Settings{OnDemand: true}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.True(t, cfg.OnDemand)
+	require.Nil(t, cfg.Reconnect)
+	cfg, err = Settings{ReconnectMinBackoff: "5s", ReconnectMaxBackoff: "30s", ReconnectFailures: 3, ReconnectCoolDown: "15m"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.ReconnectOptions{MinBackoff: 5 * time.Second, MaxBackoff: 30 * time.Second, Failures: 3, CoolDown: 15 * time.Minute}, cfg.Reconnect)
+	cfg, err = Settings{Sniffing: []string{"tls", "quic"}, SniffingRouteOnly: true}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.SniffingOptions{Enabled: true, DestOverride: []string{"tls", "quic"}, RouteOnly: true}, cfg.Sniffing)
+	cfg, err = Settings{FlowLog: "flows.jsonl", Capture: "tun.pcapng", CaptureFilter: "udp", CaptureMaxMiB: 2}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.CaptureOptions{FlowLog: "flows.jsonl", Packets: "tun.pcapng", Filter: "udp", MaxBytes: 2 << 20}, cfg.Capture)
+	cfg, err = Settings{Gateway: "192.168.1.1", GatewayWait: "1m"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1", cfg.GatewayIP.String())
+	require.Empty(t, cfg.GatewayInterface)
+	require.Equal(t, time.Minute, cfg.GatewayWait)
+	cfg, err = Settings{RouteTable: 100, RoutePriority: 1000, RouteMark: 0x1}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.RouteIsolationOptions{Table: 100, Priority: 1000, Mark: 0x1}, cfg.RouteIsolation)
+	cfg, err = Settings{ControlRoute: "direct", UpdateCheck: "24h"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, client.ControlRouteDirect, cfg.ControlRoute)
+	interval, err := Settings{UpdateCheck: "24h"}.UpdateCheckInterval()
+	require.NoError(t, err)
+	require.Equal(t, 24*time.Hour, interval)
+	watchdog, err := Settings{CheckInterval: "30s", Watchdog: "5m"}.WatchdogPeriod()
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Minute, watchdog)
+	push, err := Settings{MetricsPush: "dogstatsd://127.0.0.1:8125", MetricsInterval: "1m", MetricsTags: []string{"host=pi"}}.MetricsPushOptions()
+	require.NoError(t, err)
+	require.Equal(t, &metrics.Options{URL: "dogstatsd://127.0.0.1:8125", Interval: time.Minute, Tags: map[string]string{"host": "pi"}}, push)
+	apiOpts, err := Settings{APIListen: ":8081", APIReadToken: "secret", APICert: "cert.pem", APIKey: "key.pem"}.APIOptions()
+	require.NoError(t, err)
+	require.Equal(t, &api.Options{Addr: ":8081", ReadToken: "secret", CertFile: "cert.pem", KeyFile: "key.pem"}, apiOpts)
+	apiOpts, err = Settings{}.APIOptions()
+	require.NoError(t, err)
+	require.Nil(t, apiOpts)
+	cfg, err = Settings{RouteVerifyInterval: "30s", RouteRepair: true}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.RouteVerifyOptions{Interval: 30 * time.Second, Repair: true}, cfg.RouteVerify)
+	cfg, err = Settings{BalancerSticky: "30m"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.BalancerOptions{Sticky: 30 * time.Minute}, cfg.Balancer)
+	cfg, err = Settings{BalancerSticky: "off"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.BalancerOptions{Sticky: -1}, cfg.Balancer)
+	cfg, err = Settings{Gateway: "eth0"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Nil(t, cfg.GatewayIP)
+	require.Equal(t, "eth0", cfg.GatewayInterface)
+	cfg, err = Settings{Upstream: "192.168.1.5:1080"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.Proxy{IP: net.ParseIP("192.168.1.5"), Port: 1080}, cfg.Upstream)
+	ech := "AEL+DQA+AQAgACAPCq3sKDcsYe54ynTF10h3uXWA5DkTAVsCYRDASvz8pAAEAAEAAQAPY2RuLmV4YW1wbGUuY29tAAA="
+	cfg, err = Settings{ECH: ech, ECHOuterSNI: "cdn.example.com"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.ECHOptions{ConfigList: ech, OuterSNI: "cdn.example.com"}, cfg.ECH)
+	cfg, err = Settings{ClientCert: "client.p12", ClientKeyPassword: "secret"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.ClientCertOptions{CertFile: "client.p12", Password: "secret"}, cfg.ClientCert)
+	cfg, err = Settings{ClientCert: "keystore:tun client"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.ClientCertOptions{Keystore: "tun client"}, cfg.ClientCert)
+	cfg, err = Settings{DomainStrategy: "UseIPv4", BootstrapDNS: []string{"https://1.1.1.1/dns-query"}}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.ResolverOptions{DomainStrategy: client.DomainStrategyUseIPv4,
+		Bootstrap: []string{"https://1.1.1.1/dns-query"}}, cfg.Resolver)
+	cfg, err = Settings{ObfsFragment: "10-100", ObfsJitter: "10-50"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.ObfuscationOptions{Fragment: "10-100", Jitter: "10-50"}, cfg.Obfuscation)
+	cfg, err = Settings{LogDedup: "1m"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, cfg.LogDedup)
+	cfg, err = Settings{LogDedup: "0s"}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Negative(t, cfg.LogDedup, "zero window must disable deduplication")
+	cfg, err = Settings{LogLevels: []string{"xray:error", "route:debug"}}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, map[client.LogComponent]*client.LogOptions{
+		client.LogXRay: {Level: slog.LevelError}, client.LogRoute: {Level: slog.LevelDebug},
+	}, cfg.Logs)
+	cfg, err = Settings{NetworkManager: true}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.NetworkManagerOptions{}, cfg.NetworkManager)
+	cfg, err = Settings{TunnelDNS: []string{"1.1.1.1"}}.ClientConfig(slog.LevelError)
+	require.NoError(t, err)
+	require.Equal(t, &client.NetworkManagerOptions{DNS: []net.IP{net.ParseIP("1.1.1.1")}}, cfg.NetworkManager)
+
+	for _, s := range []Settings{
+		{InboundPort: 70000},
+		{MTU: 100},
+		{RouteMetric: -1},
+		{RouteTable: 254},
+		{RouteMark: 1},
+		{RouteTable: 100, RouteMark: -1},
+		{RouteVerifyInterval: "-1s"},
+		{RouteVerifyInterval: "often"},
+		{BalancerSticky: "0s"},
+		{BalancerSticky: "48h"},
+		{ControlRoute: "lan"},
+		{UpdateCheck: "daily"},
+		{UpdateCheck: "1m"},
+		{MetricsPush: "udp://127.0.0.1:8125"},
+		{MetricsPush: "http://127.0.0.1:9091", MetricsInterval: "100ms"},
+		{MetricsPush: "http://127.0.0.1:9091", MetricsTags: []string{"host:pi"}},
+		{MetricsTags: []string{"host=pi"}},
+		{HealthListen: "8080"},
+		{APIToken: "secret"},
+		{APIListen: ":8081", APIToken: "secret"},
+		{APIListen: "127.0.0.1:8081", APIClientCA: "ca.pem"},
+		{Watchdog: "5m"},
+		{CheckInterval: "1m", Watchdog: "30s"},
+		{Netns: "../vpn"},
+		{Gateway: "eth0/1"},
+		{GatewayWait: "-1s"},
+		{GatewayWait: "soon"},
+		{TUNAddress: "10.0.0.1"},
+		{NAT64Prefix: "64:ff9b::/80"},
+		{NAT64Prefix: "10.0.0.0/8"},
+		{DSCP: 64},
+		{Sniffing: []string{"bittorrent"}},
+		{Sniffing: []string{"tls"}, InboundSocket: "/run/goxray.sock"},
+		{Routing: []Route{{Port: "443", Action: "reject"}}},
+		{DSCPClasses: []string{"46"}},
+		{InboundAddress: "0.0.0.0"},
+		{InboundPort: 10900, InboundAllow: []string{"lan"}},
+		{InboundSocket: "/run/goxray.sock", InboundMaxConns: 10},
+		{InboundMaxConns: -1},
+		{Upstream: "proxy.lan:1080"},
+		{ECH: "AAA"},
+		{ECHOuterSNI: "cdn.example.com"},
+		{ClientKey: "client.key"},
+		{ClientCert: "keystore:tun client", ClientKeyPassword: "secret"},
+		{DomainStrategy: "ipv4"},
+		{BootstrapDNS: []string{"dns.google"}},
+		{TunnelDNS: []string{"https://1.1.1.1/dns-query"}},
+		{LogDedup: "-1s"},
+		{LogLevels: []string{"xray=error"}},
+		{LogLevels: []string{"core:error"}},
+		{LogLevels: []string{"xray:quiet"}},
+		{DomainStrategy: "AsIs", BootstrapDNS: []string{"1.1.1.1"}},
+		{ObfsJitter: "10-50"},
+		{ObfsPadding: "lots"},
+		{Upstream: "127.0.0.1"},
+		{Upstream: "127.0.0.1:0"},
+		{Upstream: "127.0.0.1:1080", InboundAllow: []string{"10.0.0.5"}},
+		{PACListen: "8086"},
+		{Reverse: []Reverse{{Local: "127.0.0.1:22"}}},
+		{CheckInterval: "often"},
+		{CheckStatus: 1000},
+		{CaptivePortal: "ignore"},
+		{CaptivePortal: "bypass", CaptivePortalWait: "1m"},
+		{CaptivePortal: "detect", CaptivePortalURL: "https://probe.example.com"},
+		{CaptivePortalURL: "http://probe.example.com"},
+		{TCPIdleTimeout: "forever"},
+		{UDPIdleTimeout: "-1s"},
+		{FlowQueueTimeout: "2s"},
+		{DrainTimeout: "-1s"},
+		{ProxyDialTimeout: "soon"},
+		{SOCKSTimeout: "-1s"},
+		{ConnIdleTimeout: "forever"},
+		{HandshakeTimeout: "-4s"},
+		{MaxFlows: -1},
+		{MemoryBudgetMiB: 8},
+		{GoMemLimit: true},
+		{PipeWorkers: 1000},
+		{PipeQueue: -1},
+		{PipeOverflow: "wait"},
+		{UDPSessionCache: "forever"},
+		{UDPSpareSessions: 100},
+		{UDPBatch: -1},
+		{QUIC: "drop"},
+		{QUIC: "block", QUICDelay: "1s"},
+		{QUIC: "deprioritize", QUICDelay: "soon"},
+		{WarmupConns: 17},
+		{ReconnectMinBackoff: "1m", ReconnectMaxBackoff: "1s"},
+		{ReconnectCoolDown: "later"},
+		{ReconnectFailures: -1},
+		{WarmupConns: 1, WarmupTarget: "example.com"},
+		{WarmupConns: 1, WarmupRecycle: "later"},
+		{CaptureFilter: "udp"},
+		{Capture: "tun.pcapng", CaptureFilter: "port dns"},
+	} {
+		_, err = s.ClientConfig(slog.LevelError)
+		require.Error(t, err, s)
+	}
+}
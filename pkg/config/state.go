@@ -0,0 +1,113 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+// State is daemon session state persisted across restarts (crash, upgrade, reboot),
+// so the previous connection is restored and traffic accounting continues.
+type State struct {
+	// Profile is the name of the profile the daemon was connected to, empty if it was not connected.
+	Profile string `json:"profile,omitempty"`
+	// BytesRead and BytesWritten are cumulative traffic totals of TUN device since Since.
+	BytesRead    int64     `json:"bytes_read"`
+	BytesWritten int64     `json:"bytes_written"`
+	Since        time.Time `json:"since"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// LastDisconnect tells how the last connection ended, nil if none ended yet.
+	LastDisconnect *client.DisconnectInfo `json:"last_disconnect,omitempty"`
+	// History are recent connection attempts of the daemon, the oldest first, see client.Client.History.
+	History []client.Attempt `json:"history,omitempty"`
+}
+
+// AddHistory adds attempts of client.Client.History newer than the last one of the state, at most
+// client.HistorySize attempts are kept.
+func (s *State) AddHistory(attempts []client.Attempt) {
+	for _, a := range attempts {
+		if n := len(s.History); n > 0 && !a.Time.After(s.History[n-1].Time) {
+			continue
+		}
+		s.History = append(s.History, a)
+	}
+	if n := len(s.History); n > client.HistorySize {
+		s.History = s.History[n-client.HistorySize:]
+	}
+}
+
+// StatePath returns state file path next to configuration file path, e.g. "tun.state.json" for "tun.json".
+func StatePath(configPath string) string {
+	return sidePath(configPath, "state")
+}
+
+// StatsPath returns statistics database path next to configuration file path, e.g. "tun.stats.db" for "tun.json".
+func StatsPath(configPath string) string {
+	return strings.TrimSuffix(configPath, filepath.Ext(configPath)) + ".stats.db"
+}
+
+// JournalPath returns journal file path of system changes (see client.Config.Journal) next to configuration
+// file path, e.g. "tun.journal.json" for "tun.json".
+func JournalPath(configPath string) string {
+	return sidePath(configPath, "journal")
+}
+
+// AuditPath returns audit log path of system changes (see client.Config.AuditLog) next to configuration file path,
+// e.g. "tun.audit.log" for "tun.json".
+func AuditPath(configPath string) string {
+	return strings.TrimSuffix(configPath, filepath.Ext(configPath)) + ".audit.log"
+}
+
+// SocketPath returns status socket path of daemon mode next to configuration file path, e.g. "tun.sock"
+// for "tun.json".
+func SocketPath(configPath string) string {
+	return strings.TrimSuffix(configPath, filepath.Ext(configPath)) + ".sock"
+}
+
+func sidePath(configPath, name string) string {
+	ext := filepath.Ext(configPath)
+
+	return strings.TrimSuffix(configPath, ext) + "." + name + ext
+}
+
+// LoadState reads state file, missing file is loaded as new state starting now.
+func LoadState(path string) (*State, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{Since: time.Now()}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+
+	s := &State{}
+	if err = json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("parse state %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Save writes state file, the file is replaced atomically.
+func (s *State) Save(path string) error {
+	s.UpdatedAt = time.Now()
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	if err = writeFile(path, append(b, '\n')); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+
+	return nil
+}
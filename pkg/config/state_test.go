@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+func TestState_SaveLoad(t *testing.T) {
+	path := StatePath(filepath.Join(t.TempDir(), "goxray", "tun.json"))
+	require.Equal(t, "tun.state.json", filepath.Base(path))
+	require.Equal(t, "tun.stats.db", filepath.Base(StatsPath("tun.json")))
+	require.Equal(t, "tun.sock", filepath.Base(SocketPath("tun.json")))
+	require.Equal(t, "tun.audit.log", filepath.Base(AuditPath("tun.json")))
+
+	s, err := LoadState(path)
+	require.NoError(t, err)
+	require.Empty(t, s.Profile)
+	require.False(t, s.Since.IsZero())
+
+	s.Profile, s.BytesRead, s.BytesWritten = "home", 1024, 2048
+	s.LastDisconnect = &client.DisconnectInfo{Reason: client.DisconnectTunnelEOF, Err: "read pipe: EIO"}
+	require.NoError(t, s.Save(path))
+	st, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+
+	loaded, err := LoadState(path)
+	require.NoError(t, err)
+	require.Equal(t, "home", loaded.Profile)
+	require.EqualValues(t, 1024, loaded.BytesRead)
+	require.EqualValues(t, 2048, loaded.BytesWritten)
+	require.True(t, s.Since.Equal(loaded.Since))
+	require.Equal(t, s.LastDisconnect, loaded.LastDisconnect)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = LoadState(path)
+	require.ErrorContains(t, err, "parse state")
+}
+
+func TestState_AddHistory(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	attempt := func(i int) client.Attempt {
+		return client.Attempt{Kind: client.AttemptConnect, Time: at.Add(time.Duration(i) * time.Second)}
+	}
+	s := &State{}
+	s.AddHistory([]client.Attempt{attempt(0), attempt(1)})
+	s.AddHistory([]client.Attempt{attempt(0), attempt(1), attempt(2)}) // Saved again with a new attempt.
+	require.Equal(t, []client.Attempt{attempt(0), attempt(1), attempt(2)}, s.History)
+
+	var many []client.Attempt
+	for i := range client.HistorySize + 10 {
+		many = append(many, attempt(i+3))
+	}
+	s.AddHistory(many)
+	require.Len(t, s.History, client.HistorySize)
+	require.Equal(t, attempt(13), s.History[0])
+}
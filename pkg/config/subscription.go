@@ -0,0 +1,30 @@
+package config
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// ParseSubscription returns links of subscription content: links one per line, commonly base64 encoded
+// as a whole. Lines which are not links are skipped.
+func ParseSubscription(b []byte) []string {
+	content := strings.TrimSpace(string(b))
+	if !strings.Contains(content, "://") {
+		compact := strings.Join(strings.Fields(content), "")
+		for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+			if decoded, err := enc.DecodeString(compact); err == nil {
+				content = string(decoded)
+				break
+			}
+		}
+	}
+
+	var links []string
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); strings.Contains(line, "://") {
+			links = append(links, line)
+		}
+	}
+
+	return links
+}
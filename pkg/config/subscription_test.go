@@ -0,0 +1,20 @@
+package config
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubscription(t *testing.T) {
+	plain := "vless://id@127.0.0.1:443#A\r\n\n# comment\ntrojan://pass@127.0.0.2:443#B\n"
+	links := []string{"vless://id@127.0.0.1:443#A", "trojan://pass@127.0.0.2:443#B"}
+
+	require.Equal(t, links, ParseSubscription([]byte(plain)))
+	require.Equal(t, links, ParseSubscription([]byte(base64.StdEncoding.EncodeToString([]byte(plain)))))
+	require.Equal(t, links, ParseSubscription([]byte(base64.RawURLEncoding.EncodeToString([]byte(plain)))))
+	wrapped := base64.StdEncoding.EncodeToString([]byte(plain))
+	require.Equal(t, links, ParseSubscription([]byte(wrapped[:20]+"\n"+wrapped[20:])), "line wrapped base64")
+	require.Empty(t, ParseSubscription([]byte("<html>not found</html>")))
+}
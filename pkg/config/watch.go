@@ -0,0 +1,61 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce groups bursts of file events (editors write files in several steps) into a single reload.
+const watchDebounce = 200 * time.Millisecond
+
+// Watch reloads configuration file each time it changes and passes it to onChange, until ctx is done.
+// Files that can not be loaded are reported to onError and skipped, the last valid configuration stays in effect.
+//
+// The directory of the file is watched, so that files replaced by rename (e.g. File.Save or editors) are tracked.
+func Watch(ctx context.Context, path string, onChange func(*File), onError func(error)) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("create watcher: %w", err)
+	}
+	defer w.Close()
+
+	path = filepath.Clean(path)
+	if err = w.Add(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("watch config dir: %w", err)
+	}
+
+	timer := time.NewTimer(0)
+	<-timer.C
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(ev.Name) != path || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			timer.Reset(watchDebounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			onError(fmt.Errorf("watch config: %w", err))
+		case <-timer.C:
+			f, err := Load(path)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			onChange(f)
+		}
+	}
+}
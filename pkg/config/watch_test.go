@@ -0,0 +1,56 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tun.json")
+	f := &File{}
+	require.NoError(t, f.Set(&Profile{Name: "home", Link: "vless://id@127.0.0.1:443"}))
+	require.NoError(t, f.Save(path))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, errs := make(chan *File, 10), make(chan error, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, path, func(f *File) { changes <- f }, func(err error) { errs <- err })
+	}()
+	time.Sleep(100 * time.Millisecond) // Let watcher start.
+
+	// Replaced by rename.
+	require.NoError(t, f.SetActive("home"))
+	require.NoError(t, f.Save(path))
+	select {
+	case got := <-changes:
+		require.Equal(t, "home", got.Active)
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not reported")
+	}
+
+	// Invalid file is reported and skipped.
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	select {
+	case err := <-errs:
+		require.ErrorContains(t, err, "parse config")
+	case <-time.After(5 * time.Second):
+		t.Fatal("error not reported")
+	}
+
+	// Other files in the directory are ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(path), "other.json"), []byte("{}"), 0o600))
+	select {
+	case <-changes:
+		t.Fatal("unexpected change")
+	case <-time.After(2 * watchDebounce):
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,233 @@
+// Package metrics pushes metrics to Prometheus Pushgateway or statsd and DogStatsD sinks, for devices which
+// can not expose a listening port to be scraped.
+//
+// The sink is chosen by the URL scheme:
+//
+//	http://pushgateway:9091   Pushgateway, metrics are replaced under job "goxray" (or the "job" tag) every push
+//	statsd://host:8125        statsd over UDP, tags are not supported by the protocol
+//	dogstatsd://host:8125     DogStatsD over UDP, tags are sent with every metric
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultInterval is the push interval by default, see Options.
+const DefaultInterval = 15 * time.Second
+
+// DefaultJob is the Pushgateway job of the metrics unless the "job" tag is set.
+const DefaultJob = "goxray"
+
+// Type is the kind of Metric.
+type Type string
+
+const (
+	// Counter only grows, e.g. traffic totals. It is pushed to statsd as the increase since the previous push.
+	Counter Type = "counter"
+	// Gauge is a value which goes up and down, e.g. active connections.
+	Gauge Type = "gauge"
+)
+
+// Metric is a named value of a push.
+type Metric struct {
+	Name  string // Prometheus style name, e.g. "goxray_bytes_read_total".
+	Help  string
+	Type  Type
+	Value float64
+}
+
+// Options configure the pushes.
+type Options struct {
+	// URL of the sink: "http(s)://" Pushgateway, "statsd://" or "dogstatsd://" host and port.
+	URL string
+	// Interval of the pushes (default: DefaultInterval).
+	Interval time.Duration
+	// Tags are added to every metric: Pushgateway grouping labels and DogStatsD tags.
+	Tags map[string]string
+}
+
+// Validate checks the sink URL and tags.
+func (o *Options) Validate() error {
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return fmt.Errorf("invalid metrics push url: %w", err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid metrics push url %q: host is required", o.URL)
+	}
+	switch u.Scheme {
+	case "http", "https", "dogstatsd":
+	case "statsd":
+		if len(o.Tags) > 0 {
+			return errors.New("statsd does not support metric tags, use dogstatsd://")
+		}
+	default:
+		return fmt.Errorf("invalid metrics push url %q: scheme must be http, https, statsd or dogstatsd", o.URL)
+	}
+	if o.Interval < 0 {
+		return errors.New("metrics push interval must not be negative")
+	}
+	for k := range o.Tags {
+		if !validName(k) {
+			return fmt.Errorf("invalid metric tag %q: letters, digits and underscores expected", k)
+		}
+	}
+
+	return nil
+}
+
+// PushInterval returns Interval, DefaultInterval if it is zero.
+func (o *Options) PushInterval() time.Duration {
+	if o.Interval == 0 {
+		return DefaultInterval
+	}
+
+	return o.Interval
+}
+
+// Pusher pushes metrics to the sink of Options.
+type Pusher struct {
+	url  *url.URL
+	tags map[string]string
+	http *http.Client
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// sent are counter values of the previous statsd push.
+	sent map[string]float64
+}
+
+// New returns Pusher of opts, connections to the sink are made with dial.
+func New(opts *Options, dial func(ctx context.Context, network, address string) (net.Conn, error)) (*Pusher, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	u, _ := url.Parse(opts.URL)
+
+	return &Pusher{
+		url:  u,
+		tags: opts.Tags,
+		http: &http.Client{Transport: &http.Transport{DialContext: dial, ForceAttemptHTTP2: true}},
+		dial: dial,
+		sent: make(map[string]float64),
+	}, nil
+}
+
+// Push sends metrics to the sink.
+func (p *Pusher) Push(ctx context.Context, metrics []Metric) error {
+	if p.url.Scheme == "http" || p.url.Scheme == "https" {
+		return p.pushGateway(ctx, metrics)
+	}
+
+	return p.pushStatsD(ctx, metrics)
+}
+
+// pushGateway replaces the metrics of the job and tags on Pushgateway.
+func (p *Pusher) pushGateway(ctx context.Context, metrics []Metric) error {
+	tags := maps.Clone(p.tags)
+	job := DefaultJob
+	if j, ok := tags["job"]; ok {
+		job = j
+		delete(tags, "job")
+	}
+	path := "/metrics/job" + pathValue(job)
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		path += "/" + k + pathValue(tags[k])
+	}
+
+	var body bytes.Buffer
+	for _, m := range metrics {
+		if m.Help != "" {
+			fmt.Fprintf(&body, "# HELP %s %s\n", m.Name, m.Help)
+		}
+		fmt.Fprintf(&body, "# TYPE %s %s\n%s %s\n", m.Name, m.Type, m.Name, formatValue(m.Value))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(p.url.String(), "/")+path, &body)
+	if err != nil {
+		return fmt.Errorf("pushgateway: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("pushgateway: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// pathValue returns Pushgateway URL path element of label value, base64 encoded if it is not a plain name.
+func pathValue(v string) string {
+	if v != "" && validName(v) {
+		return "/" + v
+	}
+
+	return "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(v))
+}
+
+// pushStatsD sends the metrics in one datagram, counters as the increase since the previous push.
+func (p *Pusher) pushStatsD(ctx context.Context, metrics []Metric) error {
+	var tags string
+	if p.url.Scheme == "dogstatsd" && len(p.tags) > 0 {
+		var pairs []string
+		for _, k := range slices.Sorted(maps.Keys(p.tags)) {
+			pairs = append(pairs, k+":"+p.tags[k])
+		}
+		tags = "|#" + strings.Join(pairs, ",")
+	}
+
+	var lines []string
+	sent := make(map[string]float64, len(p.sent))
+	for _, m := range metrics {
+		kind := "g"
+		value := m.Value
+		if m.Type == Counter {
+			kind = "c"
+			sent[m.Name] = m.Value
+			// The whole value is sent after a counter reset, e.g. by restart.
+			if prev, ok := p.sent[m.Name]; ok && prev <= value {
+				value -= prev
+			}
+		}
+		lines = append(lines, m.Name+":"+formatValue(value)+"|"+kind+tags)
+	}
+
+	conn, err := p.dial(ctx, "udp", p.url.Host)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.url.Scheme, err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		return fmt.Errorf("%s: %w", p.url.Scheme, err)
+	}
+	p.sent = sent
+
+	return nil
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// validName reports whether s is a metric or tag name: letters, digits and underscores.
+func validName(s string) bool {
+	return s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
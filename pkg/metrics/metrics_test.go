@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testMetrics = []Metric{
+	{Name: "goxray_connected", Help: "Whether the tunnel is connected.", Type: Gauge, Value: 1},
+	{Name: "goxray_bytes_read_total", Type: Counter, Value: 1024},
+}
+
+func TestPusher_pushGateway(t *testing.T) {
+	var path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		b, _ := io.ReadAll(r.Body)
+		path, body = r.URL.EscapedPath(), string(b)
+	}))
+	defer srv.Close()
+
+	p, err := New(&Options{URL: srv.URL, Tags: map[string]string{"host": "pi", "site": "home/lab"}}, (&net.Dialer{}).DialContext)
+	require.NoError(t, err)
+	require.NoError(t, p.Push(context.Background(), testMetrics))
+	require.Equal(t, "/metrics/job/goxray/host/pi/site@base64/aG9tZS9sYWI", path)
+	require.Equal(t, `# HELP goxray_connected Whether the tunnel is connected.
+# TYPE goxray_connected gauge
+goxray_connected 1
+# TYPE goxray_bytes_read_total counter
+goxray_bytes_read_total 1024
+`, body)
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "pushed metrics are invalid", http.StatusBadRequest)
+	})
+	require.ErrorContains(t, p.Push(context.Background(), testMetrics), "400 Bad Request: pushed metrics are invalid")
+}
+
+func TestPusher_pushStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	read := func() string {
+		b := make([]byte, 1024)
+		n, _, err := conn.ReadFrom(b)
+		require.NoError(t, err)
+		return string(b[:n])
+	}
+
+	p, err := New(&Options{URL: "dogstatsd://" + conn.LocalAddr().String(), Tags: map[string]string{"host": "pi", "env": "home"}},
+		(&net.Dialer{}).DialContext)
+	require.NoError(t, err)
+	require.NoError(t, p.Push(context.Background(), testMetrics))
+	require.Equal(t, "goxray_connected:1|g|#env:home,host:pi\ngoxray_bytes_read_total:1024|c|#env:home,host:pi", read())
+
+	pushed := []Metric{{Name: "goxray_bytes_read_total", Type: Counter, Value: 1536}}
+	require.NoError(t, p.Push(context.Background(), pushed))
+	require.Equal(t, "goxray_bytes_read_total:512|c|#env:home,host:pi", read(), "increase since the previous push")
+	pushed[0].Value = 100
+	require.NoError(t, p.Push(context.Background(), pushed))
+	require.Equal(t, "goxray_bytes_read_total:100|c|#env:home,host:pi", read(), "counter reset")
+}
+
+func TestOptions_Validate(t *testing.T) {
+	require.NoError(t, (&Options{URL: "statsd://127.0.0.1:8125"}).Validate())
+	for _, opts := range []*Options{
+		{URL: "pushgateway:9091"},
+		{URL: "udp://127.0.0.1:8125"},
+		{URL: "statsd://127.0.0.1:8125", Tags: map[string]string{"host": "pi"}},
+		{URL: "dogstatsd://127.0.0.1:8125", Tags: map[string]string{"host name": "pi"}},
+		{URL: "http://127.0.0.1:9091", Interval: -1},
+	} {
+		require.Error(t, opts.Validate(), opts.URL)
+	}
+}
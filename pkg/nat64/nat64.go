@@ -0,0 +1,134 @@
+// Package nat64 discovers NAT64 prefix of IPv6-only networks (RFC 7050) and synthesizes IPv6 addresses
+// of IPv4 hosts reachable through the NAT64 gateway (RFC 6052).
+package nat64
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// WellKnownPrefix is the NAT64 Well-Known Prefix 64:ff9b::/96 (RFC 6052).
+var WellKnownPrefix = &net.IPNet{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)}
+
+// ErrNoPrefix is returned by DiscoverPrefix if the network has no DNS64 resolver.
+var ErrNoPrefix = errors.New("nat64 prefix not found")
+
+// wellKnownName is resolved by DNS64 into the prefix embedded wellKnownIPs (RFC 7050).
+const wellKnownName = "ipv4only.arpa"
+
+// wellKnownIPs are the only IPv4 addresses of wellKnownName.
+var wellKnownIPs = []net.IP{net.IPv4(192, 0, 0, 170).To4(), net.IPv4(192, 0, 0, 171).To4()}
+
+// prefixLengths are the prefix lengths allowed by RFC 6052, the most common first.
+var prefixLengths = []int{96, 64, 56, 48, 40, 32}
+
+// DiscoverPrefix discovers NAT64 prefix by querying AAAA records of "ipv4only.arpa" with resolver r
+// (net.DefaultResolver if nil), it returns ErrNoPrefix if the records are not synthesized by DNS64.
+func DiscoverPrefix(ctx context.Context, r *net.Resolver) (*net.IPNet, error) {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	ips, err := r.LookupIP(ctx, "ip6", wellKnownName)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, ErrNoPrefix
+		}
+
+		return nil, fmt.Errorf("lookup %s: %w", wellKnownName, err)
+	}
+
+	return prefixFromIPs(ips)
+}
+
+// prefixFromIPs finds the prefix embedding one of wellKnownIPs in ips.
+func prefixFromIPs(ips []net.IP) (*net.IPNet, error) {
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			continue
+		}
+		for _, l := range prefixLengths {
+			prefix := &net.IPNet{IP: ip.Mask(net.CIDRMask(l, 128)), Mask: net.CIDRMask(l, 128)}
+			v4, err := Extract(prefix, ip)
+			if err != nil {
+				continue
+			}
+			for _, known := range wellKnownIPs {
+				if v4.Equal(known) {
+					return prefix, nil
+				}
+			}
+		}
+	}
+
+	return nil, ErrNoPrefix
+}
+
+// Synthesize embeds IPv4 address ip into IPv6 prefix as described in RFC 6052 section 2.2.
+func Synthesize(prefix *net.IPNet, ip net.IP) (net.IP, error) {
+	v4 := ip.To4()
+	if v4 == nil {
+		return nil, fmt.Errorf("not an IPv4 address: %s", ip)
+	}
+	start, err := embedStart(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(net.IP, net.IPv6len)
+	copy(out, prefix.IP.To16()[:start])
+	pos := start
+	for _, b := range v4 {
+		if pos == 8 {
+			pos++ // Bits 64 to 71 ("u" octet) must be zero.
+		}
+		out[pos] = b
+		pos++
+	}
+
+	return out, nil
+}
+
+// Extract returns IPv4 address embedded into IPv6 address ip with prefix, it is the reverse of Synthesize.
+func Extract(prefix *net.IPNet, ip net.IP) (net.IP, error) {
+	v6 := ip.To16()
+	if v6 == nil || ip.To4() != nil {
+		return nil, fmt.Errorf("not an IPv6 address: %s", ip)
+	}
+	start, err := embedStart(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if !prefix.Contains(v6) {
+		return nil, fmt.Errorf("%s is not in prefix %s", ip, prefix)
+	}
+	if start < 12 && v6[8] != 0 {
+		return nil, fmt.Errorf("%s has non-zero u octet", ip)
+	}
+
+	out := make(net.IP, 0, net.IPv4len)
+	for pos := start; len(out) < net.IPv4len; pos++ {
+		if pos != 8 {
+			out = append(out, v6[pos])
+		}
+	}
+
+	return out, nil
+}
+
+// embedStart returns the byte offset IPv4 address is embedded at for prefix.
+func embedStart(prefix *net.IPNet) (int, error) {
+	ones, bits := prefix.Mask.Size()
+	if bits != 8*net.IPv6len || prefix.IP.To4() != nil {
+		return 0, fmt.Errorf("not an IPv6 prefix: %s", prefix)
+	}
+	for _, l := range prefixLengths {
+		if ones == l {
+			return l / 8, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid prefix length %d, must be one of 32, 40, 48, 56, 64, 96", ones)
+}
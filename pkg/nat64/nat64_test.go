@@ -0,0 +1,77 @@
+package nat64
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	require.NoError(t, err)
+
+	return n
+}
+
+// RFC 6052 section 2.4 examples.
+var synthesized = []struct {
+	prefix, ip string
+}{
+	{"2001:db8::/32", "2001:db8:c000:221::"},
+	{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+	{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+	{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+	{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+	{"2001:db8:122:344::/96", "2001:db8:122:344::c000:221"},
+	{"64:ff9b::/96", "64:ff9b::c000:221"},
+}
+
+func TestSynthesize(t *testing.T) {
+	v4 := net.ParseIP("192.0.2.33")
+	for _, tt := range synthesized {
+		t.Run(tt.prefix, func(t *testing.T) {
+			prefix := mustParseCIDR(t, tt.prefix)
+			ip, err := Synthesize(prefix, v4)
+			require.NoError(t, err)
+			require.Equal(t, tt.ip, ip.String())
+
+			back, err := Extract(prefix, ip)
+			require.NoError(t, err)
+			require.True(t, v4.Equal(back), back)
+		})
+	}
+
+	_, err := Synthesize(WellKnownPrefix, net.ParseIP("2001:db8::1"))
+	require.Error(t, err)
+	_, err = Synthesize(mustParseCIDR(t, "2001:db8::/80"), v4)
+	require.ErrorContains(t, err, "invalid prefix length")
+	_, err = Synthesize(mustParseCIDR(t, "10.0.0.0/8"), v4)
+	require.Error(t, err)
+}
+
+func TestExtract(t *testing.T) {
+	_, err := Extract(WellKnownPrefix, net.ParseIP("2001:db8::c000:221"))
+	require.ErrorContains(t, err, "not in prefix")
+	_, err = Extract(mustParseCIDR(t, "2001:db8::/32"), net.ParseIP("2001:db8:c000:221:100::"))
+	require.ErrorContains(t, err, "u octet")
+	_, err = Extract(WellKnownPrefix, net.ParseIP("192.0.2.33"))
+	require.Error(t, err)
+}
+
+func TestPrefixFromIPs(t *testing.T) {
+	prefix, err := prefixFromIPs([]net.IP{net.ParseIP("64:ff9b::c000:aa")})
+	require.NoError(t, err)
+	require.Equal(t, WellKnownPrefix.String(), prefix.String())
+
+	// Network specific /64 prefix, 192.0.0.171 record.
+	prefix, err = prefixFromIPs([]net.IP{net.ParseIP("192.0.0.170"), net.ParseIP("2001:db8:122:344:c0:0:ab00:0")})
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8:122:344::/64", prefix.String())
+
+	_, err = prefixFromIPs([]net.IP{net.ParseIP("2001:db8::1")})
+	require.ErrorIs(t, err, ErrNoPrefix)
+	_, err = prefixFromIPs(nil)
+	require.ErrorIs(t, err, ErrNoPrefix)
+}
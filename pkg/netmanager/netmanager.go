@@ -0,0 +1,42 @@
+// Package netmanager registers the TUN device with the network configuration of Linux desktops: NetworkManager
+// is told to leave the device unmanaged, so it does not flush its addresses and routes, and DNS servers of
+// the tunnel are set for the device with systemd-resolved (via resolvectl), which NetworkManager hands DNS to
+// on most distributions.
+//
+// Both settings belong to the device, they are gone once it is removed.
+package netmanager
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// ErrUnsupported is returned by Register on platforms other than Linux.
+var ErrUnsupported = errors.New("network manager integration is supported on Linux only")
+
+// ErrNotRunning is returned by Register if there is nothing to register with: NetworkManager does not run
+// and no DNS servers are given.
+var ErrNotRunning = errors.New("NetworkManager is not running")
+
+// Register leaves device ifname unmanaged by NetworkManager, if it runs, and routes DNS queries of all domains
+// to dns servers of the device, if any are given.
+func Register(ifname string, dns []net.IP) error {
+	if ifname == "" {
+		return errors.New("no device name")
+	}
+
+	return register(ifname, dns)
+}
+
+// run executes command and returns its output, replaced in tests.
+var run = func(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return string(out), nil
+}
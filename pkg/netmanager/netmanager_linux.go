@@ -0,0 +1,44 @@
+package netmanager
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+func register(ifname string, dns []net.IP) error {
+	running := nmRunning()
+	if running {
+		// A managed device is configured by NetworkManager, which removes addresses and routes it did not add.
+		if _, err := run("nmcli", "device", "set", ifname, "managed", "no"); err != nil {
+			return err
+		}
+	}
+	if len(dns) == 0 {
+		if !running {
+			return ErrNotRunning
+		}
+		return nil
+	}
+
+	args := []string{"dns", ifname}
+	for _, ip := range dns {
+		args = append(args, ip.String())
+	}
+	// "~." routing domain with default route sends queries of all domains to the device servers,
+	// like DNS priority of VPN connections of NetworkManager.
+	for _, cmd := range [][]string{args, {"domain", ifname, "~."}, {"default-route", ifname, "true"}} {
+		if _, err := run("resolvectl", cmd...); err != nil {
+			return fmt.Errorf("tunnel dns requires systemd-resolved: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// nmRunning tells whether NetworkManager runs, nmcli may be installed without it.
+func nmRunning() bool {
+	out, err := run("nmcli", "-t", "-f", "RUNNING", "general")
+
+	return err == nil && strings.TrimSpace(out) == "running"
+}
@@ -0,0 +1,61 @@
+package netmanager
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stubRun replaces run, recording the commands. NetworkManager runs if nm is set, resolvectl fails if failResolved is.
+func stubRun(t *testing.T, nm, failResolved bool) *[]string {
+	var calls []string
+	prev := run
+	t.Cleanup(func() { run = prev })
+	run = func(name string, args ...string) (string, error) {
+		cmd := name + " " + strings.Join(args, " ")
+		switch {
+		case cmd == "nmcli -t -f RUNNING general":
+			if !nm {
+				return "", errors.New("NetworkManager is not running")
+			}
+			return "running\n", nil
+		case name == "resolvectl" && failResolved:
+			return "", errors.New("resolvectl: not found")
+		}
+		calls = append(calls, cmd)
+
+		return "", nil
+	}
+
+	return &calls
+}
+
+func TestRegister(t *testing.T) {
+	calls := stubRun(t, true, false)
+	require.NoError(t, Register("tun0", []net.IP{net.IPv4(1, 1, 1, 1), net.ParseIP("2606:4700:4700::1111")}))
+	require.Equal(t, []string{
+		"nmcli device set tun0 managed no",
+		"resolvectl dns tun0 1.1.1.1 2606:4700:4700::1111",
+		"resolvectl domain tun0 ~.",
+		"resolvectl default-route tun0 true",
+	}, *calls)
+
+	calls = stubRun(t, true, false)
+	require.NoError(t, Register("tun0", nil))
+	require.Equal(t, []string{"nmcli device set tun0 managed no"}, *calls, "no dns must be set")
+
+	calls = stubRun(t, false, false)
+	require.NoError(t, Register("tun0", []net.IP{net.IPv4(1, 1, 1, 1)}))
+	require.Len(t, *calls, 3, "dns must be set with systemd-resolved alone")
+
+	stubRun(t, false, false)
+	require.ErrorIs(t, Register("tun0", nil), ErrNotRunning)
+
+	stubRun(t, true, true)
+	require.ErrorContains(t, Register("tun0", []net.IP{net.IPv4(1, 1, 1, 1)}), "systemd-resolved")
+
+	require.Error(t, Register("", nil))
+}
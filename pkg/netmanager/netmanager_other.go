@@ -0,0 +1,9 @@
+//go:build !linux
+
+package netmanager
+
+import "net"
+
+func register(string, []net.IP) error {
+	return ErrUnsupported
+}
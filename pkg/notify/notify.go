@@ -0,0 +1,41 @@
+// Package notify shows desktop notifications: org.freedesktop.Notifications over the D-Bus session bus on Linux
+// (via gdbus of GLib) and Notification Center on macOS (via osascript).
+//
+// The daemon usually runs as root outside of desktop sessions, the notifications are shown to the users
+// logged in to the desktop then.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// AppName is the application name notifications are shown with.
+const AppName = "GoXRay"
+
+// ErrUnsupported is returned by Send on platforms without desktop notifications support.
+var ErrUnsupported = errors.New("desktop notifications are not supported on this platform")
+
+// ErrNoSession is returned by Send if there is no desktop session to show notifications in.
+var ErrNoSession = errors.New("no desktop session")
+
+// Send shows desktop notification with summary and body.
+func Send(summary, body string) error {
+	return send(summary, body)
+}
+
+// run executes command with extra environment variables, replaced in tests.
+var run = func(env []string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(cmd.Environ(), env...)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
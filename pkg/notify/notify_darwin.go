@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// send shows notification with osascript, in the session of the console user if run by root.
+func send(summary, body string) error {
+	script := "display notification " + appleScriptString(body) +
+		" with title " + appleScriptString(AppName) + " subtitle " + appleScriptString(summary)
+	if os.Geteuid() != 0 {
+		return run(nil, "osascript", "-e", script)
+	}
+
+	st, err := os.Stat("/dev/console")
+	if err != nil {
+		return ErrNoSession
+	}
+	uid := st.Sys().(*syscall.Stat_t).Uid
+	if uid == 0 {
+		return ErrNoSession // Login window.
+	}
+
+	return run(nil, "launchctl", "asuser", strconv.FormatUint(uint64(uid), 10), "osascript", "-e", script)
+}
+
+// appleScriptString quotes s as AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
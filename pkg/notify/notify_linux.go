@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// runtimeDir is the directory of per-user runtime directories with the session bus sockets, replaced in tests.
+var runtimeDir = "/run/user"
+
+// geteuid is os.Geteuid, replaced in tests.
+var geteuid = os.Geteuid
+
+// lookupGid returns primary group of user uid, replaced in tests.
+var lookupGid = func(uid string) (string, error) {
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return "", err
+	}
+
+	return u.Gid, nil
+}
+
+// expireTimeout is how long notifications are shown, in milliseconds.
+const expireTimeout = "10000"
+
+// send calls Notify method of org.freedesktop.Notifications on the session bus of the environment, or on
+// the session buses of all logged in users if run by root outside of a session.
+func send(summary, body string) error {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+		return run(nil, "gdbus", notifyArgs(summary, body)...)
+	}
+
+	uid := geteuid()
+	if uid != 0 {
+		return sendBus(nil, strconv.Itoa(uid), summary, body)
+	}
+	entries, err := os.ReadDir(runtimeDir)
+	if err != nil {
+		return ErrNoSession
+	}
+	var (
+		sent bool
+		errs []error
+	)
+	for _, e := range entries {
+		uid := e.Name()
+		if _, err := strconv.Atoi(uid); err != nil || uid == "0" {
+			continue
+		}
+		gid, err := lookupGid(uid)
+		if err != nil {
+			continue
+		}
+		// The user bus accepts connections of its user only.
+		asUser := []string{"--reuid=" + uid, "--regid=" + gid, "--init-groups", "--"}
+		if err = sendBus(asUser, uid, summary, body); errors.Is(err, ErrNoSession) {
+			continue
+		}
+		sent = true
+		errs = append(errs, err)
+	}
+	if !sent {
+		return ErrNoSession
+	}
+
+	return errors.Join(errs...)
+}
+
+// sendBus sends notification to the session bus of user uid, asUser are setpriv arguments switching to the user.
+func sendBus(asUser []string, uid, summary, body string) error {
+	bus := filepath.Join(runtimeDir, uid, "bus")
+	if _, err := os.Stat(bus); err != nil {
+		return ErrNoSession
+	}
+	env := []string{"DBUS_SESSION_BUS_ADDRESS=unix:path=" + bus}
+	if asUser == nil {
+		return run(env, "gdbus", notifyArgs(summary, body)...)
+	}
+
+	return run(env, "setpriv", append(append(asUser, "gdbus"), notifyArgs(summary, body)...)...)
+}
+
+// notifyArgs returns gdbus arguments calling Notify(app_name, replaces_id, app_icon, summary, body, actions,
+// hints, expire_timeout).
+func notifyArgs(summary, body string) []string {
+	return []string{
+		"call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.Notify",
+		AppName, "0", "network-vpn", summary, body, "[]", "{}", expireTimeout,
+	}
+}
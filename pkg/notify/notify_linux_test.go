@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stubRun replaces run, runtimeDir and geteuid, it returns the recorded commands with their environment.
+func stubRun(t *testing.T, euid int) (dir string, calls *[]string) {
+	calls = &[]string{}
+	prevRun, prevDir, prevEuid := run, runtimeDir, geteuid
+	t.Cleanup(func() { run, runtimeDir, geteuid = prevRun, prevDir, prevEuid })
+	dir = t.TempDir()
+	runtimeDir, geteuid = dir, func() int { return euid }
+	run = func(env []string, name string, args ...string) error {
+		*calls = append(*calls, strings.Join(append(append(env, name), args...), " "))
+		return nil
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "")
+
+	return dir, calls
+}
+
+func addBus(t *testing.T, dir, uid string) string {
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, uid), 0o700))
+	bus := filepath.Join(dir, uid, "bus")
+	require.NoError(t, os.WriteFile(bus, nil, 0o600))
+
+	return bus
+}
+
+func TestSend(t *testing.T) {
+	dir, calls := stubRun(t, 1000)
+	require.ErrorIs(t, Send("VPN connected", "home"), ErrNoSession)
+
+	bus := addBus(t, dir, "1000")
+	require.NoError(t, Send("VPN connected", "home"))
+	require.Equal(t, []string{"DBUS_SESSION_BUS_ADDRESS=unix:path=" + bus + " gdbus call --session" +
+		" --dest org.freedesktop.Notifications --object-path /org/freedesktop/Notifications" +
+		" --method org.freedesktop.Notifications.Notify GoXRay 0 network-vpn VPN connected home [] {} 10000"}, *calls)
+
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/tmp/bus")
+	*calls = nil
+	require.NoError(t, Send("VPN connected", "home"))
+	require.Len(t, *calls, 1)
+	require.True(t, strings.HasPrefix((*calls)[0], "gdbus call"), "bus of the environment")
+}
+
+func TestSend_Root(t *testing.T) {
+	dir, calls := stubRun(t, 0)
+	prevLookup := lookupGid
+	t.Cleanup(func() { lookupGid = prevLookup })
+	lookupGid = func(uid string) (string, error) {
+		if uid == "1000" {
+			return "100", nil
+		}
+		return "", errors.New("unknown user")
+	}
+
+	addBus(t, dir, "0")
+	require.ErrorIs(t, Send("VPN connected", "home"), ErrNoSession, "root session is skipped")
+
+	addBus(t, dir, "1001") // Unknown user.
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "1000"), 0o700))
+	require.ErrorIs(t, Send("VPN connected", "home"), ErrNoSession, "no bus of the user")
+
+	bus := addBus(t, dir, "1000")
+	require.NoError(t, Send("VPN connected", "home"))
+	require.Len(t, *calls, 1)
+	require.True(t, strings.HasPrefix((*calls)[0], "DBUS_SESSION_BUS_ADDRESS=unix:path="+bus+
+		" setpriv --reuid=1000 --regid=100 --init-groups -- gdbus call --session"), (*calls)[0])
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package notify
+
+func send(string, string) error {
+	return ErrUnsupported
+}
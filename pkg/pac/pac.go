@@ -0,0 +1,178 @@
+// Package pac generates proxy auto-config (PAC) files and serves them over HTTP.
+package pac
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// ContentType is the MIME type of PAC files.
+const ContentType = "application/x-ns-proxy-autoconfig"
+
+// Rules decide which hosts go via the proxy, the first matching rule wins in the order:
+// DirectDomains, ProxyDomains, DirectNets, ProxyNets. Not matching hosts are connected directly.
+//
+// Domain rules match the domain itself and all its subdomains. Net rules resolve the host
+// (only if there are net rules not covering all IPv4 addresses) and support IPv4 networks only.
+type Rules struct {
+	// Proxy is PAC proxy directive, e.g. "SOCKS5 127.0.0.1:1080; SOCKS 127.0.0.1:1080".
+	Proxy         string
+	ProxyDomains  []string
+	DirectDomains []string
+	ProxyNets     []*net.IPNet
+	DirectNets    []*net.IPNet
+}
+
+// LocalNets are loopback, private and link-local IPv4 networks, usually connected directly.
+var LocalNets = []*net.IPNet{
+	mustParseCIDR("127.0.0.0/8"),
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("169.254.0.0/16"),
+}
+
+var script = template.Must(template.New("pac").Parse(`function FindProxyForURL(url, host) {
+	var proxy = {{printf "%q" .Proxy}};
+	if (isPlainHostName(host)) {
+		return "DIRECT";
+	}
+{{- range .DirectDomains}}
+	if (host == {{printf "%q" .}} || dnsDomainIs(host, {{printf "%q" (printf ".%s" .)}})) {
+		return "DIRECT";
+	}
+{{- end}}
+{{- range .ProxyDomains}}
+	if (host == {{printf "%q" .}} || dnsDomainIs(host, {{printf "%q" (printf ".%s" .)}})) {
+		return proxy;
+	}
+{{- end}}
+{{- if .ResolveNets}}
+	var ip = dnsResolve(host);
+	if (!ip) {
+		return {{if .ProxyAll}}proxy{{else}}"DIRECT"{{end}};
+	}
+{{- range .DirectNets}}
+	if (isInNet(ip, "{{.IP}}", "{{.Mask}}")) {
+		return "DIRECT";
+	}
+{{- end}}
+{{- range .ProxyNets}}
+	if (isInNet(ip, "{{.IP}}", "{{.Mask}}")) {
+		return proxy;
+	}
+{{- end}}
+{{- end}}
+	return {{if .ProxyAll}}proxy{{else}}"DIRECT"{{end}};
+}
+`))
+
+type pacNet struct {
+	IP   string
+	Mask string
+}
+
+// Script returns PAC file contents for the rules.
+func Script(r Rules) []byte {
+	data := struct {
+		Proxy         string
+		ProxyDomains  []string
+		DirectDomains []string
+		DirectNets    []pacNet
+		ProxyNets     []pacNet
+		ProxyAll      bool
+		ResolveNets   bool
+	}{
+		Proxy:         r.Proxy,
+		ProxyDomains:  normalizeDomains(r.ProxyDomains),
+		DirectDomains: normalizeDomains(r.DirectDomains),
+		DirectNets:    pacNets(r.DirectNets),
+		ProxyAll:      coversIPv4(r.ProxyNets),
+	}
+	if !data.ProxyAll {
+		data.ProxyNets = pacNets(r.ProxyNets)
+	}
+	// With all addresses proxied direct nets are the only exceptions worth resolving the host for.
+	data.ResolveNets = len(data.DirectNets) > 0 || len(data.ProxyNets) > 0
+
+	var b bytes.Buffer
+	_ = script.Execute(&b, data)
+
+	return b.Bytes()
+}
+
+// Handler serves PAC file returned by script on every request, so it reflects current rules.
+func Handler(script func() []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(script())
+	})
+}
+
+func normalizeDomains(domains []string) []string {
+	var normalized []string
+	for _, d := range domains {
+		d = strings.Trim(strings.ToLower(strings.TrimSpace(d)), ".")
+		d = strings.TrimPrefix(d, "*.")
+		if d != "" {
+			normalized = append(normalized, d)
+		}
+	}
+
+	return normalized
+}
+
+func pacNets(nets []*net.IPNet) []pacNet {
+	var converted []pacNet
+	for _, n := range nets {
+		ip := n.IP.To4()
+		if ip == nil || len(n.Mask) != net.IPv4len && len(n.Mask) != net.IPv6len {
+			continue
+		}
+		mask := n.Mask
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+		converted = append(converted, pacNet{IP: ip.String(), Mask: net.IP(mask).String()})
+	}
+
+	return converted
+}
+
+// coversIPv4 reports whether nets cover the whole IPv4 space the way default routes do
+// (0.0.0.0/0 or both 0.0.0.0/1 and 128.0.0.0/1).
+func coversIPv4(nets []*net.IPNet) bool {
+	var low, high bool
+	for _, n := range nets {
+		ones, bits := n.Mask.Size()
+		if n.IP.To4() == nil || bits == 0 {
+			continue
+		}
+		if bits == net.IPv6len*8 {
+			ones -= 96
+		}
+		switch {
+		case ones == 0:
+			return true
+		case ones == 1 && n.IP.To4()[0] == 0:
+			low = true
+		case ones == 1 && n.IP.To4()[0] == 128:
+			high = true
+		}
+	}
+
+	return low && high
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}
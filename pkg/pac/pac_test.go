@@ -0,0 +1,66 @@
+package pac
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScript(t *testing.T) {
+	_, low, _ := net.ParseCIDR("0.0.0.0/1")
+	_, high, _ := net.ParseCIDR("128.0.0.0/1")
+	r := Rules{
+		Proxy:         "SOCKS5 127.0.0.1:1080",
+		ProxyDomains:  []string{"*.Example.com"},
+		DirectDomains: []string{"intranet.local."},
+		ProxyNets:     []*net.IPNet{low, high},
+		DirectNets:    LocalNets[:1],
+	}
+
+	require.Equal(t, `function FindProxyForURL(url, host) {
+	var proxy = "SOCKS5 127.0.0.1:1080";
+	if (isPlainHostName(host)) {
+		return "DIRECT";
+	}
+	if (host == "intranet.local" || dnsDomainIs(host, ".intranet.local")) {
+		return "DIRECT";
+	}
+	if (host == "example.com" || dnsDomainIs(host, ".example.com")) {
+		return proxy;
+	}
+	var ip = dnsResolve(host);
+	if (!ip) {
+		return proxy;
+	}
+	if (isInNet(ip, "127.0.0.0", "255.0.0.0")) {
+		return "DIRECT";
+	}
+	return proxy;
+}
+`, string(Script(r)))
+
+	// Only listed networks are proxied, IPv6 networks are skipped.
+	_, n, _ := net.ParseCIDR("203.0.113.0/24")
+	_, v6, _ := net.ParseCIDR("2001:db8::/32")
+	script := string(Script(Rules{Proxy: "SOCKS5 127.0.0.1:1080", ProxyNets: []*net.IPNet{n, v6}}))
+	require.Contains(t, script, `if (isInNet(ip, "203.0.113.0", "255.255.255.0")) {
+		return proxy;
+	}
+	return "DIRECT";`)
+	require.NotContains(t, script, "2001:db8")
+
+	// Nothing to resolve for.
+	require.NotContains(t, string(Script(Rules{Proxy: "SOCKS5 127.0.0.1:1080"})), "dnsResolve")
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler(func() []byte { return []byte("function FindProxyForURL() {}") }).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/proxy.pac", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	require.Equal(t, "function FindProxyForURL() {}", rec.Body.String())
+}
@@ -0,0 +1,121 @@
+// Package qr encodes share links into QR codes and decodes them from images,
+// the way XRay mobile clients exchange connection configs.
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register image decoders for Decode.
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// quietZone is the number of blank modules around the code.
+const quietZone = 2
+
+// Encode encodes text into QR code image with each module scaled to scale pixels.
+func Encode(text string, scale int) (image.Image, error) {
+	m, err := encode(text)
+	if err != nil {
+		return nil, err
+	}
+	if scale < 1 {
+		scale = 1
+	}
+
+	w, h := m.GetWidth(), m.GetHeight()
+	img := image.NewGray(image.Rect(0, 0, w*scale, h*scale))
+	for y := range h * scale {
+		for x := range w * scale {
+			if !m.Get(x/scale, y/scale) {
+				img.Pix[y*img.Stride+x] = 0xff
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// WritePNG encodes text into QR code and writes it as PNG image.
+func WritePNG(w io.Writer, text string, scale int) error {
+	img, err := Encode(text, scale)
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(w, img)
+}
+
+// Terminal renders QR code of text with unicode half blocks, two modules per character vertically.
+// The code is drawn as light modules on dark background, so it is readable on dark terminals.
+func Terminal(text string) (string, error) {
+	m, err := encode(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	w, h := m.GetWidth(), m.GetHeight()
+	for y := 0; y < h; y += 2 {
+		for x := range w {
+			top, bottom := !m.Get(x, y), y+1 < h && !m.Get(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteByte('\n')
+	}
+
+	return b.String(), nil
+}
+
+// Decode finds QR code in the image and returns its text.
+func Decode(r io.Reader) (string, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return "", fmt.Errorf("decode image: %w", err)
+	}
+
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", fmt.Errorf("read image: %w", err)
+	}
+	res, err := qrcode.NewQRCodeReader().Decode(bmp, map[gozxing.DecodeHintType]any{
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("qr code not found: %w", err)
+	}
+
+	return res.GetText(), nil
+}
+
+func encode(text string) (*gozxing.BitMatrix, error) {
+	if text == "" {
+		return nil, errors.New("empty text")
+	}
+
+	// Zero size makes the matrix one pixel per module.
+	m, err := qrcode.NewQRCodeWriter().Encode(text, gozxing.BarcodeFormat_QR_CODE, 0, 0, map[gozxing.EncodeHintType]any{
+		gozxing.EncodeHintType_ERROR_CORRECTION: "M",
+		gozxing.EncodeHintType_MARGIN:           quietZone,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode qr code: %w", err)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,37 @@
+package qr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testLink = "vless://b831381d-6324-4d53-ad4f-8cda48b30811@example.com:443?encryption=none&security=tls&type=ws#remark"
+
+func TestWritePNG_Decode(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WritePNG(&buf, testLink, 4))
+
+	text, err := Decode(&buf)
+	require.NoError(t, err)
+	require.Equal(t, testLink, text)
+
+	_, err = Decode(strings.NewReader("not an image"))
+	require.ErrorContains(t, err, "decode image")
+}
+
+func TestTerminal(t *testing.T) {
+	out, err := Terminal(testLink)
+	require.NoError(t, err)
+
+	img, err := Encode(testLink, 1)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	require.Len(t, lines, (img.Bounds().Dy()+1)/2)
+	require.Equal(t, strings.Repeat("█", img.Bounds().Dx()), lines[0], "quiet zone must be blank")
+
+	_, err = Terminal("")
+	require.Error(t, err)
+}
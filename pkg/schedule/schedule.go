@@ -0,0 +1,177 @@
+// Package schedule implements cron expressions and connection schedules built of them.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression of 5 fields: minute, hour, day of month, month and day of week,
+// e.g. "0 9 * * 1-5" is 9:00 on workdays. Fields are "*", values, ranges ("1-5") and steps ("*/15", "0-30/10"),
+// separated by commas. Day of week is 0-7, both 0 and 7 are Sunday. If both day fields are restricted,
+// either of them matches (like in crontab).
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	anyDOM, anyDOW                bool
+}
+
+// field bounds in order of Cron fields.
+var fields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses cron expression.
+func Parse(expr string) (Cron, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return Cron{}, fmt.Errorf("invalid cron %q: want %d fields, got %d", expr, len(fields), len(parts))
+	}
+
+	var masks [5]uint64
+	for i, f := range fields {
+		var err error
+		if masks[i], err = parseField(parts[i], f.min, f.max); err != nil {
+			return Cron{}, fmt.Errorf("invalid cron %q: %s: %w", expr, f.name, err)
+		}
+	}
+	if masks[4]&(1<<7) != 0 {
+		masks[4] |= 1 // 7 is Sunday too.
+	}
+
+	return Cron{
+		minute: masks[0], hour: masks[1], dom: masks[2], month: masks[3], dow: masks[4],
+		anyDOM: parts[2] == "*", anyDOW: parts[4] == "*",
+	}, nil
+}
+
+// parseField returns bitmask of values matched by cron field.
+func parseField(s string, min, max int) (uint64, error) {
+	var mask uint64
+	for _, item := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q", from)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q", to)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", rng, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << v
+		}
+	}
+
+	return mask, nil
+}
+
+// maxSearch limits search of the next match, e.g. "0 0 30 2 *" never matches.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the first time matching the expression after t, in the location of t.
+// Zero time is returned if there is no match within 5 years.
+func (c Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c Cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.anyDOM && c.anyDOW:
+		return true
+	case c.anyDOM:
+		return dow
+	case c.anyDOW:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Schedule is a recurring connection window, connected from Connect until Disconnect times,
+// e.g. work hours are Connect "0 9 * * 1-5" and Disconnect "0 18 * * 1-5".
+type Schedule struct {
+	Connect    Cron
+	Disconnect Cron
+}
+
+// New parses connect and disconnect cron expressions into Schedule.
+func New(connect, disconnect string) (*Schedule, error) {
+	c, err := Parse(connect)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	d, err := Parse(disconnect)
+	if err != nil {
+		return nil, fmt.Errorf("disconnect: %w", err)
+	}
+	if c.Next(time.Now()).IsZero() || d.Next(time.Now()).IsZero() {
+		return nil, errors.New("schedule never fires")
+	}
+
+	return &Schedule{Connect: c, Disconnect: d}, nil
+}
+
+// Active reports whether t is within the connection window, i.e. the next transition is disconnect.
+func (s *Schedule) Active(t time.Time) bool {
+	c, d := s.Connect.Next(t), s.Disconnect.Next(t)
+
+	return !d.IsZero() && (c.IsZero() || d.Before(c))
+}
+
+// Next returns time of the next transition after t.
+func (s *Schedule) Next(t time.Time) time.Time {
+	c, d := s.Connect.Next(t), s.Disconnect.Next(t)
+	if c.IsZero() || !d.IsZero() && d.Before(c) {
+		return d
+	}
+
+	return c
+}
@@ -0,0 +1,57 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCron_Next(t *testing.T) {
+	// Wednesday.
+	now := time.Date(2025, time.January, 15, 10, 30, 20, 0, time.UTC)
+	for expr, want := range map[string]time.Time{
+		"* * * * *":         time.Date(2025, time.January, 15, 10, 31, 0, 0, time.UTC),
+		"*/15 * * * *":      time.Date(2025, time.January, 15, 10, 45, 0, 0, time.UTC),
+		"0 9 * * 1-5":       time.Date(2025, time.January, 16, 9, 0, 0, 0, time.UTC),
+		"0 9 * * 6,0":       time.Date(2025, time.January, 18, 9, 0, 0, 0, time.UTC),
+		"0 9 * * 7":         time.Date(2025, time.January, 19, 9, 0, 0, 0, time.UTC),
+		"0 0 1 * *":         time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":        time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+		"0 12 1 * 0":        time.Date(2025, time.January, 19, 12, 0, 0, 0, time.UTC),
+		"10-40/10 10 * * *": time.Date(2025, time.January, 15, 10, 40, 0, 0, time.UTC),
+	} {
+		c, err := Parse(expr)
+		require.NoError(t, err, expr)
+		require.Equal(t, want, c.Next(now), expr)
+	}
+
+	c, err := Parse("0 0 30 2 *")
+	require.NoError(t, err)
+	require.True(t, c.Next(now).IsZero(), "never matches")
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err = Parse(expr)
+		require.Error(t, err, expr)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	s, err := New("0 9 * * 1-5", "0 18 * * 1-5")
+	require.NoError(t, err)
+
+	wed := func(h, m int) time.Time { return time.Date(2025, time.January, 15, h, m, 0, 0, time.UTC) }
+	require.False(t, s.Active(wed(8, 59)))
+	require.True(t, s.Active(wed(9, 0)))
+	require.True(t, s.Active(wed(17, 59)))
+	require.False(t, s.Active(wed(18, 0)))
+	require.False(t, s.Active(time.Date(2025, time.January, 18, 12, 0, 0, 0, time.UTC)), "weekend")
+
+	require.Equal(t, wed(9, 0), s.Next(wed(8, 0)))
+	require.Equal(t, wed(18, 0), s.Next(wed(9, 0)))
+
+	_, err = New("0 9 * * *", "0 0 30 2 *")
+	require.Error(t, err)
+	_, err = New("0 9 * *", "0 18 * * *")
+	require.ErrorContains(t, err, "connect: invalid cron")
+}
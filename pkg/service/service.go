@@ -0,0 +1,92 @@
+// Package service installs the client daemon as a system service and removes it: systemd units on Linux,
+// launchd daemons on macOS and Windows services (removed only, they need a service wrapper to be installed).
+//
+// The daemon is expected to be installed under DefaultName, e.g. "/etc/systemd/system/goxray-tun.service"
+// or "/Library/LaunchDaemons/goxray-tun.plist", other names are given to Install and Uninstall.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultName is the service name of the daemon.
+const DefaultName = "goxray-tun"
+
+// ErrUnsupported is returned by Install and Uninstall on platforms without service manager support.
+var ErrUnsupported = errors.New("services are not supported on this platform")
+
+// Report describes the removed service, it is empty if the service was not installed.
+type Report struct {
+	Stopped bool     `json:"stopped,omitempty"` // Whether the service was running.
+	Files   []string `json:"files,omitempty"`   // Removed unit files.
+}
+
+// Install installs service name running command (the absolute executable path and its arguments) at boot,
+// restarted if it fails, and starts it. It returns path of the written unit file, an installed service is
+// replaced.
+func Install(name string, command []string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid service name %q", name)
+	}
+	if len(command) == 0 || !filepath.IsAbs(command[0]) {
+		return "", errors.New("service command must start with absolute executable path")
+	}
+
+	return install(name, command)
+}
+
+// Uninstall stops and disables service name and removes its unit files. Service which is not installed
+// is not an error.
+func Uninstall(name string) (*Report, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid service name %q", name)
+	}
+
+	return uninstall(name)
+}
+
+// removeFiles removes existing paths, it returns the removed ones.
+func removeFiles(paths []string) ([]string, error) {
+	var (
+		removed []string
+		errs    []error
+	)
+	for _, path := range paths {
+		err := os.Remove(path)
+		switch {
+		case err == nil:
+			removed = append(removed, path)
+		case !errors.Is(err, os.ErrNotExist):
+			errs = append(errs, err)
+		}
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// existing returns paths which exist.
+func existing(paths []string) []string {
+	var found []string
+	for _, path := range paths {
+		if _, err := os.Lstat(path); err == nil {
+			found = append(found, path)
+		}
+	}
+
+	return found
+}
+
+// run executes command and returns its output, replaced in tests.
+var run = func(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return string(out), nil
+}
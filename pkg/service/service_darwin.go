@@ -0,0 +1,89 @@
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// plistDir is the launchd daemons directory, replaced in tests.
+var plistDir = "/Library/LaunchDaemons"
+
+// plistTemplate is launchd plist of the daemon, the parameters are the label and the program arguments.
+const plistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+</dict>
+</plist>
+`
+
+// install writes launchd daemon plist with label name and loads it, a loaded daemon is unloaded first.
+func install(name string, command []string) (string, error) {
+	var args strings.Builder
+	for _, arg := range command {
+		args.WriteString("\t\t<string>" + xmlEscape(arg) + "</string>\n")
+	}
+	plist := fmt.Sprintf(plistTemplate, xmlEscape(name), args.String())
+
+	path := filepath.Join(plistDir, name+".plist")
+	if err := os.WriteFile(path, []byte(plist), 0o644); err != nil {
+		return "", fmt.Errorf("write plist: %w", err)
+	}
+	target := "system/" + name
+	if _, err := run("launchctl", "print", target); err == nil {
+		if _, err = run("launchctl", "bootout", target); err != nil {
+			return path, fmt.Errorf("stop service: %w", err)
+		}
+	}
+	if _, err := run("launchctl", "bootstrap", "system", path); err != nil {
+		return path, fmt.Errorf("start service: %w", err)
+	}
+
+	return path, nil
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
+
+// uninstall unloads launchd daemon with label name and removes its plist.
+func uninstall(name string) (*Report, error) {
+	found := existing([]string{filepath.Join(plistDir, name+".plist")})
+	if len(found) == 0 {
+		return &Report{}, nil
+	}
+
+	report := &Report{}
+	target := "system/" + name
+	if _, err := run("launchctl", "print", target); err == nil {
+		if _, err = run("launchctl", "bootout", target); err != nil {
+			return nil, fmt.Errorf("stop service: %w", err)
+		}
+		report.Stopped = true
+	}
+
+	var err error
+	if report.Files, err = removeFiles(found); err != nil {
+		return report, fmt.Errorf("remove plist: %w", err)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// unitDirs are systemd system unit directories, replaced in tests.
+var unitDirs = []string{"/etc/systemd/system", "/usr/local/lib/systemd/system", "/lib/systemd/system", "/usr/lib/systemd/system"}
+
+// unitTemplate is systemd unit of the daemon, the command line is the only parameter.
+const unitTemplate = `[Unit]
+Description=XRay VPN client
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+ExecStart=%s
+ExecReload=/bin/kill -USR2 $MAINPID
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// install writes systemd unit name.service into the first unit directory, enables and starts it.
+func install(name string, command []string) (string, error) {
+	unit := name + ".service"
+	path := filepath.Join(unitDirs[0], unit)
+	args := make([]string, 0, len(command))
+	for _, arg := range command {
+		args = append(args, unitQuote(arg))
+	}
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(unitTemplate, strings.Join(args, " "))), 0o644); err != nil {
+		return "", fmt.Errorf("write unit: %w", err)
+	}
+	if _, err := run("systemctl", "daemon-reload"); err != nil {
+		return path, err
+	}
+	// Restarted, so a replaced unit runs the new command.
+	if _, err := run("systemctl", "enable", unit); err != nil {
+		return path, fmt.Errorf("enable service: %w", err)
+	}
+	if _, err := run("systemctl", "restart", unit); err != nil {
+		return path, fmt.Errorf("start service: %w", err)
+	}
+
+	return path, nil
+}
+
+// unitQuote quotes systemd command line argument, "%" and "$" start specifiers and variables in units.
+func unitQuote(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// uninstall stops, disables and removes systemd unit name.service.
+func uninstall(name string) (*Report, error) {
+	unit := name + ".service"
+	paths := make([]string, 0, len(unitDirs))
+	for _, dir := range unitDirs {
+		paths = append(paths, filepath.Join(dir, unit))
+	}
+	found := existing(paths)
+	if len(found) == 0 {
+		return &Report{}, nil
+	}
+
+	report := &Report{}
+	if _, err := run("systemctl", "is-active", "--quiet", unit); err == nil {
+		if _, err = run("systemctl", "stop", unit); err != nil {
+			return nil, fmt.Errorf("stop service: %w", err)
+		}
+		report.Stopped = true
+	}
+	if _, err := run("systemctl", "disable", unit); err != nil {
+		return report, fmt.Errorf("disable service: %w", err)
+	}
+
+	var err error
+	report.Files, err = removeFiles(found)
+	if err != nil {
+		return report, fmt.Errorf("remove unit: %w", err)
+	}
+	if _, err = run("systemctl", "daemon-reload"); err != nil {
+		return report, err
+	}
+	_, _ = run("systemctl", "reset-failed", unit) // Fails if the unit never failed.
+
+	return report, nil
+}
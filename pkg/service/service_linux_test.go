@@ -0,0 +1,94 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stubSystemctl replaces run and unitDirs, the unit is active if active is set.
+func stubSystemctl(t *testing.T, active bool) (dir string, calls *[]string) {
+	calls = &[]string{}
+	prevRun, prevDirs := run, unitDirs
+	t.Cleanup(func() { run, unitDirs = prevRun, prevDirs })
+	dir = t.TempDir()
+	unitDirs = []string{dir, filepath.Join(dir, "missing")}
+	run = func(name string, args ...string) (string, error) {
+		require.Equal(t, "systemctl", name)
+		*calls = append(*calls, strings.Join(args, " "))
+		if args[0] == "is-active" && !active || args[0] == "reset-failed" {
+			return "", errors.New("exit status 1")
+		}
+
+		return "", nil
+	}
+
+	return dir, calls
+}
+
+func TestUninstall(t *testing.T) {
+	dir, calls := stubSystemctl(t, true)
+	unit := filepath.Join(dir, DefaultName+".service")
+	require.NoError(t, os.WriteFile(unit, []byte("[Service]\n"), 0o644))
+
+	report, err := Uninstall(DefaultName)
+	require.NoError(t, err)
+	require.Equal(t, &Report{Stopped: true, Files: []string{unit}}, report)
+	require.NoFileExists(t, unit)
+	require.Equal(t, []string{
+		"is-active --quiet goxray-tun.service",
+		"stop goxray-tun.service",
+		"disable goxray-tun.service",
+		"daemon-reload",
+		"reset-failed goxray-tun.service",
+	}, *calls)
+
+	report, err = Uninstall(DefaultName)
+	require.NoError(t, err)
+	require.Equal(t, &Report{}, report, "not installed")
+
+	_, err = Uninstall("../etc/passwd")
+	require.ErrorContains(t, err, "invalid service name")
+}
+
+func TestUninstall_Stopped(t *testing.T) {
+	dir, calls := stubSystemctl(t, false)
+	unit := filepath.Join(dir, "vpn.service")
+	require.NoError(t, os.WriteFile(unit, []byte("[Service]\n"), 0o644))
+
+	report, err := Uninstall("vpn")
+	require.NoError(t, err)
+	require.False(t, report.Stopped)
+	require.Equal(t, []string{unit}, report.Files)
+	require.NotContains(t, *calls, "stop vpn.service")
+}
+
+func TestInstall(t *testing.T) {
+	dir, calls := stubSystemctl(t, false)
+
+	path, err := Install(DefaultName, []string{"/usr/local/bin/tun", "-config", "/etc/goxray/my tun.json", "daemon"})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, DefaultName+".service"), path)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "\nExecStart=/usr/local/bin/tun -config \"/etc/goxray/my tun.json\" daemon\n")
+	require.Equal(t, []string{"daemon-reload", "enable goxray-tun.service", "restart goxray-tun.service"}, *calls)
+
+	_, err = Install(DefaultName, []string{"tun", "daemon"})
+	require.ErrorContains(t, err, "absolute executable path")
+	_, err = Install("a/b", []string{"/usr/local/bin/tun"})
+	require.ErrorContains(t, err, "invalid service name")
+}
+
+func TestUnitQuote(t *testing.T) {
+	require.Equal(t, "daemon", unitQuote("daemon"))
+	require.Equal(t, `"a b"`, unitQuote("a b"))
+	require.Equal(t, `"a\"b"`, unitQuote(`a"b`))
+	require.Equal(t, "100%%", unitQuote("100%"))
+	require.Equal(t, "$$HOME", unitQuote("$HOME"))
+	require.Equal(t, `""`, unitQuote(""))
+}
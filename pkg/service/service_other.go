@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !windows
+
+package service
+
+func install(string, []string) (string, error) {
+	return "", ErrUnsupported
+}
+
+func uninstall(string) (*Report, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// install is not supported, the daemon does not implement the service control protocol itself.
+func install(string, []string) (string, error) {
+	return "", ErrUnsupported
+}
+
+// uninstall stops and deletes Windows service name.
+func uninstall(name string) (*Report, error) {
+	out, err := run("sc.exe", "query", name)
+	if err != nil {
+		return &Report{}, nil // Not installed.
+	}
+
+	report := &Report{}
+	if strings.Contains(out, "RUNNING") {
+		if _, err = run("sc.exe", "stop", name); err != nil {
+			return nil, fmt.Errorf("stop service: %w", err)
+		}
+		report.Stopped = true
+	}
+	if _, err = run("sc.exe", "delete", name); err != nil {
+		return report, fmt.Errorf("delete service: %w", err)
+	}
+
+	return report, nil
+}
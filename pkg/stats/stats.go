@@ -0,0 +1,287 @@
+// Package stats keeps long-term usage statistics: traffic, uptime and connects per day and server.
+package stats
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DayLayout is the layout of Record.Day.
+const DayLayout = "2006-01-02"
+
+// Record is usage of one server on one day (in local time).
+type Record struct {
+	Day          string        `json:"day"`
+	Server       string        `json:"server"`
+	BytesRead    int64         `json:"bytes_read"`
+	BytesWritten int64         `json:"bytes_written"`
+	Uptime       time.Duration `json:"uptime"`
+	// Connects is the number of connections established, all but the first one of the day are reconnects.
+	Connects int `json:"connects"`
+	// Obfuscated is set if traffic obfuscation was on, it costs throughput, so the traffic is not comparable
+	// with the rest.
+	Obfuscated bool `json:"obfuscated,omitempty"`
+}
+
+// openTimeout limits waiting for the database file lock, it is held by another process while it saves.
+const openTimeout = 5 * time.Second
+
+// recordsBucket is the bbolt bucket of records keyed by day and server, see recordKey.
+var recordsBucket = []byte("records")
+
+// Store is a statistics database persisted to a bbolt file, it is safe for concurrent use.
+// Changes are kept in memory until Save, the file is opened only while it is read or saved, so the statistics
+// of a running daemon can be queried by other processes.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	pending map[key]*Record // Changes not saved yet.
+}
+
+type key struct{ day, server string }
+
+// recordKey returns database key of the record of server on day, keys are ordered by day.
+func recordKey(day, server string) []byte {
+	return []byte(day + "\x00" + server)
+}
+
+// Open checks the store file, missing file is opened as empty store and created by Save.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, pending: make(map[key]*Record)}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	db, err := s.open(true)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Close(); err != nil {
+		return nil, fmt.Errorf("close stats: %w", err)
+	}
+
+	return s, nil
+}
+
+// open opens the database file.
+func (s *Store) open(readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(s.path, 0o600, &bolt.Options{Timeout: openTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("open stats %s: %w", s.path, err)
+	}
+
+	return db, nil
+}
+
+// Connected counts a connection to server established at t.
+func (s *Store) Connected(t time.Time, server string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.record(t, server).Connects++
+}
+
+// Obfuscated marks the record of server on the day of t as obfuscated, see Record.Obfuscated.
+func (s *Store) Obfuscated(t time.Time, server string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.record(t, server).Obfuscated = true
+}
+
+// Add adds traffic and uptime of server to the day of t.
+func (s *Store) Add(t time.Time, server string, read, written int64, uptime time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.record(t, server)
+	r.BytesRead += read
+	r.BytesWritten += written
+	r.Uptime += uptime
+}
+
+func (s *Store) record(t time.Time, server string) *Record {
+	k := key{t.Local().Format(DayLayout), server}
+	r, ok := s.pending[k]
+	if !ok {
+		r = &Record{Day: k.day, Server: server}
+		s.pending[k] = r
+	}
+
+	return r
+}
+
+// merge adds counters of change c to r.
+func (r *Record) merge(c *Record) {
+	r.BytesRead += c.BytesRead
+	r.BytesWritten += c.BytesWritten
+	r.Uptime += c.Uptime
+	r.Connects += c.Connects
+	r.Obfuscated = r.Obfuscated || c.Obfuscated
+}
+
+// Query returns records of days since the day of t (all if t is zero), ordered by day and server.
+// Changes not saved yet are included.
+func (s *Store) Query(since time.Time) ([]Record, error) {
+	from := ""
+	if !since.IsZero() {
+		from = since.Local().Format(DayLayout)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make(map[key]*Record)
+	if _, err := os.Stat(s.path); err == nil {
+		db, err := s.open(true)
+		if err != nil {
+			return nil, err
+		}
+		err = db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(recordsBucket)
+			if b == nil {
+				return nil
+			}
+			c := b.Cursor()
+			for k, v := c.Seek([]byte(from)); k != nil; k, v = c.Next() {
+				var r Record
+				if err := json.Unmarshal(v, &r); err != nil {
+					return fmt.Errorf("parse stats record %q: %w", k, err)
+				}
+				records[key{r.Day, r.Server}] = &r
+			}
+			return nil
+		})
+		if err = errors.Join(err, db.Close()); err != nil {
+			return nil, err
+		}
+	}
+	for k, c := range s.pending {
+		if k.day < from {
+			continue
+		}
+		r, ok := records[k]
+		if !ok {
+			r = &Record{Day: k.day, Server: k.server}
+			records[k] = r
+		}
+		r.merge(c)
+	}
+
+	res := make([]Record, 0, len(records))
+	for _, r := range records {
+		res = append(res, *r)
+	}
+	slices.SortFunc(res, func(a, b Record) int {
+		return cmp.Or(cmp.Compare(a.Day, b.Day), cmp.Compare(a.Server, b.Server))
+	})
+
+	return res, nil
+}
+
+// Save adds the changes to the store file in one transaction, the file is created if missing.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("create stats dir: %w", err)
+	}
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(recordsBucket)
+		if err != nil {
+			return err
+		}
+		for k, c := range s.pending {
+			r := Record{Day: k.day, Server: k.server}
+			if v := b.Get(recordKey(k.day, k.server)); v != nil {
+				if err = json.Unmarshal(v, &r); err != nil {
+					return fmt.Errorf("parse stats record %q: %w", recordKey(k.day, k.server), err)
+				}
+			}
+			r.merge(c)
+			v, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err = b.Put(recordKey(k.day, k.server), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err = errors.Join(err, db.Close()); err != nil {
+		return fmt.Errorf("write stats: %w", err)
+	}
+	clear(s.pending)
+
+	return nil
+}
+
+// Sum returns totals of records, grouped by server if byServer is set or by day otherwise.
+// The grouping field is empty in the totals. Totals are in order of the first record of the group.
+func Sum(records []Record, byServer bool) []Record {
+	var res []Record
+	idx := make(map[string]int)
+	for _, r := range records {
+		k := r.Day
+		if byServer {
+			k = r.Server
+		}
+		i, ok := idx[k]
+		if !ok {
+			i = len(res)
+			idx[k] = i
+			t := Record{Day: r.Day}
+			if byServer {
+				t = Record{Server: r.Server}
+			}
+			res = append(res, t)
+		}
+		res[i].merge(&r)
+	}
+
+	return res
+}
+
+// ParseSince parses the start of a query period relative to now: date ("2025-03-01"), number of days ("7d")
+// or duration ("12h"). Empty s is zero time (all records).
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(DayLayout, s, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid since %q: want date, days or duration", s)
+		}
+
+		return now.AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid since %q: want date, days or duration", s)
+	}
+
+	return now.Add(-d), nil
+}
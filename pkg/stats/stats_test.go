@@ -0,0 +1,86 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goxray", "tun.stats.db")
+	s, err := Open(path)
+	require.NoError(t, err)
+	records, err := s.Query(time.Time{})
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	day1 := time.Date(2025, 3, 1, 10, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	s.Connected(day1, "nl")
+	s.Add(day1, "nl", 100, 10, time.Hour)
+	s.Connected(day1, "nl")
+	s.Add(day1, "nl", 50, 5, time.Minute)
+	s.Connected(day1, "de")
+	s.Obfuscated(day1, "de")
+	s.Add(day1, "de", 1, 2, time.Second)
+	s.Add(day2, "nl", 7, 8, time.Minute)
+	require.NoError(t, s.Save())
+	// Saved changes are added to the records of the file.
+	s.Add(day2, "nl", 0, 0, time.Minute)
+	s.Connected(day2, "nl")
+	require.NoError(t, s.Save())
+	require.NoError(t, s.Save())
+
+	s, err = Open(path)
+	require.NoError(t, err)
+	records, err = s.Query(time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, []Record{
+		{Day: "2025-03-01", Server: "de", BytesRead: 1, BytesWritten: 2, Uptime: time.Second, Connects: 1, Obfuscated: true},
+		{Day: "2025-03-01", Server: "nl", BytesRead: 150, BytesWritten: 15, Uptime: time.Hour + time.Minute, Connects: 2},
+		{Day: "2025-03-02", Server: "nl", BytesRead: 7, BytesWritten: 8, Uptime: 2 * time.Minute, Connects: 1},
+	}, records)
+	// Changes not saved yet are queried too.
+	s.Add(day2, "de", 1, 1, time.Second)
+	records, err = s.Query(day2.Add(12 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	records, err = s.Query(time.Time{})
+	require.NoError(t, err)
+
+	require.Equal(t, []Record{
+		{Server: "de", BytesRead: 2, BytesWritten: 3, Uptime: 2 * time.Second, Connects: 1, Obfuscated: true},
+		{Server: "nl", BytesRead: 157, BytesWritten: 23, Uptime: time.Hour + 3*time.Minute, Connects: 3},
+	}, Sum(records, true))
+	require.Equal(t, []Record{
+		{Day: "2025-03-01", BytesRead: 151, BytesWritten: 17, Uptime: time.Hour + time.Minute + time.Second, Connects: 3,
+			Obfuscated: true},
+		{Day: "2025-03-02", BytesRead: 8, BytesWritten: 9, Uptime: 2*time.Minute + time.Second, Connects: 1},
+	}, Sum(records, false))
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = Open(path)
+	require.ErrorContains(t, err, "open stats")
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	for s, want := range map[string]time.Time{
+		"":           {},
+		"2025-03-01": time.Date(2025, 3, 1, 0, 0, 0, 0, time.Local),
+		"7d":         time.Date(2025, 3, 3, 12, 0, 0, 0, time.Local),
+		"12h":        time.Date(2025, 3, 10, 0, 0, 0, 0, time.Local),
+	} {
+		got, err := ParseSince(s, now)
+		require.NoError(t, err, s)
+		require.True(t, want.Equal(got), s)
+	}
+
+	for _, s := range []string{"week", "-1d", "xd", "-1h"} {
+		_, err := ParseSince(s, now)
+		require.ErrorContains(t, err, "invalid since", s)
+	}
+}
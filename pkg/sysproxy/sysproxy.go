@@ -0,0 +1,181 @@
+// Package sysproxy configures operating system proxy settings: networksetup on macOS,
+// GNOME gsettings on Linux and WinINET on Windows.
+//
+// Settings are changed for the desktop user: on Linux gsettings run in the session of the user who ran sudo when
+// the process runs as root, on macOS networksetup settings are system-wide and on Windows they are of the user
+// running the process.
+package sysproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// ErrUnsupported is returned by Set on platforms without system proxy support.
+var ErrUnsupported = errors.New("system proxy is not supported on this platform")
+
+// ErrNoSession is returned on Linux when the process runs as root and the desktop session of the user
+// (SUDO_USER) is not found: settings of root would be changed, which the desktop does not use.
+var ErrNoSession = errors.New("no desktop session of the user")
+
+// Proxy is the proxy configuration to set, empty address leaves the proxy kind disabled.
+type Proxy struct {
+	HTTP   string   // HTTP proxy "host:port", used for HTTPS as well.
+	SOCKS  string   // SOCKS proxy "host:port".
+	Bypass []string // Hosts and networks connected directly (e.g. "localhost", "127.0.0.0/8").
+}
+
+// Set applies p to system proxy settings and returns function restoring the previous settings.
+// Settings changed before a failure are restored by Set itself.
+func Set(p Proxy) (restore func() error, err error) {
+	if p.HTTP == "" && p.SOCKS == "" {
+		return nil, errors.New("no proxy address")
+	}
+	for _, addr := range []string{p.HTTP, p.SOCKS} {
+		if addr == "" {
+			continue
+		}
+		if _, _, err = net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid proxy address: %w", err)
+		}
+	}
+
+	settings, err := proxySettings(p)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := current(settings)
+	if err != nil {
+		return nil, err
+	}
+	if err = applySettings(settings, saved, put); err != nil {
+		return nil, err
+	}
+	refresh()
+
+	return func() error {
+		err := applySettings(revert(settings, saved), nil, put)
+		refresh()
+
+		return err
+	}, nil
+}
+
+// Snapshot holds system proxy settings saved by Save, it can be persisted as JSON.
+type Snapshot struct {
+	settings []setting
+}
+
+// Save returns the current values of system proxy settings changed by Set. Saved before Set and persisted,
+// they can be applied by Restore even if the process setting the proxy crashed.
+func Save() (*Snapshot, error) {
+	settings, err := proxySettings(Proxy{})
+	if err != nil {
+		return nil, err
+	}
+	saved, err := current(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{settings: saved}, nil
+}
+
+// Restore applies settings of s.
+func Restore(s *Snapshot) error {
+	err := applySettings(revert(s.settings, s.settings), nil, put)
+	refresh()
+
+	return err
+}
+
+// snapshotSetting is JSON of setting.
+type snapshotSetting struct {
+	Key   []string `json:"key"`
+	Value string   `json:"value"`
+}
+
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	settings := make([]snapshotSetting, 0, len(s.settings))
+	for _, st := range s.settings {
+		settings = append(settings, snapshotSetting{Key: st.key, Value: st.value})
+	}
+
+	return json.Marshal(settings)
+}
+
+func (s *Snapshot) UnmarshalJSON(b []byte) error {
+	var settings []snapshotSetting
+	if err := json.Unmarshal(b, &settings); err != nil {
+		return err
+	}
+	s.settings = make([]setting, 0, len(settings))
+	for _, st := range settings {
+		s.settings = append(s.settings, setting{key: st.Key, value: st.Value})
+	}
+
+	return nil
+}
+
+// current returns the current values of settings.
+func current(settings []setting) ([]setting, error) {
+	saved := make([]setting, 0, len(settings))
+	for _, s := range settings {
+		value, err := get(s.key)
+		if err != nil {
+			return nil, err
+		}
+		saved = append(saved, setting{key: s.key, value: value})
+	}
+
+	return saved, nil
+}
+
+// run executes command with environment variables env added and returns its output, replaced in tests.
+var run = func(env []string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(cmd.Environ(), env...)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return string(out), nil
+}
+
+// setting is a single system proxy setting value.
+type setting struct {
+	key   []string // Command arguments identifying the setting.
+	value string
+}
+
+// applySettings applies settings in order using set command, on failure applied settings are reverted to saved.
+func applySettings(settings, saved []setting, set func(s setting) error) error {
+	for i, s := range settings {
+		if err := set(s); err != nil {
+			return errors.Join(err, applySettings(revert(settings[:i], saved), nil, set))
+		}
+	}
+
+	return nil
+}
+
+// revert returns saved values of the applied settings, in the reverse order.
+func revert(applied, saved []setting) []setting {
+	var settings []setting
+	for i := len(applied) - 1; i >= 0; i-- {
+		for _, s := range saved {
+			if strings.Join(s.key, " ") == strings.Join(applied[i].key, " ") {
+				settings = append(settings, s)
+			}
+		}
+	}
+
+	return settings
+}
@@ -0,0 +1,110 @@
+package sysproxy
+
+import (
+	"bufio"
+	"net"
+	"strings"
+)
+
+// proxySettings returns proxy settings of p for all enabled network services, applied with networksetup.
+func proxySettings(p Proxy) ([]setting, error) {
+	services, err := networkServices()
+	if err != nil {
+		return nil, err
+	}
+
+	var settings []setting
+	for _, svc := range services {
+		for _, kp := range [][2]string{{"webproxy", p.HTTP}, {"securewebproxy", p.HTTP}, {"socksfirewallproxy", p.SOCKS}} {
+			kind, addr := kp[0], kp[1]
+			value := ""
+			if host, port, err := net.SplitHostPort(addr); err == nil {
+				value = host + " " + port
+			}
+			settings = append(settings, setting{key: []string{kind, svc}, value: value})
+		}
+		settings = append(settings, setting{key: []string{"proxybypassdomains", svc}, value: strings.Join(p.Bypass, " ")})
+	}
+
+	return settings, nil
+}
+
+// get returns the current value of setting key, "host port" of enabled proxy or empty if it is disabled.
+func get(key []string) (string, error) {
+	kind, svc := key[0], key[1]
+	out, err := run(nil, "networksetup", "-get"+kind, svc)
+	if err != nil {
+		return "", err
+	}
+	if kind == "proxybypassdomains" {
+		return parseBypassDomains(out), nil
+	}
+
+	return parseProxyState(out), nil
+}
+
+// put applies setting with networksetup, proxy value is "host port" or empty to turn the proxy off.
+func put(s setting) error {
+	kind, svc := s.key[0], s.key[1]
+	var err error
+	switch {
+	case kind == "proxybypassdomains" && s.value == "":
+		_, err = run(nil, "networksetup", "-setproxybypassdomains", svc, "Empty")
+	case kind == "proxybypassdomains":
+		_, err = run(nil, "networksetup", append([]string{"-setproxybypassdomains", svc}, strings.Fields(s.value)...)...)
+	case s.value == "":
+		_, err = run(nil, "networksetup", "-set"+kind+"state", svc, "off")
+	default:
+		host, port, _ := strings.Cut(s.value, " ")
+		_, err = run(nil, "networksetup", "-set"+kind, svc, host, port)
+	}
+
+	return err
+}
+
+func refresh() {}
+
+// networkServices lists enabled network services, disabled ones are marked with asterisk.
+func networkServices() ([]string, error) {
+	out, err := run(nil, "networksetup", "-listallnetworkservices")
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "*") || strings.HasPrefix(line, "An asterisk") {
+			continue
+		}
+		services = append(services, line)
+	}
+
+	return services, nil
+}
+
+// parseProxyState returns "host port" of enabled proxy from networksetup -get*proxy output, empty if disabled.
+func parseProxyState(out string) string {
+	fields := map[string]string{}
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), ":")
+		if ok {
+			fields[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	if fields["Enabled"] != "Yes" || fields["Server"] == "" {
+		return ""
+	}
+
+	return fields["Server"] + " " + fields["Port"]
+}
+
+func parseBypassDomains(out string) string {
+	if strings.Contains(out, "There aren't any bypass domains") {
+		return ""
+	}
+
+	return strings.Join(strings.Fields(out), " ")
+}
@@ -0,0 +1,117 @@
+package sysproxy
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+const gnomeProxySchema = "org.gnome.system.proxy"
+
+// runtimeDir is the directory of per-user runtime directories with the session bus sockets, replaced in tests.
+var runtimeDir = "/run/user"
+
+// geteuid is os.Geteuid, replaced in tests.
+var geteuid = os.Geteuid
+
+// lookupUser is user.Lookup, replaced in tests.
+var lookupUser = user.Lookup
+
+// proxySettings returns GNOME proxy settings of p, mode is switched to manual last.
+func proxySettings(p Proxy) ([]setting, error) {
+	settings := append(gnomeHostPort("http", p.HTTP), gnomeHostPort("https", p.HTTP)...)
+	settings = append(settings, gnomeHostPort("socks", p.SOCKS)...)
+	settings = append(settings,
+		setting{key: []string{gnomeProxySchema, "ignore-hosts"}, value: gvariantList(p.Bypass)},
+		setting{key: []string{gnomeProxySchema, "mode"}, value: "'manual'"},
+	)
+
+	return settings, nil
+}
+
+// get returns the gsettings value of key.
+func get(key []string) (string, error) {
+	out, err := gsettings(append([]string{"get"}, key...)...)
+
+	return strings.TrimSpace(out), err
+}
+
+// put sets the gsettings value.
+func put(s setting) error {
+	_, err := gsettings(append(append([]string{"set"}, s.key...), s.value)...)
+
+	return err
+}
+
+// gsettings runs gsettings with args in the desktop session, see session.
+func gsettings(args ...string) (string, error) {
+	env, asUser, err := session()
+	if err != nil {
+		return "", err
+	}
+	if asUser == nil {
+		return run(env, "gsettings", args...)
+	}
+
+	return run(env, "setpriv", append(append(asUser, "gsettings"), args...)...)
+}
+
+// session returns environment and setpriv arguments switching to the user of the desktop session, both nil
+// for the session of the process. Run as root (by sudo), it is the session of SUDO_USER: dconf settings
+// of root are not the desktop's.
+func session() (env, asUser []string, err error) {
+	if geteuid() != 0 {
+		return nil, nil, nil
+	}
+	name := os.Getenv("SUDO_USER")
+	if name == "" || name == "root" {
+		if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+			return nil, nil, nil // Desktop session of root.
+		}
+		return nil, nil, fmt.Errorf("%w: run as root without sudo", ErrNoSession)
+	}
+	u, err := lookupUser(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: sudo user %s: %w", ErrNoSession, name, err)
+	}
+	dir := filepath.Join(runtimeDir, u.Uid)
+	bus := filepath.Join(dir, "bus")
+	if _, err = os.Stat(bus); err != nil {
+		return nil, nil, fmt.Errorf("%w: no session bus of %s", ErrNoSession, name)
+	}
+	// The user bus accepts connections of its user only.
+	env = []string{"DBUS_SESSION_BUS_ADDRESS=unix:path=" + bus, "XDG_RUNTIME_DIR=" + dir}
+
+	return env, []string{"--reuid=" + u.Uid, "--regid=" + u.Gid, "--init-groups", "--"}, nil
+}
+
+func refresh() {}
+
+// gnomeHostPort returns host and port settings of the proxy kind, empty addr clears them.
+func gnomeHostPort(kind, addr string) []setting {
+	host, port, _ := net.SplitHostPort(addr)
+	if port == "" {
+		port = "0"
+	}
+
+	return []setting{
+		{key: []string{gnomeProxySchema + "." + kind, "host"}, value: gvariantString(host)},
+		{key: []string{gnomeProxySchema + "." + kind, "port"}, value: port},
+	}
+}
+
+func gvariantString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func gvariantList(items []string) string {
+	quoted := make([]string, 0, len(items))
+	for _, item := range items {
+		quoted = append(quoted, gvariantString(item))
+	}
+
+	return fmt.Sprintf("[%s]", strings.Join(quoted, ", "))
+}
@@ -0,0 +1,150 @@
+package sysproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stubGsettings replaces run with in-memory gsettings of a user process, set of failKey fails.
+func stubGsettings(t *testing.T, values map[string]string, failKey string) *[]string {
+	var calls []string
+	prev, prevEuid := run, geteuid
+	t.Cleanup(func() { run, geteuid = prev, prevEuid })
+	geteuid = func() int { return 1000 }
+	run = func(_ []string, name string, args ...string) (string, error) {
+		require.Equal(t, "gsettings", name)
+		key := args[1] + " " + args[2]
+		switch args[0] {
+		case "get":
+			return values[key] + "\n", nil
+		case "set":
+			if args[2] == failKey {
+				return "", errors.New("set failed")
+			}
+			calls = append(calls, key+"="+args[3])
+			values[key] = args[3]
+		}
+
+		return "", nil
+	}
+
+	return &calls
+}
+
+func TestSet_Gsettings(t *testing.T) {
+	values := map[string]string{
+		"org.gnome.system.proxy mode":         "'none'",
+		"org.gnome.system.proxy ignore-hosts": "['localhost']",
+		"org.gnome.system.proxy.http host":    "''",
+		"org.gnome.system.proxy.http port":    "0",
+		"org.gnome.system.proxy.https host":   "''",
+		"org.gnome.system.proxy.https port":   "0",
+		"org.gnome.system.proxy.socks host":   "'old'",
+		"org.gnome.system.proxy.socks port":   "1080",
+	}
+	before := make(map[string]string, len(values))
+	for k, v := range values {
+		before[k] = v
+	}
+	calls := stubGsettings(t, values, "")
+
+	restore, err := Set(Proxy{HTTP: "127.0.0.1:10808", SOCKS: "127.0.0.1:10808", Bypass: []string{"localhost", "127.0.0.0/8"}})
+	require.NoError(t, err)
+	require.Equal(t, "'127.0.0.1'", values["org.gnome.system.proxy.http host"])
+	require.Equal(t, "10808", values["org.gnome.system.proxy.socks port"])
+	require.Equal(t, "['localhost', '127.0.0.0/8']", values["org.gnome.system.proxy ignore-hosts"])
+	require.Equal(t, "org.gnome.system.proxy mode='manual'", (*calls)[len(*calls)-1], "mode must be switched last")
+
+	*calls = nil
+	require.NoError(t, restore())
+	require.Equal(t, before, values)
+	require.True(t, strings.HasPrefix((*calls)[0], "org.gnome.system.proxy mode="), "mode must be restored first")
+}
+
+func TestSet_GsettingsFailure(t *testing.T) {
+	values := map[string]string{"org.gnome.system.proxy.http host": "'prev'"}
+	stubGsettings(t, values, "ignore-hosts")
+
+	_, err := Set(Proxy{SOCKS: "127.0.0.1:1080"})
+	require.ErrorContains(t, err, "set failed")
+	require.Equal(t, "'prev'", values["org.gnome.system.proxy.http host"], "applied settings must be reverted")
+
+	_, err = Set(Proxy{})
+	require.Error(t, err)
+	_, err = Set(Proxy{HTTP: "localhost"})
+	require.ErrorContains(t, err, "invalid proxy address")
+}
+
+func TestSaveRestore_Gsettings(t *testing.T) {
+	values := map[string]string{
+		"org.gnome.system.proxy mode":       "'auto'",
+		"org.gnome.system.proxy.socks host": "'old'",
+	}
+	calls := stubGsettings(t, values, "")
+	saved, err := Save()
+	require.NoError(t, err)
+	b, err := json.Marshal(saved)
+	require.NoError(t, err)
+
+	// The process crashed after Set, the settings are restored from the persisted snapshot.
+	_, err = Set(Proxy{SOCKS: "127.0.0.1:1080"})
+	require.NoError(t, err)
+	require.Equal(t, "'manual'", values["org.gnome.system.proxy mode"])
+	restored := &Snapshot{}
+	require.NoError(t, json.Unmarshal(b, restored))
+	*calls = nil
+	require.NoError(t, Restore(restored))
+	require.Equal(t, "'auto'", values["org.gnome.system.proxy mode"])
+	require.Equal(t, "'old'", values["org.gnome.system.proxy.socks host"])
+	require.Equal(t, "org.gnome.system.proxy mode='auto'", (*calls)[0], "mode must be restored first")
+}
+
+func TestSet_GsettingsRoot(t *testing.T) {
+	stubGsettings(t, map[string]string{}, "")
+	geteuid = func() int { return 0 }
+	dir := t.TempDir()
+	prevDir, prevLookup := runtimeDir, lookupUser
+	t.Cleanup(func() { runtimeDir, lookupUser = prevDir, prevLookup })
+	runtimeDir = dir
+	lookupUser = func(name string) (*user.User, error) {
+		if name == "alice" {
+			return &user.User{Username: name, Uid: "1000", Gid: "100"}, nil
+		}
+		return nil, user.UnknownUserError(name)
+	}
+	var calls []string
+	run = func(env []string, name string, args ...string) (string, error) {
+		calls = append(calls, strings.Join(append(append(env, name), args...), " "))
+		return "''", nil
+	}
+
+	t.Setenv("SUDO_USER", "")
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "")
+	_, err := Save()
+	require.ErrorIs(t, err, ErrNoSession, "settings of root must not be changed")
+
+	t.Setenv("SUDO_USER", "bob")
+	_, err = Save()
+	require.ErrorIs(t, err, ErrNoSession, "unknown user")
+
+	t.Setenv("SUDO_USER", "alice")
+	_, err = Save()
+	require.ErrorIs(t, err, ErrNoSession, "no bus of the user")
+	require.Empty(t, calls)
+
+	bus := filepath.Join(dir, "1000", "bus")
+	require.NoError(t, os.MkdirAll(filepath.Dir(bus), 0o700))
+	require.NoError(t, os.WriteFile(bus, nil, 0o600))
+	_, err = Save()
+	require.NoError(t, err)
+	require.NotEmpty(t, calls)
+	require.Equal(t, "DBUS_SESSION_BUS_ADDRESS=unix:path="+bus+" XDG_RUNTIME_DIR="+filepath.Dir(bus)+
+		" setpriv --reuid=1000 --regid=100 --init-groups -- gsettings get org.gnome.system.proxy.http host", calls[0])
+}
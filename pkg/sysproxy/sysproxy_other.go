@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !windows
+
+package sysproxy
+
+func proxySettings(Proxy) ([]setting, error) {
+	return nil, ErrUnsupported
+}
+
+func get([]string) (string, error) {
+	return "", ErrUnsupported
+}
+
+func put(setting) error {
+	return ErrUnsupported
+}
+
+func refresh() {}
@@ -0,0 +1,104 @@
+package sysproxy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+const internetSettingsKey = `Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+
+// proxySettings returns WinINET proxy settings of p in the registry.
+func proxySettings(p Proxy) ([]setting, error) {
+	var servers []string
+	if p.HTTP != "" {
+		servers = append(servers, "http="+p.HTTP, "https="+p.HTTP)
+	}
+	if p.SOCKS != "" {
+		servers = append(servers, "socks="+p.SOCKS)
+	}
+	settings := []setting{
+		{key: []string{"ProxyServer"}, value: "REG_SZ " + strings.Join(servers, ";")},
+		{key: []string{"ProxyOverride"}, value: "REG_SZ " + strings.Join(append(p.Bypass, "<local>"), ";")},
+		{key: []string{"ProxyEnable"}, value: "REG_DWORD 1"},
+	}
+
+	return settings, nil
+}
+
+// get returns "TYPE data" of the Internet Settings value, empty if the value does not exist.
+func get(key []string) (string, error) {
+	k, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.QUERY_VALUE)
+	if err != nil {
+		return "", err
+	}
+	defer k.Close()
+
+	_, typ, err := k.GetValue(key[0], nil)
+	if errors.Is(err, registry.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	switch typ {
+	case registry.SZ, registry.EXPAND_SZ:
+		v, _, err := k.GetStringValue(key[0])
+		return "REG_SZ " + v, err
+	case registry.DWORD:
+		v, _, err := k.GetIntegerValue(key[0])
+		return "REG_DWORD " + strconv.FormatUint(v, 10), err
+	default:
+		return "", fmt.Errorf("unsupported type %d of %s", typ, key[0])
+	}
+}
+
+// put sets Internet Settings value from "TYPE data", empty value deletes it.
+func put(s setting) error {
+	k, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	if s.value == "" {
+		if err = k.DeleteValue(s.key[0]); errors.Is(err, registry.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	typ, data, _ := strings.Cut(s.value, " ")
+	switch typ {
+	case "REG_SZ":
+		return k.SetStringValue(s.key[0], data)
+	case "REG_DWORD":
+		// Base 0: snapshots of older versions have the "0x1" of reg query.
+		v, err := strconv.ParseUint(data, 0, 32)
+		if err != nil {
+			return fmt.Errorf("value of %s: %w", s.key[0], err)
+		}
+		return k.SetDWordValue(s.key[0], uint32(v))
+	default:
+		return fmt.Errorf("unsupported type %s of %s", typ, s.key[0])
+	}
+}
+
+// refresh makes running applications reload proxy settings.
+func refresh() {
+	const (
+		internetOptionSettingsChanged = 39
+		internetOptionRefresh         = 37
+	)
+
+	proc := syscall.NewLazyDLL("wininet.dll").NewProc("InternetSetOptionW")
+	if proc.Find() != nil {
+		return
+	}
+	_, _, _ = proc.Call(0, internetOptionSettingsChanged, 0, 0)
+	_, _, _ = proc.Call(0, internetOptionRefresh, 0, 0)
+}
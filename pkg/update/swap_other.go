@@ -0,0 +1,17 @@
+//go:build !windows
+
+package update
+
+import (
+	"fmt"
+	"os"
+)
+
+// swap replaces the binary at path with the file at tmp, rename is atomic: running processes keep the old binary.
+func swap(tmp, path string) error {
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace binary: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package update
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// swap replaces the binary at path with the file at tmp. The running binary can not be replaced on Windows,
+// but it can be renamed: it is moved aside to path with ".old" suffix, removed by the next update.
+func swap(tmp, path string) error {
+	old := path + ".old"
+	if err := os.Remove(old); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove previous binary: %w", err)
+	}
+	if err := os.Rename(path, old); err != nil {
+		return fmt.Errorf("move binary aside: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Join(fmt.Errorf("replace binary: %w", err), os.Rename(old, path))
+	}
+
+	return nil
+}
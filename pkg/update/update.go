@@ -0,0 +1,273 @@
+// Package update finds new releases in a signed release manifest and replaces the running binary with them.
+//
+// The manifest is JSON file published next to its ed25519 signature (the manifest URL with ".sig" suffix,
+// base64 encoded), the binaries are verified by SHA-256 digests of the signed manifest:
+//
+//	{
+//	  "version": "v1.2.0",
+//	  "binaries": {
+//	    "linux/amd64": {"url": "tun-linux-amd64", "sha256": "9f86d0...", "size": 48000000}
+//	  }
+//	}
+//
+// Binary URLs are resolved relative to the manifest URL.
+package update
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxManifestSize limits the manifest and signature responses.
+	maxManifestSize = 1 << 20
+	// maxBinarySize limits the downloaded binary.
+	maxBinarySize = 512 << 20
+)
+
+// ErrSignature is returned if the manifest signature does not match the public key.
+var ErrSignature = errors.New("release manifest signature is invalid")
+
+// Manifest describes the latest release.
+type Manifest struct {
+	// Version of the release, like "v1.2.0".
+	Version string `json:"version"`
+	// Binaries of the release by platform, "GOOS/GOARCH".
+	Binaries map[string]Binary `json:"binaries"`
+}
+
+// Binary is the release binary of a platform.
+type Binary struct {
+	URL    string `json:"url"`            // Absolute or relative to the manifest URL.
+	SHA256 string `json:"sha256"`         // Hex encoded digest.
+	Size   int64  `json:"size,omitempty"` // Size in bytes, zero if unknown.
+}
+
+// Binary returns the binary for platform goos/goarch.
+func (m *Manifest) Binary(goos, goarch string) (Binary, error) {
+	b, ok := m.Binaries[goos+"/"+goarch]
+	if !ok {
+		return Binary{}, fmt.Errorf("release %s has no binary for %s/%s", m.Version, goos, goarch)
+	}
+
+	return b, nil
+}
+
+// Checker fetches the release manifest and installs its binaries.
+type Checker struct {
+	// ManifestURL is the URL of the manifest, the signature is fetched from the URL with ".sig" suffix.
+	ManifestURL string
+	// PublicKey verifies the manifest signature.
+	PublicKey ed25519.PublicKey
+	// Client makes the requests (default: http.DefaultClient).
+	Client *http.Client
+}
+
+// ParsePublicKey parses base64 encoded ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key: %d bytes, want %d", len(b), ed25519.PublicKeySize)
+	}
+
+	return b, nil
+}
+
+// Latest fetches the manifest and verifies its signature.
+func (c *Checker) Latest(ctx context.Context) (*Manifest, error) {
+	if len(c.PublicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("no public key to verify release manifest")
+	}
+	body, err := c.get(ctx, c.ManifestURL, maxManifestSize)
+	if err != nil {
+		return nil, fmt.Errorf("fetch release manifest: %w", err)
+	}
+	encoded, err := c.get(ctx, c.ManifestURL+".sig", maxManifestSize)
+	if err != nil {
+		return nil, fmt.Errorf("fetch release manifest signature: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || !ed25519.Verify(c.PublicKey, body, sig) {
+		return nil, ErrSignature
+	}
+
+	var m Manifest
+	if err = json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("parse release manifest: %w", err)
+	}
+	if m.Version == "" {
+		return nil, errors.New("release manifest has no version")
+	}
+
+	return &m, nil
+}
+
+// Install downloads b, verifies its digest and atomically replaces the binary at path with it, keeping its
+// file mode. path is not changed if anything fails.
+func (c *Checker) Install(ctx context.Context, b Binary, path string) error {
+	want, err := hex.DecodeString(b.SHA256)
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("invalid binary digest %q", b.SHA256)
+	}
+	base, err := url.Parse(c.ManifestURL)
+	if err != nil {
+		return fmt.Errorf("invalid manifest url: %w", err)
+	}
+	ref, err := url.Parse(b.URL)
+	if err != nil {
+		return fmt.Errorf("invalid binary url: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	// The new binary is written next to the old one, so the rename does not cross file systems.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".update-*")
+	if err != nil {
+		return fmt.Errorf("create update file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err = c.download(ctx, base.ResolveReference(ref).String(), b.Size, want, tmp); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		return fmt.Errorf("update file mode: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("write update file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("write update file: %w", err)
+	}
+
+	return swap(tmp.Name(), path)
+}
+
+// download writes binary from url to w verifying its size and digest.
+func (c *Checker) download(ctx context.Context, url string, size int64, digest []byte, w io.Writer) error {
+	resp, err := c.do(ctx, url)
+	if err != nil {
+		return fmt.Errorf("download binary: %w", err)
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(resp.Body, maxBinarySize+1))
+	switch {
+	case err != nil:
+		return fmt.Errorf("download binary: %w", err)
+	case n > maxBinarySize:
+		return fmt.Errorf("download binary: larger than %d bytes", maxBinarySize)
+	case size > 0 && n != size:
+		return fmt.Errorf("download binary: got %d bytes, want %d", n, size)
+	case !bytes.Equal(h.Sum(nil), digest):
+		return errors.New("download binary: sha256 digest mismatch")
+	}
+
+	return nil
+}
+
+// get returns the body of url limited to limit bytes.
+func (c *Checker) get(ctx context.Context, url string, limit int64) ([]byte, error) {
+	resp, err := c.do(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("larger than %d bytes", limit)
+	}
+
+	return b, nil
+}
+
+// do requests url, the response status must be 200.
+func (c *Checker) do(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	cl := c.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// Newer reports whether version v is newer than current. Versions are compared by "vMAJOR.MINOR.PATCH" numbers,
+// a release is newer than its pre-release ("v1.2.0-rc.1"). Any valid v is newer than current without
+// a version (e.g. "dev" builds).
+func Newer(v, current string) bool {
+	nv, pv, ok := parseVersion(v)
+	if !ok {
+		return false
+	}
+	nc, pc, ok := parseVersion(current)
+	if !ok {
+		return true
+	}
+	for i := range nv {
+		if nv[i] != nc[i] {
+			return nv[i] > nc[i]
+		}
+	}
+
+	return pv == "" && pc != "" || pv != "" && pc != "" && pv > pc
+}
+
+// parseVersion parses "v1.2.3-pre" into numbers and the pre-release.
+func parseVersion(v string) (nums [3]int, pre string, ok bool) {
+	v, ok = strings.CutPrefix(v, "v")
+	if !ok {
+		return nums, "", false
+	}
+	v, _, _ = strings.Cut(v, "+") // Build metadata.
+	v, pre, _ = strings.Cut(v, "-")
+	parts := strings.Split(v, ".")
+	if len(parts) != len(nums) {
+		return nums, "", false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nums, "", false
+		}
+		nums[i] = n
+	}
+
+	return nums, pre, true
+}
@@ -0,0 +1,119 @@
+package update
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// release serves manifest signed with key and the binary.
+func release(t *testing.T, key ed25519.PrivateKey, manifest string, binary []byte) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/manifest.json", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(manifest))
+	})
+	mux.HandleFunc("/latest/manifest.json.sig", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(manifest))) + "\n"))
+	})
+	mux.HandleFunc("/latest/tun-linux-amd64", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(binary)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestChecker(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	binary := []byte("new binary")
+	digest := sha256.Sum256(binary)
+	manifest := `{"version": "v1.2.0", "binaries": {"linux/amd64": {"url": "tun-linux-amd64", "sha256": "` +
+		hex.EncodeToString(digest[:]) + `", "size": 10}}}`
+	srv := release(t, key, manifest, binary)
+
+	c := &Checker{ManifestURL: srv.URL + "/latest/manifest.json", PublicKey: pub}
+	m, err := c.Latest(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, "v1.2.0", m.Version)
+	b, err := m.Binary("linux", "amd64")
+	require.NoError(t, err)
+	_, err = m.Binary("plan9", "386")
+	require.ErrorContains(t, err, "release v1.2.0 has no binary for plan9/386")
+
+	path := filepath.Join(t.TempDir(), "tun")
+	require.NoError(t, os.WriteFile(path, []byte("old binary"), 0o751))
+	require.NoError(t, c.Install(t.Context(), b, path))
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, binary, got)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o751), info.Mode().Perm())
+
+	bad := b
+	bad.SHA256 = hex.EncodeToString(make([]byte, sha256.Size))
+	require.ErrorContains(t, c.Install(t.Context(), bad, path), "sha256 digest mismatch")
+	bad = b
+	bad.Size = 11
+	require.ErrorContains(t, c.Install(t.Context(), bad, path), "got 10 bytes, want 11")
+	bad = b
+	bad.URL = "missing"
+	require.ErrorContains(t, c.Install(t.Context(), bad, path), "404")
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "failed updates leave no files")
+
+	other, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, err = (&Checker{ManifestURL: c.ManifestURL, PublicKey: other}).Latest(t.Context())
+	require.ErrorIs(t, err, ErrSignature)
+	_, err = (&Checker{ManifestURL: c.ManifestURL}).Latest(t.Context())
+	require.ErrorContains(t, err, "no public key")
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	key, err := ParsePublicKey(base64.StdEncoding.EncodeToString(pub) + "\n")
+	require.NoError(t, err)
+	require.Equal(t, pub, key)
+
+	_, err = ParsePublicKey("!")
+	require.ErrorContains(t, err, "invalid public key")
+	_, err = ParsePublicKey(base64.StdEncoding.EncodeToString(pub[:16]))
+	require.ErrorContains(t, err, "16 bytes, want 32")
+}
+
+func TestNewer(t *testing.T) {
+	for _, tt := range []struct {
+		v, current string
+		newer      bool
+	}{
+		{"v1.2.0", "v1.1.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v2.0.0", "v1.99.99", true},
+		{"v1.2.0", "v1.2.0", false},
+		{"v1.1.0", "v1.2.0", false},
+		{"v1.2.0", "v1.2.0-rc.1", true},
+		{"v1.2.0-rc.2", "v1.2.0-rc.1", true},
+		{"v1.2.0-rc.1", "v1.2.0", false},
+		{"v1.2.0+build.5", "v1.2.0", false},
+		{"v1.2.0", "dev", true},
+		{"v1.2.0", "", true},
+		{"1.2.0", "v1.0.0", false},
+		{"latest", "v1.0.0", false},
+	} {
+		require.Equal(t, tt.newer, Newer(tt.v, tt.current), "%s newer than %s", tt.v, tt.current)
+	}
+}
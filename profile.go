@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/config"
+)
+
+func loadConfig() (*config.File, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	return config.Load(path)
+}
+
+func configFilePath() (string, error) {
+	if *configPath != "" {
+		return *configPath, nil
+	}
+	if path := os.Getenv(config.EnvConfig); path != "" {
+		return path, nil
+	}
+
+	return config.DefaultPath()
+}
+
+func profileCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New("profile: command is required (add, list, rm, use)")
+	}
+
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) != 3 {
+			return usageError("usage: profile add <name> <config_url>")
+		}
+		link, err := readLink(args[2])
+		if err != nil {
+			return err
+		}
+		// Reject broken links early instead of failing on connect.
+		if _, err = client.ShareLink(link); err != nil {
+			return fmt.Errorf("profile %q: %w", args[1], err)
+		}
+		if err = cfg.Set(&config.Profile{Name: args[1], Link: link}); err != nil {
+			return err
+		}
+
+		return cfg.Save(path)
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, p := range cfg.Profiles {
+			kind := "outbound"
+			if p.Link != "" {
+				kind = "link"
+			}
+			active := ""
+			if p.Name == cfg.Active {
+				active = "active"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, kind, active)
+		}
+
+		return w.Flush()
+	case "rm":
+		if len(args) != 2 {
+			return usageError("usage: profile rm <name>")
+		}
+		if err = cfg.Remove(args[1]); err != nil {
+			return err
+		}
+
+		return cfg.Save(path)
+	case "use":
+		if len(args) != 2 {
+			return usageError("usage: profile use <name>")
+		}
+		if err = cfg.SetActive(args[1]); err != nil {
+			return err
+		}
+
+		return cfg.Save(path)
+	default:
+		return fmt.Errorf("profile: unknown command %q", args[0])
+	}
+}
+
+func linkCmd(args []string) error {
+	if len(args) != 1 {
+		return usageError("usage: link <config_url>")
+	}
+
+	link, err := shareLink(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println(link)
+
+	return nil
+}
+
+// shareLink returns standard share link for link, file or profile name.
+func shareLink(arg string) (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	if p, err := cfg.Get(arg); err == nil {
+		return p.ShareLink()
+	}
+
+	link, err := readLink(arg)
+	if err != nil {
+		return "", err
+	}
+
+	return client.ShareLink(link)
+}
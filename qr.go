@@ -0,0 +1,101 @@
+//go:build !goxray_noqr
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/config"
+	"github.com/goxray/tun/pkg/qr"
+)
+
+func qrCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New("qr: command is required (import, show)")
+	}
+
+	switch args[0] {
+	case "import":
+		if len(args) < 2 || len(args) > 3 {
+			return usageError("usage: qr import <image> [profile_name]")
+		}
+
+		return qrImport(args[1], args[2:]...)
+	case "show":
+		if len(args) < 2 || len(args) > 3 {
+			return usageError("usage: qr show <config_url> [out.png]")
+		}
+
+		return qrShow(args[1], args[2:]...)
+	default:
+		return fmt.Errorf("qr: unknown command %q", args[0])
+	}
+}
+
+// qrImport decodes share link from QR code image, prints it and saves it as profile if name is given.
+func qrImport(image string, name ...string) error {
+	f, err := os.Open(image)
+	if err != nil {
+		return fmt.Errorf("open image: %w", err)
+	}
+	defer f.Close()
+
+	link, err := qr.Decode(f)
+	if err != nil {
+		return err
+	}
+	if _, err = client.ShareLink(link); err != nil {
+		return fmt.Errorf("qr code does not contain valid link: %w", err)
+	}
+	fmt.Println(link)
+
+	if len(name) == 0 {
+		return nil
+	}
+
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	if err = cfg.Set(&config.Profile{Name: name[0], Link: link}); err != nil {
+		return err
+	}
+
+	return cfg.Save(path)
+}
+
+// qrShow renders QR code of the share link in terminal, or writes PNG image if output path is given.
+func qrShow(arg string, out ...string) error {
+	link, err := shareLink(arg)
+	if err != nil {
+		return err
+	}
+
+	if len(out) > 0 {
+		f, err := os.Create(out[0])
+		if err != nil {
+			return fmt.Errorf("create image: %w", err)
+		}
+		if err = qr.WritePNG(f, link, 8); err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		return f.Close()
+	}
+
+	code, err := qr.Terminal(link)
+	if err != nil {
+		return err
+	}
+	fmt.Print(code)
+
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build goxray_noqr
+
+package main
+
+import "errors"
+
+// qrCmd is left out of builds with goxray_noqr tag together with the QR code library, see "make minimal".
+func qrCmd([]string) error {
+	return errors.New("qr: not supported by this build, it is built with goxray_noqr tag")
+}
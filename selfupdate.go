@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/config"
+	"github.com/goxray/tun/pkg/update"
+)
+
+// Release build settings, set with -ldflags "-X main.version=v1.2.0 -X main.updateKey=<base64 ed25519 key>".
+var (
+	// version of the build, the module version of "go install" builds if not set.
+	version = ""
+	// updateManifestURL is the signed release manifest, see package update.
+	updateManifestURL = "https://github.com/goxray/tun/releases/latest/download/manifest.json"
+	// updateKey is the public key of the release manifest signature, self-update is not available without it.
+	updateKey = ""
+)
+
+const (
+	// selfUpdateTimeout limits the manifest and binary download of self-update.
+	selfUpdateTimeout = 10 * time.Minute
+	// updateCheckDelay is the delay of the first daemon update check, so it does not compete with connecting.
+	updateCheckDelay = time.Minute
+	// updateCheckTimeout limits daemon update checks.
+	updateCheckTimeout = time.Minute
+)
+
+// eventUpdateAvailable is printed by daemon update checks like client events.
+const eventUpdateAvailable client.EventType = "update_available"
+
+// currentVersion returns version of the build, "dev" if it is unknown.
+func currentVersion() string {
+	if version != "" {
+		return version
+	}
+	if v := client.Version().Version; v != "" {
+		return v
+	}
+
+	return "dev"
+}
+
+func selfUpdateCmd(args []string) error {
+	fs := flag.NewFlagSet("self-update", flag.ExitOnError)
+	check := fs.Bool("check", false, "only report whether a newer release is available")
+	force := fs.Bool("force", false, "install the latest release even if it is not newer")
+	manifestURL := fs.String("url", updateManifestURL, "release manifest URL, its signature is fetched from the URL with .sig suffix")
+	key := fs.String("key", updateKey, "base64 ed25519 public key verifying the manifest signature (default: release signing key of the build)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		return usageError("usage: self-update [-check] [-force] [-url <manifest_url>] [-key <public_key>]")
+	}
+
+	checker, err := updateChecker(*manifestURL, *key, nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), selfUpdateTimeout)
+	defer cancel()
+	m, err := checker.Latest(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := currentVersion()
+	if !update.Newer(m.Version, current) && !*force {
+		fmt.Printf("%s is up to date, latest release is %s\n", current, m.Version)
+		return nil
+	}
+	if *check {
+		fmt.Printf("update available: %s (current: %s)\n", m.Version, current)
+		return nil
+	}
+
+	b, err := m.Binary(runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate binary: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("locate binary: %w", err)
+	}
+	if err = checker.Install(ctx, b, exe); err != nil {
+		return err
+	}
+	fmt.Printf("updated %s from %s to %s\n", exe, current, m.Version)
+	upgradeDaemon()
+
+	return nil
+}
+
+// upgradeDaemon asks the running daemon to execute the updated binary keeping its connection, see daemon.upgrade.
+func upgradeDaemon() {
+	var st *liveStatus
+	if path, err := configFilePath(); err == nil {
+		st = readLiveStatus(config.SocketPath(path))
+	}
+	if st == nil || st.PID == 0 {
+		fmt.Println("restart running instances to use it")
+		return
+	}
+	if err := signalUpgrade(st.PID); err != nil {
+		fmt.Printf("restart running instances to use it, the daemon (pid %d) can not be upgraded in place: %v\n", st.PID, err)
+		return
+	}
+	fmt.Printf("daemon (pid %d) is upgrading, its connection is kept\n", st.PID)
+}
+
+// updateChecker returns checker of the release manifest at manifestURL signed with base64 key, cl makes
+// the requests (nil: http.DefaultClient).
+func updateChecker(manifestURL, key string, cl *http.Client) (*update.Checker, error) {
+	if key == "" {
+		return nil, errors.New("self-update is not available: the build has no release signing key, set it with -key")
+	}
+	pub, err := update.ParsePublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &update.Checker{ManifestURL: manifestURL, PublicKey: pub, Client: cl}, nil
+}
+
+// runUpdateCheck checks for a newer release every interval until ctx is done. A newer release is reported
+// once as eventUpdateAvailable, it is installed by the user with self-update.
+func (d *daemon) runUpdateCheck(ctx context.Context, interval time.Duration) {
+	checker, err := updateChecker(updateManifestURL, updateKey, &http.Client{
+		Transport: &http.Transport{DialContext: d.controlDial, ForceAttemptHTTP2: true},
+	})
+	if err != nil {
+		d.logger.Warn("update checks disabled", "err", err)
+		return
+	}
+
+	timer := time.NewTimer(updateCheckDelay)
+	defer timer.Stop()
+	var reported string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		timer.Reset(interval)
+
+		checkCtx, cancel := context.WithTimeout(ctx, updateCheckTimeout)
+		m, err := checker.Latest(checkCtx)
+		cancel()
+		if err != nil {
+			d.logger.Warn("update check failed", "err", err)
+			continue
+		}
+		current := currentVersion()
+		if !update.Newer(m.Version, current) || m.Version == reported {
+			d.logger.Debug("no update available", "latest", m.Version, "current", current)
+			continue
+		}
+		reported = m.Version
+		logEvent(client.Event{
+			Type: eventUpdateAvailable, Time: time.Now(), Message: "update available, install it with self-update",
+			Attrs: map[string]string{"version": m.Version, "current": current},
+		})
+	}
+}
+
+// controlDial dials control traffic of the daemon routed by Config.ControlRoute while connected,
+// direct otherwise.
+func (d *daemon) controlDial(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	vpn := d.vpn // Replaced on switches to profiles with other settings.
+	d.mu.Unlock()
+	conn, err := vpn.ControlDialer("")(ctx, network, address)
+	if errors.Is(err, client.ErrNotConnected) {
+		return vpn.ControlDialer(client.ControlRouteDirect)(ctx, network, address)
+	}
+
+	return conn, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goxray/tun/pkg/config"
+	"github.com/goxray/tun/pkg/stats"
+)
+
+// statsCmd prints usage statistics counted by the daemon.
+func statsCmd(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	since := fs.String("since", "", "start of the period: date (2025-03-01), days (7d) or duration (12h) (default: all)")
+	by := fs.String("by", "", "sum the period up by day or server (default: per day and server)")
+	asJSON := fs.Bool("json", false, "print statistics as JSON")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		return usageError("usage: stats [-since <period>] [-by day|server] [-json]")
+	}
+
+	from, err := stats.ParseSince(*since, time.Now())
+	if err != nil {
+		return err
+	}
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	store, err := stats.Open(config.StatsPath(path))
+	if err != nil {
+		return err
+	}
+
+	records, err := store.Query(from)
+	if err != nil {
+		return err
+	}
+	switch *by {
+	case "":
+	case "day":
+		records = stats.Sum(records, false)
+	case "server":
+		records = stats.Sum(records, true)
+	default:
+		return fmt.Errorf("invalid by %q: want day or server", *by)
+	}
+
+	if *asJSON {
+		b, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DAY\tSERVER\tREAD\tWRITTEN\tUPTIME\tCONNECTS")
+	obfuscated := false
+	for _, r := range records {
+		mark := ""
+		if r.Obfuscated {
+			mark, obfuscated = " *", true
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d%s\n", orDash(r.Day), orDash(r.Server),
+			formatBytes(r.BytesRead), formatBytes(r.BytesWritten), r.Uptime.Round(time.Second), r.Connects, mark)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if obfuscated {
+		fmt.Println("\n* traffic obfuscation was on: padding and fragment jitter cost throughput and latency,")
+		fmt.Println("  the traffic is not comparable with unobfuscated connections")
+	}
+
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// formatBytes formats n in binary units, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
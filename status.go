@@ -0,0 +1,165 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/config"
+)
+
+// statusCmd prints where the traffic of this machine exits to the internet,
+// it goes through the tunnel if the client is connected. How the last daemon connection ended
+// is printed as well, also if the exit is not reachable. With -watch it streams the daemon connection
+// state and throughput instead, read from the daemon status socket. With -history it prints the recent
+// connection attempts of the daemon instead, to investigate intermittent failures.
+func statusCmd(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print exit info as JSON")
+	watch := fs.Bool("watch", false, "print daemon connection state and throughput every -interval, for status bars")
+	format := fs.String("format", "", "status line format of -watch: json-stream, waybar or i3blocks (default: json-stream)")
+	interval := fs.Duration("interval", 2*time.Second, "update interval of -watch")
+	history := fs.Bool("history", false, "print recent connection attempts of the daemon")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 || *interval <= 0 {
+		return usageError("usage: status [-json] [-history] [-watch] [-format json-stream|waybar|i3blocks] [-interval <duration>]")
+	}
+	if *history {
+		return printHistory(*asJSON)
+	}
+	if *watch || *format != "" {
+		path, err := configFilePath()
+		if err != nil {
+			return err
+		}
+		if !*watch {
+			*interval = 0 // A single line for status bars polling on their own.
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		return statusStream(ctx, config.SocketPath(path), cmp.Or(*format, formatJSONStream), *interval)
+	}
+
+	cfg, err := clientConfig(slog.LevelError)
+	if err != nil {
+		return err
+	}
+	timeout := client.DefaultCheckTimeout
+	if cfg.Check != nil && cfg.Check.Timeout != 0 {
+		timeout = cfg.Check.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	info, lookupErr := client.LookupExitInfo(ctx, cfg.ExitInfoURL)
+	last := lastDisconnect()
+
+	if *asJSON {
+		if lookupErr != nil && last == nil {
+			return lookupErr
+		}
+		b, err := json.MarshalIndent(struct {
+			*client.ExitInfo
+			LastDisconnect *client.DisconnectInfo `json:"last_disconnect,omitempty"`
+		}{info, last}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+
+		return lookupErr
+	}
+
+	if lookupErr == nil {
+		fmt.Printf("exit ip:  %s\n", info.IP)
+		fmt.Printf("country:  %s\n", orUnknown(info.Country))
+		fmt.Printf("asn:      %s\n", orUnknown(strings.TrimSpace(info.ASN+" "+info.Org)))
+	}
+	if last != nil {
+		reason := string(last.Reason)
+		if last.Err != "" {
+			reason += ": " + last.Err
+		}
+		fmt.Printf("last disconnect: %s (%s)\n", reason, last.Time.Local().Format(time.DateTime))
+	}
+
+	return lookupErr
+}
+
+// lastDisconnect returns how the last daemon connection ended, nil if it is not known.
+func lastDisconnect() *client.DisconnectInfo {
+	st, err := daemonState()
+	if err != nil {
+		return nil
+	}
+
+	return st.LastDisconnect
+}
+
+// printHistory prints recent connection attempts of the daemon, the oldest first.
+func printHistory(asJSON bool) error {
+	st, err := daemonState()
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		history := st.History
+		if history == nil {
+			history = []client.Attempt{}
+		}
+		b, err := json.MarshalIndent(history, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	if len(st.History) == 0 {
+		fmt.Println("no connection attempts yet")
+		return nil
+	}
+	for _, a := range st.History {
+		result := "ok"
+		if a.Kind == client.AttemptDisconnect {
+			result = string(a.Reason)
+		}
+		if a.Err != "" {
+			result = "failed: " + a.Err
+			if a.Kind == client.AttemptDisconnect {
+				result = string(a.Reason) + ": " + a.Err
+			}
+		}
+		fmt.Printf("%s  %-10s  %8s  %-24s  %s\n", a.Time.Local().Format(time.DateTime), a.Kind,
+			a.Duration.Round(time.Millisecond), orUnknown(a.Server), result)
+	}
+
+	return nil
+}
+
+// daemonState returns session state saved by the daemon.
+func daemonState() (*config.State, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	return config.LoadState(config.StatePath(path))
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+
+	return s
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/goxray/tun/pkg/client"
+	"github.com/goxray/tun/pkg/config"
+	"github.com/goxray/tun/pkg/service"
+)
+
+// stopTimeout limits waiting for a running client to disconnect after it is asked to stop.
+const stopTimeout = 15 * time.Second
+
+// uninstallReport describes what uninstall-service removed.
+type uninstallReport struct {
+	Service    *service.Report       `json:"service,omitempty"`
+	StoppedPID int                   `json:"stopped_pid,omitempty"` // Client running outside the service.
+	Cleanup    *client.CleanupReport `json:"cleanup,omitempty"`
+	Removed    []string              `json:"removed,omitempty"` // Deleted state and config files.
+}
+
+// uninstallServiceCmd removes the daemon service and everything the client left on the machine: the daemon is
+// stopped, the service unit removed, leftover system changes undone and state files deleted.
+func uninstallServiceCmd(args []string) error {
+	fs := flag.NewFlagSet("uninstall-service", flag.ExitOnError)
+	name := fs.String("name", service.DefaultName, "service name of the daemon")
+	purge := fs.Bool("purge", false, "also delete the config file with stored profiles")
+	asJSON := fs.Bool("json", false, "print uninstall report as JSON")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		return usageError("usage: uninstall-service [-name <service>] [-purge] [-json]")
+	}
+
+	path, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	cfg, err := clientConfig(slog.LevelError)
+	if err != nil {
+		return err
+	}
+
+	report := &uninstallReport{}
+	defer func() { printUninstall(report, *asJSON) }()
+	if report.Service, err = service.Uninstall(*name); err != nil && !errors.Is(err, service.ErrUnsupported) {
+		return err
+	}
+	if report.StoppedPID, err = stopClient(cfg.Journal); err != nil {
+		return err
+	}
+
+	vpn, err := client.NewClientWithOpts(cfg)
+	if err != nil {
+		return err
+	}
+	// The journal is kept if the changes are not undone, so uninstall-service can be retried.
+	if report.Cleanup, err = vpn.Cleanup(); err != nil {
+		return err
+	}
+
+	files := []string{config.StatePath(path), config.StatsPath(path), cfg.Journal}
+	if *purge {
+		// The audit log is kept otherwise, it records the changes undone by the uninstall too.
+		files = append(files, path, cfg.AuditLog)
+	}
+	var errs []error
+	for _, file := range files {
+		err := os.Remove(file)
+		switch {
+		case err == nil:
+			report.Removed = append(report.Removed, file)
+		case !errors.Is(err, os.ErrNotExist):
+			errs = append(errs, err)
+		}
+	}
+	if *purge {
+		_ = os.Remove(filepath.Dir(path)) // Only if it is empty.
+	}
+
+	return errors.Join(errs...)
+}
+
+// stopClient stops the client connected with system changes in journal, it waits until the client disconnects.
+// It returns pid of the stopped client, zero if none was running.
+func stopClient(journal string) (int, error) {
+	pid, err := client.JournalOwner(journal)
+	if pid == 0 || err != nil {
+		return 0, err
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, err
+	}
+	if err = p.Signal(syscall.SIGTERM); err != nil {
+		// Not supported on Windows, the client can not disconnect on its own then.
+		if err = p.Kill(); err != nil {
+			return 0, fmt.Errorf("stop client pid %d: %w", pid, err)
+		}
+	}
+
+	for deadline := time.Now().Add(stopTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if owner, err := client.JournalOwner(journal); owner == 0 && err == nil {
+			return pid, nil
+		}
+	}
+
+	return 0, fmt.Errorf("client pid %d did not stop in %s", pid, stopTimeout)
+}
+
+func printUninstall(report *uninstallReport, asJSON bool) {
+	if asJSON {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			fmt.Println(string(b))
+		}
+
+		return
+	}
+
+	if (report.Service == nil || report.Service.Files == nil && !report.Service.Stopped) &&
+		report.StoppedPID == 0 && report.Cleanup == nil && report.Removed == nil {
+		fmt.Println("nothing to uninstall")
+		return
+	}
+	if report.Service != nil {
+		if report.Service.Stopped {
+			fmt.Println("stopped service")
+		}
+		for _, file := range report.Service.Files {
+			fmt.Printf("removed unit:    %s\n", file)
+		}
+	}
+	if report.StoppedPID != 0 {
+		fmt.Printf("stopped client:  pid %d\n", report.StoppedPID)
+	}
+	if c := report.Cleanup; c != nil {
+		if c.TUN != "" {
+			fmt.Printf("deleted device:  %s\n", c.TUN)
+		}
+		for _, r := range c.Routes {
+			fmt.Printf("deleted route:   %s\n", r)
+		}
+		if c.SystemProxy {
+			fmt.Println("system proxy:    restored")
+		}
+	}
+	for _, file := range report.Removed {
+		fmt.Printf("removed file:    %s\n", file)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+// envHandover passes the connection handed over by the daemon to its upgraded binary, see daemon.upgrade.
+const envHandover = "GOXRAY_HANDOVER"
+
+// upgradeHandover is the connection handed over to the upgraded binary of the daemon.
+type upgradeHandover struct {
+	client.Handover
+	Profile string    `json:"profile"` // Profile of the connection, empty for the link of the environment.
+	Since   time.Time `json:"since"`   // Time of the connection, kept if the same profile is connected.
+}
+
+// takeHandover returns the connection handed over by the previous binary of the daemon, nil if there is none.
+// The environment is cleared, so the handover is not inherited by other processes.
+func takeHandover() (*upgradeHandover, error) {
+	v, ok := os.LookupEnv(envHandover)
+	if !ok {
+		return nil, nil
+	}
+	_ = os.Unsetenv(envHandover)
+	h := &upgradeHandover{}
+	if err := json.Unmarshal([]byte(v), h); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envHandover, err)
+	}
+
+	return h, nil
+}
+
+// dropHandover closes TUN device handed over by the previous binary if it is not adopted by the first connection,
+// e.g. the daemon starts outside the schedule. Routes to the device are gone with it.
+func (d *daemon) dropHandover() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closeHandover()
+}
+
+// closeHandover closes TUN device of d.handover if it is not adopted. It must be called with d.mu held.
+func (d *daemon) closeHandover() {
+	if d.handover == nil {
+		return
+	}
+	if err := d.handover.Close(); err != nil {
+		d.logger.Warn("closing handed over TUN device failed", "err", err)
+	}
+	d.handover = nil
+}
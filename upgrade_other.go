@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// runUpgrades does nothing, binaries of running processes can not be replaced on this platform.
+func (d *daemon) runUpgrades(context.Context) {}
+
+// signalUpgrade is not supported, see runUpgrades.
+func signalUpgrade(int) error {
+	return errors.ErrUnsupported
+}
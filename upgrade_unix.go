@@ -0,0 +1,104 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// handoverTimeout limits the disconnect of the connection handed over to the upgraded binary.
+const handoverTimeout = 10 * time.Second
+
+// runUpgrades upgrades the daemon on SIGUSR2 until ctx is done, see upgrade.
+func (d *daemon) runUpgrades(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+		}
+		d.upgrade()
+	}
+}
+
+// upgrade executes the binary in place of the daemon, e.g. after self-update or a package upgrade replaced it.
+// The process id is kept for service managers. TUN device of the connection is handed over to the upgraded
+// binary (see client.Handover), so routes to the tunnel stay up while it connects again and the session goes on.
+// Only the device is handed over: the inbound proxy listener and XRay core are closed, tunneled connections
+// are reset. Without handover support the connection is established again by the upgraded binary.
+// The connection is restored if the binary can not be executed, d.mu is not held while it is executed.
+func (d *daemon) upgrade() {
+	exe, err := os.Executable() // The path of the replaced binary on Linux.
+	if err != nil {
+		d.logger.Error("upgrade failed", "err", fmt.Errorf("locate binary: %w", err))
+		return
+	}
+	// Checked before the connection is handed over, so it is not dropped for a missing binary.
+	if err = unix.Access(exe, unix.X_OK); err != nil {
+		d.logger.Error("upgrade failed", "err", fmt.Errorf("binary %s: %w", exe, err))
+		return
+	}
+
+	d.mu.Lock()
+	d.logger.Info("upgrading daemon", "binary", exe)
+	link, profile := d.link, d.profile
+	var handover *upgradeHandover
+	env := os.Environ()
+	if link != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), handoverTimeout)
+		h, err := d.vpn.Handover(ctx)
+		cancel()
+		if h == nil {
+			d.logger.Warn("TUN device handover failed, connecting again after the upgrade", "err", err)
+			d.switchTo("", "")
+		} else {
+			if err != nil {
+				d.logger.Warn("disconnect failed", "err", err)
+			}
+			d.disconnected()
+			handover = &upgradeHandover{Handover: *h, Profile: profile, Since: d.connectedAt}
+			b, _ := json.Marshal(handover)
+			env = append(env, envHandover+"="+string(b))
+		}
+	}
+	d.saveState(profile) // Restored by the upgraded binary.
+	d.upgrading = true
+	d.mu.Unlock()
+
+	if handover != nil {
+		err = handover.Inherit()
+	}
+	if err == nil {
+		err = syscall.Exec(exe, os.Args, env)
+	}
+	d.logger.Error("upgrade failed, restoring the connection", "err", fmt.Errorf("execute %s: %w", exe, err))
+	if handover != nil {
+		unix.CloseOnExec(handover.TUNFd)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.upgrading = false
+	if link != "" {
+		d.handover = handover
+		d.switchTo(link, profile)
+		d.closeHandover()
+	}
+	d.sync() // The config may have changed meanwhile.
+}
+
+// signalUpgrade asks the daemon process pid to upgrade, see daemon.upgrade.
+func signalUpgrade(pid int) error {
+	return syscall.Kill(pid, syscall.SIGUSR2)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+// verifyCmd checks the REALITY server of the link without connecting, the error names the parameter to fix.
+func verifyCmd(args []string) error {
+	if len(args) != 1 {
+		return usageError("usage: verify <config_url>")
+	}
+	link, profile, err := resolveLink(args[0])
+	if err != nil {
+		return err
+	}
+
+	cfg, err := profileClientConfig(profile, slog.LevelError)
+	if err != nil {
+		return err
+	}
+	vpn, err := client.NewClientWithOpts(cfg)
+	if err != nil {
+		return err
+	}
+	if err = vpn.VerifyReality(context.Background(), link); err != nil {
+		return err
+	}
+	fmt.Println("REALITY handshake succeeded")
+
+	return nil
+}
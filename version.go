@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+// versionCmd prints version of the build, embedded XRay core, the supported protocols and features of the platform,
+// for bug reports.
+func versionCmd(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print version info as JSON")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		return usageError("usage: version [-json]")
+	}
+
+	v := client.Version()
+	v.Version = currentVersion()
+	caps := client.SupportedCapabilities()
+	if *asJSON {
+		b, err := json.MarshalIndent(struct {
+			client.VersionInfo
+			Capabilities client.Capabilities `json:"capabilities"`
+		}{v, caps}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+
+		return nil
+	}
+
+	fmt.Printf("version:    %s\n", v.Version)
+	fmt.Printf("xray-core:  %s\n", v.XrayCore)
+	fmt.Printf("protocols:  %s\n", strings.Join(v.Protocols, ", "))
+	fmt.Printf("features:   %s\n", strings.Join(capabilityNames(caps), ", "))
+	fmt.Printf("go:         %s %s\n", v.GoVersion, v.Platform)
+	if v.Revision != "" {
+		revision := v.Revision
+		if v.Modified {
+			revision += " (modified)"
+		}
+		fmt.Printf("revision:   %s %s\n", revision, v.RevisionTime)
+	}
+
+	return nil
+}
+
+// capabilityNames returns names of the available features of caps.
+func capabilityNames(caps client.Capabilities) []string {
+	var names []string
+	for _, c := range []struct {
+		name string
+		ok   bool
+	}{
+		{"ipv6", caps.IPv6}, {"udp-relay", caps.UDPRelay}, {"kill-switch", caps.KillSwitch},
+		{"system-proxy", caps.SystemProxy}, {"route-isolation", caps.RouteIsolation}, {"netns", caps.Netns},
+		{"gateway-mode", caps.GatewayMode}, {"qos", caps.QoS}, {"keystore", caps.Keystore}, {"handover", caps.Handover},
+	} {
+		if c.ok {
+			names = append(names, c.name)
+		}
+	}
+
+	return names
+}
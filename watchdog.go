@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/goxray/tun/pkg/client"
+)
+
+// watchdogTick is the interval of watchdog checks.
+const watchdogTick = 10 * time.Second
+
+// stall tracks since when the connection passes no traffic while its health checks fail.
+type stall struct {
+	since   time.Time // Zero while the connection works.
+	written int       // Traffic arrived through the tunnel when the stall started.
+	err     error     // The last failed health check.
+}
+
+// runWatchdog rebuilds the connection from scratch, with a new client (new XRay core instance and TUN device),
+// if no traffic arrives through the tunnel and health checks fail for period. It covers XRay core wedge states
+// which reconnecting does not fix, the restart is reported as eventWatchdogRestart.
+func (d *daemon) runWatchdog(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(min(watchdogTick, period))
+	defer ticker.Stop()
+	var s stall
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		if d.watch(&s, time.Now()) >= period {
+			d.restart(s.err, period)
+			s = stall{}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// watch updates s with the connection state at now and returns how long the connection stalls.
+// It must be called with d.mu held.
+func (d *daemon) watch(s *stall, now time.Time) time.Duration {
+	check := d.vpn.LastCheck()
+	written := d.vpn.BytesWritten()
+	switch {
+	case d.link == "" || check.Err == nil || check.Time.Before(d.connectedAt):
+		*s = stall{}
+	case s.since.IsZero():
+		*s = stall{since: now, written: written, err: check.Err}
+	case written != s.written:
+		*s = stall{} // Traffic arrives, checks fail for another reason, e.g. the check URL is blocked.
+	default:
+		s.err = check.Err
+		return now.Sub(s.since)
+	}
+
+	return 0
+}
+
+// restart tears down the stalled connection and connects again with a new client, a failed connect is retried
+// by runRetries. It must be called with d.mu held.
+func (d *daemon) restart(cause error, period time.Duration) {
+	link, profile := d.link, d.profile
+	d.logger.Warn("connection stalled, restarting it", "profile", serverName(link, profile), "for", period, "err", cause)
+	logEvent(client.Event{
+		Type: eventWatchdogRestart, Time: time.Now(), Message: "connection stalled, restarting it",
+		Attrs: map[string]string{"profile": serverName(link, profile), "err": cause.Error(), "period": period.String()},
+	})
+
+	if err := d.vpn.DisconnectWithReason(context.Background(), client.DisconnectWatchdog, cause); err != nil {
+		d.logger.Warn("disconnect failed", "err", err)
+	}
+	d.switchTo("", "") // Counts the traffic of the connection.
+	if err := d.newClient(profile); err != nil {
+		d.logger.Error("new client creation failed, reusing the current one", "err", err)
+	}
+	d.switchTo(link, profile)
+	if d.link == "" {
+		d.logger.Error("watchdog restart failed, retrying", "at", d.retryAt)
+	}
+}